@@ -0,0 +1,116 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/xthexder/go-jack"
+)
+
+const (
+	// AdminMuteOSCAddress is used to zero a client's fader in the studio's SuperCollider mixer
+	AdminMuteOSCAddress = "/admin/mute"
+)
+
+// sendMuteMessage asks the local SuperCollider mixer to mute or unmute a client
+func sendMuteMessage(name string, mute bool) error {
+	value := 0
+	if mute {
+		value = 1
+	}
+	osc := common.NewOSCClient("127.0.0.1", common.SuperColliderOSCPort)
+	return osc.Send(AdminMuteOSCAddress, name, value)
+}
+
+// disconnectClientPorts removes all JACK connections to and from a client's ports
+func (ac *AutoConnector) disconnectClientPorts(name string) (int, error) {
+	ac.ClientLock.Lock()
+	defer ac.ClientLock.Unlock()
+	if ac.JackClient == nil {
+		return 0, errors.New("JACK client is not available")
+	}
+
+	disconnected := 0
+	flags := []uint64{jack.PortIsOutput, jack.PortIsInput}
+	for _, flag := range flags {
+		for _, portName := range ac.JackClient.GetPorts(fmt.Sprintf("^%s:", regexp.QuoteMeta(name)), "", flag) {
+			for _, conn := range ac.JackClient.GetConnections(portName) {
+				src, dest := portName, conn
+				if flag == jack.PortIsInput {
+					src, dest = conn, portName
+				}
+				if code := ac.JackClient.Disconnect(src, dest); code != 0 {
+					log.Error(jack.StrError(code), "Unexpected error disconnecting JACK ports", "src", src, "dest", dest)
+					continue
+				}
+				log.Info("Disconnected JACK ports", "src", src, "dest", dest)
+				disconnected++
+			}
+		}
+	}
+	return disconnected, nil
+}
+
+// handleAdminRequest performs an admin action on a connected client
+func handleAdminRequest(roster *common.ClientRoster, ac *AutoConnector, credentials client.AgentCredentials, w http.ResponseWriter, r *http.Request) {
+	if !isAuthorizedAdminRequest(credentials, r) {
+		RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	var action client.AdminAction
+	if err := json.NewDecoder(r.Body).Decode(&action); err != nil || !action.IsValid() {
+		RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid admin action"})
+		return
+	}
+
+	found := false
+	for _, c := range roster.Clients() {
+		if c.Name == action.Client {
+			found = true
+			break
+		}
+	}
+	if !found {
+		RespondJSON(w, http.StatusNotFound, map[string]string{"error": "client not found"})
+		return
+	}
+
+	var err error
+	switch action.Action {
+	case client.AdminMute, client.AdminUnmute:
+		err = sendMuteMessage(action.Client, action.Action == client.AdminMute)
+	case client.AdminDisconnect:
+		_, err = ac.disconnectClientPorts(action.Client)
+	}
+	if err != nil {
+		log.Error(err, "Failed to perform admin action", "action", action.Action, "client", action.Client)
+		RespondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Info("Performed admin action", "action", action.Action, "client", action.Client)
+	RespondJSON(w, http.StatusOK, action)
+}
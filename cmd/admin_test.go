@@ -0,0 +1,64 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleAdminRequest(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	roster := common.NewClientRoster()
+	roster.Update([]string{"alice:receive_1", "alice:send_1"}, time.Unix(100, 0))
+	ac := NewAutoConnector()
+
+	newRequest := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://example.com/admin", strings.NewReader(body))
+		req.Header.Set("APIPrefix", "prefix")
+		req.Header.Set("APISecret", "secret")
+		resp := httptest.NewRecorder()
+		handleAdminRequest(roster, ac, credentials, resp, req)
+		return resp
+	}
+
+	// Case for unauthorized request
+	resp := httptest.NewRecorder()
+	handleAdminRequest(roster, ac, credentials, resp, httptest.NewRequest("POST", "http://example.com/admin", nil))
+	assert.Equal(401, resp.Code)
+
+	// Case for invalid action
+	assert.Equal(400, newRequest(`{"action":"explode","client":"alice"}`).Code)
+	assert.Equal(400, newRequest(`not json`).Code)
+
+	// Case for unknown client
+	assert.Equal(404, newRequest(`{"action":"mute","client":"bob"}`).Code)
+
+	// Case for muting a connected client
+	assert.Equal(200, newRequest(`{"action":"mute","client":"alice"}`).Code)
+
+	// Case for disconnecting without a JACK client
+	assert.Equal(500, newRequest(`{"action":"disconnect","client":"alice"}`).Code)
+}
@@ -0,0 +1,126 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// AES67ServiceName is the name of the systemd service for the AES67 network bridge
+	AES67ServiceName = "aes67.service"
+
+	// AES67ConfigTemplate is the template used to generate /tmp/default/aes67 file on raspberry pi devices
+	AES67ConfigTemplate = "AES67_OPTS=--jack-client %s --interface %s --ptp-domain %d --rate %d --source %s:%d --source-channels %d --destination %s:%d --destination-channels %d\n"
+
+	// AES67ClientName is the JACK client name used by the AES67 bridge
+	AES67ClientName = "aes67"
+
+	// DefaultAES67Port is the RTP port used for AES67 streams when none is configured
+	DefaultAES67Port = 5004
+
+	// DefaultAES67Interface is the network interface used for AES67 streams when none is configured
+	DefaultAES67Interface = "eth0"
+
+	// AES67 bridge ports; audio received from the network is output on out_N,
+	// and audio sent to the network is input on in_N
+	aes67CapturePrefix  = AES67ClientName + ":out_"
+	aes67PlaybackPrefix = AES67ClientName + ":in_"
+)
+
+// isAES67Enabled returns true if a device config requires the AES67 bridge in place of the local sound card
+func isAES67Enabled(config client.DeviceAgentConfig) bool {
+	// the bridge is only supported when the device connects using JackTrip
+	return bool(config.AES67Enabled) && usesJackTrip(config)
+}
+
+// getAES67Port returns the configured AES67 RTP port, or the default port
+func getAES67Port(config client.DeviceAgentConfig) int {
+	if config.AES67Port < 1 {
+		return DefaultAES67Port
+	}
+	return config.AES67Port
+}
+
+// getAES67Interface returns the configured AES67 network interface, or the default interface
+func getAES67Interface(config client.DeviceAgentConfig) string {
+	if config.AES67Interface == "" {
+		return DefaultAES67Interface
+	}
+	return config.AES67Interface
+}
+
+// updateAES67Config writes the AES67 bridge service config file
+func updateAES67Config(config client.DeviceAgentConfig) {
+	port := getAES67Port(config)
+	aes67Config := fmt.Sprintf(AES67ConfigTemplate, AES67ClientName, getAES67Interface(config), config.AES67PTPDomain, config.SampleRate,
+		config.AES67SourceAddress, port, getSendChannels(config), config.AES67DestinationAddress, port, getReceiveChannels(config))
+	_, err := common.WriteFileIfChanged(PathToAES67Config, []byte(aes67Config), 0644)
+	if err != nil {
+		log.Error(err, "Failed to save AES67 config", "path", PathToAES67Config)
+	}
+}
+
+// isAES67Port returns true if a JACK port belongs to the AES67 bridge
+func isAES67Port(name string) bool {
+	return strings.HasPrefix(name, AES67ClientName+":")
+}
+
+// getCapturePrefix returns the prefix of the ports that provide local input for a device
+func getCapturePrefix(config client.DeviceAgentConfig) string {
+	if isAES67Enabled(config) {
+		return aes67CapturePrefix
+	}
+	return systemCapturePrefix
+}
+
+// getPlaybackPrefix returns the prefix of the ports that receive local output for a device
+func getPlaybackPrefix(config client.DeviceAgentConfig) string {
+	if isAES67Enabled(config) {
+		return aes67PlaybackPrefix
+	}
+	return systemPlaybackPrefix
+}
+
+// connectAES67Ports routes audio between the AES67 bridge and JackTrip
+// NOTE: JackTrip does not connect its own ports when the AES67 bridge is enabled
+func (ac *AutoConnector) connectAES67Ports(config client.DeviceAgentConfig) {
+	// the input chain takes care of routing when local input is processed
+	if isInputChainEnabled(config) {
+		ac.connectInputChain(config)
+		return
+	}
+	for i := 1; i <= getSendChannels(config); i++ {
+		capture := fmt.Sprintf("%s%d", aes67CapturePrefix, i)
+		send := fmt.Sprintf("%s%d", hubserverInput, i)
+		if ac.isValidPort(capture) && ac.isValidPort(send) {
+			ac.connectPorts(capture, send)
+		}
+	}
+	for i := 1; i <= getReceiveChannels(config); i++ {
+		receive := fmt.Sprintf("%s%d", hubserverOutput, i)
+		playback := fmt.Sprintf("%s%d", aes67PlaybackPrefix, i)
+		if ac.isValidPort(receive) && ac.isValidPort(playback) {
+			ac.connectPorts(receive, playback)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xthexder/go-jack"
+)
+
+func TestIsAES67Enabled(t *testing.T) {
+	assert := assert.New(t)
+	config := client.DeviceAgentConfig{}
+	config.Type = client.JackTrip
+	assert.False(isAES67Enabled(config))
+	config.AES67Enabled = true
+	assert.True(isAES67Enabled(config))
+	assert.Equal(aes67CapturePrefix, getCapturePrefix(config))
+	assert.Equal(aes67PlaybackPrefix, getPlaybackPrefix(config))
+	config.Type = client.Jamulus
+	assert.False(isAES67Enabled(config))
+	assert.Equal(systemCapturePrefix, getCapturePrefix(config))
+	assert.Equal(systemPlaybackPrefix, getPlaybackPrefix(config))
+}
+
+func TestUpdateAES67Config(t *testing.T) {
+	assert := assert.New(t)
+	defer func(path string) { PathToAES67Config = path }(PathToAES67Config)
+	PathToAES67Config = filepath.Join(t.TempDir(), "aes67")
+
+	config := client.DeviceAgentConfig{}
+	config.SampleRate = 48000
+	config.InputChannels = 2
+	config.AES67SourceAddress = "239.69.1.1"
+	config.AES67DestinationAddress = "239.69.1.2"
+	updateAES67Config(config)
+	raw, err := ioutil.ReadFile(PathToAES67Config)
+	assert.Nil(err)
+	assert.Equal("AES67_OPTS=--jack-client aes67 --interface eth0 --ptp-domain 0 --rate 48000 --source 239.69.1.1:5004 --source-channels 2 --destination 239.69.1.2:5004 --destination-channels 2\n", string(raw))
+
+	config.AES67Port = 5006
+	config.AES67Interface = "eth1"
+	config.AES67PTPDomain = 3
+	config.InputChannels = 1
+	updateAES67Config(config)
+	raw, err = ioutil.ReadFile(PathToAES67Config)
+	assert.Nil(err)
+	assert.Equal("AES67_OPTS=--jack-client aes67 --interface eth1 --ptp-domain 3 --rate 48000 --source 239.69.1.1:5006 --source-channels 1 --destination 239.69.1.2:5006 --destination-channels 2\n", string(raw))
+}
+
+func TestConnectAES67Ports(t *testing.T) {
+	assert := assert.New(t)
+	graph := NewFakeJackGraph("autoconnector")
+	for _, port := range []string{"system:capture_1", "aes67:out_1", "aes67:out_2", "hubserver:receive_1", "hubserver:receive_2"} {
+		graph.RegisterPort(port, jack.PortIsOutput)
+	}
+	for _, port := range []string{"system:playback_1", "aes67:in_1", "aes67:in_2", "hubserver:send_1"} {
+		graph.RegisterPort(port, jack.PortIsInput)
+	}
+	ac := NewAutoConnector()
+	ac.JackClient = graph
+
+	config := client.DeviceAgentConfig{}
+	config.Type = client.JackTrip
+	config.AES67Enabled = true
+	ac.connectAES67Ports(config)
+	assert.Equal([]string{"hubserver:send_1"}, graph.GetConnections("aes67:out_1"))
+	assert.Equal(0, len(graph.GetConnections("aes67:out_2")))
+	assert.Equal(0, len(graph.GetConnections("system:capture_1")))
+	assert.Equal([]string{"aes67:in_1"}, graph.GetConnections("hubserver:receive_1"))
+	assert.Equal([]string{"aes67:in_2"}, graph.GetConnections("hubserver:receive_2"))
+	assert.Equal(0, len(graph.GetConnections("system:playback_1")))
+}
@@ -0,0 +1,276 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The parsers below read the text output of ALSA tools and procfs. Each one documents the grammar it accepts,
+// and skips lines that do not match, since the exact formatting varies between kernel and alsa-utils versions.
+
+// excludedCardID is a sound card that is never bridged with zita
+const excludedCardID = "sndrpihifiberry"
+
+// standardSampleRates are used to expand sample rate ranges of cards with continuous rates
+var standardSampleRates = []int{8000, 11025, 16000, 22050, 32000, 44100, 48000, 88200, 96000, 176400, 192000}
+
+// alsaControlSuffixes are the types of mixer controls that are managed by the agent
+var alsaControlSuffixes = []string{"Playback Volume", "Playback Switch", "Capture Volume", "Capture Switch"}
+
+// isCardID returns true if an id is not empty, and only contains the characters that ALSA allows in card ids
+func isCardID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDeviceListLine parses the number and id of a card from a line of `aplay -l` or `arecord -l`:
+//
+//	card <number>: <id> [<name>], device <number>: <id> [<name>]
+func parseDeviceListLine(line string) (int, string, bool) {
+	rest := strings.TrimSpace(line)
+	if !strings.HasPrefix(rest, "card ") {
+		return 0, "", false
+	}
+	rest = rest[len("card "):]
+	colon := strings.Index(rest, ": ")
+	if colon < 0 {
+		return 0, "", false
+	}
+	num, err := strconv.Atoi(rest[:colon])
+	if err != nil || num < 0 {
+		return 0, "", false
+	}
+	rest = rest[colon+2:]
+	bracket := strings.Index(rest, " [")
+	if bracket <= 0 || !isCardID(rest[:bracket]) {
+		return 0, "", false
+	}
+	return num, rest[:bracket], true
+}
+
+// parseCardsLine parses the number and id of a card from a line of /proc/asound/cards:
+//
+//	<number> [<id>]: <driver> - <name>
+//
+// Numbers are right-aligned to two columns and ids are padded to 15 columns; the lines that follow
+// each card are indented descriptions, which are skipped.
+func parseCardsLine(line string) (int, string, bool) {
+	rest := strings.TrimSpace(line)
+	open := strings.Index(rest, " [")
+	if open <= 0 {
+		return 0, "", false
+	}
+	num, err := strconv.Atoi(rest[:open])
+	if err != nil || num < 0 {
+		return 0, "", false
+	}
+	closing := strings.Index(rest[open:], "]")
+	if closing < 0 {
+		return 0, "", false
+	}
+	id := strings.TrimSpace(rest[open+2 : open+closing])
+	if !isCardID(id) {
+		return 0, "", false
+	}
+	return num, id, true
+}
+
+// parseALSAControlLine parses the fields of a line of `amixer controls`:
+//
+//	numid=<number>,iface=<iface>,name='<name>'[,index=<number>][,device=<number>]
+//
+// Quoted values end at a quote that is followed by a comma or the end of the line.
+func parseALSAControlLine(line string) (map[string]string, bool) {
+	fields := map[string]string{}
+	rest := strings.TrimSpace(line)
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq <= 0 {
+			return nil, false
+		}
+		key := rest[:eq]
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, "'") {
+			end := strings.Index(rest[1:], "',")
+			switch {
+			case end >= 0:
+				value, rest = rest[1:end+1], rest[end+3:]
+			case len(rest) > 1 && strings.HasSuffix(rest, "'"):
+				value, rest = rest[1:len(rest)-1], ""
+			default:
+				return nil, false
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma+1:]
+		} else {
+			value, rest = rest, ""
+		}
+		fields[key] = value
+	}
+	return fields, true
+}
+
+// extractNames returns the ids of all cards listed by `aplay -l` or `arecord -l`
+func extractNames(target string) map[string]bool {
+	names := map[string]bool{}
+	for _, line := range strings.Split(target, "\n") {
+		if _, id, ok := parseDeviceListLine(line); ok && id != excludedCardID { // exclude hifiberry since we won't use it
+			names[id] = true
+		}
+	}
+	return names
+}
+
+// extractCardNum returns a mapping of card ids to card numbers from /proc/asound/cards
+func extractCardNum(target string) map[string]int {
+	nameToNum := map[string]int{}
+	for _, line := range strings.Split(target, "\n") {
+		if num, id, ok := parseCardsLine(line); ok {
+			nameToNum[id] = num
+		}
+	}
+	return nameToNum
+}
+
+// parseALSAControls parses all relevant volume controls of an ALSA card from `amixer controls`
+func parseALSAControls(output string) map[string]bool {
+	controls := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		fields, ok := parseALSAControlLine(line)
+		if !ok {
+			continue
+		}
+		if _, err := strconv.Atoi(fields["numid"]); err != nil {
+			continue
+		}
+		for _, suffix := range alsaControlSuffixes {
+			if name := fields["name"]; strings.HasSuffix(name, suffix) {
+				controls[name] = true
+				break
+			}
+		}
+	}
+	return controls
+}
+
+// parseSampleRates parses the sample rate(s) line of an ALSA card from `/proc/asound/card%d/stream0`:
+//
+//	Rates: <rate>[, <rate>...]
+//	Rates: <min> - <max> (continuous)
+//
+// Continuous ranges are expanded to the standard sample rates that they include.
+func parseSampleRates(line string) []int {
+	sampleRates := []int{}
+	i := strings.Index(line, "Rates:")
+	if i < 0 {
+		return sampleRates
+	}
+	value := strings.TrimSpace(line[i+len("Rates:"):])
+
+	if strings.HasSuffix(value, "(continuous)") {
+		bounds := strings.SplitN(strings.TrimSuffix(value, "(continuous)"), "-", 2)
+		if len(bounds) != 2 {
+			return sampleRates
+		}
+		min, minErr := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		max, maxErr := strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if minErr != nil || maxErr != nil {
+			return sampleRates
+		}
+		for _, rate := range standardSampleRates {
+			if rate >= min && rate <= max {
+				sampleRates = append(sampleRates, rate)
+			}
+		}
+		return sampleRates
+	}
+
+	for _, rate := range strings.Split(value, ",") {
+		currSampleRate, err := strconv.Atoi(strings.TrimSpace(rate))
+		if err != nil || currSampleRate <= 0 {
+			continue
+		}
+		sampleRates = append(sampleRates, currSampleRate)
+	}
+	return sampleRates
+}
+
+// getSampleRateToChannelMap returns a map of sample-rates-to-channel-counts for an ALSA card from `/proc/asound/card%d/stream0`:
+//
+//	<description>
+//
+//	Playback:
+//	  Status: <status>
+//	  Interface <number>
+//	    Altset <number>
+//	    Format: <format>
+//	    Channels: <number>
+//	    Endpoint: <endpoint>
+//	    Rates: <rates>
+//	  [Interface <number> ...]
+//
+//	Capture:
+//	  ...
+//
+// Indentation varies between kernel versions. Each altset may support a different number of channels,
+// so the highest channel count is used for each sample rate.
+func getSampleRateToChannelMap(sentences []string, mode ZitaMode) map[int]int {
+	output := map[int]int{}
+	section := "Playback:"
+	if mode == ZitaCapture {
+		section = "Capture:"
+	}
+
+	inSection := false
+	channels := 0
+	for _, sentence := range sentences {
+		line := strings.TrimSpace(sentence)
+		switch {
+		case line == "Playback:" || line == "Capture:":
+			inSection = line == section
+			channels = 0
+		case !inSection:
+			continue
+		case strings.HasPrefix(line, "Interface") || strings.HasPrefix(line, "Altset"):
+			channels = 0
+		case strings.HasPrefix(line, "Channels:"):
+			n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Channels:")))
+			if err == nil && n > 0 {
+				channels = n
+			}
+		case strings.HasPrefix(line, "Rates:") && channels > 0:
+			for _, rate := range parseSampleRates(line) {
+				if output[rate] < channels {
+					output[rate] = channels
+				}
+			}
+		}
+	}
+	return output
+}
@@ -0,0 +1,161 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// AlsaRestoreCommand is the websocket command used to restore ALSA state from a backup
+	AlsaRestoreCommand = "alsa-restore"
+
+	// AlsaBackupInterval is how often the ALSA state is checked for changes and backed up
+	AlsaBackupInterval = 10 * time.Minute
+
+	// AlsaBackupTimeout is the maximum time allowed to upload or download a backup
+	AlsaBackupTimeout = 30 * time.Second
+)
+
+// AlsaBackupManager keeps a copy of the device's ALSA state in the control plane, so that replacement hardware
+// can be restored with the same mixer settings
+type AlsaBackupManager struct {
+	// APIClient is used to upload and download backups
+	APIClient *api.Client
+
+	// ID of the device, used to store its backup
+	ID string
+
+	uploaded string
+	mutex    sync.Mutex
+}
+
+// deviceAlsaBackup backs up the ALSA state of the device
+var deviceAlsaBackup = &AlsaBackupManager{}
+
+// getAlsaStateFile returns the path of the ALSA state that is restored when the agent starts
+func getAlsaStateFile(deviceType string) string {
+	return fmt.Sprintf("%s/asound.%s.state", AgentLibDir, deviceType)
+}
+
+// readAlsaState returns the current state of all ALSA cards, as written by `alsactl store`
+func readAlsaState() (string, error) {
+	f, err := ioutil.TempFile("", "asound")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := alsaProvider.StoreState("", f.Name()); err != nil {
+		return "", err
+	}
+	rawBytes, err := ioutil.ReadFile(f.Name())
+	return string(rawBytes), err
+}
+
+// Backup uploads the current ALSA state, if it changed since the last backup
+func (m *AlsaBackupManager) Backup(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	state, err := readAlsaState()
+	if err != nil {
+		return err
+	}
+	if state == "" || state == m.uploaded {
+		return nil
+	}
+	soundDeviceType := deviceState.SoundDevice().Type
+	backup := client.AlsaStateBackup{Type: soundDeviceType, State: state, Timestamp: time.Now()}
+	if err := m.APIClient.UploadAlsaState(ctx, m.ID, backup); err != nil {
+		return err
+	}
+	m.uploaded = state
+	log.Info("Uploaded ALSA state backup", "type", soundDeviceType, "size", len(state))
+	return nil
+}
+
+// Restore applies the ALSA state backed up by a device (or this device, if empty), and saves it to be restored
+// whenever the agent starts
+func (m *AlsaBackupManager) Restore(ctx context.Context, source string) (report client.AlsaRestoreReport) {
+	defer func() { report.Timestamp = time.Now() }()
+	if source == "" {
+		source = m.ID
+	}
+	report.Source = source
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	soundDeviceType := deviceState.SoundDevice().Type
+	backup, err := m.APIClient.FetchAlsaState(ctx, source)
+	if err == nil && backup.Type != soundDeviceType {
+		err = fmt.Errorf("backup is for a %s sound device, not %s", backup.Type, soundDeviceType)
+	}
+	if err == nil && backup.State == "" {
+		err = errors.New("backup is empty")
+	}
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	stateFile := getAlsaStateFile(soundDeviceType)
+	if _, err := common.WriteFileIfChanged(stateFile, []byte(backup.State), 0644); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	if err := alsaProvider.RestoreState("", stateFile); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	m.uploaded = backup.State
+	report.Restored = true
+	log.Info("Restored ALSA state from backup", "source", source, "file", stateFile)
+	return report
+}
+
+// Run periodically backs up the ALSA state, until the context is cancelled
+func (m *AlsaBackupManager) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	log.Info("Starting ALSA state backups")
+	ticker := time.NewTicker(AlsaBackupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping ALSA state backups")
+			return
+		case <-ticker.C:
+			backupCtx, cancel := context.WithTimeout(ctx, AlsaBackupTimeout)
+			if err := m.Backup(backupCtx); err != nil {
+				log.V(1).Info("Failed to back up ALSA state", "error", err.Error())
+			}
+			cancel()
+		}
+	}
+}
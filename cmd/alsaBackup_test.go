@@ -0,0 +1,102 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/apitest"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// stateAlsa writes and reads the contents of ALSA state files
+type stateAlsa struct {
+	*FakeAlsa
+	state    string
+	restored string
+}
+
+func (a *stateAlsa) StoreState(device, file string) error {
+	return ioutil.WriteFile(file, []byte(a.state), 0644)
+}
+
+func (a *stateAlsa) RestoreState(device, file string) error {
+	rawBytes, err := ioutil.ReadFile(file)
+	a.restored = string(rawBytes)
+	return err
+}
+
+func TestAlsaBackupManager(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	server := apitest.NewServer(credentials)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "alsa")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	defer func(libDir string) { AgentLibDir = libDir }(AgentLibDir)
+	AgentLibDir = dir
+	defer func(provider AlsaProvider) { alsaProvider = provider }(alsaProvider)
+	alsa := &stateAlsa{FakeAlsa: NewFakeAlsa(), state: "state.Device {}\n"}
+	alsaProvider = alsa
+	defer func(state *StateStore) { deviceState = state }(deviceState)
+	deviceState = NewStateStore()
+	soundDeviceType := "snd_rpi_hifiberry_dacplusadcpro"
+	deviceState.SetSoundDevice(SoundDevice{Name: "sndrpihifiberry", Type: soundDeviceType})
+
+	m := &AlsaBackupManager{APIClient: api.NewClient(server.URL, credentials, nil), ID: "abc"}
+	ctx := context.Background()
+
+	// Case for no backup
+	report := m.Restore(ctx, "")
+	assert.False(report.Restored)
+	assert.Equal("abc", report.Source)
+	assert.NotEmpty(report.Error)
+
+	// Case for uploading a backup, which is skipped until the state changes
+	assert.NoError(m.Backup(ctx))
+	backup, ok := server.AlsaState("abc")
+	assert.True(ok)
+	assert.Equal(soundDeviceType, backup.Type)
+	assert.Equal("state.Device {}\n", backup.State)
+	server.SetAlsaState("abc", client.AlsaStateBackup{})
+	assert.NoError(m.Backup(ctx))
+	backup, _ = server.AlsaState("abc")
+	assert.Equal("", backup.State)
+
+	// Case for restoring the backup of replaced hardware
+	server.SetAlsaState("old", client.AlsaStateBackup{Type: soundDeviceType, State: "state.Device { old }\n"})
+	report = m.Restore(ctx, "old")
+	assert.True(report.Restored, report.Error)
+	assert.Equal("state.Device { old }\n", alsa.restored)
+	rawBytes, err := ioutil.ReadFile(getAlsaStateFile(soundDeviceType))
+	assert.NoError(err)
+	assert.Equal("state.Device { old }\n", string(rawBytes))
+
+	// Case for a backup of another type of sound device
+	server.SetAlsaState("other", client.AlsaStateBackup{Type: "Device", State: "state.Device {}\n"})
+	report = m.Restore(ctx, "other")
+	assert.False(report.Restored)
+	assert.Contains(report.Error, "Device")
+}
@@ -0,0 +1,126 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.18 && !server
+// +build go1.18,!server
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// addALSACorpus seeds a fuzz target with the captured ALSA outputs matching a pattern
+func addALSACorpus(f *testing.F, pattern string) {
+	files, err := filepath.Glob(filepath.Join("testdata", "alsa", pattern))
+	if err != nil || len(files) == 0 {
+		f.Fatalf("no corpus files match %s", pattern)
+	}
+	for _, file := range files {
+		f.Add(readALSATestData(f, filepath.Base(file)))
+	}
+}
+
+// isValidCardID returns true if an id could be reported by ALSA
+func isValidCardID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "[]:") && strings.IndexFunc(id, unicode.IsSpace) < 0
+}
+
+func FuzzExtractNames(f *testing.F) {
+	addALSACorpus(f, "aplay-*.txt")
+	f.Fuzz(func(t *testing.T, data string) {
+		for name := range extractNames(data) {
+			if !isValidCardID(name) || name == excludedCardID {
+				t.Errorf("invalid card id %q", name)
+			}
+		}
+	})
+}
+
+func FuzzExtractCardNum(f *testing.F) {
+	addALSACorpus(f, "cards-*.txt")
+	f.Fuzz(func(t *testing.T, data string) {
+		for id, num := range extractCardNum(data) {
+			if !isValidCardID(id) || num < 0 {
+				t.Errorf("invalid card %d %q", num, id)
+			}
+		}
+	})
+}
+
+func FuzzCardsRoundTrip(f *testing.F) {
+	f.Add(uint8(0), "sndrpihifiberry")
+	f.Add(uint8(10), "studio-mic")
+	f.Fuzz(func(t *testing.T, num uint8, id string) {
+		if !isCardID(id) {
+			t.Skip()
+		}
+		content := fmt.Sprintf("%2d [%-15s]: USB-Audio - Device\n                      Device at usb-0000:01:00.0-1.3\n", num, id)
+		if cards := extractCardNum(content); len(cards) != 1 || cards[id] != int(num) {
+			t.Errorf("unexpected cards %v from %q", cards, content)
+		}
+		content = fmt.Sprintf("card %d: %s [Device], device 0: USB Audio [USB Audio]\n", num, id)
+		if names := extractNames(content); id != excludedCardID && !names[id] {
+			t.Errorf("unexpected names %v from %q", names, content)
+		}
+	})
+}
+
+func FuzzParseALSAControls(f *testing.F) {
+	addALSACorpus(f, "amixer-*.txt")
+	f.Fuzz(func(t *testing.T, data string) {
+		for name := range parseALSAControls(data) {
+			matched := false
+			for _, suffix := range alsaControlSuffixes {
+				matched = matched || strings.HasSuffix(name, suffix)
+			}
+			if !matched {
+				t.Errorf("unexpected control %q", name)
+			}
+		}
+	})
+}
+
+func FuzzParseALSAControlsRoundTrip(f *testing.F) {
+	f.Add(3, "Mic")
+	f.Add(7, "Line In 1-2")
+	f.Fuzz(func(t *testing.T, numid int, prefix string) {
+		if strings.Contains(prefix, "',") || strings.ContainsAny(prefix, "\r\n") {
+			t.Skip()
+		}
+		name := prefix + " Capture Volume"
+		line := fmt.Sprintf("numid=%d,iface=MIXER,name='%s',index=0", numid, name)
+		if controls := parseALSAControls(line); !controls[name] {
+			t.Errorf("unexpected controls %v from %q", controls, line)
+		}
+	})
+}
+
+func FuzzGetSampleRateToChannelMap(f *testing.F) {
+	addALSACorpus(f, "stream0-*.txt")
+	f.Fuzz(func(t *testing.T, data string) {
+		lines := strings.Split(data, "\n")
+		for _, mode := range []ZitaMode{ZitaCapture, ZitaPlayback} {
+			for rate, channels := range getSampleRateToChannelMap(lines, mode) {
+				if rate <= 0 || channels <= 0 {
+					t.Errorf("invalid %s channels %d at %d", mode, channels, rate)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readALSATestData reads a captured ALSA output from testdata/alsa
+func readALSATestData(t testing.TB, name string) string {
+	rawBytes, err := ioutil.ReadFile(filepath.Join("testdata", "alsa", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(rawBytes)
+}
+
+func TestParseDeviceListLine(t *testing.T) {
+	assert := assert.New(t)
+
+	num, id, ok := parseDeviceListLine("card 11: studio-mic [USB2.0 Microphone], device 0: USB Audio [USB Audio]")
+	assert.True(ok)
+	assert.Equal(11, num)
+	assert.Equal("studio-mic", id)
+
+	for _, line := range []string{"", "  Subdevices: 1/1", "card x: USB [USB]", "card 1: [USB]", "card 1: two words [USB]", "card -1: USB [USB]"} {
+		_, _, ok = parseDeviceListLine(line)
+		assert.False(ok, line)
+	}
+}
+
+func TestParseCardsLine(t *testing.T) {
+	assert := assert.New(t)
+
+	num, id, ok := parseCardsLine("10 [Device         ]: USB-Audio - USB Audio Device")
+	assert.True(ok)
+	assert.Equal(10, num)
+	assert.Equal("Device", id)
+
+	for _, line := range []string{"", "                      bcm2835 Headphones", " 1 [               ]: USB-Audio", " 1 [USB"} {
+		_, _, ok = parseCardsLine(line)
+		assert.False(ok, line)
+	}
+}
+
+func TestParseALSAControlLine(t *testing.T) {
+	assert := assert.New(t)
+
+	fields, ok := parseALSAControlLine("numid=9,iface=MIXER,name='Master, Main Playback Volume',index=0,device=0")
+	assert.True(ok)
+	assert.Equal(map[string]string{"numid": "9", "iface": "MIXER", "name": "Master, Main Playback Volume", "index": "0", "device": "0"}, fields)
+
+	fields, ok = parseALSAControlLine("numid=1,iface=MIXER,name='Mic's Capture Volume'")
+	assert.True(ok)
+	assert.Equal("Mic's Capture Volume", fields["name"])
+
+	for _, line := range []string{"numid", "numid=1,iface=MIXER,name='unterminated", "numid=1,=MIXER"} {
+		_, ok = parseALSAControlLine(line)
+		assert.False(ok, line)
+	}
+}
+
+func TestALSAParsersWithTestData(t *testing.T) {
+	assert := assert.New(t)
+
+	cards := extractCardNum(readALSATestData(t, "cards-many.txt"))
+	assert.Equal(map[string]int{"Headphones": 0, "USB": 9, "Device": 10, "studio-mic": 11}, cards)
+
+	names := extractNames(readALSATestData(t, "aplay-many.txt"))
+	assert.Equal(map[string]bool{"Headphones": true, "USB": true, "Device": true, "studio-mic": true}, names)
+
+	controls := parseALSAControls(readALSATestData(t, "amixer-usb.txt"))
+	assert.Equal(map[string]bool{
+		"Mic Capture Switch":         true,
+		"Mic Capture Volume":         true,
+		"Line In 1-2 Capture Volume": true,
+		"Master Playback Volume":     true,
+	}, controls)
+
+	// interfaces with more than 9 channels
+	stream0 := strings.Split(readALSATestData(t, "stream0-scarlett-18i20.txt"), "\n")
+	assert.Equal(map[int]int{44100: 20, 48000: 20, 88200: 16, 96000: 16}, getSampleRateToChannelMap(stream0, ZitaPlayback))
+	assert.Equal(map[int]int{44100: 18, 48000: 18, 88200: 14, 96000: 14}, getSampleRateToChannelMap(stream0, ZitaCapture))
+
+	// interfaces with continuous sample rates
+	stream0 = strings.Split(readALSATestData(t, "stream0-continuous.txt"), "\n")
+	assert.Equal(map[int]int{8000: 2, 11025: 2, 16000: 2, 22050: 2, 32000: 2, 44100: 2, 48000: 2}, getSampleRateToChannelMap(stream0, ZitaPlayback))
+	assert.Equal(1, getSampleRateToChannelMap(stream0, ZitaCapture)[48000])
+}
@@ -47,28 +47,36 @@ type AutoConnector struct {
 	Name                string
 	Channels            int
 	JTRegexp            *regexp.Regexp
-	JackClient          *jack.Client
+	JackClient          JackGraph
 	ClientLock          sync.Mutex
 	KnownClients        map[string]int
 	RegistrationChannel chan jack.PortId
+	// UnregistrationChannel coalesces port unregistrations, since the names of removed ports are no longer available
+	UnregistrationChannel chan struct{}
 }
 
 // NewAutoConnector constructs a new instance of AutoConnector
 func NewAutoConnector() *AutoConnector {
 	return &AutoConnector{
-		Name:                "autoconnector",
-		Channels:            defaultChannels,
-		JTRegexp:            regexp.MustCompile(zitaPortToken),
-		KnownClients:        map[string]int{"Jamulus": 0},
-		RegistrationChannel: make(chan jack.PortId, 200),
+		Name:                  "autoconnector",
+		Channels:              defaultChannels,
+		JTRegexp:              regexp.MustCompile(zitaPortToken),
+		KnownClients:          map[string]int{"Jamulus": 0},
+		RegistrationChannel:   make(chan jack.PortId, 200),
+		UnregistrationChannel: make(chan struct{}, 1),
 	}
 }
 
-// handlePortRegistration signals the notification channel when a new port is registered
+// handlePortRegistration signals the notification channels when a port is registered or unregistered
 // NOTE: We cannot modify ports in the callback thread so use a channel
 func (ac *AutoConnector) handlePortRegistration(port jack.PortId, register bool) {
 	if register {
 		ac.RegistrationChannel <- port
+		return
+	}
+	select {
+	case ac.UnregistrationChannel <- struct{}{}:
+	default:
 	}
 }
 
@@ -89,16 +97,26 @@ func (ac *AutoConnector) getServerChannel(clientName string, clientChannel int)
 	return serverChannel
 }
 
+// lookupServerChannel determines the server channel number of a client that was already connected,
+// without assigning a number to new clients
+func (ac *AutoConnector) lookupServerChannel(clientName string, clientChannel int) (int, bool) {
+	clientNum, ok := ac.KnownClients[clientName]
+	if !ok {
+		return 0, false
+	}
+	return (clientNum * ac.Channels) + clientChannel, true
+}
+
 // getServerPortName finds the desired hubserver port name
 func (ac *AutoConnector) getServerPortName(serverChannel int, isInput bool) string {
 	var opt string
 
 	// Use jamulus ports if available
-	jil := ac.JackClient.GetPortByName(jamulusInputLeft)
-	jir := ac.JackClient.GetPortByName(jamulusInputRight)
-	jol := ac.JackClient.GetPortByName(jamulusOutputLeft)
-	jor := ac.JackClient.GetPortByName(jamulusOutputRight)
-	if jil != nil && jir != nil && jol != nil && jor != nil {
+	jil := ac.JackClient.HasPort(jamulusInputLeft)
+	jir := ac.JackClient.HasPort(jamulusInputRight)
+	jol := ac.JackClient.HasPort(jamulusOutputLeft)
+	jor := ac.JackClient.HasPort(jamulusOutputRight)
+	if jil && jir && jol && jor {
 		opt = jamulusOutputLeft
 		if serverChannel == 1 {
 			if isInput {
@@ -131,7 +149,7 @@ func (ac *AutoConnector) isValidPort(name string) bool {
 	if name == "" {
 		return false
 	}
-	if p := ac.JackClient.GetPortByName(name); p != nil {
+	if ac.JackClient.HasPort(name) {
 		return true
 	}
 	log.Info("Could not find JACK port", "name", name)
@@ -141,12 +159,11 @@ func (ac *AutoConnector) isValidPort(name string) bool {
 // isConnected checks if a JACK connection exists from src->dest
 // NOTE: go-jack does not implement the "jack_port_connected_to"
 func (ac *AutoConnector) isConnected(src, dest string) bool {
-	p := ac.JackClient.GetPortByName(src)
-	if p == nil {
+	if !ac.JackClient.HasPort(src) {
 		log.Error(errors.New("connection failed"), "JACK port no longer exists", "name", src)
 		return true
 	}
-	for _, conn := range p.GetConnections() {
+	for _, conn := range ac.JackClient.GetConnections(src) {
 		if conn == dest {
 			log.Info("JACK ports already connected", "src", src, "dest", dest)
 			return true
@@ -169,9 +186,30 @@ func (ac *AutoConnector) connectPorts(src, dest string) {
 	}
 }
 
+// disconnectPorts removes the JACK connection from src->dest, if it exists
+func (ac *AutoConnector) disconnectPorts(src, dest string) {
+	connected := false
+	for _, conn := range ac.JackClient.GetConnections(src) {
+		if conn == dest {
+			connected = true
+			break
+		}
+	}
+	if !connected {
+		return
+	}
+	code := ac.JackClient.Disconnect(src, dest)
+	switch code {
+	case 0:
+		log.Info("Disconnected JACK ports", "src", src, "dest", dest)
+	default:
+		log.Error(jack.StrError(code), "Unexpected error disconnecting JACK ports", "src", src, "dest", dest)
+	}
+}
+
 // connectSingleZitaPort establishes individual JackTrip/Jamulus<->zita audio connections
-func (ac *AutoConnector) connectSingleZitaPort(port *jack.Port) {
-	suffix := port.GetShortName()
+func (ac *AutoConnector) connectSingleZitaPort(name string) {
+	suffix := name[strings.Index(name, ":")+1:]
 
 	isInput := true
 	if strings.HasPrefix(suffix, "playback_") {
@@ -179,7 +217,7 @@ func (ac *AutoConnector) connectSingleZitaPort(port *jack.Port) {
 	}
 
 	serverPortName := ac.getServerPortName(1, isInput)
-	if strings.HasSuffix(port.GetName(), "_2") {
+	if strings.HasSuffix(name, "_2") {
 		serverPortName = ac.getServerPortName(2, isInput)
 		if !ac.isValidPort(serverPortName) {
 			serverPortName = ac.getServerPortName(1, isInput)
@@ -188,9 +226,9 @@ func (ac *AutoConnector) connectSingleZitaPort(port *jack.Port) {
 
 	if ac.isValidPort(serverPortName) {
 		if isInput {
-			ac.connectPorts(port.GetName(), serverPortName)
+			ac.connectPorts(name, serverPortName)
 		} else {
-			ac.connectPorts(serverPortName, port.GetName())
+			ac.connectPorts(serverPortName, name)
 		}
 	}
 }
@@ -205,11 +243,10 @@ func (ac *AutoConnector) connectAllZitaPorts() {
 			if strings.HasPrefix(port, "system:") {
 				continue
 			}
-			jackPort := ac.JackClient.GetPortByName(port)
-			if jackPort == nil {
+			if !ac.JackClient.HasPort(port) {
 				log.Error(errors.New("connection failed"), "JACK port no longer exists", "name", port)
 			} else {
-				ac.connectSingleZitaPort(jackPort)
+				ac.connectSingleZitaPort(port)
 			}
 		}
 	}
@@ -230,11 +267,7 @@ func (ac *AutoConnector) connect(portID jack.PortId) error {
 	ac.ClientLock.Lock()
 	defer ac.ClientLock.Unlock()
 	if ac.JackClient == nil {
-		if err := common.WaitForJackd(); err != nil {
-			log.Error(err, "Unable to find JACK daemon")
-			return err
-		}
-		client, err := common.InitJackClient(ac.Name, ac.handlePortRegistration, ac.onShutdown, nil, nil, false)
+		client, err := openJackGraph(ac.Name, ac.handlePortRegistration, ac.onShutdown)
 		if err != nil {
 			log.Error(err, "Unable to initialize JACK client")
 			return err
@@ -243,15 +276,15 @@ func (ac *AutoConnector) connect(portID jack.PortId) error {
 		// Trigger a full-scan on initiation
 		ac.connectAllZitaPorts()
 	} else {
-		port := ac.JackClient.GetPortById(portID)
-		name := port.GetName()
+		name := ac.JackClient.GetPortName(portID)
 		match := ac.JTRegexp.MatchString(name) && !strings.HasPrefix(name, "system:")
 		if match {
-			ac.connectSingleZitaPort(port)
+			ac.connectSingleZitaPort(name)
 		}
 		if strings.HasPrefix(name, "Jamulus:") || strings.HasPrefix(name, "hubserver:") {
 			ac.connectAllZitaPorts()
 		}
+		ac.connectDevicePorts(name)
 	}
 	return nil
 }
@@ -264,6 +297,7 @@ func (ac *AutoConnector) TeardownClient() {
 		ac.JackClient.Close()
 	}
 	ac.JackClient = nil
+	deviceState.SetStatus(AutoConnectorSubsystem, "disconnected")
 	log.Info("Teardown of JACK client completed")
 }
 
@@ -271,11 +305,7 @@ func (ac *AutoConnector) TeardownClient() {
 func (ac *AutoConnector) SetupClient() {
 	ac.ClientLock.Lock()
 	defer ac.ClientLock.Unlock()
-	if err := common.WaitForJackd(); err != nil {
-		log.Error(err, "Unable to find JACK daemon")
-		panic(err)
-	}
-	client, err := common.InitJackClient(ac.Name, ac.handlePortRegistration, ac.onShutdown, nil, nil, false)
+	client, err := openJackGraph(ac.Name, ac.handlePortRegistration, ac.onShutdown)
 	if err != nil {
 		log.Error(err, "Unable to initialize JACK client")
 		panic(err)
@@ -283,6 +313,7 @@ func (ac *AutoConnector) SetupClient() {
 	ac.JackClient = client
 	// Trigger a full-scan on initiation
 	ac.connectAllZitaPorts()
+	deviceState.SetStatus(AutoConnectorSubsystem, "connected")
 	log.Info("Setup of JACK client completed", "name", ac.JackClient.GetName())
 }
 
@@ -307,6 +338,12 @@ func (ac *AutoConnector) Run(ctx context.Context, wg *sync.WaitGroup) {
 			if err != nil {
 				log.Error(err, "Failed to connect ports")
 			}
+		case <-ac.UnregistrationChannel:
+			ac.ClientLock.Lock()
+			if ac.JackClient != nil {
+				ac.rerouteDevicePorts()
+			}
+			ac.ClientLock.Unlock()
 		}
 	}
 }
@@ -0,0 +1,44 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import "strings"
+
+// connectDevicePorts routes the AES67 bridge, local input chain, metronome and timecode when one of their ports is registered
+func (ac *AutoConnector) connectDevicePorts(name string) {
+	config := deviceState.Config()
+	if isAES67Enabled(config) && (isAES67Port(name) || isEffectsPort(name) || isLV2Port(name) || strings.HasPrefix(name, "hubserver:")) {
+		ac.connectAES67Ports(config)
+	} else if isInputChainEnabled(config) && (isEffectsPort(name) || isLV2Port(name) || strings.HasPrefix(name, "hubserver:")) {
+		ac.connectInputChain(config)
+	}
+	if isMetronomePort(name) || strings.HasPrefix(name, "Jamulus:") || strings.HasPrefix(name, "hubserver:") {
+		ac.connectMetronomePorts(config.MetronomeRouting)
+	}
+	if isTimecodePort(name) || strings.HasPrefix(name, "hubserver:") {
+		ac.connectTimecodePorts(config)
+	}
+}
+
+// rerouteDevicePorts falls back to direct input routing when a port of the local input chain is unregistered
+func (ac *AutoConnector) rerouteDevicePorts() {
+	config := deviceState.Config()
+	if isInputChainEnabled(config) {
+		ac.connectInputChain(config)
+	}
+}
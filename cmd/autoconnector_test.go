@@ -90,7 +90,7 @@ func TestOnShutdown(t *testing.T) {
 	assert := assert.New(t)
 	ac := NewAutoConnector()
 	// onShutdown should revert the FullScanDone boolean
-	ac.JackClient = &jack.Client{}
+	ac.JackClient = NewFakeJackGraph(ac.Name)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
@@ -107,7 +107,7 @@ func TestTeardownClient(t *testing.T) {
 	assert := assert.New(t)
 	ac := NewAutoConnector()
 	// onShutdown should nullify the active JackClient
-	ac.JackClient = &jack.Client{}
+	ac.JackClient = NewFakeJackGraph(ac.Name)
 	ac.TeardownClient()
 	assert.Nil(ac.JackClient)
 }
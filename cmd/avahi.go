@@ -0,0 +1,199 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// AvahiServiceType is the DNS-SD type of the agent's HTTP service
+	AvahiServiceType = "_http._tcp"
+
+	// AvahiServicePort is the port of the agent's HTTP service
+	AvahiServicePort = 80
+
+	// AvahiDBusName is the well-known name of the Avahi daemon on the system bus
+	AvahiDBusName = "org.freedesktop.Avahi"
+
+	// AvahiInterfaceUnspec and AvahiProtocolUnspec publish on all network interfaces and IP protocols
+	AvahiInterfaceUnspec = int32(-1)
+	AvahiProtocolUnspec  = int32(-1)
+)
+
+// AvahiPublisher advertises the agent's service record over mDNS
+type AvahiPublisher interface {
+	// Publish registers the service record, or updates its TXT records if it was already registered
+	Publish(txt []string) error
+
+	// Remove withdraws the service record
+	Remove() error
+}
+
+// avahiPublisher is used for all mDNS service announcements
+var avahiPublisher AvahiPublisher = &fileAvahiPublisher{}
+
+// getAvahiTXTRecords returns the TXT records that describe a device
+func getAvahiTXTRecords(beat client.DeviceHeartbeat, credentials client.AgentCredentials, status string) []string {
+	return []string{
+		fmt.Sprintf("status=%s", status),
+		fmt.Sprintf("version=%s", beat.Version),
+		fmt.Sprintf("mac=%s", beat.MAC),
+		fmt.Sprintf("apiHash=%s", client.GetAPIHash(credentials.APISecret)),
+		fmt.Sprintf("pairing=%s", PairingPath),
+	}
+}
+
+// dbusAvahiPublisher registers the service record directly with the Avahi daemon over D-Bus,
+// so that status changes are announced immediately and the record is withdrawn on shutdown
+type dbusAvahiPublisher struct {
+	conn  *dbus.Conn
+	group dbus.BusObject
+	name  string
+	mutex sync.Mutex
+}
+
+// newDBusAvahiPublisher connects to the Avahi daemon over the system bus
+func newDBusAvahiPublisher() (*dbusAvahiPublisher, error) {
+	conn, err := dbus.SystemBus()
+	if err != nil {
+		return nil, err
+	}
+	var hostname string
+	server := conn.Object(AvahiDBusName, "/")
+	if err := server.Call("org.freedesktop.Avahi.Server.GetHostName", 0).Store(&hostname); err != nil {
+		return nil, err
+	}
+	return &dbusAvahiPublisher{conn: conn, name: fmt.Sprintf("JackTrip Agent on %s", hostname)}, nil
+}
+
+// getTXTBytes converts TXT records to the byte arrays expected by Avahi
+func getTXTBytes(txt []string) [][]byte {
+	var result [][]byte
+	for _, record := range txt {
+		result = append(result, []byte(record))
+	}
+	return result
+}
+
+// Publish registers the service record, or updates its TXT records if it was already registered
+func (p *dbusAvahiPublisher) Publish(txt []string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.group != nil {
+		return p.group.Call("org.freedesktop.Avahi.EntryGroup.UpdateServiceTxt", 0, AvahiInterfaceUnspec, AvahiProtocolUnspec,
+			uint32(0), p.name, AvahiServiceType, "", getTXTBytes(txt)).Err
+	}
+
+	var path dbus.ObjectPath
+	server := p.conn.Object(AvahiDBusName, "/")
+	if err := server.Call("org.freedesktop.Avahi.Server.EntryGroupNew", 0).Store(&path); err != nil {
+		return err
+	}
+	group := p.conn.Object(AvahiDBusName, path)
+	err := group.Call("org.freedesktop.Avahi.EntryGroup.AddService", 0, AvahiInterfaceUnspec, AvahiProtocolUnspec,
+		uint32(0), p.name, AvahiServiceType, "", "", uint16(AvahiServicePort), getTXTBytes(txt)).Err
+	if err == nil {
+		err = group.Call("org.freedesktop.Avahi.EntryGroup.Commit", 0).Err
+	}
+	if err != nil {
+		group.Call("org.freedesktop.Avahi.EntryGroup.Free", 0)
+		return err
+	}
+	p.group = group
+	return nil
+}
+
+// Remove withdraws the service record
+func (p *dbusAvahiPublisher) Remove() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.group == nil {
+		return nil
+	}
+	err := p.group.Call("org.freedesktop.Avahi.EntryGroup.Free", 0).Err
+	p.group = nil
+	return err
+}
+
+// fileAvahiPublisher writes an Avahi service file, for systems where Avahi is not reachable over D-Bus
+type fileAvahiPublisher struct{}
+
+// getAvahiServiceFile returns the contents of an Avahi service file with the given TXT records
+func getAvahiServiceFile(txt []string) string {
+	var records strings.Builder
+	for _, record := range txt {
+		fmt.Fprintf(&records, "\t\t<txt-record value-format=\"text\">%s</txt-record>\n", record)
+	}
+	return fmt.Sprintf(`<?xml version="1.0" standalone='no'?><!--*-nxml-*-->
+<!DOCTYPE service-group SYSTEM "avahi-service.dtd">
+<service-group>
+	<name replace-wildcards="yes">JackTrip Agent on %%h</name>
+	<service>
+		<type>%s</type>
+		<port>%d</port>
+%s	</service>
+</service-group>
+`, AvahiServiceType, AvahiServicePort, records.String())
+}
+
+// Publish writes the service file, which the Avahi daemon reloads when it changes
+func (fileAvahiPublisher) Publish(txt []string) error {
+	if err := os.MkdirAll(AvahiServicesDir, 0755); err != nil {
+		return err
+	}
+	_, err := common.WriteFileIfChanged(PathToAvahiServiceFile, []byte(getAvahiServiceFile(txt)), 0644)
+	return err
+}
+
+// Remove deletes the service file
+func (fileAvahiPublisher) Remove() error {
+	if err := os.Remove(PathToAvahiServiceFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// newAvahiPublisher returns a publisher that uses D-Bus if the Avahi daemon is reachable, or service files otherwise
+func newAvahiPublisher() AvahiPublisher {
+	p, err := newDBusAvahiPublisher()
+	if err != nil {
+		log.Error(err, "Avahi is not reachable over D-Bus, falling back to service files", "path", PathToAvahiServiceFile)
+		return &fileAvahiPublisher{}
+	}
+	// remove any service file left behind by older versions, to avoid announcing the device twice
+	(fileAvahiPublisher{}).Remove()
+	return p
+}
+
+// publishAvahiService announces the device and its status over mDNS
+func publishAvahiService(beat client.DeviceHeartbeat, credentials client.AgentCredentials, status string) {
+	if err := avahiPublisher.Publish(getAvahiTXTRecords(beat, credentials, status)); err != nil {
+		log.Error(err, "Failed to publish avahi service")
+		return
+	}
+	log.Info(fmt.Sprintf("Updated Avahi service status to %s", status))
+}
@@ -0,0 +1,67 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAvahiTXTRecords(t *testing.T) {
+	assert := assert.New(t)
+	beat := client.DeviceHeartbeat{MAC: "00:11:22:33:44:55", Version: "1.2.3"}
+	credentials := client.AgentCredentials{APISecret: "blackpink"}
+	assert.Equal([]string{
+		"status=connected",
+		"version=1.2.3",
+		"mac=00:11:22:33:44:55",
+		"apiHash=b13dabc4285540382af3f280bfc55c0752806a177f896afa8ec568b0206c3bf5",
+		"pairing=/pairing",
+	}, getAvahiTXTRecords(beat, credentials, "connected"))
+	assert.Equal([][]byte{[]byte("a=1"), []byte("b=2")}, getTXTBytes([]string{"a=1", "b=2"}))
+}
+
+func TestFileAvahiPublisher(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prev AvahiPublisher, dir string) {
+		avahiPublisher, AvahiServicesDir = prev, dir
+		updatePaths()
+	}(avahiPublisher, AvahiServicesDir)
+	avahiPublisher = &fileAvahiPublisher{}
+	AvahiServicesDir = t.TempDir()
+	updatePaths()
+
+	publishAvahiService(client.DeviceHeartbeat{MAC: "abc", Version: "1.0"}, client.AgentCredentials{}, "starting")
+	rawBytes, err := ioutil.ReadFile(PathToAvahiServiceFile)
+	assert.Nil(err)
+	content := string(rawBytes)
+	assert.Contains(content, `<name replace-wildcards="yes">JackTrip Agent on %h</name>`)
+	assert.Contains(content, "<type>_http._tcp</type>\n\t\t<port>80</port>\n")
+	assert.Contains(content, "\t\t<txt-record value-format=\"text\">status=starting</txt-record>\n")
+	assert.Contains(content, "\t\t<txt-record value-format=\"text\">mac=abc</txt-record>\n")
+
+	// the service is withdrawn on shutdown
+	assert.Nil(avahiPublisher.Remove())
+	_, err = os.Stat(PathToAvahiServiceFile)
+	assert.True(os.IsNotExist(err))
+	assert.Nil(avahiPublisher.Remove())
+}
@@ -0,0 +1,179 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// AccountingTable is the nftables table used to count JackTrip traffic; it only counts, and never drops packets
+	AccountingTable = "jacktrip_accounting"
+
+	// AccountingSentCounter and AccountingReceivedCounter are the named nftables counters of JackTrip traffic
+	AccountingSentCounter     = "jacktrip_tx"
+	AccountingReceivedCounter = "jacktrip_rx"
+
+	// DefaultJackTripBindPort is the local UDP port used by JackTrip when devicePort is not set
+	DefaultJackTripBindPort = 4464
+)
+
+// BandwidthAccounting counts the bytes sent and received by JackTrip during a session, using nftables counters
+type BandwidthAccounting struct {
+	active    bool
+	sent      uint64
+	received  uint64
+	startedAt time.Time
+	mutex     sync.Mutex
+}
+
+// deviceBandwidth counts the JackTrip traffic of the device
+var deviceBandwidth = &BandwidthAccounting{}
+
+// getJackTripBindPort returns the local UDP port used by JackTrip
+func getJackTripBindPort(config client.DeviceAgentConfig) int {
+	if config.DevicePort > 0 {
+		return config.DevicePort
+	}
+	return DefaultJackTripBindPort
+}
+
+// getAccountingRuleset returns nftables rules that count the UDP traffic of a local port
+func getAccountingRuleset(port int) string {
+	return fmt.Sprintf(`table inet %[1]s {}
+flush table inet %[1]s
+
+table inet %[1]s {
+  counter %[2]s {}
+  counter %[3]s {}
+  chain input {
+    type filter hook input priority -10; policy accept;
+    udp dport %[4]d counter name "%[3]s"
+  }
+  chain output {
+    type filter hook output priority -10; policy accept;
+    udp sport %[4]d counter name "%[2]s"
+  }
+}
+`, AccountingTable, AccountingSentCounter, AccountingReceivedCounter, port)
+}
+
+// parseNFTCounters returns the bytes counted by each named counter, from the output of `nft -j list counters`
+func parseNFTCounters(out []byte) (map[string]uint64, error) {
+	var ruleset struct {
+		NFTables []struct {
+			Counter *struct {
+				Name  string `json:"name"`
+				Table string `json:"table"`
+				Bytes uint64 `json:"bytes"`
+			} `json:"counter"`
+		} `json:"nftables"`
+	}
+	if err := json.Unmarshal(out, &ruleset); err != nil {
+		return nil, err
+	}
+	counters := map[string]uint64{}
+	for _, item := range ruleset.NFTables {
+		if item.Counter != nil && item.Counter.Table == AccountingTable {
+			counters[item.Counter.Name] = item.Counter.Bytes
+		}
+	}
+	return counters, nil
+}
+
+// readAccountingCounters returns the bytes sent and received by JackTrip since the counters were created
+func readAccountingCounters() (uint64, uint64, error) {
+	out, err := runPrivileged(NFTPath, "-j", "list", "counters", "table", "inet", AccountingTable)
+	if err != nil {
+		return 0, 0, err
+	}
+	counters, err := parseNFTCounters(out)
+	if err != nil {
+		return 0, 0, err
+	}
+	return counters[AccountingSentCounter], counters[AccountingReceivedCounter], nil
+}
+
+// StartSession starts counting JackTrip traffic from zero when a device connects to an audio server,
+// and stops counting when it disconnects
+func (b *BandwidthAccounting) StartSession(config client.DeviceAgentConfig) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.active = false
+
+	if !bool(config.Enabled) || config.Host == "" || !usesJackTrip(config) {
+		if _, err := os.Stat(PathToAccountingRules); os.IsNotExist(err) {
+			return
+		}
+		if _, err := runPrivileged(NFTPath, "delete", "table", "inet", AccountingTable); err != nil {
+			log.Error(err, "Unable to remove bandwidth accounting rules")
+		}
+		os.Remove(PathToAccountingRules)
+		return
+	}
+
+	changed, err := common.WriteFileIfChanged(PathToAccountingRules, []byte(getAccountingRuleset(getJackTripBindPort(config))), 0644)
+	if err != nil {
+		log.Error(err, "Unable to save bandwidth accounting rules", "path", PathToAccountingRules)
+		return
+	}
+	if changed {
+		if _, err := runPrivileged(NFTPath, "-f", PathToAccountingRules); err != nil {
+			log.Error(err, "Unable to apply bandwidth accounting rules")
+			os.Remove(PathToAccountingRules)
+			return
+		}
+	}
+
+	// counters keep running across sessions that use the same port, so only count from here
+	if b.sent, b.received, err = readAccountingCounters(); err != nil {
+		log.Error(err, "Unable to read bandwidth accounting counters")
+		return
+	}
+	b.active = true
+	b.startedAt = time.Now()
+}
+
+// Usage returns the JackTrip traffic of the current session, or nil if there is none
+func (b *BandwidthAccounting) Usage() (*client.BandwidthUsage, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if !b.active {
+		return nil, nil
+	}
+	sent, received, err := readAccountingCounters()
+	if err != nil {
+		return nil, err
+	}
+	// counters start again from zero if the rules were reapplied by someone else
+	if sent < b.sent || received < b.received {
+		b.sent, b.received = 0, 0
+	}
+	return &client.BandwidthUsage{
+		BytesSent:        sent - b.sent,
+		BytesReceived:    received - b.received,
+		SessionStartedAt: b.startedAt,
+	}, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// nftCountersOutput formats the output of `nft -j list counters` for the accounting table
+func nftCountersOutput(sent, received uint64) string {
+	return fmt.Sprintf(`{"nftables": [{"metainfo": {"json_schema_version": 1}}, `+
+		`{"counter": {"family": "inet", "name": "jacktrip_tx", "table": "jacktrip_accounting", "handle": 1, "packets": 10, "bytes": %d}}, `+
+		`{"counter": {"family": "inet", "name": "jacktrip_rx", "table": "jacktrip_accounting", "handle": 2, "packets": 20, "bytes": %d}}]}`, sent, received)
+}
+
+func TestParseNFTCounters(t *testing.T) {
+	assert := assert.New(t)
+	counters, err := parseNFTCounters([]byte(nftCountersOutput(100, 200)))
+	assert.NoError(err)
+	assert.Equal(map[string]uint64{AccountingSentCounter: 100, AccountingReceivedCounter: 200}, counters)
+	_, err = parseNFTCounters([]byte("Error: No such file or directory"))
+	assert.Error(err)
+}
+
+func TestGetAccountingRuleset(t *testing.T) {
+	assert := assert.New(t)
+	ruleset := getAccountingRuleset(4465)
+	assert.Contains(ruleset, `udp dport 4465 counter name "jacktrip_rx"`)
+	assert.Contains(ruleset, `udp sport 4465 counter name "jacktrip_tx"`)
+	assert.NotContains(ruleset, "drop")
+}
+
+func TestBandwidthAccounting(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prev SystemRunner, dir string) {
+		systemRunner, ServiceConfigDir = prev, dir
+		updatePaths()
+	}(systemRunner, ServiceConfigDir)
+	runner := NewFakeRunner()
+	systemRunner = runner
+	ServiceConfigDir = t.TempDir()
+	updatePaths()
+	listCounters := "nft -j list counters table inet " + AccountingTable
+	b := &BandwidthAccounting{}
+	config := client.DeviceAgentConfig{}
+	config.Type = client.JackTrip
+
+	// Case for no session
+	b.StartSession(config)
+	assert.Empty(runner.Commands)
+	usage, err := b.Usage()
+	assert.NoError(err)
+	assert.Nil(usage)
+
+	// Case for a session, which counts from the start of the session
+	config.Enabled = true
+	config.Host = "a.b.com"
+	runner.Outputs[listCounters] = nftCountersOutput(1000, 2000)
+	b.StartSession(config)
+	assert.Equal([]string{"nft -f " + PathToAccountingRules, listCounters}, runner.Commands)
+	runner.Outputs[listCounters] = nftCountersOutput(1500, 4000)
+	usage, err = b.Usage()
+	assert.NoError(err)
+	assert.Equal(uint64(500), usage.BytesSent)
+	assert.Equal(uint64(2000), usage.BytesReceived)
+
+	// Case for counters that were reset
+	runner.Outputs[listCounters] = nftCountersOutput(10, 20)
+	usage, err = b.Usage()
+	assert.NoError(err)
+	assert.Equal(uint64(10), usage.BytesSent)
+	assert.Equal(uint64(20), usage.BytesReceived)
+
+	// Case for disconnecting, which removes the rules
+	config.Enabled = false
+	b.StartSession(config)
+	assert.Equal("nft delete table inet "+AccountingTable, runner.Commands[len(runner.Commands)-1])
+	assert.NoFileExists(PathToAccountingRules)
+	usage, err = b.Usage()
+	assert.NoError(err)
+	assert.Nil(usage)
+}
@@ -0,0 +1,207 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// ChronyServiceName is the name of the systemd service for chrony
+	ChronyServiceName = "chrony.service"
+
+	// PTPServiceName is the name of the systemd service that synchronizes the network interface clock using PTP
+	PTPServiceName = "ptp4l.service"
+
+	// PHC2SysServiceName is the name of the systemd service that synchronizes the system clock to the network interface clock
+	PHC2SysServiceName = "phc2sys.service"
+
+	// ChronyConfigTemplate is the template used to generate /tmp/default/chrony.conf on raspberry pi devices
+	ChronyConfigTemplate = "%smakestep 1.0 3\nrtcsync\n"
+
+	// PTPConfigTemplate is the template used to generate /tmp/default/ptp file on raspberry pi devices
+	PTPConfigTemplate = "PTP4L_OPTS=-i %s --domainNumber %d -s\nPHC2SYS_OPTS=-s %s -c CLOCK_REALTIME -w\n"
+
+	// DefaultClockSyncInterface is the network interface used for PTP when none is configured
+	DefaultClockSyncInterface = "eth0"
+
+	// ChronycPath is the path to the chrony client
+	ChronycPath = "/usr/bin/chronyc"
+
+	// PMCPath is the path to the PTP management client
+	PMCPath = "/usr/sbin/pmc"
+)
+
+// getClockSyncInterface returns the configured PTP network interface, or the default interface
+func getClockSyncInterface(config client.ClockSyncConfig) string {
+	if config.ClockSyncInterface == "" {
+		return DefaultClockSyncInterface
+	}
+	return config.ClockSyncInterface
+}
+
+// getChronyConfig returns chrony config for a list of comma-separated NTP servers
+func getChronyConfig(servers string) string {
+	var lines string
+	for _, server := range strings.Split(servers, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			lines += fmt.Sprintf("server %s iburst\n", server)
+		}
+	}
+	return fmt.Sprintf(ChronyConfigTemplate, lines)
+}
+
+// updateClockSyncConfig writes the config files used by clock sync services
+func updateClockSyncConfig(config client.ClockSyncConfig) {
+	if err := os.MkdirAll(ServiceConfigDir, 0755); err != nil {
+		log.Error(err, "Failed to create directory", "path", ServiceConfigDir)
+		return
+	}
+	switch config.ClockSync {
+	case client.ClockSyncChrony:
+		_, err := common.WriteFileIfChanged(PathToChronyConfig, []byte(getChronyConfig(config.ClockSyncServers)), 0644)
+		if err != nil {
+			log.Error(err, "Failed to save chrony config", "path", PathToChronyConfig)
+		}
+	case client.ClockSyncPTP:
+		iface := getClockSyncInterface(config)
+		ptpConfig := fmt.Sprintf(PTPConfigTemplate, iface, config.ClockSyncPTPDomain, iface)
+		_, err := common.WriteFileIfChanged(PathToPTPConfig, []byte(ptpConfig), 0644)
+		if err != nil {
+			log.Error(err, "Failed to save PTP config", "path", PathToPTPConfig)
+		}
+	default:
+		// chrony falls back to the servers from the device image
+		if err := os.Remove(PathToChronyConfig); err != nil && !os.IsNotExist(err) {
+			log.Error(err, "Failed to remove chrony config", "path", PathToChronyConfig)
+		}
+	}
+}
+
+// restartClockSync writes clock sync config files, and restarts the services for the configured mode
+// NOTE: the device clock is disciplined even when the device is not connected to a studio
+func restartClockSync(config client.ClockSyncConfig) {
+	updateClockSyncConfig(config)
+
+	// chrony and phc2sys must never discipline the system clock at the same time
+	servicesToStop := []string{PTPServiceName, PHC2SysServiceName}
+	var servicesToStart []string
+	switch config.ClockSync {
+	case client.ClockSyncChrony:
+		servicesToStop = append(servicesToStop, ChronyServiceName)
+		servicesToStart = []string{ChronyServiceName}
+	case client.ClockSyncPTP:
+		servicesToStop = append(servicesToStop, ChronyServiceName)
+		servicesToStart = []string{PTPServiceName, PHC2SysServiceName}
+	default:
+		// restart chrony with its default config, so that the clock is still disciplined after PTP is stopped
+		servicesToStop = append(servicesToStop, ChronyServiceName)
+		servicesToStart = []string{ChronyServiceName}
+	}
+
+	if err := serviceManager.Stop(servicesToStop...); err != nil {
+		log.Error(err, "Unable to stop clock sync services")
+		return
+	}
+	for _, name := range servicesToStart {
+		if err := serviceManager.Start(name); err != nil {
+			log.Error(err, "Unable to start clock sync service", "name", name)
+			return
+		}
+	}
+	log.Info("Updated clock sync", "mode", config.ClockSync)
+}
+
+// parseChronyTracking parses the output of `chronyc -c tracking`
+func parseChronyTracking(output string) (client.ClockSyncStatus, error) {
+	status := client.ClockSyncStatus{Mode: client.ClockSyncChrony}
+	fields := strings.Split(strings.TrimSpace(output), ",")
+	if len(fields) < 14 {
+		return status, fmt.Errorf("unexpected chronyc output: %q", output)
+	}
+	offset, err := strconv.ParseFloat(fields[4], 64)
+	if err != nil {
+		return status, err
+	}
+	stratum, _ := strconv.Atoi(fields[2])
+	status.Source = fields[1]
+	status.Offset = time.Duration(math.Round(offset * float64(time.Second)))
+	status.Synchronized = stratum > 0 && stratum < 16 && fields[13] != "Not synchronised"
+	return status, nil
+}
+
+// parsePMCTimeStatus parses the output of `pmc -u -b 0 'GET TIME_STATUS_NP'`
+func parsePMCTimeStatus(output string) (client.ClockSyncStatus, error) {
+	status := client.ClockSyncStatus{Mode: client.ClockSyncPTP}
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "master_offset":
+			offset, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return status, err
+			}
+			status.Offset = time.Duration(offset)
+			found = true
+		case "gmPresent":
+			status.Synchronized = fields[1] == "true"
+		case "gmIdentity":
+			status.Source = fields[1]
+		}
+	}
+	if !found {
+		return status, fmt.Errorf("unexpected pmc output: %q", output)
+	}
+	return status, nil
+}
+
+// getClockSyncStatus returns the status of the device clock, or nil if clock sync is disabled
+func getClockSyncStatus(config client.ClockSyncConfig) *client.ClockSyncStatus {
+	var status client.ClockSyncStatus
+	var out []byte
+	var err error
+	switch config.ClockSync {
+	case client.ClockSyncChrony:
+		if out, err = systemRunner.Output(ChronycPath, "-c", "tracking"); err == nil {
+			status, err = parseChronyTracking(string(out))
+		}
+	case client.ClockSyncPTP:
+		if out, err = systemRunner.Output(PMCPath, "-u", "-b", "0", "GET TIME_STATUS_NP"); err == nil {
+			status, err = parsePMCTimeStatus(string(out))
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		log.V(1).Info("Unable to get clock sync status", "error", err.Error())
+		status = client.ClockSyncStatus{Mode: config.ClockSync}
+	}
+	return &status
+}
@@ -0,0 +1,106 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetChronyConfig(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("server ntp1.local iburst\nserver ntp2.local iburst\nmakestep 1.0 3\nrtcsync\n", getChronyConfig("ntp1.local, ntp2.local,"))
+}
+
+func TestParseChronyTracking(t *testing.T) {
+	assert := assert.New(t)
+	status, err := parseChronyTracking("A29FC87B,ntp1.local,3,1650000000.123456789,-0.000012345,0.000001234,0.000023456,-12.345,0.001,0.023,0.001234,0.000567,64.5,Normal\n")
+	assert.Nil(err)
+	assert.Equal(client.ClockSyncStatus{Mode: client.ClockSyncChrony, Synchronized: true, Offset: -12345 * time.Nanosecond, Source: "ntp1.local"}, status)
+
+	status, err = parseChronyTracking("00000000,,0,0.000000000,0.000000000,0.000000000,0.000000000,0.000,0.000,0.000,1.000000000,1.000000000,0.0,Not synchronised\n")
+	assert.Nil(err)
+	assert.False(status.Synchronized)
+
+	_, err = parseChronyTracking("506 Cannot talk to daemon")
+	assert.NotNil(err)
+}
+
+func TestParsePMCTimeStatus(t *testing.T) {
+	assert := assert.New(t)
+	output := `sending: GET TIME_STATUS_NP
+	b827eb.fffe.123456-0 seq 0 RESPONSE MANAGEMENT TIME_STATUS_NP
+		master_offset              -23
+		ingress_time               1650000000123456789
+		gmPresent                  true
+		gmIdentity                 001122.fffe.334455
+`
+	status, err := parsePMCTimeStatus(output)
+	assert.Nil(err)
+	assert.Equal(client.ClockSyncStatus{Mode: client.ClockSyncPTP, Synchronized: true, Offset: -23, Source: "001122.fffe.334455"}, status)
+
+	_, err = parsePMCTimeStatus("sending: GET TIME_STATUS_NP\n")
+	assert.NotNil(err)
+}
+
+func TestRestartClockSync(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prev ServiceManager, dir string) {
+		serviceManager, ServiceConfigDir = prev, dir
+		updatePaths()
+	}(serviceManager, ServiceConfigDir)
+	services := NewFakeServiceManager(ChronyServiceName, PTPServiceName, PHC2SysServiceName)
+	serviceManager = services
+	ServiceConfigDir = t.TempDir()
+	updatePaths()
+
+	// Case for PTP
+	config := client.ClockSyncConfig{ClockSync: client.ClockSyncPTP, ClockSyncPTPDomain: 2}
+	restartClockSync(config)
+	assert.True(services.IsActive(PTPServiceName))
+	assert.True(services.IsActive(PHC2SysServiceName))
+	raw, err := ioutil.ReadFile(filepath.Join(ServiceConfigDir, "ptp"))
+	assert.Nil(err)
+	assert.Equal("PTP4L_OPTS=-i eth0 --domainNumber 2 -s\nPHC2SYS_OPTS=-s eth0 -c CLOCK_REALTIME -w\n", string(raw))
+
+	// Case for chrony, which replaces PTP
+	config = client.ClockSyncConfig{ClockSync: client.ClockSyncChrony, ClockSyncServers: "ntp1.local"}
+	restartClockSync(config)
+	assert.True(services.IsActive(ChronyServiceName))
+	assert.False(services.IsActive(PTPServiceName))
+	assert.FileExists(PathToChronyConfig)
+
+	// Case for disabling clock sync, which restarts chrony with the default config
+	services.Events = nil
+	restartClockSync(client.ClockSyncConfig{})
+	assert.Equal([]string{"stop " + ChronyServiceName, "start " + ChronyServiceName}, services.Events)
+	assert.NoFileExists(PathToChronyConfig)
+
+	// Case for disabling clock sync after PTP, which starts chrony again
+	restartClockSync(client.ClockSyncConfig{ClockSync: client.ClockSyncPTP})
+	assert.False(services.IsActive(ChronyServiceName))
+	restartClockSync(client.ClockSyncConfig{})
+	assert.True(services.IsActive(ChronyServiceName))
+	assert.False(services.IsActive(PTPServiceName))
+	assert.False(services.IsActive(PHC2SysServiceName))
+}
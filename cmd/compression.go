@@ -0,0 +1,139 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressibleContentTypes are the content types that are compressed when a client accepts it
+var compressibleContentTypes = map[string]bool{
+	"application/json":              true,
+	"application/vnd.apple.mpegurl": true,
+	"application/x-mpegurl":         true,
+	"text/plain":                    true,
+	"text/html":                     true,
+}
+
+// getResponseEncoding returns the compression to use for a request's Accept-Encoding header ("gzip", "deflate" or "")
+func getResponseEncoding(acceptEncoding string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		splits := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(splits[0]))
+		weight := 1.0
+		for _, param := range splits[1:] {
+			if value := strings.TrimSpace(param); strings.HasPrefix(value, "q=") {
+				if q, err := strconv.ParseFloat(strings.TrimPrefix(value, "q="), 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		accepted[name] = weight > 0
+	}
+	for _, encoding := range []string{"gzip", "deflate"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// isCompressibleContentType checks if a response with the given Content-Type header should be compressed
+func isCompressibleContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && compressibleContentTypes[strings.ToLower(mediaType)]
+}
+
+// compressedResponseWriter compresses a response once its headers show that it is compressible
+type compressedResponseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	writer      io.WriteCloser
+	wroteHeader bool
+}
+
+// WriteHeader starts compressing the response if its content type is compressible
+func (c *compressedResponseWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	h := c.Header()
+	if status != http.StatusNoContent && status != http.StatusNotModified && h.Get("Content-Encoding") == "" && isCompressibleContentType(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+		if c.encoding == "gzip" {
+			c.writer = gzip.NewWriter(c.ResponseWriter)
+		} else {
+			c.writer, _ = flate.NewWriter(c.ResponseWriter, flate.DefaultCompression)
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes (and possibly compresses) part of the response body
+func (c *compressedResponseWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		if c.Header().Get("Content-Type") == "" {
+			c.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.writer != nil {
+		return c.writer.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client
+func (c *compressedResponseWriter) Flush() {
+	if f, ok := c.writer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes compressing the response
+func (c *compressedResponseWriter) Close() error {
+	if c.writer != nil {
+		return c.writer.Close()
+	}
+	return nil
+}
+
+// compressionMiddleware compresses JSON and playlist responses for clients that accept gzip or deflate
+// NOTE: websocket upgrades are passed through, since they need to hijack the connection
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := getResponseEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		cw := &compressedResponseWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetResponseEncoding(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", getResponseEncoding(""))
+	assert.Equal("gzip", getResponseEncoding("gzip, deflate, br"))
+	assert.Equal("gzip", getResponseEncoding("deflate;q=0.5, GZIP"))
+	assert.Equal("deflate", getResponseEncoding("gzip;q=0, deflate"))
+	assert.Equal("", getResponseEncoding("identity, br"))
+}
+
+func TestIsCompressibleContentType(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(isCompressibleContentType("application/json"))
+	assert.True(isCompressibleContentType("application/vnd.apple.mpegurl"))
+	assert.True(isCompressibleContentType("text/plain; charset=utf-8"))
+	assert.False(isCompressibleContentType("video/mp2t"))
+	assert.False(isCompressibleContentType(""))
+}
+
+func TestCompressionMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	payload := map[string]string{"status": "connected", "padding": string(bytes.Repeat([]byte("a"), 1000))}
+	handler := compressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/status":
+			RespondJSON(w, http.StatusOK, payload)
+		case "/segment.ts":
+			w.Header().Set("Content-Type", "video/mp2t")
+			w.Write([]byte("segment"))
+		case "/text":
+			w.Write([]byte("hello world"))
+		}
+	}))
+	serve := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com"+path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// Case for clients that don't accept compression
+	resp := serve("/status", "")
+	assert.Equal("", resp.Header().Get("Content-Encoding"))
+	assert.Contains(resp.Body.String(), "connected")
+
+	// Case for gzip
+	resp = serve("/status", "gzip")
+	assert.Equal("gzip", resp.Header().Get("Content-Encoding"))
+	assert.Equal("Accept-Encoding", resp.Header().Get("Vary"))
+	assert.Less(resp.Body.Len(), 200)
+	reader, err := gzip.NewReader(resp.Body)
+	assert.NoError(err)
+	body, err := ioutil.ReadAll(reader)
+	assert.NoError(err)
+	assert.Contains(string(body), "connected")
+
+	// Case for deflate
+	resp = serve("/status", "deflate")
+	assert.Equal("deflate", resp.Header().Get("Content-Encoding"))
+	body, err = ioutil.ReadAll(flate.NewReader(resp.Body))
+	assert.NoError(err)
+	assert.Contains(string(body), "connected")
+
+	// Case for content that is not compressible
+	resp = serve("/segment.ts", "gzip")
+	assert.Equal("", resp.Header().Get("Content-Encoding"))
+	assert.Equal("segment", resp.Body.String())
+
+	// Case for content type detected from the body
+	resp = serve("/text", "gzip")
+	assert.Equal("gzip", resp.Header().Get("Content-Encoding"))
+	reader, err = gzip.NewReader(resp.Body)
+	assert.NoError(err)
+	body, err = ioutil.ReadAll(reader)
+	assert.NoError(err)
+	assert.Equal("hello world", string(body))
+}
@@ -0,0 +1,65 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+// loadDeviceConfigCache reads the last known good device config, if one was saved
+func loadDeviceConfigCache() (client.DeviceAgentConfig, error) {
+	var config client.DeviceAgentConfig
+	rawBytes, err := ioutil.ReadFile(PathToDeviceConfigCache)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(rawBytes, &config); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// saveDeviceConfigCache persists a device config so that it can be applied immediately on the next boot
+func saveDeviceConfigCache(config client.DeviceAgentConfig) error {
+	rawBytes, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if common.IsFileUnchanged(PathToDeviceConfigCache, rawBytes) {
+		return nil
+	}
+
+	// ensure cache directory exists
+	if err := os.MkdirAll(filepath.Dir(PathToDeviceConfigCache), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so that a power loss never leaves a partial config behind
+	// NOTE: the config includes an auth token, so keep it readable by the agent user only
+	tmpPath := PathToDeviceConfigCache + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, rawBytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, PathToDeviceConfigCache)
+}
@@ -0,0 +1,60 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceConfigCache(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "jacktrip-agent")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	PathToDeviceConfigCache = fmt.Sprintf("%s/lib/config.json", dir)
+
+	// Missing cache should return an error
+	_, err = loadDeviceConfigCache()
+	assert.NotNil(err)
+
+	// Saved config should load back unchanged
+	config := client.DeviceAgentConfig{AuthToken: "foobar"}
+	config.Period = 128
+	config.Host = "a.b.com"
+	config.Enabled = true
+	assert.Nil(saveDeviceConfigCache(config))
+	result, err := loadDeviceConfigCache()
+	assert.Nil(err)
+	assert.Equal(config, result)
+
+	// Cache should only be readable by its owner
+	info, err := os.Stat(PathToDeviceConfigCache)
+	assert.Nil(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	// Corrupted cache should return an error
+	assert.Nil(ioutil.WriteFile(PathToDeviceConfigCache, []byte("{"), 0600))
+	_, err = loadDeviceConfigCache()
+	assert.NotNil(err)
+}
@@ -0,0 +1,187 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// AlsaDeviceMajor is the major number of ALSA character devices in /dev/snd
+	AlsaDeviceMajor = "116"
+
+	// DoctorContainerCheck is the name of the self-diagnostic check for sound devices in containers
+	DoctorContainerCheck = "container"
+)
+
+var (
+	// ContainerRootDir is the root of the filesystem searched for signs of a container
+	ContainerRootDir = "/"
+
+	// containerRuntime is the name of the container runtime the agent runs in, or empty if it is not containerized
+	containerRuntime = ""
+)
+
+// getContainerRuntime returns the name of the container runtime that the agent runs in, or empty if there is none
+func getContainerRuntime(rootDir, procDir string, getenv func(string) string) string {
+	if getenv("BALENA") == "1" || getenv("BALENA_DEVICE_UUID") != "" {
+		return "balena"
+	}
+	if getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return "kubernetes"
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, ".dockerenv")); err == nil {
+		return "docker"
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "run", ".containerenv")); err == nil {
+		return "podman"
+	}
+	// NOTE: systemd-nspawn, podman and lxc set this variable for the init process
+	if name := getenv("container"); name != "" {
+		return name
+	}
+	rawBytes, err := ioutil.ReadFile(filepath.Join(procDir, "1", "cgroup"))
+	if err != nil {
+		return ""
+	}
+	cgroups := string(rawBytes)
+	switch {
+	case strings.Contains(cgroups, "kubepods"):
+		return "kubernetes"
+	case strings.Contains(cgroups, "docker"):
+		return "docker"
+	case strings.Contains(cgroups, "libpod"):
+		return "podman"
+	case strings.Contains(cgroups, "/lxc/"):
+		return "lxc"
+	}
+	return ""
+}
+
+// hasSystemd returns true if systemd is the init system, and can be used to manage services
+func hasSystemd(rootDir string) bool {
+	_, err := os.Stat(filepath.Join(rootDir, "run", "systemd", "system"))
+	return err == nil
+}
+
+// isSoundDeviceAllowed returns true if the rules of a cgroup v1 devices.list allow reading and writing ALSA devices:
+//
+//	<type> <major>:<minor> <access>
+func isSoundDeviceAllowed(devicesList []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(devicesList))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || (fields[0] != "a" && fields[0] != "c") {
+			continue
+		}
+		numbers := strings.SplitN(fields[1], ":", 2)
+		if numbers[0] != "*" && numbers[0] != AlsaDeviceMajor {
+			continue
+		}
+		if strings.Contains(fields[2], "r") && strings.Contains(fields[2], "w") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSoundDeviceAccess verifies that the ALSA devices were passed into the container, and that the device cgroup
+// allows using them
+func checkSoundDeviceAccess(rootDir string) error {
+	devicesList, err := ioutil.ReadFile(filepath.Join(rootDir, "sys", "fs", "cgroup", "devices", "devices.list"))
+	if err == nil && !isSoundDeviceAllowed(devicesList) {
+		return errors.New("the device cgroup does not allow ALSA devices; run the container with --device /dev/snd")
+	}
+
+	// NOTE: cgroup v2 controls devices with eBPF, so the only way to check access is to open a device
+	controls, _ := filepath.Glob(filepath.Join(rootDir, "dev", "snd", "controlC*"))
+	if len(controls) == 0 {
+		return errors.New("no ALSA devices found in /dev/snd; run the container with --device /dev/snd")
+	}
+	sort.Strings(controls)
+	f, err := os.OpenFile(controls[0], os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open ALSA devices: %w", err)
+	}
+	return f.Close()
+}
+
+// checkContainer verifies that sound devices can be used, when running in a container
+func checkContainer() client.DoctorCheck {
+	if containerRuntime == "" {
+		return newDoctorCheck(DoctorContainerCheck, nil, "not running in a container")
+	}
+	return newDoctorCheck(DoctorContainerCheck, checkSoundDeviceAccess(ContainerRootDir),
+		fmt.Sprintf("ALSA devices are available in %s container", containerRuntime))
+}
+
+// formatCardsFromDeviceList formats the cards listed by `aplay -l` like /proc/asound/cards
+func formatCardsFromDeviceList(devices string) string {
+	var cards strings.Builder
+	seen := map[int]bool{}
+	for _, line := range strings.Split(devices, "\n") {
+		num, id, ok := parseDeviceListLine(line)
+		if !ok || seen[num] {
+			continue
+		}
+		seen[num] = true
+		fmt.Fprintf(&cards, "%2d [%-15s]: %s\n", num, id, id)
+	}
+	return cards.String()
+}
+
+// containerAlsa is used in containers, where /proc/asound may be missing or describe the host's cards
+type containerAlsa struct {
+	systemAlsa
+}
+
+// Cards returns the list of sound cards, falling back to the devices listed by `aplay -l`
+func (a containerAlsa) Cards() (string, error) {
+	if out, err := a.systemAlsa.Cards(); err == nil {
+		return out, nil
+	}
+	devices, err := a.PlaybackDevices()
+	if err != nil {
+		return "", err
+	}
+	return formatCardsFromDeviceList(devices), nil
+}
+
+// enableContainerMode adapts the agent to a container, running services as child processes when there is no systemd
+func enableContainerMode(runtime string) {
+	log.Info("Running in a container", "runtime", runtime)
+	containerRuntime = runtime
+	alsaProvider = containerAlsa{}
+	if !hasSystemd(ContainerRootDir) {
+		log.Info("Running managed services as child processes, since systemd is not available")
+		serviceManager = NewProcessServiceManager(childServices)
+	}
+	if err := checkSoundDeviceAccess(ContainerRootDir); err != nil {
+		log.Error(err, "Sound devices are not available in the container")
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetContainerRuntime(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "container")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	procDir := filepath.Join(dir, "proc")
+	assert.NoError(os.MkdirAll(filepath.Join(procDir, "1"), 0755))
+	env := map[string]string{}
+	getenv := func(key string) string { return env[key] }
+
+	// Case for a host without containers
+	assert.NoError(ioutil.WriteFile(filepath.Join(procDir, "1", "cgroup"), []byte("0::/init.scope\n"), 0644))
+	assert.Equal("", getContainerRuntime(dir, procDir, getenv))
+
+	// Case for cgroups of a container
+	assert.NoError(ioutil.WriteFile(filepath.Join(procDir, "1", "cgroup"), []byte("12:devices:/docker/0123456789ab\n"), 0644))
+	assert.Equal("docker", getContainerRuntime(dir, procDir, getenv))
+
+	// Case for files created by container runtimes
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "run"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "run", ".containerenv"), nil, 0644))
+	assert.Equal("podman", getContainerRuntime(dir, procDir, getenv))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, ".dockerenv"), nil, 0644))
+	assert.Equal("docker", getContainerRuntime(dir, procDir, getenv))
+
+	// Case for balena
+	env["BALENA_DEVICE_UUID"] = "abc123"
+	assert.Equal("balena", getContainerRuntime(dir, procDir, getenv))
+}
+
+func TestHasSystemd(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "container")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	assert.False(hasSystemd(dir))
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "run", "systemd", "system"), 0755))
+	assert.True(hasSystemd(dir))
+}
+
+func TestIsSoundDeviceAllowed(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(isSoundDeviceAllowed([]byte("a *:* rwm\n")))
+	assert.True(isSoundDeviceAllowed([]byte("c 1:3 rwm\nc 116:* rwm\n")))
+	assert.False(isSoundDeviceAllowed([]byte("c 1:3 rwm\nc 116:* r\n")))
+	assert.False(isSoundDeviceAllowed([]byte("b 116:* rwm\n")))
+	assert.False(isSoundDeviceAllowed(nil))
+}
+
+func TestCheckSoundDeviceAccess(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "container")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// Case for a container without sound devices
+	assert.Error(checkSoundDeviceAccess(dir))
+
+	// Case for sound devices that can be opened
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "dev", "snd"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "dev", "snd", "controlC0"), nil, 0644))
+	assert.NoError(checkSoundDeviceAccess(dir))
+
+	// Case for a device cgroup that denies sound devices
+	cgroupDir := filepath.Join(dir, "sys", "fs", "cgroup", "devices")
+	assert.NoError(os.MkdirAll(cgroupDir, 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(cgroupDir, "devices.list"), []byte("c 1:3 rwm\n"), 0644))
+	assert.Error(checkSoundDeviceAccess(dir))
+	assert.NoError(ioutil.WriteFile(filepath.Join(cgroupDir, "devices.list"), []byte("c 116:* rwm\n"), 0644))
+	assert.NoError(checkSoundDeviceAccess(dir))
+}
+
+func TestFormatCardsFromDeviceList(t *testing.T) {
+	assert := assert.New(t)
+	devices := "**** List of PLAYBACK Hardware Devices ****\n" +
+		"card 1: Device [USB Audio Device], device 0: USB Audio [USB Audio]\n" +
+		"card 1: Device [USB Audio Device], device 1: USB Audio [USB Audio #1]\n" +
+		"card 2: Headphones [bcm2835 Headphones], device 0: bcm2835 Headphones [bcm2835 Headphones]\n"
+	cards := formatCardsFromDeviceList(devices)
+	assert.Equal(" 1 [Device         ]: Device\n 2 [Headphones     ]: Headphones\n", cards)
+	assert.Equal(map[string]int{"Device": 1, "Headphones": 2}, extractCardNum(cards))
+	assert.Equal("", formatCardsFromDeviceList("aplay: device_list:274: no soundcards found...\n"))
+}
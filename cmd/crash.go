@@ -0,0 +1,62 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+// CrashReportTimeout is the maximum duration spent uploading a crash report before exiting
+const CrashReportTimeout = 5 * time.Second
+
+// reportCrash uploads a crash report if the calling goroutine panics, then continues panicking
+// NOTE: this must be called using defer
+// NOTE: the heartbeat is read after the panic, since the MAC and version may not be known when the handler starts
+func reportCrash(apiClient *api.Client, heartbeat func() client.DeviceHeartbeat) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	beat := heartbeat()
+	report := client.CrashReport{
+		Version:   beat.Version,
+		Message:   fmt.Sprint(r),
+		Stack:     string(debug.Stack()),
+		Timestamp: time.Now(),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), CrashReportTimeout)
+	defer cancel()
+	if err := apiClient.UploadCrashReport(ctx, beat.MAC, report); err != nil {
+		log.Error(err, "Failed to upload crash report")
+	}
+	panic(r)
+}
+
+// ackDeviceConfig notifies the API whether a device config was applied
+func ackDeviceConfig(ctx context.Context, apiClient *api.Client, id string, config client.DeviceAgentConfig, configErr error) {
+	ack := client.ConfigAck{ConfigVersion: config.ConfigVersion, Applied: configErr == nil}
+	if configErr != nil {
+		ack.Error = configErr.Error()
+	}
+	if err := apiClient.AckConfig(ctx, id, ack); err != nil {
+		log.V(1).Info("Failed to acknowledge device config", "error", err.Error())
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportCrash(t *testing.T) {
+	assert := assert.New(t)
+	var report client.CrashReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/agents/abc/crash", r.URL.Path)
+		json.NewDecoder(r.Body).Decode(&report)
+	}))
+	defer server.Close()
+	apiClient := api.NewClient(server.URL, client.AgentCredentials{}, nil)
+	var beat client.DeviceHeartbeat
+	heartbeat := func() client.DeviceHeartbeat { return beat }
+
+	// the heartbeat is read when the panic is reported, not when the handler starts
+	assert.PanicsWithValue("boom", func() {
+		defer reportCrash(apiClient, heartbeat)
+		beat.MAC, beat.Version = "abc", "1.2.3"
+		panic("boom")
+	})
+	assert.Equal("boom", report.Message)
+	assert.Equal("1.2.3", report.Version)
+	assert.Contains(report.Stack, "TestReportCrash")
+
+	// Case for no panic
+	assert.NotPanics(func() {
+		defer reportCrash(apiClient, heartbeat)
+	})
+}
+
+func TestAckDeviceConfig(t *testing.T) {
+	assert := assert.New(t)
+	var acks []client.ConfigAck
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ack client.ConfigAck
+		json.NewDecoder(r.Body).Decode(&ack)
+		acks = append(acks, ack)
+	}))
+	defer server.Close()
+	apiClient := api.NewClient(server.URL, client.AgentCredentials{}, nil)
+
+	config := client.DeviceAgentConfig{}
+	config.ConfigVersion = 1
+	ackDeviceConfig(context.Background(), apiClient, "abc", config, nil)
+	ackDeviceConfig(context.Background(), apiClient, "abc", config, errors.New("invalid config"))
+	assert.Equal([]client.ConfigAck{
+		{ConfigVersion: 1, Applied: true},
+		{ConfigVersion: 1, Applied: false, Error: "invalid config"},
+	}, acks)
+}
@@ -16,9 +16,12 @@ package main
 
 import (
 	"bytes"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"os"
 	"time"
 
@@ -28,6 +31,12 @@ import (
 const (
 	// SecretBytes are used to generate random secret strings
 	SecretBytes = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// CredentialsMetadataURL is the instance metadata URL used to retrieve credentials on cloud servers
+	CredentialsMetadataURL = "http://metadata.google.internal/computeMetadata/v1/instance/attributes/jacktrip-credentials"
+
+	// CredentialsMetadataTimeout is the maximum time to wait for instance metadata
+	CredentialsMetadataTimeout = 2 * time.Second
 )
 
 func init() {
@@ -35,29 +44,77 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-// getCredentials retrieves jacktrip agent credentials from system config file.
-// If config does not exist, it will generate and save new credentials to config file.
+// getCredentials retrieves jacktrip agent credentials from the environment, system config file or instance metadata.
 func getCredentials() client.AgentCredentials {
-	var rawBytes []byte
-	var err error
+	rawBytes, err := readCredentials(CredentialsMetadataURL)
+	if err != nil {
+		log.Error(err, "Failed to read credentials")
+		panic(err)
+	}
+
+	credentials, err := parseCredentials(rawBytes)
+	if err != nil {
+		log.Error(err, "Failed to parse credentials")
+		panic(err)
+	}
+	return credentials
+}
 
-	rawBytes = []byte(os.Getenv("JACKTRIP_API_SECRET"))
-	if len(rawBytes) == 0 {
-		rawBytes, err = ioutil.ReadFile(fmt.Sprintf("%s/credentials", AgentConfigDir))
-		if err != nil {
-			log.Error(err, "Failed to read credentials")
-			panic(err)
-		}
+// readCredentials reads raw credentials from the first available source
+func readCredentials(metadataURL string) ([]byte, error) {
+	rawBytes := []byte(os.Getenv("JACKTRIP_API_SECRET"))
+	if len(rawBytes) > 0 {
+		return rawBytes, nil
 	}
 
+	rawBytes, fileErr := ioutil.ReadFile(fmt.Sprintf("%s/credentials", AgentConfigDir))
+	if fileErr == nil {
+		return rawBytes, nil
+	}
+
+	rawBytes, err := readMetadataCredentials(metadataURL)
+	if err != nil {
+		return nil, fmt.Errorf("%s; instance metadata: %w", fileErr.Error(), err)
+	}
+	return rawBytes, nil
+}
+
+// readMetadataCredentials reads raw credentials from cloud instance metadata
+func readMetadataCredentials(metadataURL string) ([]byte, error) {
+	httpClient := &http.Client{Timeout: CredentialsMetadataTimeout}
+	req, _ := http.NewRequest("GET", metadataURL, nil)
+	req.Header.Set("Metadata-Flavor", "Google")
+	r, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad response from instance metadata: Status=%d", r.StatusCode)
+	}
+	return ioutil.ReadAll(r.Body)
+}
+
+// parseCredentials parses credentials formatted as "<APIPrefix>.<APISecret>"
+func parseCredentials(rawBytes []byte) (client.AgentCredentials, error) {
 	splits := bytes.Split(bytes.TrimSpace(rawBytes), []byte("."))
 	if len(splits) != 2 || len(splits[0]) < 1 || len(splits[1]) < 1 {
-		log.Error(err, "Failed to parse credentials")
-		panic(err)
+		return client.AgentCredentials{}, errors.New("credentials must be formatted as <prefix>.<secret>")
 	}
 
 	return client.AgentCredentials{
 		APIPrefix: string(splits[0]),
 		APISecret: string(splits[1]),
+	}, nil
+}
+
+// isAuthorizedAdminRequest checks that a request was signed with the agent's API credentials
+func isAuthorizedAdminRequest(credentials client.AgentCredentials, r *http.Request) bool {
+	if credentials.APIPrefix == "" || credentials.APISecret == "" {
+		return false
 	}
+	prefixOK := subtle.ConstantTimeCompare([]byte(r.Header.Get("APIPrefix")), []byte(credentials.APIPrefix)) == 1
+	secretOK := subtle.ConstantTimeCompare([]byte(r.Header.Get("APISecret")), []byte(credentials.APISecret)) == 1
+	return prefixOK && secretOK
 }
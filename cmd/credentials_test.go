@@ -0,0 +1,90 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCredentials(t *testing.T) {
+	assert := assert.New(t)
+
+	result, err := parseCredentials([]byte(" black.pink\n"))
+	assert.Nil(err)
+	assert.Equal("black", result.APIPrefix)
+	assert.Equal("pink", result.APISecret)
+
+	for _, raw := range []string{"", "blackpink", ".pink", "black.", "black.pink.jennie"} {
+		_, err = parseCredentials([]byte(raw))
+		assert.NotNil(err, raw)
+	}
+}
+
+func TestReadCredentials(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("meta.data"))
+	}))
+	defer ts.Close()
+
+	// Case for credentials from the environment
+	os.Setenv("JACKTRIP_API_SECRET", "env.secret")
+	defer os.Unsetenv("JACKTRIP_API_SECRET")
+	result, err := readCredentials(ts.URL)
+	assert.Nil(err)
+	assert.Equal("env.secret", string(result))
+
+	// Case for credentials from instance metadata, assuming no credentials file exists
+	os.Unsetenv("JACKTRIP_API_SECRET")
+	result, err = readCredentials(ts.URL)
+	assert.Nil(err)
+	assert.Equal("meta.data", string(result))
+
+	// Case for missing instance metadata
+	_, err = readCredentials(ts.URL + "/missing")
+	assert.NotNil(err)
+}
+
+func TestIsAuthorizedAdminRequest(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+
+	req := httptest.NewRequest("POST", "http://example.com/admin", nil)
+	assert.False(isAuthorizedAdminRequest(credentials, req))
+
+	req.Header.Set("APIPrefix", "prefix")
+	req.Header.Set("APISecret", "wrong")
+	assert.False(isAuthorizedAdminRequest(credentials, req))
+
+	req.Header.Set("APISecret", "secret")
+	assert.True(isAuthorizedAdminRequest(credentials, req))
+
+	// Empty credentials never authorize anything
+	assert.False(isAuthorizedAdminRequest(client.AgentCredentials{}, httptest.NewRequest("POST", "http://example.com/admin", nil)))
+}
@@ -0,0 +1,173 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// LogNamespaceDropInName is the name of the systemd drop-in that moves a service's logs into AgentLogNamespace
+	LogNamespaceDropInName = "jacktrip-logs.conf"
+
+	// SystemdDropInDir is the directory for runtime systemd unit drop-ins, which are cleared on reboot
+	SystemdDropInDir = "/run/systemd/system"
+)
+
+// exportServiceNames are the systemd services whose logs are included in data exports
+var exportServiceNames = []string{"jacktrip-agent.service", JackServiceName, JackTripServiceName, JamulusServiceName}
+
+// getAgentDataFiles returns the files owned by the agent that exist, which are exported and then purged
+func getAgentDataFiles() []string {
+	return getExistingFiles(PathToDeviceConfigCache, PathToPairedApps, PathToSessionTimeline, PathToTelemetryOutbox)
+}
+
+// getServiceConfigFiles returns the config files of managed services that exist; they are exported for
+// troubleshooting, but never purged since running services depend upon them
+func getServiceConfigFiles() []string {
+	paths := []string{
+		PathToJackConfig,
+		PathToJackTripConfig,
+		PathToJamulusConfig,
+		PathToMetronomeConfig,
+		PathToEffectsConfig,
+		PathToAES67Config,
+		PathToChronyConfig,
+		PathToPTPConfig,
+	}
+	for _, pattern := range []string{PathToAlsaState, PathToZitaConfig} {
+		matches, _ := filepath.Glob(strings.Replace(pattern, "%s", "*", 1))
+		paths = append(paths, matches...)
+	}
+	return getExistingFiles(paths...)
+}
+
+// getLocalRecordingFiles returns the directory of local recordings on the USB drive and its recordings, if
+// one is usable
+func getLocalRecordingFiles(storage *StorageManager) (string, []string) {
+	mount, err := storage.MountPoint()
+	if err != nil {
+		return "", nil
+	}
+	dir := filepath.Join(mount, LocalRecordingDir)
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	return dir, getExistingFiles(matches...)
+}
+
+// getLogNamespaceDropIn returns a systemd drop-in that moves a service's logs into AgentLogNamespace
+func getLogNamespaceDropIn() string {
+	return fmt.Sprintf("[Service]\nLogNamespace=%s\n", common.AgentLogNamespace)
+}
+
+// writeLogNamespaceDropIns moves the logs of exported services into AgentLogNamespace, so that purging them never
+// touches the rest of the system journal
+// NOTE: each service uses the namespace after it is next restarted, which is on the next config for managed services
+func writeLogNamespaceDropIns() error {
+	changed := false
+	for _, name := range exportServiceNames {
+		dir := filepath.Join(SystemdDropInDir, name+".d")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		written, err := common.WriteFileIfChanged(filepath.Join(dir, LogNamespaceDropInName), []byte(getLogNamespaceDropIn()), 0644)
+		if err != nil {
+			return err
+		}
+		changed = changed || written
+	}
+	if !changed {
+		return nil
+	}
+	_, err := runPrivileged(SystemctlPath, "daemon-reload")
+	return err
+}
+
+// getExportLogs returns the journal entries of all services included in data exports
+func getExportLogs() ([]byte, error) {
+	args := []string{"--no-pager", "--output", "short-iso", "--namespace", "+" + common.AgentLogNamespace}
+	for _, name := range exportServiceNames {
+		args = append(args, "--unit", name)
+	}
+	return systemRunner.Output(common.JournalctlPath, args...)
+}
+
+// sanitizeDeviceConfig returns a copy of a device config with its secrets masked
+func sanitizeDeviceConfig(config client.DeviceAgentConfig) client.DeviceAgentConfig {
+	config.AuthToken = strings.Repeat("X", len(config.AuthToken))
+	config.MQTTPassword = strings.Repeat("X", len(config.MQTTPassword))
+	return config
+}
+
+// purgeLocalData unpairs all apps, deletes agent data and local recordings, and removes the journal entries of
+// the agent's log namespace
+func (a *DeviceAgent) purgeLocalData(files []string) error {
+	if err := a.Pairing.Revoke(); err != nil {
+		return err
+	}
+	// revoking rewrites the list of paired apps, so always remove it
+	for _, path := range append(files, PathToPairedApps) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	a.Timeline.Clear()
+	if _, err := runPrivileged(common.JournalctlPath, "--namespace", common.AgentLogNamespace, "--rotate"); err != nil {
+		return err
+	}
+	_, err := runPrivileged(common.JournalctlPath, "--namespace", common.AgentLogNamespace, "--vacuum-time=1s")
+	return err
+}
+
+// collectDataExport finishes the local recording, so that its file is complete, and returns the data
+// exported from a device; the recording is started again by the next check
+func (a *DeviceAgent) collectDataExport() (DataExport, error) {
+	a.LocalRecorder.Stop()
+
+	agentFiles := getAgentDataFiles()
+	dir, recordings := getLocalRecordingFiles(a.Storage)
+	logs, err := getExportLogs()
+	if err != nil {
+		log.Error(err, "Failed to read service logs for data export")
+	}
+	return DataExport{
+		Files:         append(agentFiles, getServiceConfigFiles()...),
+		RecordingsDir: dir,
+		Recordings:    recordings,
+		Logs:          logs,
+		Masked: map[string]func() (interface{}, error){
+			PathToDeviceConfigCache: func() (interface{}, error) {
+				config, err := loadDeviceConfigCache()
+				return sanitizeDeviceConfig(config), err
+			},
+		},
+		Owned: append(agentFiles, recordings...),
+	}, nil
+}
+
+// runDataExport exports all locally stored data to an upload target, and purges data owned by the agent once the
+// upload succeeds
+func (a *DeviceAgent) runDataExport(ctx context.Context, uploadURL string) client.DataExportReport {
+	return runExport(ctx, uploadURL, a.collectDataExport, a.purgeLocalData)
+}
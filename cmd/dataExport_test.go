@@ -0,0 +1,119 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunDataExport(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prev SystemRunner, libDir, configDir string) {
+		systemRunner, AgentLibDir, ServiceConfigDir = prev, libDir, configDir
+		updatePaths()
+	}(systemRunner, AgentLibDir, ServiceConfigDir)
+	runner := NewFakeRunner()
+	runner.Outputs["/usr/bin/journalctl --no-pager --output short-iso --namespace +jacktrip --unit jacktrip-agent.service --unit jack.service --unit jacktrip.service --unit jamulus.service"] = "agent started\n"
+	systemRunner = runner
+	AgentLibDir, ServiceConfigDir = t.TempDir(), t.TempDir()
+	updatePaths()
+	mount := t.TempDir()
+	agent := NewDeviceAgent(false)
+	agent.Storage = &StorageManager{status: &client.StorageStatus{Device: "/dev/sda1", MountPoint: mount, Healthy: true}}
+	os.MkdirAll(filepath.Join(mount, LocalRecordingDir), 0755)
+	recording := filepath.Join(mount, LocalRecordingDir, "20220101T000000Z.flac")
+	ioutil.WriteFile(recording, []byte("fLaC"), 0644)
+
+	config := client.DeviceAgentConfig{}
+	config.AuthToken = "secret-token"
+	config.MQTTPassword = "secret-password"
+	assert.Nil(saveDeviceConfigCache(config))
+	ioutil.WriteFile(PathToJackConfig, []byte("JACK_OPTS="), 0644)
+	ioutil.WriteFile(filepath.Join(ServiceConfigDir, "asound-USB.state"), []byte("state"), 0644)
+
+	// Case for missing upload target
+	report := agent.runDataExport(context.Background(), "")
+	assert.Equal("missing upload URL", report.Error)
+	assert.False(report.Uploaded)
+
+	// Case for an export that is already running
+	exportRunning = 1
+	report = agent.runDataExport(context.Background(), "http://localhost")
+	assert.Equal(errExportRunning.Error(), report.Error)
+	exportRunning = 0
+
+	// Case for failed upload, which must not purge anything
+	var uploaded []byte
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploaded, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	report = agent.runDataExport(context.Background(), server.URL)
+	assert.Equal("upload failed: status=500", report.Error)
+	assert.False(report.Purged)
+	_, err := os.Stat(PathToDeviceConfigCache)
+	assert.Nil(err)
+
+	status = http.StatusOK
+	report = agent.runDataExport(context.Background(), server.URL)
+	assert.Equal("", report.Error)
+	assert.True(report.Uploaded)
+	assert.True(report.Purged)
+	assert.Equal([]string{"configs/config.json", "configs/jack", "configs/asound-USB.state", "recordings/20220101T000000Z.flac", ExportLogsName}, report.Files)
+	assert.Equal(len(uploaded), report.Size)
+	assert.False(report.Timestamp.IsZero())
+
+	// secrets in the cached config are masked
+	zr, err := zip.NewReader(bytes.NewReader(uploaded), int64(len(uploaded)))
+	assert.Nil(err)
+	assert.Len(zr.File, 5)
+	f, err := zr.File[0].Open()
+	assert.Nil(err)
+	exported, _ := ioutil.ReadAll(f)
+	assert.NotContains(string(exported), "secret")
+	assert.Contains(string(exported), `"authToken":"XXXXXXXXXXXX"`)
+
+	// agent data, recordings and the agent's journal namespace are purged, while service configs are kept
+	_, err = os.Stat(PathToDeviceConfigCache)
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(PathToPairedApps)
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(recording)
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(PathToJackConfig)
+	assert.Nil(err)
+	assert.Contains(runner.Commands, "/usr/bin/journalctl --namespace jacktrip --vacuum-time=1s")
+	assert.NotContains(runner.Commands, "/usr/bin/journalctl --vacuum-time=1s")
+}
+
+func TestGetLogNamespaceDropIn(t *testing.T) {
+	assert.Equal(t, "[Service]\nLogNamespace=jacktrip\n", getLogNamespaceDropIn())
+}
@@ -0,0 +1,139 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const (
+	// DesktopSoundDeviceName is the name of the sound device used on desktops, where JACK chooses the audio interface
+	DesktopSoundDeviceName = "desktop"
+
+	// DesktopListenAddress is used by the HTTP server on desktops, so that it can run without root
+	DesktopListenAddress = ":8080"
+)
+
+// getDesktopJackDriver returns the JACK driver for the audio system of an operating system
+func getDesktopJackDriver(goos string) string {
+	switch goos {
+	case "darwin":
+		return "coreaudio"
+	case "windows":
+		// NOTE: the portaudio driver includes ASIO devices
+		return "portaudio"
+	}
+	return "alsa"
+}
+
+// findDesktopBinary finds an executable bundled next to the agent, or else installed on the PATH
+func findDesktopBinary(file string) (string, error) {
+	if exe, err := os.Executable(); err == nil {
+		bundled := filepath.Join(filepath.Dir(exe), file)
+		if runtime.GOOS == "windows" {
+			bundled += ".exe"
+		}
+		if info, err := os.Stat(bundled); err == nil && !info.IsDir() {
+			return bundled, nil
+		}
+	}
+	return exec.LookPath(file)
+}
+
+// setDesktopPaths keeps agent files in the user's config directory, unless other directories were configured
+func setDesktopPaths(userConfigDir string) {
+	if AgentLibDir == DefaultAgentLibDir {
+		AgentLibDir = filepath.Join(userConfigDir, "jacktrip")
+	}
+	if ServiceConfigDir == DefaultServiceConfigDir {
+		ServiceConfigDir = filepath.Join(AgentLibDir, "services")
+	}
+	if AvahiServicesDir == DefaultAvahiServicesDir {
+		AvahiServicesDir = filepath.Join(AgentLibDir, "avahi")
+	}
+	updatePaths()
+}
+
+// getDesktopIdentity returns the MAC address of the first network interface with one, sorted by name,
+// since desktops may not have /sys/class/net or /etc/machine-id
+func getDesktopIdentity() (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(ifaces, func(i, j int) bool { return ifaces[i].Name < ifaces[j].Name })
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) != 6 {
+			continue
+		}
+		return strings.ToLower(iface.HardwareAddr.String()), nil
+	}
+	return "", errors.New("no network interfaces with a MAC address found")
+}
+
+// enableDesktopMode runs managed services as child processes, and skips ALSA and systemd
+func enableDesktopMode() {
+	log.Info("Running as a desktop device", "os", runtime.GOOS)
+	if dir, err := os.UserConfigDir(); err == nil {
+		setDesktopPaths(dir)
+	} else {
+		log.Error(err, "Unable to find user config directory")
+	}
+	services := NewProcessServiceManager(childServices)
+	services.LookPath = findDesktopBinary
+	serviceManager = services
+	alsaProvider = desktopAlsa{}
+}
+
+// desktopAlsa is used on desktops, where JACK accesses the audio interface and there are no ALSA cards to manage
+type desktopAlsa struct{}
+
+// CaptureDevices returns no devices
+func (desktopAlsa) CaptureDevices() (string, error) { return "", nil }
+
+// PlaybackDevices returns no devices
+func (desktopAlsa) PlaybackDevices() (string, error) { return "", nil }
+
+// Cards returns no cards
+func (desktopAlsa) Cards() (string, error) { return "", nil }
+
+// Stream0 returns an error, since there are no USB sound cards to inspect
+func (desktopAlsa) Stream0(card int) (string, error) {
+	return "", errors.New("ALSA is not available on desktops")
+}
+
+// Controls returns no controls
+func (desktopAlsa) Controls(card int) (string, error) { return "", nil }
+
+// SetControl returns an error, since volumes are controlled by the desktop's audio system
+func (desktopAlsa) SetControl(card int, control, value string) error {
+	return errors.New("ALSA is not available on desktops")
+}
+
+// StoreState does nothing
+func (desktopAlsa) StoreState(device, file string) error { return nil }
+
+// RestoreState does nothing
+func (desktopAlsa) RestoreState(device, file string) error { return nil }
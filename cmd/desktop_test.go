@@ -0,0 +1,54 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDesktopJackDriver(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("coreaudio", getDesktopJackDriver("darwin"))
+	assert.Equal("portaudio", getDesktopJackDriver("windows"))
+	assert.Equal("alsa", getDesktopJackDriver("linux"))
+}
+
+func TestSetDesktopPaths(t *testing.T) {
+	assert := assert.New(t)
+	defer func(lib, services, avahi string) {
+		AgentLibDir, ServiceConfigDir, AvahiServicesDir = lib, services, avahi
+		updatePaths()
+	}(AgentLibDir, ServiceConfigDir, AvahiServicesDir)
+
+	// Case for default paths
+	AgentLibDir, ServiceConfigDir, AvahiServicesDir = DefaultAgentLibDir, DefaultServiceConfigDir, DefaultAvahiServicesDir
+	setDesktopPaths("/home/user/.config")
+	assert.Equal(filepath.Join("/home/user/.config", "jacktrip"), AgentLibDir)
+	assert.Equal(filepath.Join(AgentLibDir, "services"), ServiceConfigDir)
+	assert.Equal(filepath.Join(AgentLibDir, "avahi"), AvahiServicesDir)
+	assert.Equal(filepath.Join(ServiceConfigDir, "jack"), PathToJackConfig)
+
+	// Case for configured paths
+	AgentLibDir, ServiceConfigDir = "/opt/jacktrip", "/opt/jacktrip/default"
+	setDesktopPaths("/home/user/.config")
+	assert.Equal("/opt/jacktrip", AgentLibDir)
+	assert.Equal("/opt/jacktrip/default", ServiceConfigDir)
+}
@@ -12,6 +12,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !server
+// +build !server
+
 package main
 
 import (
@@ -20,26 +23,27 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
-	"os/signal"
 	"regexp"
 	"strings"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 
 	"github.com/jacktrip/jacktrip-agent/pkg/client"
 	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/jacktrip/jacktrip-agent/pkg/wsclient"
 )
 
 const (
-	// DeviceHeartbeatPath is a WSS API route used for bi-directional updates for a given device
+	// HeartbeatInterval is an interval between heartbeats
+	HeartbeatInterval = 5
+
+	// DeviceHeartbeatPath is a WSS API route used to send telemetry for a given device
 	DeviceHeartbeatPath = "/devices/%s/heartbeat"
 
-	// PathToAvahiServiceFile is the path to the avahi service file for jacktrip-agent
-	PathToAvahiServiceFile = "/tmp/avahi/services/jacktrip-agent.service"
+	// DeviceControlPath is a WSS API route used to receive configs and commands for a given device
+	DeviceControlPath = "/devices/%s/control"
 
 	// JackDeviceConfigTemplate is the template used to generate /tmp/default/jack file on raspberry pi devices
 	JackDeviceConfigTemplate = "JACK_OPTS=-d %s --rate %d --period %d\n"
@@ -53,9 +57,6 @@ const (
 	// DevicesRedirectURL is a template used to construct UI redirect URL for this device
 	DevicesRedirectURL = "https://app.jacktrip.org/devices/%s?apiPrefix=%s&apiHash=%s"
 
-	// PathToMACAddress is the path to ethernet device MAC address, via Linux kernel
-	PathToMACAddress = "/sys/class/net/eth0/address"
-
 	// PathToAsoundCards is the path to the ALSA card list
 	PathToAsoundCards = "/proc/asound/cards"
 
@@ -63,86 +64,216 @@ const (
 	ALSAInputSourceToken = `Mic|ADC`
 )
 
-var ac *AutoConnector
-var soundDeviceName = ""
-var soundDeviceType = ""
-var lastDeviceStatus = "starting"
-var currentDeviceConfig client.DeviceAgentConfig
+// DeviceAgent manages the audio services of a device, and the state shared by its handlers
+type DeviceAgent struct {
+	// DesktopMode is true when the agent runs on a workstation, using the local JACK and jacktrip instead of systemd and ALSA
+	DesktopMode bool
+
+	AutoConnector *AutoConnector
+	Standby       *StandbyManager
+	Outage        *OutageMonitor
+	Outbox        *TelemetryOutbox
+	Latency       *LatencyMonitor
+	Storage       *StorageManager
+	LocalRecorder *LocalRecorder
+	Pairing       *PairingManager
+	Timeline      *SessionTimeline
+}
+
+// NewDeviceAgent constructs a new instance of DeviceAgent
+func NewDeviceAgent(desktopMode bool) *DeviceAgent {
+	timeline := NewSessionTimeline(SessionTimelineSize)
+	storage := NewStorageManager()
+	return &DeviceAgent{
+		DesktopMode:   desktopMode,
+		AutoConnector: NewAutoConnector(),
+		Standby:       &StandbyManager{Timeline: timeline},
+		Outage:        &OutageMonitor{Grace: OutageGracePeriod},
+		Outbox:        NewTelemetryOutbox(TelemetryOutboxSize),
+		Latency:       &LatencyMonitor{},
+		Storage:       storage,
+		LocalRecorder: NewLocalRecorder(storage.MountPoint),
+		Pairing:       &PairingManager{},
+		Timeline:      timeline,
+	}
+}
 
 // runOnDevice is used to run jacktrip-agent on a raspberry pi device
-func runOnDevice(apiOrigin string) {
+func runOnDevice(apiOrigin string, simulate, desktop bool) {
 	log.Info("Running jacktrip-agent in device mode")
+	agent := NewDeviceAgent(desktop)
 
-	exit := make(chan os.Signal, 1)
-	signal.Notify(exit, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+	exit, stop := newSignalContext()
+	defer stop()
 
 	// get sound device name and type
-	soundDeviceName = getSoundDeviceName()
-	soundDeviceType = getSoundDeviceType()
-	log.Info("Detected sound device", "name", soundDeviceName, "type", soundDeviceType)
+	var soundDevice SoundDevice
+	if simulate {
+		soundDevice = SoundDevice{Name: SimulatedSoundDeviceName, Type: SimulatedSoundDeviceType}
+	} else if agent.DesktopMode {
+		soundDevice = SoundDevice{Name: DesktopSoundDeviceName, Type: DesktopSoundDeviceName}
+	} else {
+		soundDevice = SoundDevice{Name: getSoundDeviceName(), Type: getSoundDeviceType()}
+	}
+	deviceState.SetSoundDevice(soundDevice)
+	log.Info("Detected sound device", "name", soundDevice.Name, "type", soundDevice.Type)
+
+	// keep service configs and avahi files in memory, since they are rewritten whenever configs change;
+	// desktops and containers can't mount filesystems, so they only create the directories
+	if agent.DesktopMode || containerRuntime != "" {
+		for _, dir := range []string{AgentLibDir, ServiceConfigDir, AvahiServicesDir} {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				log.Error(err, "Unable to create directory", "path", dir)
+			}
+		}
+	} else {
+		mountTmpfs(ServiceConfigDir, AvahiServicesDir)
+		// keep the logs of the agent and its services apart, so that data exports can purge them
+		if !simulate {
+			if err := writeLogNamespaceDropIns(); err != nil {
+				log.Error(err, "Unable to move service logs into the agent log namespace", "namespace", common.AgentLogNamespace)
+			}
+		}
+	}
 
 	// restore alsa card state, if saved state exists
-	alsaStateFile := fmt.Sprintf("%s/asound.%s.state", AgentLibDir, soundDeviceType)
+	alsaStateFile := getAlsaStateFile(soundDevice.Type)
 	if _, err := os.Stat(alsaStateFile); err == nil {
 		log.Info("Restoring ALSA state", "file", alsaStateFile)
-		cmd := exec.Command("/usr/sbin/alsactl", "restore", "--file", alsaStateFile)
-		if err := cmd.Run(); err != nil {
+		if err := alsaProvider.RestoreState("", alsaStateFile); err != nil {
 			log.Error(err, "Unable to restore ALSA state", "file", alsaStateFile)
 		}
 	}
 
+	// use the control network for the agent's own connections, when one is configured
+	controlAddress := ""
+	if ControlInterface != "" {
+		var err error
+		if controlAddress, err = bindControlSockets(ControlInterface); err != nil {
+			log.Error(err, "Unable to bind to control network interface", "interface", ControlInterface)
+		}
+	}
+
 	// get mac and credentials
-	mac := getMACAddress()
+	mac, identitySource := SimulatedMACAddress, client.IdentityInterface
+	if !simulate {
+		mac, identitySource = getMACAddress(agent.DesktopMode)
+	}
 	credentials := getCredentials()
+	deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) {
+		*beat = client.DeviceHeartbeat{
+			MAC:            mac,
+			IdentitySource: identitySource,
+			Version:        getPatchVersion(),
+			Type:           soundDevice.Type,
+			PingStats: client.PingStats{
+				StatsUpdatedAt: time.Now(),
+			},
+		}
+	})
+
+	// load companion apps that were previously paired
+	if err := agent.Pairing.Load(); err != nil {
+		log.Error(err, "Unable to load paired apps", "path", PathToPairedApps)
+	}
+
+	// load the timeline of earlier sessions, so that it survives restarts
+	if err := agent.Timeline.Load(); err != nil {
+		log.Error(err, "Unable to load session timeline", "path", PathToSessionTimeline)
+	}
+
+	// load heartbeats that were queued while offline, so that they are still delivered after a restart
+	if err := agent.Outbox.Load(); err != nil {
+		log.Error(err, "Unable to load telemetry outbox", "path", PathToTelemetryOutbox)
+	}
 
 	// setup cancellation context and wait group for multiple routines
 	ctx, cancel := context.WithCancel(context.Background())
 	var wg sync.WaitGroup
 
+	// start recording connects, disconnects and config changes in the session timeline
+	wg.Add(1)
+	go agent.Timeline.Run(ctx, &wg)
+
 	// start HTTP server to redirect requests
 	router := mux.NewRouter()
+	router.Use(deviceRateLimiter.Middleware, compressionMiddleware)
+	// liveness and readiness probes are the only routes that never require local auth
 	router.HandleFunc("/ping", handlePingRequest).Methods("GET")
-	router.PathPrefix("/info").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handleDeviceInfoRequest(mac, credentials, w, r)
-	})).Methods("GET")
-	router.PathPrefix("/").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	router.HandleFunc("/healthz", handleHealthzRequest).Methods("GET")
+	router.HandleFunc("/readyz", handleReadyzRequest).Methods("GET")
+	router.Handle("/mix", requireLocalAuth(credentials, agent.Pairing, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlePersonalMixRequest(mac, w, r)
+	}))).Methods("GET")
+	router.Handle("/status", requireLocalAuth(credentials, agent.Pairing, http.HandlerFunc(handleStatusRequest))).Methods("GET")
+	router.Handle("/session/events", requireLocalAuth(credentials, agent.Pairing, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleSessionEventsRequest(agent.Timeline, w, r)
+	}))).Methods("GET")
+	addDiagnosticsRoutes(router, credentials)
+	addPairingRoutes(ctx, router, credentials, agent.Pairing)
+	router.Handle("/transport", requireLocalAuth(credentials, agent.Pairing, http.HandlerFunc(handleTransportRequest))).Methods("POST")
+	router.PathPrefix("/info").Handler(requireLocalAuth(credentials, agent.Pairing, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleDeviceInfoRequest(mac, credentials, agent.Storage, w, r)
+	}))).Methods("GET")
+	router.PathPrefix("/").Handler(requireLocalAuth(credentials, agent.Pairing, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		handleDeviceRedirect(mac, credentials, w, r)
-	})).Methods("GET")
+	}))).Methods("GET")
 	router.PathPrefix("/").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		OptionsGetOnly(w, r)
 	})).Methods("OPTIONS")
 	wg.Add(1)
-	server := runHTTPServer(&wg, router, ":80")
+	listenAddress := ":80"
+	if simulate {
+		listenAddress = SimulatedListenAddress
+	} else if agent.DesktopMode {
+		listenAddress = DesktopListenAddress
+	}
+	server := runHTTPServer(&wg, router, getBoundListenAddress(controlAddress, listenAddress))
+	var tlsServer *http.Server
+	if *tlsAddressFlag != "" {
+		if certs, err := NewTLSCertificateManager(*tlsCertFlag, *tlsKeyFlag, time.Now()); err != nil {
+			log.Error(err, "Unable to load TLS certificate")
+		} else {
+			wg.Add(2)
+			go certs.Run(ctx, &wg)
+			tlsServer = runHTTPSServer(&wg, router, getBoundListenAddress(controlAddress, *tlsAddressFlag), certs.GetCertificate)
+		}
+	}
 
-	// update avahi service config and restart daemon
-	beat := client.DeviceHeartbeat{
-		MAC:     mac,
-		Version: getPatchVersion(),
-		Type:    soundDeviceType,
-		PingStats: client.PingStats{
-			StatsUpdatedAt: time.Now(),
-		},
+	// announce the device over mDNS
+	if !simulate && !agent.DesktopMode {
+		avahiPublisher = newAvahiPublisher()
 	}
-	updateAvahiServiceConfig(beat, credentials, lastDeviceStatus)
+	publishAvahiService(deviceState.Heartbeat(), credentials, deviceState.DeviceStatus())
 
 	// start sending heartbeats and updating agent configs
-	wsm := WebSocketManager{
-		ConfigChannel:    make(chan client.DeviceAgentConfig, 100),
-		HeartbeatChannel: make(chan interface{}, 100),
-		APIOrigin:        apiOrigin,
-		Credentials:      credentials,
-		HeartbeatPath:    DeviceHeartbeatPath,
-	}
-	wg.Add(1)
+	wsm := NewWebSocketManager(apiOrigin, credentials, agent)
+	wg.Add(2)
 	go wsm.sendHeartbeatHandler(ctx, &wg)
+	go wsm.sendResultHandler(ctx, &wg)
 
+	// back up the ALSA state to the control plane, so that it can be restored on replacement hardware
+	deviceAlsaBackup.APIClient = wsm.APIClient
+	deviceAlsaBackup.ID = mac
 	wg.Add(1)
+	go deviceAlsaBackup.Run(ctx, &wg)
+
+	// apply the last known good config immediately, instead of waiting for the first heartbeat response
+	if cachedConfig, err := loadDeviceConfigCache(); err == nil {
+		log.Info("Applying cached device config", "path", PathToDeviceConfigCache)
+		wsm.SendConfig(ctx, cachedConfig)
+	}
+
+	wg.Add(2)
 	go wsm.recvConfigHandler(ctx, &wg)
+	go wsm.recvTelemetryHandler(ctx, &wg)
+
+	// fetch the latest config right away, instead of waiting for the first heartbeat response
+	go wsm.FetchConfig(ctx, mac)
 
 	// Start JACK autoconnector
-	ac = NewAutoConnector()
 	wg.Add(1)
-	go ac.Run(ctx, &wg)
+	go agent.AutoConnector.Run(ctx, &wg)
 
 	// Start device mixer
 	dmm := DeviceMixingManager{
@@ -150,35 +281,86 @@ func runOnDevice(apiOrigin string) {
 		CurrentPlaybackDevices: map[string]bool{},
 		DeviceStream0Mapping:   map[string][]string{},
 		DeviceCardMapping:      map[string]int{},
+		AutoConnector:          agent.AutoConnector,
 	}
 	wg.Add(1)
 	go dmm.Run(ctx, &wg)
 
+	// Start managing the USB drive used for local recordings, on real devices
+	if !simulate && !agent.DesktopMode && containerRuntime == "" {
+		wg.Add(1)
+		go agent.Storage.Run(ctx, &wg)
+	}
+
+	// Start checking the safety recording of local inputs, when it is enabled
+	wg.Add(1)
+	go agent.LocalRecorder.Run(ctx, &wg)
+
 	// start sending heartbeats and updating agent configs
 	wg.Add(1)
-	go sendDeviceHeartbeats(ctx, &wg, &beat, &wsm, &dmm)
+	go agent.sendDeviceHeartbeats(ctx, &wg, wsm, &dmm)
 
 	// Start a config handler to update config changes
 	wg.Add(1)
-	go deviceConfigUpdateHandler(ctx, &wg, &beat, &wsm, &dmm)
+	go agent.deviceConfigUpdateHandler(ctx, &wg, wsm, &dmm)
+
+	// Start collecting device metrics, which are sent with heartbeats
+	wg.Add(1)
+	go agent.deviceMetricsHandler(ctx, &wg, &dmm)
+
+	// Start tuning the jitter queue, when adaptive mode is enabled
+	wg.Add(1)
+	go agent.deviceJitterTuningHandler(ctx, &wg)
+
+	// Start publishing device status to an MQTT broker, when one is configured
+	wg.Add(1)
+	go deviceMQTTHandler(ctx, &wg)
+
+	// Start forwarding managed service logs to a remote syslog server, when one is configured
+	wg.Add(1)
+	go deviceLogForwardingHandler(ctx, &wg)
+
+	// Start an expiration handler to disable the device when the studio expires
+	wg.Add(1)
+	go deviceExpirationHandler(ctx, &wg, wsm)
 
 	// Wait for process exit signal, then terminate all goroutines
-	<-exit
+	<-exit.Done()
 	shutdownHTTPServer(server)
-	if wsm.IsInitialized {
-		wsm.CloseConnection()
+	if tlsServer != nil {
+		shutdownHTTPServer(tlsServer)
 	}
+	if err := avahiPublisher.Remove(); err != nil {
+		log.Error(err, "Failed to remove avahi service")
+	}
+	wsm.CloseConnection()
 	cancel()
 
 	// wait for everything to complete
-	wg.Wait()
+	if !waitForGoroutines(&wg, ShutdownTimeout) {
+		log.Info("Timed out waiting for goroutines to stop", "timeout", ShutdownTimeout.String())
+	}
 }
 
 // deviceConfigUpdateHandler receives and processes device config updates
-func deviceConfigUpdateHandler(ctx context.Context, wg *sync.WaitGroup, beat *client.DeviceHeartbeat, wsm *WebSocketManager, dmm *DeviceMixingManager) {
+func (a *DeviceAgent) deviceConfigUpdateHandler(ctx context.Context, wg *sync.WaitGroup, wsm *WebSocketManager, dmm *DeviceMixingManager) {
 	defer wg.Done()
+	defer reportCrash(wsm.APIClient, deviceState.Heartbeat)
 	log.Info("Starting deviceConfigUpdateHandler")
 	firstConfig := true
+	mac := deviceState.Heartbeat().MAC
+
+	// heartbeats return the same config until it changes, so each config is only acknowledged once
+	ackedHash := ""
+	ackConfig := func(config client.DeviceAgentConfig, configErr error) bool {
+		hash := client.GetConfigHash(config)
+		if hash == ackedHash {
+			return false
+		}
+		ackedHash = hash
+		go ackDeviceConfig(ctx, wsm.APIClient, mac, config, configErr)
+		return true
+	}
 
 	for {
 		select {
@@ -186,29 +368,57 @@ func deviceConfigUpdateHandler(ctx context.Context, wg *sync.WaitGroup, beat *cl
 			log.Info("Stopping deviceConfigUpdateHandler")
 			return
 		case newDeviceConfig := <-wsm.ConfigChannel:
-			if firstConfig || newDeviceConfig != currentDeviceConfig {
+			// reject invalid configs rather than writing broken service configs, and report the problem in heartbeats
+			if err := client.ValidateDeviceAgentConfig(newDeviceConfig); err != nil {
+				deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) { beat.ConfigError = err.Error() })
+				if ackConfig(newDeviceConfig, err) {
+					log.Error(err, "Rejected device config")
+				}
+				continue
+			}
+			if !newDeviceConfig.IsSupported() && newDeviceConfig != deviceState.Config() {
+				log.Info("Device config is newer than this agent, ignoring unknown settings",
+					"configVersion", newDeviceConfig.ConfigVersion, "supported", client.AgentConfigVersion)
+			}
+			deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) { beat.ConfigError = "" })
+			// disable expired configs locally, even if the server has not done so yet
+			if bool(newDeviceConfig.Enabled) && isConfigExpired(newDeviceConfig, time.Now()) {
+				log.Info("Disabling expired device config", "expiresAt", newDeviceConfig.ExpiresAt)
+				newDeviceConfig.Enabled = false
+			}
+			if firstConfig || newDeviceConfig != deviceState.Config() {
 				// remove secrets before logging
-				sanitizedDeviceConfig := newDeviceConfig
-				sanitizedDeviceConfig.AuthToken = strings.Repeat("X", len(newDeviceConfig.AuthToken))
-				log.Info("Config updated", "value", sanitizedDeviceConfig)
+				log.Info("Config updated", "value", sanitizeDeviceConfig(newDeviceConfig))
 
 				// Check if the new config indicates a disconnect from an audio server. If yes, kill the existing socket as well.
-				if wsm.IsInitialized && (!bool(newDeviceConfig.Enabled) || newDeviceConfig.Host == "") {
+				if !bool(newDeviceConfig.Enabled) || newDeviceConfig.Host == "" {
 					wsm.CloseConnection()
 				}
 				// Force full device update on the first config received
-				handleDeviceUpdate(beat, wsm.Credentials, newDeviceConfig, dmm, firstConfig)
+				a.handleDeviceUpdate(wsm.Credentials, newDeviceConfig, dmm, firstConfig)
 				firstConfig = false
+				deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) {
+					beat.ConfigHash = client.GetConfigHash(newDeviceConfig)
+					beat.ConfigAppliedAt = time.Now()
+				})
+				ackConfig(newDeviceConfig, nil)
+
+				// persist the config so that it can be applied right away after a reboot
+				if err := saveDeviceConfigCache(newDeviceConfig); err != nil {
+					log.Error(err, "Failed to save device config cache", "path", PathToDeviceConfigCache)
+				}
 			}
 		}
 	}
 }
 
 // sendDeviceHeartbeats sends device heartbeat messages to the backend api, and receives config updates
-func sendDeviceHeartbeats(ctx context.Context, wg *sync.WaitGroup, beat *client.DeviceHeartbeat, wsm *WebSocketManager, dmm *DeviceMixingManager) {
+func (a *DeviceAgent) sendDeviceHeartbeats(ctx context.Context, wg *sync.WaitGroup, wsm *WebSocketManager, dmm *DeviceMixingManager) {
 	defer wg.Done()
+	defer reportCrash(wsm.APIClient, deviceState.Heartbeat)
 	log.Info("Starting sendDeviceHeartbeats")
 	firstHeartbeat := true
+	mac := deviceState.Heartbeat().MAC
 
 	for {
 		select {
@@ -219,21 +429,69 @@ func sendDeviceHeartbeats(ctx context.Context, wg *sync.WaitGroup, beat *client.
 		}
 
 		// reconcile device version to handle first-time startup where patch files may be missing
-		if beat.Version == "" {
-			beat.Version = getPatchVersion()
+		version := deviceState.Heartbeat().Version
+		if version == "" {
+			version = getPatchVersion()
 		}
 
+		lastMessageAge := wsm.LastMessageAge(time.Now())
+		failedDevices := dmm.FailedDevices()
+		localRecording := a.LocalRecorder.Status()
+		storage := a.Storage.Status()
+		deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) {
+			beat.Version = version
+			beat.LastMessageAge = lastMessageAge
+			beat.FailedDevices = failedDevices
+			beat.LocalRecording = localRecording
+			beat.Storage = storage
+		})
+
+		currentDeviceConfig := deviceState.Config()
 		if currentDeviceConfig.Enabled && currentDeviceConfig.Host != "" {
 			// device is connected to an audio server
 
 			// Measure connection latency to the audio server
-			MeasurePingStats(beat, wsm.APIOrigin, currentDeviceConfig.Host, currentDeviceConfig.AuthToken) // blocks for 5 seconds instead of time sleep
+			stats := deviceState.Heartbeat().PingStats
+			lastStatsUpdate := stats.StatsUpdatedAt
+			MeasurePingStats(&stats, wsm.APIOrigin, a.Standby.ActiveHost(currentDeviceConfig), currentDeviceConfig.AuthToken) // blocks for 5 seconds instead of time sleep
+
+			// switch to the standby server after too many missed keepalives
+			if a.Standby.Keepalive(currentDeviceConfig, stats.StatsUpdatedAt.After(lastStatsUpdate) && stats.PacketsRecv > 0) {
+				log.Info("Studio server missed keepalives", "host", currentDeviceConfig.Host, "missed", currentDeviceConfig.StandbyMissedKeepalives)
+				if err := a.Standby.Failover(currentDeviceConfig); err != nil {
+					log.Error(err, "Unable to switch to standby server", "host", currentDeviceConfig.StandbyHost)
+				}
+			}
+			standbyActiveHost := a.Standby.Active()
+			latency := a.Latency.Latest()
+			deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) {
+				beat.PingStats = stats
+				beat.StandbyActiveHost = standbyActiveHost
+				beat.Latency = latency
+			})
+
+			// Initialize the control stream first, so configs and commands are not held up by telemetry
+			// (do nothing if already connected)
+			reconnected, controlErr := wsm.ConnectControl(mac)
+			if controlErr != nil && controlErr != wsclient.ErrBackoff {
+				log.Error(controlErr, "Failed to connect the control websocket")
+			}
+			// catch up on config changes that were missed while disconnected
+			if reconnected {
+				go wsm.FetchConfig(ctx, mac)
+			}
 
-			// Initialize a socket connection (do nothing if already connected)
-			err := wsm.InitConnection(wg, beat.MAC)
+			// the telemetry stream reconnects independently of the control stream
+			err := wsm.ConnectTelemetry(mac)
+			if err == nil && controlErr != nil {
+				// configs may not reach the device without the control stream, so keep using HTTP heartbeats
+				err = controlErr
+			}
 			if err == nil {
+				a.recordOutageEnd()
+				go a.flushOutbox(ctx, wsm.APIClient, mac)
 				// send heartbeat to channel, for delivery over websocket
-				wsm.HeartbeatChannel <- *beat
+				wsm.HeartbeatChannel <- deviceState.Heartbeat()
 				continue
 			}
 
@@ -252,73 +510,144 @@ func sendDeviceHeartbeats(ctx context.Context, wg *sync.WaitGroup, beat *client.
 			}
 
 			// reset ping stats to be empty, with current timestamp
-			beat.PingStats = client.PingStats{StatsUpdatedAt: time.Now()}
+			deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) {
+				beat.PingStats = client.PingStats{StatsUpdatedAt: time.Now()}
+			})
 		}
 
 		// there is no websocket connection to the api server, so send heartbeat to HTTP endpoint
 
 		// send http heartbeat message to api server
-		newDeviceConfig, err := sendHTTPHeartbeat(*beat, wsm.Credentials, wsm.APIOrigin)
+		beat := deviceState.Heartbeat()
+		newDeviceConfig, err := wsm.APIClient.SendHeartbeat(ctx, beat)
 		if err != nil {
-			updateDeviceStatus(*beat, wsm.Credentials, "error")
+			// keep the heartbeat, so that the control plane sees the history of the outage after reconnecting
+			a.queueTelemetry(beat)
+
+			// ride out brief outages with audio services untouched, and only retry the control plane
+			if now := time.Now(); !a.Outage.Failure(now) {
+				log.Error(err, "Failed to send agent heartbeat request, retrying", "outage", a.Outage.Duration(now).Round(time.Second).String())
+				select {
+				case <-ctx.Done():
+				case <-time.After(OutageRetryInterval):
+				}
+				continue
+			}
+			log.Error(err, "Failed to send agent heartbeat request")
+			updateDeviceStatus(wsm.Credentials, "error")
 			panic(err)
 		}
+		a.recordOutageEnd()
+		go a.flushOutbox(ctx, wsm.APIClient, mac)
 
 		// send device config received from response to channel
-		wsm.ConfigChannel <- newDeviceConfig
+		wsm.SendConfig(ctx, newDeviceConfig)
+	}
+}
+
+// recordOutageEnd reports the duration of a control plane outage in heartbeats, once the control plane is reached again
+func (a *DeviceAgent) recordOutageEnd() {
+	if outage := a.Outage.Success(time.Now()); outage > 0 {
+		log.Info("Reconnected to control plane, without restarting audio services", "outage", outage.Round(time.Second).String())
+		deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) { beat.LastOutage = outage.Seconds() })
 	}
 }
 
 // handleDeviceUpdate handles updates to device configuratiosn
-func handleDeviceUpdate(beat *client.DeviceHeartbeat, credentials client.AgentCredentials, config client.DeviceAgentConfig, dmm *DeviceMixingManager, force bool) {
+func (a *DeviceAgent) handleDeviceUpdate(credentials client.AgentCredentials, config client.DeviceAgentConfig, dmm *DeviceMixingManager, force bool) {
 	// update current config sooner, so that other goroutines will have the most up-to-date version
-	lastDeviceConfig := currentDeviceConfig
-	currentDeviceConfig = config
+	lastDeviceConfig := deviceState.SetConfig(config)
+
+	// update device clock sync, which is independent of the audio services
+	if force || config.ClockSyncConfig != lastDeviceConfig.ClockSyncConfig {
+		restartClockSync(config.ClockSyncConfig)
+	}
+
+	// update the managed firewall, which only reapplies rules when they change
+	updateFirewall(config)
 
 	// update ALSA card settings
 	if force || config.ALSAConfig != lastDeviceConfig.ALSAConfig {
+		// volumes set in the device config replace any that were set by paired apps
+		a.Pairing.ResetVolumes()
 		updateALSASettings(config)
 	}
 
-	// check if ALSA card settings was the only change
+	// check if ALSA card settings or LV2 plugin parameters were the only change
+	lastLV2Config := lastDeviceConfig.LV2Config
 	lastDeviceConfig.ALSAConfig = config.ALSAConfig
+	lastDeviceConfig.LV2Config = config.LV2Config
+	lastDeviceConfig.ClockSyncConfig = config.ClockSyncConfig
+	// diagnostics endpoints check the config on each request, so toggling them never requires a restart
+	lastDeviceConfig.Diagnostics = config.Diagnostics
+	// the standby server config is kept warm, so changing it never requires a restart
+	lastDeviceConfig.StandbyConfig = config.StandbyConfig
+	// local access settings are checked on each request, and the firewall is updated without restarting services
+	lastDeviceConfig.LocalAccessConfig = config.LocalAccessConfig
+	// the local recording only captures JACK ports, so toggling it never requires a restart
+	lastDeviceConfig.LocalRecordingConfig = config.LocalRecordingConfig
+	// the USB drive is checked periodically using the latest config
+	lastDeviceConfig.StorageConfig = config.StorageConfig
+	remoteName := strings.Replace(deviceState.Heartbeat().MAC, ":", "", -1)
 	if config != lastDeviceConfig {
 		// more changes required -> reset everything
 
 		// update managed config files
-		updateServiceConfigs(config, strings.Replace(beat.MAC, ":", "", -1))
+		updateServiceConfigs(config, remoteName)
+		a.Standby.Reset()
 
 		// shutdown or restart managed services
-		ac.TeardownClient()
-		dmm.Reset()
-		restartAllServices(config)
-		if config.Enabled && config.Host != "" && config.Type != "" {
-			ac.SetupClient()
+		// NOTE: zita bridges are suspended until JACK has restarted, so that they always run at its sample rate
+		dmm.Suspend()
+		a.AutoConnector.TeardownClient()
+		a.LocalRecorder.Stop()
+		portConflict, servicesError := "", ""
+		if err := restartAllServices(config); err != nil {
+			if _, ok := err.(*PortConflict); ok {
+				portConflict = err.Error()
+			} else {
+				servicesError = err.Error()
+			}
 		}
+		deviceBandwidth.StartSession(config)
+		sampleRate, sampleRateStatus := 0, client.SampleRateStatus("")
+		if portConflict == "" && servicesError == "" && config.Enabled && config.Host != "" && config.Type != "" {
+			a.AutoConnector.SetupClient()
+			sampleRate, sampleRateStatus = verifySampleRate(a.AutoConnector, config)
+			if isLV2ChainEnabled(config) {
+				updateLV2Plugins(config)
+			}
+		}
+		deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) {
+			beat.PortConflict = portConflict
+			beat.ServicesError = servicesError
+			beat.SampleRate = sampleRate
+			beat.SampleRateStatus = sampleRateStatus
+		})
+		dmm.Resume()
+	} else if config.LV2Config != lastLV2Config && bool(config.Enabled) && isLV2ChainEnabled(config) {
+		// update LV2 plugin parameters without restarting services
+		updateLV2Parameters(config)
 	}
 
-	// update device status in avahi service config, if necessary
-	if config.Enabled {
-		updateDeviceStatus(*beat, credentials, "connected")
-	} else {
-		updateDeviceStatus(*beat, credentials, "not connected")
-	}
-}
+	// start or stop the local recording after services have restarted, since it records JACK ports
+	a.LocalRecorder.Update(config, time.Now())
 
-// getMACAddress retrieves ethernet device MAC address, via Linux kernel
-func getMACAddress() string {
-	macBytes, err := ioutil.ReadFile(PathToMACAddress)
-	if err != nil {
-		log.Error(err, "Unable to retrieve MAC address")
-		panic(err)
+	// NOTE: this only writes the standby config if it changed, and it depends upon the JackTrip settings
+	updateStandbyConfig(config, remoteName)
+	if a.Standby.Active() != "" && !isStandbyEnabled(config) {
+		// the standby server was removed, so switch back to the studio server
+		if err := a.Standby.Failback(config); err != nil {
+			log.Error(err, "Unable to switch back to studio server", "host", config.Host)
+		}
 	}
 
-	// trip whitespace and convert to lowercase
-	mac := strings.TrimSpace(string(macBytes))
-	mac = strings.ToLower(mac)
-
-	log.Info("Retrieved MAC address", "mac", mac)
-	return mac
+	// announce the device status over mDNS, if it changed
+	if config.Enabled {
+		updateDeviceStatus(credentials, "connected")
+	} else {
+		updateDeviceStatus(credentials, "not connected")
+	}
 }
 
 // getPatchVersion retrieves patch version for the device
@@ -359,6 +688,7 @@ func getSoundDeviceType() string {
 func updateALSASettings(config client.DeviceAgentConfig) {
 	var val int
 	re := regexp.MustCompile(ALSAInputSourceToken)
+	soundDevice := deviceState.SoundDevice()
 	deviceCardMap := getDeviceToNumMappings()
 	for device, card := range deviceCardMap {
 		controls := getALSAControls(card)
@@ -366,7 +696,7 @@ func updateALSASettings(config client.DeviceAgentConfig) {
 		// For analog bridges:
 		//   * if EnableUSB is false, only set the hifiberry card controls
 		//   * if EnableUSB is true, set all controls
-		if soundDeviceName == "dummy" || bool(config.EnableUSB) || strings.Contains(device, "hifiberry") {
+		if soundDevice.Name == "dummy" || bool(config.EnableUSB) || strings.Contains(device, "hifiberry") {
 			for control := range controls {
 				// NOTE: When setting mute controls, use the negation (because an ALSA value of 0 means mute)
 				isInputSource := re.MatchString(control)
@@ -400,9 +730,7 @@ func updateALSASettings(config client.DeviceAgentConfig) {
 
 // setALSAControl sets the value of an ALSA control
 func setALSAControl(card int, control, value string) {
-	cmd := exec.Command("/usr/bin/amixer", "-c", fmt.Sprintf("%d", card), "cset", fmt.Sprintf("name='%s'", control), "--", value)
-	_, err := cmd.Output()
-	if err != nil {
+	if err := alsaProvider.SetControl(card, control, value); err != nil {
 		log.Error(err, "Unable to set ALSA control", "card", card, "control", control)
 	}
 	log.Info("Updated ALSA control", "card", card, "control", control, "value", value)
@@ -410,81 +738,37 @@ func setALSAControl(card int, control, value string) {
 
 // getALSAControls returns a map of available capture/playback volume controls for a specific card
 func getALSAControls(card int) map[string]bool {
-	out, err := exec.Command("/usr/bin/amixer", "-c", fmt.Sprintf("%d", card), "controls").Output()
+	out, err := alsaProvider.Controls(card)
 	if err != nil {
 		log.Error(err, "Unable to get ALSA controls", "card", card)
 		return nil
 	}
-	return parseALSAControls(string(out))
-}
-
-// parseALSAControls parses all relevant volume controls of an ALSA card from `amixer controls`
-func parseALSAControls(output string) map[string]bool {
-	controls := map[string]bool{}
-	lines := strings.Split(output, "\n")
-	r := regexp.MustCompile(`numid=(\d+),iface=(\w+),name='(.*(Playback Volume|Playback Switch|Capture Volume|Capture Switch))'`)
-	for _, line := range lines {
-		matches := r.FindAllStringSubmatch(line, -1)
-		if len(matches) == 1 {
-			controls[matches[0][3]] = true
-		}
-	}
-	return controls
-}
-
-// updateAvahiServiceConfig generates a new /etc/avahi/services/jacktrip-agent.service file
-func updateAvahiServiceConfig(beat client.DeviceHeartbeat, credentials client.AgentCredentials, status string) {
-	// ensure config directory exists
-	err := os.MkdirAll("/tmp/avahi/services", 0755)
-	if err != nil {
-		log.Error(err, "Failed to create directory", "path", "/tmp/avahi/services")
-		return
-	}
-
-	apiHash := client.GetAPIHash(credentials.APISecret)
-	avahiServiceConfig := fmt.Sprintf(`<?xml version="1.0" standalone='no'?><!--*-nxml-*-->
-<!DOCTYPE service-group SYSTEM "avahi-service.dtd">
-<service-group>
-	<name replace-wildcards="yes">JackTrip Agent on %%h</name>
-	<service>
-		<type>_http._tcp</type>
-		<port>80</port>
-		<txt-record value-format="text">status=%s</txt-record>
-		<txt-record value-format="text">version=%s</txt-record>
-		<txt-record value-format="text">mac=%s</txt-record>
-		<txt-record value-format="text">apiHash=%s</txt-record>
-	</service>
-</service-group>
-`, status, beat.Version, beat.MAC, apiHash)
-
-	err = ioutil.WriteFile(PathToAvahiServiceFile, []byte(avahiServiceConfig), 0644)
-	if err != nil {
-		log.Error(err, "Failed to save avahi service config", "path", PathToAvahiServiceFile)
-		return
-	}
-	log.Info(fmt.Sprintf("Updated Avahi service status to %s", status))
+	return parseALSAControls(out)
 }
 
-// updateDeviceStatus updates the device status, including avahi config, if it has changed
-func updateDeviceStatus(beat client.DeviceHeartbeat, credentials client.AgentCredentials, status string) {
+// updateDeviceStatus updates the device status, including its mDNS announcement, if it has changed
+func updateDeviceStatus(credentials client.AgentCredentials, status string) {
 	log.Info(fmt.Sprintf("Updated device status to %s", status))
-	if lastDeviceStatus != status {
-		updateAvahiServiceConfig(beat, credentials, status)
-		lastDeviceStatus = status
+	if deviceState.SetDeviceStatus(status) {
+		publishAvahiService(deviceState.Heartbeat(), credentials, status)
 	}
 }
 
 // handleDeviceInfoRequest returns information about a device
-func handleDeviceInfoRequest(mac string, credentials client.AgentCredentials, w http.ResponseWriter, r *http.Request) {
+func handleDeviceInfoRequest(mac string, credentials client.AgentCredentials, storage *StorageManager, w http.ResponseWriter, r *http.Request) {
 	apiHash := client.GetAPIHash(credentials.APISecret)
 	deviceInfo := struct {
-		APIPrefix string `json:"apiPrefix"`
-		APIHash   string `json:"apiHash"`
-		MAC       string `json:"mac"`
+		APIPrefix string                `json:"apiPrefix"`
+		APIHash   string                `json:"apiHash"`
+		MAC       string                `json:"mac"`
+		ExpiresIn int                   `json:"expiresIn"`
+		Storage   *client.StorageStatus `json:"storage,omitempty"`
 	}{
 		APIPrefix: credentials.APIPrefix,
 		APIHash:   apiHash,
 		MAC:       mac,
+		ExpiresIn: getSecondsUntilDisable(deviceState.Config(), time.Now()),
+		Storage:   storage.Status(),
 	}
 	RespondJSON(w, http.StatusOK, deviceInfo)
 }
@@ -12,6 +12,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !server
+// +build !server
+
 package main
 
 import (
@@ -27,7 +30,7 @@ import (
 )
 
 // MeasurePingStats uses a socket connection to measure a RTT to an audio server
-func MeasurePingStats(beat *client.DeviceHeartbeat, apiOrigin, host, token string) {
+func MeasurePingStats(stats *client.PingStats, apiOrigin, host, token string) {
 	u := url.URL{Scheme: "wss", Host: host, Path: "/ping"}
 	dialer := websocket.Dialer{HandshakeTimeout: time.Second}
 	header := make(http.Header)
@@ -47,7 +50,7 @@ func MeasurePingStats(beat *client.DeviceHeartbeat, apiOrigin, host, token strin
 		pinger.Interval = time.Second
 		pinger.Timeout = HeartbeatInterval * time.Second
 		pinger.Run() // blocking until done
-		updateICMPPing(beat, pinger.Statistics())
+		updateICMPPing(stats, pinger.Statistics())
 		log.V(1).Info("Updated device heartbeat with ICMP ping result")
 		return
 	}
@@ -78,26 +81,26 @@ func MeasurePingStats(beat *client.DeviceHeartbeat, apiOrigin, host, token strin
 
 		time.Sleep(time.Second)
 	}
-	updateWSPing(beat, socketRtts)
+	updateWSPing(stats, socketRtts)
 	log.V(1).Info("Updated device heartbeat with websocket ping result")
 }
 
 // updatePing function takes icmpStats object and update ping statistics
-func updateICMPPing(beat *client.DeviceHeartbeat, icmpStats *goping.Statistics) {
-	beat.MinRtt = icmpStats.MinRtt
-	beat.MaxRtt = icmpStats.MaxRtt
-	beat.AvgRtt = icmpStats.AvgRtt
-	beat.StdDevRtt = icmpStats.StdDevRtt
+func updateICMPPing(stats *client.PingStats, icmpStats *goping.Statistics) {
+	stats.MinRtt = icmpStats.MinRtt
+	stats.MaxRtt = icmpStats.MaxRtt
+	stats.AvgRtt = icmpStats.AvgRtt
+	stats.StdDevRtt = icmpStats.StdDevRtt
 	if len(icmpStats.Rtts) > 0 {
-		beat.LatestRtt = icmpStats.Rtts[len(icmpStats.Rtts)-1]
+		stats.LatestRtt = icmpStats.Rtts[len(icmpStats.Rtts)-1]
 	}
-	beat.PacketsSent = icmpStats.PacketsSent
-	beat.PacketsRecv = icmpStats.PacketsRecv
-	beat.StatsUpdatedAt = time.Now()
+	stats.PacketsSent = icmpStats.PacketsSent
+	stats.PacketsRecv = icmpStats.PacketsRecv
+	stats.StatsUpdatedAt = time.Now()
 }
 
 // updateWSPing takes rtt array to update ping statistics
-func updateWSPing(beat *client.DeviceHeartbeat, rtts []time.Duration) {
+func updateWSPing(stats *client.PingStats, rtts []time.Duration) {
 	var total, minRtt, maxRtt, avgRtt, sd time.Duration
 	for _, rtt := range rtts {
 		total += rtt
@@ -114,14 +117,14 @@ func updateWSPing(beat *client.DeviceHeartbeat, rtts []time.Duration) {
 	for _, rtt := range rtts {
 		sd += (rtt - avgRtt) * (rtt - avgRtt)
 	}
-	beat.MinRtt = minRtt
-	beat.MaxRtt = maxRtt
-	beat.AvgRtt = avgRtt
-	beat.StdDevRtt = time.Duration(math.Sqrt(float64(sd.Nanoseconds() / int64(len(rtts)))))
+	stats.MinRtt = minRtt
+	stats.MaxRtt = maxRtt
+	stats.AvgRtt = avgRtt
+	stats.StdDevRtt = time.Duration(math.Sqrt(float64(sd.Nanoseconds() / int64(len(rtts)))))
 	if len(rtts) > 0 {
-		beat.LatestRtt = rtts[len(rtts)-1]
+		stats.LatestRtt = rtts[len(rtts)-1]
 	}
-	beat.PacketsSent = HeartbeatInterval
-	beat.PacketsRecv = len(rtts)
-	beat.StatsUpdatedAt = time.Now()
+	stats.PacketsSent = HeartbeatInterval
+	stats.PacketsRecv = len(rtts)
+	stats.StatsUpdatedAt = time.Now()
 }
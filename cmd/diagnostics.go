@@ -0,0 +1,82 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/gorilla/mux"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+// RuntimeStats describes the Go runtime of the agent
+type RuntimeStats struct {
+	Goroutines int    `json:"goroutines"`
+	HeapAlloc  uint64 `json:"heapAlloc"`
+	HeapInuse  uint64 `json:"heapInuse"`
+	Sys        uint64 `json:"sys"`
+	NumGC      uint32 `json:"numGC"`
+	GoVersion  string `json:"goVersion"`
+	GitSHA     string `json:"gitSHA"`
+}
+
+// getRuntimeStats returns statistics about the Go runtime
+func getRuntimeStats() RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return RuntimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		HeapAlloc:  mem.HeapAlloc,
+		HeapInuse:  mem.HeapInuse,
+		Sys:        mem.Sys,
+		NumGC:      mem.NumGC,
+		GoVersion:  runtime.Version(),
+		GitSHA:     GitSHA,
+	}
+}
+
+// requireDiagnostics only allows requests when diagnostics are enabled in the config and signed with the agent's credentials
+func requireDiagnostics(credentials client.AgentCredentials, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !deviceState.Config().Diagnostics {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !isAuthorizedAdminRequest(credentials, r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// addDiagnosticsRoutes adds pprof and runtime diagnostics endpoints to a router
+func addDiagnosticsRoutes(router *mux.Router, credentials client.AgentCredentials) {
+	debug := router.PathPrefix("/debug").Subrouter()
+	debug.Handle("/runtime", requireDiagnostics(credentials, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		RespondJSON(w, http.StatusOK, getRuntimeStats())
+	})))
+	debug.Handle("/pprof/cmdline", requireDiagnostics(credentials, http.HandlerFunc(pprof.Cmdline)))
+	debug.Handle("/pprof/profile", requireDiagnostics(credentials, http.HandlerFunc(pprof.Profile)))
+	debug.Handle("/pprof/symbol", requireDiagnostics(credentials, http.HandlerFunc(pprof.Symbol)))
+	debug.Handle("/pprof/trace", requireDiagnostics(credentials, http.HandlerFunc(pprof.Trace)))
+	// NOTE: the index also serves named profiles, ie. /debug/pprof/goroutine and /debug/pprof/heap
+	debug.PathPrefix("/pprof/").Handler(requireDiagnostics(credentials, http.HandlerFunc(pprof.Index)))
+}
@@ -0,0 +1,71 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagnosticsRoutes(t *testing.T) {
+	assert := assert.New(t)
+	saved := deviceState
+	deviceState = NewStateStore()
+	defer func() { deviceState = saved }()
+
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	router := mux.NewRouter()
+	addDiagnosticsRoutes(router, credentials)
+
+	request := func(path string, authorized bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com"+path, nil)
+		if authorized {
+			req.Header.Set("APIPrefix", "prefix")
+			req.Header.Set("APISecret", "secret")
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// Case for diagnostics disabled
+	assert.Equal(404, request("/debug/runtime", true).Code)
+
+	config := client.DeviceAgentConfig{}
+	config.Diagnostics = true
+	deviceState.SetConfig(config)
+
+	// Case for unauthorized requests
+	assert.Equal(401, request("/debug/runtime", false).Code)
+	assert.Equal(401, request("/debug/pprof/heap", false).Code)
+
+	// Case for authorized requests
+	resp := request("/debug/runtime", true)
+	assert.Equal(200, resp.Code)
+	var stats RuntimeStats
+	assert.Nil(json.Unmarshal(resp.Body.Bytes(), &stats))
+	assert.True(stats.Goroutines > 0)
+	assert.Equal(200, request("/debug/pprof/", true).Code)
+	assert.Equal(200, request("/debug/pprof/goroutine?debug=1", true).Code)
+	assert.Equal(200, request("/debug/pprof/cmdline", true).Code)
+}
@@ -0,0 +1,156 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// DoctorCommand is the websocket command used to run self-diagnostics remotely
+	DoctorCommand = "doctor"
+
+	// DoctorMinFreeBytes is the minimum free disk space required in AgentLibDir
+	DoctorMinFreeBytes = 100 * 1024 * 1024
+
+	// DoctorMaxClockSkew is the maximum allowed difference between the local and API clocks
+	DoctorMaxClockSkew = time.Minute
+)
+
+// doctorServiceNames are the systemd services that must be installed on devices
+var doctorServiceNames = []string{JackServiceName, JackTripServiceName, JamulusServiceName}
+
+// newDoctorCheck constructs the result of a check
+func newDoctorCheck(name string, err error, message string) client.DoctorCheck {
+	if err != nil {
+		return client.DoctorCheck{Name: name, Passed: false, Message: err.Error()}
+	}
+	return client.DoctorCheck{Name: name, Passed: true, Message: message}
+}
+
+// checkJackd verifies that a JACK client can connect to jackd
+func checkJackd() client.DoctorCheck {
+	_, err := common.InitJackClient("doctor", nil, nil, nil, nil, true)
+	return newDoctorCheck("jackd", err, "jackd is reachable")
+}
+
+// checkALSACards verifies that at least one ALSA sound card is present
+func checkALSACards(path string) client.DoctorCheck {
+	rawBytes, err := ioutil.ReadFile(path)
+	if err == nil {
+		cards := strings.TrimSpace(string(rawBytes))
+		if cards == "" || strings.Contains(cards, "no soundcards") {
+			err = fmt.Errorf("no ALSA sound cards found")
+		}
+	}
+	return newDoctorCheck("alsa", err, "ALSA sound cards are present")
+}
+
+// checkServices verifies that the managed systemd services are installed
+func checkServices() client.DoctorCheck {
+	missing, err := serviceManager.Missing(doctorServiceNames...)
+	if err == nil && len(missing) > 0 {
+		err = fmt.Errorf("missing systemd services: %s", strings.Join(missing, ", "))
+	}
+	return newDoctorCheck("services", err, "systemd services are installed")
+}
+
+// checkAPIAndClock verifies that the API is reachable, and that the local clock agrees with it
+func checkAPIAndClock(apiOrigin string, now time.Time) []client.DoctorCheck {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	r, err := httpClient.Get(apiOrigin)
+	if err != nil {
+		return []client.DoctorCheck{
+			newDoctorCheck("api", err, ""),
+			newDoctorCheck("clock", fmt.Errorf("unable to compare clock with API"), ""),
+		}
+	}
+	r.Body.Close()
+	checks := []client.DoctorCheck{newDoctorCheck("api", nil, fmt.Sprintf("API responded with status %d", r.StatusCode))}
+
+	apiTime, err := http.ParseTime(r.Header.Get("Date"))
+	if err == nil {
+		skew := now.Sub(apiTime)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > DoctorMaxClockSkew {
+			err = fmt.Errorf("clock differs from API by %s", skew.Round(time.Second))
+		}
+	}
+	return append(checks, newDoctorCheck("clock", err, "clock is in sync with API"))
+}
+
+// checkDiskSpace verifies that enough disk space is free for agent files
+func checkDiskSpace(path string, minFree uint64) client.DoctorCheck {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return newDoctorCheck("disk", err, "")
+	}
+	free := stat.Bavail * uint64(stat.Bsize)
+	if free < minFree {
+		err = fmt.Errorf("only %d MB free in %s", free/1024/1024, path)
+	}
+	return newDoctorCheck("disk", err, fmt.Sprintf("%d MB free in %s", free/1024/1024, path))
+}
+
+// newDoctorReport summarizes the results of checks
+func newDoctorReport(checks []client.DoctorCheck, now time.Time) client.DoctorReport {
+	report := client.DoctorReport{Passed: true, Checks: checks, Timestamp: now}
+	for _, c := range checks {
+		if !c.Passed {
+			report.Passed = false
+		}
+	}
+	return report
+}
+
+// runDoctor checks the prerequisites for running the agent on a device
+func runDoctor(apiOrigin string) client.DoctorReport {
+	now := time.Now()
+	checks := []client.DoctorCheck{
+		checkJackd(),
+		checkALSACards(PathToAsoundCards),
+		checkServices(),
+		checkContainer(),
+	}
+	checks = append(checks, checkAPIAndClock(apiOrigin, now)...)
+	checks = append(checks, checkDiskSpace(AgentLibDir, DoctorMinFreeBytes))
+	return newDoctorReport(checks, now)
+}
+
+// printDoctorReport prints a self-diagnostic report, returning true if all checks passed
+func printDoctorReport(report client.DoctorReport) bool {
+	for _, c := range report.Checks {
+		result := "PASS"
+		if !c.Passed {
+			result = "FAIL"
+		}
+		fmt.Printf("[%s] %s: %s\n", result, c.Name, c.Message)
+	}
+	return report.Passed
+}
@@ -0,0 +1,87 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckALSACards(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "doctor")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cards")
+
+	assert.False(checkALSACards(path).Passed)
+	ioutil.WriteFile(path, []byte("--- no soundcards ---\n"), 0644)
+	assert.False(checkALSACards(path).Passed)
+	ioutil.WriteFile(path, []byte(" 0 [sndrpihifiberry]: HifiberryDacp - snd_rpi_hifiberry_dacplusadcpro\n"), 0644)
+	assert.True(checkALSACards(path).Passed)
+}
+
+func TestCheckAPIAndClock(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	// Case for a reachable API with a synchronized clock (any response means the API is reachable)
+	checks := checkAPIAndClock(server.URL, time.Now())
+	assert.Equal(2, len(checks))
+	assert.True(checks[0].Passed)
+	assert.True(checks[1].Passed)
+
+	// Case for a skewed clock
+	checks = checkAPIAndClock(server.URL, time.Now().Add(time.Hour))
+	assert.True(checks[0].Passed)
+	assert.False(checks[1].Passed)
+	assert.Contains(checks[1].Message, "clock differs from API")
+
+	// Case for an unreachable API
+	checks = checkAPIAndClock("http://127.0.0.1:1", time.Now())
+	assert.False(checks[0].Passed)
+	assert.False(checks[1].Passed)
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(checkDiskSpace(os.TempDir(), 1).Passed)
+	assert.False(checkDiskSpace(os.TempDir(), ^uint64(0)).Passed)
+	assert.False(checkDiskSpace("/does/not/exist", 1).Passed)
+}
+
+func TestNewDoctorReport(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	assert.True(newDoctorReport([]client.DoctorCheck{{Name: "a", Passed: true}}, now).Passed)
+	report := newDoctorReport([]client.DoctorCheck{{Name: "a", Passed: true}, {Name: "b", Passed: false}}, now)
+	assert.False(report.Passed)
+	assert.Equal(now, report.Timestamp)
+	assert.False(printDoctorReport(report))
+}
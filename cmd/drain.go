@@ -0,0 +1,55 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+// DrainGate blocks new JackTrip and Jamulus connections to an audio server while it drains, so that
+// musicians who are connected can finish their session
+type DrainGate struct {
+	// port that new connections are blocked on, or 0 when the server is not draining
+	port int
+}
+
+// Apply blocks new connections to the server port when a config drains the server, and unblocks
+// them when it no longer does; it is only called by handleConfigs
+func (g *DrainGate) Apply(config client.ServerAgentConfig) error {
+	port := 0
+	if config.Drain {
+		port = config.Port
+	}
+	if port == g.port {
+		return nil
+	}
+	if port > 0 {
+		log.Info("Blocking new connections while draining", "port", port)
+		if _, err := systemRunner.OutputWithInput(common.GetDrainRuleset(port), NFTPath, "-f", "-"); err != nil {
+			return err
+		}
+	} else {
+		log.Info("Accepting new connections")
+		if _, err := systemRunner.Output(NFTPath, "delete", "table", "inet", common.DrainTable); err != nil {
+			return err
+		}
+	}
+	g.port = port
+	return nil
+}
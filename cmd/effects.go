@@ -0,0 +1,168 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// EffectsServiceName is the name of the systemd service for the local effects chain
+	EffectsServiceName = "effects.service"
+
+	// EffectsConfigTemplate is the template used to generate /tmp/default/effects file on raspberry pi devices
+	EffectsConfigTemplate = "EFFECTS_OPTS=-q -G:jack,%s,notransport -f:f32,%d,%d -i:jack,,in -o:jack,,out %s\n"
+
+	// EffectsClientName is the JACK client name used by the effects host
+	EffectsClientName = "effects"
+
+	// Local capture input ports
+	systemCapturePrefix  = "system:capture_"
+	systemPlaybackPrefix = "system:playback_"
+)
+
+// effectPlugins maps the effects that may be used in a chain to LSP LADSPA plugin labels
+var effectPlugins = map[string]string{
+	"gate":       "gate_mono",
+	"eq":         "para_equalizer_x8_mono",
+	"compressor": "compressor_mono",
+}
+
+// parseEffectsChain parses a comma-separated effects chain into an ordered list of effects
+func parseEffectsChain(chain string) ([]string, error) {
+	var effects []string
+	for _, effect := range strings.Split(chain, ",") {
+		effect = strings.ToLower(strings.TrimSpace(effect))
+		if effect == "" {
+			continue
+		}
+		if _, ok := effectPlugins[effect]; !ok {
+			return nil, fmt.Errorf("unsupported effect: %s", effect)
+		}
+		effects = append(effects, effect)
+	}
+	return effects, nil
+}
+
+// isEffectsChainEnabled returns true if a device config requires the local effects chain
+func isEffectsChainEnabled(config client.DeviceAgentConfig) bool {
+	// effects are only supported when the device connects using JackTrip
+	effects, err := parseEffectsChain(config.EffectsChain)
+	return err == nil && len(effects) > 0 && usesJackTrip(config)
+}
+
+// isInputChainEnabled returns true if device input is processed locally before it is sent to JackTrip
+func isInputChainEnabled(config client.DeviceAgentConfig) bool {
+	return isEffectsChainEnabled(config) || isLV2ChainEnabled(config)
+}
+
+// getEffectsOpts returns ecasound chain operator options for a list of effects
+func getEffectsOpts(effects []string) string {
+	var opts []string
+	for _, effect := range effects {
+		opts = append(opts, fmt.Sprintf("-el:%s", effectPlugins[effect]))
+	}
+	return strings.Join(opts, " ")
+}
+
+// updateEffectsConfig writes the effects service config file
+func updateEffectsConfig(config client.DeviceAgentConfig, channels int) {
+	effects, err := parseEffectsChain(config.EffectsChain)
+	if err != nil {
+		log.Error(err, "Invalid effects chain", "value", config.EffectsChain)
+	}
+	effectsConfig := fmt.Sprintf(EffectsConfigTemplate, EffectsClientName, channels, config.SampleRate, getEffectsOpts(effects))
+	_, err = common.WriteFileIfChanged(PathToEffectsConfig, []byte(effectsConfig), 0644)
+	if err != nil {
+		log.Error(err, "Failed to save effects config", "path", PathToEffectsConfig)
+	}
+}
+
+// isEffectsPort returns true if a JACK port belongs to the effects host
+func isEffectsPort(name string) bool {
+	return strings.HasPrefix(name, EffectsClientName+":")
+}
+
+// getInputChainStages returns the input and output ports of each processing stage for a channel, in order
+func getInputChainStages(config client.DeviceAgentConfig, channel int) [][2]string {
+	var stages [][2]string
+	if isEffectsChainEnabled(config) {
+		stages = append(stages, [2]string{
+			fmt.Sprintf("%s:in_%d", EffectsClientName, channel),
+			fmt.Sprintf("%s:out_%d", EffectsClientName, channel),
+		})
+	}
+	if isLV2ChainEnabled(config) {
+		stages = append(stages, getLV2Stages(config, channel)...)
+	}
+	return stages
+}
+
+// hasInputChainPorts returns true if the input and output ports of every processing stage exist
+func (ac *AutoConnector) hasInputChainPorts(stages [][2]string) bool {
+	for _, stage := range stages {
+		if !ac.JackClient.HasPort(stage[0]) || !ac.JackClient.HasPort(stage[1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// connectInputChain routes local audio (or audio from the AES67 bridge) through each processing stage before it reaches JackTrip
+// NOTE: JackTrip does not connect its own ports when the input chain is enabled
+// If any stage is missing (ie. a plugin failed to load), input is routed directly to JackTrip so that it never goes silent
+func (ac *AutoConnector) connectInputChain(config client.DeviceAgentConfig) {
+	for i := 1; i <= getSendChannels(config); i++ {
+		capture := fmt.Sprintf("%s%d", getCapturePrefix(config), i)
+		send := fmt.Sprintf("%s%d", hubserverInput, i)
+		stages := getInputChainStages(config, i)
+		if !ac.hasInputChainPorts(stages) {
+			log.Info("Input chain is incomplete, routing input directly", "channel", i)
+			if ac.isValidPort(capture) && ac.isValidPort(send) {
+				ac.connectPorts(capture, send)
+			}
+			continue
+		}
+		src := capture
+		for _, stage := range stages {
+			if ac.isValidPort(src) {
+				ac.connectPorts(src, stage[0])
+			}
+			src = stage[1]
+		}
+		if !ac.isValidPort(send) {
+			continue
+		}
+		ac.connectPorts(src, send)
+		// the direct path is only removed once every stage is connected
+		if ac.JackClient.HasPort(capture) {
+			ac.disconnectPorts(capture, send)
+		}
+	}
+	for i := 1; i <= getReceiveChannels(config); i++ {
+		receive := fmt.Sprintf("%s%d", hubserverOutput, i)
+		playback := fmt.Sprintf("%s%d", getPlaybackPrefix(config), i)
+		if ac.isValidPort(receive) && ac.isValidPort(playback) {
+			ac.connectPorts(receive, playback)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xthexder/go-jack"
+)
+
+func TestParseEffectsChain(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for empty chain
+	result, err := parseEffectsChain("")
+	assert.Nil(err)
+	assert.Equal(0, len(result))
+
+	// Case for valid chain, order should be preserved
+	result, err = parseEffectsChain(" Gate, eq,compressor ,")
+	assert.Nil(err)
+	assert.Equal([]string{"gate", "eq", "compressor"}, result)
+
+	// Case for unsupported effect
+	_, err = parseEffectsChain("gate,fuzz")
+	assert.NotNil(err)
+}
+
+func TestGetEffectsOpts(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", getEffectsOpts(nil))
+	assert.Equal("-el:gate_mono -el:compressor_mono", getEffectsOpts([]string{"gate", "compressor"}))
+}
+
+func TestIsEffectsChainEnabled(t *testing.T) {
+	assert := assert.New(t)
+	config := client.DeviceAgentConfig{}
+	config.Type = client.JackTrip
+	assert.False(isEffectsChainEnabled(config))
+	config.EffectsChain = "gate"
+	assert.True(isEffectsChainEnabled(config))
+	config.EffectsChain = "fuzz"
+	assert.False(isEffectsChainEnabled(config))
+	config.EffectsChain = "gate"
+	config.Type = client.Jamulus
+	assert.False(isEffectsChainEnabled(config))
+	config.Type = client.JackTripJamulus
+	assert.False(isEffectsChainEnabled(config))
+	config.Quality = 2
+	assert.True(isEffectsChainEnabled(config))
+}
+
+func TestConnectInputChain(t *testing.T) {
+	assert := assert.New(t)
+	graph := NewFakeJackGraph("autoconnector")
+	graph.RegisterPort("system:capture_1", jack.PortIsOutput)
+	graph.RegisterPort("hubserver:send_1", jack.PortIsInput)
+	ac := NewAutoConnector()
+	ac.JackClient = graph
+
+	config := client.DeviceAgentConfig{}
+	config.Type = client.JackTrip
+	config.EffectsChain = "gate"
+
+	// Case for missing effects ports, input is routed directly
+	ac.connectInputChain(config)
+	assert.Equal([]string{"hubserver:send_1"}, graph.GetConnections("system:capture_1"))
+
+	// Case for complete chain, the direct path is removed
+	graph.RegisterPort("effects:in_1", jack.PortIsInput)
+	graph.RegisterPort("effects:out_1", jack.PortIsOutput)
+	ac.connectInputChain(config)
+	assert.Equal([]string{"effects:in_1"}, graph.GetConnections("system:capture_1"))
+	assert.Equal([]string{"hubserver:send_1"}, graph.GetConnections("effects:out_1"))
+
+	// Case for effects host going away, input is routed directly again
+	graph.UnregisterClient("effects")
+	ac.connectInputChain(config)
+	assert.Equal([]string{"hubserver:send_1"}, graph.GetConnections("system:capture_1"))
+}
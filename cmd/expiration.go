@@ -0,0 +1,75 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// ExpirationGracePeriod is how long a device keeps its studio connection after the studio expires
+	ExpirationGracePeriod = 30 * time.Second
+
+	// CheckExpirationInterval is the time to sleep between checking for config expiration
+	CheckExpirationInterval = time.Second
+)
+
+// isConfigExpired returns true if a config has passed its expiration time, including the grace period
+func isConfigExpired(config client.DeviceAgentConfig, now time.Time) bool {
+	if config.ExpiresAt.IsZero() {
+		return false
+	}
+	return now.After(config.ExpiresAt.Add(ExpirationGracePeriod))
+}
+
+// getSecondsUntilDisable returns the number of seconds until a config is disabled locally, or -1 if it never expires
+func getSecondsUntilDisable(config client.DeviceAgentConfig, now time.Time) int {
+	if !bool(config.Enabled) || config.ExpiresAt.IsZero() {
+		return -1
+	}
+	remaining := config.ExpiresAt.Add(ExpirationGracePeriod).Sub(now)
+	if remaining < 0 {
+		return 0
+	}
+	return int(remaining.Seconds())
+}
+
+// deviceExpirationHandler disables the device locally once its config expires, instead of waiting for the server to push a disable
+func deviceExpirationHandler(ctx context.Context, wg *sync.WaitGroup, wsm *WebSocketManager) {
+	defer wg.Done()
+	log.Info("Starting deviceExpirationHandler")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping deviceExpirationHandler")
+			return
+		case <-time.After(CheckExpirationInterval):
+			// re-submit the current config so that deviceConfigUpdateHandler applies the expiration
+			config := deviceState.Config()
+			if bool(config.Enabled) && isConfigExpired(config, time.Now()) {
+				log.Info("Device config has expired", "expiresAt", config.ExpiresAt)
+				wsm.SendConfig(ctx, config)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsConfigExpired(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2022, 4, 9, 13, 0, 0, 0, time.UTC)
+	config := client.DeviceAgentConfig{}
+
+	// Case for config without expiration
+	assert.False(isConfigExpired(config, now))
+
+	// Case for config that expires in the future
+	config.ExpiresAt = now.Add(time.Minute)
+	assert.False(isConfigExpired(config, now))
+
+	// Case for config that expired within the grace period
+	config.ExpiresAt = now.Add(-1 * time.Second)
+	assert.False(isConfigExpired(config, now))
+
+	// Case for config that expired beyond the grace period
+	config.ExpiresAt = now.Add(-1 * (ExpirationGracePeriod + time.Second))
+	assert.True(isConfigExpired(config, now))
+}
+
+func TestGetSecondsUntilDisable(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2022, 4, 9, 13, 0, 0, 0, time.UTC)
+	config := client.DeviceAgentConfig{}
+	config.ExpiresAt = now.Add(time.Minute)
+
+	// Case for disabled config
+	assert.Equal(-1, getSecondsUntilDisable(config, now))
+
+	// Case for enabled config without expiration
+	config.Enabled = true
+	config.ExpiresAt = time.Time{}
+	assert.Equal(-1, getSecondsUntilDisable(config, now))
+
+	// Case for enabled config that expires in the future
+	config.ExpiresAt = now.Add(time.Minute)
+	assert.Equal(90, getSecondsUntilDisable(config, now))
+
+	// Case for enabled config beyond the grace period
+	config.ExpiresAt = now.Add(-1 * time.Hour)
+	assert.Equal(0, getSecondsUntilDisable(config, now))
+}
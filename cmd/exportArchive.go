@@ -0,0 +1,226 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// ExportCommand is the command used to export and purge all locally stored data
+	ExportCommand = "export"
+
+	// ExportTimeout is the maximum time allowed to upload a data export
+	ExportTimeout = 5 * time.Minute
+
+	// ExportLogsName is the name of the service logs within a data export
+	ExportLogsName = "logs/services.log"
+)
+
+// errExportRunning is returned when an export is requested while another one is still running
+var errExportRunning = errors.New("an export is already running")
+
+// exportRunning is set while a data export is running, so that exports never overlap
+var exportRunning int32
+
+// DataExport describes the contents of a data export archive, and the data purged once it is uploaded
+type DataExport struct {
+	// Files are exported under configs/ by their base name
+	Files []string
+
+	// Recordings are exported under recordings/ by their path relative to RecordingsDir
+	RecordingsDir string
+	Recordings    []string
+
+	// Logs are the journal entries of the exported services
+	Logs []byte
+
+	// Masked returns the contents of files with secrets masked, by path; they are exported as JSON
+	Masked map[string]func() (interface{}, error)
+
+	// Owned are the files owned by the agent, which are purged once the export is uploaded
+	Owned []string
+}
+
+// getExistingFiles returns the paths that are regular files
+func getExistingFiles(paths ...string) []string {
+	var files []string
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// writeDataExport writes a zip archive containing local files, recordings and service logs, returning the names
+// of its entries
+func writeDataExport(w io.Writer, export DataExport) ([]string, error) {
+	zw := zip.NewWriter(w)
+	var names []string
+	for _, path := range export.Files {
+		name := "configs/" + filepath.Base(path)
+		if err := addExportFile(zw, path, name, export.Masked[path]); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	for _, path := range export.Recordings {
+		rel, err := filepath.Rel(export.RecordingsDir, path)
+		if err != nil {
+			return nil, err
+		}
+		name := "recordings/" + filepath.ToSlash(rel)
+		if err := addExportFile(zw, path, name, nil); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	lw, err := zw.Create(ExportLogsName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := lw.Write(export.Logs); err != nil {
+		return nil, err
+	}
+	names = append(names, ExportLogsName)
+	return names, zw.Close()
+}
+
+// addExportFile copies a file from disk into a zip archive, or the masked contents of a file with secrets
+// NOTE: recordings are already compressed, so they are stored without compressing them again
+func addExportFile(zw *zip.Writer, path, name string, masked func() (interface{}, error)) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	if strings.HasPrefix(name, "recordings/") {
+		header.Method = zip.Store
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if masked != nil {
+		contents, err := masked()
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(contents)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// uploadDataExport streams a data export archive to an upload target, so that recordings never have to fit in memory
+func uploadDataExport(ctx context.Context, uploadURL string, body io.Reader) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", uploadURL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/zip")
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return fmt.Errorf("upload failed: status=%d", r.StatusCode)
+	}
+	return nil
+}
+
+// runExport uploads the data export returned by collect, and then calls purge with the files owned by the
+// agent once the upload succeeds
+func runExport(ctx context.Context, uploadURL string, collect func() (DataExport, error), purge func(owned []string) error) (report client.DataExportReport) {
+	defer func() { report.Timestamp = time.Now() }()
+
+	if uploadURL == "" {
+		report.Error = "missing upload URL"
+		return report
+	}
+	if !atomic.CompareAndSwapInt32(&exportRunning, 0, 1) {
+		report.Error = errExportRunning.Error()
+		return report
+	}
+	defer atomic.StoreInt32(&exportRunning, 0)
+
+	export, err := collect()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+
+	uploadCtx, cancel := context.WithTimeout(ctx, ExportTimeout)
+	defer cancel()
+	pr, pw := io.Pipe()
+	body := &countingWriter{w: pw}
+	written := make(chan error, 1)
+	go func() {
+		var err error
+		report.Files, err = writeDataExport(body, export)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+	err = uploadDataExport(uploadCtx, uploadURL, pr)
+	pr.CloseWithError(errors.New("upload finished"))
+	if writeErr := <-written; writeErr != nil && err == nil {
+		err = writeErr
+	}
+	report.Size = body.n
+	if err != nil {
+		report.Files = nil
+		report.Error = err.Error()
+		return report
+	}
+	report.Uploaded = true
+
+	if err := purge(export.Owned); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Purged = true
+	log.Info("Exported and purged local data", "files", len(report.Files), "size", report.Size)
+	return report
+}
@@ -0,0 +1,153 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// FaustServiceName is the name of the systemd service that runs the compiled Faust program
+	FaustServiceName = "faust.service"
+
+	// Faust2JackPath is the path to the script that compiles Faust code into a JACK program
+	Faust2JackPath = "/usr/bin/faust2jack"
+
+	// FaustClientName is the JACK client name of the compiled Faust program, which is named after its source file
+	FaustClientName = "faust"
+)
+
+// getFaustOutputPorts returns the JACK output ports of the Faust program that the recorder should capture;
+// faust2jack numbers its ports from 0
+func getFaustOutputPorts(config client.ServerAgentConfig) []string {
+	ports := make([]string, client.GetChannelLayout(config).Channels())
+	for i := range ports {
+		ports[i] = fmt.Sprintf("%s:out_%d", FaustClientName, i)
+	}
+	return ports
+}
+
+// getMixerOutputPorts returns the JACK output ports of the mixing backend used by a config
+func getMixerOutputPorts(config client.ServerAgentConfig) []string {
+	if client.GetMixEngine(config) == client.FaustEngine {
+		return getFaustOutputPorts(config)
+	}
+	return client.GetBroadcastPorts(config, SuperColliderClientName)
+}
+
+// compileFaust compiles the Faust code at PathToFaustDSP into PathToFaustProgram
+func compileFaust() error {
+	if _, err := systemRunner.Output(Faust2JackPath, PathToFaustDSP); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return fmt.Errorf("unable to compile Faust code: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return fmt.Errorf("unable to compile Faust code: %w", err)
+	}
+	return nil
+}
+
+// FaustMixer compiles the Faust code of a config and runs it as an alternative to the SuperCollider mixer
+type FaustMixer struct {
+	// writeDropIn writes the systemd drop-in that sandboxes a service
+	writeDropIn func(serviceName string, limits common.SandboxLimits) error
+
+	limits  *common.SandboxLimits
+	running bool
+	lastErr string
+	mutex   sync.Mutex
+}
+
+// NewFaustMixer constructs a new instance of FaustMixer
+func NewFaustMixer() *FaustMixer {
+	return &FaustMixer{writeDropIn: common.WriteSandboxDropIn}
+}
+
+// Apply compiles and restarts the Faust program when its code changes, or stops it when a config uses
+// another mixing backend; the running program is left alone if new code fails to compile
+func (m *FaustMixer) Apply(config client.ServerAgentConfig) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	err := m.apply(config)
+	m.lastErr = ""
+	if err != nil {
+		m.lastErr = err.Error()
+	}
+	return err
+}
+
+// apply updates the Faust service for a config; callers must hold the lock
+func (m *FaustMixer) apply(config client.ServerAgentConfig) error {
+	if client.GetMixEngine(config) != client.FaustEngine {
+		if !m.running {
+			return nil
+		}
+		log.Info("Stopping Faust mixer")
+		m.running = false
+		return serviceManager.Stop(FaustServiceName)
+	}
+
+	changed, err := common.WriteFileIfChanged(PathToFaustDSP, []byte(config.FaustCode), 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(PathToFaustProgram); changed || err != nil {
+		if err := compileFaust(); err != nil {
+			// remove the code so that it is compiled again for the next config
+			os.Remove(PathToFaustDSP)
+			return err
+		}
+		changed = true
+	}
+	if limits := getMixSandboxLimits(config); m.limits == nil || *m.limits != limits {
+		if err := writeSandboxDropIns(m.writeDropIn, limits, FaustServiceName); err != nil {
+			return err
+		}
+		m.limits = &limits
+		changed = true
+	}
+	if !changed && m.running {
+		return nil
+	}
+
+	log.Info("Restarting Faust mixer")
+	m.running = false
+	if err := serviceManager.Stop(FaustServiceName); err != nil {
+		return err
+	}
+	if err := serviceManager.Start(FaustServiceName); err != nil {
+		return err
+	}
+	m.running = true
+	return nil
+}
+
+// Error returns the error from the last config that could not be applied, for the MixCodeError of server heartbeats
+func (m *FaustMixer) Error() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.lastErr
+}
@@ -0,0 +1,97 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMixerOutputPorts(t *testing.T) {
+	assert := assert.New(t)
+	config := client.ServerAgentConfig{}
+	assert.Equal([]string{"SuperCollider:out_1", "SuperCollider:out_2"}, getMixerOutputPorts(config))
+	config.MixEngine = client.FaustEngine
+	assert.Equal([]string{"faust:out_0", "faust:out_1"}, getMixerOutputPorts(config))
+}
+
+func TestFaustMixer(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prevServices ServiceManager, prevRunner SystemRunner, dir string) {
+		serviceManager, systemRunner, ServiceConfigDir = prevServices, prevRunner, dir
+		updatePaths()
+	}(serviceManager, systemRunner, ServiceConfigDir)
+	services := NewFakeServiceManager()
+	serviceManager = services
+	runner := NewFakeRunner()
+	systemRunner = runner
+	ServiceConfigDir = t.TempDir()
+	updatePaths()
+	compile := Faust2JackPath + " " + PathToFaustDSP
+	dropIns := map[string]common.SandboxLimits{}
+	mixer := NewFaustMixer()
+	mixer.writeDropIn = func(serviceName string, limits common.SandboxLimits) error {
+		dropIns[serviceName] = limits
+		return nil
+	}
+
+	// Case for the SuperCollider engine, which leaves the Faust mixer stopped
+	assert.Nil(mixer.Apply(client.ServerAgentConfig{}))
+	assert.Empty(runner.Commands)
+	assert.Empty(services.Events)
+
+	// Case for new Faust code, which is compiled and started
+	config := client.ServerAgentConfig{MixEngine: client.FaustEngine, FaustCode: "process = _,_;"}
+	assert.Nil(mixer.Apply(config))
+	assert.Equal([]string{compile, SystemctlPath + " daemon-reload"}, runner.Commands)
+	assert.Equal([]string{"start " + FaustServiceName}, services.Events)
+	assert.Equal(common.SandboxLimits{NoNetwork: true, ConfigDir: ServiceConfigDir}, dropIns[FaustServiceName])
+	assert.Nil(ioutil.WriteFile(PathToFaustProgram, []byte("program"), 0755))
+
+	// Case for unchanged code
+	runner.Commands, services.Events = nil, nil
+	assert.Nil(mixer.Apply(config))
+	assert.Empty(runner.Commands)
+	assert.Empty(services.Events)
+
+	// Case for new limits, which restart the program
+	config.MixCPUQuota = 150
+	assert.Nil(mixer.Apply(config))
+	assert.Equal([]string{SystemctlPath + " daemon-reload"}, runner.Commands)
+	assert.Equal([]string{"stop " + FaustServiceName, "start " + FaustServiceName}, services.Events)
+	assert.Equal(150, dropIns[FaustServiceName].CPUQuota)
+	services.Events = nil
+
+	// Case for code that fails to compile, which keeps the previous program running
+	config.FaustCode = "process = ;"
+	runner.Errors[compile] = errors.New("exit status 1")
+	assert.NotNil(mixer.Apply(config))
+	assert.Contains(mixer.Error(), "unable to compile Faust code")
+	assert.Empty(services.Events)
+	assert.NoFileExists(PathToFaustDSP)
+
+	// Case for switching back to SuperCollider
+	assert.Nil(mixer.Apply(client.ServerAgentConfig{}))
+	assert.Equal([]string{"stop " + FaustServiceName}, services.Events)
+	assert.Empty(mixer.Error())
+}
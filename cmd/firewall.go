@@ -0,0 +1,97 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"os"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// MDNSPort is used to announce devices on the local network
+	MDNSPort = 5353
+
+	// DHCPClientPort receives address leases for the device
+	DHCPClientPort = 68
+
+	// DHCPv6ClientPort receives IPv6 address leases for the device
+	DHCPv6ClientPort = 546
+
+	// PTPEventPort and PTPGeneralPort receive PTP clock messages
+	PTPEventPort   = 319
+	PTPGeneralPort = 320
+)
+
+// getDeviceFirewallRules returns the ports a device needs to accept traffic on
+// NOTE: replies from studio servers (including Jamulus) are accepted as part of connections the device opens
+func getDeviceFirewallRules(config client.DeviceAgentConfig) common.FirewallRules {
+	rules := common.FirewallRules{
+		TCPPorts:      []int{common.SSHPort},
+		UDPPorts:      []int{MDNSPort, DHCPClientPort, DHCPv6ClientPort},
+		HTTPPorts:     []int{80},
+		HTTPRateLimit: getLocalRateLimit(config),
+	}
+	if port := getListenPort(*tlsAddressFlag); port > 0 {
+		rules.HTTPPorts = append(rules.HTTPPorts, port)
+	}
+	if usesJackTrip(config) && config.DevicePort > 0 {
+		rules.UDPPorts = append(rules.UDPPorts, config.DevicePort)
+	}
+	if isAES67Enabled(config) {
+		rules.UDPPorts = append(rules.UDPPorts, getAES67Port(config), PTPEventPort, PTPGeneralPort)
+	} else if config.ClockSync == client.ClockSyncPTP {
+		rules.UDPPorts = append(rules.UDPPorts, PTPEventPort, PTPGeneralPort)
+	}
+	return rules
+}
+
+// updateFirewall applies the managed firewall rules when they change, or removes them when the firewall is disabled
+func updateFirewall(config client.DeviceAgentConfig) {
+	if !config.Firewall {
+		if _, err := os.Stat(PathToFirewallRules); os.IsNotExist(err) {
+			return
+		}
+		log.Info("Removing managed firewall rules")
+		if _, err := runPrivileged(NFTPath, "delete", "table", "inet", common.FirewallTable); err != nil {
+			log.Error(err, "Unable to remove managed firewall rules")
+			return
+		}
+		if err := os.Remove(PathToFirewallRules); err != nil {
+			log.Error(err, "Unable to remove firewall rules", "path", PathToFirewallRules)
+		}
+		return
+	}
+
+	ruleset := common.GetNFTablesRuleset(getDeviceFirewallRules(config))
+	changed, err := common.WriteFileIfChanged(PathToFirewallRules, []byte(ruleset), 0644)
+	if err != nil {
+		log.Error(err, "Unable to save firewall rules", "path", PathToFirewallRules)
+		return
+	}
+	if !changed {
+		return
+	}
+	log.Info("Applying managed firewall rules")
+	if _, err := runPrivileged(NFTPath, "-f", PathToFirewallRules); err != nil {
+		log.Error(err, "Unable to apply managed firewall rules")
+		// remove the rules, so that they are applied again with the next config update
+		os.Remove(PathToFirewallRules)
+	}
+}
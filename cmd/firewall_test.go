@@ -0,0 +1,104 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDeviceFirewallRules(t *testing.T) {
+	assert := assert.New(t)
+	config := client.DeviceAgentConfig{}
+	config.Type = client.JackTrip
+	config.DevicePort = 4464
+	config.LocalRateLimit = 30
+
+	rules := getDeviceFirewallRules(config)
+	assert.Equal([]int{common.SSHPort}, rules.TCPPorts)
+	assert.Equal([]int{80}, rules.HTTPPorts)
+	assert.Equal([]int{MDNSPort, DHCPClientPort, DHCPv6ClientPort, 4464}, rules.UDPPorts)
+	assert.Equal(30, rules.HTTPRateLimit)
+
+	// Case for Jamulus, which only receives replies to the connection opened by the device
+	config.Type = client.Jamulus
+	assert.Equal([]int{MDNSPort, DHCPClientPort, DHCPv6ClientPort}, getDeviceFirewallRules(config).UDPPorts)
+
+	// Case for PTP clock sync
+	config.ClockSync = client.ClockSyncPTP
+	assert.Equal([]int{MDNSPort, DHCPClientPort, DHCPv6ClientPort, PTPEventPort, PTPGeneralPort}, getDeviceFirewallRules(config).UDPPorts)
+
+	// Case for AES67 streams
+	config.Type = client.JackTrip
+	config.AES67Enabled = true
+	assert.Equal([]int{MDNSPort, DHCPClientPort, DHCPv6ClientPort, 4464, DefaultAES67Port, PTPEventPort, PTPGeneralPort}, getDeviceFirewallRules(config).UDPPorts)
+}
+
+func TestUpdateFirewall(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prev SystemRunner, dir string) {
+		systemRunner, ServiceConfigDir = prev, dir
+		updatePaths()
+	}(systemRunner, ServiceConfigDir)
+	runner := NewFakeRunner()
+	systemRunner = runner
+	ServiceConfigDir = t.TempDir()
+	updatePaths()
+	config := client.DeviceAgentConfig{}
+	config.Type = client.JackTrip
+	config.DevicePort = 4464
+
+	// Case for firewall disabled, which leaves the host rules alone
+	updateFirewall(config)
+	assert.Empty(runner.Commands)
+
+	// Case for enabling the firewall
+	config.Firewall = true
+	updateFirewall(config)
+	assert.Equal([]string{"nft -f " + PathToFirewallRules}, runner.Commands)
+	assert.FileExists(PathToFirewallRules)
+
+	// Case for unchanged rules
+	updateFirewall(config)
+	assert.Len(runner.Commands, 1)
+
+	// Case for a new device port
+	config.DevicePort = 4465
+	updateFirewall(config)
+	assert.Len(runner.Commands, 2)
+
+	// Case for rules that fail to apply, which are retried with the next update
+	config.DevicePort = 4466
+	runner.Errors["nft -f "+PathToFirewallRules] = errors.New("syntax error")
+	updateFirewall(config)
+	assert.NoFileExists(PathToFirewallRules)
+	delete(runner.Errors, "nft -f "+PathToFirewallRules)
+	updateFirewall(config)
+	assert.Len(runner.Commands, 4)
+	assert.FileExists(PathToFirewallRules)
+
+	// Case for disabling the firewall
+	config.Firewall = false
+	updateFirewall(config)
+	assert.Equal("nft delete table inet jacktrip_agent", runner.Commands[4])
+	assert.NoFileExists(PathToFirewallRules)
+}
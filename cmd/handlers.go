@@ -16,16 +16,29 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
 )
 
+// ConnectedClient describes a client connected to the audio server
+type ConnectedClient struct {
+	common.RosterClient
+
+	// Audio server channels assigned to the client
+	ServerChannels []int `json:"serverChannels"`
+}
+
 // runHTTPServer runs the agent's HTTP server
 func runHTTPServer(wg *sync.WaitGroup, router *mux.Router, address string) *http.Server {
 	log.Info("Starting agent HTTP server")
@@ -33,7 +46,9 @@ func runHTTPServer(wg *sync.WaitGroup, router *mux.Router, address string) *http
 
 	go func() {
 		defer wg.Done()
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		if err := srv.ListenAndServe(); errors.Is(err, syscall.EADDRINUSE) {
+			log.Error(&PortConflict{Network: "tcp", Port: getListenPort(address), Owner: findPortOwner("tcp", getListenPort(address))}, "HTTP server error")
+		} else if err != http.ErrServerClosed {
 			log.Error(err, "HTTP server error")
 		}
 	}()
@@ -42,6 +57,26 @@ func runHTTPServer(wg *sync.WaitGroup, router *mux.Router, address string) *http
 	return srv
 }
 
+// runHTTPSServer runs the agent's HTTP server over TLS
+func runHTTPSServer(wg *sync.WaitGroup, router *mux.Router, address string, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *http.Server {
+	log.Info("Starting agent HTTPS server", "address", address)
+	srv := &http.Server{
+		Addr:      address,
+		Handler:   router,
+		TLSConfig: &tls.Config{GetCertificate: getCertificate, MinVersion: tls.VersionTLS12},
+	}
+
+	go func() {
+		defer wg.Done()
+		if err := srv.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+			log.Error(err, "HTTPS server error")
+		}
+	}()
+
+	log.Info("Successfully started agent HTTPS server")
+	return srv
+}
+
 // shutdownHTTPServer gracefully terminates the HTTP server
 func shutdownHTTPServer(server *http.Server) {
 	// use a separate context to enforce server shutdown within time limit
@@ -54,6 +89,20 @@ func shutdownHTTPServer(server *http.Server) {
 	}
 }
 
+// isSameOrigin checks if a websocket request was opened by a page served from the host it connects to
+// NOTE: clients that are not browsers, such as apps on the LAN, do not send an Origin header
+func isSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
 // handlePingRequest upgrades ping request to a websocket responder
 func handlePingRequest(w http.ResponseWriter, r *http.Request) {
 	// return success if no request for websocket
@@ -91,6 +140,25 @@ func handlePingRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleClientsRequest returns the clients currently connected to the audio server
+func handleClientsRequest(roster *common.ClientRoster, ac *AutoConnector, w http.ResponseWriter, r *http.Request) {
+	ac.ClientLock.Lock()
+	defer ac.ClientLock.Unlock()
+
+	clients := []ConnectedClient{}
+	for _, c := range roster.Clients() {
+		serverChannels := []int{}
+		for i := 1; i <= c.Channels; i++ {
+			// clients are only assigned channels by the autoconnector, so listing them never changes assignments
+			if serverChannel, ok := ac.lookupServerChannel(c.Name, i); ok {
+				serverChannels = append(serverChannels, serverChannel)
+			}
+		}
+		clients = append(clients, ConnectedClient{RosterClient: c, ServerChannels: serverChannels})
+	}
+	RespondJSON(w, http.StatusOK, clients)
+}
+
 // OptionsGetOnly responds with a list of allow methods for Get only
 func OptionsGetOnly(w http.ResponseWriter, r *http.Request) {
 	allowMethods := "GET, OPTIONS"
@@ -18,6 +18,9 @@ import (
 	"io/ioutil"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -66,6 +69,52 @@ func TestHandlePingRequestNoWS(t *testing.T) {
 	}
 }
 
+func TestIsSameOrigin(t *testing.T) {
+	assert := assert.New(t)
+	mockReq := httptest.NewRequest("GET", "http://device.local/mix", nil)
+	assert.True(isSameOrigin(mockReq))
+	mockReq.Header.Set("Origin", "http://DEVICE.local")
+	assert.True(isSameOrigin(mockReq))
+	mockReq.Header.Set("Origin", "https://evil.example.com")
+	assert.False(isSameOrigin(mockReq))
+	mockReq.Header.Set("Origin", "http://device.local:8080")
+	assert.False(isSameOrigin(mockReq))
+}
+
+func TestHandleClientsRequest(t *testing.T) {
+	assert := assert.New(t)
+	roster := common.NewClientRoster()
+	ac := NewAutoConnector()
+
+	// Case for no connected clients
+	mockResp := httptest.NewRecorder()
+	mockReq := httptest.NewRequest("GET", "http://example.com/clients", nil)
+	handleClientsRequest(roster, ac, mockResp, mockReq)
+	resp := mockResp.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(200, resp.StatusCode)
+	assert.Equal(`[]`, string(body))
+
+	// Case for connected clients
+	now := time.Date(2022, 4, 9, 13, 0, 0, 0, time.UTC)
+	roster.Update([]string{"alice:receive_1", "alice:receive_2"}, now)
+	mockResp = httptest.NewRecorder()
+	handleClientsRequest(roster, ac, mockResp, mockReq)
+	resp = mockResp.Result()
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(200, resp.StatusCode)
+	assert.Equal(`[{"name":"alice","channels":2,"connectedAt":"2022-04-09T13:00:00Z","serverChannels":[]}]`, string(body))
+	assert.Equal(1, len(ac.KnownClients))
+
+	// Case for clients whose ports were connected by the autoconnector
+	ac.getServerChannel("alice", 1)
+	mockResp = httptest.NewRecorder()
+	handleClientsRequest(roster, ac, mockResp, mockReq)
+	resp = mockResp.Result()
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(`[{"name":"alice","channels":2,"connectedAt":"2022-04-09T13:00:00Z","serverChannels":[3,4]}]`, string(body))
+}
+
 func TestOptionsGetOnly(t *testing.T) {
 	assert := assert.New(t)
 	// Instantiate mock response writer and request to exercise method
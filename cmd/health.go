@@ -0,0 +1,73 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// HealthResponse is returned by health and readiness probes
+type HealthResponse struct {
+	Status     string                        `json:"status"`
+	Failures   []string                      `json:"failures"`
+	Subsystems map[Subsystem]SubsystemStatus `json:"subsystems"`
+}
+
+// readySubsystemStatuses are the statuses each subsystem must report while connected to a studio
+var readySubsystemStatuses = map[Subsystem]string{
+	ServicesSubsystem:      "running",
+	AutoConnectorSubsystem: "connected",
+	MixerSubsystem:         "running",
+}
+
+// getReadinessFailures returns the reasons the agent is not ready to serve a studio
+func getReadinessFailures(snapshot StateSnapshot) []string {
+	failures := []string{}
+	if !snapshot.Configured {
+		return append(failures, "no config has been applied")
+	}
+	if !snapshot.Enabled || snapshot.Host == "" {
+		return failures
+	}
+	for _, subsystem := range []Subsystem{ServicesSubsystem, AutoConnectorSubsystem, MixerSubsystem} {
+		status := snapshot.Subsystems[subsystem].Status
+		if status != readySubsystemStatuses[subsystem] {
+			failures = append(failures, fmt.Sprintf("%s is %q", subsystem, status))
+		}
+	}
+	return failures
+}
+
+// handleHealthzRequest reports that the agent is alive, along with the status of each subsystem
+func handleHealthzRequest(w http.ResponseWriter, r *http.Request) {
+	snapshot := deviceState.Snapshot()
+	RespondJSON(w, http.StatusOK, HealthResponse{Status: "ok", Failures: []string{}, Subsystems: snapshot.Subsystems})
+}
+
+// handleReadyzRequest reports whether the agent has applied its config and all required subsystems are up
+func handleReadyzRequest(w http.ResponseWriter, r *http.Request) {
+	snapshot := deviceState.Snapshot()
+	resp := HealthResponse{Status: "ok", Failures: getReadinessFailures(snapshot), Subsystems: snapshot.Subsystems}
+	status := http.StatusOK
+	if len(resp.Failures) > 0 {
+		resp.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+	RespondJSON(w, status, resp)
+}
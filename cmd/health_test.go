@@ -0,0 +1,77 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetReadinessFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for no config yet
+	assert.Equal([]string{"no config has been applied"}, getReadinessFailures(StateSnapshot{}))
+
+	// Case for a disabled device, which is ready without any subsystems
+	assert.Equal([]string{}, getReadinessFailures(StateSnapshot{Configured: true}))
+
+	// Case for a connected device with subsystems down
+	snapshot := StateSnapshot{
+		Configured: true,
+		Enabled:    true,
+		Host:       "a.b.com",
+		Subsystems: map[Subsystem]SubsystemStatus{
+			ServicesSubsystem: {Status: "running"},
+			MixerSubsystem:    {Status: "suspended"},
+		},
+	}
+	assert.Equal([]string{`autoconnector is ""`, `mixer is "suspended"`}, getReadinessFailures(snapshot))
+
+	// Case for a connected device with all subsystems up
+	snapshot.Subsystems[AutoConnectorSubsystem] = SubsystemStatus{Status: "connected"}
+	snapshot.Subsystems[MixerSubsystem] = SubsystemStatus{Status: "running"}
+	assert.Equal([]string{}, getReadinessFailures(snapshot))
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	assert := assert.New(t)
+	saved := deviceState
+	deviceState = NewStateStore()
+	defer func() { deviceState = saved }()
+
+	mockResp := httptest.NewRecorder()
+	handleHealthzRequest(mockResp, httptest.NewRequest("GET", "http://example.com/healthz", nil))
+	assert.Equal(200, mockResp.Code)
+
+	// Case for no config yet
+	mockResp = httptest.NewRecorder()
+	handleReadyzRequest(mockResp, httptest.NewRequest("GET", "http://example.com/readyz", nil))
+	assert.Equal(503, mockResp.Code)
+	assert.Contains(mockResp.Body.String(), "no config has been applied")
+
+	// Case for a disabled device
+	deviceState.SetConfig(client.DeviceAgentConfig{})
+	mockResp = httptest.NewRecorder()
+	handleReadyzRequest(mockResp, httptest.NewRequest("GET", "http://example.com/readyz", nil))
+	assert.Equal(200, mockResp.Code)
+}
@@ -0,0 +1,67 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+// matchOrigin checks if a web origin matches an allowed origin, which may be "*" or use a wildcard subdomain (ie. "https://*.example.com")
+func matchOrigin(allowed, origin string) bool {
+	if allowed == "*" || strings.EqualFold(allowed, origin) {
+		return true
+	}
+	splits := strings.SplitN(allowed, "*.", 2)
+	if len(splits) != 2 || !strings.HasSuffix(splits[0], "://") {
+		return false
+	}
+	prefix, suffix := strings.ToLower(splits[0]), "."+strings.ToLower(splits[1])
+	origin = strings.ToLower(origin)
+	return strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) && len(origin) > len(prefix)+len(suffix)
+}
+
+// checkHLSOrigin checks if a web origin is allowed to play the HLS broadcasts of a server
+func checkHLSOrigin(config client.ServerAgentConfig, origin string) bool {
+	for _, allowed := range client.GetHLSAllowedOrigins(config) {
+		if matchOrigin(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// setHLSCORSHeaders adds CORS headers to an HLS response, returning false if the request came from a web origin that is not allowed
+func setHLSCORSHeaders(config client.ServerAgentConfig, w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// requests from players that are not browsers do not need CORS headers
+		return true
+	}
+	if !checkHLSOrigin(config, origin) {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Range")
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range")
+	return true
+}
@@ -0,0 +1,77 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchOrigin(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(matchOrigin("*", "https://anything.example.com"))
+	assert.True(matchOrigin("https://radio.example.com", "https://Radio.example.com"))
+	assert.False(matchOrigin("https://radio.example.com", "http://radio.example.com"))
+	assert.True(matchOrigin("https://*.example.com", "https://radio.example.com"))
+	assert.True(matchOrigin("https://*.example.com", "https://a.b.example.com"))
+	assert.False(matchOrigin("https://*.example.com", "https://example.com"))
+	assert.False(matchOrigin("https://*.example.com", "https://.example.com"))
+	assert.False(matchOrigin("https://*.example.com", "https://evilexample.com"))
+	assert.False(matchOrigin("https://*.example.com", "http://radio.example.com"))
+	assert.False(matchOrigin("https://radio*.example.com", "https://radio1.example.com"))
+}
+
+func TestCheckHLSOrigin(t *testing.T) {
+	assert := assert.New(t)
+	config := client.ServerAgentConfig{}
+	assert.True(checkHLSOrigin(config, "https://app.jacktrip.org"))
+	assert.False(checkHLSOrigin(config, "http://localhost:3000"))
+
+	config.HLSAllowedOrigins = "http://localhost:3000,https://*.example.com"
+	assert.True(checkHLSOrigin(config, "http://localhost:3000"))
+	assert.True(checkHLSOrigin(config, "https://radio.example.com"))
+	assert.False(checkHLSOrigin(config, "https://app.jacktrip.org"))
+}
+
+func TestSetHLSCORSHeaders(t *testing.T) {
+	assert := assert.New(t)
+	config := client.ServerAgentConfig{}
+	config.HLSAllowedOrigins = "https://radio.example.com"
+
+	// Case for players that are not browsers
+	resp := httptest.NewRecorder()
+	assert.True(setHLSCORSHeaders(config, resp, httptest.NewRequest("GET", "http://example.com/hls/stream.m3u8", nil)))
+	assert.Equal("", resp.Header().Get("Access-Control-Allow-Origin"))
+
+	// Case for an allowed origin
+	req := httptest.NewRequest("GET", "http://example.com/hls/stream.m3u8", nil)
+	req.Header.Set("Origin", "https://radio.example.com")
+	resp = httptest.NewRecorder()
+	assert.True(setHLSCORSHeaders(config, resp, req))
+	assert.Equal("https://radio.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("Origin", resp.Header().Get("Vary"))
+
+	// Case for an origin that is not allowed
+	req.Header.Set("Origin", "https://other.example.com")
+	resp = httptest.NewRecorder()
+	assert.False(setHLSCORSHeaders(config, resp, req))
+	assert.Equal("", resp.Header().Get("Access-Control-Allow-Origin"))
+}
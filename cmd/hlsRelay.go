@@ -0,0 +1,162 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// HLSRelayInterval is how often new HLS files are pushed to the API
+	HLSRelayInterval = time.Second
+)
+
+// HLSRelay pushes HLS playlists and segments to the API instead of serving them locally
+type HLSRelay struct {
+	Dir       string
+	APIClient *api.Client
+
+	// Markers returns HLS tags that are added to playlists before they are uploaded
+	Markers func() []string
+
+	uploaded map[string]time.Time
+	mutex    sync.Mutex
+}
+
+// NewHLSRelay constructs a new instance of HLSRelay
+func NewHLSRelay(dir string, apiClient *api.Client) *HLSRelay {
+	return &HLSRelay{
+		Dir:       dir,
+		APIClient: apiClient,
+		uploaded:  map[string]time.Time{},
+	}
+}
+
+// isHLSRelayEnabled checks if HLS files should be pushed to the API for a server config
+func isHLSRelayEnabled(config client.ServerAgentConfig) bool {
+	return client.IsHLSEnabled(config) && client.GetHLSDelivery(config) == client.HLSDeliveryRelay
+}
+
+// getHLSContentType returns the content type of an HLS file, or an empty string if it is not one
+func getHLSContentType(name string) string {
+	switch filepath.Ext(name) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".m4s", ".mp4":
+		return "video/mp4"
+	case ".aac":
+		return "audio/aac"
+	}
+	return ""
+}
+
+// upload sends a single HLS file to the API
+func (h *HLSRelay) upload(ctx context.Context, name, contentType string) error {
+	body, err := ioutil.ReadFile(filepath.Join(h.Dir, name))
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(name) == ".m3u8" && h.Markers != nil {
+		body = addPlaylistMarkers(body, h.Markers())
+	}
+	return h.APIClient.UploadHLSFile(ctx, name, contentType, body)
+}
+
+// Sync uploads all new or modified HLS files, and deletes files that were rotated out, returning the number
+// of files uploaded
+// NOTE: playlists are uploaded last so that they never reference segments the API does not have yet, and
+// rotated files are deleted after the playlists that stopped referencing them
+func (h *HLSRelay) Sync(ctx context.Context) (int, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	files, err := ioutil.ReadDir(h.Dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var segments, playlists []string
+	current := map[string]time.Time{}
+	for _, f := range files {
+		if f.IsDir() || getHLSContentType(f.Name()) == "" {
+			continue
+		}
+		current[f.Name()] = f.ModTime()
+		if last, ok := h.uploaded[f.Name()]; ok && last.Equal(f.ModTime()) {
+			continue
+		}
+		if strings.HasSuffix(f.Name(), ".m3u8") {
+			playlists = append(playlists, f.Name())
+		} else {
+			segments = append(segments, f.Name())
+		}
+	}
+	sort.Strings(segments)
+	sort.Strings(playlists)
+
+	count := 0
+	for _, name := range append(segments, playlists...) {
+		if err := h.upload(ctx, name, getHLSContentType(name)); err != nil {
+			return count, err
+		}
+		h.uploaded[name] = current[name]
+		count++
+	}
+
+	// delete files that were rotated out of the playlist, so that the API does not keep every segment;
+	// files that fail to delete are retried on the next sync
+	for name := range h.uploaded {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		if err := h.APIClient.DeleteHLSFile(ctx, name); err != nil {
+			return count, err
+		}
+		delete(h.uploaded, name)
+	}
+	return count, nil
+}
+
+// Run pushes HLS files to the API until the context is cancelled
+func (h *HLSRelay) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(HLSRelayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping HLS relay")
+			return
+		case <-ticker.C:
+			if _, err := h.Sync(ctx); err != nil {
+				log.Error(err, "Failed to relay HLS files")
+			}
+		}
+	}
+}
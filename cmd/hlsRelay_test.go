@@ -0,0 +1,133 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsHLSRelayEnabled(t *testing.T) {
+	assert := assert.New(t)
+	config := client.ServerAgentConfig{Broadcast: client.BroadcastPublicWOStemWOVideo, HLSDelivery: client.HLSDeliveryRelay}
+	assert.True(isHLSRelayEnabled(config))
+	config.HLSDelivery = client.HLSDeliveryLocal
+	assert.False(isHLSRelayEnabled(config))
+	config.HLSDelivery = client.HLSDeliveryRelay
+	config.Broadcast = client.PrivateRecordWOStemWOVideo
+	assert.False(isHLSRelayEnabled(config))
+}
+
+func TestGetHLSContentType(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("application/vnd.apple.mpegurl", getHLSContentType("live.m3u8"))
+	assert.Equal("video/mp2t", getHLSContentType("seg1.ts"))
+	assert.Equal("", getHLSContentType("notes.txt"))
+}
+
+func TestHLSRelaySync(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "hls")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	var mutex sync.Mutex
+	var uploads, deletes []string
+	var playlist []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Header.Get("APISecret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "DELETE" {
+			deletes = append(deletes, r.URL.Path)
+		} else {
+			uploads = append(uploads, r.URL.Path)
+		}
+		if strings.HasSuffix(r.URL.Path, ".m3u8") {
+			playlist, _ = ioutil.ReadAll(r.Body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	ioutil.WriteFile(filepath.Join(dir, "live.m3u8"), []byte("#EXTM3U\n#EXT-X-PROGRAM-DATE-TIME:2022-05-01T20:00:00.000Z\n#EXTINF:4.0,\nseg1.ts\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "seg2.ts"), []byte("2"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "seg1.ts"), []byte("1"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644)
+
+	apiClient := api.NewClient(server.URL, client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}, nil)
+	apiClient.Retries = 0
+	relay := NewHLSRelay(dir, apiClient)
+	relay.Markers = func() []string { return []string{"#EXT-X-DATERANGE:ID=\"marker-1\""} }
+	count, err := relay.Sync(context.Background())
+	assert.Nil(err)
+	assert.Equal(3, count)
+	// playlists must be uploaded after their segments
+	assert.Equal([]string{"/agents/hls/seg1.ts", "/agents/hls/seg2.ts", "/agents/hls/live.m3u8"}, uploads)
+	assert.Contains(string(playlist), "#EXT-X-DATERANGE:ID=\"marker-1\"\n#EXTINF:4.0,")
+
+	// Case for nothing changed
+	count, err = relay.Sync(context.Background())
+	assert.Nil(err)
+	assert.Equal(0, count)
+
+	// Case for a rotated segment and updated playlist
+	os.Remove(filepath.Join(dir, "seg1.ts"))
+	ioutil.WriteFile(filepath.Join(dir, "seg3.ts"), []byte("3"), 0644)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "live.m3u8"), later, later)
+	count, err = relay.Sync(context.Background())
+	assert.Nil(err)
+	assert.Equal(2, count)
+	assert.Equal("/agents/hls/live.m3u8", uploads[len(uploads)-1])
+	assert.Equal(3, len(relay.uploaded))
+	// the rotated segment is deleted from the API too
+	assert.Equal([]string{"/agents/hls/seg1.ts"}, deletes)
+
+	// Case for a failed delete, which is retried on the next sync
+	apiClient.Credentials.APISecret = "wrong"
+	os.Remove(filepath.Join(dir, "seg2.ts"))
+	_, err = relay.Sync(context.Background())
+	assert.NotNil(err)
+	apiClient.Credentials.APISecret = "secret"
+	count, err = relay.Sync(context.Background())
+	assert.Nil(err)
+	assert.Equal(0, count)
+	assert.Equal([]string{"/agents/hls/seg1.ts", "/agents/hls/seg2.ts"}, deletes)
+
+	// Case for rejected uploads
+	apiClient.Credentials.APISecret = "wrong"
+	ioutil.WriteFile(filepath.Join(dir, "seg4.ts"), []byte("4"), 0644)
+	_, err = relay.Sync(context.Background())
+	assert.NotNil(err)
+}
@@ -0,0 +1,195 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+var (
+	// SysClassNetDir is the directory containing network interfaces, via Linux kernel
+	SysClassNetDir = "/sys/class/net"
+
+	// PathToMachineID is the path to the unique machine ID generated by systemd
+	PathToMachineID = "/etc/machine-id"
+)
+
+// readInterfaceMAC returns the MAC address of a network interface, or an error if it does not have a usable one
+func readInterfaceMAC(iface string) (string, error) {
+	macBytes, err := ioutil.ReadFile(filepath.Join(SysClassNetDir, iface, "address"))
+	if err != nil {
+		return "", err
+	}
+
+	// trim whitespace and convert to lowercase
+	mac := strings.ToLower(strings.TrimSpace(string(macBytes)))
+	if mac == "" || mac == "00:00:00:00:00:00" {
+		return "", fmt.Errorf("interface %s does not have a MAC address", iface)
+	}
+	return mac, nil
+}
+
+// isPhysicalInterface returns true if a network interface is backed by hardware, unlike loopback, bridges or VPNs
+func isPhysicalInterface(iface string) bool {
+	_, err := os.Stat(filepath.Join(SysClassNetDir, iface, "device"))
+	return err == nil
+}
+
+// getPhysicalInterfaceMAC returns the MAC address of the first physical network interface, sorted by name
+func getPhysicalInterfaceMAC() (string, error) {
+	entries, err := ioutil.ReadDir(SysClassNetDir)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	for _, iface := range names {
+		if !isPhysicalInterface(iface) {
+			continue
+		}
+		if mac, err := readInterfaceMAC(iface); err == nil {
+			return mac, nil
+		}
+	}
+	return "", errors.New("no physical network interfaces found")
+}
+
+// getMachineIDMAC derives a stable, locally administered unicast MAC address from /etc/machine-id
+func getMachineIDMAC() (string, error) {
+	rawBytes, err := ioutil.ReadFile(PathToMachineID)
+	if err != nil {
+		return "", err
+	}
+	id, err := hex.DecodeString(strings.TrimSpace(string(rawBytes)))
+	if err != nil || len(id) < 6 {
+		return "", fmt.Errorf("invalid machine id in %s", PathToMachineID)
+	}
+	id[0] = (id[0] | 0x02) &^ 0x01
+	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", id[0], id[1], id[2], id[3], id[4], id[5]), nil
+}
+
+// savedIdentity is the identity persisted the first time a device started
+type savedIdentity struct {
+	MAC    string                `json:"mac"`
+	Source client.IdentitySource `json:"source"`
+}
+
+// loadDeviceIdentity reads the persisted identity, returning an empty MAC address if none was saved
+func loadDeviceIdentity() (savedIdentity, error) {
+	var identity savedIdentity
+	rawBytes, err := ioutil.ReadFile(PathToDeviceIdentity)
+	if os.IsNotExist(err) {
+		return identity, nil
+	} else if err != nil {
+		return identity, err
+	}
+	if err := json.Unmarshal(rawBytes, &identity); err != nil {
+		return savedIdentity{}, err
+	}
+	identity.MAC = strings.ToLower(strings.TrimSpace(identity.MAC))
+	return identity, nil
+}
+
+// saveDeviceIdentity persists an identity, so that it survives network interfaces being added or removed
+func saveDeviceIdentity(identity savedIdentity) error {
+	rawBytes, err := json.Marshal(identity)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(PathToDeviceIdentity), 0755); err != nil {
+		return err
+	}
+	tmpPath := PathToDeviceIdentity + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, rawBytes, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, PathToDeviceIdentity)
+}
+
+// getDeviceIdentity returns the MAC address used to identify the device and where it came from. The first
+// identity detected is persisted and reused afterwards, since plugging in a USB NIC (or losing eth0) would
+// otherwise change which interface is chosen and turn the device into a new one.
+func getDeviceIdentity(desktop bool) (string, client.IdentitySource, error) {
+	saved, err := loadDeviceIdentity()
+	if err != nil {
+		log.Error(err, "Unable to load device identity", "path", PathToDeviceIdentity)
+	} else if saved.MAC != "" {
+		return saved.MAC, saved.Source, nil
+	}
+
+	mac, source, err := detectDeviceIdentity(desktop)
+	if err != nil {
+		return "", "", err
+	}
+	if err := saveDeviceIdentity(savedIdentity{MAC: mac, Source: source}); err != nil {
+		log.Error(err, "Unable to save device identity", "path", PathToDeviceIdentity)
+	}
+	return mac, source, nil
+}
+
+// detectDeviceIdentity returns the MAC address that identifies the device and where it came from, trying
+// the configured network interface, then any physical interface, then the machine ID, and on desktops the
+// interfaces reported by the operating system
+func detectDeviceIdentity(desktop bool) (string, client.IdentitySource, error) {
+	mac, err := readInterfaceMAC(NetworkInterface)
+	if err == nil {
+		return mac, client.IdentityInterface, nil
+	}
+	log.Info("Unable to retrieve MAC address of network interface", "interface", NetworkInterface, "error", err.Error())
+
+	if mac, err = getPhysicalInterfaceMAC(); err == nil {
+		return mac, client.IdentityPhysical, nil
+	}
+	log.Info("Unable to retrieve MAC address of physical network interfaces", "error", err.Error())
+
+	if mac, err = getMachineIDMAC(); err == nil {
+		return mac, client.IdentityMachineID, nil
+	}
+
+	// desktops may not have sysfs, so ask the operating system for its network interfaces
+	if desktop {
+		if mac, err = getDesktopIdentity(); err == nil {
+			return mac, client.IdentityPhysical, nil
+		}
+	}
+	return "", "", err
+}
+
+// getMACAddress retrieves the MAC address used to identify the device, and where it came from
+func getMACAddress(desktop bool) (string, client.IdentitySource) {
+	mac, source, err := getDeviceIdentity(desktop)
+	if err != nil {
+		log.Error(err, "Unable to retrieve MAC address")
+		panic(err)
+	}
+	log.Info("Retrieved MAC address", "mac", mac, "source", source)
+	return mac, source
+}
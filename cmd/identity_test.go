@@ -0,0 +1,126 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// addNetworkInterface creates a fake network interface in SysClassNetDir
+func addNetworkInterface(t *testing.T, iface, mac string, physical bool) {
+	dir := filepath.Join(SysClassNetDir, iface)
+	assert.Nil(t, os.MkdirAll(dir, 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "address"), []byte(mac+"\n"), 0644))
+	if physical {
+		assert.Nil(t, os.Mkdir(filepath.Join(dir, "device"), 0755))
+	}
+}
+
+func TestDetectDeviceIdentity(t *testing.T) {
+	assert := assert.New(t)
+	defer func(netDir, machineID, iface string) {
+		SysClassNetDir, PathToMachineID, NetworkInterface = netDir, machineID, iface
+	}(SysClassNetDir, PathToMachineID, NetworkInterface)
+	SysClassNetDir = t.TempDir()
+	PathToMachineID = filepath.Join(t.TempDir(), "machine-id")
+	NetworkInterface = "eth0"
+
+	// Case for no identity
+	_, _, err := detectDeviceIdentity(false)
+	assert.NotNil(err)
+
+	// Case for machine id, which is converted to a locally administered unicast address
+	ioutil.WriteFile(PathToMachineID, []byte("0da8b3c2e5f64b1e9c1f0e2d3c4b5a69\n"), 0644)
+	mac, source, err := detectDeviceIdentity(false)
+	assert.Nil(err)
+	assert.Equal("0e:a8:b3:c2:e5:f6", mac)
+	assert.Equal(client.IdentityMachineID, source)
+
+	// Case for a physical interface; loopback and virtual interfaces are ignored
+	addNetworkInterface(t, "lo", "00:00:00:00:00:00", false)
+	addNetworkInterface(t, "docker0", "02:42:ac:11:00:01", false)
+	addNetworkInterface(t, "wlan0", "DC:A6:32:00:00:02", true)
+	mac, source, err = detectDeviceIdentity(false)
+	assert.Nil(err)
+	assert.Equal("dc:a6:32:00:00:02", mac)
+	assert.Equal(client.IdentityPhysical, source)
+
+	// Case for the configured interface
+	addNetworkInterface(t, "eth0", "dc:a6:32:00:00:01", true)
+	mac, source, err = detectDeviceIdentity(false)
+	assert.Nil(err)
+	assert.Equal("dc:a6:32:00:00:01", mac)
+	assert.Equal(client.IdentityInterface, source)
+
+	NetworkInterface = "docker0"
+	mac, _, err = detectDeviceIdentity(false)
+	assert.Nil(err)
+	assert.Equal("02:42:ac:11:00:01", mac)
+}
+
+func TestGetDeviceIdentity(t *testing.T) {
+	assert := assert.New(t)
+	defer func(netDir, machineID, iface, identity string) {
+		SysClassNetDir, PathToMachineID, NetworkInterface, PathToDeviceIdentity = netDir, machineID, iface, identity
+	}(SysClassNetDir, PathToMachineID, NetworkInterface, PathToDeviceIdentity)
+	SysClassNetDir = t.TempDir()
+	PathToMachineID = filepath.Join(t.TempDir(), "machine-id")
+	PathToDeviceIdentity = filepath.Join(t.TempDir(), "identity.json")
+	NetworkInterface = "eth0"
+
+	// Case for no identity, which is not persisted
+	_, _, err := getDeviceIdentity(false)
+	assert.NotNil(err)
+	_, err = os.Stat(PathToDeviceIdentity)
+	assert.True(os.IsNotExist(err))
+
+	// Case for the first identity detected, which is persisted
+	addNetworkInterface(t, "wlan0", "dc:a6:32:00:00:02", true)
+	mac, source, err := getDeviceIdentity(false)
+	assert.Nil(err)
+	assert.Equal("dc:a6:32:00:00:02", mac)
+	assert.Equal(client.IdentityPhysical, source)
+
+	// Case for a USB NIC sorted before the original interface, which does not change the identity
+	addNetworkInterface(t, "eth1", "00:e0:4c:00:00:03", true)
+	mac, source, err = getDeviceIdentity(false)
+	assert.Nil(err)
+	assert.Equal("dc:a6:32:00:00:02", mac)
+	assert.Equal(client.IdentityPhysical, source)
+
+	// Case for the original interface disappearing
+	assert.Nil(os.RemoveAll(filepath.Join(SysClassNetDir, "wlan0")))
+	mac, _, err = getDeviceIdentity(false)
+	assert.Nil(err)
+	assert.Equal("dc:a6:32:00:00:02", mac)
+
+	// Case for a corrupted identity file, which is detected again
+	assert.Nil(ioutil.WriteFile(PathToDeviceIdentity, []byte("{"), 0644))
+	mac, _, err = getDeviceIdentity(false)
+	assert.Nil(err)
+	assert.Equal("00:e0:4c:00:00:03", mac)
+	saved, err := loadDeviceIdentity()
+	assert.Nil(err)
+	assert.Equal(savedIdentity{MAC: "00:e0:4c:00:00:03", Source: client.IdentityPhysical}, saved)
+}
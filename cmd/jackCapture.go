@@ -0,0 +1,51 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// JackCapturePath is the path to jack_capture, used for direct-to-disk capture of JACK ports
+const JackCapturePath = "/usr/bin/jack_capture"
+
+// captureProcess is a running capture of JACK ports to a file
+type captureProcess interface {
+	// Stop finishes writing the capture file and waits for the capture to exit
+	Stop() error
+}
+
+// execCaptureProcess is a jack_capture process
+type execCaptureProcess struct {
+	cmd *exec.Cmd
+}
+
+// Stop interrupts jack_capture, which closes its file before exiting
+func (p execCaptureProcess) Stop() error {
+	if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+		return err
+	}
+	return p.cmd.Wait()
+}
+
+// startJackCapture starts a jack_capture process
+func startJackCapture(args []string) (captureProcess, error) {
+	cmd := exec.Command(JackCapturePath, args...)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return execCaptureProcess{cmd}, nil
+}
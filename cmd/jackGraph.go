@@ -0,0 +1,77 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/xthexder/go-jack"
+)
+
+// JackGraph is used to inspect and connect the ports of a JACK server
+type JackGraph interface {
+	GetName() string
+	GetSampleRate() uint32
+	GetCPULoad() float64
+	GetPorts(portName, portType string, flags uint64) []string
+	GetPortName(id jack.PortId) string
+	HasPort(name string) bool
+	GetConnections(name string) []string
+	Connect(src, dest string) int
+	Disconnect(src, dest string) int
+	Close() int
+}
+
+// openJackGraph waits for jackd and opens a new client with the given callbacks; replaced when simulating
+var openJackGraph = func(name string, onRegistration jack.PortRegistrationCallback, onShutdown jack.ShutdownCallback) (JackGraph, error) {
+	if err := common.WaitForJackd(); err != nil {
+		return nil, err
+	}
+	client, err := common.InitJackClient(name, onRegistration, onShutdown, nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return jackClientGraph{client}, nil
+}
+
+// jackClientGraph implements JackGraph using a JACK client
+type jackClientGraph struct {
+	*jack.Client
+}
+
+// GetPortName returns the full name of a port, or an empty string if it does not exist
+func (g jackClientGraph) GetPortName(id jack.PortId) string {
+	if port := g.GetPortById(id); port != nil {
+		return port.GetName()
+	}
+	return ""
+}
+
+// GetCPULoad returns the percent of the JACK process cycle used for DSP
+func (g jackClientGraph) GetCPULoad() float64 {
+	return common.GetJackCPULoad(g.Client)
+}
+
+// HasPort returns true if a port exists
+func (g jackClientGraph) HasPort(name string) bool {
+	return g.GetPortByName(name) != nil
+}
+
+// GetConnections returns the names of all ports connected to a port
+func (g jackClientGraph) GetConnections(name string) []string {
+	if port := g.GetPortByName(name); port != nil {
+		return port.GetConnections()
+	}
+	return nil
+}
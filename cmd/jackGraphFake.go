@@ -0,0 +1,191 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/xthexder/go-jack"
+)
+
+// FakeJackGraph keeps track of JACK ports and connections in memory
+type FakeJackGraph struct {
+	Name           string
+	SampleRate     uint32
+	CPULoad        float64
+	ports          map[string]uint64
+	ids            map[jack.PortId]string
+	connections    map[string]map[string]bool
+	onRegistration jack.PortRegistrationCallback
+	lastID         jack.PortId
+	mutex          sync.Mutex
+}
+
+// NewFakeJackGraph constructs a new instance of FakeJackGraph with no ports
+func NewFakeJackGraph(name string) *FakeJackGraph {
+	return &FakeJackGraph{
+		Name:        name,
+		SampleRate:  48000,
+		ports:       map[string]uint64{},
+		ids:         map[jack.PortId]string{},
+		connections: map[string]map[string]bool{},
+	}
+}
+
+// SetRegistrationCallback sets the function that is called when ports are registered or unregistered
+func (f *FakeJackGraph) SetRegistrationCallback(callback jack.PortRegistrationCallback) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.onRegistration = callback
+}
+
+// RegisterPort adds a new port, using flags such as jack.PortIsInput or jack.PortIsOutput
+func (f *FakeJackGraph) RegisterPort(name string, flags uint64) jack.PortId {
+	f.mutex.Lock()
+	f.lastID++
+	id := f.lastID
+	f.ports[name] = flags
+	f.ids[id] = name
+	callback := f.onRegistration
+	f.mutex.Unlock()
+
+	if callback != nil {
+		callback(id, true)
+	}
+	return id
+}
+
+// UnregisterClient removes all of the ports and connections that belong to a client
+func (f *FakeJackGraph) UnregisterClient(client string) {
+	f.mutex.Lock()
+	var removed []jack.PortId
+	for id, name := range f.ids {
+		if !strings.HasPrefix(name, client+":") {
+			continue
+		}
+		for conn := range f.connections[name] {
+			delete(f.connections[conn], name)
+		}
+		delete(f.connections, name)
+		delete(f.ports, name)
+		delete(f.ids, id)
+		removed = append(removed, id)
+	}
+	callback := f.onRegistration
+	f.mutex.Unlock()
+
+	if callback != nil {
+		for _, id := range removed {
+			callback(id, false)
+		}
+	}
+}
+
+// GetName returns the name of the client
+func (f *FakeJackGraph) GetName() string {
+	return f.Name
+}
+
+// GetSampleRate returns the sample rate of the fake server
+func (f *FakeJackGraph) GetSampleRate() uint32 {
+	return f.SampleRate
+}
+
+// GetCPULoad returns the DSP load of the fake server
+func (f *FakeJackGraph) GetCPULoad() float64 {
+	return f.CPULoad
+}
+
+// GetPorts returns the sorted names of ports matching a regular expression and flags
+func (f *FakeJackGraph) GetPorts(portName, portType string, flags uint64) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var re *regexp.Regexp
+	if portName != "" {
+		re = regexp.MustCompile(portName)
+	}
+	var names []string
+	for name, portFlags := range f.ports {
+		if (re == nil || re.MatchString(name)) && portFlags&flags == flags {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetPortName returns the full name of a port, or an empty string if it does not exist
+func (f *FakeJackGraph) GetPortName(id jack.PortId) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.ids[id]
+}
+
+// HasPort returns true if a port exists
+func (f *FakeJackGraph) HasPort(name string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	_, ok := f.ports[name]
+	return ok
+}
+
+// GetConnections returns the sorted names of all ports connected to a port
+func (f *FakeJackGraph) GetConnections(name string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var names []string
+	for conn := range f.connections[name] {
+		names = append(names, conn)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Connect connects an output port to an input port
+func (f *FakeJackGraph) Connect(src, dest string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.ports[src]&jack.PortIsOutput == 0 || f.ports[dest]&jack.PortIsInput == 0 {
+		return -1
+	}
+	for _, pair := range [][2]string{{src, dest}, {dest, src}} {
+		if f.connections[pair[0]] == nil {
+			f.connections[pair[0]] = map[string]bool{}
+		}
+		f.connections[pair[0]][pair[1]] = true
+	}
+	return 0
+}
+
+// Disconnect removes a connection between two ports
+func (f *FakeJackGraph) Disconnect(src, dest string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.connections[src][dest] {
+		return -1
+	}
+	delete(f.connections[src], dest)
+	delete(f.connections[dest], src)
+	return 0
+}
+
+// Close stops sending registration callbacks
+func (f *FakeJackGraph) Close() int {
+	f.SetRegistrationCallback(nil)
+	return 0
+}
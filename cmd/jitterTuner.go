@@ -0,0 +1,230 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// JitterTuningInterval is how often the jitter queue is reconsidered
+	JitterTuningInterval = 30 * time.Second
+
+	// JitterLossThreshold is the fraction of lost keepalive packets that grows the jitter queue
+	JitterLossThreshold = 0.02
+
+	// JitterStdDevThreshold is the round-trip time deviation that grows the jitter queue
+	JitterStdDevThreshold = 5 * time.Millisecond
+
+	// JitterGlitchThreshold is the number of late packets logged by JackTrip in one interval that grows the jitter queue
+	JitterGlitchThreshold = 3
+
+	// JitterStableIntervals is the number of consecutive healthy intervals before the jitter queue shrinks
+	JitterStableIntervals = 10
+
+	// JitterRestartInterval is the shortest time between restarts of JackTrip to apply a tuned jitter queue
+	JitterRestartInterval = 10 * time.Minute
+
+	// JackTripGlitchToken is logged by JackTrip whenever packets from the audio server arrive late
+	JackTripGlitchToken = "waiting too long"
+)
+
+// JitterSample describes network conditions measured during one tuning interval
+type JitterSample struct {
+	// fraction of keepalive packets that were lost
+	Loss float64
+
+	// standard deviation of keepalive round-trip times
+	StdDev time.Duration
+
+	// number of late packets logged by JackTrip
+	Glitches int
+}
+
+// isHealthy checks if network conditions are good enough to consider a smaller jitter queue
+func (s JitterSample) isHealthy() bool {
+	return s.Loss < JitterLossThreshold && s.StdDev < JitterStdDevThreshold && s.Glitches < JitterGlitchThreshold
+}
+
+// JitterTuner nudges the jitter queue of a device within the bounds set by operators
+type JitterTuner struct {
+	queue  int
+	stable int
+}
+
+// isJitterTuningEnabled checks if the jitter queue should be tuned automatically for a device config
+func isJitterTuningEnabled(config client.DeviceAgentConfig) bool {
+	return bool(config.AdaptiveQueue) && bool(config.Enabled) && config.Host != "" && usesJackTrip(config)
+}
+
+// getInitialQueue returns the jitter queue used when tuning starts, which is QueueBuffer within the tuning bounds
+func getInitialQueue(config client.DeviceAgentConfig) int {
+	queue := config.QueueBuffer
+	if queue < config.QueueBufferMin {
+		queue = config.QueueBufferMin
+	}
+	if queue > config.QueueBufferMax {
+		queue = config.QueueBufferMax
+	}
+	return queue
+}
+
+// Queue returns the jitter queue currently used, or 0 if it is not being tuned
+func (t *JitterTuner) Queue() int {
+	return t.queue
+}
+
+// Reset starts tuning over from the jitter queue in a device config
+func (t *JitterTuner) Reset(config client.DeviceAgentConfig) {
+	t.queue = 0
+	t.stable = 0
+	if isJitterTuningEnabled(config) {
+		t.queue = getInitialQueue(config)
+	}
+}
+
+// Next returns the jitter queue to use after a sample, and a reason if it changed
+func (t *JitterTuner) Next(config client.DeviceAgentConfig, sample JitterSample) (int, string) {
+	if !sample.isHealthy() {
+		t.stable = 0
+		if t.queue < config.QueueBufferMax {
+			t.queue++
+			return t.queue, "network jitter or packet loss"
+		}
+		return t.queue, ""
+	}
+	t.stable++
+	if t.stable >= JitterStableIntervals && t.queue > config.QueueBufferMin {
+		t.stable = 0
+		t.queue--
+		return t.queue, "network stable"
+	}
+	return t.queue, ""
+}
+
+// getJitterSample measures network conditions from keepalive stats and JackTrip logs
+func getJitterSample(beat client.DeviceHeartbeat, since time.Time) JitterSample {
+	sample := JitterSample{StdDev: beat.StdDevRtt}
+	if beat.PacketsSent > 0 {
+		sample.Loss = 1 - float64(beat.PacketsRecv)/float64(beat.PacketsSent)
+	}
+	glitches, err := common.CountLogLines(JackTripServiceName, since, JackTripGlitchToken)
+	if err != nil {
+		log.V(1).Info("Unable to count JackTrip late packets", "error", err.Error())
+	}
+	sample.Glitches = glitches
+	return sample
+}
+
+// errJitterConfigChanged is returned when the device config changed before a tuned jitter queue was applied
+var errJitterConfigChanged = errors.New("device config changed")
+
+// applyJitterQueue restarts JackTrip using a tuned jitter queue, unless a new device config is being applied
+func (a *DeviceAgent) applyJitterQueue(config client.DeviceAgentConfig, queue int, remoteName string) error {
+	serviceRestartMutex.Lock()
+	defer serviceRestartMutex.Unlock()
+	if deviceState.Config() != config {
+		return errJitterConfigChanged
+	}
+	config.QueueBuffer = queue
+	host, port := config.Host, config.Port
+	if active := a.Standby.Active(); active != "" {
+		host, port = active, getStandbyPort(config)
+	}
+	return restartJackTrip([]byte(getJackTripConfig(config, host, port, remoteName)))
+}
+
+// deviceJitterTuningHandler tunes the jitter queue of JackTrip, when adaptive mode is enabled
+// NOTE: JackTrip must be restarted to change its queue, so tuned queues are applied at most once per JitterRestartInterval
+func (a *DeviceAgent) deviceJitterTuningHandler(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	log.Info("Starting deviceJitterTuningHandler")
+	remoteName := strings.Replace(deviceState.Heartbeat().MAC, ":", "", -1)
+	var tuner JitterTuner
+	var last client.DeviceAgentConfig
+	since := time.Now()
+	lastRestart := time.Now()
+	settling := false
+	pending := ""
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping deviceJitterTuningHandler")
+			return
+		case <-time.After(JitterTuningInterval):
+		}
+
+		// new configs restart JackTrip with the configured queue, so start tuning over
+		config := deviceState.Config()
+		if config != last {
+			last = config
+			tuner.Reset(config)
+			since = time.Now()
+			lastRestart = time.Now()
+			queue := tuner.Queue()
+			deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) { beat.QueueBuffer = queue })
+			continue
+		}
+		if !isJitterTuningEnabled(config) {
+			continue
+		}
+
+		// JackTrip logs late packets while it reconnects, so skip the interval after a restart
+		if settling {
+			settling = false
+			since = time.Now()
+			continue
+		}
+
+		sample := getJitterSample(deviceState.Heartbeat(), since)
+		since = time.Now()
+		applied := deviceState.Heartbeat().QueueBuffer
+		queue, reason := tuner.Next(config, sample)
+		if reason != "" {
+			pending = reason
+			log.Info("Tuned jitter queue", "queue", queue, "reason", reason,
+				"loss", sample.Loss, "stdDev", sample.StdDev, "glitches", sample.Glitches)
+		}
+		if queue == applied || time.Since(lastRestart) < JitterRestartInterval {
+			continue
+		}
+		log.Info("Adjusting jitter queue", "from", applied, "to", queue, "reason", pending)
+		err := a.applyJitterQueue(config, queue, remoteName)
+		if err == errJitterConfigChanged {
+			continue
+		}
+		lastRestart = time.Now()
+		settling = true
+		if err != nil {
+			log.Error(err, "Unable to adjust jitter queue", "queue", queue)
+			continue
+		}
+		deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) { beat.QueueBuffer = queue })
+		if err := a.Timeline.Record(SessionEvent{Type: SessionQueueAdjusted, Queue: queue, Status: pending}); err != nil {
+			log.Error(err, "Failed to record session event", "path", PathToSessionTimeline)
+		}
+	}
+}
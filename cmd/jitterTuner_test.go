@@ -0,0 +1,137 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func getJitterTuningConfig() client.DeviceAgentConfig {
+	config := client.DeviceAgentConfig{}
+	config.Enabled = true
+	config.Type = client.JackTrip
+	config.Host = "a.b.com"
+	config.AdaptiveQueue = true
+	config.QueueBufferMin = 2
+	config.QueueBufferMax = 4
+	return config
+}
+
+func TestIsJitterTuningEnabled(t *testing.T) {
+	assert := assert.New(t)
+	config := getJitterTuningConfig()
+	assert.True(isJitterTuningEnabled(config))
+	config.Type = client.Jamulus
+	assert.False(isJitterTuningEnabled(config))
+	config = getJitterTuningConfig()
+	config.Enabled = false
+	assert.False(isJitterTuningEnabled(config))
+	config = getJitterTuningConfig()
+	config.AdaptiveQueue = false
+	assert.False(isJitterTuningEnabled(config))
+}
+
+func TestGetInitialQueue(t *testing.T) {
+	assert := assert.New(t)
+	config := getJitterTuningConfig()
+	assert.Equal(2, getInitialQueue(config))
+	config.QueueBuffer = 3
+	assert.Equal(3, getInitialQueue(config))
+	config.QueueBuffer = 10
+	assert.Equal(4, getInitialQueue(config))
+
+	// JackTrip configs should use the clamped queue when tuning is enabled
+	assert.Contains(getJackTripConfig(config, config.Host, 4464, "pi"), "-q 4")
+	config.AdaptiveQueue = false
+	assert.Contains(getJackTripConfig(config, config.Host, 4464, "pi"), "-q 10")
+}
+
+func TestJitterTuner(t *testing.T) {
+	assert := assert.New(t)
+	config := getJitterTuningConfig()
+	healthy := JitterSample{}
+	lossy := JitterSample{Loss: 0.2}
+	jittery := JitterSample{StdDev: 10 * time.Millisecond}
+	glitchy := JitterSample{Glitches: JitterGlitchThreshold}
+
+	var tuner JitterTuner
+	tuner.Reset(config)
+	assert.Equal(2, tuner.Queue())
+
+	// Poor network conditions should grow the queue up to its maximum
+	queue, reason := tuner.Next(config, lossy)
+	assert.Equal(3, queue)
+	assert.NotEmpty(reason)
+	queue, _ = tuner.Next(config, jittery)
+	assert.Equal(4, queue)
+	queue, reason = tuner.Next(config, glitchy)
+	assert.Equal(4, queue)
+	assert.Empty(reason)
+
+	// Stable network conditions should slowly shrink the queue down to its minimum
+	for i := 1; i < JitterStableIntervals; i++ {
+		queue, reason = tuner.Next(config, healthy)
+		assert.Equal(4, queue)
+		assert.Empty(reason)
+	}
+	queue, reason = tuner.Next(config, healthy)
+	assert.Equal(3, queue)
+	assert.NotEmpty(reason)
+
+	// Any poor sample should restart the count of stable intervals
+	for i := 1; i < JitterStableIntervals; i++ {
+		tuner.Next(config, healthy)
+	}
+	queue, _ = tuner.Next(config, lossy)
+	assert.Equal(4, queue)
+
+	// Tuning should stop when it is disabled
+	config.AdaptiveQueue = false
+	tuner.Reset(config)
+	assert.Equal(0, tuner.Queue())
+}
+
+func TestGetJitterSample(t *testing.T) {
+	assert := assert.New(t)
+	beat := client.DeviceHeartbeat{}
+	beat.PacketsSent = 5
+	beat.PacketsRecv = 4
+	beat.StdDevRtt = time.Millisecond
+	sample := getJitterSample(beat, time.Now())
+	assert.InDelta(0.2, sample.Loss, 0.001)
+	assert.Equal(time.Millisecond, sample.StdDev)
+	assert.False(sample.isHealthy())
+}
+
+func TestApplyJitterQueueConfigChanged(t *testing.T) {
+	assert := assert.New(t)
+	defer func(state *StateStore) { deviceState = state }(deviceState)
+	deviceState = NewStateStore()
+
+	config := client.DeviceAgentConfig{}
+	config.Host = "studio.example.com"
+	deviceState.SetConfig(config)
+
+	// Case for a new config being applied while the queue was tuned
+	config.Host = "other.example.com"
+	assert.Equal(errJitterConfigChanged, NewDeviceAgent(false).applyJitterQueue(config, 4, "remote"))
+}
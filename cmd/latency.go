@@ -0,0 +1,317 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/xthexder/go-jack"
+)
+
+const (
+	// LatencyCommand measures the end-to-end latency of a device
+	LatencyCommand = "latency"
+
+	// LatencyClientName is the name of the JACK client used to measure latency
+	LatencyClientName = "latency"
+
+	// LatencyTimeout is the maximum time to wait for the studio to be quiet, and then for the marker to return
+	LatencyTimeout = 3 * time.Second
+
+	// LatencyAnalysisInterval is the time between searches for the marker in the audio received so far
+	LatencyAnalysisInterval = 50 * time.Millisecond
+
+	// LatencyBurstFrequency is the frequency of the carrier of the marker, in Hz
+	LatencyBurstFrequency = 1000
+
+	// LatencyChipDuration is the length of each chip of the code that modulates the marker
+	LatencyChipDuration = 2 * time.Millisecond
+
+	// LatencyBurstLevel is the peak level of the marker
+	LatencyBurstLevel = 0.5
+
+	// LatencyCorrelationThreshold is the normalized correlation with the marker at which it is considered
+	// to have returned; music and speech stay well below it
+	LatencyCorrelationThreshold = 0.6
+
+	// LatencyIdleDuration is how long the studio mix must be quiet before the marker is sent on a shared channel
+	LatencyIdleDuration = 250 * time.Millisecond
+
+	// LatencyIdleLevel is the peak level below which the studio mix is considered quiet
+	LatencyIdleLevel = 0.01
+
+	// JackPeriods is the number of periods in JACK's ALSA playback buffer, which is its default
+	JackPeriods = 2
+)
+
+// latencyBarkerCode is the code that modulates the phase of the marker; its autocorrelation has a single
+// sharp peak, so the marker can be told apart from its echoes and from other sounds in the studio mix
+var latencyBarkerCode = []float64{1, 1, 1, 1, 1, -1, -1, 1, 1, -1, 1, -1, 1}
+
+// getLatencyMarker returns the samples of the marker: a tone whose phase is flipped by a Barker code
+func getLatencyMarker(sampleRate int) []float64 {
+	chipFrames := int(LatencyChipDuration.Seconds() * float64(sampleRate))
+	marker := make([]float64, chipFrames*len(latencyBarkerCode))
+	for n := range marker {
+		marker[n] = LatencyBurstLevel * latencyBarkerCode[n/chipFrames] * math.Cos(2*math.Pi*LatencyBurstFrequency*float64(n)/float64(sampleRate))
+	}
+	return marker
+}
+
+// latencyDetector waits for the studio mix to be quiet, emits a marker and records what comes back, so that
+// the marker can be found by correlation, counting frames in between
+type latencyDetector struct {
+	sampleRate int
+	marker     []float64
+	energy     float64
+	chipFrames int
+	// frames of silence required before the marker is emitted, or 0 on a dedicated channel
+	idleFrames int
+	quiet      int
+	frame      int
+	recording  []float32
+	recorded   int32
+	armed      int32
+	started    int32
+	searched   int
+	detected   int
+}
+
+// newLatencyDetector constructs a new instance of latencyDetector, which waits for the studio mix to be
+// quiet for the idle duration before emitting the marker
+func newLatencyDetector(sampleRate int, idle time.Duration) *latencyDetector {
+	d := &latencyDetector{
+		sampleRate: sampleRate,
+		marker:     getLatencyMarker(sampleRate),
+		chipFrames: int(LatencyChipDuration.Seconds() * float64(sampleRate)),
+		idleFrames: int(idle.Seconds() * float64(sampleRate)),
+		recording:  make([]float32, int(LatencyTimeout.Seconds()*float64(sampleRate))),
+		detected:   -1,
+	}
+	for _, v := range d.marker {
+		d.energy += v * v
+	}
+	return d
+}
+
+// arm starts waiting for the studio mix to be quiet in the next process cycle
+func (d *latencyDetector) arm() {
+	atomic.StoreInt32(&d.armed, 1)
+}
+
+// isStarted returns true once the marker has been emitted
+func (d *latencyDetector) isStarted() bool {
+	return atomic.LoadInt32(&d.started) == 1
+}
+
+// process writes the marker to out and records in, once the studio mix is quiet
+// NOTE: this runs in the JACK process thread, so it must not block or allocate
+func (d *latencyDetector) process(out, in []jack.AudioSample) {
+	for i := range out {
+		out[i] = 0
+	}
+	if atomic.LoadInt32(&d.armed) == 0 {
+		return
+	}
+	if !d.isStarted() {
+		for _, v := range in {
+			if math.Abs(float64(v)) >= LatencyIdleLevel {
+				d.quiet = 0
+			} else {
+				d.quiet++
+			}
+		}
+		if d.quiet >= d.idleFrames {
+			atomic.StoreInt32(&d.started, 1)
+		}
+		return
+	}
+	for i := range out {
+		n := d.frame + i
+		if n < len(d.marker) {
+			out[i] = jack.AudioSample(d.marker[n])
+		}
+		if n < len(d.recording) && i < len(in) {
+			d.recording[n] = float32(in[i])
+		}
+	}
+	d.frame += len(out)
+	if d.frame > len(d.recording) {
+		d.frame = len(d.recording)
+	}
+	atomic.StoreInt32(&d.recorded, int32(d.frame))
+}
+
+// correlate returns the normalized correlation of the marker with the recording at an offset
+func (d *latencyDetector) correlate(offset int) float64 {
+	var product, energy float64
+	for i, v := range d.marker {
+		x := float64(d.recording[offset+i])
+		product += x * v
+		energy += x * x
+	}
+	if energy == 0 {
+		return 0
+	}
+	return product / math.Sqrt(energy*d.energy)
+}
+
+// find searches the audio recorded so far for the marker, returning the frame it returned at, or -1 if it
+// has not been found yet; the strongest correlation within a chip of the first match is used
+func (d *latencyDetector) find() int {
+	if d.detected >= 0 {
+		return d.detected
+	}
+	recorded := int(atomic.LoadInt32(&d.recorded))
+	for ; d.searched+len(d.marker) <= recorded; d.searched++ {
+		if d.correlate(d.searched) < LatencyCorrelationThreshold {
+			continue
+		}
+		if d.searched+d.chipFrames+len(d.marker) > recorded {
+			// wait for the rest of the peak to be recorded
+			return -1
+		}
+		best, bestScore := d.searched, 0.0
+		for offset := d.searched; offset < d.searched+d.chipFrames; offset++ {
+			if score := d.correlate(offset); score > bestScore {
+				best, bestScore = offset, score
+			}
+		}
+		d.detected = best
+		return d.detected
+	}
+	return -1
+}
+
+// getLatencyReport converts a measured number of frames into a latency report
+func getLatencyReport(frames, sampleRate, period int) client.LatencyReport {
+	ms := func(frames int) float64 {
+		return float64(frames) * 1000 / float64(sampleRate)
+	}
+	// JACK adds one period of capture latency, and a full buffer of playback latency
+	report := client.LatencyReport{RoundTrip: ms(frames), Device: ms((1 + JackPeriods) * period)}
+	report.Total = report.RoundTrip + report.Device
+	return report
+}
+
+// measureLatency sends a marker from the device through the studio mix and back, and measures how long it takes;
+// on a shared channel, the marker is only sent while nobody else can be heard
+func measureLatency(ctx context.Context, config client.DeviceAgentConfig) (client.LatencyReport, error) {
+	if !bool(config.Enabled) || config.Host == "" || !usesJackTrip(config) {
+		return client.LatencyReport{}, errors.New("device is not connected to a JackTrip studio")
+	}
+	channel, idle := 1, LatencyIdleDuration
+	if config.LatencyChannel > 0 {
+		channel, idle = config.LatencyChannel, 0
+	}
+
+	var detector *latencyDetector
+	var outPort, inPort *jack.Port
+	registerPorts := func(c *jack.Client) {
+		outPort = c.PortRegister("out_1", jack.DEFAULT_AUDIO_TYPE, jack.PortIsOutput, 0)
+		inPort = c.PortRegister("in_1", jack.DEFAULT_AUDIO_TYPE, jack.PortIsInput, 0)
+		detector = newLatencyDetector(int(c.GetSampleRate()), idle)
+	}
+	process := func(nframes uint32) int {
+		detector.process(outPort.GetBuffer(nframes), inPort.GetBuffer(nframes))
+		return 0
+	}
+	jackClient, err := common.InitJackClient(LatencyClientName, nil, nil, process, registerPorts, false)
+	if err != nil {
+		return client.LatencyReport{}, err
+	}
+	defer jackClient.Close()
+
+	if code := jackClient.Connect(outPort.GetName(), fmt.Sprintf("%s%d", hubserverInput, channel)); code != 0 {
+		return client.LatencyReport{}, fmt.Errorf("unable to connect to JackTrip: %w", jack.StrError(code))
+	}
+	if code := jackClient.Connect(fmt.Sprintf("%s%d", hubserverOutput, channel), inPort.GetName()); code != 0 {
+		return client.LatencyReport{}, fmt.Errorf("unable to connect from JackTrip: %w", jack.StrError(code))
+	}
+	detector.arm()
+
+	ticker := time.NewTicker(LatencyAnalysisInterval)
+	defer ticker.Stop()
+	deadline := time.After(LatencyTimeout)
+	for started := false; ; {
+		select {
+		case <-ticker.C:
+			if !started && detector.isStarted() {
+				started, deadline = true, time.After(LatencyTimeout)
+			}
+			if frames := detector.find(); frames >= 0 {
+				return getLatencyReport(frames, detector.sampleRate, config.Period), nil
+			}
+		case <-deadline:
+			if !started && detector.isStarted() {
+				started, deadline = true, time.After(LatencyTimeout)
+				continue
+			}
+			if !started {
+				return client.LatencyReport{}, errors.New("studio mix was not quiet; set a dedicated latency channel to measure during sessions")
+			}
+			return client.LatencyReport{}, errors.New("marker did not return from the studio mix")
+		case <-ctx.Done():
+			return client.LatencyReport{}, ctx.Err()
+		}
+	}
+}
+
+// LatencyMonitor runs latency measurements one at a time, and keeps the latest result for heartbeats
+type LatencyMonitor struct {
+	latest    *client.LatencyReport
+	measuring int32
+	mutex     sync.Mutex
+}
+
+// Latest returns the result of the most recent measurement, or nil if none was made
+func (m *LatencyMonitor) Latest() *client.LatencyReport {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.latest
+}
+
+// Measure runs a latency measurement, unless one is already running
+func (m *LatencyMonitor) Measure(ctx context.Context, config client.DeviceAgentConfig) client.LatencyReport {
+	if !atomic.CompareAndSwapInt32(&m.measuring, 0, 1) {
+		return client.LatencyReport{Error: "a latency measurement is already running", Timestamp: time.Now()}
+	}
+	defer atomic.StoreInt32(&m.measuring, 0)
+
+	report, err := measureLatency(ctx, config)
+	report.Timestamp = time.Now()
+	if err != nil {
+		log.Error(err, "Unable to measure latency")
+		report.Error = err.Error()
+	} else {
+		log.Info("Measured latency", "roundTrip", report.RoundTrip, "device", report.Device, "total", report.Total)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.latest = &report
+	return report
+}
@@ -0,0 +1,133 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xthexder/go-jack"
+)
+
+// runLatencyLoop feeds the output of a detector back into its input after a delay, mixed with other audio from
+// the studio, returning the detected frame
+func runLatencyLoop(d *latencyDetector, delay, period, cycles int, studio func(n int) float64) int {
+	line := make([]jack.AudioSample, delay+period*cycles)
+	for c := 0; c < cycles; c++ {
+		out := make([]jack.AudioSample, period)
+		in := make([]jack.AudioSample, period)
+		for i := range in {
+			in[i] = line[c*period+i] + jack.AudioSample(studio(c*period+i))
+		}
+		d.process(out, in)
+		copy(line[c*period+delay:], out)
+		if frames := d.find(); frames >= 0 {
+			return frames
+		}
+	}
+	return -1
+}
+
+// quietStudio is a studio mix where nobody is playing
+func quietStudio(int) float64 { return 0 }
+
+func TestLatencyDetector(t *testing.T) {
+	assert := assert.New(t)
+
+	// Nothing should be emitted until the detector is armed
+	d := newLatencyDetector(48000, 0)
+	assert.Equal(96*13, len(d.marker))
+	out := []jack.AudioSample{1, 1, 1}
+	d.process(out, make([]jack.AudioSample, 3))
+	assert.Equal([]jack.AudioSample{0, 0, 0}, out)
+	assert.Equal(0, d.frame)
+	assert.False(d.isStarted())
+
+	// The marker should be detected after the loop delay
+	for _, delay := range []int{128, 1000, 4801} {
+		d = newLatencyDetector(48000, 0)
+		d.arm()
+		assert.Equal(delay, runLatencyLoop(d, delay, 128, 100, quietStudio))
+	}
+
+	// A marker that never returns should not be detected
+	d = newLatencyDetector(48000, 0)
+	d.arm()
+	assert.Equal(-1, runLatencyLoop(d, 128*200, 128, 100, quietStudio))
+
+	// Musicians playing in the studio mix, including a tone at the carrier frequency, should not be detected
+	random := rand.New(rand.NewSource(1))
+	music := func(n int) float64 {
+		t := float64(n) / 48000
+		return 0.3*math.Sin(2*math.Pi*LatencyBurstFrequency*t) + 0.2*math.Sin(2*math.Pi*220*t) + 0.1*(random.Float64()-0.5)
+	}
+	d = newLatencyDetector(48000, 0)
+	d.arm()
+	assert.Equal(-1, runLatencyLoop(d, 128*200, 128, 100, music))
+
+	// The marker should still be found under other audio on a dedicated channel
+	d = newLatencyDetector(48000, 0)
+	d.arm()
+	assert.Equal(1000, runLatencyLoop(d, 1000, 128, 100, func(n int) float64 { return 0.5 * music(n) }))
+
+	// On a shared channel, the marker should wait for the studio mix to be quiet
+	d = newLatencyDetector(48000, 10*time.Millisecond)
+	d.arm()
+	assert.Equal(-1, runLatencyLoop(d, 1000, 128, 100, music))
+	assert.False(d.isStarted())
+	d = newLatencyDetector(48000, 10*time.Millisecond)
+	d.arm()
+	assert.Equal(1000, runLatencyLoop(d, 1000, 128, 100, func(n int) float64 {
+		if n < 2000 {
+			return music(n)
+		}
+		return 0
+	}))
+	assert.True(d.isStarted())
+}
+
+func TestGetLatencyReport(t *testing.T) {
+	assert := assert.New(t)
+	report := getLatencyReport(4800, 48000, 128)
+	assert.InDelta(100.0, report.RoundTrip, 0.001)
+	assert.InDelta(8.0, report.Device, 0.001)
+	assert.InDelta(108.0, report.Total, 0.001)
+}
+
+func TestLatencyMonitor(t *testing.T) {
+	assert := assert.New(t)
+	var m LatencyMonitor
+	assert.Nil(m.Latest())
+
+	// Devices that are not connected to a JackTrip studio cannot be measured
+	report := m.Measure(context.Background(), client.DeviceAgentConfig{})
+	assert.NotEmpty(report.Error)
+	assert.False(report.Timestamp.IsZero())
+	assert.Equal(&report, m.Latest())
+
+	// Only one measurement may run at a time
+	m.measuring = 1
+	report = m.Measure(context.Background(), client.DeviceAgentConfig{})
+	assert.Contains(report.Error, "already running")
+	assert.NotEqual(&report, m.Latest())
+}
@@ -0,0 +1,455 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/xthexder/go-jack"
+)
+
+const (
+	// ListenClientName is the name of the JACK client used to monitor audio for listeners
+	ListenClientName = "listen"
+
+	// ListenChannels is the number of audio channels sent to listeners
+	ListenChannels = 2
+
+	// ListenFormatOpus describes the audio sent to listeners by default: Opus in a live WebM stream, for MediaSource
+	ListenFormatOpus = "webm/opus"
+
+	// ListenFormatPCM describes the audio sent to listeners that ask for it: interleaved signed 16-bit little-endian PCM
+	ListenFormatPCM = "s16le"
+
+	// ListenOpusBitrate is the bitrate of the Opus stream encoded for each listener
+	ListenOpusBitrate = "128k"
+
+	// ListenDrainInterval is how often captured audio is sent to listeners
+	ListenDrainInterval = 10 * time.Millisecond
+
+	// FFmpegPath is the path to ffmpeg, used to encode the audio sent to listeners
+	FFmpegPath = "/usr/bin/ffmpeg"
+
+	// listenRingSize is the number of bytes of captured audio buffered between the JACK process thread and listeners
+	listenRingSize = 1 << 16
+
+	// listenerBufferSize is the number of audio chunks buffered per listener before chunks are dropped
+	listenerBufferSize = 32
+)
+
+// ListenHeader is sent to each listener before any audio
+type ListenHeader struct {
+	SampleRate int    `json:"sampleRate"`
+	Channels   int    `json:"channels"`
+	Format     string `json:"format"`
+}
+
+// encodePCM interleaves JACK sample buffers and encodes them as signed 16-bit little-endian PCM
+func encodePCM(buffers [][]jack.AudioSample) []byte {
+	return encodePCMInto(nil, buffers)
+}
+
+// encodePCMInto works like encodePCM, but reuses dst when it is large enough so that it does not allocate
+func encodePCMInto(dst []byte, buffers [][]jack.AudioSample) []byte {
+	if len(buffers) == 0 {
+		return nil
+	}
+	frames := len(buffers[0])
+	size := frames * len(buffers) * 2
+	if cap(dst) < size {
+		dst = make([]byte, size)
+	}
+	result := dst[:size]
+	for i := 0; i < frames; i++ {
+		for ch, buf := range buffers {
+			sample := math.Max(-1, math.Min(1, float64(buf[i])))
+			offset := (i*len(buffers) + ch) * 2
+			binary.LittleEndian.PutUint16(result[offset:], uint16(int16(sample*math.MaxInt16)))
+		}
+	}
+	return result
+}
+
+// pcmRing is a single-producer, single-consumer ring buffer that the JACK process thread writes to without locking
+type pcmRing struct {
+	buf   []byte
+	read  uint64
+	write uint64
+}
+
+// newPCMRing constructs a new instance of pcmRing
+func newPCMRing(size int) *pcmRing {
+	return &pcmRing{buf: make([]byte, size)}
+}
+
+// Write appends data to the ring, returning false if there is not enough room; only the producer may call it
+func (r *pcmRing) Write(data []byte) bool {
+	size := uint64(len(r.buf))
+	write, read := atomic.LoadUint64(&r.write), atomic.LoadUint64(&r.read)
+	if uint64(len(data)) > size-(write-read) {
+		return false
+	}
+	n := copy(r.buf[write%size:], data)
+	copy(r.buf, data[n:])
+	atomic.StoreUint64(&r.write, write+uint64(len(data)))
+	return true
+}
+
+// Read moves up to len(p) bytes out of the ring, returning the number of bytes read; only the consumer may call it
+func (r *pcmRing) Read(p []byte) int {
+	size := uint64(len(r.buf))
+	write, read := atomic.LoadUint64(&r.write), atomic.LoadUint64(&r.read)
+	available := write - read
+	if available > uint64(len(p)) {
+		available = uint64(len(p))
+	}
+	n := copy(p[:available], r.buf[read%size:])
+	copy(p[n:available], r.buf)
+	atomic.StoreUint64(&r.read, read+available)
+	return int(available)
+}
+
+// ListenMonitor captures audio from JACK and fans it out to websocket listeners
+// NOTE: the JACK process thread only writes to a lock-free ring, which a separate goroutine drains to listeners
+type ListenMonitor struct {
+	JackClient *jack.Client
+	Ports      []*jack.Port
+	listeners  map[chan []byte]bool
+	active     int32
+	sampleRate int32
+	dropped    uint64
+	ring       *pcmRing
+	buffers    [][]jack.AudioSample
+	scratch    []byte
+	done       chan struct{}
+	mutex      sync.Mutex
+}
+
+// NewListenMonitor constructs a new instance of ListenMonitor
+func NewListenMonitor() *ListenMonitor {
+	return &ListenMonitor{
+		listeners: map[chan []byte]bool{},
+		ring:      newPCMRing(listenRingSize),
+	}
+}
+
+// Subscribe registers a new listener, returning a channel that receives PCM audio
+func (lm *ListenMonitor) Subscribe() chan []byte {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	ch := make(chan []byte, listenerBufferSize)
+	lm.listeners[ch] = true
+	atomic.StoreInt32(&lm.active, int32(len(lm.listeners)))
+	return ch
+}
+
+// Unsubscribe removes a listener
+func (lm *ListenMonitor) Unsubscribe(ch chan []byte) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	if lm.listeners[ch] {
+		delete(lm.listeners, ch)
+		close(ch)
+	}
+	atomic.StoreInt32(&lm.active, int32(len(lm.listeners)))
+}
+
+// Listeners returns the number of active listeners
+func (lm *ListenMonitor) Listeners() int {
+	return int(atomic.LoadInt32(&lm.active))
+}
+
+// broadcast sends a chunk of audio to all listeners
+// NOTE: slow listeners drop chunks instead of holding up the others
+func (lm *ListenMonitor) broadcast(chunk []byte) {
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	for ch := range lm.listeners {
+		select {
+		case ch <- chunk:
+		default:
+		}
+	}
+}
+
+// drain sends audio captured by the JACK process thread to listeners, until the monitor is stopped
+func (lm *ListenMonitor) drain(done chan struct{}) {
+	ticker := time.NewTicker(ListenDrainInterval)
+	defer ticker.Stop()
+	buf := make([]byte, listenRingSize)
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if n := lm.ring.Read(buf); n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			lm.broadcast(chunk)
+		}
+		if dropped := atomic.SwapUint64(&lm.dropped, 0); dropped > 0 {
+			log.V(1).Info("Dropped listen audio", "periods", dropped)
+		}
+	}
+}
+
+// process is the JACK process callback that captures audio for listeners
+// NOTE: this runs in the JACK process thread, so it must not lock or allocate
+func (lm *ListenMonitor) process(nframes uint32) int {
+	if atomic.LoadInt32(&lm.active) == 0 || len(lm.Ports) == 0 {
+		return 0
+	}
+	for i, port := range lm.Ports {
+		lm.buffers[i] = port.GetBuffer(nframes)
+	}
+	lm.scratch = encodePCMInto(lm.scratch, lm.buffers)
+	if !lm.ring.Write(lm.scratch) {
+		atomic.AddUint64(&lm.dropped, 1)
+	}
+	return 0
+}
+
+// Start registers a JACK client and connects it to the given source ports
+func (lm *ListenMonitor) Start(sources []string) error {
+	registerPorts := func(client *jack.Client) {
+		for i := 1; i <= ListenChannels; i++ {
+			port := client.PortRegister(fmt.Sprintf("in_%d", i), jack.DEFAULT_AUDIO_TYPE, jack.PortIsInput, 0)
+			lm.Ports = append(lm.Ports, port)
+		}
+		// allocate buffers before the process callback starts running
+		lm.buffers = make([][]jack.AudioSample, len(lm.Ports))
+		lm.scratch = make([]byte, int(client.GetBufferSize())*len(lm.Ports)*2)
+	}
+	client, err := common.InitJackClient(ListenClientName, nil, nil, lm.process, registerPorts, false)
+	if err != nil {
+		return err
+	}
+	lm.JackClient = client
+	lm.done = make(chan struct{})
+	go lm.drain(lm.done)
+	atomic.StoreInt32(&lm.sampleRate, int32(client.GetSampleRate()))
+	for i, src := range sources {
+		if i >= len(lm.Ports) {
+			break
+		}
+		if code := client.Connect(src, lm.Ports[i].GetName()); code != 0 {
+			log.Error(jack.StrError(code), "Unable to connect listen port", "src", src)
+		}
+	}
+	return nil
+}
+
+// SampleRate returns the sample rate of the JACK server that the monitor is running on, or 0 if it is not running
+// NOTE: the monitor is started in the background, so handlers use this instead of JackClient
+func (lm *ListenMonitor) SampleRate() int {
+	return int(atomic.LoadInt32(&lm.sampleRate))
+}
+
+// Stop closes the JACK client and disconnects all listeners
+func (lm *ListenMonitor) Stop() {
+	atomic.StoreInt32(&lm.sampleRate, 0)
+	if lm.JackClient != nil {
+		lm.JackClient.Close()
+		lm.JackClient = nil
+	}
+	if lm.done != nil {
+		close(lm.done)
+		lm.done = nil
+	}
+	lm.Ports = nil
+	lm.mutex.Lock()
+	defer lm.mutex.Unlock()
+	for ch := range lm.listeners {
+		delete(lm.listeners, ch)
+		close(ch)
+	}
+	atomic.StoreInt32(&lm.active, 0)
+}
+
+// execListenEncoder is an ffmpeg process that encodes PCM audio written to it for a single listener
+type execListenEncoder struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	once   sync.Once
+}
+
+// Write sends PCM audio to ffmpeg
+func (e *execListenEncoder) Write(p []byte) (int, error) {
+	return e.stdin.Write(p)
+}
+
+// Read returns audio encoded by ffmpeg
+func (e *execListenEncoder) Read(p []byte) (int, error) {
+	return e.stdout.Read(p)
+}
+
+// Close stops ffmpeg; it is safe to call more than once
+func (e *execListenEncoder) Close() error {
+	e.once.Do(func() {
+		e.stdin.Close()
+		e.cmd.Process.Kill()
+		e.cmd.Wait()
+	})
+	return nil
+}
+
+// getOpusEncoderArgs returns the ffmpeg arguments used to encode listen audio as a live WebM stream of Opus
+func getOpusEncoderArgs(sampleRate int) []string {
+	return []string{
+		"-hide_banner", "-loglevel", "error",
+		"-f", ListenFormatPCM, "-ar", strconv.Itoa(sampleRate), "-ac", strconv.Itoa(ListenChannels), "-i", "pipe:0",
+		"-c:a", "libopus", "-b:a", ListenOpusBitrate, "-application", "lowdelay", "-frame_duration", "10",
+		"-f", "webm", "-live", "1", "-cluster_time_limit", "100", "-flush_packets", "1", "pipe:1",
+	}
+}
+
+// startOpusEncoder starts an ffmpeg process that encodes listen audio as Opus
+var startOpusEncoder = func(sampleRate int) (io.ReadWriteCloser, error) {
+	cmd := exec.Command(FFmpegPath, getOpusEncoderArgs(sampleRate)...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &execListenEncoder{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+// pcmPassthrough sends PCM audio to listeners as it is, for clients that decode audio themselves
+type pcmPassthrough struct {
+	*io.PipeReader
+	*io.PipeWriter
+}
+
+// Close stops the passthrough; it is safe to call more than once
+func (p pcmPassthrough) Close() error {
+	p.PipeReader.Close()
+	return p.PipeWriter.Close()
+}
+
+// startListenEncoder starts encoding listen audio in the requested format, returning the format that is used
+func startListenEncoder(format string, sampleRate int) (io.ReadWriteCloser, string, error) {
+	if format == ListenFormatPCM {
+		r, w := io.Pipe()
+		return pcmPassthrough{r, w}, ListenFormatPCM, nil
+	}
+	encoder, err := startOpusEncoder(sampleRate)
+	return encoder, ListenFormatOpus, err
+}
+
+// checkListenOrigin only allows pages served by the audio server, or by origins allowed to play its broadcasts
+func checkListenOrigin(config client.ServerAgentConfig, r *http.Request) bool {
+	return isSameOrigin(r) || checkHLSOrigin(config, r.Header.Get("Origin"))
+}
+
+// handleListenRequest upgrades listen requests to a websocket that streams audio, encoded with Opus unless
+// the listener asks for ?format=s16le
+func handleListenRequest(lm *ListenMonitor, config client.ServerAgentConfig, credentials client.AgentCredentials, w http.ResponseWriter, r *http.Request) {
+	if !isListenAuthorized(config, credentials, r) {
+		RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	sampleRate := lm.SampleRate()
+	if sampleRate == 0 {
+		RespondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "listen monitor is not running"})
+		return
+	}
+	serveListener(lm, config, sampleRate, w, r)
+}
+
+// serveListener streams audio from a listen monitor to a websocket
+func serveListener(lm *ListenMonitor, config client.ServerAgentConfig, sampleRate int, w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return checkListenOrigin(config, r) }}
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error(err, "Unable to upgrade to websocket")
+		return
+	}
+	defer c.Close()
+
+	encoder, format, err := startListenEncoder(r.URL.Query().Get("format"), sampleRate)
+	if err != nil {
+		log.Error(err, "Unable to start listen encoder")
+		return
+	}
+	defer encoder.Close()
+
+	header := ListenHeader{SampleRate: sampleRate, Channels: ListenChannels, Format: format}
+	if err := c.WriteJSON(header); err != nil {
+		log.Error(err, "Unable to write listen header")
+		return
+	}
+
+	frames := lm.Subscribe()
+	defer lm.Unsubscribe(frames)
+
+	// feed captured audio to the encoder, stopping it when the monitor stops
+	go func() {
+		defer encoder.Close()
+		for frame := range frames {
+			if _, err := encoder.Write(frame); err != nil {
+				return
+			}
+		}
+	}()
+
+	// detect when the listener goes away, which also stops the encoder
+	go func() {
+		defer encoder.Close()
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := encoder.Read(buf)
+		if n > 0 {
+			if err := c.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+					log.Error(err, "Unable to write websocket message")
+				}
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
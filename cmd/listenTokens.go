@@ -0,0 +1,99 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+// handleListenTokenRequest mints a temporary listen token that studio hosts can share with guests
+func handleListenTokenRequest(credentials client.AgentCredentials, w http.ResponseWriter, r *http.Request) {
+	if !isAuthorizedAdminRequest(credentials, r) {
+		RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	var request client.ListenTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !request.IsValid() {
+		RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid listen token request"})
+		return
+	}
+
+	now := time.Now()
+	claims := common.ListenClaims{
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Duration(request.GetTTL()) * time.Second).Unix(),
+		Label:     request.Label,
+	}
+	token, err := common.MintListenToken(credentials.APISecret, claims)
+	if err != nil {
+		log.Error(err, "Failed to mint listen token")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Info("Minted listen token", "label", request.Label, "ttl", request.GetTTL())
+	RespondJSON(w, http.StatusOK, client.ListenToken{Token: token, ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC()})
+}
+
+// getListenToken returns the listen token sent with a request, if any
+// NOTE: HLS players are unable to set headers, so tokens are usually sent as a query parameter
+func getListenToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// isListenAuthorized checks if a request may listen to a broadcast; public broadcasts are open to anyone, while
+// other broadcasts require the agent's credentials or a listen token that has not expired
+func isListenAuthorized(config client.ServerAgentConfig, credentials client.AgentCredentials, r *http.Request) bool {
+	if client.IsStreamPublic(config.Broadcast) || isAuthorizedAdminRequest(credentials, r) {
+		return true
+	}
+	if !client.IsStreamEnabled(config.Broadcast) {
+		return false
+	}
+	_, err := common.VerifyListenToken(credentials.APISecret, getListenToken(r), time.Now())
+	return err == nil
+}
+
+// addPlaylistToken appends a listen token to the URIs in an HLS playlist, so players send it with every request
+func addPlaylistToken(playlist []byte, token string) []byte {
+	param := "token=" + url.QueryEscape(token)
+	lines := bytes.Split(playlist, []byte("\n"))
+	for i, line := range lines {
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 || trimmed[0] == '#' {
+			continue
+		}
+		if bytes.ContainsRune(trimmed, '?') {
+			lines[i] = append(append([]byte{}, trimmed...), []byte("&"+param)...)
+		} else {
+			lines[i] = append(append([]byte{}, trimmed...), []byte("?"+param)...)
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
@@ -0,0 +1,100 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandleListenTokenRequest(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+
+	newRequest := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://example.com/listen/tokens", strings.NewReader(body))
+		req.Header.Set("APIPrefix", "prefix")
+		req.Header.Set("APISecret", "secret")
+		resp := httptest.NewRecorder()
+		handleListenTokenRequest(credentials, resp, req)
+		return resp
+	}
+
+	// Case for unauthorized request
+	resp := httptest.NewRecorder()
+	handleListenTokenRequest(credentials, resp, httptest.NewRequest("POST", "http://example.com/listen/tokens", nil))
+	assert.Equal(401, resp.Code)
+
+	// Case for invalid requests
+	assert.Equal(400, newRequest(`{"ttl":-5}`).Code)
+	assert.Equal(400, newRequest(`not json`).Code)
+
+	// Case for minting a token
+	resp = newRequest(`{"ttl":600,"label":"guests"}`)
+	assert.Equal(200, resp.Code)
+	var token client.ListenToken
+	assert.NoError(json.Unmarshal(resp.Body.Bytes(), &token))
+	assert.WithinDuration(time.Now().Add(10*time.Minute), token.ExpiresAt, 2*time.Second)
+	claims, err := common.VerifyListenToken("secret", token.Token, time.Now())
+	assert.NoError(err)
+	assert.Equal("guests", claims.Label)
+	assert.NotContains(resp.Body.String(), "secret")
+}
+
+func TestIsListenAuthorized(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	config := client.ServerAgentConfig{}
+	valid, _ := common.MintListenToken("secret", common.ListenClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	expired, _ := common.MintListenToken("secret", common.ListenClaims{ExpiresAt: time.Now().Add(-time.Hour).Unix()})
+
+	// Case for public broadcasts
+	config.Broadcast = client.BroadcastPublicWOStemWOVideo
+	assert.True(isListenAuthorized(config, credentials, httptest.NewRequest("GET", "/stream/a.m3u8", nil)))
+
+	// Case for unlisted broadcasts
+	config.Broadcast = client.BroadcastUnlistedWOStemWOVideo
+	assert.False(isListenAuthorized(config, credentials, httptest.NewRequest("GET", "/stream/a.m3u8", nil)))
+	assert.True(isListenAuthorized(config, credentials, httptest.NewRequest("GET", "/stream/a.m3u8?token="+valid, nil)))
+	assert.False(isListenAuthorized(config, credentials, httptest.NewRequest("GET", "/stream/a.m3u8?token="+expired, nil)))
+	req := httptest.NewRequest("GET", "/stream/a.m3u8", nil)
+	req.Header.Set("Authorization", "Bearer "+valid)
+	assert.True(isListenAuthorized(config, credentials, req))
+	req = httptest.NewRequest("GET", "/stream/a.m3u8", nil)
+	req.Header.Set("APIPrefix", "prefix")
+	req.Header.Set("APISecret", "secret")
+	assert.True(isListenAuthorized(config, credentials, req))
+
+	// Case for broadcasts that are disabled
+	config.Broadcast = client.Offline
+	assert.False(isListenAuthorized(config, credentials, httptest.NewRequest("GET", "/stream/a.m3u8?token="+valid, nil)))
+}
+
+func TestAddPlaylistToken(t *testing.T) {
+	assert := assert.New(t)
+	playlist := "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXTINF:2.0,\nsegment0.ts\n#EXTINF:2.0,\nsegment1.ts?v=2\n"
+	assert.Equal("#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXTINF:2.0,\nsegment0.ts?token=a%2Bb\n#EXTINF:2.0,\nsegment1.ts?v=2&token=a%2Bb\n",
+		string(addPlaylistToken([]byte(playlist), "a+b")))
+}
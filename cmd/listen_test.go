@@ -0,0 +1,167 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xthexder/go-jack"
+)
+
+func TestEncodePCM(t *testing.T) {
+	assert := assert.New(t)
+	assert.Nil(encodePCM(nil))
+
+	left := []jack.AudioSample{0, 1, -2}
+	right := []jack.AudioSample{0.5, -1, 2}
+	result := encodePCM([][]jack.AudioSample{left, right})
+	assert.Equal(12, len(result))
+	// samples are interleaved and clipped
+	assert.Equal([]byte{
+		0x00, 0x00, 0xff, 0x3f,
+		0xff, 0x7f, 0x01, 0x80,
+		0x01, 0x80, 0xff, 0x7f,
+	}, result)
+}
+
+func TestEncodePCMInto(t *testing.T) {
+	assert := assert.New(t)
+	dst := make([]byte, 16)
+	result := encodePCMInto(dst, [][]jack.AudioSample{{0, 1}, {0, -1}})
+	assert.Equal(8, len(result))
+	// the destination is reused when it is large enough
+	assert.Equal(&dst[0], &result[0])
+}
+
+func TestPCMRing(t *testing.T) {
+	assert := assert.New(t)
+	ring := newPCMRing(8)
+	buf := make([]byte, 8)
+	assert.Equal(0, ring.Read(buf))
+
+	assert.True(ring.Write([]byte{1, 2, 3, 4, 5, 6}))
+	// writes that do not fit are dropped
+	assert.False(ring.Write([]byte{7, 8, 9}))
+	assert.Equal(4, ring.Read(buf[:4]))
+	assert.Equal([]byte{1, 2, 3, 4}, buf[:4])
+
+	// writes and reads wrap around the end of the ring
+	assert.True(ring.Write([]byte{7, 8, 9, 10, 11, 12}))
+	assert.Equal(8, ring.Read(buf))
+	assert.Equal([]byte{5, 6, 7, 8, 9, 10, 11, 12}, buf)
+}
+
+func TestListenMonitorBroadcast(t *testing.T) {
+	assert := assert.New(t)
+	lm := NewListenMonitor()
+	a := lm.Subscribe()
+	b := lm.Subscribe()
+	assert.Equal(2, lm.Listeners())
+
+	lm.broadcast([]byte{1, 2})
+	assert.Equal([]byte{1, 2}, <-a)
+	assert.Equal([]byte{1, 2}, <-b)
+
+	// Slow listeners drop frames rather than blocking
+	for i := 0; i < listenerBufferSize+5; i++ {
+		lm.broadcast([]byte{byte(i)})
+	}
+	assert.Equal(listenerBufferSize, len(a))
+
+	lm.Unsubscribe(a)
+	lm.Unsubscribe(a)
+	assert.Equal(1, lm.Listeners())
+	lm.Stop()
+	assert.Equal(0, lm.Listeners())
+	_, ok := <-b
+	for ok {
+		_, ok = <-b
+	}
+	assert.False(ok)
+}
+
+func TestHandleListenRequest(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	config := client.ServerAgentConfig{}
+
+	// Case for listeners without a token
+	mockResp := httptest.NewRecorder()
+	mockReq := httptest.NewRequest("GET", "http://example.com/listen", nil)
+	handleListenRequest(NewListenMonitor(), config, credentials, mockResp, mockReq)
+	assert.Equal(401, mockResp.Code)
+
+	// Case for a monitor that is not running
+	config.Broadcast = client.BroadcastPublicWOStemWOVideo
+	mockResp = httptest.NewRecorder()
+	handleListenRequest(NewListenMonitor(), config, credentials, mockResp, mockReq)
+	assert.Equal(503, mockResp.Code)
+}
+
+func TestServeListener(t *testing.T) {
+	assert := assert.New(t)
+	lm := NewListenMonitor()
+	config := client.ServerAgentConfig{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveListener(lm, config, 48000, w, r)
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// Case for pages served from other origins
+	header := http.Header{}
+	header.Set("Origin", "https://evil.example.com")
+	_, resp, err := websocket.DefaultDialer.Dial(url, header)
+	assert.NotNil(err)
+	assert.Equal(403, resp.StatusCode)
+
+	// Case for listeners that ask for PCM
+	c, _, err := websocket.DefaultDialer.Dial(url+"?format=s16le", nil)
+	assert.Nil(err)
+	defer c.Close()
+	var listenHeader ListenHeader
+	assert.Nil(c.ReadJSON(&listenHeader))
+	assert.Equal(ListenHeader{SampleRate: 48000, Channels: ListenChannels, Format: ListenFormatPCM}, listenHeader)
+	for lm.Listeners() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	lm.broadcast([]byte{1, 2, 3, 4})
+	_, msg, err := c.ReadMessage()
+	assert.Nil(err)
+	assert.Equal([]byte{1, 2, 3, 4}, msg)
+
+	// Stopping the monitor disconnects listeners
+	lm.Stop()
+	_, _, err = c.ReadMessage()
+	assert.NotNil(err)
+}
+
+func TestGetOpusEncoderArgs(t *testing.T) {
+	assert := assert.New(t)
+	args := strings.Join(getOpusEncoderArgs(44100), " ")
+	assert.Contains(args, "-f s16le -ar 44100 -ac 2 -i pipe:0")
+	assert.Contains(args, "-c:a libopus")
+	assert.Contains(args, "-f webm")
+}
@@ -0,0 +1,130 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// DefaultLocalRateLimit is the number of requests per minute allowed from each address when not configured
+	DefaultLocalRateLimit = 120
+
+	// rateLimiterMaxClients is the number of addresses tracked before idle ones are forgotten
+	rateLimiterMaxClients = 1000
+)
+
+// rateBucket tracks the requests remaining for one address
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter limits the rate of requests from each address, allowing bursts of up to one minute's worth
+type RateLimiter struct {
+	buckets map[string]*rateBucket
+	mutex   sync.Mutex
+}
+
+// deviceRateLimiter limits requests to the device HTTP server
+var deviceRateLimiter = &RateLimiter{}
+
+// getLocalRateLimit returns the requests per minute allowed from each address
+func getLocalRateLimit(config client.DeviceAgentConfig) int {
+	if config.LocalRateLimit > 0 {
+		return config.LocalRateLimit
+	}
+	return DefaultLocalRateLimit
+}
+
+// Allow returns true if another request from an address is allowed, given a limit in requests per minute
+func (l *RateLimiter) Allow(addr string, limit int, now time.Time) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.buckets == nil {
+		l.buckets = map[string]*rateBucket{}
+	}
+
+	bucket, ok := l.buckets[addr]
+	if !ok {
+		if len(l.buckets) >= rateLimiterMaxClients {
+			l.prune(now)
+		}
+		bucket = &rateBucket{tokens: float64(limit), updated: now}
+		l.buckets[addr] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.updated).Minutes() * float64(limit)
+	if bucket.tokens > float64(limit) {
+		bucket.tokens = float64(limit)
+	}
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// prune forgets addresses that have been idle for over a minute, since their buckets would be full; callers must hold the lock
+func (l *RateLimiter) prune(now time.Time) {
+	for addr, bucket := range l.buckets {
+		if now.Sub(bucket.updated) > time.Minute {
+			delete(l.buckets, addr)
+		}
+	}
+}
+
+// getRequestAddress returns the IP address a request was sent from
+func getRequestAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware rejects requests from addresses that exceed the configured rate limit
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := getLocalRateLimit(deviceState.Config())
+		if !l.Allow(getRequestAddress(r), limit, time.Now()) {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", 60/limit+1))
+			RespondJSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many requests"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireLocalAuth only allows requests signed with the agent's credentials or from paired apps, when local auth is enabled
+func requireLocalAuth(credentials client.AgentCredentials, pairing *PairingManager, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if bool(deviceState.Config().LocalAuth) && !isAuthorizedAdminRequest(credentials, r) && !pairing.IsPaired(getPairingToken(r)) {
+			RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
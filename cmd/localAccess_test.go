@@ -0,0 +1,137 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	assert := assert.New(t)
+	limiter := &RateLimiter{}
+	now := time.Now()
+
+	// Case for bursts up to the limit
+	for i := 0; i < 3; i++ {
+		assert.True(limiter.Allow("10.0.0.2", 3, now))
+	}
+	assert.False(limiter.Allow("10.0.0.2", 3, now))
+
+	// Case for other addresses, which have their own limit
+	assert.True(limiter.Allow("10.0.0.3", 3, now))
+
+	// Case for refilling over time
+	assert.True(limiter.Allow("10.0.0.2", 3, now.Add(20*time.Second)))
+	assert.False(limiter.Allow("10.0.0.2", 3, now.Add(20*time.Second)))
+
+	// Case for forgetting idle addresses
+	for i := 0; i < rateLimiterMaxClients; i++ {
+		limiter.Allow(fmt.Sprintf("10.1.%d.%d", i/256, i%256), 3, now)
+	}
+	assert.True(limiter.Allow("10.0.0.4", 3, now.Add(2*time.Minute)))
+	assert.Len(limiter.buckets, 1)
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	saved := deviceState
+	deviceState = NewStateStore()
+	defer func() { deviceState = saved }()
+	config := client.DeviceAgentConfig{}
+	config.LocalRateLimit = 2
+	deviceState.SetConfig(config)
+
+	handler := (&RateLimiter{}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	newRequest := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com/info", nil)
+		req.RemoteAddr = addr
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+	assert.Equal(200, newRequest("10.0.0.2:5000").Code)
+	assert.Equal(200, newRequest("10.0.0.2:5001").Code)
+	resp := newRequest("10.0.0.2:5002")
+	assert.Equal(429, resp.Code)
+	assert.Equal("31", resp.Header().Get("Retry-After"))
+	assert.Equal(200, newRequest("10.0.0.3:5000").Code)
+}
+
+func TestGetLocalRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	config := client.DeviceAgentConfig{}
+	assert.Equal(DefaultLocalRateLimit, getLocalRateLimit(config))
+	config.LocalRateLimit = 30
+	assert.Equal(30, getLocalRateLimit(config))
+}
+
+func TestRequireLocalAuth(t *testing.T) {
+	assert := assert.New(t)
+	saved := deviceState
+	deviceState = NewStateStore()
+	defer func() { deviceState = saved }()
+	defer func(path string) { PathToPairedApps = path }(PathToPairedApps)
+	PathToPairedApps = filepath.Join(t.TempDir(), "paired-apps.json")
+	pairing := &PairingManager{}
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+
+	handler := requireLocalAuth(credentials, pairing, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(req *http.Request) int {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	// Case for local auth disabled
+	assert.Equal(200, serve(httptest.NewRequest("GET", "http://example.com/info", nil)))
+
+	// Case for local auth enabled
+	config := client.DeviceAgentConfig{}
+	config.LocalAuth = true
+	deviceState.SetConfig(config)
+	assert.Equal(401, serve(httptest.NewRequest("GET", "http://example.com/info", nil)))
+
+	// Case for signed requests
+	req := httptest.NewRequest("GET", "http://example.com/info", nil)
+	req.Header.Set("APIPrefix", "prefix")
+	req.Header.Set("APISecret", "secret")
+	assert.Equal(200, serve(req))
+
+	// Case for paired apps
+	pin, _, err := pairing.Start(time.Now())
+	assert.NoError(err)
+	token, err := pairing.Confirm(pin, "phone", time.Now())
+	assert.NoError(err)
+	req = httptest.NewRequest("GET", "http://example.com/info", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	assert.Equal(200, serve(req))
+	assert.Equal(200, serve(httptest.NewRequest("GET", "http://example.com/info?token="+token, nil)))
+	assert.Equal(401, serve(httptest.NewRequest("GET", "http://example.com/info?token=wrong", nil)))
+}
@@ -0,0 +1,239 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/xthexder/go-jack"
+)
+
+const (
+	// LocalRecordingDir is the directory on the USB storage that local recordings are written to
+	LocalRecordingDir = "jacktrip-recordings"
+
+	// LocalRecorderClientName is the name of the JACK client used to find the local capture ports
+	LocalRecorderClientName = "local-recorder"
+
+	// LocalRecordingInterval is the time between checks of the free space on the USB storage
+	LocalRecordingInterval = 30 * time.Second
+
+	// DefaultLocalRecordingMinFree is the free space to keep on the USB storage, in MB, if it is not configured
+	DefaultLocalRecordingMinFree = 1024
+
+	// localCapturePortToken matches the JACK ports of the device's own inputs
+	localCapturePortToken = `^system:capture_`
+)
+
+// LocalRecorder records the device's own inputs to FLAC on attached USB storage, as a safety recording
+// of the performer while the device is connected to a studio server
+type LocalRecorder struct {
+	storage   func() (string, error)
+	start     func(args []string) (captureProcess, error)
+	freeSpace func(path string) (uint64, error)
+	process   captureProcess
+	enabled   bool
+	status    client.LocalRecordingStatus
+	mutex     sync.Mutex
+}
+
+// NewLocalRecorder constructs a new instance of LocalRecorder, which records to the USB drive whose mount point is returned by storage
+func NewLocalRecorder(storage func() (string, error)) *LocalRecorder {
+	return &LocalRecorder{
+		storage:   storage,
+		start:     startJackCapture,
+		freeSpace: getFreeSpace,
+	}
+}
+
+// getFreeSpace returns the space available to unprivileged users on a filesystem, in bytes
+func getFreeSpace(path string) (uint64, error) {
+	free, _, err := getDiskSpace(path)
+	return free, err
+}
+
+// getLocalRecordingMinFree returns the free space to keep on the USB storage, in bytes
+func getLocalRecordingMinFree(config client.DeviceAgentConfig) uint64 {
+	minFree := config.LocalRecordingMinFree
+	if minFree <= 0 {
+		minFree = DefaultLocalRecordingMinFree
+	}
+	return uint64(minFree) * 1024 * 1024
+}
+
+// getLocalCapturePorts returns the names of the JACK ports of the device's own inputs
+func getLocalCapturePorts() ([]string, error) {
+	graph, err := openJackGraph(LocalRecorderClientName, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer graph.Close()
+	ports := graph.GetPorts(localCapturePortToken, "", jack.PortIsOutput)
+	if len(ports) == 0 {
+		return nil, errors.New("no capture ports are registered")
+	}
+	return ports, nil
+}
+
+// getLocalRecordingArgs returns the jack_capture arguments used to record ports to a FLAC file
+func getLocalRecordingArgs(ports []string, path string) []string {
+	args := []string{"--no-stdin", "--format", "flac", "--channels", strconv.Itoa(len(ports))}
+	for _, port := range ports {
+		args = append(args, "--port", port)
+	}
+	return append(args, path)
+}
+
+// Update starts or stops recording to match a config, and stops recording when the USB storage is nearly full
+func (r *LocalRecorder) Update(config client.DeviceAgentConfig, now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.enabled = bool(config.LocalRecording)
+	if !r.enabled || !bool(config.Enabled) || config.Host == "" {
+		r.stop(now, nil)
+		return
+	}
+
+	minFree := getLocalRecordingMinFree(config)
+	if r.process != nil {
+		free, err := r.freeSpace(filepath.Dir(r.status.File))
+		if err == nil {
+			r.status.FreeSpace = free / 1024 / 1024
+			if free < minFree {
+				err = fmt.Errorf("only %d MB free on USB storage", free/1024/1024)
+			}
+		}
+		if err != nil {
+			r.stop(now, err)
+		}
+		return
+	}
+
+	if err := r.record(minFree, now); err != nil {
+		if r.status.Error != err.Error() {
+			log.Error(err, "Unable to start local recording")
+			r.status.Error = err.Error()
+			r.status.UpdatedAt = now
+		}
+	}
+}
+
+// record starts recording the local capture ports to a new file; callers must hold the lock
+func (r *LocalRecorder) record(minFree uint64, now time.Time) error {
+	mount, err := r.storage()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(mount, LocalRecordingDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	free, err := r.freeSpace(dir)
+	if err != nil {
+		return err
+	}
+	if free < minFree {
+		return fmt.Errorf("only %d MB free on USB storage", free/1024/1024)
+	}
+	ports, err := getLocalCapturePorts()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%s.flac", now.UTC().Format("20060102T150405Z")))
+	process, err := r.start(getLocalRecordingArgs(ports, path))
+	if err != nil {
+		return err
+	}
+	log.Info("Started local recording", "path", path, "channels", len(ports))
+	r.process = process
+	r.status = client.LocalRecordingStatus{
+		Recording: true,
+		File:      path,
+		Channels:  len(ports),
+		FreeSpace: free / 1024 / 1024,
+		UpdatedAt: now,
+	}
+	return nil
+}
+
+// stop finishes the current recording, if any, with the reason it was stopped; callers must hold the lock
+func (r *LocalRecorder) stop(now time.Time, reason error) {
+	if r.process != nil {
+		if err := r.process.Stop(); err != nil {
+			log.Error(err, "Local recording exited with an error", "path", r.status.File)
+		} else {
+			log.Info("Stopped local recording", "path", r.status.File)
+		}
+		r.process = nil
+		r.status.Recording = false
+		r.status.UpdatedAt = now
+	}
+	if reason != nil {
+		log.Error(reason, "Stopped local recording")
+		r.status.Error = reason.Error()
+		r.status.UpdatedAt = now
+	} else if r.status.Error != "" {
+		r.status.Error = ""
+		r.status.UpdatedAt = now
+	}
+}
+
+// Stop finishes the current recording, ie. before JACK is restarted
+func (r *LocalRecorder) Stop() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stop(time.Now(), nil)
+}
+
+// Status returns the status of the local recording, or nil if it is not enabled
+func (r *LocalRecorder) Status() *client.LocalRecordingStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.enabled {
+		return nil
+	}
+	status := r.status
+	return &status
+}
+
+// Run periodically checks the free space on the USB storage, and retries recording after errors (ie. when a USB
+// drive is plugged in), until the context is cancelled; the recording is then finished, so that its file is complete
+func (r *LocalRecorder) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(LocalRecordingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.Stop()
+			return
+		case now := <-ticker.C:
+			r.Update(deviceState.Config(), now)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xthexder/go-jack"
+)
+
+func TestGetLocalRecordingArgs(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]string{"--no-stdin", "--format", "flac", "--channels", "2",
+		"--port", "system:capture_1", "--port", "system:capture_2", "/media/usb/rec.flac"},
+		getLocalRecordingArgs([]string{"system:capture_1", "system:capture_2"}, "/media/usb/rec.flac"))
+
+	config := client.DeviceAgentConfig{}
+	assert.Equal(uint64(DefaultLocalRecordingMinFree*1024*1024), getLocalRecordingMinFree(config))
+	config.LocalRecordingMinFree = 10
+	assert.Equal(uint64(10*1024*1024), getLocalRecordingMinFree(config))
+}
+
+func TestLocalRecorder(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+	dir, err := ioutil.TempDir("", "usb")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	graph := NewFakeJackGraph("jackd")
+	defer func(prev func(string, jack.PortRegistrationCallback, jack.ShutdownCallback) (JackGraph, error)) {
+		openJackGraph = prev
+	}(openJackGraph)
+	openJackGraph = func(string, jack.PortRegistrationCallback, jack.ShutdownCallback) (JackGraph, error) {
+		return graph, nil
+	}
+
+	var started, stopped []string
+	free := uint64(2048 * 1024 * 1024)
+	storageErr := errNoUSBStorage
+	r := NewLocalRecorder(func() (string, error) { return dir, storageErr })
+	r.freeSpace = func(string) (uint64, error) { return free, nil }
+	r.start = func(args []string) (captureProcess, error) {
+		path := args[len(args)-1]
+		started = append(started, path)
+		return fakeCaptureProcess{&stopped, path}, nil
+	}
+
+	// Case for local recording disabled
+	config := client.DeviceAgentConfig{}
+	config.Enabled = true
+	config.Host = "a.b.com"
+	r.Update(config, now)
+	assert.Nil(r.Status())
+
+	// Case for no USB storage
+	config.LocalRecording = true
+	r.Update(config, now)
+	assert.False(r.Status().Recording)
+	assert.Equal("no USB storage is mounted", r.Status().Error)
+	storageErr = nil
+	r.Update(config, now)
+	assert.Equal("no capture ports are registered", r.Status().Error)
+
+	// Case for recording the capture ports
+	graph.RegisterPort("system:capture_1", jack.PortIsOutput)
+	graph.RegisterPort("system:capture_2", jack.PortIsOutput)
+	graph.RegisterPort("system:playback_1", jack.PortIsInput)
+	r.Update(config, now)
+	path := filepath.Join(dir, LocalRecordingDir, "20220501T200000Z.flac")
+	assert.Equal([]string{path}, started)
+	status := r.Status()
+	assert.True(status.Recording)
+	assert.Equal(path, status.File)
+	assert.Equal(2, status.Channels)
+	assert.Equal(uint64(2048), status.FreeSpace)
+	assert.Empty(status.Error)
+	r.Update(config, now.Add(time.Minute))
+	assert.Equal(1, len(started))
+
+	// Case for the USB storage filling up, which is not retried until space is freed
+	free = 512 * 1024 * 1024
+	r.Update(config, now.Add(2*time.Minute))
+	assert.Equal([]string{path}, stopped)
+	assert.False(r.Status().Recording)
+	assert.Equal("only 512 MB free on USB storage", r.Status().Error)
+	r.Update(config, now.Add(3*time.Minute))
+	assert.Equal(1, len(started))
+	free = 2048 * 1024 * 1024
+	r.Update(config, now.Add(4*time.Minute))
+	assert.Equal(2, len(started))
+
+	// Case for disconnecting from the studio server
+	config.Host = ""
+	r.Update(config, now.Add(5*time.Minute))
+	assert.Equal(2, len(stopped))
+	assert.False(r.Status().Recording)
+	assert.Empty(r.Status().Error)
+
+	// Case for disabling local recording
+	config.LocalRecording = false
+	r.Update(config, now.Add(6*time.Minute))
+	assert.Nil(r.Status())
+}
@@ -0,0 +1,287 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// LogForwardingRetryInterval is the time to wait before reconnecting after log forwarding fails
+	LogForwardingRetryInterval = 10 * time.Second
+
+	// syslogFacilityDaemon is the syslog facility used for forwarded service logs
+	syslogFacilityDaemon = 3
+
+	// syslogTimestampFormat is the RFC 5424 timestamp format, which allows at most microsecond precision
+	syslogTimestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// defaultSyslogUnits are the managed systemd units that are forwarded when no filters are configured
+var defaultSyslogUnits = []string{JackServiceName, JackTripServiceName, JamulusServiceName, "zita-*"}
+
+// syslogPriorities maps syslog priority names to journald PRIORITY values
+var syslogPriorities = map[string]int{
+	"emerg":   0,
+	"alert":   1,
+	"crit":    2,
+	"err":     3,
+	"warning": 4,
+	"notice":  5,
+	"info":    6,
+	"debug":   7,
+}
+
+// syslogFilter forwards messages from units matching a glob pattern, up to a maximum priority value
+type syslogFilter struct {
+	unit     string
+	priority int
+}
+
+// journalEntry is a single message read from the systemd journal
+type journalEntry struct {
+	Unit      string
+	Priority  int
+	Timestamp time.Time
+	Message   string
+}
+
+// parseSyslogFilters parses comma-separated "<unit>[=<priority>]" filters; priorities default to info
+func parseSyslogFilters(filters string) ([]syslogFilter, error) {
+	var result []syslogFilter
+	for _, filter := range strings.Split(filters, ",") {
+		filter = strings.TrimSpace(filter)
+		if filter == "" {
+			continue
+		}
+		unit, name := filter, "info"
+		if i := strings.Index(filter, "="); i >= 0 {
+			unit, name = strings.TrimSpace(filter[:i]), strings.ToLower(strings.TrimSpace(filter[i+1:]))
+		}
+		priority, ok := syslogPriorities[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog priority: %s", name)
+		}
+		if _, err := path.Match(unit, ""); err != nil || unit == "" {
+			return nil, fmt.Errorf("invalid syslog unit: %s", unit)
+		}
+		result = append(result, syslogFilter{unit: unit, priority: priority})
+	}
+	if len(result) == 0 {
+		for _, unit := range defaultSyslogUnits {
+			result = append(result, syslogFilter{unit: unit, priority: syslogPriorities["info"]})
+		}
+	}
+	return result, nil
+}
+
+// matchSyslogFilters returns true if the first filter matching a unit allows messages of a priority
+func matchSyslogFilters(filters []syslogFilter, entry journalEntry) bool {
+	for _, filter := range filters {
+		if ok, _ := path.Match(filter.unit, entry.Unit); ok {
+			return entry.Priority <= filter.priority
+		}
+	}
+	return false
+}
+
+// parseJournalEntry parses a line of "journalctl --output json" output
+func parseJournalEntry(line []byte) (journalEntry, error) {
+	var raw struct {
+		Unit      string          `json:"_SYSTEMD_UNIT"`
+		Priority  string          `json:"PRIORITY"`
+		Timestamp string          `json:"__REALTIME_TIMESTAMP"`
+		Message   json.RawMessage `json:"MESSAGE"`
+	}
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return journalEntry{}, err
+	}
+	entry := journalEntry{Unit: raw.Unit, Priority: syslogPriorities["info"]}
+	if priority, err := strconv.Atoi(raw.Priority); err == nil {
+		entry.Priority = priority
+	}
+	if usec, err := strconv.ParseInt(raw.Timestamp, 10, 64); err == nil {
+		entry.Timestamp = time.Unix(0, usec*int64(time.Microsecond))
+	} else {
+		entry.Timestamp = time.Now()
+	}
+	// journald encodes messages that are not valid UTF-8 as arrays of bytes
+	if err := json.Unmarshal(raw.Message, &entry.Message); err != nil {
+		var b []byte
+		var ints []int
+		if json.Unmarshal(raw.Message, &ints) == nil {
+			for _, i := range ints {
+				b = append(b, byte(i))
+			}
+		}
+		entry.Message = string(b)
+	}
+	return entry, nil
+}
+
+// formatSyslogMessage formats a journal entry as an RFC 5424 syslog message
+func formatSyslogMessage(entry journalEntry, hostname string) string {
+	appName := strings.TrimSuffix(entry.Unit, ".service")
+	if appName == "" {
+		appName = "-"
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - - - %s", syslogFacilityDaemon*8+entry.Priority,
+		entry.Timestamp.UTC().Format(syslogTimestampFormat), hostname, appName, strings.TrimRight(entry.Message, "\n"))
+}
+
+// syslogForwarder sends journal entries to a remote syslog server
+type syslogForwarder struct {
+	conn     io.Writer
+	stream   bool
+	hostname string
+	filters  []syslogFilter
+}
+
+// forward reads journal entries until the reader is closed, sending each one that matches the filters
+func (f *syslogForwarder) forward(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		entry, err := parseJournalEntry(scanner.Bytes())
+		if err != nil || !matchSyslogFilters(f.filters, entry) {
+			continue
+		}
+		msg := formatSyslogMessage(entry, f.hostname)
+		// stream transports use octet-counting framing (RFC 6587)
+		if f.stream {
+			msg = fmt.Sprintf("%d %s", len(msg), msg)
+		}
+		if _, err := io.WriteString(f.conn, msg); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// runLogForwarder forwards managed service logs to a remote syslog server until the context is cancelled
+func runLogForwarder(ctx context.Context, config client.LogForwardingConfig) error {
+	filters, err := parseSyslogFilters(config.SyslogFilters)
+	if err != nil {
+		return err
+	}
+	u, err := url.Parse(config.SyslogAddress)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, u.Scheme, u.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	args := []string{"--follow", "--lines", "0", "--output", "json", "--namespace", "+" + common.AgentLogNamespace}
+	for _, filter := range filters {
+		args = append(args, "--unit", filter.unit)
+	}
+	cmd := exec.CommandContext(ctx, common.JournalctlPath, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	defer cmd.Wait()
+
+	hostname, _ := os.Hostname()
+	f := syslogForwarder{conn: conn, stream: u.Scheme == "tcp", hostname: hostname, filters: filters}
+	return f.forward(stdout)
+}
+
+// forwardServiceLogs runs the log forwarder until the context is cancelled, retrying after failures
+func forwardServiceLogs(ctx context.Context, config client.LogForwardingConfig) {
+	log.Info("Forwarding service logs", "address", config.SyslogAddress, "filters", config.SyslogFilters)
+	for {
+		err := runLogForwarder(ctx, config)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error(err, "Service log forwarding stopped", "address", config.SyslogAddress)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(LogForwardingRetryInterval):
+		}
+	}
+}
+
+// deviceLogForwardingHandler forwards managed service logs to the remote syslog server of the current config
+func deviceLogForwardingHandler(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	log.Info("Starting deviceLogForwardingHandler")
+	events := deviceState.Subscribe()
+	defer deviceState.Unsubscribe(events)
+
+	var current client.LogForwardingConfig
+	var forwarderWg sync.WaitGroup
+	cancel := func() {}
+
+	// restart forwarding whenever its settings change
+	apply := func(config client.LogForwardingConfig) {
+		cancel()
+		forwarderWg.Wait()
+		current = config
+		if config.SyslogAddress == "" {
+			return
+		}
+		var forwarderCtx context.Context
+		forwarderCtx, cancel = context.WithCancel(ctx)
+		forwarderWg.Add(1)
+		go func() {
+			defer forwarderWg.Done()
+			forwardServiceLogs(forwarderCtx, config)
+		}()
+	}
+	apply(deviceState.Config().LogForwardingConfig)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping deviceLogForwardingHandler")
+			cancel()
+			forwarderWg.Wait()
+			return
+		case event := <-events:
+			if event.Type == ConfigChanged && event.Config.LogForwardingConfig != current {
+				apply(event.Config.LogForwardingConfig)
+			}
+		}
+	}
+}
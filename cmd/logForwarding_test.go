@@ -0,0 +1,98 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSyslogFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for default filters
+	result, err := parseSyslogFilters("")
+	assert.Nil(err)
+	assert.Equal(len(defaultSyslogUnits), len(result))
+	assert.Equal(syslogFilter{unit: JackServiceName, priority: 6}, result[0])
+
+	// Case for custom filters
+	result, err = parseSyslogFilters("jack.service=Warning, zita-* ,")
+	assert.Nil(err)
+	assert.Equal([]syslogFilter{{unit: "jack.service", priority: 4}, {unit: "zita-*", priority: 6}}, result)
+
+	// Case for invalid filters
+	_, err = parseSyslogFilters("jack.service=loud")
+	assert.NotNil(err)
+	_, err = parseSyslogFilters("=err")
+	assert.NotNil(err)
+	_, err = parseSyslogFilters("zita-[")
+	assert.NotNil(err)
+}
+
+func TestParseJournalEntry(t *testing.T) {
+	assert := assert.New(t)
+	entry, err := parseJournalEntry([]byte(`{"_SYSTEMD_UNIT":"jack.service","PRIORITY":"3","__REALTIME_TIMESTAMP":"1650000000123456","MESSAGE":"xrun"}`))
+	assert.Nil(err)
+	assert.Equal("jack.service", entry.Unit)
+	assert.Equal(3, entry.Priority)
+	assert.Equal("xrun", entry.Message)
+	assert.Equal(time.Date(2022, 4, 15, 5, 20, 0, 123456000, time.UTC), entry.Timestamp.UTC())
+
+	// Case for binary message
+	entry, err = parseJournalEntry([]byte(`{"_SYSTEMD_UNIT":"jack.service","MESSAGE":[104,105]}`))
+	assert.Nil(err)
+	assert.Equal("hi", entry.Message)
+	assert.Equal(6, entry.Priority)
+
+	_, err = parseJournalEntry([]byte(`not json`))
+	assert.NotNil(err)
+}
+
+func TestFormatSyslogMessage(t *testing.T) {
+	assert := assert.New(t)
+	entry := journalEntry{Unit: "zita-a2j@USB.service", Priority: 4, Timestamp: time.Date(2022, 4, 15, 5, 20, 0, 123456000, time.UTC), Message: "resync\n"}
+	assert.Equal("<28>1 2022-04-15T05:20:00.123456Z pi zita-a2j@USB - - - resync", formatSyslogMessage(entry, "pi"))
+}
+
+func TestSyslogForwarder(t *testing.T) {
+	assert := assert.New(t)
+	filters, _ := parseSyslogFilters("jack.service=err,zita-*=info")
+	journal := strings.Join([]string{
+		`{"_SYSTEMD_UNIT":"jack.service","PRIORITY":"3","__REALTIME_TIMESTAMP":"0","MESSAGE":"a"}`,
+		`{"_SYSTEMD_UNIT":"jack.service","PRIORITY":"6","__REALTIME_TIMESTAMP":"0","MESSAGE":"b"}`,
+		`{"_SYSTEMD_UNIT":"zita-j2a@USB.service","PRIORITY":"6","__REALTIME_TIMESTAMP":"0","MESSAGE":"c"}`,
+		`{"_SYSTEMD_UNIT":"jacktrip.service","PRIORITY":"0","__REALTIME_TIMESTAMP":"0","MESSAGE":"d"}`,
+	}, "\n")
+
+	// Case for datagram transport
+	var out bytes.Buffer
+	f := syslogForwarder{conn: &out, hostname: "pi", filters: filters}
+	assert.Nil(f.forward(strings.NewReader(journal)))
+	assert.Equal("<27>1 1970-01-01T00:00:00.000000Z pi jack - - - a<30>1 1970-01-01T00:00:00.000000Z pi zita-j2a@USB - - - c", out.String())
+
+	// Case for stream transport
+	out.Reset()
+	f.stream = true
+	assert.Nil(f.forward(strings.NewReader(journal)))
+	assert.True(strings.HasPrefix(out.String(), "49 <27>1 1970-01-01T00:00:00.000000Z pi jack - - - a57 <30>1"))
+}
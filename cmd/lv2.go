@@ -0,0 +1,262 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// ModHostServiceName is the name of the systemd service for the mod-host LV2 plugin host
+	ModHostServiceName = "mod-host.service"
+
+	// ModHostAddress is the address of the mod-host command socket
+	ModHostAddress = "127.0.0.1:5555"
+
+	// ModHostTimeout is the maximum time to wait for a mod-host command to complete
+	ModHostTimeout = 5 * time.Second
+
+	// PathToLV2Plugins is the directory that LV2 plugin bundles are installed into
+	PathToLV2Plugins = "/usr/lib/lv2"
+
+	// lv2InstancesPerChannel is used to allocate mod-host instance numbers for each channel
+	lv2InstancesPerChannel = 100
+)
+
+// LV2Plugin describes a whitelisted LV2 plugin
+type LV2Plugin struct {
+	// URI of the plugin
+	URI string
+
+	// Bundle directory name, relative to PathToLV2Plugins
+	Bundle string
+
+	// Symbol of the audio input port
+	Input string
+
+	// Symbol of the audio output port
+	Output string
+}
+
+// lv2Plugins maps the LV2 plugins that may be loaded to their descriptions
+var lv2Plugins = map[string]LV2Plugin{
+	"gate": {
+		URI:    "http://lsp-plug.in/plugins/lv2/gate_mono",
+		Bundle: "lsp-plugins.lv2",
+		Input:  "in",
+		Output: "out",
+	},
+	"eq": {
+		URI:    "http://lsp-plug.in/plugins/lv2/para_equalizer_x8_mono",
+		Bundle: "lsp-plugins.lv2",
+		Input:  "in",
+		Output: "out",
+	},
+	"compressor": {
+		URI:    "http://lsp-plug.in/plugins/lv2/compressor_mono",
+		Bundle: "lsp-plugins.lv2",
+		Input:  "in",
+		Output: "out",
+	},
+	"reverb": {
+		URI:    "http://calf.sourceforge.net/plugins/Reverb",
+		Bundle: "calf.lv2",
+		Input:  "in_l",
+		Output: "out_l",
+	},
+}
+
+// LV2Parameter is a control port value for a loaded LV2 plugin
+type LV2Parameter struct {
+	// Position of the plugin in the chain
+	Index int
+
+	// Symbol of the control port
+	Symbol string
+
+	// Value of the control port
+	Value float64
+}
+
+// parseLV2Plugins parses a comma-separated list of whitelisted LV2 plugin names
+func parseLV2Plugins(plugins string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(plugins, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := lv2Plugins[name]; !ok {
+			return nil, fmt.Errorf("unsupported LV2 plugin: %s", name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// parseLV2Parameters parses a comma-separated list of parameters, each formatted as "<index>.<symbol>=<value>"
+func parseLV2Parameters(params string) ([]LV2Parameter, error) {
+	var result []LV2Parameter
+	for _, param := range strings.Split(params, ",") {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		keyValue := strings.SplitN(param, "=", 2)
+		indexSymbol := strings.SplitN(keyValue[0], ".", 2)
+		if len(keyValue) != 2 || len(indexSymbol) != 2 || indexSymbol[1] == "" {
+			return nil, fmt.Errorf("invalid LV2 parameter: %s", param)
+		}
+		index, err := strconv.Atoi(indexSymbol[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid LV2 parameter index: %s", param)
+		}
+		value, err := strconv.ParseFloat(keyValue[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LV2 parameter value: %s", param)
+		}
+		result = append(result, LV2Parameter{Index: index, Symbol: indexSymbol[1], Value: value})
+	}
+	return result, nil
+}
+
+// isLV2ChainEnabled returns true if a device config requires LV2 plugins to be loaded
+func isLV2ChainEnabled(config client.DeviceAgentConfig) bool {
+	names, err := parseLV2Plugins(config.LV2Plugins)
+	return err == nil && len(names) > 0 && usesJackTrip(config)
+}
+
+// getLV2Instance returns the mod-host instance number for a plugin in the chain of a channel
+func getLV2Instance(channel, index int) int {
+	return channel*lv2InstancesPerChannel + index
+}
+
+// getLV2PortName returns the JACK port name for a port of a mod-host plugin instance
+func getLV2PortName(instance int, symbol string) string {
+	return fmt.Sprintf("effect_%d:%s", instance, symbol)
+}
+
+// isLV2Port returns true if a JACK port belongs to mod-host
+func isLV2Port(name string) bool {
+	return strings.HasPrefix(name, "effect_")
+}
+
+// sendModHostCommand sends a single command to mod-host and waits for the response
+func sendModHostCommand(command string) error {
+	conn, err := net.DialTimeout("tcp", ModHostAddress, ModHostTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ModHostTimeout))
+
+	if _, err := conn.Write(append([]byte(command), 0)); err != nil {
+		return err
+	}
+
+	// responses are formatted as "resp <status>", where a negative status is an error
+	response, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(strings.TrimRight(response, "\x00"))
+	if len(fields) < 2 || fields[0] != "resp" {
+		return fmt.Errorf("unexpected mod-host response: %s", response)
+	}
+	if status, err := strconv.Atoi(fields[1]); err != nil || status < 0 {
+		return fmt.Errorf("mod-host command failed: command=%s, response=%s", command, response)
+	}
+	return nil
+}
+
+// updateLV2Plugins loads the LV2 plugins for each channel into mod-host and applies their parameters
+func updateLV2Plugins(config client.DeviceAgentConfig) {
+	names, err := parseLV2Plugins(config.LV2Plugins)
+	if err != nil {
+		log.Error(err, "Invalid LV2 plugins", "value", config.LV2Plugins)
+		return
+	}
+
+	// mod-host may still be starting up
+	err = common.RetryWithBackoff(func() error {
+		return sendModHostCommand("remove -1")
+	})
+	if err != nil {
+		log.Error(err, "Unable to connect to mod-host")
+		return
+	}
+
+	for channel := 1; channel <= getSendChannels(config); channel++ {
+		for index, name := range names {
+			plugin := lv2Plugins[name]
+			if _, err := os.Stat(fmt.Sprintf("%s/%s", PathToLV2Plugins, plugin.Bundle)); errors.Is(err, os.ErrNotExist) {
+				log.Error(err, "LV2 plugin is not installed", "name", name, "bundle", plugin.Bundle)
+				continue
+			}
+			instance := getLV2Instance(channel, index)
+			if err := sendModHostCommand(fmt.Sprintf("add %s %d", plugin.URI, instance)); err != nil {
+				log.Error(err, "Unable to load LV2 plugin", "name", name, "instance", instance)
+			}
+		}
+	}
+	log.Info("Loaded LV2 plugins", "plugins", names)
+
+	updateLV2Parameters(config)
+}
+
+// updateLV2Parameters applies LV2 plugin parameters to all loaded plugin instances
+func updateLV2Parameters(config client.DeviceAgentConfig) {
+	params, err := parseLV2Parameters(config.LV2Parameters)
+	if err != nil {
+		log.Error(err, "Invalid LV2 parameters", "value", config.LV2Parameters)
+		return
+	}
+	for channel := 1; channel <= getSendChannels(config); channel++ {
+		for _, param := range params {
+			instance := getLV2Instance(channel, param.Index)
+			if err := sendModHostCommand(fmt.Sprintf("param_set %d %s %f", instance, param.Symbol, param.Value)); err != nil {
+				log.Error(err, "Unable to set LV2 parameter", "instance", instance, "symbol", param.Symbol)
+			}
+		}
+	}
+}
+
+// getLV2Stages returns the input and output ports of each LV2 plugin for a channel, in order
+func getLV2Stages(config client.DeviceAgentConfig, channel int) [][2]string {
+	var stages [][2]string
+	names, err := parseLV2Plugins(config.LV2Plugins)
+	if err != nil {
+		return stages
+	}
+	for index, name := range names {
+		plugin := lv2Plugins[name]
+		instance := getLV2Instance(channel, index)
+		stages = append(stages, [2]string{getLV2PortName(instance, plugin.Input), getLV2PortName(instance, plugin.Output)})
+	}
+	return stages
+}
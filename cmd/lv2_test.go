@@ -0,0 +1,76 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLV2Plugins(t *testing.T) {
+	assert := assert.New(t)
+
+	result, err := parseLV2Plugins("")
+	assert.Nil(err)
+	assert.Equal(0, len(result))
+
+	result, err = parseLV2Plugins("Gate, reverb")
+	assert.Nil(err)
+	assert.Equal([]string{"gate", "reverb"}, result)
+
+	// Case for plugin that is not whitelisted
+	_, err = parseLV2Plugins("gate,http://example.com/plugins/evil")
+	assert.NotNil(err)
+}
+
+func TestParseLV2Parameters(t *testing.T) {
+	assert := assert.New(t)
+
+	result, err := parseLV2Parameters("")
+	assert.Nil(err)
+	assert.Equal(0, len(result))
+
+	result, err = parseLV2Parameters("0.threshold=-30, 1.wet=0.5")
+	assert.Nil(err)
+	assert.Equal([]LV2Parameter{{Index: 0, Symbol: "threshold", Value: -30}, {Index: 1, Symbol: "wet", Value: 0.5}}, result)
+
+	// Case for invalid parameters
+	for _, params := range []string{"threshold=-30", "a.threshold=-30", "0.threshold", "0.=1", "0.threshold=loud"} {
+		_, err = parseLV2Parameters(params)
+		assert.NotNil(err, params)
+	}
+}
+
+func TestGetInputChainStages(t *testing.T) {
+	assert := assert.New(t)
+	config := client.DeviceAgentConfig{}
+	config.Type = client.JackTrip
+	assert.Equal(0, len(getInputChainStages(config, 1)))
+	assert.False(isInputChainEnabled(config))
+
+	// Case for LV2 plugins only
+	config.LV2Plugins = "gate,reverb"
+	assert.True(isInputChainEnabled(config))
+	assert.Equal([][2]string{{"effect_200:in", "effect_200:out"}, {"effect_201:in_l", "effect_201:out_l"}}, getInputChainStages(config, 2))
+
+	// Case for effects chain followed by LV2 plugins
+	config.EffectsChain = "compressor"
+	assert.Equal([][2]string{{"effects:in_1", "effects:out_1"}, {"effect_100:in", "effect_100:out"}, {"effect_101:in_l", "effect_101:out_l"}}, getInputChainStages(config, 1))
+}
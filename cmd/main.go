@@ -20,14 +20,6 @@ import (
 	"os"
 )
 
-const (
-	// AgentConfigDir is the directory containing agent config files
-	AgentConfigDir = "/etc/jacktrip"
-
-	// AgentLibDir is the directory containing additional files used by the agent
-	AgentLibDir = "/var/lib/jacktrip"
-)
-
 // GitSHA is the commit hash of the current build
 var GitSHA string
 
@@ -37,17 +29,15 @@ func main() {
 	version := flag.Bool("v", false, "display version and exit")
 	flag.Parse()
 
+	if err := loadAgentPaths(); err != nil {
+		log.Error(err, "Unable to load agent paths")
+		os.Exit(1)
+	}
+
 	if *version {
 		fmt.Printf("Git SHA: %s\n", GitSHA)
 		return
 	}
 
-	// require this be run as root
-	if os.Geteuid() != 0 {
-		log.Info("jacktrip-agent must be run as root")
-		os.Exit(1)
-	}
-
-	runOnDevice(*apiOrigin)
-	log.Info("Exiting")
+	runAgent(*apiOrigin)
 }
@@ -0,0 +1,67 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"flag"
+	"os"
+)
+
+var (
+	metricsFlag  = flag.Bool("m", false, "display device metrics and exit")
+	doctorFlag   = flag.Bool("d", false, "run self-diagnostic checks and exit")
+	simulateFlag = flag.Bool("simulate", false, "simulate sound cards, JACK and systemd services, for running without audio hardware")
+	desktopFlag  = flag.Bool("desktop", false, "run on a Windows, macOS or Linux workstation, using the local JACK and a bundled jacktrip")
+)
+
+// runAgent runs the device agent, or one of its diagnostic commands
+func runAgent(apiOrigin string) {
+	if *simulateFlag {
+		enableSimulation()
+	} else if *desktopFlag {
+		enableDesktopMode()
+	} else if runtime := getContainerRuntime(ContainerRootDir, ProcDir, os.Getenv); runtime != "" {
+		enableContainerMode(runtime)
+	}
+
+	if *doctorFlag {
+		if !printDoctorReport(runDoctor(apiOrigin)) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *metricsFlag {
+		if err := printDeviceMetrics(); err != nil {
+			log.Error(err, "Unable to collect device metrics")
+			os.Exit(1)
+		}
+		return
+	}
+
+	// require root or the capabilities needed by the agent, unless hardware is simulated or on a desktop
+	if !*simulateFlag && !*desktopFlag {
+		if err := checkPrivileges(os.Geteuid()); err != nil {
+			log.Error(err, "Insufficient privileges")
+			os.Exit(1)
+		}
+	}
+
+	runOnDevice(apiOrigin, *simulateFlag, *desktopFlag && !*simulateFlag)
+	log.Info("Exiting")
+}
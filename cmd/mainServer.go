@@ -0,0 +1,35 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build server
+// +build server
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+)
+
+var cloudIDFlag = flag.String("cloud-id", "", "cloud id reported in heartbeats (defaults to the hostname)")
+
+// runAgent runs the server agent
+func runAgent(apiOrigin string) {
+	if os.Geteuid() != 0 {
+		log.Error(errors.New("jacktrip-agent must run as root on audio servers"), "Insufficient privileges")
+		os.Exit(1)
+	}
+	runOnServer(apiOrigin, *cloudIDFlag)
+}
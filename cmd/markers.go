@@ -0,0 +1,158 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+// ErrNoActiveRecording is returned when a marker is added while nothing is being recorded
+var ErrNoActiveRecording = errors.New("no recording is active")
+
+// MetaflacPath is the path to metaflac, used to write markers to captured FLAC files
+const MetaflacPath = "/usr/bin/metaflac"
+
+// ActiveRecording tracks the manifest of the session being recorded
+type ActiveRecording struct {
+	manifest *common.SessionManifest
+	mutex    sync.Mutex
+}
+
+// Start begins tracking a new session
+func (a *ActiveRecording) Start(manifest common.SessionManifest) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.manifest = &manifest
+}
+
+// Stop stops tracking the current session, returning its manifest with all markers
+func (a *ActiveRecording) Stop() (common.SessionManifest, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.manifest == nil {
+		return common.SessionManifest{}, ErrNoActiveRecording
+	}
+	manifest := *a.manifest
+	a.manifest = nil
+	return manifest, nil
+}
+
+// AddMarker adds a marker to the current session
+func (a *ActiveRecording) AddMarker(label string, at time.Time) (common.SessionMarker, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.manifest == nil {
+		return common.SessionMarker{}, ErrNoActiveRecording
+	}
+	return a.manifest.AddMarker(label, at), nil
+}
+
+// AddTrack adds a file that started recording to the current session
+func (a *ActiveRecording) AddTrack(name, file string, at time.Time) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.manifest == nil {
+		return ErrNoActiveRecording
+	}
+	a.manifest.AddTrack(name, file, at)
+	return nil
+}
+
+// HLSTags returns the HLS tags of all markers in the current session; the recorder rewrites its playlists
+// with every segment, so they are added each time a playlist is served or relayed
+func (a *ActiveRecording) HLSTags() []string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.manifest == nil {
+		return nil
+	}
+	var tags []string
+	for i, marker := range a.manifest.Markers {
+		tags = append(tags, common.GetHLSMarkerTag(marker, i+1))
+	}
+	return tags
+}
+
+// addPlaylistMarkers inserts marker tags before the first segment of an HLS media playlist; playlists
+// without a program date are left alone, since players can't place markers on their timeline
+func addPlaylistMarkers(playlist []byte, tags []string) []byte {
+	if len(tags) == 0 || !bytes.Contains(playlist, []byte("#EXT-X-PROGRAM-DATE-TIME")) {
+		return playlist
+	}
+	insert := []byte(strings.Join(tags, "\n") + "\n")
+	i := bytes.Index(playlist, []byte("#EXTINF"))
+	if i < 0 {
+		i = len(playlist)
+	}
+	result := make([]byte, 0, len(playlist)+len(insert))
+	result = append(result, playlist[:i]...)
+	result = append(result, insert...)
+	return append(result, playlist[i:]...)
+}
+
+// tagFLACMarkers writes the markers of a session to its captured FLAC files as chapter comments, once the
+// files are complete
+func tagFLACMarkers(dir string, manifest common.SessionManifest) {
+	for _, track := range manifest.Tracks {
+		comments := common.GetFLACMarkerComments(manifest.TrackMarkers(track))
+		if len(comments) == 0 || filepath.Ext(track.File) != ".flac" {
+			continue
+		}
+		args := []string{}
+		for _, comment := range comments {
+			args = append(args, "--set-tag="+comment)
+		}
+		path := filepath.Join(dir, track.File)
+		if _, err := systemRunner.Output(MetaflacPath, append(args, path)...); err != nil {
+			log.Error(err, "Unable to write markers to FLAC file", "path", path)
+		}
+	}
+}
+
+// handleRecordMarkerRequest adds a named marker to the active recording
+func handleRecordMarkerRequest(recording *ActiveRecording, credentials client.AgentCredentials, w http.ResponseWriter, r *http.Request) {
+	if !isAuthorizedAdminRequest(credentials, r) {
+		RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	var request client.RecordingMarker
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !request.IsValid() {
+		RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid marker"})
+		return
+	}
+
+	marker, err := recording.AddMarker(request.Label, time.Now())
+	if err != nil {
+		RespondJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Info("Added recording marker", "label", marker.Label, "offset", marker.Offset)
+	RespondJSON(w, http.StatusOK, marker)
+}
@@ -0,0 +1,136 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActiveRecording(t *testing.T) {
+	assert := assert.New(t)
+	var recording ActiveRecording
+	start := time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+
+	_, err := recording.AddMarker("Intro", start)
+	assert.Equal(ErrNoActiveRecording, err)
+	_, err = recording.Stop()
+	assert.Equal(ErrNoActiveRecording, err)
+
+	recording.Start(common.SessionManifest{Name: "rehearsal", StartedAt: start})
+	marker, err := recording.AddMarker("Intro", start.Add(5*time.Second))
+	assert.NoError(err)
+	assert.Equal(5.0, marker.Offset)
+	_, err = recording.AddMarker("Verse", start.Add(65*time.Second))
+	assert.NoError(err)
+
+	assert.NoError(recording.AddTrack("alice", "alice.flac", start.Add(10*time.Second)))
+
+	// markers stay in playlists for the rest of the session
+	tags := recording.HLSTags()
+	assert.Len(tags, 2)
+	assert.Contains(tags[1], `ID="marker-2"`)
+	assert.Len(recording.HLSTags(), 2)
+
+	manifest, err := recording.Stop()
+	assert.NoError(err)
+	assert.Equal("rehearsal", manifest.Name)
+	assert.Len(manifest.Markers, 2)
+	assert.Equal("Verse", manifest.Markers[1].Label)
+	assert.Equal([]common.SessionTrack{{Name: "alice", File: "alice.flac", Offset: 10}}, manifest.Tracks)
+	assert.Empty(recording.HLSTags())
+	assert.Equal(ErrNoActiveRecording, recording.AddTrack("bob", "bob.flac", start))
+}
+
+func TestAddPlaylistMarkers(t *testing.T) {
+	assert := assert.New(t)
+	playlist := []byte("#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-PROGRAM-DATE-TIME:2022-05-01T20:00:00.000Z\n#EXTINF:4.0,\nseg1.ts\n")
+	tags := []string{"#EXT-X-DATERANGE:ID=\"marker-1\""}
+
+	// Case for a media playlist
+	assert.Equal("#EXTM3U\n#EXT-X-TARGETDURATION:4\n#EXT-X-PROGRAM-DATE-TIME:2022-05-01T20:00:00.000Z\n"+
+		"#EXT-X-DATERANGE:ID=\"marker-1\"\n#EXTINF:4.0,\nseg1.ts\n", string(addPlaylistMarkers(playlist, tags)))
+	assert.Equal(playlist, addPlaylistMarkers(playlist, nil))
+
+	// Case for playlists without a program date, such as master playlists
+	master := []byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=128000\nlow.m3u8\n")
+	assert.Equal(master, addPlaylistMarkers(master, tags))
+}
+
+func TestTagFLACMarkers(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prev SystemRunner) { systemRunner = prev }(systemRunner)
+	runner := NewFakeRunner()
+	systemRunner = runner
+	start := time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+	manifest := common.SessionManifest{StartedAt: start}
+	manifest.AddTrack("alice", "alice.flac", start)
+	manifest.AddTrack("bob", "bob.wav", start)
+	manifest.AddTrack("carol", "carol.flac", start.Add(time.Minute))
+	manifest.AddMarker("Intro", start.Add(5*time.Second))
+
+	// Case for no markers
+	tagFLACMarkers("/rec", common.SessionManifest{Tracks: manifest.Tracks})
+	assert.Empty(runner.Commands)
+
+	// Case for markers, which are only written to FLAC tracks that were recording
+	tagFLACMarkers("/rec", manifest)
+	assert.Equal([]string{MetaflacPath + " --set-tag=CHAPTER001=00:00:05.000 --set-tag=CHAPTER001NAME=Intro /rec/alice.flac"}, runner.Commands)
+}
+
+func TestHandleRecordMarkerRequest(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	var recording ActiveRecording
+
+	newRequest := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "http://example.com/record/marker", strings.NewReader(body))
+		req.Header.Set("APIPrefix", "prefix")
+		req.Header.Set("APISecret", "secret")
+		resp := httptest.NewRecorder()
+		handleRecordMarkerRequest(&recording, credentials, resp, req)
+		return resp
+	}
+
+	// Case for unauthorized request
+	resp := httptest.NewRecorder()
+	handleRecordMarkerRequest(&recording, credentials, resp, httptest.NewRequest("POST", "http://example.com/record/marker", nil))
+	assert.Equal(401, resp.Code)
+
+	// Case for invalid marker
+	assert.Equal(400, newRequest(`{"label":""}`).Code)
+	assert.Equal(400, newRequest(`not json`).Code)
+
+	// Case for no active recording
+	assert.Equal(409, newRequest(`{"label":"Take 1"}`).Code)
+
+	// Case for an active recording
+	recording.Start(common.SessionManifest{Name: "rehearsal", StartedAt: time.Now()})
+	resp = newRequest(`{"label":"Take 1"}`)
+	assert.Equal(200, resp.Code)
+	assert.Contains(resp.Body.String(), `"label":"Take 1"`)
+	manifest, err := recording.Stop()
+	assert.NoError(err)
+	assert.Len(manifest.Markers, 1)
+}
@@ -0,0 +1,117 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/xthexder/go-jack"
+)
+
+// MetricsInterval is the time between collecting device metrics
+const MetricsInterval = 30 * time.Second
+
+// CollectMetrics returns the number of JACK ports and connections
+func (ac *AutoConnector) CollectMetrics() (int, int) {
+	ac.ClientLock.Lock()
+	defer ac.ClientLock.Unlock()
+	if ac.JackClient == nil {
+		return 0, 0
+	}
+
+	ports := ac.JackClient.GetPorts("", "", 0)
+	connections := 0
+	// only count from output ports, so that each connection is counted once
+	for _, name := range ac.JackClient.GetPorts("", "", jack.PortIsOutput) {
+		connections += len(ac.JackClient.GetConnections(name))
+	}
+	return len(ports), connections
+}
+
+// CollectMetrics returns the number of running zita capture and playback bridges
+func (dmm *DeviceMixingManager) CollectMetrics() (int, int) {
+	dmm.mutex.Lock()
+	defer dmm.mutex.Unlock()
+	return len(dmm.CurrentCaptureDevices), len(dmm.CurrentPlaybackDevices)
+}
+
+// collectDeviceMetrics gathers metrics from the autoconnector, device mixer and JACK logs
+func collectDeviceMetrics(ac *AutoConnector, stats client.PingStats, dmm *DeviceMixingManager, xruns *common.LogCounter) client.DeviceMetrics {
+	metrics := client.DeviceMetrics{CollectedAt: time.Now(), PingStats: stats}
+	metrics.JackPorts, metrics.JackConnections = ac.CollectMetrics()
+	if dmm != nil {
+		metrics.ZitaCaptureBridges, metrics.ZitaPlaybackBridges = dmm.CollectMetrics()
+	}
+	var err error
+	if metrics.Xruns, err = xruns.Count(); err != nil {
+		log.V(1).Info("Unable to count JACK xruns", "error", err.Error())
+	}
+	metrics.ClockSync = getClockSyncStatus(deviceState.Config().ClockSyncConfig)
+	if metrics.Bandwidth, err = deviceBandwidth.Usage(); err != nil {
+		log.V(1).Info("Unable to read JackTrip bandwidth usage", "error", err.Error())
+	}
+	return metrics
+}
+
+// deviceMetricsHandler periodically collects device metrics, which are sent with heartbeats
+func (a *DeviceAgent) deviceMetricsHandler(ctx context.Context, wg *sync.WaitGroup, dmm *DeviceMixingManager) {
+	defer wg.Done()
+	log.Info("Starting deviceMetricsHandler")
+	// only new JACK log lines are scanned each interval
+	xruns := common.NewXrunCounter(JackServiceName, time.Now())
+	lastXruns := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping deviceMetricsHandler")
+			return
+		case <-time.After(MetricsInterval):
+			metrics := collectDeviceMetrics(a.AutoConnector, deviceState.Heartbeat().PingStats, dmm, xruns)
+			deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) { beat.Metrics = &metrics })
+			a.Timeline.RecordXruns(lastXruns, metrics.Xruns)
+			lastXruns = metrics.Xruns
+		}
+	}
+}
+
+// printDeviceMetrics collects device metrics once and prints them to stdout
+func printDeviceMetrics() error {
+	// NOTE: use a passive JACK client, so that no ports are connected as a side effect
+	jackClient, err := common.InitJackClient("metrics", nil, nil, nil, nil, false)
+	if err != nil {
+		return err
+	}
+	ac := NewAutoConnector()
+	ac.JackClient = jackClientGraph{jackClient}
+	defer ac.TeardownClient()
+
+	metrics := collectDeviceMetrics(ac, client.PingStats{}, nil, common.NewXrunCounter(JackServiceName, time.Now().Add(-24*time.Hour)))
+	out, err := json.MarshalIndent(metrics, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
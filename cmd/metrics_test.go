@@ -0,0 +1,51 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollectDeviceMetrics(t *testing.T) {
+	assert := assert.New(t)
+	dmm := DeviceMixingManager{
+		CurrentCaptureDevices:  map[string]bool{"one": true, "two": true},
+		CurrentPlaybackDevices: map[string]bool{"one": true},
+	}
+	capture, playback := dmm.CollectMetrics()
+	assert.Equal(2, capture)
+	assert.Equal(1, playback)
+
+	// Case for no JACK client
+	metrics := collectDeviceMetrics(NewAutoConnector(), client.PingStats{PacketsRecv: 5}, &dmm, common.NewXrunCounter(JackServiceName, time.Now()))
+	assert.Equal(0, metrics.JackPorts)
+	assert.Equal(0, metrics.JackConnections)
+	assert.Equal(2, metrics.ZitaCaptureBridges)
+	assert.Equal(1, metrics.ZitaPlaybackBridges)
+	assert.Equal(5, metrics.PingStats.PacketsRecv)
+	assert.False(metrics.CollectedAt.IsZero())
+
+	ports, connections := NewAutoConnector().CollectMetrics()
+	assert.Equal(0, ports)
+	assert.Equal(0, connections)
+}
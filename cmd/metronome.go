@@ -0,0 +1,94 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/xthexder/go-jack"
+)
+
+const (
+	// MetronomeServiceName is the name of the systemd service for the metronome
+	MetronomeServiceName = "metronome.service"
+
+	// MetronomeConfigTemplate is the template used to generate /tmp/default/metronome file on raspberry pi devices
+	MetronomeConfigTemplate = "METRONOME_OPTS=-n %s -b %d\n"
+
+	// MetronomeClientName is the JACK client name used by the metronome
+	MetronomeClientName = "metronome"
+
+	// DefaultMetronomeBPM is the tempo used when none is configured
+	DefaultMetronomeBPM = 120
+
+	// Local monitor output ports
+	systemPlaybackLeft  = "system:playback_1"
+	systemPlaybackRight = "system:playback_2"
+)
+
+// getMetronomeBPM returns the configured metronome tempo, or the default tempo
+func getMetronomeBPM(config client.DeviceAgentConfig) int {
+	if config.MetronomeBPM < 1 {
+		return DefaultMetronomeBPM
+	}
+	return config.MetronomeBPM
+}
+
+// updateMetronomeConfig writes the metronome service config file
+func updateMetronomeConfig(config client.DeviceAgentConfig) {
+	metronomeConfig := fmt.Sprintf(MetronomeConfigTemplate, MetronomeClientName, getMetronomeBPM(config))
+	_, err := common.WriteFileIfChanged(PathToMetronomeConfig, []byte(metronomeConfig), 0644)
+	if err != nil {
+		log.Error(err, "Failed to save metronome config", "path", PathToMetronomeConfig)
+	}
+}
+
+// isMetronomePort returns true if a JACK port belongs to the metronome
+func isMetronomePort(name string) bool {
+	return strings.HasPrefix(name, MetronomeClientName+":")
+}
+
+// getMetronomeDestinations returns the ports that metronome audio should be sent to
+func (ac *AutoConnector) getMetronomeDestinations(routing client.MetronomeRouting) []string {
+	var dests []string
+	if routing == client.MetronomeMonitor || routing == client.MetronomeMonitorAndServer || routing == "" {
+		dests = append(dests, systemPlaybackLeft, systemPlaybackRight)
+	}
+	if routing == client.MetronomeServer || routing == client.MetronomeMonitorAndServer {
+		if serverPortName := ac.getServerPortName(1, true); serverPortName != "" {
+			dests = append(dests, serverPortName)
+		}
+	}
+	return dests
+}
+
+// connectMetronomePorts routes metronome audio to the local monitor and/or the audio server
+func (ac *AutoConnector) connectMetronomePorts(routing client.MetronomeRouting) {
+	ports := ac.JackClient.GetPorts(MetronomeClientName+":", "", jack.PortIsOutput)
+	dests := ac.getMetronomeDestinations(routing)
+	for _, port := range ports {
+		for _, dest := range dests {
+			if ac.isValidPort(dest) {
+				ac.connectPorts(port, dest)
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMetronomeBPM(t *testing.T) {
+	assert := assert.New(t)
+	config := client.DeviceAgentConfig{}
+	assert.Equal(DefaultMetronomeBPM, getMetronomeBPM(config))
+	config.MetronomeBPM = -5
+	assert.Equal(DefaultMetronomeBPM, getMetronomeBPM(config))
+	config.MetronomeBPM = 93
+	assert.Equal(93, getMetronomeBPM(config))
+}
+
+func TestIsMetronomePort(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(isMetronomePort("metronome:120_bpm"))
+	assert.False(isMetronomePort("metronome_2:120_bpm"))
+	assert.False(isMetronomePort("hubserver:send_1"))
+}
@@ -0,0 +1,77 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"sync"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+// MixDeployer tests new mix code on a shadow server before it replaces the running mixer,
+// so that a bad push leaves the previous mix running instead of causing dead air
+type MixDeployer struct {
+	// Test runs mix code against a shadow server sandboxed with the given limits, returning an error if it fails to boot
+	Test func(code string, sampleRate int, limits common.SandboxLimits) error
+
+	active  string
+	lastErr string
+	mutex   sync.Mutex
+}
+
+// NewMixDeployer returns a deployer that tests mix code with a shadow sclang instance
+func NewMixDeployer() *MixDeployer {
+	return &MixDeployer{Test: common.ShadowTestSCLang}
+}
+
+// Deploy returns the mix code that should run for a config: its MixCode if it passed a shadow test,
+// or the previously deployed code along with the error if it failed
+func (d *MixDeployer) Deploy(config client.ServerAgentConfig) (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if client.GetMixEngine(config) != client.SuperColliderEngine || config.MixCode == d.active {
+		return d.active, nil
+	}
+
+	if err := d.Test(config.MixCode, config.SampleRate, getMixSandboxLimits(config)); err != nil {
+		log.Error(err, "New mix code failed on shadow server, keeping previous mix")
+		d.lastErr = err.Error()
+		return d.active, err
+	}
+
+	log.Info("New mix code passed shadow test")
+	d.active = config.MixCode
+	d.lastErr = ""
+	return d.active, nil
+}
+
+// Active returns the mix code that is currently deployed
+func (d *MixDeployer) Active() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.active
+}
+
+// Error returns the error from the last failed deploy, for the MixCodeError of server heartbeats
+func (d *MixDeployer) Error() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.lastErr
+}
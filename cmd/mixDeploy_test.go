@@ -0,0 +1,80 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMixDeployer(t *testing.T) {
+	assert := assert.New(t)
+	var tested []string
+	var testLimits common.SandboxLimits
+	deployer := NewMixDeployer()
+	deployer.Test = func(code string, sampleRate int, limits common.SandboxLimits) error {
+		tested = append(tested, code)
+		testLimits = limits
+		if code == "bad" {
+			return errors.New("ERROR: Message 'foo' not understood.")
+		}
+		return nil
+	}
+	config := client.ServerAgentConfig{}
+	config.SampleRate = 48000
+	config.MixCPUQuota = 150
+	config.MixMemoryMax = 512
+
+	// Case for good mix code, which is tested with the limits of the mix services
+	config.MixCode = "good"
+	code, err := deployer.Deploy(config)
+	assert.NoError(err)
+	assert.Equal("good", code)
+	assert.Equal(getMixSandboxLimits(config), testLimits)
+
+	// Case for unchanged mix code, which is not tested again
+	_, err = deployer.Deploy(config)
+	assert.NoError(err)
+	assert.Equal([]string{"good"}, tested)
+
+	// Case for bad mix code, which keeps the previous mix running
+	config.MixCode = "bad"
+	code, err = deployer.Deploy(config)
+	assert.Error(err)
+	assert.Equal("good", code)
+	assert.Equal("good", deployer.Active())
+	assert.Equal("ERROR: Message 'foo' not understood.", deployer.Error())
+
+	// Case for Faust mixes, which don't use mix code
+	config.MixEngine = client.FaustEngine
+	code, err = deployer.Deploy(config)
+	assert.NoError(err)
+	assert.Equal("good", code)
+
+	// Case for fixed mix code
+	config.MixEngine = client.SuperColliderEngine
+	config.MixCode = "fixed"
+	code, err = deployer.Deploy(config)
+	assert.NoError(err)
+	assert.Equal("fixed", code)
+	assert.Equal("", deployer.Error())
+}
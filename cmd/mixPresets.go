@@ -0,0 +1,254 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// PresetCommand switches the mix to a stored preset, or back to the server config if no preset is named
+	PresetCommand = "preset"
+
+	// MixPresetOSCAddress is used to send the sclang code of a preset to the studio's SuperCollider mixer
+	MixPresetOSCAddress = "/mix/preset"
+)
+
+var (
+	errMixPresetNotFound    = errors.New("mix preset not found")
+	errInvalidMixPresetName = errors.New("invalid mix preset name")
+)
+
+// MixPresetStore keeps track of named SCConfig presets and which one is active
+type MixPresetStore struct {
+	presets map[string]string
+	active  string
+	mutex   sync.Mutex
+}
+
+// serverMixPresets manages the mix presets stored on this server
+var serverMixPresets = &MixPresetStore{}
+
+// Load reads presets from disk, if any were saved
+func (s *MixPresetStore) Load() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	rawBytes, err := ioutil.ReadFile(PathToMixPresets)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var list client.MixPresetList
+	if err := json.Unmarshal(rawBytes, &list); err != nil {
+		return err
+	}
+	s.presets = map[string]string{}
+	for _, preset := range list.Presets {
+		s.presets[preset.Name] = preset.SCConfig
+	}
+	s.active = list.Active
+	return nil
+}
+
+// list returns the stored presets sorted by name; callers must hold the lock
+func (s *MixPresetStore) list() client.MixPresetList {
+	list := client.MixPresetList{Presets: []client.MixPreset{}, Active: s.active}
+	for name, scConfig := range s.presets {
+		list.Presets = append(list.Presets, client.MixPreset{Name: name, SCConfig: scConfig})
+	}
+	sort.Slice(list.Presets, func(i, j int) bool { return list.Presets[i].Name < list.Presets[j].Name })
+	return list
+}
+
+// save writes presets to disk; callers must hold the lock
+func (s *MixPresetStore) save() error {
+	rawBytes, err := json.Marshal(s.list())
+	if err != nil {
+		return err
+	}
+	if common.IsFileUnchanged(PathToMixPresets, rawBytes) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(PathToMixPresets), 0755); err != nil {
+		return err
+	}
+	tmpPath := PathToMixPresets + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, rawBytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, PathToMixPresets)
+}
+
+// List returns the stored presets and the name of the active preset
+func (s *MixPresetStore) List() client.MixPresetList {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.list()
+}
+
+// Save adds or replaces a preset after validating its SCConfig
+func (s *MixPresetStore) Save(preset client.MixPreset) error {
+	if !client.IsValidMixPresetName(preset.Name) {
+		return errInvalidMixPresetName
+	}
+	if _, err := client.ParseSCConfig(preset.SCConfig); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.presets == nil {
+		s.presets = map[string]string{}
+	}
+	s.presets[preset.Name] = preset.SCConfig
+	return s.save()
+}
+
+// Delete removes a preset; if it was active, the mix returns to the SCConfig of the server config
+func (s *MixPresetStore) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.presets[name]; !ok {
+		return errMixPresetNotFound
+	}
+	delete(s.presets, name)
+	if s.active == name {
+		s.active = ""
+	}
+	return s.save()
+}
+
+// Activate makes a preset active, or deactivates presets if name is empty
+func (s *MixPresetStore) Activate(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.presets[name]; name != "" && !ok {
+		return errMixPresetNotFound
+	}
+	s.active = name
+	return s.save()
+}
+
+// GetSCConfig returns the SCConfig of the active preset, or the SCConfig of the server config if none is active
+func (s *MixPresetStore) GetSCConfig(config client.ServerAgentConfig) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if scConfig, ok := s.presets[s.active]; ok && s.active != "" {
+		return scConfig
+	}
+	return config.SCConfig
+}
+
+// sendMixPreset sends the sclang code for the active SCConfig to the local SuperCollider mixer
+func sendMixPreset(store *MixPresetStore, config client.ServerAgentConfig) error {
+	config.SCConfig = store.GetSCConfig(config)
+	code, err := client.GetSCConfigSCLang(config)
+	if err != nil {
+		return err
+	}
+	osc := common.NewOSCClient("127.0.0.1", common.SuperColliderOSCPort)
+	return osc.Send(MixPresetOSCAddress, store.List().Active, code)
+}
+
+// switchMixPreset activates a preset and applies it to the running mix
+func switchMixPreset(store *MixPresetStore, config client.ServerAgentConfig, name string) error {
+	if err := store.Activate(name); err != nil {
+		return err
+	}
+	if err := sendMixPreset(store, config); err != nil {
+		return err
+	}
+	log.Info("Switched mix preset", "preset", name)
+	return nil
+}
+
+// runMixPresetCommand switches presets in response to a command from the control plane
+func runMixPresetCommand(store *MixPresetStore, config client.ServerAgentConfig, name string) client.MixPresetList {
+	err := switchMixPreset(store, config, name)
+	list := store.List()
+	if err != nil {
+		log.Error(err, "Unable to switch mix preset", "preset", name)
+		list.Error = err.Error()
+	}
+	return list
+}
+
+// handleMixPresetsRequest lists the mix presets stored on the server
+func handleMixPresetsRequest(store *MixPresetStore, credentials client.AgentCredentials, w http.ResponseWriter, r *http.Request) {
+	if !isAuthorizedAdminRequest(credentials, r) {
+		RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+	RespondJSON(w, http.StatusOK, store.List())
+}
+
+// handleMixPresetRequest saves (PUT), deletes (DELETE) or activates (POST) a mix preset
+func handleMixPresetRequest(store *MixPresetStore, config client.ServerAgentConfig, credentials client.AgentCredentials, w http.ResponseWriter, r *http.Request) {
+	if !isAuthorizedAdminRequest(credentials, r) {
+		RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	var err error
+	switch r.Method {
+	case http.MethodPut:
+		preset := client.MixPreset{Name: name}
+		if err := json.NewDecoder(r.Body).Decode(&preset); err != nil {
+			RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid mix preset"})
+			return
+		}
+		preset.Name = name
+		if err = store.Save(preset); err != nil {
+			RespondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		// apply changes to the active preset right away
+		if store.List().Active == name {
+			err = sendMixPreset(store, config)
+		}
+	case http.MethodDelete:
+		err = store.Delete(name)
+	case http.MethodPost:
+		err = switchMixPreset(store, config, name)
+	default:
+		RespondJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	if errors.Is(err, errMixPresetNotFound) {
+		RespondJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	} else if err != nil {
+		log.Error(err, "Failed to update mix preset", "preset", name, "method", r.Method)
+		RespondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	RespondJSON(w, http.StatusOK, store.List())
+}
@@ -0,0 +1,129 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMixPresetStore(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "presets")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	defer func(libDir string) {
+		AgentLibDir = libDir
+		updatePaths()
+	}(AgentLibDir)
+	AgentLibDir = dir
+	updatePaths()
+
+	store := &MixPresetStore{}
+	config := client.ServerAgentConfig{}
+	config.SCConfig = `{"links": [{"class": "Mixer"}]}`
+	concert := client.MixPreset{Name: "concert", SCConfig: `{"links": [{"class": "Limiter"}]}`}
+
+	// Case for invalid presets
+	assert.Equal(errInvalidMixPresetName, store.Save(client.MixPreset{Name: "Concert Hall"}))
+	assert.Error(store.Save(client.MixPreset{Name: "concert", SCConfig: `{"links": [{"class": "limiter"}]}`}))
+	assert.Equal(errMixPresetNotFound, store.Activate("concert"))
+
+	// Case for saving and activating presets
+	assert.NoError(store.Save(concert))
+	assert.NoError(store.Save(client.MixPreset{Name: "rehearsal", SCConfig: "{}"}))
+	assert.Equal(config.SCConfig, store.GetSCConfig(config))
+	assert.NoError(store.Activate("concert"))
+	assert.Equal(concert.SCConfig, store.GetSCConfig(config))
+
+	// Case for loading presets from disk
+	loaded := &MixPresetStore{}
+	assert.NoError(loaded.Load())
+	list := loaded.List()
+	assert.Equal("concert", list.Active)
+	assert.Equal([]client.MixPreset{concert, {Name: "rehearsal", SCConfig: "{}"}}, list.Presets)
+
+	// Case for deleting the active preset
+	assert.NoError(loaded.Delete("concert"))
+	assert.Equal(errMixPresetNotFound, loaded.Delete("concert"))
+	assert.Equal("", loaded.List().Active)
+	assert.Equal(config.SCConfig, loaded.GetSCConfig(config))
+	assert.NoError(loaded.Activate(""))
+}
+
+func TestHandleMixPresetRequest(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "presets")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	defer func(libDir string) {
+		AgentLibDir = libDir
+		updatePaths()
+	}(AgentLibDir)
+	AgentLibDir = dir
+	updatePaths()
+
+	store := &MixPresetStore{}
+	config := client.ServerAgentConfig{}
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+
+	newRequest := func(method, name, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://example.com/mix/presets/"+name, strings.NewReader(body))
+		req.Header.Set("APIPrefix", "prefix")
+		req.Header.Set("APISecret", "secret")
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+		resp := httptest.NewRecorder()
+		handleMixPresetRequest(store, config, credentials, resp, req)
+		return resp
+	}
+
+	// Case for unauthorized request
+	resp := httptest.NewRecorder()
+	handleMixPresetsRequest(store, credentials, resp, httptest.NewRequest("GET", "http://example.com/mix/presets", nil))
+	assert.Equal(401, resp.Code)
+
+	// Case for invalid presets
+	assert.Equal(400, newRequest("PUT", "concert", "not json").Code)
+	assert.Equal(400, newRequest("PUT", "concert", `{"scConfig": "{\"schemaVersion\": 9}"}`).Code)
+	assert.Equal(405, newRequest("PATCH", "concert", "").Code)
+
+	// Case for saving and listing presets
+	assert.Equal(200, newRequest("PUT", "concert", `{"scConfig": "{}"}`).Code)
+	req := httptest.NewRequest("GET", "http://example.com/mix/presets", nil)
+	req.Header.Set("APIPrefix", "prefix")
+	req.Header.Set("APISecret", "secret")
+	resp = httptest.NewRecorder()
+	handleMixPresetsRequest(store, credentials, resp, req)
+	assert.Equal(200, resp.Code)
+	var list client.MixPresetList
+	assert.NoError(json.Unmarshal(resp.Body.Bytes(), &list))
+	assert.Equal([]client.MixPreset{{Name: "concert", SCConfig: "{}"}}, list.Presets)
+
+	// Case for missing presets
+	assert.Equal(404, newRequest("POST", "broadcast", "").Code)
+	assert.Equal(200, newRequest("DELETE", "concert", "").Code)
+	assert.Equal(404, newRequest("DELETE", "concert", "").Code)
+}
@@ -1,14 +1,13 @@
+//go:build !server
+// +build !server
+
 package main
 
 import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
-	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -25,10 +24,6 @@ const (
 	ZitaCapture ZitaMode = "a2j"
 	// ZitaPlayback is the zita-j2a service mode
 	ZitaPlayback ZitaMode = "j2a"
-	// PathToAlsaState is the location of the ALSA state file for a particular device
-	PathToAlsaState = "/tmp/default/asound-%s.state"
-	// PathToZitaConfig is a systemd conf file path for zita
-	PathToZitaConfig = "/tmp/default/zita-%s-conf"
 	// ZitaConfigTemplate is a set of parameters for zita systemd
 	ZitaConfigTemplate = "ZITA_OPTS=-d hw:%s -c %d -p %d -r %d -j %s\n"
 	// ZitaServiceNameTemplate uses a wildcard systemd conf file
@@ -43,17 +38,38 @@ type DeviceMixingManager struct {
 	CurrentPlaybackDevices map[string]bool
 	DeviceCardMapping      map[string]int
 	DeviceStream0Mapping   map[string][]string
+	AutoConnector          *AutoConnector
+	suspended              bool
+	supervisor             ZitaSupervisor
 	mutex                  sync.Mutex
 }
 
 // Run a continuous loop performing device synchronization
 func (dmm *DeviceMixingManager) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
+	events := deviceState.Subscribe()
+	defer deviceState.Unsubscribe(events)
+
+	// watch for zita bridges that crash
+	updates := make(chan ServiceStateUpdate, 100)
+	go func() {
+		if err := serviceManager.Watch(ctx, updates); err != nil {
+			log.Error(err, "Unable to watch zita services")
+		}
+	}()
 
 	for {
 		select {
+		case event := <-events:
+			// synchronize right away when a new config is applied
+			if event.Type == ConfigChanged {
+				dmm.SynchronizeConnections(event.Config)
+			}
+		case update := <-updates:
+			dmm.handleServiceUpdate(update, time.Now())
 		case <-time.After(DetectDevicesInterval):
-			dmm.SynchronizeConnections(currentDeviceConfig)
+			dmm.restartCrashedBridges(time.Now())
+			dmm.SynchronizeConnections(deviceState.Config())
 		case <-ctx.Done():
 			dmm.Reset()
 			log.Info("Stopping device mixer")
@@ -66,6 +82,10 @@ func (dmm *DeviceMixingManager) Run(ctx context.Context, wg *sync.WaitGroup) {
 func (dmm *DeviceMixingManager) Reset() {
 	dmm.mutex.Lock()
 	defer dmm.mutex.Unlock()
+	dmm.supervisor.Reset()
+
+	// restore each device's ALSA state once, even if it was used for both capture and playback
+	devices := map[string]bool{}
 
 	if len(dmm.CurrentCaptureDevices) > 0 {
 		for device := range dmm.CurrentCaptureDevices {
@@ -75,9 +95,7 @@ func (dmm *DeviceMixingManager) Reset() {
 			// Remove zita config
 			connectionName := fmt.Sprintf("%s-%s", ZitaCapture, device)
 			os.Remove(fmt.Sprintf(PathToZitaConfig, connectionName))
-			// Restore and cleanup ALSA state
-			restoreAlsaState(device)
-			os.Remove(fmt.Sprintf(PathToAlsaState, device))
+			devices[device] = true
 		}
 		dmm.CurrentCaptureDevices = map[string]bool{}
 	}
@@ -90,13 +108,17 @@ func (dmm *DeviceMixingManager) Reset() {
 			// Remove zita config
 			connectionName := fmt.Sprintf("%s-%s", ZitaPlayback, device)
 			os.Remove(fmt.Sprintf(PathToZitaConfig, connectionName))
-			// Restore and cleanup ALSA state
-			restoreAlsaState(device)
-			os.Remove(fmt.Sprintf(PathToAlsaState, device))
+			devices[device] = true
 		}
 		dmm.CurrentPlaybackDevices = map[string]bool{}
 	}
 
+	// Restore and cleanup ALSA state
+	for device := range devices {
+		restoreAlsaState(device)
+		os.Remove(fmt.Sprintf(PathToAlsaState, device))
+	}
+
 	// reinitialize device lists
 	if len(dmm.DeviceStream0Mapping) > 0 {
 		dmm.DeviceStream0Mapping = map[string][]string{}
@@ -106,18 +128,91 @@ func (dmm *DeviceMixingManager) Reset() {
 	}
 }
 
+// Suspend stops all zita bridges and prevents new ones from starting, ie. while JACK is restarting
+func (dmm *DeviceMixingManager) Suspend() {
+	dmm.mutex.Lock()
+	dmm.suspended = true
+	dmm.mutex.Unlock()
+	dmm.Reset()
+	deviceState.SetStatus(MixerSubsystem, "suspended")
+}
+
+// Resume allows zita bridges to be started again
+func (dmm *DeviceMixingManager) Resume() {
+	dmm.mutex.Lock()
+	defer dmm.mutex.Unlock()
+	dmm.suspended = false
+	deviceState.SetStatus(MixerSubsystem, "running")
+}
+
+// handleServiceUpdate records crashes of zita bridges that are expected to be running
+func (dmm *DeviceMixingManager) handleServiceUpdate(update ServiceStateUpdate, now time.Time) {
+	match := zitaServiceName.FindStringSubmatch(update.Name)
+	if match == nil || update.SubState != ServiceFailed {
+		return
+	}
+	dmm.mutex.Lock()
+	currentDevices := dmm.CurrentPlaybackDevices
+	if ZitaMode(match[1]) == ZitaCapture {
+		currentDevices = dmm.CurrentCaptureDevices
+	}
+	running := currentDevices[match[2]]
+	dmm.mutex.Unlock()
+	if !running {
+		return
+	}
+
+	if dmm.supervisor.Crashed(update.Name, now) {
+		log.Info("Zita bridge keeps crashing, marking device as failed", "name", update.Name, "device", match[2])
+		return
+	}
+	log.Info("Zita bridge crashed, restarting with backoff", "name", update.Name)
+}
+
+// restartCrashedBridges restarts zita bridges whose backoff has elapsed
+func (dmm *DeviceMixingManager) restartCrashedBridges(now time.Time) {
+	if dmm.isSuspended() {
+		return
+	}
+	for _, name := range dmm.supervisor.Due(now) {
+		log.Info("Restarting crashed zita bridge", "name", name)
+		StartZitaService(name)
+	}
+}
+
+// FailedDevices returns the names of devices whose zita bridges crashed too many times
+func (dmm *DeviceMixingManager) FailedDevices() []string {
+	return dmm.supervisor.FailedDevices()
+}
+
+// isSuspended returns true if zita bridges should not be started
+func (dmm *DeviceMixingManager) isSuspended() bool {
+	dmm.mutex.Lock()
+	defer dmm.mutex.Unlock()
+	return dmm.suspended
+}
+
 // SynchronizeConnections synchronizes all Zita <-> Jack port connections
 func (dmm *DeviceMixingManager) SynchronizeConnections(config client.DeviceAgentConfig) {
+	if dmm.isSuspended() {
+		return
+	}
+
 	// Reset should be called under the following conditions:
 	// - multi-USB mode is disabled and the detected soundcard is not dummy (indicative of analog bridge)
 	// - or device is not connected to server
-	if (!config.EnableUSB && soundDeviceName != "dummy") || !config.Enabled || config.Host == "" {
+	// - or device audio is bridged from an AES67 network instead of USB audio interfaces
+	if (!config.EnableUSB && deviceState.SoundDevice().Name != "dummy") || !config.Enabled || config.Host == "" {
+		dmm.Reset()
+		return
+	}
+	if isAES67Enabled(config) {
 		dmm.Reset()
 		return
 	}
 
 	// Wait for autoconnector to be available before proceeding
-	if ac == nil || ac.JackClient == nil {
+	if dmm.AutoConnector == nil || dmm.AutoConnector.JackClient == nil {
 		return
 	}
 	dmm.mutex.Lock()
@@ -134,23 +229,36 @@ func (dmm *DeviceMixingManager) SynchronizeConnections(config client.DeviceAgent
 	// 3. Remove stale capture devices
 	removeInactiveDevices(dmm.CurrentCaptureDevices, activeCaptureDevices, ZitaCapture)
 
-	// 4. Synchronize new capture devices
-	dmm.addActiveDevices(config, newCaptureDevices, ZitaCapture)
-
-	// 5. Fetch all active playback devices and get diff between active and current
+	// 4. Fetch all active playback devices and get diff between active and current
 	activePlaybackDevices := getPlaybackDeviceNames()
 	newPlaybackDevices := findNewDevices(dmm.CurrentPlaybackDevices, activePlaybackDevices)
 
-	// 6. Remove stale playback devices
+	// 5. Remove stale playback devices
 	removeInactiveDevices(dmm.CurrentPlaybackDevices, activePlaybackDevices, ZitaPlayback)
 
-	// 7. Synchronize new playback devices
+	// 6. Write the current state of all new devices in a single batch, before zita changes any settings
+	dmm.storeAlsaStates(append(newCaptureDevices, newPlaybackDevices...))
+
+	// 7. Synchronize new capture devices
+	dmm.addActiveDevices(config, newCaptureDevices, ZitaCapture)
+
+	// 8. Synchronize new playback devices
 	dmm.addActiveDevices(config, newPlaybackDevices, ZitaPlayback)
 
-	// 8. Update ALSA settings
+	// 9. Update ALSA settings
 	if len(newCaptureDevices) > 0 || len(newPlaybackDevices) > 0 {
 		updateALSASettings(config)
 	}
+
+	// 10. Forget about crashes of bridges for devices that were removed
+	services := map[string]bool{}
+	for device := range dmm.CurrentCaptureDevices {
+		services[fmt.Sprintf(ZitaServiceNameTemplate, ZitaCapture, device)] = true
+	}
+	for device := range dmm.CurrentPlaybackDevices {
+		services[fmt.Sprintf(ZitaServiceNameTemplate, ZitaPlayback, device)] = true
+	}
+	dmm.supervisor.Retain(services)
 }
 
 func (dmm *DeviceMixingManager) connectZita(mode ZitaMode, device string, config client.DeviceAgentConfig) error {
@@ -208,9 +316,6 @@ func (dmm *DeviceMixingManager) addActiveDevices(config client.DeviceAgentConfig
 			dmm.DeviceStream0Mapping[device] = readCardStream0(cardNum)
 		}
 
-		// write the current state of the device to a file
-		storeAlsaState(device)
-
 		// establish zita <-> JACK connections
 		if err := dmm.connectZita(mode, device, config); err == nil {
 			currentDevices[device] = true
@@ -251,18 +356,29 @@ func writeZitaConfig(numChannel int, period int, rate int, mode ZitaMode, device
 }
 
 func writeConfig(path string, content string) error {
-	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+	if _, err := common.WriteFileIfChanged(path, []byte(content), 0644); err != nil {
 		log.Error(err, "Error while writing config")
 		return err
 	}
 	return nil
 }
 
+// storeAlsaStates saves the state of each new device with a sound card once, skipping duplicates
+func (dmm *DeviceMixingManager) storeAlsaStates(devices []string) {
+	stored := map[string]bool{}
+	for _, device := range devices {
+		if _, ok := dmm.DeviceCardMapping[device]; !ok || stored[device] {
+			continue
+		}
+		stored[device] = true
+		storeAlsaState(device)
+	}
+}
+
 func storeAlsaState(device string) error {
 	stateFile := fmt.Sprintf(PathToAlsaState, device)
 	if _, err := os.Stat(stateFile); errors.Is(err, os.ErrNotExist) {
-		_, err := exec.Command("alsactl", "store", "--file", stateFile, device).Output()
-		if err != nil {
+		if err := alsaProvider.StoreState(device, stateFile); err != nil {
 			log.Error(err, "Unable to store device state")
 			return err
 		}
@@ -273,8 +389,7 @@ func storeAlsaState(device string) error {
 func restoreAlsaState(device string) error {
 	stateFile := fmt.Sprintf(PathToAlsaState, device)
 	if _, err := os.Stat(stateFile); err == nil {
-		_, err := exec.Command("alsactl", "restore", "--file", stateFile, device).Output()
-		if err != nil {
+		if err := alsaProvider.RestoreState(device, stateFile); err != nil {
 			log.Error(err, "Unable to restore device state")
 			return err
 		}
@@ -283,39 +398,39 @@ func restoreAlsaState(device string) error {
 }
 
 func getCaptureDeviceNames() map[string]bool {
-	out, err := exec.Command("arecord", "-l").Output()
+	out, err := alsaProvider.CaptureDevices()
 	if err != nil {
 		log.Error(err, "Unable to retrieve capture device names")
 		return nil
 	}
-	return extractNames(string(out))
+	return extractNames(out)
 }
 
 func getPlaybackDeviceNames() map[string]bool {
-	out, err := exec.Command("aplay", "-l").Output()
+	out, err := alsaProvider.PlaybackDevices()
 	if err != nil {
 		log.Error(err, "Unable to retrieve playback device names")
 		return nil
 	}
-	return extractNames(string(out))
+	return extractNames(out)
 }
 
 func getDeviceToNumMappings() map[string]int {
-	out, err := exec.Command("cat", "/proc/asound/cards").Output()
+	out, err := alsaProvider.Cards()
 	if err != nil {
 		log.Error(err, "Unable to retrieve playback device names")
 		return nil
 	}
-	return extractCardNum(string(out))
+	return extractCardNum(out)
 }
 
 func readCardStream0(cardNum int) []string {
-	out, err := exec.Command("cat", fmt.Sprintf("/proc/asound/card%d/stream0", cardNum)).Output()
+	out, err := alsaProvider.Stream0(cardNum)
 	if err != nil {
 		log.Error(err, fmt.Sprintf("Unable to retrieve card information for card %d", cardNum))
 		return nil
 	}
-	return strings.Split(string(out), "\n")
+	return strings.Split(out, "\n")
 }
 
 // findBestSampleRateAndChannel returns the best sample rate & channel count based on a desired target
@@ -335,101 +450,3 @@ func findBestSampleRateAndChannel(rateToChannelsMap map[int]int, desiredSampleRa
 	}
 	return 0, -1
 }
-
-// getSampleRateToChannelMap returns a map of sample-rates-to-channel-counts for an ALSA card from `/proc/asound/card%d/stream0`
-func getSampleRateToChannelMap(sentences []string, mode ZitaMode) map[int]int {
-	output := map[int]int{}
-	// When scanning through the output, we begin parsing each line beginning at `startSequence` and ending at `stopSequence`
-	startSequence := "Playback:"
-	stopSequence := "Capture:"
-	if mode == ZitaCapture {
-		startSequence = "Capture:"
-		stopSequence = "Playback:"
-	}
-	for i, sentence := range sentences {
-		// when we find the `startSequence` section, scan each subsequent line for any meaningful bits of data
-		if strings.Contains(sentence, startSequence) {
-			for j := i + 1; j < len(sentences); j++ {
-				currSentence := sentences[j]
-				// break when we see the `startSequence` because that indicates the end of the section
-				if strings.Contains(currSentence, stopSequence) {
-					return output
-				}
-				// if we found our target sampleRate, look for the number of channels
-				if strings.Contains(currSentence, "Rates:") {
-					sampleRates := parseSampleRates(currSentence)
-					// parse the interface's channels
-					r := regexp.MustCompile(`Channels: (\d)`)
-					for ii := j - 1; ii >= common.Max(0, j-5); ii-- {
-						currSentence := sentences[ii]
-						subMatch := r.FindStringSubmatch(currSentence)
-						if len(subMatch) > 1 {
-							n, err := strconv.Atoi(subMatch[1])
-							if err != nil {
-								continue
-							}
-							for _, rate := range sampleRates {
-								currChannels, ok := output[rate]
-								if !ok {
-									output[rate] = n
-								} else {
-									output[rate] = common.Max(currChannels, n)
-								}
-							}
-						}
-					}
-				}
-
-			}
-		}
-	}
-	return output
-}
-
-func extractNames(target string) map[string]bool {
-	names := map[string]bool{}
-	sentences := strings.Split(target, "\n")
-	r := regexp.MustCompile(`^card \d+: (\w+) \[`)
-	for _, sentence := range sentences {
-		subMatch := r.FindStringSubmatch(sentence)
-		if len(subMatch) > 1 && subMatch[1] != "sndrpihifiberry" { // exclude hifiberry since we won't use it
-			names[subMatch[1]] = true
-		}
-	}
-	return names
-}
-
-func extractCardNum(target string) map[string]int {
-	nameToNum := map[string]int{}
-	sentences := strings.Split(target, "\n")
-	r := regexp.MustCompile(`^ (\d+) \[(\w+)\s*\]`)
-	for _, sentence := range sentences {
-		result := r.FindAllStringSubmatch(sentence, -1)
-		if len(result) == 1 {
-			num, err := strconv.Atoi(result[0][1])
-			if err == nil {
-				nameToNum[result[0][2]] = num
-			}
-		}
-	}
-	return nameToNum
-}
-
-// parseSampleRates parses the sample rate(s) line of an ALSA card from `/proc/asound/card%d/stream0`
-func parseSampleRates(line string) []int {
-	sampleRates := []int{}
-	r := regexp.MustCompile(`Rates: (.*)`)
-	match := r.FindStringSubmatch(line)
-	if len(match) <= 1 {
-		return sampleRates
-	}
-	rates := strings.Split(match[1], ", ")
-	for _, rate := range rates {
-		currSampleRate, err := strconv.Atoi(rate)
-		if err != nil {
-			continue
-		}
-		sampleRates = append(sampleRates, currSampleRate)
-	}
-	return sampleRates
-}
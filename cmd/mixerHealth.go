@@ -0,0 +1,134 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// SCSynthOSCPort is the UDP port that the SuperCollider audio server listens on
+	SCSynthOSCPort = 57110
+
+	// MixerHealthInterval is the time between status requests sent to the mixer
+	MixerHealthInterval = 5 * time.Second
+
+	// MixerPingTimeout is the maximum time to wait for the mixer to reply to a status request
+	MixerPingTimeout = 2 * time.Second
+
+	// MixerMaxFailures is the number of status requests in a row that may fail before the mixer is restarted
+	MixerMaxFailures = 3
+
+	// MaxMixErrors is the number of recent mixer errors reported in server heartbeats
+	MaxMixErrors = 10
+)
+
+// MixerHealth periodically sends status requests to the SuperCollider audio server, and restarts the
+// mixer when it stops responding
+type MixerHealth struct {
+	// Address of the audio server, formatted as host:port
+	Address string
+
+	// Ping sends a status request to the audio server
+	Ping func(address string, timeout time.Duration) error
+
+	// Active returns true if the mixer is expected to be running
+	Active func() bool
+
+	// Restart restarts the mixer services
+	Restart func() error
+
+	status   client.MixerStatus
+	failures int
+	restarts int
+	mutex    sync.Mutex
+}
+
+// NewMixerHealth constructs a new instance of MixerHealth
+func NewMixerHealth(active func() bool, restart func() error) *MixerHealth {
+	return &MixerHealth{
+		Address: fmt.Sprintf("127.0.0.1:%d", SCSynthOSCPort),
+		Ping:    common.PingSCSynth,
+		Active:  active,
+		Restart: restart,
+	}
+}
+
+// Check sends a status request to the mixer, restarting it after MixerMaxFailures failures in a row
+func (h *MixerHealth) Check() client.MixerStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if !h.Active() {
+		h.status, h.failures = "", 0
+		return h.status
+	}
+
+	err := h.Ping(h.Address, MixerPingTimeout)
+	if err == nil {
+		h.status, h.failures = client.MixerHealthy, 0
+		return h.status
+	}
+
+	h.failures++
+	if h.failures < MixerMaxFailures {
+		// keep reporting that the mixer is restarting until it comes back up
+		if h.status != client.MixerRestarting {
+			h.status = client.MixerUnresponsive
+		}
+		return h.status
+	}
+
+	log.Error(err, "Mixer is unresponsive, restarting", "failures", h.failures)
+	h.failures = 0
+	h.restarts++
+	h.status = client.MixerRestarting
+	if err := h.Restart(); err != nil {
+		log.Error(err, "Unable to restart mixer")
+	}
+	return h.status
+}
+
+// Status returns the health of the mixer, and the number of times it was restarted to recover
+func (h *MixerHealth) Status() (client.MixerStatus, int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.status, h.restarts
+}
+
+// Run checks the health of the mixer until the context is cancelled
+func (h *MixerHealth) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(MixerHealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping mixer health checks")
+			return
+		case <-ticker.C:
+			h.Check()
+		}
+	}
+}
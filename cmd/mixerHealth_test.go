@@ -0,0 +1,62 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMixerHealth(t *testing.T) {
+	assert := assert.New(t)
+	active, restarts := false, 0
+	var pingErr error
+	health := NewMixerHealth(func() bool { return active }, func() error {
+		restarts++
+		return nil
+	})
+	health.Ping = func(address string, timeout time.Duration) error { return pingErr }
+
+	// Case for a mixer that is not running yet
+	assert.Equal(client.MixerStatus(""), health.Check())
+
+	// Case for a healthy mixer
+	active = true
+	assert.Equal(client.MixerHealthy, health.Check())
+
+	// Case for an unresponsive mixer, which is restarted after MixerMaxFailures checks
+	pingErr = errors.New("timeout")
+	for i := 1; i < MixerMaxFailures; i++ {
+		assert.Equal(client.MixerUnresponsive, health.Check())
+	}
+	assert.Equal(0, restarts)
+	assert.Equal(client.MixerRestarting, health.Check())
+	assert.Equal(1, restarts)
+	assert.Equal(client.MixerRestarting, health.Check())
+
+	// Case for the mixer recovering
+	pingErr = nil
+	assert.Equal(client.MixerHealthy, health.Check())
+	status, count := health.Status()
+	assert.Equal(client.MixerHealthy, status)
+	assert.Equal(1, count)
+}
@@ -12,6 +12,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !server
+// +build !server
+
 package main
 
 import (
@@ -64,8 +67,10 @@ func TestFindNewDevices(t *testing.T) {
 }
 
 func TestRemoveInactiveDevices(t *testing.T) {
-	// NOTE: This test spews a bunch of dbus error logs that we're ignoring
 	assert := assert.New(t)
+	services := NewFakeServiceManager()
+	defer func(prev ServiceManager) { serviceManager = prev }(serviceManager)
+	serviceManager = services
 
 	// Case for no active devices
 	foundDevices := map[string]bool{"one": true}
@@ -0,0 +1,226 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// MQTTConnectTimeout is the maximum time to wait when connecting to an MQTT broker
+	MQTTConnectTimeout = 10 * time.Second
+
+	// MQTTKeepAlive is the keep alive interval requested from MQTT brokers; status is published more often than this
+	MQTTKeepAlive = 60 * time.Second
+
+	// Suffixes of the topics that device status is published to
+	mqttAvailabilityTopic = "availability"
+	mqttStatusTopic       = "status"
+	mqttMetricsTopic      = "metrics"
+	mqttAlertsTopic       = "alerts"
+)
+
+// MQTTStatus is published whenever the device state changes
+type MQTTStatus struct {
+	StateSnapshot
+	MAC              string                  `json:"mac"`
+	Version          string                  `json:"version"`
+	SampleRate       int                     `json:"sampleRate,omitempty"`
+	SampleRateStatus client.SampleRateStatus `json:"sampleRateStatus,omitempty"`
+	PingStats        client.PingStats        `json:"pingStats"`
+}
+
+// mqttPublisher publishes device status to the MQTT broker of a device config
+type mqttPublisher struct {
+	config     client.MQTTConfig
+	client     *common.MQTTClient
+	prefix     string
+	lastAlerts string
+}
+
+// getMQTTTopicPrefix returns the configured topic prefix, or a prefix derived from the device's MAC address
+func getMQTTTopicPrefix(config client.MQTTConfig, mac string) string {
+	prefix := strings.Trim(config.MQTTTopicPrefix, "/")
+	if prefix == "" {
+		return "jacktrip/" + strings.ToLower(strings.ReplaceAll(mac, ":", ""))
+	}
+	return prefix
+}
+
+// getDeviceAlerts returns problems that device operators should be notified about
+func getDeviceAlerts(snapshot StateSnapshot, beat client.DeviceHeartbeat) []string {
+	alerts := getReadinessFailures(snapshot)
+	if beat.ConfigError != "" {
+		alerts = append(alerts, beat.ConfigError)
+	}
+	if beat.PortConflict != "" {
+		alerts = append(alerts, beat.PortConflict)
+	}
+	if beat.ServicesError != "" {
+		alerts = append(alerts, beat.ServicesError)
+	}
+	if beat.Storage != nil && beat.Storage.Error != "" {
+		alerts = append(alerts, fmt.Sprintf("USB drive is not usable: %s", beat.Storage.Error))
+	}
+	if beat.LocalRecording != nil && beat.LocalRecording.Error != "" {
+		alerts = append(alerts, fmt.Sprintf("local recording is not running: %s", beat.LocalRecording.Error))
+	}
+	if beat.SampleRateStatus == client.SampleRateMismatch {
+		alerts = append(alerts, fmt.Sprintf("JACK is running at %d Hz instead of the configured sample rate", beat.SampleRate))
+	}
+	if beat.NetworkOutage {
+		alerts = append(alerts, "network connection to the audio server is unstable")
+	}
+	if beat.HighLatency {
+		alerts = append(alerts, "latency to the audio server is high")
+	}
+	return alerts
+}
+
+// connect opens a connection to the configured broker, announcing that the device is online
+func (p *mqttPublisher) connect(ctx context.Context, config client.MQTTConfig, mac string) error {
+	p.config = config
+	p.prefix = getMQTTTopicPrefix(config, mac)
+	p.lastAlerts = ""
+	ctx, cancel := context.WithTimeout(ctx, MQTTConnectTimeout)
+	defer cancel()
+	c, err := common.DialMQTT(ctx, common.MQTTOptions{
+		Broker:      config.MQTTBroker,
+		ClientID:    "jacktrip-agent-" + strings.ReplaceAll(mac, ":", ""),
+		Username:    config.MQTTUsername,
+		Password:    config.MQTTPassword,
+		TLS:         bool(config.MQTTTLS),
+		KeepAlive:   MQTTKeepAlive,
+		WillTopic:   p.topic(mqttAvailabilityTopic),
+		WillPayload: []byte("offline"),
+	})
+	if err != nil {
+		return err
+	}
+	p.client = c
+	return p.client.Publish(p.topic(mqttAvailabilityTopic), []byte("online"), true)
+}
+
+// close disconnects from the broker, announcing that the device is offline
+func (p *mqttPublisher) close() {
+	if p.client == nil {
+		return
+	}
+	p.client.Publish(p.topic(mqttAvailabilityTopic), []byte("offline"), true)
+	p.client.Close()
+	p.client = nil
+}
+
+// topic returns the full name of a status topic
+func (p *mqttPublisher) topic(suffix string) string {
+	return p.prefix + "/" + suffix
+}
+
+// publishJSON publishes a value encoded as JSON
+func (p *mqttPublisher) publishJSON(suffix string, value interface{}, retain bool) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return p.client.Publish(p.topic(suffix), payload, retain)
+}
+
+// publish sends the latest device status, metrics and alerts to the broker
+func (p *mqttPublisher) publish(beat client.DeviceHeartbeat) error {
+	snapshot := deviceState.Snapshot()
+	status := MQTTStatus{
+		StateSnapshot:    snapshot,
+		MAC:              beat.MAC,
+		Version:          beat.Version,
+		SampleRate:       beat.SampleRate,
+		SampleRateStatus: beat.SampleRateStatus,
+		PingStats:        beat.PingStats,
+	}
+	if err := p.publishJSON(mqttStatusTopic, status, true); err != nil {
+		return err
+	}
+	if beat.Metrics != nil {
+		if err := p.publishJSON(mqttMetricsTopic, beat.Metrics, false); err != nil {
+			return err
+		}
+	}
+	// alerts are retained, so they are only published when they change
+	alerts, err := json.Marshal(getDeviceAlerts(snapshot, beat))
+	if err != nil || string(alerts) == p.lastAlerts {
+		return err
+	}
+	if err := p.client.Publish(p.topic(mqttAlertsTopic), alerts, true); err != nil {
+		return err
+	}
+	p.lastAlerts = string(alerts)
+	return nil
+}
+
+// update connects to or disconnects from the configured broker as needed, then publishes device status
+func (p *mqttPublisher) update(ctx context.Context, beat client.DeviceHeartbeat) {
+	config := deviceState.Config().MQTTConfig
+	if p.client != nil && config != p.config {
+		p.close()
+	}
+	if config.MQTTBroker == "" {
+		return
+	}
+	if p.client == nil {
+		if err := p.connect(ctx, config, beat.MAC); err != nil {
+			log.Error(err, "Failed to connect to MQTT broker", "broker", config.MQTTBroker)
+			p.close()
+			return
+		}
+		log.Info("Connected to MQTT broker", "broker", config.MQTTBroker, "prefix", p.prefix)
+	}
+	if err := p.publish(beat); err != nil {
+		log.Error(err, "Failed to publish device status to MQTT broker", "broker", config.MQTTBroker)
+		p.close()
+	}
+}
+
+// deviceMQTTHandler publishes device status to an MQTT broker at every heartbeat, and whenever the device state changes
+func deviceMQTTHandler(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	log.Info("Starting deviceMQTTHandler")
+	events := deviceState.Subscribe()
+	defer deviceState.Unsubscribe(events)
+	ticker := time.NewTicker(HeartbeatInterval * time.Second)
+	defer ticker.Stop()
+	var p mqttPublisher
+	defer p.close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping deviceMQTTHandler")
+			return
+		case <-events:
+		case <-ticker.C:
+		}
+		p.update(ctx, deviceState.Heartbeat())
+	}
+}
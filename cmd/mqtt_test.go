@@ -0,0 +1,149 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// readMQTTPublish reads a PUBLISH packet, returning its topic and payload; other packets end the stream
+func readMQTTPublish(r *bufio.Reader) (string, string, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return "", "", err
+	}
+	if header&0xf0 != 0x30 {
+		return "", "", io.EOF
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", "", err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return "", "", err
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	return string(body[2 : 2+n]), string(body[2+n:]), nil
+}
+
+func TestGetMQTTTopicPrefix(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("jacktrip/001b44113ab7", getMQTTTopicPrefix(client.MQTTConfig{}, "00:1B:44:11:3A:B7"))
+	assert.Equal("home/studio", getMQTTTopicPrefix(client.MQTTConfig{MQTTTopicPrefix: "/home/studio/"}, "00:1B:44:11:3A:B7"))
+}
+
+func TestGetDeviceAlerts(t *testing.T) {
+	assert := assert.New(t)
+	beat := client.DeviceHeartbeat{}
+	assert.Equal([]string{}, getDeviceAlerts(StateSnapshot{Configured: true}, beat))
+
+	beat.ConfigError = "invalid config: bad"
+	beat.SampleRate = 44100
+	beat.SampleRateStatus = client.SampleRateMismatch
+	beat.NetworkOutage = true
+	beat.PortConflict = "udp port 4464 is in use"
+	beat.ServicesError = "timed out after 1m0s waiting for jackd"
+	alerts := getDeviceAlerts(StateSnapshot{}, beat)
+	assert.Equal(6, len(alerts))
+	assert.Equal("no config has been applied", alerts[0])
+	assert.Equal("invalid config: bad", alerts[1])
+	assert.Equal("udp port 4464 is in use", alerts[2])
+	assert.Equal("timed out after 1m0s waiting for jackd", alerts[3])
+	assert.Contains(alerts[4], "44100 Hz")
+
+	beat = client.DeviceHeartbeat{LocalRecording: &client.LocalRecordingStatus{Recording: true}}
+	assert.Equal([]string{}, getDeviceAlerts(StateSnapshot{Configured: true}, beat))
+	beat.LocalRecording.Error = "no USB storage is mounted"
+	assert.Equal([]string{"local recording is not running: no USB storage is mounted"}, getDeviceAlerts(StateSnapshot{Configured: true}, beat))
+	beat.Storage = &client.StorageStatus{Error: "filesystem is mounted read-only"}
+	alerts = getDeviceAlerts(StateSnapshot{Configured: true}, beat)
+	assert.Equal("USB drive is not usable: filesystem is mounted read-only", alerts[0])
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	assert := assert.New(t)
+	saved := deviceState
+	defer func() { deviceState = saved }()
+	deviceState = NewStateStore()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+	published := make(chan [2]string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		// skip the CONNECT packet
+		r.ReadByte()
+		length, _ := binary.ReadUvarint(r)
+		r.Discard(int(length))
+		conn.Write([]byte{0x20, 2, 0, 0})
+		for {
+			topic, payload, err := readMQTTPublish(r)
+			if err != nil {
+				close(published)
+				return
+			}
+			published <- [2]string{topic, payload}
+		}
+	}()
+
+	// Case for no broker configured
+	var p mqttPublisher
+	beat := client.DeviceHeartbeat{MAC: "00:1B:44:11:3A:B7"}
+	p.update(context.Background(), beat)
+	assert.Nil(p.client)
+
+	// Case for a configured broker
+	config := client.DeviceAgentConfig{}
+	config.MQTTBroker = listener.Addr().String()
+	config.MQTTTopicPrefix = "studio"
+	deviceState.SetConfig(config)
+	p.update(context.Background(), beat)
+	assert.NotNil(p.client)
+	assert.Equal([2]string{"studio/availability", "online"}, <-published)
+	status := <-published
+	assert.Equal("studio/status", status[0])
+	assert.Contains(status[1], `"mac":"00:1B:44:11:3A:B7"`)
+	assert.Equal([2]string{"studio/alerts", "[]"}, <-published)
+
+	// alerts are only published when they change
+	p.update(context.Background(), beat)
+	assert.Equal("studio/status", (<-published)[0])
+
+	// Case for broker removed from config
+	deviceState.SetConfig(client.DeviceAgentConfig{})
+	p.update(context.Background(), beat)
+	assert.Nil(p.client)
+	assert.Equal([2]string{"studio/availability", "offline"}, <-published)
+	_, ok := <-published
+	assert.False(ok)
+}
@@ -0,0 +1,160 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+// captureFileNameRegexp matches characters that are replaced in the names of capture files
+var captureFileNameRegexp = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// MultitrackCapture manages a jack_capture process for each client in the roster, as an
+// alternative to the in-process recorder that writes every client to its own file
+type MultitrackCapture struct {
+	// Dir is the directory capture files are written to
+	Dir string
+
+	format    client.RecordingFormat
+	processes map[string]captureProcess
+	start     func(args []string) (captureProcess, error)
+	mutex     sync.Mutex
+}
+
+// NewMultitrackCapture constructs a new instance of MultitrackCapture
+func NewMultitrackCapture(dir string, format client.RecordingFormat) *MultitrackCapture {
+	return &MultitrackCapture{
+		Dir:       dir,
+		format:    format,
+		processes: map[string]captureProcess{},
+		start:     startJackCapture,
+	}
+}
+
+// SetFormat changes the format of captures started after this call
+func (m *MultitrackCapture) SetFormat(format client.RecordingFormat) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.format = format
+}
+
+// getCaptureFormat returns the jack_capture file format for a recording format; FLAC is preferred
+// when both are recorded, since jack_capture only writes one file per client
+// NOTE: jack_capture writes plain WAV files, without the bext chunk of the recorder service's masters
+func getCaptureFormat(format client.RecordingFormat) string {
+	if format == client.RecordingWAV {
+		return "wav"
+	}
+	return "flac"
+}
+
+// getCaptureFileName returns the name of the file a client is captured to
+func getCaptureFileName(name string, format client.RecordingFormat, now time.Time) string {
+	return fmt.Sprintf("%s-%s.%s", now.UTC().Format("20060102T150405Z"),
+		captureFileNameRegexp.ReplaceAllString(name, "_"), getCaptureFormat(format))
+}
+
+// getJackCaptureArgs returns the jack_capture arguments used to capture all channels received from a client
+func getJackCaptureArgs(c common.RosterClient, path string, format client.RecordingFormat) []string {
+	channels := c.Channels
+	if channels < 1 {
+		channels = 1
+	}
+	args := []string{"--no-stdin", "--format", getCaptureFormat(format), "--channels", strconv.Itoa(channels)}
+	for i := 1; i <= channels; i++ {
+		args = append(args, "--port", fmt.Sprintf("%s:receive_%d", c.Name, i))
+	}
+	return append(args, path)
+}
+
+// Sync starts capturing clients that joined and stops capturing clients that left, returning the tracks
+// that started, with files relative to Dir
+func (m *MultitrackCapture) Sync(clients []common.RosterClient, now time.Time) []common.SessionTrack {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var started []common.SessionTrack
+	active := map[string]bool{}
+	for _, c := range clients {
+		active[c.Name] = true
+		if _, ok := m.processes[c.Name]; ok {
+			continue
+		}
+		file := getCaptureFileName(c.Name, m.format, now)
+		path := filepath.Join(m.Dir, file)
+		process, err := m.start(getJackCaptureArgs(c, path, m.format))
+		if err != nil {
+			log.Error(err, "Unable to start multitrack capture", "client", c.Name)
+			continue
+		}
+		log.Info("Started multitrack capture", "client", c.Name, "path", path)
+		m.processes[c.Name] = process
+		started = append(started, common.SessionTrack{Name: c.Name, File: file})
+	}
+
+	for name, process := range m.processes {
+		if active[name] {
+			continue
+		}
+		m.stop(name, process)
+	}
+	return started
+}
+
+// stop finishes capturing a client; callers must hold the lock
+func (m *MultitrackCapture) stop(name string, process captureProcess) {
+	if err := process.Stop(); err != nil {
+		log.Error(err, "Multitrack capture exited with an error", "client", name)
+	} else {
+		log.Info("Stopped multitrack capture", "client", name)
+	}
+	delete(m.processes, name)
+}
+
+// StopAll finishes capturing all clients, ie. when the session ends
+func (m *MultitrackCapture) StopAll() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for name, process := range m.processes {
+		m.stop(name, process)
+	}
+}
+
+// Run finishes all captures once the context is cancelled, so that capture files are complete when the agent exits
+func (m *MultitrackCapture) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	<-ctx.Done()
+	log.Info("Stopping multitrack capture", "clients", m.Capturing())
+	m.StopAll()
+}
+
+// Capturing returns the number of clients being captured
+func (m *MultitrackCapture) Capturing() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.processes)
+}
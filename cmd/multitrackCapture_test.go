@@ -0,0 +1,92 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetJackCaptureArgs(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+	assert.Equal("20220501T200000Z-__ffff_10.0.0.1.flac", getCaptureFileName("__ffff_10.0.0.1", client.RecordingFLACAndWAV, now))
+	assert.Equal("20220501T200000Z-Jane_s_Pi.wav", getCaptureFileName("Jane's Pi", client.RecordingWAV, now))
+
+	c := common.RosterClient{Name: "alice", Channels: 2}
+	assert.Equal([]string{"--no-stdin", "--format", "flac", "--channels", "2",
+		"--port", "alice:receive_1", "--port", "alice:receive_2", "/rec/alice.flac"},
+		getJackCaptureArgs(c, "/rec/alice.flac", client.RecordingFLAC))
+}
+
+func TestMultitrackCapture(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+	var started, stopped []string
+	capture := NewMultitrackCapture("/rec", client.RecordingWAV)
+	capture.start = func(args []string) (captureProcess, error) {
+		path := args[len(args)-1]
+		if strings.HasSuffix(path, "-broken.wav") {
+			return nil, errors.New("jack_capture failed")
+		}
+		started = append(started, path)
+		return fakeCaptureProcess{&stopped, path}, nil
+	}
+
+	// Case for clients joining
+	tracks := capture.Sync([]common.RosterClient{{Name: "alice", Channels: 1}, {Name: "bob", Channels: 2}, {Name: "broken"}}, now)
+	assert.Equal([]string{"/rec/20220501T200000Z-alice.wav", "/rec/20220501T200000Z-bob.wav"}, started)
+	assert.Equal([]common.SessionTrack{{Name: "alice", File: "20220501T200000Z-alice.wav"}, {Name: "bob", File: "20220501T200000Z-bob.wav"}}, tracks)
+	assert.Equal(2, capture.Capturing())
+
+	// Case for a client leaving, and a client rejoining
+	assert.Empty(capture.Sync([]common.RosterClient{{Name: "bob", Channels: 2}, {Name: "broken"}}, now.Add(time.Minute)))
+	assert.Equal([]string{"/rec/20220501T200000Z-alice.wav"}, stopped)
+	assert.Equal(1, capture.Capturing())
+	capture.Sync([]common.RosterClient{{Name: "alice", Channels: 1}, {Name: "bob", Channels: 2}}, now.Add(2*time.Minute))
+	assert.Equal("/rec/20220501T200200Z-alice.wav", started[2])
+
+	// Case for a new format, which only applies to clients that join afterwards
+	capture.SetFormat(client.RecordingFLAC)
+	capture.Sync([]common.RosterClient{{Name: "alice", Channels: 1}, {Name: "bob", Channels: 2}, {Name: "carol"}}, now.Add(2*time.Minute))
+	assert.Equal("/rec/20220501T200200Z-carol.flac", started[3])
+
+	// Case for the session ending
+	capture.StopAll()
+	assert.Equal(0, capture.Capturing())
+	assert.Len(stopped, 4)
+
+	// Case for the agent shutting down
+	capture.Sync([]common.RosterClient{{Name: "alice", Channels: 1}}, now.Add(3*time.Minute))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go capture.Run(ctx, &wg)
+	cancel()
+	wg.Wait()
+	assert.Equal(0, capture.Capturing())
+	assert.Len(stopped, 5)
+}
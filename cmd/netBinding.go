@@ -0,0 +1,98 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// interfaceAddrs returns the addresses of a network interface
+var interfaceAddrs = func(name string) ([]net.Addr, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return iface.Addrs()
+}
+
+// getInterfaceIP returns the first IPv4 address of a network interface, or else its first global IPv6 address
+func getInterfaceIP(name string) (net.IP, error) {
+	addrs, err := interfaceAddrs(name)
+	if err != nil {
+		return nil, err
+	}
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			return ip4, nil
+		}
+		if ipv6 == nil && ipNet.IP.IsGlobalUnicast() {
+			ipv6 = ipNet.IP
+		}
+	}
+	if ipv6 == nil {
+		return nil, fmt.Errorf("no addresses found on network interface %s", name)
+	}
+	return ipv6, nil
+}
+
+// getBindAddress returns the source address for a service, using a configured address or else the address of a
+// configured interface; it is empty if neither is configured
+func getBindAddress(iface, address string) (string, error) {
+	if address != "" || iface == "" {
+		return address, nil
+	}
+	ip, err := getInterfaceIP(iface)
+	if err != nil {
+		return "", err
+	}
+	return ip.String(), nil
+}
+
+// getBoundListenAddress restricts a listen address (ie. ":80") to a source address, if one is set
+func getBoundListenAddress(ip, address string) string {
+	if ip == "" {
+		return address
+	}
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return net.JoinHostPort(ip, port)
+}
+
+// bindControlSockets makes the agent's own HTTP and websocket connections use the address of a network interface,
+// returning the address so that servers can listen on it too
+func bindControlSockets(iface string) (string, error) {
+	ip, err := getInterfaceIP(iface)
+	if err != nil {
+		return "", err
+	}
+	dialer := &net.Dialer{LocalAddr: &net.TCPAddr{IP: ip}}
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport.DialContext = dialer.DialContext
+	}
+	websocket.DefaultDialer.NetDialContext = dialer.DialContext
+	log.Info("Bound control connections to network interface", "interface", iface, "address", ip.String())
+	return ip.String(), nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBindAddress(t *testing.T) {
+	assert := assert.New(t)
+	defer func(addrs func(string) ([]net.Addr, error)) { interfaceAddrs = addrs }(interfaceAddrs)
+	interfaceAddrs = func(name string) ([]net.Addr, error) {
+		switch name {
+		case "eth0.20":
+			return []net.Addr{
+				&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+				&net.IPNet{IP: net.ParseIP("10.0.20.5"), Mask: net.CIDRMask(24, 32)},
+			}, nil
+		case "eth1":
+			return []net.Addr{
+				&net.IPNet{IP: net.ParseIP("fe80::2"), Mask: net.CIDRMask(64, 128)},
+				&net.IPNet{IP: net.ParseIP("2001:db8::2"), Mask: net.CIDRMask(64, 128)},
+			}, nil
+		case "eth2":
+			return []net.Addr{&net.IPNet{IP: net.ParseIP("fe80::3"), Mask: net.CIDRMask(64, 128)}}, nil
+		}
+		return nil, errors.New("no such network interface")
+	}
+
+	address, err := getBindAddress("", "")
+	assert.NoError(err)
+	assert.Equal("", address)
+	address, err = getBindAddress("eth0.20", "")
+	assert.NoError(err)
+	assert.Equal("10.0.20.5", address)
+	address, err = getBindAddress("eth0.20", "10.0.20.9")
+	assert.NoError(err)
+	assert.Equal("10.0.20.9", address)
+	address, err = getBindAddress("eth1", "")
+	assert.NoError(err)
+	assert.Equal("2001:db8::2", address)
+	_, err = getBindAddress("eth2", "")
+	assert.Error(err)
+	_, err = getBindAddress("eth9", "")
+	assert.Error(err)
+}
+
+func TestGetBoundListenAddress(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(":80", getBoundListenAddress("", ":80"))
+	assert.Equal("10.0.10.5:80", getBoundListenAddress("10.0.10.5", ":80"))
+	assert.Equal("[2001:db8::2]:8443", getBoundListenAddress("2001:db8::2", ":8443"))
+}
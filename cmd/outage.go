@@ -0,0 +1,74 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// OutageGracePeriod is how long the control plane may be unreachable before the agent gives up and restarts,
+	// which also restarts audio services; shorter outages leave audio untouched
+	OutageGracePeriod = 2 * time.Minute
+
+	// OutageRetryInterval is the delay between attempts to reach the control plane during an outage
+	OutageRetryInterval = 5 * time.Second
+)
+
+// OutageMonitor tracks how long the control plane has been unreachable, so that brief network outages (ie. Wi-Fi
+// drops) only re-establish control plane connections
+type OutageMonitor struct {
+	// Grace is how long an outage may last before it is treated as persistent
+	Grace time.Duration
+
+	startedAt time.Time
+	mutex     sync.Mutex
+}
+
+// Failure records a failed attempt to reach the control plane, returning true once the outage outlasts the grace window
+func (m *OutageMonitor) Failure(now time.Time) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.startedAt.IsZero() {
+		m.startedAt = now
+	}
+	return now.Sub(m.startedAt) >= m.Grace
+}
+
+// Success records that the control plane was reached, returning the duration of the outage that ended, if any
+func (m *OutageMonitor) Success(now time.Time) time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.startedAt.IsZero() {
+		return 0
+	}
+	duration := now.Sub(m.startedAt)
+	m.startedAt = time.Time{}
+	return duration
+}
+
+// Duration returns how long the current outage has lasted, or 0 if the control plane is reachable
+func (m *OutageMonitor) Duration(now time.Time) time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.startedAt.IsZero() {
+		return 0
+	}
+	return now.Sub(m.startedAt)
+}
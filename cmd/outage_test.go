@@ -0,0 +1,59 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutageMonitor(t *testing.T) {
+	assert := assert.New(t)
+	m := &OutageMonitor{Grace: time.Minute}
+	now := time.Now()
+	assert.Equal(time.Duration(0), m.Success(now))
+	assert.Equal(time.Duration(0), m.Duration(now))
+
+	// Case for a brief outage
+	assert.False(m.Failure(now))
+	assert.False(m.Failure(now.Add(30 * time.Second)))
+	assert.Equal(30*time.Second, m.Duration(now.Add(30*time.Second)))
+	assert.Equal(40*time.Second, m.Success(now.Add(40*time.Second)))
+	assert.Equal(time.Duration(0), m.Duration(now.Add(40*time.Second)))
+
+	// Case for an outage that outlasts the grace window
+	now = now.Add(time.Hour)
+	assert.False(m.Failure(now))
+	assert.True(m.Failure(now.Add(time.Minute)))
+}
+
+func TestRecordOutageEnd(t *testing.T) {
+	assert := assert.New(t)
+	defer func(state *StateStore) { deviceState = state }(deviceState)
+	deviceState = NewStateStore()
+	agent := NewDeviceAgent(false)
+	agent.Outage = &OutageMonitor{Grace: time.Minute}
+
+	agent.recordOutageEnd()
+	assert.Equal(float64(0), deviceState.Heartbeat().LastOutage)
+	agent.Outage.Failure(time.Now().Add(-10 * time.Second))
+	agent.recordOutageEnd()
+	assert.InDelta(10, deviceState.Heartbeat().LastOutage, 1)
+}
@@ -0,0 +1,235 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// TelemetryOutboxSize is the maximum number of messages queued while offline; the oldest are dropped first
+	TelemetryOutboxSize = 500
+
+	// TelemetryOutboxBatchSize is the maximum number of queued messages sent in one request
+	TelemetryOutboxBatchSize = 100
+)
+
+// TelemetryOutbox queues telemetry that could not be sent while the control plane was unreachable, in memory and
+// in a file on disk, so that it can be delivered after reconnecting
+type TelemetryOutbox struct {
+	// Size is the maximum number of messages that are queued
+	Size int
+
+	entries   []client.QueuedTelemetry
+	lines     int
+	dropped   int
+	mutex     sync.Mutex
+	flushLock sync.Mutex
+}
+
+// NewTelemetryOutbox constructs a new instance of TelemetryOutbox
+func NewTelemetryOutbox(size int) *TelemetryOutbox {
+	return &TelemetryOutbox{Size: size}
+}
+
+// trim drops the oldest messages beyond the size of the outbox; callers must hold the lock
+func (o *TelemetryOutbox) trim() {
+	if extra := len(o.entries) - o.Size; extra > 0 {
+		o.entries = append([]client.QueuedTelemetry{}, o.entries[extra:]...)
+		o.dropped += extra
+	}
+}
+
+// Load reads messages that were queued before the agent restarted
+func (o *TelemetryOutbox) Load() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	rawBytes, err := ioutil.ReadFile(PathToTelemetryOutbox)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	o.entries, o.lines = nil, 0
+	scanner := bufio.NewScanner(bytes.NewReader(rawBytes))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		o.lines++
+		var entry client.QueuedTelemetry
+		// skip lines that were only partially written before a power loss
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		o.entries = append(o.entries, entry)
+	}
+	o.trim()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// rewrite a partially written last line, so that new messages are not appended to it
+	if len(rawBytes) > 0 && rawBytes[len(rawBytes)-1] != '\n' {
+		return o.compact()
+	}
+	return nil
+}
+
+// Add queues a message, and appends it to the outbox file
+// NOTE: the file is compacted once it holds twice as many lines as the outbox, to limit writes
+func (o *TelemetryOutbox) Add(message interface{}, now time.Time) error {
+	rawMessage, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	entry := client.QueuedTelemetry{QueuedAt: now, Message: rawMessage}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.entries = append(o.entries, entry)
+	o.trim()
+
+	if o.lines+1 > 2*o.Size {
+		return o.compact()
+	}
+	if err := os.MkdirAll(filepath.Dir(PathToTelemetryOutbox), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(PathToTelemetryOutbox, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	o.lines++
+	return nil
+}
+
+// compact rewrites the outbox file with only the messages held in memory, or removes it if there are none;
+// callers must hold the lock
+func (o *TelemetryOutbox) compact() error {
+	if len(o.entries) == 0 {
+		o.lines = 0
+		if err := os.Remove(PathToTelemetryOutbox); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, entry := range o.entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if err := os.MkdirAll(filepath.Dir(PathToTelemetryOutbox), 0755); err != nil {
+		return err
+	}
+	tmpPath := PathToTelemetryOutbox + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, PathToTelemetryOutbox); err != nil {
+		return err
+	}
+	o.lines = len(o.entries)
+	return nil
+}
+
+// Len returns the number of queued messages
+func (o *TelemetryOutbox) Len() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return len(o.entries)
+}
+
+// Flush sends queued messages in batches, oldest first, and returns the number that were delivered
+// NOTE: messages are sent without holding the lock, so that queueing is never blocked by a slow control plane
+func (o *TelemetryOutbox) Flush(ctx context.Context, apiClient *api.Client, id string) (int, error) {
+	o.flushLock.Lock()
+	defer o.flushLock.Unlock()
+
+	sent := 0
+	for {
+		o.mutex.Lock()
+		n := len(o.entries)
+		if n > TelemetryOutboxBatchSize {
+			n = TelemetryOutboxBatchSize
+		}
+		batch := append([]client.QueuedTelemetry{}, o.entries[:n]...)
+		dropped := o.dropped
+		o.mutex.Unlock()
+		if n == 0 {
+			return sent, nil
+		}
+
+		if err := apiClient.SendQueuedTelemetry(ctx, id, batch); err != nil {
+			return sent, err
+		}
+		sent += n
+
+		// remove the batch, minus any of its messages that were dropped to make room while it was being sent
+		o.mutex.Lock()
+		n -= o.dropped - dropped
+		if n > 0 {
+			o.entries = append([]client.QueuedTelemetry{}, o.entries[n:]...)
+		}
+		err := o.compact()
+		o.mutex.Unlock()
+		if err != nil {
+			return sent, err
+		}
+	}
+}
+
+// flushOutbox delivers heartbeats that were queued while the device was offline
+func (a *DeviceAgent) flushOutbox(ctx context.Context, apiClient *api.Client, id string) {
+	if a.Outbox.Len() == 0 {
+		return
+	}
+	sent, err := a.Outbox.Flush(ctx, apiClient, id)
+	if sent > 0 {
+		log.Info("Sent telemetry queued while offline", "count", sent)
+	}
+	if err != nil {
+		log.Error(err, "Failed to send queued telemetry", "remaining", a.Outbox.Len())
+	}
+}
+
+// queueTelemetry adds a message that could not be sent to the device outbox
+func (a *DeviceAgent) queueTelemetry(message interface{}) {
+	if err := a.Outbox.Add(message, time.Now()); err != nil {
+		log.Error(err, "Failed to queue telemetry", "path", PathToTelemetryOutbox)
+	}
+}
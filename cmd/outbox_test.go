@@ -0,0 +1,115 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/apitest"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTelemetryOutbox(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "jacktrip-agent")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	originalDir := AgentLibDir
+	defer func() { AgentLibDir = originalDir; updatePaths() }()
+	AgentLibDir = dir
+	updatePaths()
+
+	// Missing file should load an empty outbox
+	outbox := NewTelemetryOutbox(3)
+	assert.Nil(outbox.Load())
+	assert.Equal(0, outbox.Len())
+
+	// The oldest messages are dropped once the outbox is full
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		assert.Nil(outbox.Add(client.DeviceHeartbeat{MAC: string(rune('a' + i))}, start.Add(time.Duration(i)*time.Second)))
+	}
+	assert.Equal(3, outbox.Len())
+
+	// Messages should survive a restart, ignoring partially written lines
+	f, err := os.OpenFile(PathToTelemetryOutbox, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(err)
+	f.Write([]byte(`{"queuedAt":"20`))
+	f.Close()
+	loaded := NewTelemetryOutbox(3)
+	assert.Nil(loaded.Load())
+	assert.Equal(3, loaded.Len())
+
+	// Messages are kept while the control plane is unreachable
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	server := apitest.NewServer(credentials)
+	defer server.Close()
+	sent, err := loaded.Flush(context.Background(), api.NewClient(server.URL, client.AgentCredentials{}, nil), "abc")
+	assert.NotNil(err)
+	assert.Equal(0, sent)
+	assert.Equal(3, loaded.Len())
+
+	// Messages are delivered oldest first with the time they were queued, then removed
+	sent, err = loaded.Flush(context.Background(), api.NewClient(server.URL, credentials, nil), "abc")
+	assert.Nil(err)
+	assert.Equal(3, sent)
+	assert.Equal(0, loaded.Len())
+	queued := server.QueuedTelemetry()
+	assert.Len(queued, 3)
+	for i, entry := range queued {
+		assert.True(start.Add(time.Duration(i+1) * time.Second).Equal(entry.QueuedAt))
+		var beat client.DeviceHeartbeat
+		assert.Nil(json.Unmarshal(entry.Message, &beat))
+		assert.Equal(string(rune('a'+i+1)), beat.MAC)
+	}
+	_, err = os.Stat(PathToTelemetryOutbox)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestTelemetryOutboxBatches(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "jacktrip-agent")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	originalDir := AgentLibDir
+	defer func() { AgentLibDir = originalDir; updatePaths() }()
+	AgentLibDir = dir
+	updatePaths()
+
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	server := apitest.NewServer(credentials)
+	defer server.Close()
+
+	// Large backlogs are sent in several requests, and the file is compacted as the outbox grows
+	outbox := NewTelemetryOutbox(TelemetryOutboxSize)
+	for i := 0; i < 2*TelemetryOutboxBatchSize+1; i++ {
+		assert.Nil(outbox.Add(client.DeviceHeartbeat{MAC: "abc"}, time.Now()))
+	}
+	sent, err := outbox.Flush(context.Background(), api.NewClient(server.URL, credentials, nil), "abc")
+	assert.Nil(err)
+	assert.Equal(2*TelemetryOutboxBatchSize+1, sent)
+	assert.Len(server.QueuedTelemetry(), sent)
+	assert.Equal(0, outbox.Len())
+}
@@ -0,0 +1,448 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/jmoiron/sqlx/types"
+)
+
+const (
+	// PairingWindow is how long a pairing PIN may be used after pairing is started
+	PairingWindow = 2 * time.Minute
+
+	// PairingMaxAttempts is the number of incorrect PINs allowed before the pairing window is closed
+	PairingMaxAttempts = 5
+
+	// PairingPINDigits is the number of digits in a pairing PIN
+	PairingPINDigits = 6
+
+	// PairingPath is the route used by companion apps to pair with a device
+	PairingPath = "/pairing"
+
+	// PairingStatusInterval is the time between status events sent to paired apps
+	PairingStatusInterval = HeartbeatInterval * time.Second
+
+	// Types of events sent to and received from paired apps
+	pairingStatusEvent = "status"
+	pairingVolumeEvent = "volume"
+	pairingErrorEvent  = "error"
+)
+
+var (
+	errPairingClosed   = errors.New("pairing has not been started or has expired")
+	errPairingRejected = errors.New("incorrect pairing PIN")
+)
+
+// PairedApp is a companion app that has paired with the device
+type PairedApp struct {
+	Name      string    `json:"name"`
+	TokenHash string    `json:"tokenHash"`
+	PairedAt  time.Time `json:"pairedAt"`
+}
+
+// PairingVolumes are volume changes requested by a paired app; nil values are left unchanged
+type PairingVolumes struct {
+	CaptureVolume  *int  `json:"captureVolume,omitempty"`
+	CaptureMute    *bool `json:"captureMute,omitempty"`
+	PlaybackVolume *int  `json:"playbackVolume,omitempty"`
+	PlaybackMute   *bool `json:"playbackMute,omitempty"`
+	MonitorVolume  *int  `json:"monitorVolume,omitempty"`
+	MonitorMute    *bool `json:"monitorMute,omitempty"`
+}
+
+// PairingEvent is exchanged with paired apps over the local event stream
+type PairingEvent struct {
+	Type    string                `json:"type"`
+	Status  *StateSnapshot        `json:"status,omitempty"`
+	Volumes *client.ALSAConfig    `json:"volumes,omitempty"`
+	Metrics *client.DeviceMetrics `json:"metrics,omitempty"`
+	Error   string                `json:"error,omitempty"`
+	PairingVolumes
+}
+
+// PairingManager keeps track of pairing PINs, paired apps and volumes set by paired apps
+type PairingManager struct {
+	pin       string
+	expiresAt time.Time
+	attempts  int
+	apps      []PairedApp
+	volumes   *client.ALSAConfig
+	mutex     sync.Mutex
+}
+
+// hashPairingToken returns the hash of a token that is stored for a paired app
+func hashPairingToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newPairingPIN returns a random numeric PIN
+func newPairingPIN() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < PairingPINDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", PairingPINDigits, n), nil
+}
+
+// newPairingToken returns a random token used by a paired app to authenticate
+func newPairingToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Load reads paired apps from disk, if any were saved
+func (m *PairingManager) Load() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	rawBytes, err := ioutil.ReadFile(PathToPairedApps)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(rawBytes, &m.apps)
+}
+
+// save writes paired apps to disk; callers must hold the lock
+func (m *PairingManager) save() error {
+	rawBytes, err := json.Marshal(m.apps)
+	if err != nil {
+		return err
+	}
+	if common.IsFileUnchanged(PathToPairedApps, rawBytes) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(PathToPairedApps), 0755); err != nil {
+		return err
+	}
+	tmpPath := PathToPairedApps + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, rawBytes, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, PathToPairedApps)
+}
+
+// Start opens a pairing window, returning the PIN that must be entered in the app
+func (m *PairingManager) Start(now time.Time) (string, time.Time, error) {
+	pin, err := newPairingPIN()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.pin = pin
+	m.expiresAt = now.Add(PairingWindow)
+	m.attempts = 0
+	return m.pin, m.expiresAt, nil
+}
+
+// Confirm pairs an app if the PIN is correct, returning the token it should use
+func (m *PairingManager) Confirm(pin, name string, now time.Time) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.pin == "" || now.After(m.expiresAt) {
+		return "", errPairingClosed
+	}
+	if subtle.ConstantTimeCompare([]byte(pin), []byte(m.pin)) != 1 {
+		m.attempts++
+		if m.attempts >= PairingMaxAttempts {
+			m.pin = ""
+		}
+		return "", errPairingRejected
+	}
+	token, err := newPairingToken()
+	if err != nil {
+		return "", err
+	}
+	m.pin = ""
+	m.apps = append(m.apps, PairedApp{Name: name, TokenHash: hashPairingToken(token), PairedAt: now})
+	if err := m.save(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// IsPaired returns true if a token belongs to a paired app
+func (m *PairingManager) IsPaired(token string) bool {
+	if token == "" {
+		return false
+	}
+	hash := hashPairingToken(token)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, app := range m.apps {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(app.TokenHash)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Apps returns all paired apps
+func (m *PairingManager) Apps() []PairedApp {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]PairedApp{}, m.apps...)
+}
+
+// Revoke unpairs all apps
+func (m *PairingManager) Revoke() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.apps = nil
+	m.pin = ""
+	return m.save()
+}
+
+// Volumes returns the volumes currently applied to the sound card
+func (m *PairingManager) Volumes(config client.DeviceAgentConfig) client.ALSAConfig {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.volumes != nil {
+		return *m.volumes
+	}
+	return config.ALSAConfig
+}
+
+// SetVolumes applies volume changes from a paired app on top of the current volumes
+// NOTE: these are kept until volumes are changed in the device config
+func (m *PairingManager) SetVolumes(config client.DeviceAgentConfig, v PairingVolumes) (client.ALSAConfig, error) {
+	volumes := m.Volumes(config)
+	for _, level := range []*int{v.CaptureVolume, v.PlaybackVolume, v.MonitorVolume} {
+		if level != nil && (*level < 0 || *level > 100) {
+			return volumes, fmt.Errorf("volume must be between 0 and 100, got %d", *level)
+		}
+	}
+	if v.CaptureVolume != nil {
+		volumes.CaptureVolume = *v.CaptureVolume
+	}
+	if v.CaptureMute != nil {
+		volumes.CaptureMute = types.BitBool(*v.CaptureMute)
+	}
+	if v.PlaybackVolume != nil {
+		volumes.PlaybackVolume = *v.PlaybackVolume
+	}
+	if v.PlaybackMute != nil {
+		volumes.PlaybackMute = types.BitBool(*v.PlaybackMute)
+	}
+	if v.MonitorVolume != nil {
+		volumes.MonitorVolume = *v.MonitorVolume
+	}
+	if v.MonitorMute != nil {
+		volumes.MonitorMute = types.BitBool(*v.MonitorMute)
+	}
+	m.mutex.Lock()
+	m.volumes = &volumes
+	m.mutex.Unlock()
+	config.ALSAConfig = volumes
+	updateALSASettings(config)
+	return volumes, nil
+}
+
+// ResetVolumes discards volume changes made by paired apps
+func (m *PairingManager) ResetVolumes() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.volumes = nil
+}
+
+// getPairingToken returns the token sent by a paired app, as a bearer token or query parameter
+// NOTE: browsers are unable to set headers on websocket requests
+func getPairingToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// handlePairingStartRequest opens a pairing window; the PIN is returned to the owner's signed request
+func handlePairingStartRequest(pairing *PairingManager, credentials client.AgentCredentials, w http.ResponseWriter, r *http.Request) {
+	if !isAuthorizedAdminRequest(credentials, r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	pin, expiresAt, err := pairing.Start(time.Now())
+	if err != nil {
+		log.Error(err, "Failed to start pairing")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Info("Started pairing", "expiresAt", expiresAt)
+	RespondJSON(w, http.StatusOK, map[string]interface{}{"pin": pin, "expiresAt": expiresAt})
+}
+
+// handlePairingConfirmRequest exchanges a pairing PIN for a token
+func handlePairingConfirmRequest(pairing *PairingManager, w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		PIN  string `json:"pin"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+	token, err := pairing.Confirm(req.PIN, req.Name, time.Now())
+	switch err {
+	case nil:
+		log.Info("Paired companion app", "name", req.Name)
+		RespondJSON(w, http.StatusOK, map[string]string{"token": token})
+	case errPairingClosed, errPairingRejected:
+		RespondJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
+	default:
+		log.Error(err, "Failed to pair companion app", "name", req.Name)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// handlePairingRevokeRequest unpairs all companion apps
+func handlePairingRevokeRequest(pairing *PairingManager, credentials client.AgentCredentials, w http.ResponseWriter, r *http.Request) {
+	if !isAuthorizedAdminRequest(credentials, r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err := pairing.Revoke(); err != nil {
+		log.Error(err, "Failed to revoke paired apps")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Info("Revoked all paired apps")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getPairingStatusEvent returns the latest device status for paired apps
+func getPairingStatusEvent(pairing *PairingManager) PairingEvent {
+	snapshot := deviceState.Snapshot()
+	volumes := pairing.Volumes(deviceState.Config())
+	return PairingEvent{Type: pairingStatusEvent, Status: &snapshot, Volumes: &volumes, Metrics: deviceState.Heartbeat().Metrics}
+}
+
+// pairingUpgrader accepts websocket connections from any origin, since apps authenticate with a token
+var pairingUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handlePairingEventsRequest streams device status to a paired app, and applies volume changes it sends
+func handlePairingEventsRequest(ctx context.Context, pairing *PairingManager, w http.ResponseWriter, r *http.Request) {
+	if !pairing.IsPaired(getPairingToken(r)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	conn, err := pairingUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error(err, "Failed to upgrade pairing event stream")
+		return
+	}
+	defer conn.Close()
+
+	events := deviceState.Subscribe()
+	defer deviceState.Unsubscribe(events)
+	// done stops the reader once the writer returns, and closing conn unblocks any pending read
+	received := make(chan PairingEvent)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(received)
+		for {
+			var event PairingEvent
+			if err := conn.ReadJSON(&event); err != nil {
+				return
+			}
+			select {
+			case received <- event:
+			case <-done:
+				return
+			}
+		}
+	}()
+	ticker := time.NewTicker(PairingStatusInterval)
+	defer ticker.Stop()
+
+	send := getPairingStatusEvent(pairing)
+	for {
+		if err := conn.WriteJSON(send); err != nil {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-events:
+			send = getPairingStatusEvent(pairing)
+		case <-ticker.C:
+			send = getPairingStatusEvent(pairing)
+		case event, ok := <-received:
+			if !ok {
+				return
+			}
+			if event.Type != pairingVolumeEvent {
+				send = PairingEvent{Type: pairingErrorEvent, Error: fmt.Sprintf("unsupported event type %q", event.Type)}
+				continue
+			}
+			if _, err := pairing.SetVolumes(deviceState.Config(), event.PairingVolumes); err != nil {
+				send = PairingEvent{Type: pairingErrorEvent, Error: err.Error()}
+				continue
+			}
+			send = getPairingStatusEvent(pairing)
+		}
+	}
+}
+
+// addPairingRoutes adds companion app pairing endpoints to a router
+func addPairingRoutes(ctx context.Context, router *mux.Router, credentials client.AgentCredentials, pairing *PairingManager) {
+	routes := router.PathPrefix(PairingPath).Subrouter()
+	routes.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
+		handlePairingStartRequest(pairing, credentials, w, r)
+	}).Methods("POST")
+	routes.HandleFunc("", func(w http.ResponseWriter, r *http.Request) {
+		handlePairingRevokeRequest(pairing, credentials, w, r)
+	}).Methods("DELETE")
+	routes.HandleFunc("/confirm", func(w http.ResponseWriter, r *http.Request) {
+		handlePairingConfirmRequest(pairing, w, r)
+	}).Methods("POST")
+	routes.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		handlePairingEventsRequest(ctx, pairing, w, r)
+	}).Methods("GET")
+}
@@ -0,0 +1,169 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPairingManager(t *testing.T) {
+	assert := assert.New(t)
+	defer func(path string) { PathToPairedApps = path }(PathToPairedApps)
+	PathToPairedApps = filepath.Join(t.TempDir(), "paired-apps.json")
+	now := time.Now()
+	m := &PairingManager{}
+
+	// Case for pairing before it was started
+	_, err := m.Confirm("000000", "phone", now)
+	assert.Equal(errPairingClosed, err)
+
+	// Case for a successful pairing
+	pin, expiresAt, err := m.Start(now)
+	assert.Nil(err)
+	assert.Equal(PairingPINDigits, len(pin))
+	assert.Equal(now.Add(PairingWindow), expiresAt)
+	token, err := m.Confirm(pin, "phone", now)
+	assert.Nil(err)
+	assert.True(m.IsPaired(token))
+	assert.False(m.IsPaired(""))
+	assert.False(m.IsPaired("other"))
+
+	// PINs may only be used once
+	_, err = m.Confirm(pin, "tablet", now)
+	assert.Equal(errPairingClosed, err)
+
+	// paired apps are persisted
+	loaded := &PairingManager{}
+	assert.Nil(loaded.Load())
+	assert.True(loaded.IsPaired(token))
+	assert.Equal("phone", loaded.Apps()[0].Name)
+
+	// Case for an expired PIN
+	pin, _, _ = m.Start(now)
+	_, err = m.Confirm(pin, "tablet", now.Add(PairingWindow+time.Second))
+	assert.Equal(errPairingClosed, err)
+
+	// Case for too many incorrect PINs
+	pin, _, _ = m.Start(now)
+	for i := 0; i < PairingMaxAttempts; i++ {
+		_, err = m.Confirm("bad", "tablet", now)
+		assert.Equal(errPairingRejected, err)
+	}
+	_, err = m.Confirm(pin, "tablet", now)
+	assert.Equal(errPairingClosed, err)
+
+	// Case for revoking all apps
+	assert.Nil(m.Revoke())
+	assert.False(m.IsPaired(token))
+	assert.Nil(loaded.Load())
+	assert.Empty(loaded.Apps())
+}
+
+func TestPairingVolumes(t *testing.T) {
+	assert := assert.New(t)
+	alsa := NewFakeAlsa()
+	defer func(prev AlsaProvider, state *StateStore) { alsaProvider, deviceState = prev, state }(alsaProvider, deviceState)
+	alsaProvider, deviceState = alsa, NewStateStore()
+	deviceState.SetSoundDevice(SoundDevice{Name: "dummy"})
+	alsa.CardList = " 1 [USB            ]: USB-Audio - USB Audio Device\n"
+	alsa.ControlLists[1] = "numid=4,iface=MIXER,name='Mic Capture Volume'\n"
+
+	m := &PairingManager{}
+	config := client.DeviceAgentConfig{}
+	config.CaptureVolume = 50
+	config.PlaybackVolume = 60
+	assert.Equal(config.ALSAConfig, m.Volumes(config))
+
+	level := 80
+	volumes, err := m.SetVolumes(config, PairingVolumes{CaptureVolume: &level})
+	assert.Nil(err)
+	assert.Equal(80, volumes.CaptureVolume)
+	assert.Equal(60, volumes.PlaybackVolume)
+	assert.Equal(volumes, m.Volumes(config))
+	assert.Equal("80%", alsa.Values["1:Mic Capture Volume"])
+
+	level = 101
+	_, err = m.SetVolumes(config, PairingVolumes{PlaybackVolume: &level})
+	assert.NotNil(err)
+
+	m.ResetVolumes()
+	assert.Equal(config.ALSAConfig, m.Volumes(config))
+}
+
+func TestPairingEvents(t *testing.T) {
+	assert := assert.New(t)
+	defer func(path string) { PathToPairedApps = path }(PathToPairedApps)
+	PathToPairedApps = filepath.Join(t.TempDir(), "paired-apps.json")
+	pairing := &PairingManager{}
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+
+	router := mux.NewRouter()
+	addPairingRoutes(context.Background(), router, credentials, pairing)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	// starting pairing requires the agent's credentials
+	resp, err := http.Post(server.URL+PairingPath, "application/json", nil)
+	assert.Nil(err)
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	pin, _, _ := pairing.Start(time.Now())
+	resp, err = http.Post(server.URL+PairingPath+"/confirm", "application/json", strings.NewReader(`{"pin":"`+pin+`","name":"phone"}`))
+	assert.Nil(err)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	var paired struct {
+		Token string `json:"token"`
+	}
+	assert.Nil(json.NewDecoder(resp.Body).Decode(&paired))
+	resp.Body.Close()
+
+	// event streams require a paired token
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + PairingPath + "/events"
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL, nil)
+	assert.NotNil(err)
+	assert.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	// paired apps receive status as soon as they connect
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Authorization": {"Bearer " + paired.Token}})
+	assert.Nil(err)
+	defer conn.Close()
+	var event PairingEvent
+	assert.Nil(conn.ReadJSON(&event))
+	assert.Equal(pairingStatusEvent, event.Type)
+	assert.NotNil(event.Status)
+	assert.NotNil(event.Volumes)
+
+	// invalid requests are reported as errors
+	assert.Nil(conn.WriteJSON(map[string]interface{}{"type": pairingVolumeEvent, "captureVolume": 200}))
+	event = PairingEvent{}
+	assert.Nil(conn.ReadJSON(&event))
+	assert.Equal(pairingErrorEvent, event.Type)
+	assert.Contains(event.Error, "between 0 and 100")
+}
@@ -0,0 +1,266 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// AgentPathsFile is the name of an optional file in AgentConfigDir that overrides agent directories,
+	// using the same KEY=VALUE environment variables documented below
+	AgentPathsFile = "agent.conf"
+
+	// ConfigDirEnv overrides AgentConfigDir; it is only read from the environment
+	ConfigDirEnv = "JACKTRIP_CONFIG_DIR"
+
+	// LibDirEnv overrides AgentLibDir
+	LibDirEnv = "JACKTRIP_LIB_DIR"
+
+	// ServiceConfigDirEnv overrides ServiceConfigDir
+	ServiceConfigDirEnv = "JACKTRIP_SERVICE_CONFIG_DIR"
+
+	// AvahiServicesDirEnv overrides AvahiServicesDir
+	AvahiServicesDirEnv = "JACKTRIP_AVAHI_SERVICES_DIR"
+
+	// NetworkInterfaceEnv overrides NetworkInterface
+	NetworkInterfaceEnv = "JACKTRIP_NETWORK_INTERFACE"
+
+	// ControlInterfaceEnv sets ControlInterface
+	ControlInterfaceEnv = "JACKTRIP_CONTROL_INTERFACE"
+)
+
+const (
+	// DefaultAgentLibDir is the default value of AgentLibDir
+	DefaultAgentLibDir = "/var/lib/jacktrip"
+
+	// DefaultServiceConfigDir is the default value of ServiceConfigDir
+	DefaultServiceConfigDir = "/tmp/default"
+
+	// DefaultAvahiServicesDir is the default value of AvahiServicesDir
+	DefaultAvahiServicesDir = "/tmp/avahi/services"
+)
+
+var (
+	// AgentConfigDir is the directory containing agent config files
+	AgentConfigDir = "/etc/jacktrip"
+
+	// AgentLibDir is the directory containing additional files used by the agent
+	AgentLibDir = DefaultAgentLibDir
+
+	// ServiceConfigDir is the directory containing config files for managed systemd services
+	ServiceConfigDir = DefaultServiceConfigDir
+
+	// AvahiServicesDir is the directory containing avahi service files
+	AvahiServicesDir = DefaultAvahiServicesDir
+
+	// NetworkInterface is the network interface whose MAC address identifies the device
+	NetworkInterface = "eth0"
+
+	// ControlInterface is the network interface used by the agent's own connections and HTTP servers;
+	// all interfaces are used if it is empty
+	ControlInterface = ""
+)
+
+var (
+	// PathToJackConfig is the path to Jack service config file
+	PathToJackConfig string
+
+	// PathToJackTripConfig is the path to JackTrip service config file
+	PathToJackTripConfig string
+
+	// PathToJackTripStandbyConfig is the path to JackTrip service config file for the standby server
+	PathToJackTripStandbyConfig string
+
+	// PathToJamulusConfig is the path to Jamulus service config file
+	PathToJamulusConfig string
+
+	// PathToMetronomeConfig is the path to metronome service config file
+	PathToMetronomeConfig string
+
+	// PathToTimecodeConfig is the path to timecode service config file
+	PathToTimecodeConfig string
+
+	// PathToEffectsConfig is the path to effects service config file
+	PathToEffectsConfig string
+
+	// PathToAES67Config is the path to AES67 bridge service config file
+	PathToAES67Config string
+
+	// PathToChronyConfig is the path to chrony config file
+	PathToChronyConfig string
+
+	// PathToPTPConfig is the path to PTP services config file
+	PathToPTPConfig string
+
+	// PathToAlsaState is the location of the ALSA state file for a particular device
+	PathToAlsaState string
+
+	// PathToZitaConfig is a systemd conf file path for zita
+	PathToZitaConfig string
+
+	// PathToFirewallRules is the path to the nftables rules applied by the managed firewall
+	PathToFirewallRules string
+
+	// PathToAccountingRules is the path to the nftables rules that count JackTrip traffic
+	PathToAccountingRules string
+
+	// PathToAvahiServiceFile is the path to the avahi service file for jacktrip-agent
+	PathToAvahiServiceFile string
+
+	// PathToDeviceConfigCache is the path to the last known good device config
+	PathToDeviceConfigCache string
+
+	// PathToDeviceIdentity is the path to the identity (MAC address) chosen the first time a device started
+	PathToDeviceIdentity string
+
+	// PathToPairedApps is the path to the list of companion apps paired with a device
+	PathToPairedApps string
+
+	// PathToSessionTimeline is the path to the ring file of recent session events
+	PathToSessionTimeline string
+
+	// PathToTelemetryOutbox is the path to telemetry queued while the control plane is unreachable
+	PathToTelemetryOutbox string
+
+	// PathToMixPresets is the path to the mix presets stored on a server
+	PathToMixPresets string
+
+	// PathToSuperColliderConfig is the path to the SuperCollider server service config file
+	PathToSuperColliderConfig string
+
+	// PathToSCLangStartup is the path to the sclang code run by the SuperCollider language service
+	PathToSCLangStartup string
+
+	// PathToRecorderConfig is the path to the recorder service config file
+	PathToRecorderConfig string
+
+	// PathToFaustDSP is the path to the Faust code compiled for the Faust mixer
+	PathToFaustDSP string
+
+	// PathToFaustProgram is the path to the JACK program that faust2jack compiles from PathToFaustDSP
+	PathToFaustProgram string
+
+	// PathToRecordings is the directory that recordings and multitrack captures are written to
+	PathToRecordings string
+
+	// PathToHLS is the directory that HLS playlists and segments are written to
+	PathToHLS string
+
+	// PathToTLSCertificate is the path to the self-signed certificate used for TLS
+	PathToTLSCertificate string
+
+	// PathToTLSKey is the path to the private key of the self-signed certificate used for TLS
+	PathToTLSKey string
+)
+
+// agentDirs maps the settings used to override agent directories (and network interfaces) to their variables
+var agentDirs = map[string]*string{
+	LibDirEnv:           &AgentLibDir,
+	ServiceConfigDirEnv: &ServiceConfigDir,
+	AvahiServicesDirEnv: &AvahiServicesDir,
+	NetworkInterfaceEnv: &NetworkInterface,
+	ControlInterfaceEnv: &ControlInterface,
+}
+
+func init() {
+	updatePaths()
+}
+
+// updatePaths derives the paths of individual files from the agent directories
+func updatePaths() {
+	PathToJackConfig = filepath.Join(ServiceConfigDir, "jack")
+	PathToJackTripConfig = filepath.Join(ServiceConfigDir, "jacktrip")
+	PathToJackTripStandbyConfig = filepath.Join(ServiceConfigDir, "jacktrip-standby")
+	PathToJamulusConfig = filepath.Join(ServiceConfigDir, "jamulus")
+	PathToMetronomeConfig = filepath.Join(ServiceConfigDir, "metronome")
+	PathToTimecodeConfig = filepath.Join(ServiceConfigDir, "timecode")
+	PathToEffectsConfig = filepath.Join(ServiceConfigDir, "effects")
+	PathToAES67Config = filepath.Join(ServiceConfigDir, "aes67")
+	PathToChronyConfig = filepath.Join(ServiceConfigDir, "chrony.conf")
+	PathToPTPConfig = filepath.Join(ServiceConfigDir, "ptp")
+	PathToAlsaState = filepath.Join(ServiceConfigDir, "asound-%s.state")
+	PathToZitaConfig = filepath.Join(ServiceConfigDir, "zita-%s-conf")
+	PathToFirewallRules = filepath.Join(ServiceConfigDir, "nftables.conf")
+	PathToAccountingRules = filepath.Join(ServiceConfigDir, "accounting.conf")
+	PathToSuperColliderConfig = filepath.Join(ServiceConfigDir, "supercollider")
+	PathToSCLangStartup = filepath.Join(ServiceConfigDir, "startup.scd")
+	PathToRecorderConfig = filepath.Join(ServiceConfigDir, "recorder")
+	PathToFaustDSP = filepath.Join(ServiceConfigDir, "faust.dsp")
+	PathToFaustProgram = filepath.Join(ServiceConfigDir, "faust")
+	PathToAvahiServiceFile = filepath.Join(AvahiServicesDir, "jacktrip-agent.service")
+	PathToDeviceConfigCache = filepath.Join(AgentLibDir, "config.json")
+	PathToDeviceIdentity = filepath.Join(AgentLibDir, "identity.json")
+	PathToPairedApps = filepath.Join(AgentLibDir, "paired-apps.json")
+	PathToSessionTimeline = filepath.Join(AgentLibDir, "timeline.jsonl")
+	PathToTelemetryOutbox = filepath.Join(AgentLibDir, "outbox.jsonl")
+	PathToMixPresets = filepath.Join(AgentLibDir, "mix-presets.json")
+	PathToRecordings = filepath.Join(AgentLibDir, "recordings")
+	PathToHLS = filepath.Join(AgentLibDir, "hls")
+	PathToTLSCertificate = filepath.Join(AgentLibDir, "tls", "cert.pem")
+	PathToTLSKey = filepath.Join(AgentLibDir, "tls", "key.pem")
+}
+
+// parseAgentPaths parses KEY=VALUE lines from an agent paths file, ignoring blank lines and comments
+func parseAgentPaths(rawBytes []byte) (map[string]string, error) {
+	settings := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(rawBytes))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		splits := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(splits[0])
+		if _, ok := agentDirs[key]; !ok || len(splits) != 2 {
+			return nil, fmt.Errorf("invalid setting on line %d: %s", n, line)
+		}
+		settings[key] = strings.Trim(strings.TrimSpace(splits[1]), `"`)
+	}
+	return settings, scanner.Err()
+}
+
+// loadAgentPaths overrides agent directories using the agent paths file, then the environment
+func loadAgentPaths() error {
+	if dir := os.Getenv(ConfigDirEnv); dir != "" {
+		AgentConfigDir = dir
+	}
+
+	rawBytes, err := ioutil.ReadFile(filepath.Join(AgentConfigDir, AgentPathsFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	settings, err := parseAgentPaths(rawBytes)
+	if err != nil {
+		return fmt.Errorf("%s: %w", AgentPathsFile, err)
+	}
+
+	for key, dir := range agentDirs {
+		if value := os.Getenv(key); value != "" {
+			settings[key] = value
+		}
+		if value, ok := settings[key]; ok && value != "" {
+			*dir = value
+		}
+	}
+	updatePaths()
+	return nil
+}
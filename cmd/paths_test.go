@@ -0,0 +1,71 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAgentPaths(t *testing.T) {
+	assert := assert.New(t)
+
+	settings, err := parseAgentPaths([]byte("# comment\n\nJACKTRIP_LIB_DIR = /data/lib\nJACKTRIP_AVAHI_SERVICES_DIR=\"/run/avahi\"\n"))
+	assert.NoError(err)
+	assert.Equal(map[string]string{LibDirEnv: "/data/lib", AvahiServicesDirEnv: "/run/avahi"}, settings)
+
+	settings, err = parseAgentPaths([]byte("JACKTRIP_NETWORK_INTERFACE=wlan0\n"))
+	assert.NoError(err)
+	assert.Equal(map[string]string{NetworkInterfaceEnv: "wlan0"}, settings)
+
+	_, err = parseAgentPaths([]byte("JACKTRIP_UNKNOWN_DIR=/data\n"))
+	assert.EqualError(err, "invalid setting on line 1: JACKTRIP_UNKNOWN_DIR=/data")
+
+	_, err = parseAgentPaths([]byte("\nJACKTRIP_LIB_DIR\n"))
+	assert.EqualError(err, "invalid setting on line 2: JACKTRIP_LIB_DIR")
+}
+
+func TestLoadAgentPaths(t *testing.T) {
+	assert := assert.New(t)
+	configDir, libDir, serviceConfigDir, avahiServicesDir := AgentConfigDir, AgentLibDir, ServiceConfigDir, AvahiServicesDir
+	defer func() {
+		AgentConfigDir, AgentLibDir, ServiceConfigDir, AvahiServicesDir = configDir, libDir, serviceConfigDir, avahiServicesDir
+		updatePaths()
+	}()
+
+	// defaults are used without a paths file
+	dir := t.TempDir()
+	t.Setenv(ConfigDirEnv, dir)
+	assert.NoError(loadAgentPaths())
+	assert.Equal(dir, AgentConfigDir)
+	assert.Equal("/var/lib/jacktrip/config.json", PathToDeviceConfigCache)
+	assert.Equal("/tmp/default/jack", PathToJackConfig)
+
+	// environment variables take precedence over the paths file
+	content := "JACKTRIP_LIB_DIR=/data/lib\nJACKTRIP_SERVICE_CONFIG_DIR=/data/default\n"
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, AgentPathsFile), []byte(content), 0644))
+	t.Setenv(ServiceConfigDirEnv, "/run/default")
+	assert.NoError(loadAgentPaths())
+	assert.Equal("/data/lib/config.json", PathToDeviceConfigCache)
+	assert.Equal("/run/default/jack", PathToJackConfig)
+	assert.Equal("/run/default/zita-%s-conf", PathToZitaConfig)
+	assert.Equal("/tmp/avahi/services/jacktrip-agent.service", PathToAvahiServiceFile)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, AgentPathsFile), []byte("bad"), 0644))
+	assert.Error(loadAgentPaths())
+}
@@ -0,0 +1,105 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// PersonalMixOSCPort is the port that the studio's SuperCollider mixer listens on for OSC messages
+	PersonalMixOSCPort = common.SuperColliderOSCPort
+
+	// PersonalMixOSCPrefix is prepended to the address of all relayed personal mix messages
+	PersonalMixOSCPrefix = "/personalmix"
+)
+
+// personalMixAddressRegexp is used to validate the addresses of personal mix control messages
+var personalMixAddressRegexp = regexp.MustCompile(`^(/[A-Za-z0-9_]+)+$`)
+
+// PersonalMixMessage is a personal mix control message (ie. a fader move) received from the LAN
+type PersonalMixMessage struct {
+	// OSC address of the control, relative to PersonalMixOSCPrefix (ie. "/volume/2")
+	Address string `json:"address"`
+
+	// new value for the control
+	Value float32 `json:"value"`
+}
+
+// validatePersonalMixMessage checks that a personal mix control message can be relayed
+func validatePersonalMixMessage(msg PersonalMixMessage) error {
+	if !personalMixAddressRegexp.MatchString(msg.Address) {
+		return fmt.Errorf("invalid personal mix address: %s", msg.Address)
+	}
+	return nil
+}
+
+// relayPersonalMixMessage forwards a personal mix control message to the studio's SuperCollider mixer
+func relayPersonalMixMessage(config client.DeviceAgentConfig, remoteName string, msg PersonalMixMessage) error {
+	if !config.Enabled || config.Host == "" {
+		return errors.New("device is not connected to a studio")
+	}
+	if err := validatePersonalMixMessage(msg); err != nil {
+		return err
+	}
+	// include the device's remote name so the mixer knows whose mix to adjust
+	osc := common.NewOSCClient(config.Host, PersonalMixOSCPort)
+	return osc.Send(PersonalMixOSCPrefix+msg.Address, remoteName, msg.Value)
+}
+
+// handlePersonalMixRequest upgrades personal mix requests to a websocket relay
+func handlePersonalMixRequest(mac string, w http.ResponseWriter, r *http.Request) {
+	remoteName := strings.Replace(mac, ":", "", -1)
+
+	// only allow control surfaces served by the device itself, or apps that are not browsers
+	upgrader := websocket.Upgrader{CheckOrigin: isSameOrigin}
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Error(err, "Unable to upgrade to websocket")
+		return
+	}
+	defer c.Close()
+	for {
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Error(err, "Unable to read websocket message")
+			}
+			break
+		}
+
+		var msg PersonalMixMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Error(err, "Failed to unmarshal personal mix message")
+			continue
+		}
+
+		if err := relayPersonalMixMessage(deviceState.Config(), remoteName, msg); err != nil {
+			log.Error(err, "Failed to relay personal mix message", "address", msg.Address)
+		}
+	}
+}
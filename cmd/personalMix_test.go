@@ -0,0 +1,44 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePersonalMixMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for valid address
+	assert.Nil(validatePersonalMixMessage(PersonalMixMessage{Address: "/volume/2", Value: 0.5}))
+
+	// Case for invalid addresses
+	for _, address := range []string{"", "/", "volume", "/volume/", "/volume/../2", "/vol ume"} {
+		assert.NotNil(validatePersonalMixMessage(PersonalMixMessage{Address: address}), address)
+	}
+}
+
+func TestRelayPersonalMixMessageDisconnected(t *testing.T) {
+	assert := assert.New(t)
+	config := client.DeviceAgentConfig{}
+	err := relayPersonalMixMessage(config, "001b44113ab7", PersonalMixMessage{Address: "/volume/1"})
+	assert.NotNil(err)
+}
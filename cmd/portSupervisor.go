@@ -0,0 +1,184 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xthexder/go-jack"
+)
+
+// portSupervisorBufferSize is the number of port registrations buffered before they are dropped
+const portSupervisorBufferSize = 200
+
+// PortConnection is a JACK connection from an output port to an input port
+type PortConnection struct {
+	Src  string
+	Dest string
+}
+
+// PortSupervisor keeps a set of JACK connections in place, re-wiring them whenever one of their ports is registered again
+// NOTE: this is meant for clients like the recorder, whose inputs are lost whenever JackTrip restarts
+type PortSupervisor struct {
+	Name        string
+	Connections []PortConnection
+	JackClient  JackGraph
+
+	registrations chan jack.PortId
+	mutex         sync.Mutex
+}
+
+// NewPortSupervisor constructs a new instance of PortSupervisor
+func NewPortSupervisor(name string, connections ...PortConnection) *PortSupervisor {
+	return &PortSupervisor{
+		Name:          name,
+		Connections:   connections,
+		registrations: make(chan jack.PortId, portSupervisorBufferSize),
+	}
+}
+
+// handlePortRegistration signals the supervisor when a new port is registered
+// NOTE: ports cannot be connected in the callback thread, so use a channel
+func (s *PortSupervisor) handlePortRegistration(port jack.PortId, register bool) {
+	if !register {
+		return
+	}
+	select {
+	case s.registrations <- port:
+	default:
+		// a full buffer already guarantees another reconnect
+	}
+}
+
+// Open creates a JACK client for the supervisor, and connects any ports that already exist
+func (s *PortSupervisor) Open() error {
+	client, err := openJackGraph(s.Name, s.handlePortRegistration, nil)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.JackClient = client
+	s.mutex.Unlock()
+	s.Reconnect()
+	return nil
+}
+
+// Close closes the JACK client of the supervisor
+func (s *PortSupervisor) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.JackClient != nil {
+		s.JackClient.Close()
+		s.JackClient = nil
+	}
+}
+
+// SetConnections replaces the supervised connections, ie. when a new config changes the ports that are wired,
+// disconnecting any that are no longer supervised and making any that are missing
+func (s *PortSupervisor) SetConnections(connections ...PortConnection) int {
+	s.mutex.Lock()
+	supervised := map[PortConnection]bool{}
+	for _, conn := range connections {
+		supervised[conn] = true
+	}
+	for _, conn := range s.Connections {
+		if supervised[conn] || s.JackClient == nil || !s.isConnected(conn) {
+			continue
+		}
+		if code := s.JackClient.Disconnect(conn.Src, conn.Dest); code != 0 {
+			log.Error(jack.StrError(code), "Unable to disconnect JACK ports", "src", conn.Src, "dest", conn.Dest)
+			continue
+		}
+		log.Info("Disconnected JACK ports", "src", conn.Src, "dest", conn.Dest)
+	}
+	s.Connections = connections
+	s.mutex.Unlock()
+	return s.Reconnect()
+}
+
+// isConnected checks if the ports of a connection are connected to each other; callers must hold the lock
+func (s *PortSupervisor) isConnected(conn PortConnection) bool {
+	for _, name := range s.JackClient.GetConnections(conn.Src) {
+		if name == conn.Dest {
+			return true
+		}
+	}
+	return false
+}
+
+// isSupervisedPort checks if a port is part of any supervised connection; callers must hold the lock
+func (s *PortSupervisor) isSupervisedPort(name string) bool {
+	for _, conn := range s.Connections {
+		if conn.Src == name || conn.Dest == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Reconnect makes every supervised connection whose ports exist but are not connected, returning the number made
+func (s *PortSupervisor) Reconnect() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.JackClient == nil {
+		return 0
+	}
+	count := 0
+	for _, conn := range s.Connections {
+		if !s.JackClient.HasPort(conn.Src) || !s.JackClient.HasPort(conn.Dest) {
+			continue
+		}
+		if s.isConnected(conn) {
+			continue
+		}
+		if code := s.JackClient.Connect(conn.Src, conn.Dest); code != 0 {
+			log.Error(jack.StrError(code), "Unable to reconnect JACK ports", "src", conn.Src, "dest", conn.Dest)
+			continue
+		}
+		log.Info("Reconnected JACK ports", "src", conn.Src, "dest", conn.Dest)
+		count++
+	}
+	return count
+}
+
+// Run re-wires supervised connections whenever one of their ports is registered, until the context is cancelled
+func (s *PortSupervisor) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	log.Info("Starting port supervisor", "name", s.Name)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping port supervisor", "name", s.Name)
+			s.Close()
+			return
+		case portID := <-s.registrations:
+			s.mutex.Lock()
+			name := ""
+			if s.JackClient != nil {
+				name = s.JackClient.GetPortName(portID)
+			}
+			supervised := s.isSupervisedPort(name)
+			s.mutex.Unlock()
+			if supervised {
+				s.Reconnect()
+			}
+		}
+	}
+}
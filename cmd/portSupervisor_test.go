@@ -0,0 +1,102 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xthexder/go-jack"
+)
+
+func TestPortSupervisor(t *testing.T) {
+	assert := assert.New(t)
+	graph := NewFakeJackGraph("recorder-supervisor")
+	graph.RegisterPort("jacktrip:send_1", jack.PortIsOutput)
+	graph.RegisterPort("jacktrip:send_2", jack.PortIsOutput)
+	graph.RegisterPort("recorder:in_1", jack.PortIsInput)
+	graph.RegisterPort("recorder:in_2", jack.PortIsInput)
+	graph.RegisterPort("other:out_1", jack.PortIsOutput)
+
+	s := NewPortSupervisor("recorder-supervisor",
+		PortConnection{Src: "jacktrip:send_1", Dest: "recorder:in_1"},
+		PortConnection{Src: "jacktrip:send_2", Dest: "recorder:in_2"},
+	)
+	assert.Equal(0, s.Reconnect())
+	s.JackClient = graph
+	graph.SetRegistrationCallback(s.handlePortRegistration)
+
+	// Existing ports should be connected once
+	assert.Equal(2, s.Reconnect())
+	assert.Equal(0, s.Reconnect())
+	assert.Equal([]string{"recorder:in_1"}, graph.GetConnections("jacktrip:send_1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go s.Run(ctx, &wg)
+
+	// Restarting JackTrip should re-wire the recorder inputs
+	graph.UnregisterClient("jacktrip")
+	assert.Empty(graph.GetConnections("recorder:in_1"))
+	graph.RegisterPort("other:out_2", jack.PortIsOutput)
+	graph.RegisterPort("jacktrip:send_1", jack.PortIsOutput)
+	graph.RegisterPort("jacktrip:send_2", jack.PortIsOutput)
+	assert.Eventually(func() bool {
+		return len(graph.GetConnections("recorder:in_1")) == 1 && len(graph.GetConnections("recorder:in_2")) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(graph.GetConnections("other:out_2"))
+
+	cancel()
+	wg.Wait()
+	assert.Nil(s.JackClient)
+}
+
+func TestIsSupervisedPort(t *testing.T) {
+	assert := assert.New(t)
+	s := NewPortSupervisor("supervisor", PortConnection{Src: "a:out", Dest: "b:in"})
+	assert.True(s.isSupervisedPort("a:out"))
+	assert.True(s.isSupervisedPort("b:in"))
+	assert.False(s.isSupervisedPort("c:in"))
+	assert.False(s.isSupervisedPort(""))
+}
+
+func TestPortSupervisorSetConnections(t *testing.T) {
+	assert := assert.New(t)
+	graph := NewFakeJackGraph("supervisor")
+	graph.RegisterPort("SuperCollider:out_1", jack.PortIsOutput)
+	graph.RegisterPort("SuperCollider:broadcast_1", jack.PortIsOutput)
+	graph.RegisterPort("recorder:in_1", jack.PortIsInput)
+
+	s := NewPortSupervisor("supervisor", PortConnection{Src: "SuperCollider:out_1", Dest: "recorder:in_1"})
+	s.JackClient = graph
+	assert.Equal(1, s.Reconnect())
+
+	// Switching to the broadcast mix should move the recorder input to the broadcast port
+	assert.Equal(1, s.SetConnections(PortConnection{Src: "SuperCollider:broadcast_1", Dest: "recorder:in_1"}))
+	assert.Equal([]string{"SuperCollider:broadcast_1"}, graph.GetConnections("recorder:in_1"))
+	assert.True(s.isSupervisedPort("SuperCollider:broadcast_1"))
+	assert.False(s.isSupervisedPort("SuperCollider:out_1"))
+
+	// Removing all connections should leave nothing wired
+	assert.Equal(0, s.SetConnections())
+	assert.Empty(graph.GetConnections("recorder:in_1"))
+}
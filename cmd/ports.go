@@ -0,0 +1,141 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ProcDir is the mount point of the proc filesystem, used to find the processes holding ports
+var ProcDir = "/proc"
+
+// PortConflict is returned when a port needed by the agent or its services is held by another process
+type PortConflict struct {
+	// "tcp" or "udp"
+	Network string
+
+	// port number that is in use
+	Port int
+
+	// process holding the port (ie. "jacktrip (pid 123)"), if it could be found
+	Owner string
+}
+
+// Error describes the port conflict
+func (c *PortConflict) Error() string {
+	if c.Owner == "" {
+		return fmt.Sprintf("%s port %d is in use", c.Network, c.Port)
+	}
+	return fmt.Sprintf("%s port %d is in use by %s", c.Network, c.Port, c.Owner)
+}
+
+// getListenPort returns the port of a listen address (ie. ":443"), or 0 if it has none
+func getListenPort(address string) int {
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return port
+}
+
+// checkPortAvailable returns a PortConflict if a TCP or UDP port can't be bound because another process holds it
+func checkPortAvailable(network string, port int) error {
+	address := fmt.Sprintf(":%d", port)
+	var err error
+	switch network {
+	case "tcp":
+		var l net.Listener
+		if l, err = net.Listen(network, address); err == nil {
+			l.Close()
+		}
+	case "udp":
+		var c net.PacketConn
+		if c, err = net.ListenPacket(network, address); err == nil {
+			c.Close()
+		}
+	default:
+		return fmt.Errorf("unsupported network %q", network)
+	}
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return &PortConflict{Network: network, Port: port, Owner: findPortOwner(network, port)}
+	}
+	return err
+}
+
+// getSocketInodes returns the inodes of sockets bound to a local port, from /proc/net/{tcp,udp}{,6}
+func getSocketInodes(network string, port int) map[string]bool {
+	inodes := map[string]bool{}
+	suffix := fmt.Sprintf(":%04X", port)
+	for _, name := range []string{network, network + "6"} {
+		f, err := os.Open(filepath.Join(ProcDir, "net", name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // skip header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 9 && strings.HasSuffix(fields[1], suffix) && fields[9] != "0" {
+				inodes[fields[9]] = true
+			}
+		}
+		f.Close()
+	}
+	return inodes
+}
+
+// findPortOwner returns the name and pid of a process holding a port, or an empty string if it can't be found
+func findPortOwner(network string, port int) string {
+	inodes := getSocketInodes(network, port)
+	if len(inodes) == 0 {
+		return ""
+	}
+	procs, err := ioutil.ReadDir(ProcDir)
+	if err != nil {
+		return ""
+	}
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(ProcDir, proc.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			if inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+				comm, _ := ioutil.ReadFile(filepath.Join(ProcDir, proc.Name(), "comm"))
+				return fmt.Sprintf("%s (pid %d)", strings.TrimSpace(string(comm)), pid)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPortConflictError(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("udp port 4464 is in use", (&PortConflict{Network: "udp", Port: 4464}).Error())
+	assert.Equal("tcp port 80 is in use by nginx (pid 12)", (&PortConflict{Network: "tcp", Port: 80, Owner: "nginx (pid 12)"}).Error())
+}
+
+func TestGetListenPort(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(443, getListenPort(":443"))
+	assert.Equal(8443, getListenPort("0.0.0.0:8443"))
+	assert.Equal(0, getListenPort(""))
+	assert.Equal(0, getListenPort("443"))
+}
+
+func TestCheckPortAvailable(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for a UDP port held by this process
+	conn, err := net.ListenPacket("udp", ":0")
+	assert.NoError(err)
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	err = checkPortAvailable("udp", port)
+	conflict, ok := err.(*PortConflict)
+	assert.True(ok)
+	assert.Equal(port, conflict.Port)
+	if _, statErr := os.Stat(filepath.Join(ProcDir, "net", "udp")); statErr == nil {
+		assert.Contains(conflict.Owner, fmt.Sprintf("(pid %d)", os.Getpid()))
+	}
+
+	// Case for a port that was released
+	conn.Close()
+	assert.NoError(checkPortAvailable("udp", port))
+
+	// Case for a TCP port
+	l, err := net.Listen("tcp", ":0")
+	assert.NoError(err)
+	port = l.Addr().(*net.TCPAddr).Port
+	assert.IsType(&PortConflict{}, checkPortAvailable("tcp", port))
+	l.Close()
+	assert.NoError(checkPortAvailable("tcp", port))
+
+	assert.Error(checkPortAvailable("sctp", port))
+}
+
+func TestFindPortOwner(t *testing.T) {
+	assert := assert.New(t)
+	defer func(dir string) { ProcDir = dir }(ProcDir)
+	ProcDir = t.TempDir()
+
+	assert.NoError(os.MkdirAll(filepath.Join(ProcDir, "net"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(ProcDir, "net", "udp"), []byte(
+		"  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n"+
+			"  12: 00000000:1170 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 41235 2 0000000000000000 0\n"),
+		0644))
+	assert.NoError(os.MkdirAll(filepath.Join(ProcDir, "321", "fd"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(ProcDir, "321", "comm"), []byte("qjackctl\n"), 0644))
+	assert.NoError(os.Symlink("socket:[41235]", filepath.Join(ProcDir, "321", "fd", "7")))
+
+	assert.Equal("qjackctl (pid 321)", findPortOwner("udp", 4464))
+	assert.Equal("", findPortOwner("udp", 4465))
+	assert.Equal("", findPortOwner("tcp", 4464))
+}
@@ -0,0 +1,99 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// CapNetBindService is the Linux capability needed to listen on port 80
+	CapNetBindService = 10
+
+	// PrivilegedHelperPath is used to run the few commands that need root when the agent is unprivileged;
+	// the system image allows the agent user to run only those commands without a password
+	PrivilegedHelperPath = "/usr/bin/sudo"
+)
+
+// privilegedHelper is the command prefix used to run privileged commands, or empty when running as root
+var privilegedHelper []string
+
+// parseEffectiveCapabilities returns the effective capabilities from the contents of /proc/self/status
+func parseEffectiveCapabilities(status []byte) (uint64, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "CapEff:" {
+			return strconv.ParseUint(fields[1], 16, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("CapEff not found in process status")
+}
+
+// hasCapability returns true if a capability is included in a capability set
+func hasCapability(caps uint64, capability uint) bool {
+	return caps&(1<<capability) != 0
+}
+
+// checkPrivileges verifies that the agent can run as the current user, and configures the privileged
+// helper when it is not root; only audio and dbus group rights are needed besides binding port 80
+func checkPrivileges(euid int) error {
+	if euid == 0 {
+		privilegedHelper = nil
+		return nil
+	}
+
+	status, err := ioutil.ReadFile(filepath.Join(ProcDir, "self", "status"))
+	if err != nil {
+		return err
+	}
+	caps, err := parseEffectiveCapabilities(status)
+	if err != nil {
+		return err
+	}
+	if !hasCapability(caps, CapNetBindService) {
+		return errors.New("jacktrip-agent must be run as root or with CAP_NET_BIND_SERVICE")
+	}
+
+	if _, err := os.Stat(PrivilegedHelperPath); err != nil {
+		log.Error(err, "Privileged helper is not available; firewall, tmpfs and data export commands will fail", "path", PrivilegedHelperPath)
+	}
+	privilegedHelper = []string{PrivilegedHelperPath, "-n"}
+	log.Info("Running without root", "euid", euid, "helper", PrivilegedHelperPath)
+	return nil
+}
+
+// runPrivileged runs a command that needs root, using the privileged helper when the agent is unprivileged
+func runPrivileged(name string, args ...string) ([]byte, error) {
+	if len(privilegedHelper) == 0 {
+		return systemRunner.Output(name, args...)
+	}
+	helperArgs := append([]string{}, privilegedHelper[1:]...)
+	helperArgs = append(helperArgs, name)
+	return systemRunner.Output(privilegedHelper[0], append(helperArgs, args...)...)
+}
@@ -0,0 +1,80 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEffectiveCapabilities(t *testing.T) {
+	assert := assert.New(t)
+	caps, err := parseEffectiveCapabilities([]byte("Name:\tjacktrip-agent\nCapInh:\t0000000000000000\nCapEff:\t0000000000000400\n"))
+	assert.NoError(err)
+	assert.True(hasCapability(caps, CapNetBindService))
+	assert.False(hasCapability(caps, 12))
+
+	_, err = parseEffectiveCapabilities([]byte("Name:\tjacktrip-agent\n"))
+	assert.Error(err)
+	_, err = parseEffectiveCapabilities([]byte("CapEff:\tzz\n"))
+	assert.Error(err)
+}
+
+func TestCheckPrivileges(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "proc")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	defer func(procDir string) { ProcDir = procDir }(ProcDir)
+	ProcDir = dir
+	defer func() { privilegedHelper = nil }()
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "self"), 0755))
+	statusPath := filepath.Join(dir, "self", "status")
+
+	// Case for root
+	assert.NoError(checkPrivileges(0))
+	assert.Empty(privilegedHelper)
+
+	// Case for an unprivileged user without capabilities
+	assert.NoError(ioutil.WriteFile(statusPath, []byte("CapEff:\t0000000000000000\n"), 0644))
+	assert.Error(checkPrivileges(1000))
+
+	// Case for an unprivileged user allowed to bind port 80
+	assert.NoError(ioutil.WriteFile(statusPath, []byte("CapEff:\t0000000000000400\n"), 0644))
+	assert.NoError(checkPrivileges(1000))
+	assert.Equal([]string{PrivilegedHelperPath, "-n"}, privilegedHelper)
+}
+
+func TestRunPrivileged(t *testing.T) {
+	assert := assert.New(t)
+	runner := NewFakeRunner()
+	defer func(prev SystemRunner) { systemRunner = prev }(systemRunner)
+	systemRunner = runner
+	defer func() { privilegedHelper = nil }()
+
+	_, err := runPrivileged(NFTPath, "-f", "rules.conf")
+	assert.NoError(err)
+	privilegedHelper = []string{PrivilegedHelperPath, "-n"}
+	_, err = runPrivileged(NFTPath, "-f", "rules.conf")
+	assert.NoError(err)
+	assert.Equal([]string{NFTPath + " -f rules.conf", PrivilegedHelperPath + " -n " + NFTPath + " -f rules.conf"}, runner.Commands)
+}
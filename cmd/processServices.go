@@ -0,0 +1,234 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ProcessStopTimeout is how long a child process may take to exit after it is interrupted, before it is killed
+	ProcessStopTimeout = 10 * time.Second
+
+	// ServiceRunning is the sub state reported for a service while its process is running
+	ServiceRunning = "running"
+
+	// ServiceDead is the sub state reported for a service after its process exits cleanly or is stopped
+	ServiceDead = "dead"
+)
+
+// processService describes how to run a managed service as a child process instead of a systemd unit
+type processService struct {
+	// name of the executable, found with the service manager's LookPath
+	Binary string
+
+	// path to the environment file written for the service's systemd unit
+	Config *string
+
+	// variable in the environment file containing the command line options
+	OptsVar string
+}
+
+// childServices are the managed services that can run as child processes, using the same config files as their units
+var childServices = map[string]processService{
+	JackServiceName:      {Binary: "jackd", Config: &PathToJackConfig, OptsVar: "JACK_OPTS"},
+	JackTripServiceName:  {Binary: "jacktrip", Config: &PathToJackTripConfig, OptsVar: "JACKTRIP_OPTS"},
+	JamulusServiceName:   {Binary: "Jamulus", Config: &PathToJamulusConfig, OptsVar: "JAMULUS_OPTS"},
+	MetronomeServiceName: {Binary: "jack_metro", Config: &PathToMetronomeConfig, OptsVar: "METRONOME_OPTS"},
+}
+
+// managedProcess is a running child process
+type managedProcess struct {
+	cmd      *exec.Cmd
+	done     chan struct{}
+	stopping bool
+}
+
+// ProcessServiceManager runs managed services as child processes of the agent, for hosts without systemd
+type ProcessServiceManager struct {
+	// LookPath returns the path of a service's executable
+	LookPath func(file string) (string, error)
+
+	services  map[string]processService
+	processes map[string]*managedProcess
+	watchers  []chan<- ServiceStateUpdate
+	mutex     sync.Mutex
+}
+
+// NewProcessServiceManager constructs a new instance of ProcessServiceManager
+func NewProcessServiceManager(services map[string]processService) *ProcessServiceManager {
+	return &ProcessServiceManager{
+		LookPath:  exec.LookPath,
+		services:  services,
+		processes: map[string]*managedProcess{},
+	}
+}
+
+// parseServiceOpts returns the command line options set by a variable in a systemd environment file
+func parseServiceOpts(content []byte, name string) []string {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		splits := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(splits) == 2 && splits[0] == name {
+			return strings.Fields(strings.Trim(splits[1], `"`))
+		}
+	}
+	return nil
+}
+
+// notify sends a service state update to all watchers; callers must hold the lock
+func (m *ProcessServiceManager) notify(name, subState string) {
+	for _, w := range m.watchers {
+		select {
+		case w <- ServiceStateUpdate{Name: name, SubState: subState}:
+		default:
+			log.Info("Dropped service state update", "name", name, "state", subState)
+		}
+	}
+}
+
+// Start starts the process of a service, using the options from its config file
+func (m *ProcessServiceManager) Start(name string) error {
+	service, ok := m.services[name]
+	if !ok {
+		return fmt.Errorf("failed to start %s: not supported without systemd", name)
+	}
+	path, err := m.LookPath(service.Binary)
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	content, err := ioutil.ReadFile(*service.Config)
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", name, err)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.processes[name]; ok {
+		return nil
+	}
+	cmd := exec.Command(path, parseServiceOpts(content, service.OptsVar)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	log.Info("Started managed process", "service", name, "pid", cmd.Process.Pid)
+
+	p := &managedProcess{cmd: cmd, done: make(chan struct{})}
+	m.processes[name] = p
+	m.notify(name, ServiceRunning)
+	go m.wait(name, p)
+	return nil
+}
+
+// wait reaps a process when it exits, and reports whether it failed
+func (m *ProcessServiceManager) wait(name string, p *managedProcess) {
+	err := p.cmd.Wait()
+	close(p.done)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.processes[name] == p {
+		delete(m.processes, name)
+	}
+	if err != nil && !p.stopping {
+		log.Error(err, "Managed process exited", "service", name)
+		m.notify(name, ServiceFailed)
+		return
+	}
+	m.notify(name, ServiceDead)
+}
+
+// Stop interrupts the processes of any of the services that are running, and waits for them to exit
+func (m *ProcessServiceManager) Stop(names ...string) error {
+	m.mutex.Lock()
+	var stopping []*managedProcess
+	for _, name := range names {
+		if p, ok := m.processes[name]; ok {
+			p.stopping = true
+			// NOTE: interrupts are not supported on Windows, where processes are killed instead
+			if err := p.cmd.Process.Signal(os.Interrupt); err != nil {
+				p.cmd.Process.Kill()
+			}
+			stopping = append(stopping, p)
+		}
+	}
+	m.mutex.Unlock()
+
+	for _, p := range stopping {
+		select {
+		case <-p.done:
+		case <-time.After(ProcessStopTimeout):
+			p.cmd.Process.Kill()
+			<-p.done
+		}
+	}
+	return nil
+}
+
+// Kill kills the process of a service
+func (m *ProcessServiceManager) Kill(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if p, ok := m.processes[name]; ok {
+		p.cmd.Process.Kill()
+	}
+}
+
+// Missing returns the services that are not supported, or whose executables are not installed
+func (m *ProcessServiceManager) Missing(names ...string) ([]string, error) {
+	var missing []string
+	for _, name := range names {
+		service, ok := m.services[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		if _, err := m.LookPath(service.Binary); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// Watch sends the sub state of services whenever their processes start or exit, until the context is cancelled
+func (m *ProcessServiceManager) Watch(ctx context.Context, updates chan<- ServiceStateUpdate) error {
+	m.mutex.Lock()
+	m.watchers = append(m.watchers, updates)
+	m.mutex.Unlock()
+	<-ctx.Done()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i, w := range m.watchers {
+		if w == updates {
+			m.watchers = append(m.watchers[:i], m.watchers[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseServiceOpts(t *testing.T) {
+	assert := assert.New(t)
+	content := []byte("# managed by jacktrip-agent\nJACK_OPTS=-d alsa -d hw:Device --rate 48000\nOTHER=1\n")
+	assert.Equal([]string{"-d", "alsa", "-d", "hw:Device", "--rate", "48000"}, parseServiceOpts(content, "JACK_OPTS"))
+	assert.Equal([]string{"-n", "1"}, parseServiceOpts([]byte(`METRONOME_OPTS="-n 1"`), "METRONOME_OPTS"))
+	assert.Nil(parseServiceOpts(content, "JACKTRIP_OPTS"))
+}
+
+func TestProcessServiceManager(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "services")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "sleep")
+	assert.NoError(ioutil.WriteFile(configPath, []byte("SLEEP_OPTS=30\n"), 0644))
+
+	m := NewProcessServiceManager(map[string]processService{
+		"sleep.service":   {Binary: "sleep", Config: &configPath, OptsVar: "SLEEP_OPTS"},
+		"missing.service": {Binary: "missing", Config: &configPath, OptsVar: "SLEEP_OPTS"},
+	})
+	m.LookPath = func(file string) (string, error) {
+		if file == "sleep" {
+			return exec.LookPath(file)
+		}
+		return "", errors.New("not found")
+	}
+	missing, err := m.Missing("sleep.service", "missing.service", "unknown.service")
+	assert.NoError(err)
+	assert.Equal([]string{"missing.service", "unknown.service"}, missing)
+	assert.Error(m.Start("missing.service"))
+	assert.Error(m.Start("unknown.service"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan ServiceStateUpdate, 4)
+	go m.Watch(ctx, updates)
+	assert.Eventually(func() bool {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		return len(m.watchers) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Case for starting and stopping a service
+	assert.NoError(m.Start("sleep.service"))
+	assert.NoError(m.Start("sleep.service"))
+	assert.Equal(ServiceStateUpdate{Name: "sleep.service", SubState: ServiceRunning}, <-updates)
+	assert.NoError(m.Stop("sleep.service", "missing.service"))
+	assert.Equal(ServiceStateUpdate{Name: "sleep.service", SubState: ServiceDead}, <-updates)
+	assert.Empty(m.processes)
+
+	// Case for a service that is killed
+	assert.NoError(m.Start("sleep.service"))
+	assert.Equal(ServiceStateUpdate{Name: "sleep.service", SubState: ServiceRunning}, <-updates)
+	m.Kill("sleep.service")
+	assert.Equal(ServiceStateUpdate{Name: "sleep.service", SubState: ServiceFailed}, <-updates)
+}
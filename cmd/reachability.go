@@ -0,0 +1,157 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// ReachabilityInterval is how often servers check that they can be reached from the internet
+	ReachabilityInterval = 30 * time.Minute
+
+	// ReachabilityTimeout is how long a server waits for the probe sent by the control plane
+	ReachabilityTimeout = 10 * time.Second
+
+	// ReachabilityGuidance explains how to fix a server that can't be reached from the internet
+	ReachabilityGuidance = "unreachable from internet: allow inbound UDP port %d in the firewall or security group, " +
+		"and forward it to this server if it is behind NAT"
+)
+
+// ReachabilityChecker confirms that the control plane can send UDP packets to the JackTrip port of a server,
+// so that unreachable servers are reported instead of silently failing to connect clients
+type ReachabilityChecker struct {
+	// APIClient is used to request probes
+	APIClient *api.Client
+
+	// ID of the server agent
+	ID string
+
+	// Timeout is how long to wait for a probe
+	Timeout time.Duration
+
+	last  *client.ReachabilityReport
+	mutex sync.Mutex
+}
+
+// NewReachabilityChecker constructs a new instance of ReachabilityChecker
+func NewReachabilityChecker(apiClient *api.Client, id string) *ReachabilityChecker {
+	return &ReachabilityChecker{APIClient: apiClient, ID: id, Timeout: ReachabilityTimeout}
+}
+
+// newProbeToken returns a random token used to recognize a probe
+func newProbeToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// waitForProbe reads packets until one contains the token, or the deadline passes
+func waitForProbe(conn net.PacketConn, token string, deadline time.Time) error {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(buf[:n], []byte(token)) {
+			return nil
+		}
+	}
+}
+
+// Check listens on a UDP port, asks the control plane to send a probe to it, and reports whether it arrived
+func (c *ReachabilityChecker) Check(ctx context.Context, port int) (report client.ReachabilityReport) {
+	report.Port = port
+	defer func() {
+		report.Timestamp = time.Now()
+		c.mutex.Lock()
+		c.last = &report
+		c.mutex.Unlock()
+	}()
+
+	token, err := newProbeToken()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		var conflict *PortConflict
+		if conflictErr := checkPortAvailable("udp", port); errors.As(conflictErr, &conflict) {
+			err = conflict
+		}
+		report.Error = err.Error()
+		return report
+	}
+	defer conn.Close()
+
+	if err := c.APIClient.RequestReachabilityProbe(ctx, c.ID, client.ReachabilityProbe{Port: port, Token: token}); err != nil {
+		report.Error = fmt.Sprintf("unable to request probe: %s", err.Error())
+		return report
+	}
+	if err := waitForProbe(conn, token, time.Now().Add(c.Timeout)); err != nil {
+		report.Error = fmt.Sprintf("no probe received on UDP port %d", port)
+		report.Guidance = fmt.Sprintf(ReachabilityGuidance, port)
+		log.Info("Server is unreachable from the internet", "port", port)
+		return report
+	}
+	report.Reachable = true
+	return report
+}
+
+// Last returns the result of the latest check, or nil if none finished
+func (c *ReachabilityChecker) Last() *client.ReachabilityReport {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.last
+}
+
+// Run checks reachability at startup and then periodically, until the context is cancelled
+func (c *ReachabilityChecker) Run(ctx context.Context, wg *sync.WaitGroup, port int) {
+	defer wg.Done()
+	log.Info("Starting reachability checks", "port", port)
+	ticker := time.NewTicker(ReachabilityInterval)
+	defer ticker.Stop()
+
+	for {
+		c.Check(ctx, port)
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping reachability checks")
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/apitest"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// getFreeUDPPort returns a UDP port that is not in use
+func getFreeUDPPort(t *testing.T) int {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestReachabilityChecker(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	server := apitest.NewServer(credentials)
+	defer server.Close()
+	c := NewReachabilityChecker(api.NewClient(server.URL, credentials, nil), "abc")
+	c.Timeout = 200 * time.Millisecond
+	assert.Nil(c.Last())
+
+	// Case for a reachable server
+	port := getFreeUDPPort(t)
+	report := c.Check(context.Background(), port)
+	assert.True(report.Reachable, report.Error)
+	assert.Equal(port, report.Port)
+	assert.Equal(report, *c.Last())
+
+	// Case for a firewall dropping probes
+	server.SetUnreachable(true)
+	report = c.Check(context.Background(), port)
+	assert.False(report.Reachable)
+	assert.Contains(report.Guidance, "unreachable from internet")
+
+	// Case for a port held by another process
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+	assert.NoError(err)
+	defer conn.Close()
+	report = c.Check(context.Background(), port)
+	assert.False(report.Reachable)
+	assert.Contains(report.Error, "in use")
+	assert.Empty(report.Guidance)
+}
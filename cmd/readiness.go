@@ -0,0 +1,113 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// ServiceReadyTimeout is the maximum time to wait for each readiness check, before giving up on starting services
+	ServiceReadyTimeout = time.Minute
+
+	// ServiceReadyInterval is the delay between attempts of a readiness check
+	ServiceReadyInterval = 250 * time.Millisecond
+
+	// ReadinessClientName is the name of the JACK client used to check that JACK is ready
+	ReadinessClientName = "jacktrip-readiness"
+)
+
+// ServiceNotReady is returned when a dependency of the managed services did not become ready in time
+type ServiceNotReady struct {
+	// what was being waited for (ie. "JACK audio ports")
+	Check string
+
+	// how long it was waited for
+	Timeout time.Duration
+
+	// the last error of the check, if any
+	Err error
+}
+
+// Error describes what was not ready
+func (e *ServiceNotReady) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("timed out after %s waiting for %s", e.Timeout, e.Check)
+	}
+	return fmt.Sprintf("timed out after %s waiting for %s: %s", e.Timeout, e.Check, e.Err.Error())
+}
+
+// waitForCheck runs a check until it succeeds, or returns a ServiceNotReady once the timeout has passed
+// NOTE: a single attempt may run past the timeout, ie. while jackd is starting
+func waitForCheck(name string, timeout, interval time.Duration, check func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return &ServiceNotReady{Check: name, Timeout: timeout, Err: err}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// usesAlsaCard returns true if JACK is configured to use the sound card of the device
+func usesAlsaCard(config client.DeviceAgentConfig) bool {
+	name := deviceState.SoundDevice().Name
+	return name != "" && name != "dummy" && name != DesktopSoundDeviceName && !isAES67Enabled(config)
+}
+
+// checkAlsaCard returns an error unless ALSA lists a sound card, ie. after its driver finished loading on boot
+func checkAlsaCard(name string) error {
+	out, err := alsaProvider.Cards()
+	if err != nil {
+		return err
+	}
+	if _, ok := extractCardNum(out)[name]; !ok {
+		return fmt.Errorf("sound card %s is not listed", name)
+	}
+	return nil
+}
+
+// waitForJack waits until jackd accepts clients and has registered its audio ports, so that services depending
+// on JACK don't start too early and fail in a loop
+func waitForJack(timeout, interval time.Duration) error {
+	var graph JackGraph
+	err := waitForCheck("jackd", timeout, interval, func() error {
+		var err error
+		graph, err = openJackGraph(ReadinessClientName, nil, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer graph.Close()
+
+	return waitForCheck("JACK audio ports", timeout, interval, func() error {
+		if len(graph.GetPorts(zitaPortToken, "", 0)) == 0 {
+			return errors.New("no capture or playback ports are registered")
+		}
+		return nil
+	})
+}
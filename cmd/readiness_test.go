@@ -0,0 +1,105 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xthexder/go-jack"
+)
+
+func TestServiceNotReadyError(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("timed out after 1m0s waiting for jackd", (&ServiceNotReady{Check: "jackd", Timeout: time.Minute}).Error())
+	assert.Equal("timed out after 1s waiting for jackd: refused", (&ServiceNotReady{Check: "jackd", Timeout: time.Second, Err: errors.New("refused")}).Error())
+}
+
+func TestWaitForCheck(t *testing.T) {
+	assert := assert.New(t)
+	attempts := 0
+	assert.NoError(waitForCheck("test", time.Second, time.Millisecond, func() error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	}))
+	assert.Equal(3, attempts)
+
+	err := waitForCheck("test", 10*time.Millisecond, time.Millisecond, func() error { return errors.New("never") })
+	notReady, ok := err.(*ServiceNotReady)
+	assert.True(ok)
+	assert.Equal("test", notReady.Check)
+	assert.EqualError(notReady.Err, "never")
+}
+
+func TestUsesAlsaCard(t *testing.T) {
+	assert := assert.New(t)
+	defer func(state *StateStore) { deviceState = state }(deviceState)
+	deviceState = NewStateStore()
+	config := client.DeviceAgentConfig{}
+
+	for _, name := range []string{"", "dummy", DesktopSoundDeviceName} {
+		deviceState.SetSoundDevice(SoundDevice{Name: name})
+		assert.False(usesAlsaCard(config), name)
+	}
+	deviceState.SetSoundDevice(SoundDevice{Name: "USB"})
+	assert.True(usesAlsaCard(config))
+}
+
+func TestCheckAlsaCard(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prev AlsaProvider) { alsaProvider = prev }(alsaProvider)
+	alsa := NewFakeAlsa()
+	alsaProvider = alsa
+
+	assert.Error(checkAlsaCard("USB"))
+	alsa.CardList = " 1 [USB            ]: USB-Audio - USB Audio Device\n"
+	assert.NoError(checkAlsaCard("USB"))
+	assert.Error(checkAlsaCard("Pro"))
+}
+
+func TestWaitForJack(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prev func(string, jack.PortRegistrationCallback, jack.ShutdownCallback) (JackGraph, error)) {
+		openJackGraph = prev
+	}(openJackGraph)
+
+	// jackd is not running
+	openJackGraph = func(string, jack.PortRegistrationCallback, jack.ShutdownCallback) (JackGraph, error) {
+		return nil, errors.New("unable to open client")
+	}
+	err := waitForJack(10*time.Millisecond, time.Millisecond)
+	assert.EqualError(err, "timed out after 10ms waiting for jackd: unable to open client")
+
+	// jackd is running without audio ports
+	graph := NewFakeJackGraph("jackd")
+	openJackGraph = func(string, jack.PortRegistrationCallback, jack.ShutdownCallback) (JackGraph, error) {
+		return graph, nil
+	}
+	err = waitForJack(10*time.Millisecond, time.Millisecond)
+	assert.IsType(&ServiceNotReady{}, err)
+	assert.Contains(err.Error(), "JACK audio ports")
+
+	graph.RegisterPort("system:playback_1", jack.PortIsInput)
+	assert.NoError(waitForJack(10*time.Millisecond, time.Millisecond))
+}
@@ -0,0 +1,175 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// RecorderServiceName is the name of the systemd service that records the studio mix and encodes HLS broadcasts
+	RecorderServiceName = "jacktrip-recorder.service"
+
+	// RecorderClientName is the JACK client name used by the recorder
+	RecorderClientName = "recorder"
+
+	// RecorderConfigTemplate is the template used to generate /tmp/default/recorder file on audio servers
+	RecorderConfigTemplate = "RECORDER_FORMAT=%s\nRECORDER_DIR=%s\nRECORDER_PRE_ROLL=%d\nRECORDER_CHANNELS=%d\n" +
+		"RECORDER_LAYOUT=%s\nHLS_DIR=%s\nHLS_VARIANTS=%s\n"
+)
+
+// getRecorderConfig returns the contents of the recorder service config file; RECORDER_PRE_ROLL is the number
+// of seconds of the mix the recorder keeps in memory and writes at the start of each recording, RECORDER_LAYOUT
+// is the ffmpeg channel layout of the mix, and HLS_VARIANTS is empty when the studio is only recorded, so that
+// the recorder skips the HLS pipeline
+func getRecorderConfig(config client.ServerAgentConfig, variants []common.HLSVariant) string {
+	var names []string
+	if client.IsHLSEnabled(config) {
+		for _, v := range variants {
+			names = append(names, fmt.Sprintf("%s:%d", v.Name, v.Bitrate))
+		}
+	}
+	layout := client.GetChannelLayout(config)
+	return fmt.Sprintf(RecorderConfigTemplate, client.GetRecordingFormat(config), PathToRecordings,
+		int(client.GetRecordingPreRoll(config).Seconds()), layout.Channels(), layout.FFmpegLayout(),
+		PathToHLS, strings.Join(names, ","))
+}
+
+// getRecorderConnections returns the connections from the mixer to the recorder's inputs
+func getRecorderConnections(config client.ServerAgentConfig) []PortConnection {
+	var connections []PortConnection
+	for i, src := range getMixerOutputPorts(config) {
+		connections = append(connections, PortConnection{Src: src, Dest: fmt.Sprintf("%s:in_%d", RecorderClientName, i+1)})
+	}
+	return connections
+}
+
+// getJamulusRecorderConnections returns the connections that bridge the Jamulus server's mix into the
+// recorder's inputs, where JACK sums it with the mixer; a mono recorder takes both Jamulus channels
+func getJamulusRecorderConnections(config client.ServerAgentConfig) []PortConnection {
+	if !client.IsJamulusRecordingEnabled(config) {
+		return nil
+	}
+	right := fmt.Sprintf("%s:in_1", RecorderClientName)
+	if client.GetChannelLayout(config).Channels() > 1 {
+		right = fmt.Sprintf("%s:in_2", RecorderClientName)
+	}
+	return []PortConnection{
+		{Src: jamulusOutputLeft, Dest: fmt.Sprintf("%s:in_1", RecorderClientName)},
+		{Src: jamulusOutputRight, Dest: right},
+	}
+}
+
+// isRecorderServiceEnabled checks if the recorder service should run for a config; direct multitrack capture
+// replaces it
+func isRecorderServiceEnabled(config client.ServerAgentConfig) bool {
+	return client.IsRecorderEnabled(config) && client.GetRecorderMode(config) == client.RecorderInProcess
+}
+
+// ServerRecorder manages the recorder service, shedding HLS variants when it uses more than its CPU budget
+type ServerRecorder struct {
+	// Budget tracks which HLS variants fit within the recorder's CPU quota
+	Budget *common.RecorderBudget
+
+	// writeDropIn writes the systemd drop-in with the recorder's CPU settings
+	writeDropIn func(serviceName string, cpuQuota int) error
+
+	config   client.ServerAgentConfig
+	cpuQuota int
+	running  bool
+	mutex    sync.Mutex
+}
+
+// NewServerRecorder constructs a new instance of ServerRecorder
+func NewServerRecorder() *ServerRecorder {
+	return &ServerRecorder{
+		Budget:      common.NewRecorderBudget(common.DefaultHLSVariants),
+		writeDropIn: common.WriteRecorderDropIn,
+		cpuQuota:    -1,
+	}
+}
+
+// Apply starts, restarts or stops the recorder service for a config
+func (r *ServerRecorder) Apply(config client.ServerAgentConfig) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.config != config {
+		// a new config may fit all variants again
+		r.Budget.Reset()
+		r.config = config
+	}
+
+	if !isRecorderServiceEnabled(config) {
+		if !r.running {
+			return nil
+		}
+		log.Info("Stopping recorder")
+		r.running = false
+		return serviceManager.Stop(RecorderServiceName)
+	}
+
+	if config.RecorderCPUQuota != r.cpuQuota {
+		if err := r.writeDropIn(RecorderServiceName, config.RecorderCPUQuota); err != nil {
+			return err
+		}
+		if err := reloadSystemd(); err != nil {
+			return err
+		}
+		r.cpuQuota = config.RecorderCPUQuota
+		r.running = false
+	}
+	return r.update()
+}
+
+// Shed stops encoding an HLS variant, restarting the recorder without it
+func (r *ServerRecorder) Shed(variant common.HLSVariant) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	log.Info("Recorder is over its CPU budget, shedding HLS variant", "variant", variant.Name, "bitrate", variant.Bitrate)
+	if !r.running {
+		return
+	}
+	if err := r.update(); err != nil {
+		log.Error(err, "Unable to restart recorder")
+	}
+}
+
+// update writes the recorder config, restarting the recorder if it changed or is not running; callers must hold the lock
+func (r *ServerRecorder) update() error {
+	changed, err := common.WriteFileIfChanged(PathToRecorderConfig, []byte(getRecorderConfig(r.config, r.Budget.Active())), 0644)
+	if err != nil {
+		return err
+	}
+	if !changed && r.running {
+		return nil
+	}
+	log.Info("Restarting recorder", "format", client.GetRecordingFormat(r.config), "hls", client.IsHLSEnabled(r.config))
+	if err := serviceManager.Stop(RecorderServiceName); err != nil {
+		return err
+	}
+	if err := serviceManager.Start(RecorderServiceName); err != nil {
+		return err
+	}
+	r.running = true
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRecorderConfig(t *testing.T) {
+	assert := assert.New(t)
+	variants := []common.HLSVariant{{Name: "high", Bitrate: 256}, {Name: "low", Bitrate: 64}}
+
+	config := client.ServerAgentConfig{Broadcast: client.BroadcastPublicWOStemWOVideo}
+	assert.Contains(getRecorderConfig(config, variants), "HLS_VARIANTS=high:256,low:64\n")
+	assert.True(isRecorderServiceEnabled(config))
+
+	// Case for a private recording, which skips the HLS pipeline
+	config.Broadcast = client.PrivateRecordWOStemWOVideo
+	assert.Contains(getRecorderConfig(config, variants), "HLS_VARIANTS=\n")
+
+	// Case for an ambisonic mix, which has no speaker positions
+	config.ChannelLayout = client.LayoutAmbisonics1
+	assert.Contains(getRecorderConfig(config, variants), "RECORDER_CHANNELS=4\nRECORDER_LAYOUT=4c\n")
+	assert.Len(getRecorderConnections(config), 4)
+
+	// Case for direct multitrack capture, which replaces the recorder service
+	config.RecorderMode = client.RecorderDirectCapture
+	assert.False(isRecorderServiceEnabled(config))
+}
+
+func TestGetJamulusRecorderConnections(t *testing.T) {
+	assert := assert.New(t)
+	config := client.ServerAgentConfig{Broadcast: client.BroadcastPublicWOStemWOVideo}
+	config.Type = client.JackTrip
+	assert.Empty(getJamulusRecorderConnections(config))
+
+	// Case for a hybrid studio, which records both Jamulus channels
+	config.Type = client.JackTripJamulus
+	assert.Equal([]PortConnection{
+		{Src: jamulusOutputLeft, Dest: RecorderClientName + ":in_1"},
+		{Src: jamulusOutputRight, Dest: RecorderClientName + ":in_2"},
+	}, getJamulusRecorderConnections(config))
+
+	// Case for a mono mix, which folds both Jamulus channels into one input
+	config.ChannelLayout = client.LayoutMono
+	assert.Equal(RecorderClientName+":in_1", getJamulusRecorderConnections(config)[1].Dest)
+
+	// Case for a studio that is not recorded
+	config.Broadcast = client.Offline
+	assert.Empty(getJamulusRecorderConnections(config))
+}
+
+func TestServerRecorder(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prevServices ServiceManager, prevRunner SystemRunner, dir string) {
+		serviceManager, systemRunner, ServiceConfigDir = prevServices, prevRunner, dir
+		updatePaths()
+	}(serviceManager, systemRunner, ServiceConfigDir)
+	services := NewFakeServiceManager()
+	serviceManager = services
+	runner := NewFakeRunner()
+	systemRunner = runner
+	ServiceConfigDir = t.TempDir()
+	updatePaths()
+
+	var quotas []int
+	recorder := NewServerRecorder()
+	recorder.writeDropIn = func(serviceName string, cpuQuota int) error {
+		quotas = append(quotas, cpuQuota)
+		return nil
+	}
+
+	// Case for the first config, which writes the drop-in and starts the recorder
+	config := client.ServerAgentConfig{Broadcast: client.BroadcastPublicWOStemWOVideo, RecorderCPUQuota: 150}
+	assert.Nil(recorder.Apply(config))
+	assert.Equal([]int{150}, quotas)
+	assert.Equal([]string{SystemctlPath + " daemon-reload"}, runner.Commands)
+	assert.Equal([]string{"start " + RecorderServiceName}, services.Events)
+	assert.FileExists(PathToRecorderConfig)
+
+	// Case for an unchanged config
+	services.Events = nil
+	assert.Nil(recorder.Apply(config))
+	assert.Empty(services.Events)
+
+	// Case for a new CPU quota, which restarts the recorder
+	config.RecorderCPUQuota = 100
+	assert.Nil(recorder.Apply(config))
+	assert.Equal([]int{150, 100}, quotas)
+	assert.Equal([]string{"stop " + RecorderServiceName, "start " + RecorderServiceName}, services.Events)
+
+	// Case for disabling the recorder
+	services.Events = nil
+	config.DisableRecorder = true
+	assert.Nil(recorder.Apply(config))
+	assert.Equal([]string{"stop " + RecorderServiceName}, services.Events)
+}
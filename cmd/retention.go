@@ -0,0 +1,206 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// RetentionInterval is how often retention policies are enforced
+	RetentionInterval = 10 * time.Minute
+
+	// RetentionMaxAgeReason is reported for recordings deleted because they are too old
+	RetentionMaxAgeReason = "maxAge"
+
+	// RetentionMaxSizeReason is reported for recordings deleted to stay within the size limit
+	RetentionMaxSizeReason = "maxSize"
+
+	// RetentionSessionEndReason is reported for recordings deleted when a session ends
+	RetentionSessionEndReason = "sessionEnd"
+)
+
+// recordingFile describes a single file found by the retention janitor
+type recordingFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// RecordingJanitor deletes recordings according to a studio's retention policies
+type RecordingJanitor struct {
+	// Directories containing recordings (ie. media and archive locations)
+	Dirs []string
+
+	mutex   sync.Mutex
+	config  client.RetentionConfig
+	deleted []client.DeletedRecording
+}
+
+// NewRecordingJanitor constructs a new instance of RecordingJanitor
+func NewRecordingJanitor(dirs ...string) *RecordingJanitor {
+	return &RecordingJanitor{Dirs: dirs}
+}
+
+// SetConfig updates the retention policies that are enforced
+func (j *RecordingJanitor) SetConfig(config client.RetentionConfig) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.config = config
+}
+
+// TakeDeleted returns all recordings deleted since the last call, for reporting in heartbeats
+func (j *RecordingJanitor) TakeDeleted() []client.DeletedRecording {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	deleted := j.deleted
+	j.deleted = nil
+	return deleted
+}
+
+// listFiles returns all files within the janitor's directories, oldest first
+func (j *RecordingJanitor) listFiles() ([]recordingFile, error) {
+	var files []recordingFile
+	for _, dir := range j.Dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.Mode().IsRegular() {
+				files = append(files, recordingFile{path: path, size: info.Size(), modTime: info.ModTime()})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(files, func(a, b int) bool { return files[a].modTime.Before(files[b].modTime) })
+	return files, nil
+}
+
+// remove deletes a recording file and records it for reporting
+func (j *RecordingJanitor) remove(f recordingFile, reason string) (client.DeletedRecording, error) {
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		return client.DeletedRecording{}, err
+	}
+	log.Info("Deleted recording", "path", f.path, "size", f.size, "reason", reason)
+	deleted := client.DeletedRecording{Path: f.path, Size: f.size, Reason: reason}
+	j.deleted = append(j.deleted, deleted)
+	return deleted, nil
+}
+
+// Sweep deletes recordings older than the max age, then the oldest recordings until the total size
+// is within the max size, returning the recordings that were deleted
+func (j *RecordingJanitor) Sweep(now time.Time) ([]client.DeletedRecording, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.config.RetentionMaxAge <= 0 && j.config.RetentionMaxSize <= 0 {
+		return nil, nil
+	}
+	files, err := j.listFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []client.DeletedRecording
+	var kept []recordingFile
+	var total int64
+	cutoff := now.Add(-time.Duration(j.config.RetentionMaxAge) * 24 * time.Hour)
+	for _, f := range files {
+		if j.config.RetentionMaxAge > 0 && f.modTime.Before(cutoff) {
+			deleted, err := j.remove(f, RetentionMaxAgeReason)
+			if err != nil {
+				return result, err
+			}
+			result = append(result, deleted)
+			continue
+		}
+		kept = append(kept, f)
+		total += f.size
+	}
+
+	if j.config.RetentionMaxSize > 0 {
+		maxSize := int64(j.config.RetentionMaxSize) * 1024 * 1024
+		for _, f := range kept {
+			if total <= maxSize {
+				break
+			}
+			deleted, err := j.remove(f, RetentionMaxSizeReason)
+			if err != nil {
+				return result, err
+			}
+			result = append(result, deleted)
+			total -= f.size
+		}
+	}
+	return result, nil
+}
+
+// SessionEnded deletes all recordings if the studio deletes them when a session ends
+func (j *RecordingJanitor) SessionEnded() ([]client.DeletedRecording, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if !j.config.DeleteOnSessionEnd {
+		return nil, nil
+	}
+	files, err := j.listFiles()
+	if err != nil {
+		return nil, err
+	}
+	var result []client.DeletedRecording
+	for _, f := range files {
+		deleted, err := j.remove(f, RetentionSessionEndReason)
+		if err != nil {
+			return result, err
+		}
+		result = append(result, deleted)
+	}
+	return result, nil
+}
+
+// Run enforces retention policies until the context is cancelled
+func (j *RecordingJanitor) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping recording janitor")
+			return
+		case <-ticker.C:
+			if _, err := j.Sweep(time.Now()); err != nil {
+				log.Error(err, "Failed to enforce recording retention")
+			}
+		}
+	}
+}
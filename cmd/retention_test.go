@@ -0,0 +1,100 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeRecording creates a file of the given size and age for retention tests
+func writeRecording(t *testing.T, path string, size int, modTime time.Time) {
+	assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.Nil(t, ioutil.WriteFile(path, make([]byte, size), 0644))
+	assert.Nil(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestRecordingJanitorSweep(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "retention")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	media, archive := filepath.Join(dir, "media"), filepath.Join(dir, "archive")
+	writeRecording(t, filepath.Join(media, "old.flac"), 100, now.Add(-72*time.Hour))
+	writeRecording(t, filepath.Join(archive, "session1", "mix.flac"), 600*1024, now.Add(-36*time.Hour))
+	writeRecording(t, filepath.Join(media, "new.flac"), 600*1024, now.Add(-time.Hour))
+
+	janitor := NewRecordingJanitor(media, archive, filepath.Join(dir, "missing"))
+
+	// Case for no policies
+	deleted, err := janitor.Sweep(now)
+	assert.Nil(err)
+	assert.Empty(deleted)
+
+	// Case for max age
+	janitor.SetConfig(client.RetentionConfig{RetentionMaxAge: 2})
+	deleted, err = janitor.Sweep(now)
+	assert.Nil(err)
+	assert.Equal([]client.DeletedRecording{
+		{Path: filepath.Join(media, "old.flac"), Size: 100, Reason: RetentionMaxAgeReason},
+	}, deleted)
+
+	// Case for max size, which removes the oldest recordings first
+	janitor.SetConfig(client.RetentionConfig{RetentionMaxSize: 1})
+	deleted, err = janitor.Sweep(now)
+	assert.Nil(err)
+	assert.Equal([]client.DeletedRecording{
+		{Path: filepath.Join(archive, "session1", "mix.flac"), Size: 600 * 1024, Reason: RetentionMaxSizeReason},
+	}, deleted)
+	_, err = os.Stat(filepath.Join(media, "new.flac"))
+	assert.Nil(err)
+
+	// Deletions are reported once
+	assert.Len(janitor.TakeDeleted(), 2)
+	assert.Empty(janitor.TakeDeleted())
+}
+
+func TestRecordingJanitorSessionEnded(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "retention")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	writeRecording(t, filepath.Join(dir, "mix.flac"), 10, time.Now())
+	janitor := NewRecordingJanitor(dir)
+
+	// Case for recordings that are kept after a session
+	deleted, err := janitor.SessionEnded()
+	assert.Nil(err)
+	assert.Empty(deleted)
+
+	janitor.SetConfig(client.RetentionConfig{DeleteOnSessionEnd: true})
+	deleted, err = janitor.SessionEnded()
+	assert.Nil(err)
+	assert.Equal([]client.DeletedRecording{
+		{Path: filepath.Join(dir, "mix.flac"), Size: 10, Reason: RetentionSessionEndReason},
+	}, deleted)
+}
@@ -0,0 +1,52 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+// getSampleRateStatus compares the JACK sample rate with the configured sample rate
+func getSampleRateStatus(actual, desired int) client.SampleRateStatus {
+	if actual == 0 {
+		return client.SampleRateUnknown
+	}
+	if actual != desired {
+		return client.SampleRateMismatch
+	}
+	return client.SampleRateOK
+}
+
+// verifySampleRate checks that JACK restarted at the configured sample rate, returning the rate and status to report
+// in heartbeats
+func verifySampleRate(ac *AutoConnector, config client.DeviceAgentConfig) (int, client.SampleRateStatus) {
+	actual := 0
+	ac.ClientLock.Lock()
+	if ac.JackClient != nil {
+		actual = int(ac.JackClient.GetSampleRate())
+	}
+	ac.ClientLock.Unlock()
+
+	status := getSampleRateStatus(actual, config.SampleRate)
+	if status != client.SampleRateOK {
+		log.Info("JACK is not running at the configured sample rate", "actual", actual, "desired", config.SampleRate)
+		return actual, status
+	}
+	log.Info("Verified JACK sample rate", "rate", actual)
+	return actual, status
+}
@@ -0,0 +1,56 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSampleRateStatus(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(client.SampleRateOK, getSampleRateStatus(48000, 48000))
+	assert.Equal(client.SampleRateMismatch, getSampleRateStatus(44100, 48000))
+	assert.Equal(client.SampleRateUnknown, getSampleRateStatus(0, 48000))
+}
+
+func TestSuspendDeviceMixingManager(t *testing.T) {
+	assert := assert.New(t)
+	dmm := DeviceMixingManager{
+		CurrentCaptureDevices:  map[string]bool{},
+		CurrentPlaybackDevices: map[string]bool{},
+		DeviceStream0Mapping:   map[string][]string{},
+		DeviceCardMapping:      map[string]int{"one": 1},
+	}
+	dmm.Suspend()
+	assert.True(dmm.isSuspended())
+	assert.Equal(0, len(dmm.DeviceCardMapping))
+
+	// Synchronizing should do nothing while suspended
+	config := client.DeviceAgentConfig{}
+	config.Enabled = true
+	config.Host = "a.b.com"
+	config.EnableUSB = true
+	dmm.SynchronizeConnections(config)
+	assert.Equal(0, len(dmm.DeviceCardMapping))
+
+	dmm.Resume()
+	assert.False(dmm.isSuspended())
+}
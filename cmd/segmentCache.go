@@ -0,0 +1,227 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// DefaultSegmentCacheBytes is the default memory used to cache HLS playlists and segments
+	DefaultSegmentCacheBytes = 64 << 20
+
+	// DefaultSegmentCacheEntryBytes is the default size of the largest file kept in memory; larger files are sent from disk
+	DefaultSegmentCacheEntryBytes = 4 << 20
+)
+
+// cachedSegment is an HLS file kept in memory, or a reference to one that is too large and is sent from disk
+type cachedSegment struct {
+	name    string
+	data    []byte
+	size    int64
+	modTime time.Time
+	onDisk  bool
+}
+
+// segmentRead is a read of an HLS file shared by all requests that arrive while it is in flight
+type segmentRead struct {
+	done    chan struct{}
+	segment *cachedSegment
+	err     error
+}
+
+// SegmentCache serves HLS files to many listeners from memory, so each file is read from disk once
+type SegmentCache struct {
+	Dir           string
+	MaxBytes      int64
+	MaxEntryBytes int64
+	entries       map[string]*list.Element
+	lru           *list.List
+	size          int64
+	inflight      map[string]*segmentRead
+	mutex         sync.Mutex
+}
+
+// NewSegmentCache constructs a new instance of SegmentCache
+func NewSegmentCache(dir string, maxBytes, maxEntryBytes int64) *SegmentCache {
+	return &SegmentCache{
+		Dir:           dir,
+		MaxBytes:      maxBytes,
+		MaxEntryBytes: maxEntryBytes,
+		entries:       map[string]*list.Element{},
+		lru:           list.New(),
+		inflight:      map[string]*segmentRead{},
+	}
+}
+
+// isSegmentFresh checks if a cached file is still current; segments never change, but playlists are rewritten in place
+func (c *SegmentCache) isSegmentFresh(segment *cachedSegment) bool {
+	if filepath.Ext(segment.name) != ".m3u8" {
+		return true
+	}
+	info, err := os.Stat(filepath.Join(c.Dir, segment.name))
+	return err == nil && info.ModTime().Equal(segment.modTime) && info.Size() == segment.size
+}
+
+// Get returns an HLS file, reading it from disk only if it is not cached and no other request is reading it
+func (c *SegmentCache) Get(name string) (*cachedSegment, error) {
+	c.mutex.Lock()
+	if elem, ok := c.entries[name]; ok {
+		segment := elem.Value.(*cachedSegment)
+		c.lru.MoveToFront(elem)
+		c.mutex.Unlock()
+		if c.isSegmentFresh(segment) {
+			return segment, nil
+		}
+		c.mutex.Lock()
+		if current, ok := c.entries[name]; ok && current == elem {
+			c.remove(elem)
+		}
+	}
+	if read, ok := c.inflight[name]; ok {
+		c.mutex.Unlock()
+		<-read.done
+		return read.segment, read.err
+	}
+	read := &segmentRead{done: make(chan struct{})}
+	c.inflight[name] = read
+	c.mutex.Unlock()
+
+	read.segment, read.err = c.read(name)
+
+	c.mutex.Lock()
+	delete(c.inflight, name)
+	if read.err == nil {
+		c.add(read.segment)
+	}
+	c.mutex.Unlock()
+	close(read.done)
+	return read.segment, read.err
+}
+
+// read loads an HLS file from disk
+func (c *SegmentCache) read(name string) (*cachedSegment, error) {
+	path := filepath.Join(c.Dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	segment := &cachedSegment{name: name, size: info.Size(), modTime: info.ModTime()}
+	if info.Size() > c.MaxEntryBytes {
+		segment.onDisk = true
+		return segment, nil
+	}
+	segment.data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	segment.size = int64(len(segment.data))
+	return segment, nil
+}
+
+// add caches a file, evicting the least recently used files to stay within MaxBytes; callers must hold the lock
+func (c *SegmentCache) add(segment *cachedSegment) {
+	if elem, ok := c.entries[segment.name]; ok {
+		c.remove(elem)
+	}
+	c.entries[segment.name] = c.lru.PushFront(segment)
+	c.size += int64(len(segment.data))
+	for c.size > c.MaxBytes && c.lru.Len() > 1 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops a file from the cache; callers must hold the lock
+func (c *SegmentCache) remove(elem *list.Element) {
+	segment := c.lru.Remove(elem).(*cachedSegment)
+	delete(c.entries, segment.name)
+	c.size -= int64(len(segment.data))
+}
+
+// Size returns the number of bytes cached in memory
+func (c *SegmentCache) Size() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.size
+}
+
+// handleStreamRequest serves an HLS playlist or segment to a listener, adding the markers of the recording to playlists
+func handleStreamRequest(cache *SegmentCache, recording *ActiveRecording, config client.ServerAgentConfig, credentials client.AgentCredentials, w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["file"]
+	contentType := getHLSContentType(name)
+	if !client.IsHLSEnabled(config) || name == "" || filepath.Base(name) != name || contentType == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !setHLSCORSHeaders(config, w, r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if !isListenAuthorized(config, credentials, r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	segment, err := cache.Get(name)
+	if os.IsNotExist(err) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Error(err, "Unable to read HLS file", "name", name)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if contentType == "application/vnd.apple.mpegurl" {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+	if !segment.onDisk {
+		data := segment.data
+		if contentType == "application/vnd.apple.mpegurl" {
+			data = addPlaylistMarkers(data, recording.HLSTags())
+		}
+		if token := r.URL.Query().Get("token"); token != "" && contentType == "application/vnd.apple.mpegurl" {
+			// guests only have the token in the URL of the playlist, so it is passed on to the segments
+			data = addPlaylistToken(data, token)
+		}
+		http.ServeContent(w, r, name, segment.modTime, bytes.NewReader(data))
+		return
+	}
+
+	// large files are copied from disk, which uses sendfile when possible
+	f, err := os.Open(filepath.Join(cache.Dir, name))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	http.ServeContent(w, r, name, segment.modTime, f)
+}
@@ -0,0 +1,160 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentCacheGet(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	for _, name := range []string{"a.ts", "b.ts", "c.ts"} {
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name), make([]byte, 100), 0644))
+	}
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "big.ts"), make([]byte, 300), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "stream.m3u8"), []byte("#EXTM3U\n"), 0644))
+	cache := NewSegmentCache(dir, 250, 200)
+
+	// Case for concurrent reads sharing one cached file
+	var wg sync.WaitGroup
+	results := make([]*cachedSegment, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = cache.Get("a.ts")
+		}(i)
+	}
+	wg.Wait()
+	for _, segment := range results {
+		assert.Same(results[0], segment)
+	}
+	assert.Equal(int64(100), cache.Size())
+
+	// Case for evicting the least recently used files
+	_, err := cache.Get("b.ts")
+	assert.NoError(err)
+	_, err = cache.Get("a.ts")
+	assert.NoError(err)
+	_, err = cache.Get("c.ts")
+	assert.NoError(err)
+	assert.Equal(int64(200), cache.Size())
+	assert.Contains(cache.entries, "a.ts")
+	assert.NotContains(cache.entries, "b.ts")
+
+	// Case for large files, which are sent from disk
+	segment, err := cache.Get("big.ts")
+	assert.NoError(err)
+	assert.True(segment.onDisk)
+	assert.Nil(segment.data)
+	assert.Equal(int64(200), cache.Size())
+
+	// Case for playlists, which are reread when they change
+	segment, err = cache.Get("stream.m3u8")
+	assert.NoError(err)
+	assert.Equal("#EXTM3U\n", string(segment.data))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "stream.m3u8"), []byte("#EXTM3U\n#EXT-X-VERSION:3\n"), 0644))
+	assert.NoError(os.Chtimes(filepath.Join(dir, "stream.m3u8"), time.Now(), time.Now().Add(time.Second)))
+	segment, err = cache.Get("stream.m3u8")
+	assert.NoError(err)
+	assert.Equal("#EXTM3U\n#EXT-X-VERSION:3\n", string(segment.data))
+
+	// Case for missing files
+	_, err = cache.Get("missing.ts")
+	assert.True(os.IsNotExist(err))
+}
+
+func TestHandleStreamRequest(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "stream.m3u8"), []byte("#EXTM3U\n"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "segment0.ts"), []byte("0123456789"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "segment1.ts"), make([]byte, 2000), 0644))
+	cache := NewSegmentCache(dir, DefaultSegmentCacheBytes, 1000)
+	config := client.ServerAgentConfig{}
+	config.Broadcast = client.BroadcastPublicWOStemWOVideo
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+
+	serve := func(file, origin, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com/stream/"+file, nil)
+		req = mux.SetURLVars(req, map[string]string{"file": file})
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp := httptest.NewRecorder()
+		handleStreamRequest(cache, &ActiveRecording{}, config, credentials, resp, req)
+		return resp
+	}
+
+	// Case for playlists
+	resp := serve("stream.m3u8", "https://app.jacktrip.org", "")
+	assert.Equal(200, resp.Code)
+	assert.Equal("application/vnd.apple.mpegurl", resp.Header().Get("Content-Type"))
+	assert.Equal("no-cache", resp.Header().Get("Cache-Control"))
+	assert.Equal("https://app.jacktrip.org", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal("#EXTM3U\n", resp.Body.String())
+
+	// Case for segments, including range requests
+	resp = serve("segment0.ts", "", "bytes=2-4")
+	assert.Equal(206, resp.Code)
+	assert.Equal("234", resp.Body.String())
+	resp = serve("segment1.ts", "", "")
+	assert.Equal(200, resp.Code)
+	assert.Equal(2000, resp.Body.Len())
+
+	// Case for origins that are not allowed
+	assert.Equal(403, serve("stream.m3u8", "https://other.example.com", "").Code)
+
+	// Case for missing or unsupported files
+	assert.Equal(404, serve("segment2.ts", "", "").Code)
+	assert.Equal(404, serve("secrets.txt", "", "").Code)
+	assert.Equal(404, serve("../stream.m3u8", "", "").Code)
+
+	// Case for unlisted broadcasts, which require a listen token
+	config.Broadcast = client.BroadcastUnlistedWOStemWOVideo
+	assert.Equal(401, serve("stream.m3u8", "", "").Code)
+	token, err := common.MintListenToken("secret", common.ListenClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	assert.NoError(err)
+	req := httptest.NewRequest("GET", "http://example.com/stream/stream.m3u8?token="+token, nil)
+	req = mux.SetURLVars(req, map[string]string{"file": "stream.m3u8"})
+	resp = httptest.NewRecorder()
+	handleStreamRequest(cache, &ActiveRecording{}, config, credentials, resp, req)
+	assert.Equal(200, resp.Code)
+	assert.Equal("#EXTM3U\n", resp.Body.String())
+
+	// Case for private recordings, which have no HLS stream
+	config.Broadcast = client.PrivateRecordWOStemWOVideo
+	resp = httptest.NewRecorder()
+	handleStreamRequest(cache, &ActiveRecording{}, config, credentials, resp, req)
+	assert.Equal(404, resp.Code)
+}
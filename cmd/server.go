@@ -0,0 +1,599 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// ServerHeartbeatInterval is the time between heartbeats sent by audio servers
+	ServerHeartbeatInterval = 5 * time.Second
+
+	// ServerListenAddress is the address of the agent's HTTP server on audio servers
+	ServerListenAddress = ":80"
+
+	// PortSupervisorClientName is the JACK client name used to keep the recorder and listen monitor wired to the mixer
+	PortSupervisorClientName = "supervisor"
+)
+
+// ServerAgent wires together the components that manage an audio server
+type ServerAgent struct {
+	// CloudID identifies the server in heartbeats
+	CloudID string
+
+	// Credentials used to authorize admin and listen requests
+	Credentials client.AgentCredentials
+
+	// APIClient is used to send heartbeats and receive configs
+	APIClient *api.Client
+
+	AutoConnector *AutoConnector
+	Roster        *common.ClientRoster
+	Recording     *ActiveRecording
+	Janitor       *RecordingJanitor
+	Capture       *MultitrackCapture
+	Supervisor    *PortSupervisor
+	Listen        *ListenMonitor
+	Segments      *SegmentCache
+	Relay         *HLSRelay
+	Webhooks      *common.WebhookNotifier
+	Reachability  *ReachabilityChecker
+	Presets       *MixPresetStore
+	Mixer         *SuperColliderMixer
+	Faust         *FaustMixer
+	Health        *MixerHealth
+	Recorder      *ServerRecorder
+	Drain         *DrainGate
+	Firewall      *ServerFirewall
+
+	// getMixErrors returns recent errors logged by the mixer services
+	getMixErrors func(serviceNames []string, maxLines int) ([]string, error)
+
+	configs    chan client.ServerAgentConfig
+	config     client.ServerAgentConfig
+	configured bool
+	applied    client.ServerAgentConfig
+	checking   bool
+	drained    bool
+	streaming  bool
+	stopRelay  context.CancelFunc
+	deleted    []client.DeletedRecording
+	events     []client.ClientEvent
+	cpu        common.CPUSampler
+	mutex      sync.RWMutex
+}
+
+// NewServerAgent constructs a new instance of ServerAgent
+func NewServerAgent(cloudID string, credentials client.AgentCredentials, apiClient *api.Client) *ServerAgent {
+	mixer := NewSuperColliderMixer(serverMixPresets)
+	recording := &ActiveRecording{}
+	relay := NewHLSRelay(PathToHLS, apiClient)
+	relay.Markers = recording.HLSTags
+	agent := &ServerAgent{
+		CloudID:       cloudID,
+		Credentials:   credentials,
+		APIClient:     apiClient,
+		AutoConnector: NewAutoConnector(),
+		Roster:        common.NewClientRoster(),
+		Recording:     recording,
+		Janitor:       NewRecordingJanitor(PathToRecordings),
+		Capture:       NewMultitrackCapture(PathToRecordings, client.RecordingFLAC),
+		Supervisor:    NewPortSupervisor(PortSupervisorClientName),
+		Listen:        NewListenMonitor(),
+		Segments:      NewSegmentCache(PathToHLS, DefaultSegmentCacheBytes, DefaultSegmentCacheEntryBytes),
+		Relay:         relay,
+		Webhooks:      common.NewWebhookNotifier(),
+		Reachability:  NewReachabilityChecker(apiClient, cloudID),
+		Presets:       serverMixPresets,
+		Mixer:         mixer,
+		Faust:         NewFaustMixer(),
+		Recorder:      NewServerRecorder(),
+		Drain:         &DrainGate{},
+		Firewall:      &ServerFirewall{},
+		getMixErrors:  common.GetServiceErrors,
+		configs:       make(chan client.ServerAgentConfig, 1),
+	}
+	agent.Health = NewMixerHealth(mixer.IsRunning, agent.restartMixer)
+	return agent
+}
+
+// runOnServer is used to run jacktrip-agent on an audio server
+func runOnServer(apiOrigin, cloudID string) {
+	log.Info("Running jacktrip-agent in server mode")
+
+	exit, stop := newSignalContext()
+	defer stop()
+
+	for _, dir := range []string{AgentLibDir, ServiceConfigDir, PathToRecordings, PathToHLS} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Error(err, "Unable to create directory", "path", dir)
+		}
+	}
+
+	if cloudID == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Error(err, "Unable to get hostname for cloud id")
+		}
+		cloudID = hostname
+	}
+	credentials := getCredentials()
+	agent := NewServerAgent(cloudID, credentials, api.NewClient(apiOrigin, credentials, nil))
+	if err := agent.Presets.Load(); err != nil {
+		log.Error(err, "Unable to load mix presets", "path", PathToMixPresets)
+	}
+	// the studio port is opened once the first config is applied
+	if err := agent.Firewall.Apply(client.ServerAgentConfig{}); err != nil {
+		log.Error(err, "Unable to apply managed firewall rules")
+	}
+
+	// setup cancellation context and wait group for multiple routines
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	router := agent.Router()
+	server := runHTTPServer(&wg, router, ServerListenAddress)
+	var tlsServer *http.Server
+	if *tlsAddressFlag != "" {
+		if certs, err := NewTLSCertificateManager(*tlsCertFlag, *tlsKeyFlag, time.Now()); err != nil {
+			log.Error(err, "Unable to load TLS certificate")
+		} else {
+			wg.Add(2)
+			go certs.Run(ctx, &wg)
+			tlsServer = runHTTPSServer(&wg, router, *tlsAddressFlag, certs.GetCertificate)
+		}
+	}
+	agent.Run(ctx, &wg)
+
+	// Wait for process exit signal, then terminate all goroutines
+	<-exit.Done()
+	shutdownHTTPServer(server)
+	if tlsServer != nil {
+		shutdownHTTPServer(tlsServer)
+	}
+	cancel()
+
+	// wait for everything to complete
+	if !waitForGoroutines(&wg, ShutdownTimeout) {
+		log.Info("Timed out waiting for goroutines to stop", "timeout", ShutdownTimeout.String())
+	}
+}
+
+// Config returns the latest server config
+func (a *ServerAgent) Config() client.ServerAgentConfig {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.config
+}
+
+// Router returns the routes served by the agent on audio servers
+func (a *ServerAgent) Router() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(compressionMiddleware)
+	// liveness and readiness probes are the only routes that never require credentials
+	router.HandleFunc("/ping", handlePingRequest).Methods("GET")
+	router.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		handleServerHealthzRequest(a, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		handleServerReadyzRequest(a, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/clients", func(w http.ResponseWriter, r *http.Request) {
+		if !isAuthorizedAdminRequest(a.Credentials, r) {
+			RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		handleClientsRequest(a.Roster, a.AutoConnector, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/admin", func(w http.ResponseWriter, r *http.Request) {
+		handleAdminRequest(a.Roster, a.AutoConnector, a.Credentials, w, r)
+	}).Methods("POST")
+	router.HandleFunc("/listen", func(w http.ResponseWriter, r *http.Request) {
+		handleListenRequest(a.Listen, a.Config(), a.Credentials, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/listen/tokens", func(w http.ResponseWriter, r *http.Request) {
+		handleListenTokenRequest(a.Credentials, w, r)
+	}).Methods("POST")
+	router.HandleFunc("/record/marker", func(w http.ResponseWriter, r *http.Request) {
+		handleRecordMarkerRequest(a.Recording, a.Credentials, w, r)
+	}).Methods("POST")
+	router.HandleFunc("/mix/presets", func(w http.ResponseWriter, r *http.Request) {
+		handleMixPresetsRequest(a.Presets, a.Credentials, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/mix/presets/{name}", func(w http.ResponseWriter, r *http.Request) {
+		handleMixPresetRequest(a.Presets, a.Config(), a.Credentials, w, r)
+	}).Methods("PUT", "DELETE", "POST")
+	router.HandleFunc("/commands", func(w http.ResponseWriter, r *http.Request) {
+		handleServerCommandRequest(a, w, r)
+	}).Methods("POST")
+	router.HandleFunc("/sessions/{name}/export", func(w http.ResponseWriter, r *http.Request) {
+		handleSessionExportRequest(PathToRecordings, a.Credentials, w, r)
+	}).Methods("GET")
+	router.HandleFunc("/stream/{file}", func(w http.ResponseWriter, r *http.Request) {
+		handleStreamRequest(a.Segments, a.Recording, a.Config(), a.Credentials, w, r)
+	}).Methods("GET")
+	router.PathPrefix("/").HandlerFunc(OptionsGetOnly).Methods("OPTIONS")
+	return router
+}
+
+// handleServerCommandRequest runs a command sent by the control plane to an audio server, which has no
+// websocket to receive commands over, and responds with its result
+func handleServerCommandRequest(a *ServerAgent, w http.ResponseWriter, r *http.Request) {
+	if !isAuthorizedAdminRequest(a.Credentials, r) {
+		RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	var command client.AgentCommand
+	if err := json.NewDecoder(r.Body).Decode(&command); err != nil {
+		RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid command"})
+		return
+	}
+	log.Info("Received command", "command", command.Command)
+	result := client.AgentCommandResult{Command: command.Command}
+	switch command.Command {
+	case PresetCommand:
+		result.Result = runMixPresetCommand(a.Presets, a.Config(), command.Preset)
+	case ExportCommand:
+		result.Result = a.runDataExport(r.Context(), command.UploadURL)
+	default:
+		RespondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("unknown command: %s", command.Command)})
+		return
+	}
+	RespondJSON(w, http.StatusOK, result)
+}
+
+// Run starts the background routines of the agent, which stop when the context is cancelled
+func (a *ServerAgent) Run(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(9)
+	go a.AutoConnector.Run(ctx, wg)
+	go a.Janitor.Run(ctx, wg)
+	go a.Capture.Run(ctx, wg)
+	go a.Webhooks.Run(ctx, wg, func(event common.WebhookEvent, err error) {
+		log.Error(err, "Failed to send webhook", "type", event.Type)
+	})
+	go a.Recorder.Budget.Run(ctx, wg, common.PathToRecorderCPUStat, a.Recorder.Shed)
+	go a.Health.Run(ctx, wg)
+	go a.runJackClients(ctx, wg)
+	go a.sendHeartbeats(ctx, wg)
+	go a.handleConfigs(ctx, wg)
+}
+
+// runJackClients opens the JACK clients of the port supervisor and the listen monitor once JACK is running
+func (a *ServerAgent) runJackClients(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if err := a.Supervisor.Open(); err != nil {
+		log.Error(err, "Unable to start port supervisor")
+		return
+	}
+	if err := a.Listen.Start(nil); err != nil {
+		log.Error(err, "Unable to start listen monitor")
+	}
+	a.Supervisor.SetConnections(getServerConnections(a.Config())...)
+	wg.Add(1)
+	a.Supervisor.Run(ctx, wg)
+	a.Listen.Stop()
+}
+
+// getServerConnections returns the connections from the mixer to the recorder and listen monitor for a config;
+// listeners get the first two channels of the mix, or its only channel on both sides for mono
+func getServerConnections(config client.ServerAgentConfig) []PortConnection {
+	connections := getRecorderConnections(config)
+	ports := getMixerOutputPorts(config)
+	for i := 0; i < ListenChannels; i++ {
+		src := ports[len(ports)-1]
+		if i < len(ports) {
+			src = ports[i]
+		}
+		connections = append(connections, PortConnection{Src: src, Dest: fmt.Sprintf("%s:in_%d", ListenClientName, i+1)})
+	}
+	return connections
+}
+
+// sendHeartbeats sends a heartbeat at startup and then periodically, queueing new configs for handleConfigs
+func (a *ServerAgent) sendHeartbeats(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	log.Info("Starting server heartbeats", "cloudId", a.CloudID)
+	ticker := time.NewTicker(ServerHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		a.updateRoster(time.Now())
+		beat := a.getHeartbeat()
+		a.updateDrain(beat)
+		config, err := a.APIClient.SendServerHeartbeat(ctx, beat)
+		if err != nil {
+			log.Error(err, "Failed to send server heartbeat")
+		} else {
+			a.clearReported(beat)
+			a.receiveConfig(config)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping server heartbeats")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// getHeartbeat returns the latest status of the server
+func (a *ServerAgent) getHeartbeat() client.ServerHeartbeat {
+	config := a.Config()
+	clients := a.Roster.Count()
+	beat := client.ServerHeartbeat{
+		CloudID:      a.CloudID,
+		MixCodeError: a.Mixer.Error() + a.Faust.Error(), // only the active mixing backend reports errors
+		Draining:     bool(config.Drain),
+		Reachability: a.Reachability.Last(),
+		Utilization:  &client.Utilization{ActiveClients: clients},
+	}
+	if beat.Draining {
+		beat.DrainRemaining = clients
+	}
+	beat.MixerStatus, beat.MixerRestarts = a.Health.Status()
+	if beat.MixCodeError != "" || (beat.MixerStatus != "" && beat.MixerStatus != client.MixerHealthy) {
+		// only scan the journal when something is wrong with the mixer
+		if lines, err := a.getMixErrors(superColliderServiceNames, MaxMixErrors); err == nil {
+			beat.MixErrors = lines
+		}
+	}
+	if cpu, err := a.cpu.Sample(); err == nil {
+		beat.Utilization.CPUPercent = cpu
+	}
+	if memory, err := common.GetMemoryPercent(); err == nil {
+		beat.Utilization.MemoryPercent = memory
+	}
+	a.AutoConnector.ClientLock.Lock()
+	if a.AutoConnector.JackClient != nil {
+		beat.Utilization.DSPLoad = a.AutoConnector.JackClient.GetCPULoad()
+	}
+	a.AutoConnector.ClientLock.Unlock()
+
+	// deleted recordings and client events are kept until a heartbeat reporting them is delivered
+	a.mutex.Lock()
+	a.deleted = append(a.deleted, a.Janitor.TakeDeleted()...)
+	beat.DeletedRecordings = append([]client.DeletedRecording{}, a.deleted...)
+	beat.ClientEvents = append([]client.ClientEvent{}, a.events...)
+	a.mutex.Unlock()
+	return beat
+}
+
+// clearReported forgets the deleted recordings and client events reported by a delivered heartbeat
+func (a *ServerAgent) clearReported(beat client.ServerHeartbeat) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.deleted = a.deleted[len(beat.DeletedRecordings):]
+	a.events = a.events[len(beat.ClientEvents):]
+}
+
+// updateDrain finishes draining the server once its last client has left, by applying the config again
+// to stop the recorder, which finalizes its files before the server is recycled
+func (a *ServerAgent) updateDrain(beat client.ServerHeartbeat) {
+	a.mutex.Lock()
+	complete := client.IsDrainComplete(beat)
+	changed := complete != a.drained
+	a.drained = complete
+	config := a.config
+	a.mutex.Unlock()
+	if !changed {
+		return
+	}
+	if complete {
+		log.Info("Drain complete, server is safe to recycle")
+	}
+	a.queueConfig(config)
+}
+
+// isDrained returns true if the server finished draining
+func (a *ServerAgent) isDrained() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.drained
+}
+
+// receiveConfig makes a new config visible to handlers, and queues it to be applied
+func (a *ServerAgent) receiveConfig(config client.ServerAgentConfig) {
+	a.mutex.Lock()
+	changed := !a.configured || a.config != config
+	a.config, a.configured = config, true
+	a.mutex.Unlock()
+	if changed {
+		a.queueConfig(config)
+	}
+}
+
+// queueConfig queues a config to be applied; only the latest config is kept if the previous one is still
+// being applied
+func (a *ServerAgent) queueConfig(config client.ServerAgentConfig) {
+	select {
+	case <-a.configs:
+	default:
+	}
+	a.configs <- config
+}
+
+// handleConfigs applies new configs until the context is cancelled
+func (a *ServerAgent) handleConfigs(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case config := <-a.configs:
+			a.applyConfig(ctx, wg, config)
+		}
+	}
+}
+
+// applyConfig updates all components of the server for a new config
+func (a *ServerAgent) applyConfig(ctx context.Context, wg *sync.WaitGroup, config client.ServerAgentConfig) {
+	log.Info("Applying server config", "type", config.Type, "broadcast", config.Broadcast, "recorderMode", client.GetRecorderMode(config))
+	a.Janitor.SetConfig(config.RetentionConfig)
+	a.Webhooks.SetConfig(config.WebhookConfig)
+	a.Capture.SetFormat(client.GetRecordingFormat(config))
+	// the mix doesn't depend on who may access the broadcast, so only the recorder is updated when
+	// that is all that changed
+	broadcastOnly := client.IsBroadcastOnlyChange(a.applied, config)
+	a.applied = config
+	if broadcastOnly {
+		log.Info("Only broadcast changed, keeping mixer running", "broadcast", config.Broadcast)
+	} else {
+		if err := a.Firewall.Apply(config); err != nil {
+			log.Error(err, "Unable to apply managed firewall rules", "port", config.Port)
+		}
+		if err := a.Drain.Apply(config); err != nil {
+			log.Error(err, "Unable to update connections while draining", "drain", config.Drain)
+		}
+		if err := a.Mixer.Apply(ctx, config); err != nil {
+			log.Error(err, "Unable to apply mixer config")
+			a.Webhooks.Notify(common.NewErrorWebhookEvent("mixer", err))
+		}
+		if err := a.Faust.Apply(config); err != nil {
+			log.Error(err, "Unable to apply Faust mixer config")
+			a.Webhooks.Notify(common.NewErrorWebhookEvent("mixer", err))
+		}
+	}
+	recorderConfig := config
+	if a.isDrained() {
+		recorderConfig.DisableRecorder = true
+	}
+	if err := a.Recorder.Apply(recorderConfig); err != nil {
+		log.Error(err, "Unable to apply recorder config")
+		a.Webhooks.Notify(common.NewErrorWebhookEvent("recorder", err))
+	}
+	if streaming := client.IsHLSEnabled(config); streaming != a.streaming {
+		a.streaming = streaming
+		a.Webhooks.Notify(common.NewBroadcastWebhookEvent(streaming, config.Broadcast))
+	}
+	connections := getServerConnections(config)
+	if jamulus := getJamulusRecorderConnections(config); len(jamulus) > 0 {
+		log.Info("Recording Jamulus mix", "type", config.Type)
+		connections = append(connections, jamulus...)
+	}
+	a.Supervisor.SetConnections(connections...)
+	a.updateHLSRelay(ctx, wg, config)
+
+	// check that clients can reach the JackTrip port, once it is known
+	a.mutex.Lock()
+	startChecks := config.Port > 0 && !a.checking
+	a.checking = a.checking || startChecks
+	a.mutex.Unlock()
+	if startChecks {
+		wg.Add(1)
+		go a.Reachability.Run(ctx, wg, config.Port)
+	}
+}
+
+// restartMixer restarts the mixer services after they stopped responding to health checks
+func (a *ServerAgent) restartMixer() error {
+	a.Webhooks.Notify(common.NewErrorWebhookEvent("mixer", errors.New("mixer stopped responding and is restarting")))
+	return a.Mixer.Restart()
+}
+
+// updateHLSRelay starts pushing HLS files to the API when a config relays the broadcast, and stops when it
+// no longer does; it is only called by handleConfigs
+func (a *ServerAgent) updateHLSRelay(ctx context.Context, wg *sync.WaitGroup, config client.ServerAgentConfig) {
+	enabled := isHLSRelayEnabled(config)
+	if enabled && a.stopRelay == nil {
+		log.Info("Starting HLS relay")
+		var relayCtx context.Context
+		relayCtx, a.stopRelay = context.WithCancel(ctx)
+		wg.Add(1)
+		go a.Relay.Run(relayCtx, wg)
+	} else if !enabled && a.stopRelay != nil {
+		a.stopRelay()
+		a.stopRelay = nil
+	}
+}
+
+// updateRoster updates the clients connected to the audio server from its JACK ports, starting and ending
+// sessions and multitrack captures as clients come and go
+func (a *ServerAgent) updateRoster(now time.Time) {
+	a.AutoConnector.ClientLock.Lock()
+	if a.AutoConnector.JackClient == nil {
+		a.AutoConnector.ClientLock.Unlock()
+		return
+	}
+	ports := a.AutoConnector.JackClient.GetPorts("", "", 0)
+	a.AutoConnector.ClientLock.Unlock()
+
+	before := a.Roster.Count()
+	events := a.Roster.Update(ports, now)
+	a.Webhooks.NotifyClientEvents(events)
+	a.mutex.Lock()
+	a.events = append(a.events, events...)
+	a.mutex.Unlock()
+	count := a.Roster.Count()
+	config := a.Config()
+	if before == 0 && count > 0 {
+		a.startSession(config, now)
+	} else if before > 0 && count == 0 {
+		a.endSession()
+	}
+
+	if client.IsRecorderEnabled(config) && client.GetRecorderMode(config) == client.RecorderDirectCapture {
+		for _, track := range a.Capture.Sync(a.Roster.Clients(), now) {
+			if err := a.Recording.AddTrack(track.Name, track.File, now); err != nil {
+				log.Error(err, "Unable to add track to session", "file", track.File)
+			}
+		}
+	} else if a.Capture.Capturing() > 0 {
+		a.Capture.StopAll()
+	}
+}
+
+// startSession starts tracking the recording of a session, when the first client joins
+func (a *ServerAgent) startSession(config client.ServerAgentConfig, now time.Time) {
+	name := now.UTC().Format("20060102T150405Z")
+	log.Info("Session started", "name", name)
+	a.Recording.Start(common.SessionManifest{Name: name, SampleRate: config.SampleRate, StartedAt: now})
+}
+
+// endSession finishes captured files and saves the manifest of a session, with its markers, and applies
+// retention policies once the last client leaves
+func (a *ServerAgent) endSession() {
+	a.Capture.StopAll()
+	if manifest, err := a.Recording.Stop(); err == nil {
+		log.Info("Session ended", "name", manifest.Name, "markers", len(manifest.Markers))
+		tagFLACMarkers(PathToRecordings, manifest)
+		if err := saveSessionManifest(PathToRecordings, manifest); err != nil {
+			log.Error(err, "Unable to save session manifest", "name", manifest.Name)
+		} else {
+			a.Webhooks.Notify(common.NewRecordingWebhookEvent(manifest))
+		}
+	}
+	if _, err := a.Janitor.SessionEnded(); err != nil {
+		log.Error(err, "Failed to delete recordings at the end of the session")
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+// errStudioInSession is returned when an export is requested while clients are connected to the studio
+var errStudioInSession = errors.New("clients are connected to the studio, so its recordings are still being written")
+
+// serverExportServiceNames are the systemd services whose logs are included in data exports of audio servers
+var serverExportServiceNames = []string{"jacktrip-agent.service", SCSynthServiceName, SupernovaServiceName, SCLangServiceName, FaustServiceName, RecorderServiceName}
+
+// getServerRecordingFiles returns the recordings, multitrack captures and session manifests stored in a directory
+func getServerRecordingFiles(dir string) []string {
+	var files []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
+
+// getServerExportLogs returns the journal entries of the services included in data exports of audio servers
+// NOTE: unlike devices, servers keep these logs in the system journal, so they are exported but not purged
+func getServerExportLogs() ([]byte, error) {
+	args := []string{"--no-pager", "--output", "short-iso"}
+	for _, name := range serverExportServiceNames {
+		args = append(args, "--unit", name)
+	}
+	return systemRunner.Output(common.JournalctlPath, args...)
+}
+
+// collectDataExport returns the data exported from an audio server, where studio recordings and session
+// manifests are owned by the agent; it fails during a session, since its recordings are still being written
+func (a *ServerAgent) collectDataExport() (DataExport, error) {
+	if a.Roster.Count() > 0 {
+		return DataExport{}, errStudioInSession
+	}
+	recordings := getServerRecordingFiles(PathToRecordings)
+	logs, err := getServerExportLogs()
+	if err != nil {
+		log.Error(err, "Failed to read service logs for data export")
+	}
+	return DataExport{
+		Files:         getExistingFiles(PathToMixPresets, PathToSuperColliderConfig, PathToSCLangStartup, PathToRecorderConfig, PathToFaustDSP),
+		RecordingsDir: PathToRecordings,
+		Recordings:    recordings,
+		Logs:          logs,
+		Owned:         recordings,
+	}, nil
+}
+
+// purgeRecordings deletes exported recordings and session manifests
+func purgeRecordings(files []string) error {
+	for _, path := range files {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// runDataExport exports the studio recordings, session manifests, service configs and logs of an audio server
+// to an upload target, and purges the recordings and manifests once the upload succeeds
+func (a *ServerAgent) runDataExport(ctx context.Context, uploadURL string) client.DataExportReport {
+	return runExport(ctx, uploadURL, a.collectDataExport, purgeRecordings)
+}
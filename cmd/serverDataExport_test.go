@@ -0,0 +1,82 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/xthexder/go-jack"
+)
+
+func TestServerDataExport(t *testing.T) {
+	assert := assert.New(t)
+	agent, _ := newTestServerAgent(t, nil)
+	runner := systemRunner.(*FakeRunner)
+	runner.Outputs["/usr/bin/journalctl --no-pager --output short-iso --unit jacktrip-agent.service --unit scsynth.service "+
+		"--unit supernova.service --unit sclang.service --unit faust.service --unit jacktrip-recorder.service"] = "agent started\n"
+	os.MkdirAll(filepath.Join(PathToRecordings, "20220501T200000Z"), 0755)
+	mix := filepath.Join(PathToRecordings, "20220501T200000Z.flac")
+	manifest := filepath.Join(PathToRecordings, "20220501T200000Z.json")
+	track := filepath.Join(PathToRecordings, "20220501T200000Z", "alice.flac")
+	for _, path := range []string{mix, manifest, track} {
+		ioutil.WriteFile(path, []byte("data"), 0644)
+	}
+	ioutil.WriteFile(PathToRecorderConfig, []byte("RECORDER_FORMAT=flac\n"), 0644)
+
+	var uploaded []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uploaded, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	// Case for a studio in session, which must not export recordings that are still being written
+	graph := NewFakeJackGraph("agent")
+	graph.RegisterPort("alice:receive_1", jack.PortIsOutput)
+	agent.Roster.Update(graph.GetPorts("", "", 0), time.Now())
+	report := agent.runDataExport(context.Background(), server.URL)
+	assert.Equal(errStudioInSession.Error(), report.Error)
+	assert.False(report.Uploaded)
+
+	// Case for an idle studio, which exports and purges its recordings and session manifests
+	agent.Roster = common.NewClientRoster()
+	report = agent.runDataExport(context.Background(), server.URL)
+	assert.Equal("", report.Error)
+	assert.True(report.Uploaded)
+	assert.True(report.Purged)
+	assert.Equal([]string{"configs/recorder", "recordings/20220501T200000Z/alice.flac", "recordings/20220501T200000Z.flac",
+		"recordings/20220501T200000Z.json", ExportLogsName}, report.Files)
+	zr, err := zip.NewReader(bytes.NewReader(uploaded), int64(len(uploaded)))
+	assert.Nil(err)
+	assert.Len(zr.File, 5)
+	for _, path := range []string{mix, manifest, track} {
+		_, err := os.Stat(path)
+		assert.True(os.IsNotExist(err), path)
+	}
+	assert.FileExists(PathToRecorderConfig)
+}
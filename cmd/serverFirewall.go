@@ -0,0 +1,70 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// JackTripUDPBasePort is the first UDP port a JackTrip hub server assigns to its clients, one port each
+	JackTripUDPBasePort = 61002
+
+	// JackTripUDPLastPort is the last UDP port opened for JackTrip clients
+	JackTripUDPLastPort = 61999
+)
+
+// getServerFirewallRules returns the ports an audio server needs to accept traffic on; the studio port is
+// only known once a config was received
+func getServerFirewallRules(config client.ServerAgentConfig) common.FirewallRules {
+	rules := common.FirewallRules{
+		TCPPorts:  []int{common.SSHPort},
+		UDPRanges: []common.PortRange{{First: JackTripUDPBasePort, Last: JackTripUDPLastPort}},
+		HTTPPorts: []int{getListenPort(ServerListenAddress)},
+	}
+	if port := getListenPort(*tlsAddressFlag); port > 0 {
+		rules.HTTPPorts = append(rules.HTTPPorts, port)
+	}
+	if config.Port > 0 {
+		// JackTrip clients connect over TCP before streaming, while Jamulus and reachability probes use UDP
+		rules.TCPPorts = append(rules.TCPPorts, config.Port)
+		rules.UDPPorts = append(rules.UDPPorts, config.Port)
+	}
+	return rules
+}
+
+// ServerFirewall applies the managed firewall rules of an audio server
+type ServerFirewall struct {
+	ruleset string
+}
+
+// Apply replaces the managed firewall rules when they change for a config; it is only called by runOnServer,
+// before the agent runs, and by handleConfigs
+func (f *ServerFirewall) Apply(config client.ServerAgentConfig) error {
+	ruleset := common.GetNFTablesRuleset(getServerFirewallRules(config))
+	if ruleset == f.ruleset {
+		return nil
+	}
+	log.Info("Applying managed firewall rules", "port", config.Port)
+	if _, err := systemRunner.OutputWithInput(ruleset, NFTPath, "-f", "-"); err != nil {
+		return err
+	}
+	f.ruleset = ruleset
+	return nil
+}
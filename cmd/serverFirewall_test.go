@@ -0,0 +1,68 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetServerFirewallRules(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for no config yet, which opens SSH, HTTP and the JackTrip client ports
+	config := client.ServerAgentConfig{}
+	rules := getServerFirewallRules(config)
+	assert.Equal([]int{common.SSHPort}, rules.TCPPorts)
+	assert.Empty(rules.UDPPorts)
+	assert.Equal([]common.PortRange{{First: JackTripUDPBasePort, Last: JackTripUDPLastPort}}, rules.UDPRanges)
+	assert.Equal([]int{80}, rules.HTTPPorts)
+
+	// Case for a studio port
+	config.Port = 4464
+	rules = getServerFirewallRules(config)
+	assert.Equal([]int{common.SSHPort, 4464}, rules.TCPPorts)
+	assert.Equal([]int{4464}, rules.UDPPorts)
+}
+
+func TestServerFirewall(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prev SystemRunner) { systemRunner = prev }(systemRunner)
+	runner := NewFakeRunner()
+	systemRunner = runner
+	firewall := &ServerFirewall{}
+
+	// Case for the rules applied at startup
+	assert.Nil(firewall.Apply(client.ServerAgentConfig{}))
+	assert.Equal([]string{NFTPath + " -f -"}, runner.Commands)
+	assert.Contains(runner.Inputs[0], "udp dport 61002-61999 accept")
+
+	// Case for unchanged rules, which are not applied again
+	assert.Nil(firewall.Apply(client.ServerAgentConfig{}))
+	assert.Len(runner.Commands, 1)
+
+	// Case for a studio port
+	config := client.ServerAgentConfig{}
+	config.Port = 4464
+	assert.Nil(firewall.Apply(config))
+	assert.Len(runner.Commands, 2)
+	assert.Contains(runner.Inputs[1], "udp dport { 4464 } accept")
+}
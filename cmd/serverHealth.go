@@ -0,0 +1,75 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+// ServerHealthResponse is returned by health and readiness probes on audio servers
+type ServerHealthResponse struct {
+	Status   string             `json:"status"`
+	Failures []string           `json:"failures"`
+	Mixer    client.MixerStatus `json:"mixer,omitempty"`
+}
+
+// getServerReadinessFailures returns the reasons an audio server is not ready to serve a studio
+func getServerReadinessFailures(a *ServerAgent) []string {
+	failures := []string{}
+	a.mutex.RLock()
+	configured, draining := a.configured, bool(a.config.Drain)
+	a.mutex.RUnlock()
+	if !configured {
+		return append(failures, "no config has been applied")
+	}
+	if draining {
+		failures = append(failures, "server is draining")
+	}
+	a.AutoConnector.ClientLock.Lock()
+	connected := a.AutoConnector.JackClient != nil
+	a.AutoConnector.ClientLock.Unlock()
+	if !connected {
+		failures = append(failures, "autoconnector is not connected to JACK")
+	}
+	// the mixer has no status while it is not expected to run
+	if status, _ := a.Health.Status(); status != "" && status != client.MixerHealthy {
+		failures = append(failures, fmt.Sprintf("mixer is %q", status))
+	}
+	return failures
+}
+
+// handleServerHealthzRequest reports that the agent is alive, along with the health of the mixer
+func handleServerHealthzRequest(a *ServerAgent, w http.ResponseWriter, r *http.Request) {
+	status, _ := a.Health.Status()
+	RespondJSON(w, http.StatusOK, ServerHealthResponse{Status: "ok", Failures: []string{}, Mixer: status})
+}
+
+// handleServerReadyzRequest reports whether the server has applied its config and is ready for clients
+func handleServerReadyzRequest(a *ServerAgent, w http.ResponseWriter, r *http.Request) {
+	status, _ := a.Health.Status()
+	resp := ServerHealthResponse{Status: "ok", Failures: getServerReadinessFailures(a), Mixer: status}
+	code := http.StatusOK
+	if len(resp.Failures) > 0 {
+		resp.Status = "unavailable"
+		code = http.StatusServiceUnavailable
+	}
+	RespondJSON(w, code, resp)
+}
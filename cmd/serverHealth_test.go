@@ -0,0 +1,75 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetServerReadinessFailures(t *testing.T) {
+	assert := assert.New(t)
+	agent, _ := newTestServerAgent(t, nil)
+
+	// Case for no config yet
+	assert.Equal([]string{"no config has been applied"}, getServerReadinessFailures(agent))
+
+	// Case for a draining server with an unresponsive mixer and no JACK client
+	config := client.ServerAgentConfig{Drain: true}
+	agent.receiveConfig(config)
+	agent.Health.status = client.MixerUnresponsive
+	assert.Equal([]string{"server is draining", "autoconnector is not connected to JACK", `mixer is "unresponsive"`},
+		getServerReadinessFailures(agent))
+
+	// Case for a server that is ready
+	agent.receiveConfig(client.ServerAgentConfig{})
+	agent.AutoConnector.JackClient = NewFakeJackGraph("agent")
+	agent.Health.status = client.MixerHealthy
+	assert.Equal([]string{}, getServerReadinessFailures(agent))
+}
+
+func TestServerHealthEndpoints(t *testing.T) {
+	assert := assert.New(t)
+	agent, _ := newTestServerAgent(t, nil)
+	router := agent.Router()
+
+	// Case for liveness, which does not depend on readiness
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(http.StatusOK, w.Code)
+
+	// Case for a server that has not applied a config yet
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+	var resp ServerHealthResponse
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal("unavailable", resp.Status)
+
+	// Case for a server that is ready
+	agent.receiveConfig(client.ServerAgentConfig{})
+	agent.AutoConnector.JackClient = NewFakeJackGraph("agent")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(http.StatusOK, w.Code)
+}
@@ -0,0 +1,376 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/apitest"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/xthexder/go-jack"
+)
+
+// newTestServerAgent returns a server agent that writes to temporary directories and uses fake services
+func newTestServerAgent(t *testing.T, apiClient *api.Client) (*ServerAgent, *FakeServiceManager) {
+	prevServices, prevRunner, prevLibDir, prevConfigDir := serviceManager, systemRunner, AgentLibDir, ServiceConfigDir
+	t.Cleanup(func() {
+		serviceManager, systemRunner, AgentLibDir, ServiceConfigDir = prevServices, prevRunner, prevLibDir, prevConfigDir
+		updatePaths()
+	})
+	services := NewFakeServiceManager()
+	serviceManager = services
+	systemRunner = NewFakeRunner()
+	AgentLibDir = t.TempDir()
+	ServiceConfigDir = t.TempDir()
+	updatePaths()
+
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	agent := NewServerAgent("abc", credentials, apiClient)
+	agent.Presets = &MixPresetStore{}
+	agent.Mixer.Presets = agent.Presets
+	agent.Mixer.Deployer.Test = func(code string, sampleRate int, limits common.SandboxLimits) error { return nil }
+	agent.Recorder.writeDropIn = func(serviceName string, cpuQuota int) error { return nil }
+	agent.Mixer.writeDropIn = func(serviceName string, limits common.SandboxLimits) error { return nil }
+	agent.Faust.writeDropIn = agent.Mixer.writeDropIn
+	agent.getMixErrors = func(serviceNames []string, maxLines int) ([]string, error) {
+		return []string{"ERROR: Message 'play' not understood."}, nil
+	}
+	return agent, services
+}
+
+// receiveWebhooks delivers the webhooks of a server agent to a test server until the context is cancelled,
+// returning the URL of the test server and a channel of the event types it received
+func receiveWebhooks(ctx context.Context, t *testing.T, wg *sync.WaitGroup, agent *ServerAgent) (string, <-chan common.WebhookEventType) {
+	events := make(chan common.WebhookEventType, common.WebhookQueueSize)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events <- common.WebhookEventType(r.Header.Get(common.WebhookEventHeader))
+	}))
+	t.Cleanup(ts.Close)
+	wg.Add(1)
+	go agent.Webhooks.Run(ctx, wg, nil)
+	return ts.URL, events
+}
+
+// nextWebhook returns the next event type received by receiveWebhooks, or an empty type if none arrives
+func nextWebhook(events <-chan common.WebhookEventType) common.WebhookEventType {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		return ""
+	}
+}
+
+func TestServerAgentRouter(t *testing.T) {
+	assert := assert.New(t)
+	agent, _ := newTestServerAgent(t, nil)
+	router := agent.Router()
+
+	// Case for an unauthenticated health check
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+	assert.Equal(http.StatusOK, w.Code)
+
+	// Case for a client that accepts compressed responses
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal("gzip", w.Header().Get("Content-Encoding"))
+
+	// Case for admin routes, which require credentials
+	for _, req := range []*http.Request{
+		httptest.NewRequest("GET", "/clients", nil),
+		httptest.NewRequest("POST", "/admin", nil),
+		httptest.NewRequest("POST", "/listen/tokens", nil),
+		httptest.NewRequest("POST", "/record/marker", nil),
+		httptest.NewRequest("GET", "/mix/presets", nil),
+		httptest.NewRequest("POST", "/commands", nil),
+		httptest.NewRequest("GET", "/sessions/20220501T200000Z/export", nil),
+	} {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(http.StatusUnauthorized, w.Code, req.URL.Path)
+	}
+
+	// Case for listing clients with credentials
+	req = httptest.NewRequest("GET", "/clients", nil)
+	req.Header.Set("APIPrefix", agent.Credentials.APIPrefix)
+	req.Header.Set("APISecret", agent.Credentials.APISecret)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(http.StatusOK, w.Code)
+
+	// Case for commands from the control plane
+	newCommand := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/commands", strings.NewReader(body))
+		req.Header.Set("APIPrefix", agent.Credentials.APIPrefix)
+		req.Header.Set("APISecret", agent.Credentials.APISecret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	assert.Equal(http.StatusBadRequest, newCommand("not json").Code)
+	assert.Equal(http.StatusBadRequest, newCommand(`{"command": "doctor"}`).Code)
+	w = newCommand(`{"command": "preset", "preset": "concert"}`)
+	assert.Equal(http.StatusOK, w.Code)
+	var result struct {
+		Command string               `json:"command"`
+		Result  client.MixPresetList `json:"result"`
+	}
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(PresetCommand, result.Command)
+	assert.Equal(errMixPresetNotFound.Error(), result.Result.Error)
+
+	// Case for an export without an upload target
+	w = newCommand(`{"command": "export"}`)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "missing upload URL")
+}
+
+func TestServerAgentHeartbeats(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	server := apitest.NewServer(credentials)
+	defer server.Close()
+	config := client.ServerAgentConfig{MixCode: "mix"}
+	config.Host = "c.d.com"
+	server.SetServerConfig(config)
+	agent, _ := newTestServerAgent(t, api.NewClient(server.URL, credentials, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go agent.sendHeartbeats(ctx, &wg)
+
+	// the first heartbeat is sent right away, and queues its config
+	select {
+	case received := <-agent.configs:
+		assert.Equal(config, received)
+	case <-time.After(5 * time.Second):
+		assert.Fail("timed out waiting for config")
+	}
+	cancel()
+	wg.Wait()
+	assert.Equal(config, agent.Config())
+
+	var beat client.ServerHeartbeat
+	assert.Nil(json.Unmarshal(server.Heartbeats()[0], &beat))
+	assert.Equal("abc", beat.CloudID)
+	assert.NotNil(beat.Utilization)
+	assert.Empty(beat.MixErrors)
+
+	// Case for a running JACK server, which reports its DSP load
+	graph := NewFakeJackGraph("agent")
+	graph.CPULoad = 22.5
+	agent.AutoConnector.JackClient = graph
+	assert.Equal(22.5, agent.getHeartbeat().Utilization.DSPLoad)
+
+	// Case for an unresponsive mixer, which reports recent mixer errors
+	agent.Health.status = client.MixerUnresponsive
+	assert.Equal([]string{"ERROR: Message 'play' not understood."}, agent.getHeartbeat().MixErrors)
+
+	// Case for an unchanged config, which is not applied again
+	agent.receiveConfig(config)
+	assert.Len(agent.configs, 0)
+	config.MixCode = "new mix"
+	agent.receiveConfig(config)
+	agent.receiveConfig(config)
+	assert.Len(agent.configs, 1)
+}
+
+func TestServerAgentSessions(t *testing.T) {
+	assert := assert.New(t)
+	agent, _ := newTestServerAgent(t, nil)
+	graph := NewFakeJackGraph("agent")
+	agent.AutoConnector.JackClient = graph
+	now := time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+
+	// Case for JACK not running yet
+	agent.updateRoster(now)
+	assert.Equal(0, agent.Roster.Count())
+
+	// Case for the first client, which starts a session
+	graph.RegisterPort("alice:receive_1", jack.PortIsOutput)
+	graph.RegisterPort("alice:send_1", jack.PortIsInput)
+	agent.updateRoster(now)
+	assert.Equal(1, agent.Roster.Count())
+	beat := agent.getHeartbeat()
+	assert.Len(beat.ClientEvents, 1)
+	assert.Equal("alice", beat.ClientEvents[0].Name)
+	agent.clearReported(beat)
+	assert.Empty(agent.getHeartbeat().ClientEvents)
+	_, err := agent.Recording.AddMarker("chorus", now.Add(time.Minute))
+	assert.Nil(err)
+
+	// Case for the last client leaving, which saves the manifest
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	url, events := receiveWebhooks(ctx, t, &wg, agent)
+	agent.Webhooks.SetConfig(client.WebhookConfig{WebhookURLs: url})
+	graph.UnregisterClient("alice")
+	agent.updateRoster(now.Add(2 * time.Minute))
+	assert.Equal(common.WebhookClientJoined, nextWebhook(events))
+	assert.Equal(common.WebhookClientLeft, nextWebhook(events))
+	assert.Equal(common.WebhookRecordingSaved, nextWebhook(events))
+	assert.Equal(0, agent.Roster.Count())
+	assert.Len(agent.getHeartbeat().ClientEvents, 1)
+	raw, err := ioutil.ReadFile(filepath.Join(PathToRecordings, "20220501T200000Z.json"))
+	assert.Nil(err)
+	var manifest common.SessionManifest
+	assert.Nil(json.Unmarshal(raw, &manifest))
+	assert.Len(manifest.Markers, 1)
+	_, err = agent.Recording.AddMarker("outro", now.Add(3*time.Minute))
+	assert.Equal(ErrNoActiveRecording, err)
+}
+
+func TestServerAgentApplyConfig(t *testing.T) {
+	assert := assert.New(t)
+	agent, services := newTestServerAgent(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	url, events := receiveWebhooks(ctx, t, &wg, agent)
+
+	// Case for a public broadcast, which runs the mixer and the recorder
+	config := client.ServerAgentConfig{MixCode: "mix", Broadcast: client.BroadcastPublicWOStemWOVideo}
+	config.WebhookURLs = url
+	agent.applyConfig(ctx, &wg, config)
+	assert.Equal(common.WebhookBroadcastStarted, nextWebhook(events))
+	assert.True(services.IsActive(SCSynthServiceName))
+	assert.True(services.IsActive(SCLangServiceName))
+	assert.True(services.IsActive(RecorderServiceName))
+	assert.Equal(getServerConnections(config), agent.Supervisor.Connections)
+	assert.FileExists(PathToSuperColliderConfig)
+	assert.FileExists(PathToRecorderConfig)
+	assert.Empty(agent.Mixer.Error())
+	assert.Nil(agent.stopRelay)
+
+	// Case for relaying the broadcast through the API
+	config.HLSDelivery = client.HLSDeliveryRelay
+	agent.applyConfig(ctx, &wg, config)
+	assert.NotNil(agent.stopRelay)
+
+	// Case for a hybrid studio, which bridges the Jamulus mix into the recorder
+	config.Type = client.JackTripJamulus
+	agent.applyConfig(ctx, &wg, config)
+	assert.Subset(agent.Supervisor.Connections, getJamulusRecorderConnections(config))
+	assert.Len(agent.Supervisor.Connections, len(getServerConnections(config))+2)
+
+	// Case for unlisting the broadcast, which keeps every service running
+	services.Events = nil
+	config.Broadcast = client.BroadcastUnlistedWOStemWOVideo
+	agent.applyConfig(ctx, &wg, config)
+	assert.Empty(services.Events)
+	assert.True(services.IsActive(SCLangServiceName))
+	assert.True(services.IsActive(RecorderServiceName))
+	assert.Equal(config, agent.applied)
+
+	// Case for going offline, which stops the recorder but keeps the mix running
+	services.Events = nil
+	config.Broadcast = client.Offline
+	agent.applyConfig(ctx, &wg, config)
+	assert.Equal([]string{"stop " + RecorderServiceName}, services.Events)
+	assert.True(services.IsActive(SCLangServiceName))
+	assert.Nil(agent.stopRelay)
+	assert.Equal(common.WebhookBroadcastStopped, nextWebhook(events))
+
+	// Case for a mixer that fails to restart
+	services.Installed = map[string]bool{RecorderServiceName: true}
+	config.MixCode = "new mix"
+	agent.applyConfig(ctx, &wg, config)
+	assert.NotEmpty(agent.Mixer.Error())
+	assert.Equal(common.WebhookError, nextWebhook(events))
+}
+
+func TestServerAgentDrain(t *testing.T) {
+	assert := assert.New(t)
+	agent, services := newTestServerAgent(t, nil)
+	runner := systemRunner.(*FakeRunner)
+	agent.checking = true // there is no API to probe the port
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+	config := client.ServerAgentConfig{MixCode: "mix", Broadcast: client.BroadcastPublicWOStemWOVideo}
+	config.Port = 4464
+	agent.receiveConfig(config)
+	agent.applyConfig(ctx, &wg, <-agent.configs)
+	assert.NotContains(runner.Inputs, common.GetDrainRuleset(4464))
+	runner.Commands, runner.Inputs = nil, nil
+
+	// Case for draining, which blocks new connections but keeps recording
+	config.Drain = true
+	agent.receiveConfig(config)
+	agent.applyConfig(ctx, &wg, <-agent.configs)
+	assert.Equal([]string{"nft -f -"}, runner.Commands)
+	assert.Equal([]string{common.GetDrainRuleset(4464)}, runner.Inputs)
+	assert.True(services.IsActive(RecorderServiceName))
+
+	// Case for clients that remain connected
+	agent.updateDrain(client.ServerHeartbeat{Draining: true, DrainRemaining: 1})
+	assert.Len(agent.configs, 0)
+
+	// Case for the last client leaving, which stops the recorder
+	agent.updateDrain(client.ServerHeartbeat{Draining: true})
+	agent.applyConfig(ctx, &wg, <-agent.configs)
+	assert.False(services.IsActive(RecorderServiceName))
+	assert.True(services.IsActive(SCLangServiceName))
+	assert.Len(runner.Commands, 1)
+
+	// Case for no longer draining, which accepts new connections and restarts the recorder
+	config.Drain = false
+	agent.receiveConfig(config)
+	agent.updateDrain(client.ServerHeartbeat{})
+	agent.applyConfig(ctx, &wg, <-agent.configs)
+	assert.Equal("nft delete table inet jacktrip_drain", runner.Commands[1])
+	assert.True(services.IsActive(RecorderServiceName))
+}
+
+func TestGetServerConnections(t *testing.T) {
+	assert := assert.New(t)
+	config := client.ServerAgentConfig{ChannelLayout: client.Layout51}
+	connections := getServerConnections(config)
+	assert.Len(connections, 8)
+	assert.Equal(PortConnection{Src: "SuperCollider:out_6", Dest: "recorder:in_6"}, connections[5])
+	assert.Equal(PortConnection{Src: "SuperCollider:out_2", Dest: "listen:in_2"}, connections[7])
+
+	// Case for a mono mix, which is sent to both sides of the listen monitor
+	config.ChannelLayout = client.LayoutMono
+	assert.Equal([]PortConnection{
+		{Src: "SuperCollider:out_1", Dest: "recorder:in_1"},
+		{Src: "SuperCollider:out_1", Dest: "listen:in_1"},
+		{Src: "SuperCollider:out_1", Dest: "listen:in_2"},
+	}, getServerConnections(config))
+}
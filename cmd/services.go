@@ -12,19 +12,24 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !server
+// +build !server
+
 package main
 
 import (
 	"bufio"
 	"encoding/base64"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
 
-	"github.com/coreos/go-systemd/v22/dbus"
 	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
 )
 
 const (
@@ -36,20 +41,78 @@ const (
 
 	// JamulusServiceName is the name of the systemd service for Jamulus client on RPI devices
 	JamulusServiceName = "jamulus.service"
-
-	// PathToJackConfig is the path to Jack service config file
-	PathToJackConfig = "/tmp/default/jack"
-
-	// PathToJackTripConfig is the path to JackTrip service config file
-	PathToJackTripConfig = "/tmp/default/jacktrip"
-
-	// PathToJamulusConfig is the path to Jamulus service config file
-	PathToJamulusConfig = "/tmp/default/jamulus"
 )
 
+// serviceRestartMutex serializes restarts of managed services by config updates, standby switches and jitter tuning
+// NOTE: it must be locked before the StandbyManager mutex
+var serviceRestartMutex sync.Mutex
+
 // updateServiceConfigs is used to update config for managed systemd services
 func updateServiceConfigs(config client.DeviceAgentConfig, remoteName string) {
 
+	// create config opts from templates
+	var jackConfig, jackTripConfig string
+
+	updateJamulusIni(config, remoteName)
+
+	soundDeviceName := deviceState.SoundDevice().Name
+	jackConfig = fmt.Sprintf(JackDeviceConfigTemplate, "alsa -d hw:"+soundDeviceName, config.SampleRate, config.Period)
+	// the AES67 bridge replaces the local sound card, so JACK is clocked by the dummy driver
+	if soundDeviceName == "dummy" || isAES67Enabled(config) {
+		jackConfig = fmt.Sprintf(JackDeviceConfigTemplate, "dummy", config.SampleRate, config.Period)
+	} else if soundDeviceName == DesktopSoundDeviceName {
+		jackConfig = fmt.Sprintf(JackDeviceConfigTemplate, getDesktopJackDriver(runtime.GOOS), config.SampleRate, config.Period)
+	}
+
+	jackTripConfig = getJackTripConfig(config, config.Host, config.Port, remoteName)
+
+	// ensure config directory exists
+	err := os.MkdirAll(ServiceConfigDir, 0755)
+	if err != nil {
+		log.Error(err, "Failed to create directory", "path", ServiceConfigDir)
+		panic(err)
+	}
+
+	// write jack config file
+	_, err = common.WriteFileIfChanged(PathToJackConfig, []byte(jackConfig), 0644)
+	if err != nil {
+		log.Error(err, "Failed to save Jack config", "path", PathToJackConfig)
+		panic(err)
+	}
+
+	// write JackTrip config file
+	_, err = common.WriteFileIfChanged(PathToJackTripConfig, []byte(jackTripConfig), 0644)
+	if err != nil {
+		log.Error(err, "Failed to save JackTrip config", "path", PathToJackTripConfig)
+	}
+
+	// write Jamulus config file
+	jamulusConfig := fmt.Sprintf(JamulusDeviceConfigTemplate, config.Host, config.Port)
+	_, err = common.WriteFileIfChanged(PathToJamulusConfig, []byte(jamulusConfig), 0644)
+	if err != nil {
+		log.Error(err, "Failed to save Jamulus config", "path", PathToJamulusConfig)
+	}
+
+	// write metronome config file
+	updateMetronomeConfig(config)
+
+	// write timecode config file
+	updateTimecodeConfig(config)
+
+	// write effects config file
+	updateEffectsConfig(config, getSendChannels(config))
+
+	// write AES67 bridge config file
+	updateAES67Config(config)
+}
+
+// getJackTripConfig returns the JackTrip service config used to connect a device to an audio server
+func getJackTripConfig(config client.DeviceAgentConfig, host string, port int, remoteName string) string {
+	// tuned jitter queues always stay within the tuning bounds
+	if config.AdaptiveQueue {
+		config.QueueBuffer = getInitialQueue(config)
+	}
+
 	// assume auto queue unless > 0
 	bufStrategy := config.BufferStrategy
 	if bufStrategy < 1 {
@@ -68,16 +131,6 @@ func updateServiceConfigs(config client.DeviceAgentConfig, remoteName string) {
 		}
 	}
 
-	// create config opts from templates
-	var jackConfig, jackTripConfig string
-
-	updateJamulusIni(config, remoteName)
-
-	jackConfig = fmt.Sprintf(JackDeviceConfigTemplate, "alsa -d hw:"+soundDeviceName, config.SampleRate, config.Period)
-	if soundDeviceName == "dummy" {
-		jackConfig = fmt.Sprintf(JackDeviceConfigTemplate, soundDeviceName, config.SampleRate, config.Period)
-	}
-
 	// configure limiter
 	if config.Limiter {
 		jackTripExtraOpts = fmt.Sprintf("%s -Oio", jackTripExtraOpts)
@@ -96,48 +149,45 @@ func updateServiceConfigs(config client.DeviceAgentConfig, remoteName string) {
 		jackTripExtraOpts = fmt.Sprintf("%s -f \"%s\"", jackTripExtraOpts, strings.TrimSpace(jackTripEffects))
 	}
 
-	receiveChannels := config.OutputChannels // audio signals from the audio server to the user, hence receiveChannels
-	sendChannels := config.InputChannels     // audio signals to the audio server from user's input, hence sendChannels
-	if receiveChannels == 0 {
-		receiveChannels = 2 // default output channels is stereo
-	}
-	if sendChannels == 0 {
-		sendChannels = 1 // default input channels is mono
+	// keep audio on its own network, when one is configured
+	if address, err := getBindAddress(config.AudioInterface, config.AudioAddress); err != nil {
+		log.Error(err, "Unable to find address of audio network interface", "interface", config.AudioInterface)
+	} else if address != "" {
+		jackTripExtraOpts = fmt.Sprintf("%s --localaddress %s", jackTripExtraOpts, address)
 	}
 
-	jackTripConfig = fmt.Sprintf(JackTripDeviceConfigTemplate, receiveChannels, sendChannels, config.Host, config.Port, config.DevicePort, remoteName, strings.TrimSpace(jackTripExtraOpts))
-
-	// ensure config directory exists
-	err := os.MkdirAll("/tmp/default", 0755)
-	if err != nil {
-		log.Error(err, "Failed to create directory", "path", "/tmp/default")
-		panic(err)
+	// the input chain or AES67 bridge sits between local capture and JackTrip, so JackTrip must not connect its own ports
+	if isInputChainEnabled(config) || isAES67Enabled(config) {
+		jackTripExtraOpts = fmt.Sprintf("%s -D", jackTripExtraOpts)
 	}
 
-	// write jack config file
-	err = ioutil.WriteFile(PathToJackConfig, []byte(jackConfig), 0644)
-	if err != nil {
-		log.Error(err, "Failed to save Jack config", "path", PathToJackConfig)
-		panic(err)
-	}
+	return fmt.Sprintf(JackTripDeviceConfigTemplate, getReceiveChannels(config), getJackTripSendChannels(config), host, port, config.DevicePort, remoteName, strings.TrimSpace(jackTripExtraOpts))
+}
 
-	// write JackTrip config file
-	err = ioutil.WriteFile(PathToJackTripConfig, []byte(jackTripConfig), 0644)
-	if err != nil {
-		log.Error(err, "Failed to save JackTrip config", "path", PathToJackTripConfig)
+// getReceiveChannels returns the number of audio channels from the audio server to the user, hence receiveChannels
+func getReceiveChannels(config client.DeviceAgentConfig) int {
+	if config.OutputChannels == 0 {
+		return 2 // default output channels is stereo
 	}
+	return config.OutputChannels
+}
 
-	// write Jamulus config file
-	jamulusConfig := fmt.Sprintf(JamulusDeviceConfigTemplate, config.Host, config.Port)
-	err = ioutil.WriteFile(PathToJamulusConfig, []byte(jamulusConfig), 0644)
-	if err != nil {
-		log.Error(err, "Failed to save Jamulus config", "path", PathToJamulusConfig)
+// getSendChannels returns the number of audio channels to the audio server from user's input, hence sendChannels
+func getSendChannels(config client.DeviceAgentConfig) int {
+	if config.InputChannels == 0 {
+		return 1 // default input channels is mono
 	}
+	return config.InputChannels
+}
+
+// usesJackTrip returns true if a device connects to the audio server using JackTrip
+func usesJackTrip(config client.DeviceAgentConfig) bool {
+	return config.Type == client.JackTrip || (config.Type == client.JackTripJamulus && config.Quality == 2)
 }
 
-// updateJamulusIni writes a new /tmp/jamulus.ini file using template at /var/lib/jacktrip/jamulus.ini
+// updateJamulusIni writes a new /tmp/jamulus.ini file using template at <AgentLibDir>/jamulus.ini
 func updateJamulusIni(config client.DeviceAgentConfig, remoteName string) {
-	srcFileName := "/var/lib/jacktrip/jamulus.ini"
+	srcFileName := filepath.Join(AgentLibDir, "jamulus.ini")
 	srcFile, err := os.Open(srcFileName)
 	if err != nil {
 		log.Error(err, "Failed to open file for reading", "path", srcFileName)
@@ -189,13 +239,7 @@ func updateJamulusIni(config client.DeviceAgentConfig, remoteName string) {
 
 // StartZitaService starts a zita service
 func StartZitaService(serviceName string) error {
-	conn, err := dbus.New()
-	if err != nil {
-		log.Error(err, "Failed to connect to dbus")
-	}
-	defer conn.Close()
-
-	err = startService(conn, serviceName)
+	err := serviceManager.Start(serviceName)
 	if err != nil {
 		log.Error(err, "Unable to start service", "name", serviceName)
 	}
@@ -204,57 +248,46 @@ func StartZitaService(serviceName string) error {
 
 // StopZitaService stops a running zita service
 func StopZitaService(serviceName string) error {
-	conn, err := dbus.New()
-	if err != nil {
-		log.Error(err, "Failed to connect to dbus")
-		return err
-	}
-	defer conn.Close()
-
-	// stop any managed services that are active
-	units, err := conn.ListUnitsByNames([]string{serviceName})
+	err := serviceManager.Stop(serviceName)
 	if err != nil {
-		log.Error(err, "Failed to get status of managed services")
-		return err
+		log.Error(err, "Unable to stop service")
 	}
+	return err
+}
 
-	for _, u := range units {
-		err = stopService(conn, u)
-		if err != nil {
-			log.Error(err, "Unable to stop service")
-			return err
-		}
+// checkServicePorts returns a PortConflict if a port needed by the services of a device config is held by another process
+func checkServicePorts(config client.DeviceAgentConfig) error {
+	if usesJackTrip(config) && config.DevicePort > 0 {
+		return checkPortAvailable("udp", config.DevicePort)
 	}
 	return nil
 }
 
-// restartAllServices is used to restart all of the managed systemd services
-func restartAllServices(config client.DeviceAgentConfig) {
-	// create dbus connection to manage systemd units
-	conn, err := dbus.New()
-	if err != nil {
-		log.Error(err, "Failed to connect to dbus")
-		panic(err)
-	}
-	defer conn.Close()
+// restartAllServices is used to restart all of the managed systemd services; if another process holds
+// one of their ports, they are left stopped and the PortConflict is returned, and if the sound card or JACK
+// does not become ready in time, they are left stopped and a ServiceNotReady is returned
+func restartAllServices(config client.DeviceAgentConfig) error {
+	serviceRestartMutex.Lock()
+	defer serviceRestartMutex.Unlock()
 
 	// stop any managed services that are active
-	units, err := conn.ListUnitsByNames([]string{JackServiceName, JackTripServiceName, JamulusServiceName})
+	err := serviceManager.Stop(JackServiceName, JackTripServiceName, JamulusServiceName, MetronomeServiceName, TimecodeServiceName, EffectsServiceName, ModHostServiceName, AES67ServiceName)
 	if err != nil {
-		log.Error(err, "Failed to get status of managed services")
+		log.Error(err, "Unable to stop service")
 		panic(err)
 	}
-	for _, u := range units {
-		err = stopService(conn, u)
-		if err != nil {
-			log.Error(err, "Unable to stop service")
-			panic(err)
-		}
-	}
 
 	// don't restart if server is not active
 	if !config.Enabled {
-		return
+		deviceState.SetStatus(ServicesSubsystem, "stopped")
+		return nil
+	}
+
+	// services fail with opaque errors when their ports are taken, so check them now that managed services are stopped
+	if err := checkServicePorts(config); err != nil {
+		log.Error(err, "Unable to start services")
+		deviceState.SetStatus(ServicesSubsystem, "port conflict")
+		return err
 	}
 
 	// determine which services to start
@@ -275,66 +308,69 @@ func restartAllServices(config client.DeviceAgentConfig) {
 		}
 	}
 
-	// start managed services
-	for _, serviceName := range servicesToStart {
-		err = startService(conn, serviceName)
-		if err != nil {
-			log.Error(err, "Unable to start service", "name", serviceName)
-			panic(err)
-		}
+	// AES67 bridge depends upon jack, and JackTrip relies on it for local input and output
+	if isAES67Enabled(config) && len(servicesToStart) > 0 {
+		servicesToStart = append(servicesToStart, AES67ServiceName)
 	}
-}
 
-// stopService is used to stop a managed systemd service
-func stopService(conn *dbus.Conn, u dbus.UnitStatus) error {
-	if u.ActiveState == "inactive" {
-		return nil
+	// effects host depends upon jack, and JackTrip relies on it for local input
+	if isEffectsChainEnabled(config) && len(servicesToStart) > 0 {
+		servicesToStart = append(servicesToStart, EffectsServiceName)
 	}
 
-	log.Info("Stopping managed service", "service", u.Name)
-
-	reschan := make(chan string)
-	_, err := conn.StopUnit(u.Name, "replace", reschan)
-	if err != nil {
-		return fmt.Errorf("failed to stop %s: job status=%s", u.Name, err.Error())
+	// LV2 plugin host depends upon jack, and JackTrip relies on it for local input
+	if isLV2ChainEnabled(config) && len(servicesToStart) > 0 {
+		servicesToStart = append(servicesToStart, ModHostServiceName)
 	}
 
-	jobStatus := <-reschan
-	if jobStatus != "done" {
-		return fmt.Errorf("failed to stop %s: job status=%s", u.Name, jobStatus)
+	// metronome depends upon jack, so start it last
+	if config.Metronome && len(servicesToStart) > 0 {
+		servicesToStart = append(servicesToStart, MetronomeServiceName)
 	}
 
-	log.Info("Finished stopping managed service", "name", u.Name)
-	return nil
-}
-
-// startService is used to start a managed systemd service
-func startService(conn *dbus.Conn, name string) error {
-	log.Info("Starting managed service", "name", name)
-
-	reschan := make(chan string)
-	_, err := conn.StartUnit(name, "replace", reschan)
+	// timecode generator follows JACK transport, so it also depends upon jack
+	if config.Timecode != client.TimecodeOff && len(servicesToStart) > 0 {
+		servicesToStart = append(servicesToStart, TimecodeServiceName)
+	}
 
-	if err != nil {
-		return fmt.Errorf("failed to start %s: job status=%s", name, err.Error())
+	// the sound card may still be initializing on boot, and JACK exits right away without it
+	if len(servicesToStart) > 0 && usesAlsaCard(config) {
+		err = waitForCheck("ALSA sound card", ServiceReadyTimeout, ServiceReadyInterval, func() error {
+			return checkAlsaCard(deviceState.SoundDevice().Name)
+		})
+		if err != nil {
+			log.Error(err, "Unable to start services")
+			deviceState.SetStatus(ServicesSubsystem, "not ready")
+			return err
+		}
 	}
 
-	jobStatus := <-reschan
-	if jobStatus != "done" {
-		return fmt.Errorf("failed to start %s: job status=%s", name, jobStatus)
+	// start managed services
+	for _, serviceName := range servicesToStart {
+		err = serviceManager.Start(serviceName)
+		if err != nil {
+			log.Error(err, "Unable to start service", "name", serviceName)
+			panic(err)
+		}
+
+		// other services depend upon jack, and fail in a loop if it is not ready yet (ie. on slow SD cards)
+		if serviceName == JackServiceName {
+			if err := waitForJack(ServiceReadyTimeout, ServiceReadyInterval); err != nil {
+				log.Error(err, "Unable to start services")
+				if err := serviceManager.Stop(JackServiceName); err != nil {
+					log.Error(err, "Unable to stop service", "name", JackServiceName)
+				}
+				deviceState.SetStatus(ServicesSubsystem, "not ready")
+				return err
+			}
+		}
 	}
-	log.Info("Finished starting managed service", "name", name)
+	deviceState.SetStatus(ServicesSubsystem, "running")
 	return nil
 }
 
 // killService is used to kill a managed systemd service
 func killService(name string) {
-	conn, err := dbus.New()
-	if err != nil {
-		log.Error(err, "Failed to connect to dbus")
-		return
-	}
-	defer conn.Close()
 	log.Info("Killing managed service", "name", name)
-	conn.KillUnit(name, 9)
+	serviceManager.Kill(name)
 }
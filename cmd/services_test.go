@@ -0,0 +1,40 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetJackTripConfigAudioBinding(t *testing.T) {
+	assert := assert.New(t)
+	config := client.DeviceAgentConfig{}
+	config.Type = client.JackTrip
+	assert.NotContains(getJackTripConfig(config, "example.com", 4464, "device"), "--localaddress")
+
+	config.AudioAddress = "10.0.20.5"
+	assert.Contains(getJackTripConfig(config, "example.com", 4464, "device"), "--localaddress 10.0.20.5")
+
+	// JackTrip is started without a binding if the interface has no address
+	config.AudioAddress = ""
+	config.AudioInterface = "missing0"
+	assert.NotContains(getJackTripConfig(config, "example.com", 4464, "device"), "--localaddress")
+}
@@ -0,0 +1,89 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/gorilla/mux"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+// getSessionManifestPath returns the path to the manifest of a session, which is saved next to its recordings
+func getSessionManifestPath(dir, name string) string {
+	return filepath.Join(dir, name+".json")
+}
+
+// saveSessionManifest writes the manifest of a session next to its recordings
+func saveSessionManifest(dir string, manifest common.SessionManifest) error {
+	rawBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(getSessionManifestPath(dir, manifest.Name), rawBytes, 0644)
+}
+
+// loadSessionManifest reads the manifest of a session saved by saveSessionManifest
+func loadSessionManifest(dir, name string) (common.SessionManifest, error) {
+	var manifest common.SessionManifest
+	rawBytes, err := os.ReadFile(getSessionManifestPath(dir, name))
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(rawBytes, &manifest)
+	return manifest, err
+}
+
+// handleSessionExportRequest downloads a recorded session as a zip archive of its tracks, with a manifest and
+// a Reaper project, so that it can be imported into a DAW
+func handleSessionExportRequest(dir string, credentials client.AgentCredentials, w http.ResponseWriter, r *http.Request) {
+	if !isAuthorizedAdminRequest(credentials, r) {
+		RespondJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if name == "" || filepath.Base(name) != name || name[0] == '.' {
+		RespondJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	}
+	manifest, err := loadSessionManifest(dir, name)
+	if os.IsNotExist(err) {
+		RespondJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	} else if err != nil {
+		log.Error(err, "Unable to read session manifest", "name", name)
+		RespondJSON(w, http.StatusInternalServerError, map[string]string{"error": "unable to read session"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+	if err := common.ExportSession(w, dir, manifest); err != nil {
+		// the status was sent with the first file, so the client only sees a truncated archive
+		log.Error(err, "Unable to export session", "name", name)
+	}
+}
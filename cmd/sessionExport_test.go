@@ -0,0 +1,89 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionManifests(t *testing.T) {
+	assert := assert.New(t)
+	dir := filepath.Join(t.TempDir(), "recordings")
+	manifest := common.SessionManifest{Name: "20220501T200000Z", SampleRate: 48000}
+	manifest.AddTrack("alice", "alice.flac", time.Time{})
+
+	assert.NoError(saveSessionManifest(dir, manifest))
+	loaded, err := loadSessionManifest(dir, manifest.Name)
+	assert.NoError(err)
+	assert.Equal(manifest, loaded)
+	_, err = loadSessionManifest(dir, "missing")
+	assert.Error(err)
+}
+
+func TestHandleSessionExportRequest(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	dir := t.TempDir()
+	manifest := common.SessionManifest{Name: "20220501T200000Z", SampleRate: 48000}
+	manifest.AddTrack("alice", "alice.flac", time.Time{})
+	assert.NoError(saveSessionManifest(dir, manifest))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "alice.flac"), []byte("fLaC"), 0644))
+
+	export := func(name string, authorized bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://example.com/sessions/"+name+"/export", nil)
+		req = mux.SetURLVars(req, map[string]string{"name": name})
+		if authorized {
+			req.Header.Set("APIPrefix", "prefix")
+			req.Header.Set("APISecret", "secret")
+		}
+		resp := httptest.NewRecorder()
+		handleSessionExportRequest(dir, credentials, resp, req)
+		return resp
+	}
+
+	// Case for unauthorized request
+	assert.Equal(401, export(manifest.Name, false).Code)
+
+	// Case for missing or invalid sessions
+	assert.Equal(404, export("missing", true).Code)
+	assert.Equal(404, export("../"+manifest.Name, true).Code)
+
+	// Case for a recorded session
+	resp := export(manifest.Name, true)
+	assert.Equal(200, resp.Code)
+	assert.Equal("application/zip", resp.Header().Get("Content-Type"))
+	assert.Equal(`attachment; filename="20220501T200000Z.zip"`, resp.Header().Get("Content-Disposition"))
+	archive, err := zip.NewReader(bytes.NewReader(resp.Body.Bytes()), int64(resp.Body.Len()))
+	assert.NoError(err)
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal([]string{"alice.flac", "session.json", "session.rpp"}, names)
+}
@@ -0,0 +1,52 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSignalContext(t *testing.T) {
+	assert := assert.New(t)
+	ctx, stop := newSignalContext()
+	defer stop()
+
+	process, err := os.FindProcess(os.Getpid())
+	assert.Nil(err)
+	assert.Nil(process.Signal(syscall.SIGTERM))
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		assert.Fail("context was not cancelled by SIGTERM")
+	}
+}
+
+func TestWaitForGoroutines(t *testing.T) {
+	assert := assert.New(t)
+	var wg sync.WaitGroup
+	assert.True(waitForGoroutines(&wg, time.Second))
+
+	// Case for a goroutine that does not stop in time
+	wg.Add(1)
+	assert.False(waitForGoroutines(&wg, 10*time.Millisecond))
+	wg.Done()
+	assert.True(waitForGoroutines(&wg, time.Second))
+}
@@ -0,0 +1,194 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/xthexder/go-jack"
+)
+
+const (
+	// SimulatedSoundDeviceName is the sound device used when simulating hardware; JACK treats it like its dummy driver
+	SimulatedSoundDeviceName = "dummy"
+
+	// SimulatedSoundDeviceType is the type of sound device used when simulating hardware
+	SimulatedSoundDeviceType = "dummy"
+
+	// SimulatedMACAddress is a locally administered MAC address used when simulating hardware
+	SimulatedMACAddress = "02:00:00:00:00:01"
+
+	// SimulatedListenAddress is used by the HTTP server when simulating hardware, so that it can run without root
+	SimulatedListenAddress = ":8080"
+
+	// simulatedCardName is the name of the simulated USB sound card
+	simulatedCardName = "Simulated"
+)
+
+// simulatedPort is a JACK port registered by a simulated service
+type simulatedPort struct {
+	name  string
+	flags uint64
+}
+
+// simulatedServices starts and stops services in memory, registering their ports with a fake JACK graph
+type simulatedServices struct {
+	*FakeServiceManager
+	graph *FakeJackGraph
+}
+
+// getSimulatedChannelPorts returns numbered input and output ports, one for each channel
+func getSimulatedChannelPorts(inputPrefix string, inputs int, outputPrefix string, outputs int) []simulatedPort {
+	var ports []simulatedPort
+	for i := 1; i <= inputs; i++ {
+		ports = append(ports, simulatedPort{fmt.Sprintf("%s%d", inputPrefix, i), jack.PortIsInput})
+	}
+	for i := 1; i <= outputs; i++ {
+		ports = append(ports, simulatedPort{fmt.Sprintf("%s%d", outputPrefix, i), jack.PortIsOutput})
+	}
+	return ports
+}
+
+// getSimulatedPorts returns the JACK client name and ports registered by a service
+func getSimulatedPorts(name string) (string, []simulatedPort) {
+	if match := zitaServiceName.FindStringSubmatch(name); match != nil {
+		client := fmt.Sprintf("%s-%s", match[1], match[2])
+		if ZitaMode(match[1]) == ZitaCapture {
+			return client, []simulatedPort{{"capture_1", jack.PortIsOutput}, {"capture_2", jack.PortIsOutput}}
+		}
+		return client, []simulatedPort{{"playback_1", jack.PortIsInput}, {"playback_2", jack.PortIsInput}}
+	}
+
+	switch name {
+	case JackServiceName:
+		return "system", []simulatedPort{
+			{"capture_1", jack.PortIsOutput}, {"capture_2", jack.PortIsOutput},
+			{"playback_1", jack.PortIsInput}, {"playback_2", jack.PortIsInput},
+		}
+	case JackTripServiceName:
+		config := deviceState.Config()
+		return "hubserver", getSimulatedChannelPorts("send_", getJackTripSendChannels(config), "receive_", getReceiveChannels(config))
+	case JamulusServiceName:
+		return "Jamulus", []simulatedPort{
+			{"input left", jack.PortIsInput}, {"input right", jack.PortIsInput},
+			{"output left", jack.PortIsOutput}, {"output right", jack.PortIsOutput},
+		}
+	case EffectsServiceName:
+		return EffectsClientName, []simulatedPort{
+			{"in_1", jack.PortIsInput}, {"in_2", jack.PortIsInput},
+			{"out_1", jack.PortIsOutput}, {"out_2", jack.PortIsOutput},
+		}
+	case AES67ServiceName:
+		config := deviceState.Config()
+		return AES67ClientName, getSimulatedChannelPorts("in_", getReceiveChannels(config), "out_", getSendChannels(config))
+	case MetronomeServiceName:
+		return MetronomeClientName, []simulatedPort{{"out", jack.PortIsOutput}}
+	case TimecodeServiceName:
+		return TimecodeClientName, []simulatedPort{{"out", jack.PortIsOutput}}
+	}
+	return "", nil
+}
+
+// Start marks a service as active and registers its JACK ports
+func (s *simulatedServices) Start(name string) error {
+	if name != JackServiceName && !s.IsActive(JackServiceName) {
+		return fmt.Errorf("failed to start %s: JACK server is not running", name)
+	}
+	if name == JackServiceName {
+		if config := deviceState.Config(); config.SampleRate > 0 {
+			s.graph.SampleRate = uint32(config.SampleRate)
+		}
+	}
+	if err := s.FakeServiceManager.Start(name); err != nil {
+		return err
+	}
+	client, ports := getSimulatedPorts(name)
+	for _, port := range ports {
+		s.graph.RegisterPort(fmt.Sprintf("%s:%s", client, port.name), port.flags)
+	}
+	return nil
+}
+
+// Stop marks any active services as inactive and unregisters their JACK ports
+func (s *simulatedServices) Stop(names ...string) error {
+	for _, name := range names {
+		if client, _ := getSimulatedPorts(name); client != "" && s.IsActive(name) {
+			s.graph.UnregisterClient(client)
+		}
+	}
+	return s.FakeServiceManager.Stop(names...)
+}
+
+// Kill marks a service as inactive and unregisters its JACK ports
+func (s *simulatedServices) Kill(name string) {
+	if client, _ := getSimulatedPorts(name); client != "" {
+		s.graph.UnregisterClient(client)
+	}
+	s.FakeServiceManager.Kill(name)
+}
+
+// newSimulatedAlsa returns a fake ALSA provider with a single stereo USB sound card
+func newSimulatedAlsa() *FakeAlsa {
+	alsa := NewFakeAlsa()
+	devices := fmt.Sprintf("**** List of Hardware Devices ****\ncard 1: %s [Simulated USB Audio], device 0: USB Audio [USB Audio]\n", simulatedCardName)
+	alsa.CaptureList = devices
+	alsa.PlaybackList = devices
+	alsa.CardList = fmt.Sprintf(" 1 [%-15s]: USB-Audio - Simulated USB Audio\n", simulatedCardName)
+	alsa.Streams[1] = `Simulated USB Audio at usb-0000:01:00.0-1.3, high speed : USB Audio
+
+Playback:
+  Status: Stop
+  Interface 1
+    Altset 1
+    Format: S16_LE
+    Channels: 2
+    Endpoint: 1 OUT (ADAPTIVE)
+    Rates: 44100, 48000
+
+Capture:
+  Status: Stop
+  Interface 2
+    Altset 1
+    Format: S16_LE
+    Channels: 2
+    Endpoint: 2 IN (ASYNC)
+    Rates: 44100, 48000
+`
+	alsa.ControlLists[1] = "numid=3,iface=MIXER,name='Mic Capture Volume'\nnumid=4,iface=MIXER,name='Speaker Playback Volume'\n"
+	return alsa
+}
+
+// enableSimulation replaces sound cards, the JACK graph and systemd services with in-memory fakes,
+// so that the agent can run end-to-end without audio hardware
+func enableSimulation() {
+	log.Info("Simulating sound cards, JACK and systemd services")
+	graph := NewFakeJackGraph("jackd")
+	services := &simulatedServices{FakeServiceManager: NewFakeServiceManager(), graph: graph}
+
+	systemRunner = NewFakeRunner()
+	serviceManager = services
+	alsaProvider = newSimulatedAlsa()
+	openJackGraph = func(name string, onRegistration jack.PortRegistrationCallback, onShutdown jack.ShutdownCallback) (JackGraph, error) {
+		if !services.IsActive(JackServiceName) {
+			return nil, errors.New("JACK server is not running")
+		}
+		graph.SetRegistrationCallback(onRegistration)
+		return graph, nil
+	}
+}
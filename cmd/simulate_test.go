@@ -0,0 +1,67 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulation(t *testing.T) {
+	assert := assert.New(t)
+	runner, services, alsa, open, state := systemRunner, serviceManager, alsaProvider, openJackGraph, deviceState
+	defer func() {
+		systemRunner, serviceManager, alsaProvider, openJackGraph, deviceState = runner, services, alsa, open, state
+	}()
+	deviceState = NewStateStore()
+	enableSimulation()
+
+	// the simulated sound card is discovered like a real one
+	assert.Equal(map[string]bool{simulatedCardName: true}, getCaptureDeviceNames())
+	assert.Equal(map[string]int{simulatedCardName: 1}, getDeviceToNumMappings())
+	assert.Equal(2, getSampleRateToChannelMap(readCardStream0(1), ZitaCapture)[48000])
+
+	// JACK clients cannot be opened until the JACK service is started
+	ac := NewAutoConnector()
+	_, err := openJackGraph(ac.Name, ac.handlePortRegistration, ac.onShutdown)
+	assert.Error(err)
+	assert.Error(StartZitaService("zita-a2j@Simulated.service"))
+
+	config := client.DeviceAgentConfig{}
+	config.Enabled = true
+	config.Type = client.JackTrip
+	config.InputChannels = 2
+	deviceState.SetConfig(config)
+	restartAllServices(config)
+	assert.NoError(StartZitaService("zita-a2j@Simulated.service"))
+	ac.SetupClient()
+	assert.Equal(uint32(48000), ac.JackClient.GetSampleRate())
+	assert.Equal([]string{"hubserver:send_1"}, ac.JackClient.GetConnections("a2j-Simulated:capture_1"))
+	assert.Equal([]string{"hubserver:send_2"}, ac.JackClient.GetConnections("a2j-Simulated:capture_2"))
+	ports, connections := ac.CollectMetrics()
+	assert.Equal(10, ports)
+	assert.Equal(2, connections)
+
+	// stopping a service removes its ports and connections
+	assert.NoError(StopZitaService("zita-a2j@Simulated.service"))
+	assert.False(ac.JackClient.HasPort("a2j-Simulated:capture_1"))
+	assert.Empty(ac.JackClient.GetConnections("hubserver:send_1"))
+	ac.TeardownClient()
+}
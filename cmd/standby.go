@@ -0,0 +1,197 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// FailoverCommand switches a device to its standby server
+	FailoverCommand = "failover"
+
+	// FailbackCommand switches a device back to its studio server
+	FailbackCommand = "failback"
+)
+
+// StandbyManager switches a device between its studio server and a standby server
+type StandbyManager struct {
+	// Timeline records each switch between servers
+	Timeline *SessionTimeline
+
+	active  string
+	primary []byte
+	missed  int
+	mutex   sync.Mutex
+}
+
+// isStandbyEnabled checks if a device config includes a standby server that can be switched to
+func isStandbyEnabled(config client.DeviceAgentConfig) bool {
+	return config.StandbyHost != "" && usesJackTrip(config)
+}
+
+// getStandbyPort returns the port of the standby server, which defaults to the studio server port
+func getStandbyPort(config client.DeviceAgentConfig) int {
+	if config.StandbyPort > 0 {
+		return config.StandbyPort
+	}
+	return config.Port
+}
+
+// updateStandbyConfig keeps a warm JackTrip config for the standby server, so that switching only restarts JackTrip
+func updateStandbyConfig(config client.DeviceAgentConfig, remoteName string) {
+	if !isStandbyEnabled(config) {
+		if err := os.Remove(PathToJackTripStandbyConfig); err != nil && !os.IsNotExist(err) {
+			log.Error(err, "Failed to remove JackTrip standby config", "path", PathToJackTripStandbyConfig)
+		}
+		return
+	}
+	standbyConfig := getJackTripConfig(config, config.StandbyHost, getStandbyPort(config), remoteName)
+	if _, err := common.WriteFileIfChanged(PathToJackTripStandbyConfig, []byte(standbyConfig), 0644); err != nil {
+		log.Error(err, "Failed to save JackTrip standby config", "path", PathToJackTripStandbyConfig)
+	}
+}
+
+// restartJackTrip replaces the JackTrip service config and restarts JackTrip, leaving all other services running
+// NOTE: callers must hold serviceRestartMutex
+func restartJackTrip(jackTripConfig []byte) error {
+	if _, err := common.WriteFileIfChanged(PathToJackTripConfig, jackTripConfig, 0644); err != nil {
+		return err
+	}
+	if err := serviceManager.Stop(JackTripServiceName); err != nil {
+		return err
+	}
+	return serviceManager.Start(JackTripServiceName)
+}
+
+// Active returns the host of the standby server, if the device switched to it
+func (m *StandbyManager) Active() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.active
+}
+
+// ActiveHost returns the host of the audio server the device is connected to
+func (m *StandbyManager) ActiveHost(config client.DeviceAgentConfig) string {
+	if active := m.Active(); active != "" {
+		return active
+	}
+	return config.Host
+}
+
+// Reset forgets about the standby server, after the studio server config was rewritten
+func (m *StandbyManager) Reset() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.active = ""
+	m.primary = nil
+	m.missed = 0
+}
+
+// Keepalive records the result of a keepalive to the active server, returning true if the device should switch to its standby server
+func (m *StandbyManager) Keepalive(config client.DeviceAgentConfig, ok bool) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if ok {
+		m.missed = 0
+		return false
+	}
+	m.missed++
+	return m.active == "" && isStandbyEnabled(config) && config.StandbyMissedKeepalives > 0 && m.missed >= config.StandbyMissedKeepalives
+}
+
+// Failover switches the device to its standby server
+func (m *StandbyManager) Failover(config client.DeviceAgentConfig) error {
+	serviceRestartMutex.Lock()
+	defer serviceRestartMutex.Unlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !bool(config.Enabled) || !isStandbyEnabled(config) {
+		return errors.New("no standby server is configured")
+	}
+	if m.active != "" {
+		return nil
+	}
+
+	primary, err := ioutil.ReadFile(PathToJackTripConfig)
+	if err != nil {
+		return err
+	}
+	standby, err := ioutil.ReadFile(PathToJackTripStandbyConfig)
+	if err != nil {
+		return err
+	}
+	log.Info("Switching to standby server", "host", config.StandbyHost)
+	if err := restartJackTrip(standby); err != nil {
+		return err
+	}
+	m.active = config.StandbyHost
+	m.primary = primary
+	m.missed = 0
+	if err := m.Timeline.Record(SessionEvent{Type: SessionStandby, Host: m.active}); err != nil {
+		log.Error(err, "Failed to record session event", "path", PathToSessionTimeline)
+	}
+	return nil
+}
+
+// Failback switches the device back to its studio server
+func (m *StandbyManager) Failback(config client.DeviceAgentConfig) error {
+	serviceRestartMutex.Lock()
+	defer serviceRestartMutex.Unlock()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.active == "" {
+		return nil
+	}
+	log.Info("Switching back to studio server", "host", config.Host)
+	if err := restartJackTrip(m.primary); err != nil {
+		return err
+	}
+	m.active = ""
+	m.primary = nil
+	m.missed = 0
+	if err := m.Timeline.Record(SessionEvent{Type: SessionStandby, Host: config.Host}); err != nil {
+		log.Error(err, "Failed to record session event", "path", PathToSessionTimeline)
+	}
+	return nil
+}
+
+// runStandbyCommand handles commands that switch between the studio server and the standby server
+func (a *DeviceAgent) runStandbyCommand(command string) client.StandbyReport {
+	config := deviceState.Config()
+	var err error
+	if command == FailoverCommand {
+		err = a.Standby.Failover(config)
+	} else {
+		err = a.Standby.Failback(config)
+	}
+
+	active := a.Standby.Active()
+	report := client.StandbyReport{Host: a.Standby.ActiveHost(config), Standby: active != ""}
+	if err != nil {
+		log.Error(err, "Unable to switch servers", "command", command)
+		report.Error = err.Error()
+	}
+	return report
+}
@@ -0,0 +1,129 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStandbyManager(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "jacktrip-agent")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	originalServiceDir, originalLibDir := ServiceConfigDir, AgentLibDir
+	defer func() {
+		ServiceConfigDir, AgentLibDir = originalServiceDir, originalLibDir
+		updatePaths()
+	}()
+	ServiceConfigDir, AgentLibDir = dir, dir
+	updatePaths()
+	timeline := NewSessionTimeline(10)
+	defer func(prev ServiceManager) { serviceManager = prev }(serviceManager)
+	services := NewFakeServiceManager()
+	serviceManager = services
+
+	config := client.DeviceAgentConfig{}
+	config.Enabled = true
+	config.Type = client.JackTrip
+	config.Host = "a.b.com"
+	config.Port = 4464
+	primary := getJackTripConfig(config, config.Host, config.Port, "pi")
+	assert.Nil(ioutil.WriteFile(PathToJackTripConfig, []byte(primary), 0644))
+
+	// Without a standby server, there is nothing to switch to
+	m := &StandbyManager{Timeline: timeline}
+	updateStandbyConfig(config, "pi")
+	_, err = os.Stat(PathToJackTripStandbyConfig)
+	assert.True(os.IsNotExist(err))
+	assert.NotNil(m.Failover(config))
+	assert.False(m.Keepalive(config, false))
+
+	// Standby config should be kept warm, using the studio port by default
+	config.StandbyHost = "standby.b.com"
+	config.StandbyMissedKeepalives = 2
+	updateStandbyConfig(config, "pi")
+	rawBytes, err := ioutil.ReadFile(PathToJackTripStandbyConfig)
+	assert.Nil(err)
+	assert.Contains(string(rawBytes), "standby.b.com")
+	assert.Contains(string(rawBytes), "4464")
+	assert.Equal("a.b.com", m.ActiveHost(config))
+
+	// Missed keepalives should trigger a failover once the threshold is reached
+	m.Reset()
+	assert.False(m.Keepalive(config, false))
+	assert.False(m.Keepalive(config, true))
+	assert.False(m.Keepalive(config, false))
+	assert.True(m.Keepalive(config, false))
+	assert.Nil(m.Failover(config))
+	assert.Equal("standby.b.com", m.Active())
+	assert.Equal("standby.b.com", m.ActiveHost(config))
+	assert.Equal([]string{"start " + JackTripServiceName}, services.Events)
+	rawBytes, err = ioutil.ReadFile(PathToJackTripConfig)
+	assert.Nil(err)
+	assert.True(strings.Contains(string(rawBytes), "standby.b.com"))
+
+	// Switching again should do nothing
+	assert.Nil(m.Failover(config))
+	assert.False(m.Keepalive(config, false))
+	assert.False(m.Keepalive(config, false))
+	assert.Len(services.Events, 1)
+
+	// Failback should restore the studio server config
+	assert.Nil(m.Failback(config))
+	assert.Equal("", m.Active())
+	assert.Equal([]string{"start " + JackTripServiceName, "stop " + JackTripServiceName, "start " + JackTripServiceName}, services.Events)
+	rawBytes, err = ioutil.ReadFile(PathToJackTripConfig)
+	assert.Nil(err)
+	assert.Equal(primary, string(rawBytes))
+
+	events := timeline.Events(time.Time{})
+	assert.Len(events, 2)
+	assert.Equal(SessionStandby, events[0].Type)
+	assert.Equal("standby.b.com", events[0].Host)
+	assert.Equal("a.b.com", events[1].Host)
+
+	// A custom port should be used for the standby server, and removing it should remove its config
+	config.StandbyPort = 5000
+	updateStandbyConfig(config, "pi")
+	rawBytes, err = ioutil.ReadFile(PathToJackTripStandbyConfig)
+	assert.Nil(err)
+	assert.Contains(string(rawBytes), "5000")
+	config.StandbyHost = ""
+	updateStandbyConfig(config, "pi")
+	_, err = os.Stat(PathToJackTripStandbyConfig)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestIsStandbyEnabled(t *testing.T) {
+	assert := assert.New(t)
+	config := client.DeviceAgentConfig{}
+	config.Type = client.JackTrip
+	assert.False(isStandbyEnabled(config))
+	config.StandbyHost = "standby.b.com"
+	assert.True(isStandbyEnabled(config))
+	config.Type = client.Jamulus
+	assert.False(isStandbyEnabled(config))
+}
@@ -0,0 +1,306 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+// Subsystem identifies a component of the agent that reports its status
+type Subsystem string
+
+const (
+	// WebSocketSubsystem is the control websocket connection to the control plane
+	WebSocketSubsystem Subsystem = "websocket"
+
+	// TelemetrySubsystem is the telemetry websocket connection to the control plane
+	TelemetrySubsystem Subsystem = "telemetry"
+
+	// AutoConnectorSubsystem is the JACK autoconnector
+	AutoConnectorSubsystem Subsystem = "autoconnector"
+
+	// MixerSubsystem is the USB device mixer
+	MixerSubsystem Subsystem = "mixer"
+
+	// ServicesSubsystem is the set of managed systemd services
+	ServicesSubsystem Subsystem = "services"
+)
+
+// StateEventType is used to determine the type of a state event
+type StateEventType string
+
+const (
+	// ConfigChanged means a new device config was applied
+	ConfigChanged StateEventType = "config"
+
+	// StatusChanged means a subsystem reported a new status
+	StatusChanged StateEventType = "status"
+)
+
+// stateSubscriberBufferSize is the number of events queued per subscriber before status events are dropped
+const stateSubscriberBufferSize = 16
+
+// StateEvent is published to subscribers whenever the agent state changes
+type StateEvent struct {
+	Type      StateEventType
+	Config    client.DeviceAgentConfig
+	Subsystem Subsystem
+	Status    string
+}
+
+// SubsystemStatus describes the latest status of a subsystem
+type SubsystemStatus struct {
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// SoundDevice identifies the sound card used by JACK
+type SoundDevice struct {
+	// ALSA card id (ie. "USB"), or "dummy" when simulating
+	Name string `json:"name"`
+
+	// ALSA type of the card (ie. "snd_rpi_hifiberry_dacplusadcpro")
+	Type string `json:"type"`
+}
+
+// StateSnapshot is a point-in-time view of the agent state
+type StateSnapshot struct {
+	Configured   bool                          `json:"configured"`
+	Enabled      bool                          `json:"enabled"`
+	Host         string                        `json:"host"`
+	Type         client.ServerType             `json:"type"`
+	DeviceStatus string                        `json:"deviceStatus"`
+	SoundDevice  SoundDevice                   `json:"soundDevice"`
+	Subsystems   map[Subsystem]SubsystemStatus `json:"subsystems"`
+}
+
+// StateStore holds the agent's shared state, and notifies subscribers of changes
+type StateStore struct {
+	configured   bool
+	config       client.DeviceAgentConfig
+	deviceStatus string
+	soundDevice  SoundDevice
+	heartbeat    client.DeviceHeartbeat
+	statuses     map[Subsystem]SubsystemStatus
+	subscribers  map[chan StateEvent]*stateSubscriber
+	mutex        sync.RWMutex
+}
+
+// stateSubscriber queues events for a subscriber, so that a slow subscriber never blocks the publisher
+type stateSubscriber struct {
+	out   chan StateEvent
+	wake  chan struct{}
+	done  chan struct{}
+	queue []StateEvent
+	mutex sync.Mutex
+}
+
+// push queues an event for the subscriber; status events are dropped once the queue is full, while a config event
+// replaces any config that was not delivered yet, so that the latest config always reaches the subscriber
+func (sub *stateSubscriber) push(event StateEvent) {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	if event.Type == ConfigChanged {
+		for i, queued := range sub.queue {
+			if queued.Type == ConfigChanged {
+				sub.queue = append(sub.queue[:i], sub.queue[i+1:]...)
+				break
+			}
+		}
+	} else if len(sub.queue) >= stateSubscriberBufferSize {
+		log.Info("Dropped state event for slow subscriber", "type", event.Type)
+		return
+	}
+	sub.queue = append(sub.queue, event)
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run delivers queued events in order, until the subscriber is removed
+func (sub *stateSubscriber) run() {
+	defer close(sub.out)
+	for {
+		select {
+		case <-sub.done:
+			return
+		case <-sub.wake:
+		}
+		for {
+			sub.mutex.Lock()
+			if len(sub.queue) == 0 {
+				sub.mutex.Unlock()
+				break
+			}
+			event := sub.queue[0]
+			sub.queue = sub.queue[1:]
+			sub.mutex.Unlock()
+			select {
+			case sub.out <- event:
+			case <-sub.done:
+				return
+			}
+		}
+	}
+}
+
+// NewStateStore constructs a new instance of StateStore
+func NewStateStore() *StateStore {
+	return &StateStore{
+		deviceStatus: "starting",
+		statuses:     map[Subsystem]SubsystemStatus{},
+		subscribers:  map[chan StateEvent]*stateSubscriber{},
+	}
+}
+
+// deviceState is the state shared by all device subsystems
+var deviceState = NewStateStore()
+
+// Config returns the current device config
+func (s *StateStore) Config() client.DeviceAgentConfig {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.config
+}
+
+// SetConfig updates the current device config, returning the previous one
+func (s *StateStore) SetConfig(config client.DeviceAgentConfig) client.DeviceAgentConfig {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	last := s.config
+	s.config = config
+	s.configured = true
+	s.publish(StateEvent{Type: ConfigChanged, Config: config})
+	return last
+}
+
+// SoundDevice returns the sound card used by JACK
+func (s *StateStore) SoundDevice() SoundDevice {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.soundDevice
+}
+
+// SetSoundDevice updates the sound card used by JACK
+func (s *StateStore) SetSoundDevice(device SoundDevice) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.soundDevice = device
+}
+
+// DeviceStatus returns the status of the device, as announced over mDNS (ie. "connected")
+func (s *StateStore) DeviceStatus() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.deviceStatus
+}
+
+// SetDeviceStatus updates the status of the device, returning true if it has changed
+func (s *StateStore) SetDeviceStatus(status string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.deviceStatus == status {
+		return false
+	}
+	s.deviceStatus = status
+	return true
+}
+
+// Heartbeat returns a copy of the status that is reported in heartbeats
+func (s *StateStore) Heartbeat() client.DeviceHeartbeat {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.heartbeat
+}
+
+// UpdateHeartbeat changes the status that is reported in heartbeats, while holding the lock
+// NOTE: pointer fields must be replaced rather than modified, since copies returned by Heartbeat share them
+func (s *StateStore) UpdateHeartbeat(update func(beat *client.DeviceHeartbeat)) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	update(&s.heartbeat)
+}
+
+// SetStatus records the status of a subsystem
+func (s *StateStore) SetStatus(subsystem Subsystem, status string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if current, ok := s.statuses[subsystem]; ok && current.Status == status {
+		return
+	}
+	s.statuses[subsystem] = SubsystemStatus{Status: status, UpdatedAt: time.Now()}
+	s.publish(StateEvent{Type: StatusChanged, Subsystem: subsystem, Status: status})
+}
+
+// Snapshot returns a point-in-time view of the agent state
+func (s *StateStore) Snapshot() StateSnapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	snapshot := StateSnapshot{
+		Configured:   s.configured,
+		Enabled:      bool(s.config.Enabled),
+		Host:         s.config.Host,
+		Type:         s.config.Type,
+		DeviceStatus: s.deviceStatus,
+		SoundDevice:  s.soundDevice,
+		Subsystems:   map[Subsystem]SubsystemStatus{},
+	}
+	for k, v := range s.statuses {
+		snapshot.Subsystems[k] = v
+	}
+	return snapshot
+}
+
+// Subscribe returns a channel that receives all future state events
+func (s *StateStore) Subscribe() chan StateEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sub := &stateSubscriber{
+		out:  make(chan StateEvent),
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	s.subscribers[sub.out] = sub
+	go sub.run()
+	return sub.out
+}
+
+// Unsubscribe stops sending state events to a channel, which is closed
+func (s *StateStore) Unsubscribe(ch chan StateEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if sub, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(sub.done)
+	}
+}
+
+// publish queues an event for all subscribers; callers must hold the lock
+// NOTE: slow subscribers may miss status events, but always receive the latest config
+func (s *StateStore) publish(event StateEvent) {
+	for _, sub := range s.subscribers {
+		sub.push(event)
+	}
+}
+
+// handleStatusRequest returns a snapshot of the agent state
+func handleStatusRequest(w http.ResponseWriter, r *http.Request) {
+	RespondJSON(w, http.StatusOK, deviceState.Snapshot())
+}
@@ -0,0 +1,127 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateStore(t *testing.T) {
+	assert := assert.New(t)
+	s := NewStateStore()
+	events := s.Subscribe()
+
+	config := client.DeviceAgentConfig{}
+	config.Enabled = true
+	config.Host = "a.b.com"
+	last := s.SetConfig(config)
+	assert.Equal(client.DeviceAgentConfig{}, last)
+	assert.Equal(config, s.Config())
+	event := <-events
+	assert.Equal(ConfigChanged, event.Type)
+	assert.Equal("a.b.com", event.Config.Host)
+
+	s.SetStatus(WebSocketSubsystem, "connected")
+	event = <-events
+	assert.Equal(StatusChanged, event.Type)
+	assert.Equal(WebSocketSubsystem, event.Subsystem)
+	assert.Equal("connected", event.Status)
+
+	// Unchanged statuses are not published
+	s.SetStatus(WebSocketSubsystem, "connected")
+	assertNoStateEvent(t, events)
+
+	// Device status changes are reported to the caller, without publishing an event
+	assert.Equal("starting", s.DeviceStatus())
+	assert.True(s.SetDeviceStatus("connected"))
+	assert.False(s.SetDeviceStatus("connected"))
+	assertNoStateEvent(t, events)
+
+	s.SetSoundDevice(SoundDevice{Name: "USB", Type: "usb"})
+	assert.Equal("USB", s.SoundDevice().Name)
+
+	snapshot := s.Snapshot()
+	assert.True(snapshot.Enabled)
+	assert.Equal("a.b.com", snapshot.Host)
+	assert.Equal("connected", snapshot.DeviceStatus)
+	assert.Equal(SoundDevice{Name: "USB", Type: "usb"}, snapshot.SoundDevice)
+	assert.Equal("connected", snapshot.Subsystems[WebSocketSubsystem].Status)
+
+	// Heartbeat status is updated under the lock, and copies are not affected by later updates
+	s.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) { beat.ConfigError = "bad config" })
+	beat := s.Heartbeat()
+	s.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) { beat.ConfigError = "" })
+	assert.Equal("bad config", beat.ConfigError)
+	assert.Equal("", s.Heartbeat().ConfigError)
+	assertNoStateEvent(t, events)
+
+	// Slow subscribers drop status events instead of blocking, but always receive the latest config
+	for i := 0; i < stateSubscriberBufferSize+5; i++ {
+		config.Port = i
+		s.SetConfig(config)
+		s.SetStatus(WebSocketSubsystem, fmt.Sprintf("status %d", i))
+	}
+	received := drainStateEvents(events)
+	assert.LessOrEqual(len(received), stateSubscriberBufferSize+2)
+	var latest client.DeviceAgentConfig
+	for _, event := range received {
+		if event.Type == ConfigChanged {
+			latest = event.Config
+		}
+	}
+	assert.Equal(stateSubscriberBufferSize+4, latest.Port)
+
+	s.Unsubscribe(events)
+	s.Unsubscribe(events)
+	s.SetConfig(config)
+}
+
+// assertNoStateEvent checks that no state event is delivered to a subscriber
+func assertNoStateEvent(t *testing.T, events chan StateEvent) {
+	select {
+	case event := <-events:
+		t.Errorf("unexpected state event: %v", event.Type)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// drainStateEvents returns the events delivered to a subscriber until it goes quiet
+func drainStateEvents(events chan StateEvent) []StateEvent {
+	var drained []StateEvent
+	for {
+		select {
+		case event := <-events:
+			drained = append(drained, event)
+		case <-time.After(50 * time.Millisecond):
+			return drained
+		}
+	}
+}
+
+func TestHandleStatusRequest(t *testing.T) {
+	assert := assert.New(t)
+	mockResp := httptest.NewRecorder()
+	handleStatusRequest(mockResp, httptest.NewRequest("GET", "http://example.com/status", nil))
+	assert.Equal(200, mockResp.Code)
+	var snapshot StateSnapshot
+	assert.Nil(json.Unmarshal(mockResp.Body.Bytes(), &snapshot))
+}
@@ -0,0 +1,350 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// StorageMountPoint is the directory the agent mounts the USB drive on, if it is not already mounted
+	StorageMountPoint = "/media/jacktrip"
+
+	// StorageCheckInterval is the time between checks of the USB drive
+	StorageCheckInterval = 30 * time.Second
+
+	// PathToLsblk is the path to lsblk, used to find USB drives
+	PathToLsblk = "/bin/lsblk"
+
+	// PathToMount is the path to mount
+	PathToMount = "/bin/mount"
+
+	// PathToUmount is the path to umount
+	PathToUmount = "/bin/umount"
+
+	// PathToFsck is the path to fsck, used to check filesystems before they are mounted
+	PathToFsck = "/sbin/fsck"
+
+	// PathToMounts is the path to the list of mounted filesystems
+	PathToMounts = "/proc/mounts"
+)
+
+// errNoUSBStorage is reported when USB storage is needed but none is mounted
+var errNoUSBStorage = errors.New("no USB storage is mounted")
+
+// lsblkColumns are the columns of block devices listed by lsblk
+const lsblkColumns = "NAME,TYPE,HOTPLUG,FSTYPE,LABEL,UUID,MOUNTPOINT"
+
+// lsblkPairRegexp matches the KEY="value" pairs printed by `lsblk -P`
+var lsblkPairRegexp = regexp.MustCompile(`([A-Z]+)="([^"]*)"`)
+
+// blockDevice is a block device listed by lsblk
+type blockDevice struct {
+	Name       string
+	Type       string
+	Hotplug    bool
+	FSType     string
+	Label      string
+	UUID       string
+	MountPoint string
+}
+
+// parseLsblk parses the output of `lsblk -P -p -o NAME,TYPE,HOTPLUG,FSTYPE,LABEL,UUID,MOUNTPOINT`
+func parseLsblk(output string) []blockDevice {
+	var devices []blockDevice
+	for _, line := range strings.Split(output, "\n") {
+		fields := map[string]string{}
+		for _, match := range lsblkPairRegexp.FindAllStringSubmatch(line, -1) {
+			// lsblk escapes spaces, ie. in labels
+			fields[match[1]] = strings.Replace(match[2], `\x20`, " ", -1)
+		}
+		if fields["NAME"] == "" {
+			continue
+		}
+		devices = append(devices, blockDevice{
+			Name:       fields["NAME"],
+			Type:       fields["TYPE"],
+			Hotplug:    fields["HOTPLUG"] == "1",
+			FSType:     fields["FSTYPE"],
+			Label:      fields["LABEL"],
+			UUID:       fields["UUID"],
+			MountPoint: fields["MOUNTPOINT"],
+		})
+	}
+	return devices
+}
+
+// findStorageDevice returns the first filesystem on a USB drive, or the one with a label if it is not empty
+func findStorageDevice(devices []blockDevice, label string) (blockDevice, bool) {
+	for _, d := range devices {
+		if !d.Hotplug || d.FSType == "" || (d.Type != "part" && d.Type != "disk") {
+			continue
+		}
+		if label == "" || d.Label == label {
+			return d, true
+		}
+	}
+	return blockDevice{}, false
+}
+
+// isFATFilesystem returns true for filesystems that set a dirty bit whenever a drive is unplugged without
+// being unmounted, which is how most people remove USB drives
+func isFATFilesystem(fsType string) bool {
+	return fsType == "vfat" || fsType == "msdos" || fsType == "exfat"
+}
+
+// fsckErrorsCorrected is the exit status of fsck when it repaired the filesystem
+const fsckErrorsCorrected = 1
+
+// getExitStatus returns the exit status of a command that failed, or -1 if it did not exit
+func getExitStatus(err error) int {
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// isReadWriteMount returns true if a directory is listed as a writable mount in /proc/mounts; the kernel
+// remounts filesystems read-only after errors
+func isReadWriteMount(mounts, mountPoint string) bool {
+	for _, line := range strings.Split(mounts, "\n") {
+		fields := strings.Fields(line)
+		// spaces in mount points are escaped as octal
+		if len(fields) < 4 || strings.Replace(fields[1], `\040`, " ", -1) != mountPoint {
+			continue
+		}
+		for _, option := range strings.Split(fields[3], ",") {
+			if option == "rw" {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// getDiskSpace returns the space available to unprivileged users and the size of a filesystem, in bytes
+func getDiskSpace(path string) (uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), stat.Blocks * uint64(stat.Bsize), nil
+}
+
+// StorageManager finds, mounts and checks the USB drive used for local recordings
+type StorageManager struct {
+	mountsPath string
+	mountPoint string
+	diskSpace  func(path string) (uint64, uint64, error)
+	attachTime func(name string) time.Time
+	status     *client.StorageStatus
+	// filesystem (UUID or device name) that could not be mounted; it is retried once it is re-attached
+	failed string
+	// device the agent mounted, which is unmounted if it disappears
+	mounted string
+	mutex   sync.Mutex
+}
+
+// NewStorageManager constructs a new instance of StorageManager
+func NewStorageManager() *StorageManager {
+	return &StorageManager{
+		mountsPath: PathToMounts,
+		mountPoint: StorageMountPoint,
+		diskSpace:  getDiskSpace,
+		attachTime: getAttachTime,
+	}
+}
+
+// Check finds the designated USB drive, mounts it if needed, and checks its health and free space
+func (m *StorageManager) Check(config client.StorageConfig, now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	out, err := systemRunner.Output(PathToLsblk, "-P", "-p", "-o", lsblkColumns)
+	if err != nil {
+		m.setStatus(client.StorageStatus{Error: err.Error(), UpdatedAt: now})
+		return
+	}
+	device, ok := findStorageDevice(parseLsblk(string(out)), config.StorageLabel)
+	if !ok || m.getStorageKey(device) != m.failed {
+		// the drive was detached, or another one was attached in its place
+		m.failed = ""
+	}
+	if m.mounted != "" && (!ok || device.Name != m.mounted) {
+		m.unmount()
+	}
+	if !ok {
+		if config.StorageLabel == "" {
+			m.setStatus(client.StorageStatus{})
+		} else {
+			m.setStatus(client.StorageStatus{Error: fmt.Sprintf("USB drive %s is not attached", config.StorageLabel), UpdatedAt: now})
+		}
+		return
+	}
+
+	status := client.StorageStatus{Device: device.Name, Label: device.Label, FSType: device.FSType, MountPoint: device.MountPoint, UpdatedAt: now}
+	if device.MountPoint == "" {
+		// drives that failed their filesystem check are not checked again until they are re-attached
+		if m.failed != "" {
+			return
+		}
+		if err := m.mount(device); err != nil {
+			m.failed = m.getStorageKey(device)
+			status.Error = err.Error()
+			m.setStatus(status)
+			return
+		}
+		m.mounted = device.Name
+		status.MountPoint = m.mountPoint
+	}
+
+	mounts, err := ioutil.ReadFile(m.mountsPath)
+	if err == nil && !isReadWriteMount(string(mounts), status.MountPoint) {
+		err = errors.New("filesystem is mounted read-only")
+	}
+	if err == nil {
+		var free, total uint64
+		free, total, err = m.diskSpace(status.MountPoint)
+		status.FreeSpace, status.TotalSpace = free/1024/1024, total/1024/1024
+	}
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Healthy = true
+	}
+	m.setStatus(status)
+}
+
+// getAttachTime returns when a device node was created, which changes whenever a drive is re-attached
+func getAttachTime(name string) time.Time {
+	info, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// getStorageKey identifies a filesystem by its UUID (or device name, if it does not have one) and when it
+// was attached; callers must hold the lock
+func (m *StorageManager) getStorageKey(device blockDevice) string {
+	key := device.UUID
+	if key == "" {
+		key = device.Name
+	}
+	return fmt.Sprintf("%s@%d", key, m.attachTime(device.Name).UnixNano())
+}
+
+// mount checks the filesystem of a USB drive, and mounts it if it is clean. FAT filesystems are repaired
+// automatically, since their dirty bit is set whenever a drive is pulled out; others are only checked.
+func (m *StorageManager) mount(device blockDevice) error {
+	fsckMode := "-n"
+	if isFATFilesystem(device.FSType) {
+		fsckMode = "-a"
+	}
+	if _, err := systemRunner.Output(PathToFsck, fsckMode, device.Name); err != nil {
+		if fsckMode == "-n" || getExitStatus(err) != fsckErrorsCorrected {
+			return fmt.Errorf("filesystem check failed: %s", err.Error())
+		}
+		log.Info("Repaired filesystem of USB drive", "device", device.Name, "label", device.Label)
+	}
+	if err := os.MkdirAll(m.mountPoint, 0755); err != nil {
+		return err
+	}
+	if _, err := systemRunner.Output(PathToMount, "-o", "noatime,nodev,nosuid,noexec", device.Name, m.mountPoint); err != nil {
+		return err
+	}
+	log.Info("Mounted USB drive", "device", device.Name, "label", device.Label, "path", m.mountPoint)
+	return nil
+}
+
+// unmount lazily unmounts a USB drive that disappeared, so that the mount point can be reused once it is
+// plugged back in; callers must hold the lock
+func (m *StorageManager) unmount() {
+	if _, err := systemRunner.Output(PathToUmount, "-l", m.mountPoint); err != nil {
+		log.Error(err, "Unable to unmount USB drive", "device", m.mounted, "path", m.mountPoint)
+	} else {
+		log.Info("Unmounted USB drive", "device", m.mounted, "path", m.mountPoint)
+	}
+	m.mounted = ""
+}
+
+// setStatus updates the status of the USB drive, logging errors when they change; callers must hold the lock
+func (m *StorageManager) setStatus(status client.StorageStatus) {
+	if status.Error != "" && (m.status == nil || m.status.Error != status.Error) {
+		log.Error(errors.New(status.Error), "USB drive is not usable", "device", status.Device)
+	}
+	if status.Device == "" && status.Error == "" {
+		m.status = nil
+		return
+	}
+	m.status = &status
+}
+
+// Status returns the status of the USB drive, or nil if none is attached or designated
+func (m *StorageManager) Status() *client.StorageStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.status == nil {
+		return nil
+	}
+	status := *m.status
+	return &status
+}
+
+// MountPoint returns the directory the USB drive is mounted on, or an error if it is not usable
+func (m *StorageManager) MountPoint() (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.status == nil || m.status.MountPoint == "" {
+		return "", errNoUSBStorage
+	}
+	if !m.status.Healthy {
+		return "", errors.New(m.status.Error)
+	}
+	return m.status.MountPoint, nil
+}
+
+// Run checks the USB drive right away and then periodically, ie. to find drives after they are plugged in,
+// until the context is cancelled
+func (m *StorageManager) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	m.Check(deviceState.Config().StorageConfig, time.Now())
+	ticker := time.NewTicker(StorageCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.Check(deviceState.Config().StorageConfig, now)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// lsblkCommand is the command used by StorageManager to list block devices
+var lsblkCommand = joinCommand(PathToLsblk, "-P", "-p", "-o", "NAME,TYPE,HOTPLUG,FSTYPE,LABEL,UUID,MOUNTPOINT")
+
+// exitStatusError is a command error with an exit status, like exec.ExitError
+type exitStatusError int
+
+func (e exitStatusError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+func (e exitStatusError) ExitCode() int { return int(e) }
+
+func TestParseLsblk(t *testing.T) {
+	assert := assert.New(t)
+	output := `NAME="/dev/mmcblk0" TYPE="disk" HOTPLUG="0" FSTYPE="" LABEL="" MOUNTPOINT=""
+NAME="/dev/mmcblk0p2" TYPE="part" HOTPLUG="0" FSTYPE="ext4" LABEL="rootfs" MOUNTPOINT="/"
+NAME="/dev/sda" TYPE="disk" HOTPLUG="1" FSTYPE="" LABEL="" MOUNTPOINT=""
+NAME="/dev/sda1" TYPE="part" HOTPLUG="1" FSTYPE="vfat" LABEL="MY\x20DRIVE" UUID="1234-ABCD" MOUNTPOINT=""
+NAME="/dev/sdb1" TYPE="part" HOTPLUG="1" FSTYPE="exfat" LABEL="JACKTRIP" MOUNTPOINT="/media/usb"
+`
+	devices := parseLsblk(output)
+	assert.Equal(5, len(devices))
+	assert.Equal(blockDevice{Name: "/dev/sda1", Type: "part", Hotplug: true, FSType: "vfat", Label: "MY DRIVE", UUID: "1234-ABCD"}, devices[3])
+
+	device, ok := findStorageDevice(devices, "")
+	assert.True(ok)
+	assert.Equal("/dev/sda1", device.Name)
+	device, ok = findStorageDevice(devices, "JACKTRIP")
+	assert.True(ok)
+	assert.Equal("/media/usb", device.MountPoint)
+	_, ok = findStorageDevice(devices, "rootfs")
+	assert.False(ok)
+}
+
+func TestIsReadWriteMount(t *testing.T) {
+	assert := assert.New(t)
+	mounts := `/dev/mmcblk0p2 / ext4 rw,noatime 0 0
+/dev/sda1 /media/read\040only vfat ro,relatime 0 0
+/dev/sdb1 /media/usb vfat rw,relatime 0 0
+`
+	assert.True(isReadWriteMount(mounts, "/media/usb"))
+	assert.False(isReadWriteMount(mounts, "/media/read only"))
+	assert.False(isReadWriteMount(mounts, "/media/jacktrip"))
+}
+
+func TestStorageManager(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+	dir, err := ioutil.TempDir("", "storage")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	defer func(prev SystemRunner) { systemRunner = prev }(systemRunner)
+	runner := NewFakeRunner()
+	systemRunner = runner
+
+	m := NewStorageManager()
+	m.mountsPath = filepath.Join(dir, "mounts")
+	m.mountPoint = filepath.Join(dir, "usb")
+	m.diskSpace = func(string) (uint64, uint64, error) { return 512 * 1024 * 1024, 2048 * 1024 * 1024, nil }
+	attachedAt := now
+	m.attachTime = func(string) time.Time { return attachedAt }
+	fsckCommand := joinCommand(PathToFsck, "-a", "/dev/sda1")
+	mountCommand := joinCommand(PathToMount, "-o", "noatime,nodev,nosuid,noexec", "/dev/sda1", m.mountPoint)
+	umountCommand := joinCommand(PathToUmount, "-l", m.mountPoint)
+
+	// Case for no USB drive
+	m.Check(client.StorageConfig{}, now)
+	assert.Nil(m.Status())
+	_, err = m.MountPoint()
+	assert.Equal(errNoUSBStorage, err)
+	m.Check(client.StorageConfig{StorageLabel: "JACKTRIP"}, now)
+	assert.Equal("USB drive JACKTRIP is not attached", m.Status().Error)
+
+	// Case for a USB drive that fails its filesystem check, which is not checked again
+	runner.Outputs[lsblkCommand] = `NAME="/dev/sda1" TYPE="part" HOTPLUG="1" FSTYPE="vfat" LABEL="JACKTRIP" UUID="1234-ABCD" MOUNTPOINT=""` + "\n"
+	runner.Errors[fsckCommand] = exitStatusError(4)
+	m.Check(client.StorageConfig{StorageLabel: "JACKTRIP"}, now)
+	assert.False(m.Status().Healthy)
+	assert.Equal("filesystem check failed: exit status 4", m.Status().Error)
+	runner.Commands = nil
+	m.Check(client.StorageConfig{StorageLabel: "JACKTRIP"}, now)
+	assert.Equal([]string{lsblkCommand}, runner.Commands)
+
+	// Case for a USB drive that is re-attached between checks, which is checked again
+	attachedAt = now.Add(time.Minute)
+	runner.Commands = nil
+	m.Check(client.StorageConfig{StorageLabel: "JACKTRIP"}, now)
+	assert.Equal([]string{lsblkCommand, fsckCommand}, runner.Commands)
+
+	// Case for another USB drive attached in its place, which is checked again
+	runner.Outputs[lsblkCommand] = `NAME="/dev/sda1" TYPE="part" HOTPLUG="1" FSTYPE="ext4" LABEL="JACKTRIP" UUID="0f6e2a1c" MOUNTPOINT=""` + "\n"
+	ext4FsckCommand := joinCommand(PathToFsck, "-n", "/dev/sda1")
+	runner.Errors[ext4FsckCommand] = errors.New("exit status 1")
+	runner.Commands = nil
+	m.Check(client.StorageConfig{StorageLabel: "JACKTRIP"}, now)
+	assert.Equal([]string{lsblkCommand, ext4FsckCommand}, runner.Commands)
+	assert.Equal("filesystem check failed: exit status 1", m.Status().Error)
+
+	// Case for mounting a USB drive after it is re-attached, whose dirty bit is repaired
+	runner.Outputs[lsblkCommand] = ""
+	m.Check(client.StorageConfig{}, now)
+	runner.Outputs[lsblkCommand] = `NAME="/dev/sda1" TYPE="part" HOTPLUG="1" FSTYPE="vfat" LABEL="JACKTRIP" UUID="1234-ABCD" MOUNTPOINT=""` + "\n"
+	runner.Errors[fsckCommand] = exitStatusError(fsckErrorsCorrected)
+	mounts := fmt.Sprintf("/dev/sda1 %s vfat rw,relatime 0 0\n", m.mountPoint)
+	assert.NoError(ioutil.WriteFile(m.mountsPath, []byte(mounts), 0644))
+	runner.Commands = nil
+	m.Check(client.StorageConfig{}, now)
+	assert.Equal([]string{lsblkCommand, fsckCommand, mountCommand}, runner.Commands)
+	status := m.Status()
+	assert.True(status.Healthy, status.Error)
+	assert.Equal(m.mountPoint, status.MountPoint)
+	assert.Equal(uint64(512), status.FreeSpace)
+	assert.Equal(uint64(2048), status.TotalSpace)
+	mountPoint, err := m.MountPoint()
+	assert.NoError(err)
+	assert.Equal(m.mountPoint, mountPoint)
+
+	// Case for a USB drive that was remounted read-only after errors
+	runner.Outputs[lsblkCommand] = fmt.Sprintf(`NAME="/dev/sda1" TYPE="part" HOTPLUG="1" FSTYPE="vfat" LABEL="JACKTRIP" UUID="1234-ABCD" MOUNTPOINT="%s"`, m.mountPoint)
+	mounts = fmt.Sprintf("/dev/sda1 %s vfat ro,relatime 0 0\n", m.mountPoint)
+	assert.NoError(ioutil.WriteFile(m.mountsPath, []byte(mounts), 0644))
+	m.Check(client.StorageConfig{}, now)
+	assert.False(m.Status().Healthy)
+	_, err = m.MountPoint()
+	assert.EqualError(err, "filesystem is mounted read-only")
+
+	// Case for a USB drive that is pulled out, which is unmounted lazily
+	runner.Outputs[lsblkCommand] = ""
+	runner.Commands = nil
+	m.Check(client.StorageConfig{}, now)
+	assert.Equal([]string{lsblkCommand, umountCommand}, runner.Commands)
+	assert.Nil(m.Status())
+	runner.Commands = nil
+	m.Check(client.StorageConfig{}, now)
+	assert.Equal([]string{lsblkCommand}, runner.Commands)
+}
@@ -0,0 +1,267 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+const (
+	// SCSynthServiceName is the name of the systemd service for the scsynth audio server
+	SCSynthServiceName = "scsynth.service"
+
+	// SupernovaServiceName is the name of the systemd service for the supernova audio server
+	SupernovaServiceName = "supernova.service"
+
+	// SCLangServiceName is the name of the systemd service that runs the mix code with sclang
+	SCLangServiceName = "sclang.service"
+
+	// SuperColliderClientName is the JACK client name used by the SuperCollider audio server
+	SuperColliderClientName = "SuperCollider"
+
+	// SuperColliderConfigTemplate is the template used to generate /tmp/default/supercollider file on audio servers
+	SuperColliderConfigTemplate = "SC_OPTS=%s\nSC_MIX_BRANCH=%s\nSC_MIX_DIR=%s\n"
+)
+
+// superColliderServiceNames are all the services used to run the SuperCollider mixer
+var superColliderServiceNames = []string{SCLangServiceName, SCSynthServiceName, SupernovaServiceName}
+
+// getMixSandboxLimits returns the limits of the services that run a studio's mix code, which may only use
+// the network to exchange OSC messages over loopback, and read the files the agent writes for them
+func getMixSandboxLimits(config client.ServerAgentConfig) common.SandboxLimits {
+	return common.SandboxLimits{CPUQuota: config.MixCPUQuota, MemoryMaxMB: config.MixMemoryMax, NoNetwork: true,
+		ConfigDir: ServiceConfigDir}
+}
+
+// writeSandboxDropIns writes the sandbox drop-ins of services and reloads systemd, so that they apply
+// the next time the services start
+func writeSandboxDropIns(write func(string, common.SandboxLimits) error, limits common.SandboxLimits, serviceNames ...string) error {
+	for _, name := range serviceNames {
+		if err := write(name, limits); err != nil {
+			return err
+		}
+	}
+	return reloadSystemd()
+}
+
+// getSCServerServiceName returns the service of the SuperCollider audio server used by a config
+func getSCServerServiceName(config client.ServerAgentConfig) string {
+	if client.GetSCServerType(config) == client.Supernova {
+		return SupernovaServiceName
+	}
+	return SCSynthServiceName
+}
+
+// getSuperColliderConfig returns the contents of the config file read by the SuperCollider services
+func getSuperColliderConfig(config client.ServerAgentConfig, mixDir string) string {
+	options := strings.Join(client.GetSCServerOptions(config).Args(), " ")
+	return fmt.Sprintf(SuperColliderConfigTemplate, options, config.MixBranch, mixDir)
+}
+
+// getSCLangStartup returns the sclang code that configures the mixer and then runs the mix code
+func getSCLangStartup(config client.ServerAgentConfig, code string) (string, error) {
+	scConfig, err := client.GetSCConfigSCLang(config)
+	if err != nil {
+		return "", err
+	}
+	return client.GetSCServerOptions(config).SCLang() + client.GetClientChannelsSCLang(config) +
+		client.GetChannelLayoutSCLang(config) + client.GetBroadcastMixSCLang(config) + scConfig + code + "\n", nil
+}
+
+// SuperColliderMixer writes the config of the SuperCollider services that mix a studio, and restarts them when it changes
+type SuperColliderMixer struct {
+	// Deployer tests new mix code before it replaces the running mix
+	Deployer *MixDeployer
+
+	// Cache fetches pinned revisions of the jacktrip-sc repository
+	Cache *common.MixCache
+
+	// Presets may replace the SCConfig of the server config
+	Presets *MixPresetStore
+
+	// sendOSC sends a message to the running sclang interpreter
+	sendOSC func(address string, args ...interface{}) error
+
+	// writeDropIn writes the systemd drop-in that sandboxes a service
+	writeDropIn func(serviceName string, limits common.SandboxLimits) error
+
+	config  client.ServerAgentConfig
+	code    string
+	limits  *common.SandboxLimits
+	running bool
+	lastErr string
+	mutex   sync.Mutex
+}
+
+// NewSuperColliderMixer constructs a new instance of SuperColliderMixer
+func NewSuperColliderMixer(presets *MixPresetStore) *SuperColliderMixer {
+	return &SuperColliderMixer{
+		Deployer:    NewMixDeployer(),
+		Cache:       common.NewMixCache(),
+		Presets:     presets,
+		sendOSC:     common.NewOSCClient("127.0.0.1", common.SuperColliderOSCPort).Send,
+		writeDropIn: common.WriteSandboxDropIn,
+	}
+}
+
+// Apply updates the SuperCollider services for a config, restarting them if their config changed; the running
+// mix is left alone if the config can't be applied
+func (m *SuperColliderMixer) Apply(ctx context.Context, config client.ServerAgentConfig) error {
+	// shadow tests can take a minute, so they run without holding the lock; a failed deploy keeps the
+	// previous mix code, but still applies the rest of the config
+	code, deployErr := m.Deployer.Deploy(config)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	err := m.apply(ctx, config, code, deployErr)
+	m.lastErr = ""
+	if err != nil {
+		m.lastErr = err.Error()
+	}
+	return err
+}
+
+// apply writes the config files of the SuperCollider services with deployed mix code; callers must hold the lock
+func (m *SuperColliderMixer) apply(ctx context.Context, config client.ServerAgentConfig, code string, deployErr error) error {
+	if client.GetMixEngine(config) != client.SuperColliderEngine {
+		m.config = config
+		if !m.running {
+			return nil
+		}
+		log.Info("Stopping SuperCollider")
+		m.running = false
+		return serviceManager.Stop(superColliderServiceNames...)
+	}
+
+	mixDir := ""
+	if config.MixRevision != "" {
+		dir, err := m.Cache.Get(ctx, config.MixRevision, config.MixChecksum)
+		if err != nil {
+			return fmt.Errorf("unable to fetch mix revision %s: %w", config.MixRevision, err)
+		}
+		mixDir = dir
+	}
+
+	config.SCConfig = m.Presets.GetSCConfig(config)
+	startup, err := getSCLangStartup(config, code)
+	if err != nil {
+		return err
+	}
+
+	configChanged, err := common.WriteFileIfChanged(PathToSuperColliderConfig, []byte(getSuperColliderConfig(config, mixDir)), 0644)
+	if err != nil {
+		return err
+	}
+	startupChanged, err := common.WriteFileIfChanged(PathToSCLangStartup, []byte(startup), 0644)
+	if err != nil {
+		return err
+	}
+	// new limits only apply when the services are restarted
+	if limits := getMixSandboxLimits(config); m.limits == nil || *m.limits != limits {
+		if err := writeSandboxDropIns(m.writeDropIn, limits, superColliderServiceNames...); err != nil {
+			return err
+		}
+		m.limits = &limits
+		m.running = false
+	}
+
+	live := !configChanged && startupChanged && m.running && m.updateLive(config, code)
+	if (configChanged || startupChanged || !m.running) && !live {
+		m.running = false
+		if err := restartSuperCollider(config); err != nil {
+			return err
+		}
+		m.running = true
+	}
+	m.config = config
+	m.code = code
+	return deployErr
+}
+
+// updateLive sends the SCConfig options that changed to the running mixer, returning false if anything
+// else changed or the options can't be updated without restarting it; callers must hold the lock
+func (m *SuperColliderMixer) updateLive(config client.ServerAgentConfig, code string) bool {
+	// the rest of the startup code must be unchanged
+	prev := m.config
+	prev.SCConfig = config.SCConfig
+	if code != m.code || prev != config {
+		return false
+	}
+	prevSC, err := client.ParseSCConfig(m.config.SCConfig)
+	if err != nil {
+		return false
+	}
+	nextSC, err := client.ParseSCConfig(config.SCConfig)
+	if err != nil {
+		return false
+	}
+	updates, ok := client.GetSCOptionUpdates(prevSC, nextSC)
+	if !ok {
+		return false
+	}
+	for _, update := range updates {
+		if err := m.sendOSC(client.SCOptionOSCAddress, update.OSCArgs()...); err != nil {
+			log.Error(err, "Unable to update mixer option, restarting", "link", update.Link, "option", update.Option)
+			return false
+		}
+	}
+	log.Info("Updated mixer options without restarting", "options", len(updates))
+	return true
+}
+
+// IsRunning returns true if the SuperCollider services were started for the latest config
+func (m *SuperColliderMixer) IsRunning() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.running
+}
+
+// Restart restarts the SuperCollider services with the latest config, ie. to recover when they stop responding
+func (m *SuperColliderMixer) Restart() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.running {
+		return nil
+	}
+	return restartSuperCollider(m.config)
+}
+
+// Error returns the error from the last config that could not be applied, for the MixCodeError of server heartbeats
+func (m *SuperColliderMixer) Error() string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.lastErr
+}
+
+// restartSuperCollider restarts the audio server used by a config, and then sclang, which connects to it
+func restartSuperCollider(config client.ServerAgentConfig) error {
+	log.Info("Restarting SuperCollider", "server", getSCServerServiceName(config))
+	if err := serviceManager.Stop(superColliderServiceNames...); err != nil {
+		return err
+	}
+	if err := serviceManager.Start(getSCServerServiceName(config)); err != nil {
+		return err
+	}
+	return serviceManager.Start(SCLangServiceName)
+}
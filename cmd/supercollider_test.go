@@ -0,0 +1,140 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSuperColliderConfig(t *testing.T) {
+	assert := assert.New(t)
+	config := client.ServerAgentConfig{MixBranch: "main"}
+	assert.Equal(SCSynthServiceName, getSCServerServiceName(config))
+	assert.Contains(getSuperColliderConfig(config, "/mix"), "SC_MIX_BRANCH=main\nSC_MIX_DIR=/mix\n")
+
+	startup, err := getSCLangStartup(config, "~mix.play;")
+	assert.Nil(err)
+	assert.Contains(startup, "~mix.play;\n")
+	assert.Contains(startup, "~channelLayout = \"stereo\";\n")
+}
+
+func TestSuperColliderMixer(t *testing.T) {
+	assert := assert.New(t)
+	defer func(prevServices ServiceManager, prevRunner SystemRunner, dir string) {
+		serviceManager, systemRunner, ServiceConfigDir = prevServices, prevRunner, dir
+		updatePaths()
+	}(serviceManager, systemRunner, ServiceConfigDir)
+	services := NewFakeServiceManager()
+	serviceManager = services
+	systemRunner = NewFakeRunner()
+	ServiceConfigDir = t.TempDir()
+	updatePaths()
+
+	mixer := NewSuperColliderMixer(&MixPresetStore{})
+	dropIns := map[string]common.SandboxLimits{}
+	mixer.writeDropIn = func(serviceName string, limits common.SandboxLimits) error {
+		dropIns[serviceName] = limits
+		return nil
+	}
+	mixer.Deployer.Test = func(code string, sampleRate int, limits common.SandboxLimits) error {
+		if code == "broken" {
+			return errors.New("syntax error")
+		}
+		return nil
+	}
+	ctx := context.Background()
+
+	// Case for the first config, which starts the audio server and then sclang
+	config := client.ServerAgentConfig{MixCode: "good"}
+	assert.Nil(mixer.Apply(ctx, config))
+	assert.Equal([]string{"start " + SCSynthServiceName, "start " + SCLangServiceName}, services.Events)
+	raw, err := ioutil.ReadFile(PathToSCLangStartup)
+	assert.Nil(err)
+	assert.Contains(string(raw), "good\n")
+	assert.True(mixer.IsRunning())
+	assert.Len(dropIns, 3)
+	assert.Equal(common.SandboxLimits{NoNetwork: true, ConfigDir: ServiceConfigDir}, dropIns[SCLangServiceName])
+
+	// Case for a restart to recover from failures
+	services.Events = nil
+	assert.Nil(mixer.Restart())
+	assert.Equal([]string{"stop " + SCLangServiceName, "stop " + SCSynthServiceName,
+		"start " + SCSynthServiceName, "start " + SCLangServiceName}, services.Events)
+
+	// Case for an unchanged config, which leaves the mixer running
+	services.Events = nil
+	assert.Nil(mixer.Apply(ctx, config))
+	assert.Empty(services.Events)
+
+	// Case for a changed option, which is sent to the running mixer
+	var sent [][]interface{}
+	mixer.sendOSC = func(address string, args ...interface{}) error {
+		assert.Equal(client.SCOptionOSCAddress, address)
+		sent = append(sent, args)
+		return nil
+	}
+	config.SCConfig = `{"links": [{"class": "Compressor", "options": {"threshold": -12}}]}`
+	assert.Nil(mixer.Apply(ctx, config))
+	assert.Equal([]string{"stop " + SCLangServiceName, "stop " + SCSynthServiceName,
+		"start " + SCSynthServiceName, "start " + SCLangServiceName}, services.Events)
+	services.Events = nil
+	config.SCConfig = `{"links": [{"class": "Compressor", "options": {"threshold": -6}}]}`
+	assert.Nil(mixer.Apply(ctx, config))
+	assert.Empty(services.Events)
+	assert.Equal([][]interface{}{{0, "threshold", float32(-6)}}, sent)
+	raw, err = ioutil.ReadFile(PathToSCLangStartup)
+	assert.Nil(err)
+	assert.Contains(string(raw), "threshold: -6")
+
+	// Case for a new link, which restarts the mixer
+	config.SCConfig = `{"links": [{"class": "Compressor", "options": {"threshold": -6}}, {"class": "Limiter"}]}`
+	assert.Nil(mixer.Apply(ctx, config))
+	assert.Len(sent, 1)
+	assert.Contains(services.Events, "start "+SCLangServiceName)
+	services.Events = nil
+
+	// Case for new limits, which restart the mixer
+	config.MixMemoryMax = 512
+	assert.Nil(mixer.Apply(ctx, config))
+	assert.Equal(512, dropIns[SCSynthServiceName].MemoryMaxMB)
+	assert.Contains(services.Events, "start "+SCLangServiceName)
+	services.Events = nil
+
+	// Case for broken mix code, which keeps the previous code and reports the error
+	config.MixCode = "broken"
+	assert.NotNil(mixer.Apply(ctx, config))
+	assert.Equal("syntax error", mixer.Error())
+	assert.Empty(services.Events)
+	raw, err = ioutil.ReadFile(PathToSCLangStartup)
+	assert.Nil(err)
+	assert.Contains(string(raw), "good\n")
+
+	// Case for switching to the Faust engine, which stops SuperCollider
+	services.Events = nil
+	config.MixEngine = client.FaustEngine
+	assert.Nil(mixer.Apply(ctx, config))
+	assert.Equal([]string{"stop " + SCLangServiceName, "stop " + SCSynthServiceName}, services.Events)
+	assert.False(mixer.IsRunning())
+}
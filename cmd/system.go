@@ -0,0 +1,151 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+)
+
+const (
+	// TmpfsMagic is the filesystem type reported by statfs for tmpfs mounts
+	TmpfsMagic = 0x01021994
+
+	// TmpfsOptions are the mount options used for directories of ephemeral files
+	TmpfsOptions = "mode=0755,size=16m"
+)
+
+// AlsaProvider reads and updates ALSA sound cards
+type AlsaProvider interface {
+	// CaptureDevices returns the list of capture devices, formatted like `arecord -l`
+	CaptureDevices() (string, error)
+
+	// PlaybackDevices returns the list of playback devices, formatted like `aplay -l`
+	PlaybackDevices() (string, error)
+
+	// Cards returns the list of sound cards, formatted like /proc/asound/cards
+	Cards() (string, error)
+
+	// Stream0 returns the stream info for a USB sound card, formatted like /proc/asound/cardN/stream0
+	Stream0(card int) (string, error)
+
+	// Controls returns the mixer controls of a card, formatted like `amixer controls`
+	Controls(card int) (string, error)
+
+	// SetControl updates the value of a mixer control
+	SetControl(card int, control, value string) error
+
+	// StoreState saves the state of a device (or all devices, if empty) to a file
+	StoreState(device, file string) error
+
+	// RestoreState restores the state of a device (or all devices, if empty) from a file
+	RestoreState(device, file string) error
+}
+
+// alsaProvider is used for all access to sound cards
+var alsaProvider AlsaProvider = systemAlsa{}
+
+// systemAlsa accesses ALSA using alsa-utils and procfs
+type systemAlsa struct{}
+
+// CaptureDevices returns the list of capture devices, formatted like `arecord -l`
+func (systemAlsa) CaptureDevices() (string, error) {
+	out, err := systemRunner.Output("arecord", "-l")
+	return string(out), err
+}
+
+// PlaybackDevices returns the list of playback devices, formatted like `aplay -l`
+func (systemAlsa) PlaybackDevices() (string, error) {
+	out, err := systemRunner.Output("aplay", "-l")
+	return string(out), err
+}
+
+// Cards returns the list of sound cards, formatted like /proc/asound/cards
+func (systemAlsa) Cards() (string, error) {
+	out, err := ioutil.ReadFile(PathToAsoundCards)
+	return string(out), err
+}
+
+// Stream0 returns the stream info for a USB sound card, formatted like /proc/asound/cardN/stream0
+func (systemAlsa) Stream0(card int) (string, error) {
+	out, err := ioutil.ReadFile(fmt.Sprintf("/proc/asound/card%d/stream0", card))
+	return string(out), err
+}
+
+// Controls returns the mixer controls of a card, formatted like `amixer controls`
+func (systemAlsa) Controls(card int) (string, error) {
+	out, err := systemRunner.Output("/usr/bin/amixer", "-c", fmt.Sprintf("%d", card), "controls")
+	return string(out), err
+}
+
+// SetControl updates the value of a mixer control
+func (systemAlsa) SetControl(card int, control, value string) error {
+	_, err := systemRunner.Output("/usr/bin/amixer", "-c", fmt.Sprintf("%d", card), "cset", fmt.Sprintf("name='%s'", control), "--", value)
+	return err
+}
+
+// alsactlArgs returns the arguments used to store or restore ALSA state
+func alsactlArgs(command, device, file string) []string {
+	args := []string{command, "--file", file}
+	if device != "" {
+		args = append(args, device)
+	}
+	return args
+}
+
+// StoreState saves the state of a device (or all devices, if empty) to a file
+func (systemAlsa) StoreState(device, file string) error {
+	_, err := systemRunner.Output("/usr/sbin/alsactl", alsactlArgs("store", device, file)...)
+	return err
+}
+
+// RestoreState restores the state of a device (or all devices, if empty) from a file
+func (systemAlsa) RestoreState(device, file string) error {
+	_, err := systemRunner.Output("/usr/sbin/alsactl", alsactlArgs("restore", device, file)...)
+	return err
+}
+
+// isTmpfs returns true if a path is on a tmpfs mount
+func isTmpfs(path string) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return false
+	}
+	return int64(stat.Type) == TmpfsMagic
+}
+
+// mountTmpfs mounts a tmpfs on each directory that is not already in memory, so that
+// frequently rewritten ephemeral files never wear out the SD card
+func mountTmpfs(dirs ...string) {
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Error(err, "Failed to create directory", "path", dir)
+			continue
+		}
+		if isTmpfs(dir) {
+			continue
+		}
+		if _, err := runPrivileged("/bin/mount", "-t", "tmpfs", "-o", TmpfsOptions, "tmpfs", dir); err != nil {
+			log.Error(err, "Failed to mount tmpfs", "path", dir)
+			continue
+		}
+		log.Info("Mounted tmpfs for ephemeral files", "path", dir)
+	}
+}
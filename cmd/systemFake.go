@@ -0,0 +1,110 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FakeAlsa serves sound card information from memory, and records control changes
+type FakeAlsa struct {
+	CaptureList  string
+	PlaybackList string
+	CardList     string
+	Streams      map[int]string
+	ControlLists map[int]string
+	Values       map[string]string
+	States       map[string]bool
+	mutex        sync.Mutex
+}
+
+// NewFakeAlsa constructs a new instance of FakeAlsa with no sound cards
+func NewFakeAlsa() *FakeAlsa {
+	return &FakeAlsa{
+		Streams:      map[int]string{},
+		ControlLists: map[int]string{},
+		Values:       map[string]string{},
+		States:       map[string]bool{},
+	}
+}
+
+// CaptureDevices returns the list of capture devices
+func (f *FakeAlsa) CaptureDevices() (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.CaptureList, nil
+}
+
+// PlaybackDevices returns the list of playback devices
+func (f *FakeAlsa) PlaybackDevices() (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.PlaybackList, nil
+}
+
+// Cards returns the list of sound cards
+func (f *FakeAlsa) Cards() (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.CardList, nil
+}
+
+// Stream0 returns the stream info for a sound card
+func (f *FakeAlsa) Stream0(card int) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	stream, ok := f.Streams[card]
+	if !ok {
+		return "", fmt.Errorf("card %d has no stream0", card)
+	}
+	return stream, nil
+}
+
+// Controls returns the mixer controls of a card
+func (f *FakeAlsa) Controls(card int) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.ControlLists[card], nil
+}
+
+// SetControl records the value of a mixer control
+func (f *FakeAlsa) SetControl(card int, control, value string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.Values[fmt.Sprintf("%d:%s", card, control)] = value
+	return nil
+}
+
+// StoreState records that a device state was saved
+func (f *FakeAlsa) StoreState(device, file string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.States[file] = true
+	return nil
+}
+
+// RestoreState fails if a device state was never saved
+func (f *FakeAlsa) RestoreState(device, file string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.States[file] {
+		return fmt.Errorf("no saved state in %s", file)
+	}
+	return nil
+}
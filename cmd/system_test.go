@@ -0,0 +1,171 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/xthexder/go-jack"
+)
+
+func TestSystemAlsa(t *testing.T) {
+	assert := assert.New(t)
+	runner := NewFakeRunner()
+	defer func(prev SystemRunner) { systemRunner = prev }(systemRunner)
+	systemRunner = runner
+
+	runner.Outputs["arecord -l"] = "card 1: USB [USB Audio], device 0: USB Audio [USB Audio]"
+	out, err := systemAlsa{}.CaptureDevices()
+	assert.NoError(err)
+	assert.Equal(map[string]bool{"USB": true}, extractNames(out))
+
+	runner.Errors["aplay -l"] = errors.New("exit status 1")
+	_, err = systemAlsa{}.PlaybackDevices()
+	assert.Error(err)
+
+	assert.NoError(systemAlsa{}.SetControl(1, "Mic Capture Volume", "50%"))
+	assert.NoError(systemAlsa{}.StoreState("USB", "/tmp/usb.state"))
+	assert.NoError(systemAlsa{}.RestoreState("", "/tmp/asound.state"))
+	assert.Equal([]string{
+		"arecord -l",
+		"aplay -l",
+		"/usr/bin/amixer -c 1 cset name='Mic Capture Volume' -- 50%",
+		"/usr/sbin/alsactl store --file /tmp/usb.state USB",
+		"/usr/sbin/alsactl restore --file /tmp/asound.state",
+	}, runner.Commands)
+}
+
+func TestRestartAllServices(t *testing.T) {
+	assert := assert.New(t)
+	services := NewFakeServiceManager()
+	defer func(prev ServiceManager) { serviceManager = prev }(serviceManager)
+	serviceManager = services
+	graph := NewFakeJackGraph("jackd")
+	graph.RegisterPort("system:capture_1", jack.PortIsOutput)
+	defer func(prev func(string, jack.PortRegistrationCallback, jack.ShutdownCallback) (JackGraph, error)) {
+		openJackGraph = prev
+	}(openJackGraph)
+	openJackGraph = func(string, jack.PortRegistrationCallback, jack.ShutdownCallback) (JackGraph, error) {
+		return graph, nil
+	}
+
+	config := client.DeviceAgentConfig{}
+	config.Enabled = true
+	config.Type = client.JackTrip
+	config.Metronome = true
+	config.Timecode = client.TimecodeLTC
+	restartAllServices(config)
+	assert.Equal([]string{"start " + JackServiceName, "start " + JackTripServiceName, "start " + MetronomeServiceName,
+		"start " + TimecodeServiceName}, services.Events)
+
+	// restarting stops the running services before starting new ones
+	services.Events = nil
+	config.Type = client.Jamulus
+	config.Metronome = false
+	config.Timecode = client.TimecodeOff
+	restartAllServices(config)
+	assert.Equal("stop "+JackServiceName, services.Events[0])
+	assert.True(services.IsActive(JamulusServiceName))
+	assert.False(services.IsActive(JackTripServiceName))
+	assert.False(services.IsActive(MetronomeServiceName))
+	assert.False(services.IsActive(TimecodeServiceName))
+
+	// disabled devices only stop services
+	config.Enabled = false
+	restartAllServices(config)
+	assert.False(services.IsActive(JackServiceName))
+	assert.False(services.IsActive(JamulusServiceName))
+
+	// ports held by other processes leave services stopped
+	conn, err := net.ListenPacket("udp", ":0")
+	assert.NoError(err)
+	defer conn.Close()
+	config.Enabled = true
+	config.Type = client.JackTrip
+	config.DevicePort = conn.LocalAddr().(*net.UDPAddr).Port
+	err = restartAllServices(config)
+	assert.IsType(&PortConflict{}, err)
+	assert.False(services.IsActive(JackServiceName))
+	assert.False(services.IsActive(JackTripServiceName))
+	conn.Close()
+	assert.NoError(restartAllServices(config))
+	assert.True(services.IsActive(JackTripServiceName))
+	config.DevicePort = 0
+
+	// missing services cause a panic
+	serviceManager = NewFakeServiceManager(JackServiceName)
+	config.Enabled = true
+	assert.Panics(func() { restartAllServices(config) })
+}
+
+func TestDeviceDiscoveryWithFakeAlsa(t *testing.T) {
+	assert := assert.New(t)
+	alsa := NewFakeAlsa()
+	defer func(prev AlsaProvider) { alsaProvider = prev }(alsaProvider)
+	alsaProvider = alsa
+
+	alsa.CardList = " 0 [Headphones     ]: bcm2835_headpho - bcm2835 Headphones\n 1 [USB            ]: USB-Audio - USB Audio Device\n"
+	alsa.Streams[1] = "Capture:\n  Status: Stop\n"
+	assert.Equal(map[string]int{"Headphones": 0, "USB": 1}, getDeviceToNumMappings())
+	assert.Equal([]string{"Capture:", "  Status: Stop", ""}, readCardStream0(1))
+	assert.Nil(readCardStream0(2))
+
+	assert.Error(alsa.RestoreState("USB", "/tmp/usb.state"))
+	assert.NoError(alsa.StoreState("USB", "/tmp/usb.state"))
+	assert.NoError(alsa.RestoreState("USB", "/tmp/usb.state"))
+}
+
+func TestMountTmpfs(t *testing.T) {
+	assert := assert.New(t)
+	runner := NewFakeRunner()
+	defer func(prev SystemRunner) { systemRunner = prev }(systemRunner)
+	systemRunner = runner
+
+	dir := filepath.Join(t.TempDir(), "default")
+	mountTmpfs(dir)
+	_, err := os.Stat(dir)
+	assert.Nil(err)
+	if isTmpfs(dir) {
+		assert.Empty(runner.Commands)
+	} else {
+		assert.Equal([]string{"/bin/mount -t tmpfs -o mode=0755,size=16m tmpfs " + dir}, runner.Commands)
+	}
+}
+
+func TestStoreAlsaStates(t *testing.T) {
+	assert := assert.New(t)
+	alsa := NewFakeAlsa()
+	defer func(prev AlsaProvider, dir string) {
+		alsaProvider, ServiceConfigDir = prev, dir
+		updatePaths()
+	}(alsaProvider, ServiceConfigDir)
+	alsaProvider = alsa
+	ServiceConfigDir = t.TempDir()
+	updatePaths()
+
+	// devices used for capture and playback are stored once, and devices without a card are skipped
+	dmm := DeviceMixingManager{DeviceCardMapping: map[string]int{"USB": 1}}
+	dmm.storeAlsaStates([]string{"USB", "USB", "Missing"})
+	assert.Equal(map[string]bool{filepath.Join(ServiceConfigDir, "asound-USB.state"): true}, alsa.States)
+}
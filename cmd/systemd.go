@@ -0,0 +1,236 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+// SystemRunner runs external commands
+type SystemRunner interface {
+	// Output runs a command and returns its standard output
+	Output(name string, args ...string) ([]byte, error)
+
+	// OutputWithInput runs a command with the given standard input and returns its standard output
+	OutputWithInput(input string, name string, args ...string) ([]byte, error)
+}
+
+// ServiceManager controls systemd services
+type ServiceManager interface {
+	// Start starts a service and waits for it to finish starting
+	Start(name string) error
+
+	// Stop stops any of the services that are active, and waits for them to finish stopping
+	Stop(names ...string) error
+
+	// Kill sends SIGKILL to all processes of a service
+	Kill(name string)
+
+	// Missing returns the services that are not installed
+	Missing(names ...string) ([]string, error)
+
+	// Watch sends the sub state of services whenever it changes, until the context is cancelled
+	Watch(ctx context.Context, updates chan<- ServiceStateUpdate) error
+}
+
+// SystemctlPath is the path to the systemd control tool
+const SystemctlPath = "/bin/systemctl"
+
+// NFTPath is the path to the nftables command
+const NFTPath = "nft"
+
+// ServiceFailed is the systemd sub state of a service that exited with an error
+const ServiceFailed = "failed"
+
+// ServiceStateUpdate describes a change to the state of a systemd service
+type ServiceStateUpdate struct {
+	// name of the service (ie. "jack.service")
+	Name string
+
+	// systemd sub state of the service (ie. "running" or "failed")
+	SubState string
+}
+
+// systemRunner and serviceManager are used for all access to the host system
+var systemRunner SystemRunner = execRunner{}
+var serviceManager ServiceManager = systemdManager{}
+
+// execRunner runs commands using os/exec
+type execRunner struct{}
+
+// Output runs a command and returns its standard output
+func (execRunner) Output(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+// OutputWithInput runs a command with the given standard input and returns its standard output
+func (execRunner) OutputWithInput(input string, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	return cmd.Output()
+}
+
+// systemdManager controls systemd services over dbus
+type systemdManager struct{}
+
+// Start starts a service and waits for it to finish starting
+func (systemdManager) Start(name string) error {
+	conn, err := dbus.New()
+	if err != nil {
+		log.Error(err, "Failed to connect to dbus")
+		return err
+	}
+	defer conn.Close()
+	return startService(conn, name)
+}
+
+// Stop stops any of the services that are active, and waits for them to finish stopping
+func (systemdManager) Stop(names ...string) error {
+	conn, err := dbus.New()
+	if err != nil {
+		log.Error(err, "Failed to connect to dbus")
+		return err
+	}
+	defer conn.Close()
+
+	units, err := conn.ListUnitsByNames(names)
+	if err != nil {
+		log.Error(err, "Failed to get status of managed services")
+		return err
+	}
+	for _, u := range units {
+		if err := stopService(conn, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Kill sends SIGKILL to all processes of a service
+func (systemdManager) Kill(name string) {
+	conn, err := dbus.New()
+	if err != nil {
+		log.Error(err, "Failed to connect to dbus")
+		return
+	}
+	defer conn.Close()
+	conn.KillUnit(name, 9)
+}
+
+// Missing returns the services that are not installed
+func (systemdManager) Missing(names ...string) ([]string, error) {
+	conn, err := dbus.New()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	units, err := conn.ListUnitsByNames(names)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, u := range units {
+		if u.LoadState == "not-found" {
+			missing = append(missing, u.Name)
+		}
+	}
+	return missing, nil
+}
+
+// Watch subscribes to systemd unit changes over dbus, and sends the sub state of services whenever it changes
+func (systemdManager) Watch(ctx context.Context, updates chan<- ServiceStateUpdate) error {
+	conn, err := dbus.New()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.Subscribe(); err != nil {
+		return err
+	}
+	defer conn.Unsubscribe()
+
+	subStates := make(chan *dbus.SubStateUpdate, 100)
+	errs := make(chan error, 10)
+	conn.SetSubStateSubscriber(subStates, errs)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case u := <-subStates:
+			updates <- ServiceStateUpdate{Name: u.UnitName, SubState: u.SubState}
+		case err := <-errs:
+			log.Error(err, "Failed to receive systemd unit changes")
+		}
+	}
+}
+
+// reloadSystemd reloads systemd unit files, so that new drop-ins apply the next time services start
+func reloadSystemd() error {
+	_, err := systemRunner.Output(SystemctlPath, "daemon-reload")
+	return err
+}
+
+// stopService is used to stop a managed systemd service
+func stopService(conn *dbus.Conn, u dbus.UnitStatus) error {
+	if u.ActiveState == "inactive" {
+		return nil
+	}
+
+	log.Info("Stopping managed service", "service", u.Name)
+
+	reschan := make(chan string)
+	_, err := conn.StopUnit(u.Name, "replace", reschan)
+	if err != nil {
+		return fmt.Errorf("failed to stop %s: job status=%s", u.Name, err.Error())
+	}
+
+	jobStatus := <-reschan
+	if jobStatus != "done" {
+		return fmt.Errorf("failed to stop %s: job status=%s", u.Name, jobStatus)
+	}
+
+	log.Info("Finished stopping managed service", "name", u.Name)
+	return nil
+}
+
+// startService is used to start a managed systemd service
+func startService(conn *dbus.Conn, name string) error {
+	log.Info("Starting managed service", "name", name)
+
+	reschan := make(chan string)
+	_, err := conn.StartUnit(name, "replace", reschan)
+
+	if err != nil {
+		return fmt.Errorf("failed to start %s: job status=%s", name, err.Error())
+	}
+
+	jobStatus := <-reschan
+	if jobStatus != "done" {
+		return fmt.Errorf("failed to start %s: job status=%s", name, jobStatus)
+	}
+	log.Info("Finished starting managed service", "name", name)
+	return nil
+}
+
+// joinCommand formats a command and its arguments as a single string
+func joinCommand(name string, args ...string) string {
+	return strings.TrimSpace(name + " " + strings.Join(args, " "))
+}
@@ -0,0 +1,159 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// FakeRunner records commands instead of running them, returning scripted outputs
+type FakeRunner struct {
+	Outputs  map[string]string
+	Errors   map[string]error
+	Commands []string
+	Inputs   []string
+	mutex    sync.Mutex
+}
+
+// NewFakeRunner constructs a new instance of FakeRunner
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{Outputs: map[string]string{}, Errors: map[string]error{}}
+}
+
+// Output records a command and returns its scripted output
+func (f *FakeRunner) Output(name string, args ...string) ([]byte, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	command := joinCommand(name, args...)
+	f.Commands = append(f.Commands, command)
+	return []byte(f.Outputs[command]), f.Errors[command]
+}
+
+// OutputWithInput records a command and its input, and returns its scripted output
+func (f *FakeRunner) OutputWithInput(input string, name string, args ...string) ([]byte, error) {
+	f.mutex.Lock()
+	f.Inputs = append(f.Inputs, input)
+	f.mutex.Unlock()
+	return f.Output(name, args...)
+}
+
+// FakeServiceManager keeps track of services in memory
+type FakeServiceManager struct {
+	Installed map[string]bool
+	Active    map[string]bool
+	Events    []string
+	watchers  []chan<- ServiceStateUpdate
+	mutex     sync.Mutex
+}
+
+// NewFakeServiceManager constructs a new instance of FakeServiceManager; all services are installed if none are given
+func NewFakeServiceManager(installed ...string) *FakeServiceManager {
+	f := &FakeServiceManager{Active: map[string]bool{}}
+	if len(installed) > 0 {
+		f.Installed = map[string]bool{}
+		for _, name := range installed {
+			f.Installed[name] = true
+		}
+	}
+	return f
+}
+
+// isInstalled returns true if a service is installed
+func (f *FakeServiceManager) isInstalled(name string) bool {
+	return f.Installed == nil || f.Installed[name]
+}
+
+// Start marks a service as active
+func (f *FakeServiceManager) Start(name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.isInstalled(name) {
+		return fmt.Errorf("failed to start %s: job status=failed", name)
+	}
+	f.Active[name] = true
+	f.Events = append(f.Events, "start "+name)
+	return nil
+}
+
+// Stop marks any active services as inactive
+func (f *FakeServiceManager) Stop(names ...string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, name := range names {
+		if f.Active[name] {
+			delete(f.Active, name)
+			f.Events = append(f.Events, "stop "+name)
+		}
+	}
+	return nil
+}
+
+// Kill marks a service as inactive
+func (f *FakeServiceManager) Kill(name string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.Active, name)
+	f.Events = append(f.Events, "kill "+name)
+}
+
+// Missing returns the services that are not installed
+func (f *FakeServiceManager) Missing(names ...string) ([]string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var missing []string
+	for _, name := range names {
+		if !f.isInstalled(name) {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
+
+// Watch sends service failures simulated with Fail, until the context is cancelled
+func (f *FakeServiceManager) Watch(ctx context.Context, updates chan<- ServiceStateUpdate) error {
+	f.mutex.Lock()
+	f.watchers = append(f.watchers, updates)
+	f.mutex.Unlock()
+	<-ctx.Done()
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i, w := range f.watchers {
+		if w == updates {
+			f.watchers = append(f.watchers[:i], f.watchers[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Fail marks a service as inactive and notifies watchers that it failed, as if it crashed
+func (f *FakeServiceManager) Fail(name string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.Active, name)
+	f.Events = append(f.Events, "fail "+name)
+	for _, w := range f.watchers {
+		w <- ServiceStateUpdate{Name: name, SubState: ServiceFailed}
+	}
+}
+
+// IsActive returns true if a service is active
+func (f *FakeServiceManager) IsActive(name string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.Active[name]
+}
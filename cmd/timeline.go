@@ -0,0 +1,280 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// SessionTimelineSize is the maximum number of session events that are kept
+	SessionTimelineSize = 500
+
+	// XrunSpikeThreshold is the minimum number of xruns between metrics samples that is recorded as a spike
+	XrunSpikeThreshold = 10
+)
+
+// SessionEventType is used to determine the type of a session event
+type SessionEventType string
+
+const (
+	// SessionConnected means the device connected to a studio
+	SessionConnected SessionEventType = "connect"
+
+	// SessionDisconnected means the device disconnected from a studio
+	SessionDisconnected SessionEventType = "disconnect"
+
+	// SessionStandby means the device switched between its studio server and a standby server
+	SessionStandby SessionEventType = "standby"
+
+	// SessionQueueAdjusted means the jitter queue was tuned automatically
+	SessionQueueAdjusted SessionEventType = "queue"
+
+	// SessionConfigChanged means a new device config was applied
+	SessionConfigChanged SessionEventType = "config"
+
+	// SessionStatusChanged means a subsystem reported a new status
+	SessionStatusChanged SessionEventType = "status"
+
+	// SessionXrunSpike means JACK reported a burst of xruns
+	SessionXrunSpike SessionEventType = "xruns"
+)
+
+// SessionEvent is a single entry in the session timeline
+type SessionEvent struct {
+	Type      SessionEventType `json:"type"`
+	Timestamp time.Time        `json:"timestamp"`
+	Host      string           `json:"host,omitempty"`
+	Subsystem Subsystem        `json:"subsystem,omitempty"`
+	Status    string           `json:"status,omitempty"`
+	Xruns     int              `json:"xruns,omitempty"`
+	Queue     int              `json:"queue,omitempty"`
+}
+
+// SessionTimeline keeps the most recent session events in memory and in a ring file on disk
+type SessionTimeline struct {
+	// Size is the maximum number of events that are kept
+	Size int
+
+	events []SessionEvent
+	lines  int
+	mutex  sync.Mutex
+}
+
+// NewSessionTimeline constructs a new instance of SessionTimeline
+func NewSessionTimeline(size int) *SessionTimeline {
+	return &SessionTimeline{Size: size}
+}
+
+// trim drops the oldest events beyond the size of the timeline; callers must hold the lock
+func (t *SessionTimeline) trim() {
+	if len(t.events) > t.Size {
+		t.events = append([]SessionEvent{}, t.events[len(t.events)-t.Size:]...)
+	}
+}
+
+// Load reads events that were previously saved to the timeline file
+func (t *SessionTimeline) Load() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	rawBytes, err := ioutil.ReadFile(PathToSessionTimeline)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	t.events, t.lines = nil, 0
+	scanner := bufio.NewScanner(bytes.NewReader(rawBytes))
+	for scanner.Scan() {
+		t.lines++
+		var event SessionEvent
+		// skip lines that were only partially written before a power loss
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		t.events = append(t.events, event)
+	}
+	t.trim()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	// rewrite a partially written last line, so that new events are not appended to it
+	if len(rawBytes) > 0 && rawBytes[len(rawBytes)-1] != '\n' {
+		return t.compact()
+	}
+	return nil
+}
+
+// Record adds an event to the timeline, and appends it to the timeline file
+// NOTE: the file is compacted once it holds twice as many lines as the timeline, to limit writes
+func (t *SessionTimeline) Record(event SessionEvent) error {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.events = append(t.events, event)
+	t.trim()
+
+	if t.lines+1 > 2*t.Size {
+		return t.compact()
+	}
+	if err := os.MkdirAll(filepath.Dir(PathToSessionTimeline), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(PathToSessionTimeline, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	t.lines++
+	return nil
+}
+
+// compact rewrites the timeline file with only the events held in memory; callers must hold the lock
+func (t *SessionTimeline) compact() error {
+	var buf bytes.Buffer
+	for _, event := range t.events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	tmpPath := PathToSessionTimeline + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, PathToSessionTimeline); err != nil {
+		return err
+	}
+	t.lines = len(t.events)
+	return nil
+}
+
+// Clear forgets all events held in memory, after the timeline file was removed
+func (t *SessionTimeline) Clear() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.events, t.lines = nil, 0
+}
+
+// Events returns the events recorded at or after a given time, oldest first
+func (t *SessionTimeline) Events(since time.Time) []SessionEvent {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	events := []SessionEvent{}
+	for _, event := range t.events {
+		if !event.Timestamp.Before(since) {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// isSessionConnected checks if a device config connects the device to a studio
+func isSessionConnected(config client.DeviceAgentConfig) bool {
+	return bool(config.Enabled) && config.Host != ""
+}
+
+// getSessionEvents converts a state event into session events, given the previous device config
+func getSessionEvents(last client.DeviceAgentConfig, event StateEvent, now time.Time) []SessionEvent {
+	switch event.Type {
+	case ConfigChanged:
+		events := []SessionEvent{{Type: SessionConfigChanged, Timestamp: now, Host: event.Config.Host}}
+		wasConnected, isConnected := isSessionConnected(last), isSessionConnected(event.Config)
+		if wasConnected && (!isConnected || last.Host != event.Config.Host) {
+			events = append(events, SessionEvent{Type: SessionDisconnected, Timestamp: now, Host: last.Host})
+		}
+		if isConnected && (!wasConnected || last.Host != event.Config.Host) {
+			events = append(events, SessionEvent{Type: SessionConnected, Timestamp: now, Host: event.Config.Host})
+		}
+		return events
+	case StatusChanged:
+		return []SessionEvent{{Type: SessionStatusChanged, Timestamp: now, Subsystem: event.Subsystem, Status: event.Status}}
+	}
+	return nil
+}
+
+// RecordXruns records a spike if the number of xruns grew by at least XrunSpikeThreshold since the last sample
+func (t *SessionTimeline) RecordXruns(last, current int) {
+	if current-last < XrunSpikeThreshold {
+		return
+	}
+	if err := t.Record(SessionEvent{Type: SessionXrunSpike, Xruns: current - last}); err != nil {
+		log.Error(err, "Failed to record session event", "path", PathToSessionTimeline)
+	}
+}
+
+// Run records device state changes in the timeline until the context is cancelled
+func (t *SessionTimeline) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	log.Info("Starting session timeline")
+	events := deviceState.Subscribe()
+	defer deviceState.Unsubscribe(events)
+	last := deviceState.Config()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping session timeline")
+			return
+		case event := <-events:
+			for _, sessionEvent := range getSessionEvents(last, event, time.Now()) {
+				if err := t.Record(sessionEvent); err != nil {
+					log.Error(err, "Failed to record session event", "path", PathToSessionTimeline)
+				}
+			}
+			if event.Type == ConfigChanged {
+				last = event.Config
+			}
+		}
+	}
+}
+
+// handleSessionEventsRequest returns the session timeline, optionally limited to events since an RFC 3339 time
+func handleSessionEventsRequest(timeline *SessionTimeline, w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid since"})
+			return
+		}
+	}
+	RespondJSON(w, http.StatusOK, timeline.Events(since))
+}
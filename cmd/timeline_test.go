@@ -0,0 +1,164 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionTimeline(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "jacktrip-agent")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	originalDir := AgentLibDir
+	defer func() { AgentLibDir = originalDir; updatePaths() }()
+	AgentLibDir = dir
+	updatePaths()
+
+	// Missing file should load an empty timeline
+	timeline := NewSessionTimeline(3)
+	assert.Nil(timeline.Load())
+	assert.Empty(timeline.Events(time.Time{}))
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		assert.Nil(timeline.Record(SessionEvent{Type: SessionXrunSpike, Xruns: i, Timestamp: start.Add(time.Duration(i) * time.Second)}))
+	}
+	events := timeline.Events(time.Time{})
+	assert.Len(events, 3)
+	assert.Equal(1, events[0].Xruns)
+	assert.Equal(3, events[2].Xruns)
+	assert.Len(timeline.Events(start.Add(3*time.Second)), 1)
+
+	// Events should survive a restart, ignoring partially written lines
+	f, err := os.OpenFile(PathToSessionTimeline, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(err)
+	f.Write([]byte(`{"type":"xr`))
+	f.Close()
+	loaded := NewSessionTimeline(3)
+	assert.Nil(loaded.Load())
+	loadedEvents := loaded.Events(time.Time{})
+	assert.Len(loadedEvents, 3)
+	for i, event := range loadedEvents {
+		assert.Equal(events[i].Xruns, event.Xruns)
+		assert.True(events[i].Timestamp.Equal(event.Timestamp))
+	}
+	countLines := func() int {
+		rawBytes, err := ioutil.ReadFile(PathToSessionTimeline)
+		assert.Nil(err)
+		return strings.Count(string(rawBytes), "\n")
+	}
+	assert.Equal(3, countLines())
+
+	// File should be compacted once it holds twice as many lines as the timeline
+	for i := 4; i < 7; i++ {
+		assert.Nil(loaded.Record(SessionEvent{Type: SessionXrunSpike, Xruns: i}))
+		assert.Equal(i, countLines())
+	}
+	assert.Nil(loaded.Record(SessionEvent{Type: SessionXrunSpike, Xruns: 7}))
+	assert.Equal(3, countLines())
+	assert.Nil(loaded.Load())
+	assert.Equal(7, loaded.Events(time.Time{})[2].Xruns)
+
+	loaded.Clear()
+	assert.Empty(loaded.Events(time.Time{}))
+}
+
+func TestGetSessionEvents(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	disabled := client.DeviceAgentConfig{}
+	connected := client.DeviceAgentConfig{}
+	connected.Enabled = true
+	connected.Host = "a.b.com"
+	moved := connected
+	moved.Host = "c.d.com"
+
+	types := func(events []SessionEvent) []SessionEventType {
+		var result []SessionEventType
+		for _, event := range events {
+			result = append(result, event.Type)
+		}
+		return result
+	}
+
+	events := getSessionEvents(disabled, StateEvent{Type: ConfigChanged, Config: connected}, now)
+	assert.Equal([]SessionEventType{SessionConfigChanged, SessionConnected}, types(events))
+	assert.Equal("a.b.com", events[1].Host)
+
+	events = getSessionEvents(connected, StateEvent{Type: ConfigChanged, Config: moved}, now)
+	assert.Equal([]SessionEventType{SessionConfigChanged, SessionDisconnected, SessionConnected}, types(events))
+	assert.Equal("a.b.com", events[1].Host)
+	assert.Equal("c.d.com", events[2].Host)
+
+	events = getSessionEvents(connected, StateEvent{Type: ConfigChanged, Config: disabled}, now)
+	assert.Equal([]SessionEventType{SessionConfigChanged, SessionDisconnected}, types(events))
+
+	events = getSessionEvents(connected, StateEvent{Type: ConfigChanged, Config: connected}, now)
+	assert.Equal([]SessionEventType{SessionConfigChanged}, types(events))
+
+	events = getSessionEvents(connected, StateEvent{Type: StatusChanged, Subsystem: WebSocketSubsystem, Status: "disconnected"}, now)
+	assert.Equal([]SessionEvent{{Type: SessionStatusChanged, Timestamp: now, Subsystem: WebSocketSubsystem, Status: "disconnected"}}, events)
+}
+
+func TestHandleSessionEventsRequest(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "jacktrip-agent")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	originalDir := AgentLibDir
+	defer func() { AgentLibDir = originalDir; updatePaths() }()
+	AgentLibDir = dir
+	updatePaths()
+	timeline := NewSessionTimeline(10)
+
+	start := time.Date(2022, 5, 1, 20, 14, 0, 0, time.UTC)
+	timeline.RecordXruns(0, XrunSpikeThreshold-1)
+	assert.Empty(timeline.Events(time.Time{}))
+	assert.Nil(timeline.Record(SessionEvent{Type: SessionConnected, Host: "a.b.com", Timestamp: start}))
+	assert.Nil(timeline.Record(SessionEvent{Type: SessionXrunSpike, Xruns: 20, Timestamp: start.Add(time.Minute)}))
+
+	w := httptest.NewRecorder()
+	handleSessionEventsRequest(timeline, w, httptest.NewRequest("GET", "/session/events", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	var events []SessionEvent
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &events))
+	assert.Len(events, 2)
+
+	w = httptest.NewRecorder()
+	handleSessionEventsRequest(timeline, w, httptest.NewRequest("GET", "/session/events?since=2022-05-01T20:15:00Z", nil))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &events))
+	assert.Len(events, 1)
+	assert.Equal(SessionXrunSpike, events[0].Type)
+
+	w = httptest.NewRecorder()
+	handleSessionEventsRequest(timeline, w, httptest.NewRequest("GET", "/session/events?since=yesterday", nil))
+	assert.Equal(http.StatusBadRequest, w.Code)
+}
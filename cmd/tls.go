@@ -0,0 +1,116 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+)
+
+// TLSCertificateCheckInterval is the time between checks for a renewed certificate
+const TLSCertificateCheckInterval = 12 * time.Hour
+
+var (
+	tlsAddressFlag = flag.String("tls", "", "address to also serve the agent HTTP endpoints over TLS (ie. \":443\")")
+	tlsCertFlag    = flag.String("tls-cert", "", "certificate file to use for TLS (a self-signed certificate is generated if omitted)")
+	tlsKeyFlag     = flag.String("tls-key", "", "private key file to use with -tls-cert")
+)
+
+// getTLSHosts returns the host names that a self-signed certificate is issued for
+func getTLSHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append([]string{hostname, hostname + ".local"}, hosts...)
+	}
+	return hosts
+}
+
+// getTLSCertificate loads the certificate provided to the agent, or a self-signed certificate kept in AgentLibDir,
+// which is replaced if it is close to expiring
+func getTLSCertificate(certFile, keyFile string, now time.Time) (tls.Certificate, error) {
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return tls.Certificate{}, errors.New("both a certificate and key file are required")
+		}
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	return common.LoadOrCreateSelfSignedCert(PathToTLSCertificate, PathToTLSKey, getTLSHosts(), now)
+}
+
+// TLSCertificateManager serves the current TLS certificate, reloading it periodically so that long running
+// agents pick up renewed self-signed certificates, and provided certificates replaced on disk (ie. by certbot)
+type TLSCertificateManager struct {
+	certFile string
+	keyFile  string
+	cert     tls.Certificate
+	mutex    sync.Mutex
+}
+
+// NewTLSCertificateManager constructs a new instance of TLSCertificateManager, loading the certificate
+func NewTLSCertificateManager(certFile, keyFile string, now time.Time) (*TLSCertificateManager, error) {
+	cert, err := getTLSCertificate(certFile, keyFile, now)
+	if err != nil {
+		return nil, err
+	}
+	return &TLSCertificateManager{certFile: certFile, keyFile: keyFile, cert: cert}, nil
+}
+
+// GetCertificate returns the current certificate, for use in tls.Config
+func (m *TLSCertificateManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cert := m.cert
+	return &cert, nil
+}
+
+// Refresh reloads the certificate, keeping the current one if it cannot be loaded
+func (m *TLSCertificateManager) Refresh(now time.Time) error {
+	cert, err := getTLSCertificate(m.certFile, m.keyFile, now)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !bytes.Equal(cert.Certificate[0], m.cert.Certificate[0]) {
+		log.Info("Loaded renewed TLS certificate")
+	}
+	m.cert = cert
+	return nil
+}
+
+// Run reloads the certificate periodically, until the context is cancelled
+func (m *TLSCertificateManager) Run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	ticker := time.NewTicker(TLSCertificateCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := m.Refresh(now); err != nil {
+				log.Error(err, "Unable to reload TLS certificate")
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTLSCertificate(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	origLibDir := AgentLibDir
+	defer func() {
+		AgentLibDir = origLibDir
+		updatePaths()
+	}()
+	AgentLibDir = dir
+	updatePaths()
+
+	// Case for generating a self-signed certificate
+	cert, err := getTLSCertificate("", "", time.Now())
+	assert.NoError(err)
+	assert.FileExists(PathToTLSCertificate)
+	assert.FileExists(PathToTLSKey)
+
+	// Case for reusing the self-signed certificate
+	reused, err := getTLSCertificate("", "", time.Now())
+	assert.NoError(err)
+	assert.Equal(cert.Certificate[0], reused.Certificate[0])
+
+	// Case for a provided certificate
+	certPEM, keyPEM, err := common.GenerateSelfSignedCert([]string{"studio.example.com"}, time.Now())
+	assert.NoError(err)
+	certFile, keyFile := filepath.Join(dir, "provided.crt"), filepath.Join(dir, "provided.key")
+	assert.NoError(ioutil.WriteFile(certFile, certPEM, 0644))
+	assert.NoError(ioutil.WriteFile(keyFile, keyPEM, 0600))
+	provided, err := getTLSCertificate(certFile, keyFile, time.Now())
+	assert.NoError(err)
+	assert.NotEqual(cert.Certificate[0], provided.Certificate[0])
+
+	// Case for a missing key file
+	_, err = getTLSCertificate(certFile, "", time.Now())
+	assert.Error(err)
+	_, err = getTLSCertificate(certFile, filepath.Join(dir, "missing.key"), time.Now())
+	assert.Error(err)
+}
+
+func TestTLSCertificateManager(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	origLibDir := AgentLibDir
+	defer func() {
+		AgentLibDir = origLibDir
+		updatePaths()
+	}()
+	AgentLibDir = dir
+	updatePaths()
+	now := time.Now()
+
+	// Case for a self-signed certificate, which is kept until it is close to expiring
+	m, err := NewTLSCertificateManager("", "", now)
+	assert.NoError(err)
+	cert, err := m.GetCertificate(nil)
+	assert.NoError(err)
+	assert.NoError(m.Refresh(now.Add(24 * time.Hour)))
+	reused, _ := m.GetCertificate(nil)
+	assert.Equal(cert.Certificate[0], reused.Certificate[0])
+	renewAt := cert.Leaf.NotAfter.Add(-common.SelfSignedCertRenewBefore).Add(time.Hour)
+	assert.NoError(m.Refresh(renewAt))
+	renewed, _ := m.GetCertificate(nil)
+	assert.NotEqual(cert.Certificate[0], renewed.Certificate[0])
+	assert.True(renewed.Leaf.NotAfter.After(cert.Leaf.NotAfter))
+
+	// Case for a provided certificate that is replaced on disk
+	certFile, keyFile := filepath.Join(dir, "provided.crt"), filepath.Join(dir, "provided.key")
+	writeCert := func() {
+		certPEM, keyPEM, err := common.GenerateSelfSignedCert([]string{"studio.example.com"}, time.Now())
+		assert.NoError(err)
+		assert.NoError(ioutil.WriteFile(certFile, certPEM, 0644))
+		assert.NoError(ioutil.WriteFile(keyFile, keyPEM, 0600))
+	}
+	writeCert()
+	m, err = NewTLSCertificateManager(certFile, keyFile, now)
+	assert.NoError(err)
+	cert, _ = m.GetCertificate(nil)
+	writeCert()
+	assert.NoError(m.Refresh(now))
+	renewed, _ = m.GetCertificate(nil)
+	assert.NotEqual(cert.Certificate[0], renewed.Certificate[0])
+
+	// Case for a provided certificate that cannot be loaded, which keeps the current one
+	assert.NoError(os.Remove(keyFile))
+	assert.Error(m.Refresh(now))
+	kept, _ := m.GetCertificate(nil)
+	assert.Equal(renewed.Certificate[0], kept.Certificate[0])
+}
+
+func TestGetTLSHosts(t *testing.T) {
+	assert := assert.New(t)
+	hosts := getTLSHosts()
+	assert.Contains(hosts, "localhost")
+	assert.Contains(hosts, "127.0.0.1")
+}
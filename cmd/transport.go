@@ -0,0 +1,140 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/common"
+	"github.com/xthexder/go-jack"
+)
+
+const (
+	// JackTransportPath is the path to the JACK transport control utility
+	JackTransportPath = "/usr/bin/jack_transport"
+
+	// TimecodeServiceName is the name of the systemd service that generates timecode from JACK transport
+	TimecodeServiceName = "timecode.service"
+
+	// TimecodeConfigTemplate is the template used to generate /tmp/default/timecode file on raspberry pi devices
+	TimecodeConfigTemplate = "TIMECODE_OPTS=-n %s -f %s -r %d\n"
+
+	// TimecodeClientName is the JACK client name used by the timecode generator
+	TimecodeClientName = "timecode"
+
+	// DefaultTimecodeFPS is the timecode frame rate used when none is configured
+	DefaultTimecodeFPS = 30
+)
+
+// getTimecodeFPS returns the configured timecode frame rate, or the default frame rate
+func getTimecodeFPS(config client.DeviceAgentConfig) int {
+	if config.TimecodeFPS < 1 {
+		return DefaultTimecodeFPS
+	}
+	return config.TimecodeFPS
+}
+
+// isTimecodeToServer returns true if LTC is sent to the audio server on a dedicated JackTrip channel
+func isTimecodeToServer(config client.DeviceAgentConfig) bool {
+	return config.Timecode == client.TimecodeLTC && bool(config.TimecodeToServer) && usesJackTrip(config)
+}
+
+// getJackTripSendChannels returns the number of JackTrip channels sent to the audio server, including timecode
+func getJackTripSendChannels(config client.DeviceAgentConfig) int {
+	if isTimecodeToServer(config) {
+		return getSendChannels(config) + 1
+	}
+	return getSendChannels(config)
+}
+
+// updateTimecodeConfig writes the timecode service config file
+func updateTimecodeConfig(config client.DeviceAgentConfig) {
+	if config.Timecode == client.TimecodeOff {
+		return
+	}
+	timecodeConfig := fmt.Sprintf(TimecodeConfigTemplate, TimecodeClientName, config.Timecode, getTimecodeFPS(config))
+	_, err := common.WriteFileIfChanged(PathToTimecodeConfig, []byte(timecodeConfig), 0644)
+	if err != nil {
+		log.Error(err, "Failed to save timecode config", "path", PathToTimecodeConfig)
+	}
+}
+
+// isTimecodePort returns true if a JACK port belongs to the timecode generator
+func isTimecodePort(name string) bool {
+	return strings.HasPrefix(name, TimecodeClientName+":")
+}
+
+// connectTimecodePorts sends LTC to the dedicated JackTrip channel after the device's input channels
+func (ac *AutoConnector) connectTimecodePorts(config client.DeviceAgentConfig) {
+	if !isTimecodeToServer(config) {
+		return
+	}
+	dest := fmt.Sprintf("%s%d", hubserverInput, getJackTripSendChannels(config))
+	for _, port := range ac.JackClient.GetPorts(TimecodeClientName+":", "", jack.PortIsOutput) {
+		if ac.isValidPort(dest) {
+			ac.connectPorts(port, dest)
+		}
+	}
+}
+
+// getTransportCommands returns the jack_transport commands used to perform a transport request
+func getTransportCommands(request client.TransportRequest, sampleRate int) string {
+	var command string
+	switch request.Action {
+	case client.TransportStart:
+		command = "play"
+	case client.TransportStop:
+		command = "stop"
+	case client.TransportLocate:
+		command = fmt.Sprintf("locate %d", int64(request.Position*float64(sampleRate)))
+	}
+	return command + "\nquit\n"
+}
+
+// controlTransport starts, stops or locates JACK transport, which is followed by all transport-aware clients
+func controlTransport(request client.TransportRequest, sampleRate int) error {
+	_, err := systemRunner.OutputWithInput(getTransportCommands(request, sampleRate), JackTransportPath)
+	return err
+}
+
+// handleTransportRequest controls JACK transport on the device
+func handleTransportRequest(w http.ResponseWriter, r *http.Request) {
+	var request client.TransportRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !request.IsValid() {
+		RespondJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid transport request"})
+		return
+	}
+
+	config := deviceState.Config()
+	if !config.Enabled {
+		RespondJSON(w, http.StatusConflict, map[string]string{"error": "audio services are not running"})
+		return
+	}
+	if err := controlTransport(request, config.SampleRate); err != nil {
+		log.Error(err, "Failed to control JACK transport", "action", request.Action)
+		RespondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	log.Info("Controlled JACK transport", "action", request.Action, "position", request.Position)
+	RespondJSON(w, http.StatusOK, request)
+}
@@ -0,0 +1,95 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetJackTripSendChannels(t *testing.T) {
+	assert := assert.New(t)
+	config := client.DeviceAgentConfig{}
+	config.Type = client.JackTrip
+	config.InputChannels = 2
+	assert.Equal(2, getJackTripSendChannels(config))
+
+	// LTC is sent on a dedicated channel after the input channels
+	config.Timecode = client.TimecodeLTC
+	config.TimecodeToServer = true
+	assert.Equal(3, getJackTripSendChannels(config))
+	assert.Contains(getJackTripConfig(config, "example.com", 4464, "device"), "--sendchannels 3")
+
+	// MTC and Jamulus can't be sent to the server
+	config.Timecode = client.TimecodeMTC
+	assert.Equal(2, getJackTripSendChannels(config))
+	config.Timecode = client.TimecodeLTC
+	config.Type = client.Jamulus
+	assert.Equal(2, getJackTripSendChannels(config))
+}
+
+func TestGetTransportCommands(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("play\nquit\n", getTransportCommands(client.TransportRequest{Action: client.TransportStart}, 48000))
+	assert.Equal("stop\nquit\n", getTransportCommands(client.TransportRequest{Action: client.TransportStop}, 48000))
+	assert.Equal("locate 120000\nquit\n", getTransportCommands(client.TransportRequest{Action: client.TransportLocate, Position: 2.5}, 48000))
+	assert.Equal(30, getTimecodeFPS(client.DeviceAgentConfig{}))
+	assert.True(isTimecodePort("timecode:out"))
+	assert.False(isTimecodePort("metronome:out"))
+}
+
+func TestHandleTransportRequest(t *testing.T) {
+	assert := assert.New(t)
+	saved := deviceState
+	deviceState = NewStateStore()
+	defer func() { deviceState = saved }()
+	runner := NewFakeRunner()
+	defer func(prev SystemRunner) { systemRunner = prev }(systemRunner)
+	systemRunner = runner
+
+	newRequest := func(body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		handleTransportRequest(resp, httptest.NewRequest("POST", "http://example.com/transport", strings.NewReader(body)))
+		return resp
+	}
+
+	// Case for invalid requests
+	assert.Equal(400, newRequest(`{"action":"rewind"}`).Code)
+	assert.Equal(400, newRequest(`{"action":"locate","position":-1}`).Code)
+
+	// Case for devices that aren't running audio services
+	assert.Equal(409, newRequest(`{"action":"start"}`).Code)
+
+	// Case for locating transport
+	config := client.DeviceAgentConfig{}
+	config.Enabled = true
+	config.SampleRate = 96000
+	deviceState.SetConfig(config)
+	assert.Equal(200, newRequest(`{"action":"locate","position":10}`).Code)
+	assert.Equal([]string{JackTransportPath}, runner.Commands)
+	assert.Equal([]string{"locate 960000\nquit\n"}, runner.Inputs)
+
+	// Case for jack_transport failures
+	runner.Errors[JackTransportPath] = errors.New("cannot connect to JACK server")
+	assert.Equal(500, newRequest(`{"action":"stop"}`).Code)
+}
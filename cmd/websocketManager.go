@@ -12,74 +12,70 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !server
+// +build !server
+
 package main
 
 import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"net/url"
 	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
 	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/jacktrip/jacktrip-agent/pkg/wsclient"
 )
 
-// WebSocketManager is used to manage a websocket connection to the control plane
+// WebSocketManager is used to manage the websocket connections to the control plane. Configs, commands and their
+// results use the control stream, while heartbeats use the telemetry stream, so that a slow heartbeat never delays
+// a config or command
 type WebSocketManager struct {
-	Conn             *websocket.Conn
-	Mu               sync.Mutex
-	IsInitialized    bool
+	Control          *wsclient.Stream
+	Telemetry        *wsclient.Stream
 	APIOrigin        string
+	APIClient        *api.Client
 	Credentials      client.AgentCredentials
+	Agent            *DeviceAgent
 	ConfigChannel    chan client.DeviceAgentConfig
 	HeartbeatChannel chan interface{}
-	HeartbeatPath    string
+	ResultChannel    chan interface{}
+	Mu               sync.Mutex
+	configETag       string
 }
 
-// InitConnection initializes a new connection if there is no connection or returns an existing connection
-func (wsm *WebSocketManager) InitConnection(wg *sync.WaitGroup, id string) error {
-	if wsm.IsInitialized {
-		return nil
-	}
-
-	// Parse url and format a ws(s) url
-	u, _ := url.Parse(wsm.APIOrigin)
-	scheme := "ws"
-	if u.Scheme == "https" {
-		scheme = "wss"
-	}
-	path := fmt.Sprintf("%s%s", u.Path, fmt.Sprintf(wsm.HeartbeatPath, id))
-	wsURL := url.URL{Scheme: scheme, Host: u.Host, Path: path}
-
-	// Initialize a websocket to the control plane
-	wsm.Mu.Lock()
-	h := http.Header{"Origin": []string{"http://jacktrip.local"}}
-	h.Set("APISecret", wsm.Credentials.APISecret)
-	h.Set("APIPrefix", wsm.Credentials.APIPrefix)
-	c, _, err := websocket.DefaultDialer.Dial(wsURL.String(), h)
-	wsm.Conn = c
-	wsm.Mu.Unlock()
-
-	if err != nil {
-		wsm.IsInitialized = false
-		return err
+// NewWebSocketManager constructs a new instance of WebSocketManager
+func NewWebSocketManager(apiOrigin string, credentials client.AgentCredentials, agent *DeviceAgent) *WebSocketManager {
+	return &WebSocketManager{
+		Control:          newWebSocketStream(DeviceControlPath, WebSocketSubsystem),
+		Telemetry:        newWebSocketStream(DeviceHeartbeatPath, TelemetrySubsystem),
+		APIOrigin:        apiOrigin,
+		APIClient:        api.NewClient(apiOrigin, credentials, nil),
+		Credentials:      credentials,
+		Agent:            agent,
+		ConfigChannel:    make(chan client.DeviceAgentConfig, 100),
+		HeartbeatChannel: make(chan interface{}, 100),
+		ResultChannel:    make(chan interface{}, 100),
 	}
+}
 
-	wsm.IsInitialized = true
-	log.Info("Websocket connected", "target", wsURL.String())
+// ConnectControl opens the control stream if it is not connected, returning true if it was reconnected
+func (wsm *WebSocketManager) ConnectControl(id string) (bool, error) {
+	return wsm.Control.Connect(wsm.APIOrigin, wsm.Credentials, id)
+}
 
-	return nil
+// ConnectTelemetry opens the telemetry stream if it is not connected
+func (wsm *WebSocketManager) ConnectTelemetry(id string) error {
+	_, err := wsm.Telemetry.Connect(wsm.APIOrigin, wsm.Credentials, id)
+	return err
 }
 
-// CloseConnection closes an initialized connection in a websocketmanager
+// CloseConnection closes both streams
 func (wsm *WebSocketManager) CloseConnection() {
-	wsm.Mu.Lock()
-	defer wsm.Mu.Unlock()
-	wsm.Conn.Close()
-	wsm.IsInitialized = false
+	wsm.Control.Close()
+	wsm.Telemetry.Close()
 }
 
 // Handlers to be used as a Goroutine
@@ -88,36 +84,68 @@ func (wsm *WebSocketManager) recvConfigHandler(ctx context.Context, wg *sync.Wai
 	defer wg.Done()
 	log.Info("Starting recvConfigHandler")
 
+	wsm.Control.ReadLoop(ctx, func(message []byte) { wsm.handleControlMessage(ctx, message) }, func(err error) {
+		log.Error(err, "[Websocket] Error reading message. Replacing the control connection.")
+	})
+	log.Info("Stopping recvConfigHandler")
+}
+
+// handleControlMessage handles a config or command received over the control stream
+func (wsm *WebSocketManager) handleControlMessage(ctx context.Context, message []byte) {
+	// handle commands, which are sent over the same websocket as configs
+	var command client.AgentCommand
+	if err := json.Unmarshal(message, &command); err == nil && command.Command != "" {
+		go wsm.handleCommand(command)
+		return
+	}
+
+	var config client.DeviceAgentConfig
+	if err := json.Unmarshal(message, &config); err != nil {
+		log.Error(err, "Failed to unmarshal heartbeat response")
+		return
+	}
+
+	wsm.SendConfig(ctx, config)
+}
+
+// SendConfig queues a config for the device update loop, returning false if the agent stopped before it was queued
+func (wsm *WebSocketManager) SendConfig(ctx context.Context, config client.DeviceAgentConfig) bool {
+	select {
+	case wsm.ConfigChannel <- config:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// recvTelemetryHandler reads from the telemetry stream, which is needed to process pongs and notice when it closes.
+// NOTE: the heartbeat route still sends configs to agents, so they are handled the same way as over the control stream,
+// until every control plane serves the control route
+func (wsm *WebSocketManager) recvTelemetryHandler(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	log.Info("Starting recvTelemetryHandler")
+
+	wsm.Telemetry.ReadLoop(ctx, func(message []byte) { wsm.handleControlMessage(ctx, message) }, func(err error) {
+		log.Error(err, "[Websocket] Error reading message. Replacing the telemetry connection.")
+	})
+	log.Info("Stopping recvTelemetryHandler")
+}
+
+// sendResultHandler sends command results over the control stream
+func (wsm *WebSocketManager) sendResultHandler(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+	log.Info("Starting sendResultHandler")
+
 	for {
 		select {
 		case <-ctx.Done():
-			log.Info("Stopping recvConfigHandler")
+			log.Info("Stopping sendResultHandler")
 			return
-		default:
-		}
-
-		if !wsm.IsInitialized {
-			// sleep while not connected to avoid inf loop
-			time.Sleep(time.Second)
-			continue
-		}
-
-		// read config message
-		wsm.Conn.SetReadDeadline(time.Now().Add(time.Minute * 5)) // timeout after 5 minutes
-		_, message, err := wsm.Conn.ReadMessage()
-		if err != nil {
-			log.Error(err, "[Websocket] Error reading message. Closing the connection.")
-			wsm.CloseConnection()
-			continue
-		}
-
-		var config client.DeviceAgentConfig
-		if err := json.Unmarshal(message, &config); err != nil {
-			log.Error(err, "Failed to unmarshal heartbeat response")
-			continue
+		case result := <-wsm.ResultChannel:
+			if err := wsm.Control.Send(result); err != nil {
+				log.Error(err, "[Websocket] Failed to send a command result. Replacing the control connection.")
+			}
 		}
-
-		wsm.ConfigChannel <- config
 	}
 }
 
@@ -131,23 +159,76 @@ func (wsm *WebSocketManager) sendHeartbeatHandler(ctx context.Context, wg *sync.
 			log.Info("Stopping sendHeartbeatHandler")
 			return
 		case beat := <-wsm.HeartbeatChannel:
-			if !wsm.IsInitialized {
-				continue
+			// heartbeats are snapshots, so only the latest one is worth sending after a slow send
+			for len(wsm.HeartbeatChannel) > 0 {
+				beat = <-wsm.HeartbeatChannel
 			}
-			beatBytes, err := json.Marshal(beat)
-			if err != nil {
-				log.Error(err, "Failed to marshal heartbeat message")
+			if !wsm.Telemetry.Connected() {
+				wsm.Agent.queueTelemetry(beat)
 				continue
 			}
-
-			err = wsm.Conn.WriteMessage(websocket.TextMessage, beatBytes)
-
-			if err != nil {
-				log.Error(err, "[Websocket] Failed to send a message. Closing the connection.")
-				wsm.CloseConnection()
+			if err := wsm.Telemetry.Send(beat); err != nil {
+				log.Error(err, "[Websocket] Failed to send a message. Replacing the telemetry connection.")
+				wsm.Agent.queueTelemetry(beat)
 			} else {
 				log.V(1).Info("Sent heartbeat message via websocket")
 			}
 		}
 	}
 }
+
+// FetchConfig requests the latest config from the API and sends it to the config channel if it changed,
+// so that devices do not have to wait for the next heartbeat or websocket push after starting or reconnecting
+func (wsm *WebSocketManager) FetchConfig(ctx context.Context, id string) {
+	wsm.Mu.Lock()
+	etag := wsm.configETag
+	wsm.Mu.Unlock()
+
+	config, etag, err := wsm.APIClient.FetchDeviceConfig(ctx, id, etag)
+	if err == api.ErrNotModified {
+		log.V(1).Info("Device config not modified")
+		return
+	}
+	if err != nil {
+		log.Error(err, "Failed to fetch device config")
+		return
+	}
+
+	wsm.Mu.Lock()
+	wsm.configETag = etag
+	wsm.Mu.Unlock()
+	wsm.SendConfig(ctx, config)
+}
+
+// LastMessageAge returns the number of seconds since the last message was received over the control stream,
+// or -1 if none was received
+func (wsm *WebSocketManager) LastMessageAge(now time.Time) float64 {
+	lastMessageAt := wsm.Control.LastMessageAt()
+	if lastMessageAt.IsZero() {
+		return -1
+	}
+	return now.Sub(lastMessageAt).Seconds()
+}
+
+// handleCommand runs a command received from the control plane, and sends the result back over the control stream
+func (wsm *WebSocketManager) handleCommand(command client.AgentCommand) {
+	log.Info("Received command", "command", command.Command)
+	result := client.AgentCommandResult{Command: command.Command}
+	switch command.Command {
+	case DoctorCommand:
+		result.Result = runDoctor(wsm.APIOrigin)
+	case ExportCommand:
+		result.Result = wsm.Agent.runDataExport(context.Background(), command.UploadURL)
+	case FailoverCommand, FailbackCommand:
+		result.Result = wsm.Agent.runStandbyCommand(command.Command)
+	case AlsaRestoreCommand:
+		ctx, cancel := context.WithTimeout(context.Background(), AlsaBackupTimeout)
+		result.Result = deviceAlsaBackup.Restore(ctx, command.Device)
+		cancel()
+	case LatencyCommand:
+		result.Result = wsm.Agent.Latency.Measure(context.Background(), deviceState.Config())
+	default:
+		result.Result = fmt.Sprintf("unknown command: %s", command.Command)
+	}
+	wsm.ResultChannel <- result
+}
@@ -0,0 +1,191 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/apitest"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSocketManagerWithFakeControlPlane(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	server := apitest.NewServer(credentials)
+	defer server.Close()
+
+	config := client.DeviceAgentConfig{}
+	config.Host = "a.b.com"
+	server.SetConfig(config)
+
+	wsm := NewWebSocketManager(server.URL, credentials, NewDeviceAgent(false))
+	assert.Equal(float64(-1), wsm.LastMessageAge(time.Now()))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	reconnected, err := wsm.ConnectControl("abc")
+	assert.Nil(err)
+	assert.True(reconnected)
+	reconnected, err = wsm.ConnectControl("abc")
+	assert.Nil(err)
+	assert.False(reconnected)
+	assert.Nil(wsm.ConnectTelemetry("abc"))
+	assert.Eventually(func() bool { return server.Connections() == 1 && server.TelemetryConnections() == 1 }, time.Second, 10*time.Millisecond)
+	wg.Add(4)
+	go wsm.recvConfigHandler(ctx, &wg)
+	go wsm.recvTelemetryHandler(ctx, &wg)
+	go wsm.sendHeartbeatHandler(ctx, &wg)
+	go wsm.sendResultHandler(ctx, &wg)
+
+	// Config is received on connect, and again when it is pushed
+	received := <-wsm.ConfigChannel
+	assert.Equal("a.b.com", received.Host)
+	config.Host = "c.d.com"
+	assert.Nil(server.SetConfig(config))
+	received = <-wsm.ConfigChannel
+	assert.Equal("c.d.com", received.Host)
+	age := wsm.LastMessageAge(time.Now())
+	assert.True(age >= 0 && age < 1)
+
+	// Heartbeats are delivered over the telemetry websocket
+	wsm.HeartbeatChannel <- client.DeviceHeartbeat{MAC: "abc"}
+	assert.Eventually(func() bool { return len(server.Heartbeats()) == 1 }, time.Second, 10*time.Millisecond)
+	var beat client.DeviceHeartbeat
+	assert.Nil(json.Unmarshal(server.Heartbeats()[0], &beat))
+	assert.Equal("abc", beat.MAC)
+
+	// Commands are answered over the control websocket instead of being treated as configs
+	assert.Nil(server.SendCommand(client.AgentCommand{Command: "bogus"}))
+	assert.Eventually(func() bool { return len(server.Results()) == 1 }, time.Second, 10*time.Millisecond)
+	var result client.AgentCommandResult
+	assert.Nil(json.Unmarshal(server.Results()[0], &result))
+	assert.Equal("bogus", result.Command)
+	assert.Equal("unknown command: bogus", result.Result)
+	assert.Equal(0, len(wsm.ConfigChannel))
+	assert.Equal(1, len(server.Heartbeats()))
+
+	// Configs are fetched on demand, and only sent again after they change
+	wsm.FetchConfig(ctx, "abc")
+	received = <-wsm.ConfigChannel
+	assert.Equal("c.d.com", received.Host)
+	wsm.FetchConfig(ctx, "abc")
+	assert.Equal(0, len(wsm.ConfigChannel))
+
+	// The telemetry stream reconnects without touching the control stream
+	wsm.Telemetry.Close()
+	assert.True(wsm.Control.Connected())
+	assert.Nil(wsm.ConnectTelemetry("abc"))
+	wsm.HeartbeatChannel <- client.DeviceHeartbeat{MAC: "abc"}
+	assert.Eventually(func() bool { return len(server.Heartbeats()) == 2 }, time.Second, 10*time.Millisecond)
+
+	wsm.CloseConnection()
+	wsm.CloseConnection()
+	assert.False(wsm.Control.Connected())
+	assert.False(wsm.Telemetry.Connected())
+	cancel()
+	wg.Wait()
+}
+
+func TestWebSocketManagerWithLegacyControlPlane(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	server := apitest.NewServer(credentials)
+	defer server.Close()
+	server.SetLegacy(true)
+
+	config := client.DeviceAgentConfig{}
+	config.Host = "a.b.com"
+	server.SetConfig(config)
+
+	wsm := NewWebSocketManager(server.URL, credentials, NewDeviceAgent(false))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+
+	// Without the control route, configs are still received over the heartbeat websocket
+	_, err := wsm.ConnectControl("abc")
+	assert.NotNil(err)
+	assert.Nil(wsm.ConnectTelemetry("abc"))
+	wg.Add(1)
+	go wsm.recvTelemetryHandler(ctx, &wg)
+	received := <-wsm.ConfigChannel
+	assert.Equal("a.b.com", received.Host)
+	config.Host = "c.d.com"
+	assert.Nil(server.SetConfig(config))
+	received = <-wsm.ConfigChannel
+	assert.Equal("c.d.com", received.Host)
+
+	wsm.CloseConnection()
+	cancel()
+	wg.Wait()
+}
+
+func TestDeviceConfigUpdateHandlerRejectsInvalidConfig(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	server := apitest.NewServer(credentials)
+	defer server.Close()
+
+	defer func(state *StateStore) { deviceState = state }(deviceState)
+	deviceState = NewStateStore()
+	deviceState.UpdateHeartbeat(func(beat *client.DeviceHeartbeat) { beat.MAC = "abc" })
+
+	agent := NewDeviceAgent(false)
+	wsm := NewWebSocketManager(server.URL, credentials, agent)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go agent.deviceConfigUpdateHandler(ctx, &wg, wsm, nil)
+
+	// An invalid config is acknowledged as not applied, without touching any services
+	config := client.DeviceAgentConfig{}
+	config.Enabled = true
+	wsm.ConfigChannel <- config
+	assert.Eventually(func() bool { return len(server.Acks()) == 1 }, time.Second, 10*time.Millisecond)
+	ack := server.Acks()[0]
+	assert.False(ack.Applied)
+	assert.Contains(ack.Error, "serverHost is required")
+
+	// The same config is returned by every heartbeat, but only acknowledged once
+	wsm.ConfigChannel <- config
+	config.CaptureVolume = 200
+	wsm.ConfigChannel <- config
+	assert.Eventually(func() bool { return len(server.Acks()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Contains(server.Acks()[1].Error, "captureVolume")
+
+	cancel()
+	wg.Wait()
+	assert.Contains(deviceState.Heartbeat().ConfigError, "serverHost is required")
+}
+
+func TestWebSocketManagerSendConfig(t *testing.T) {
+	assert := assert.New(t)
+	wsm := NewWebSocketManager("https://example.com", client.AgentCredentials{}, NewDeviceAgent(false))
+	wsm.ConfigChannel = make(chan client.DeviceAgentConfig, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	assert.True(wsm.SendConfig(ctx, client.DeviceAgentConfig{}))
+
+	// Case for a full channel after the agent stopped, which must not block
+	cancel()
+	assert.False(wsm.SendConfig(ctx, client.DeviceAgentConfig{}))
+}
@@ -0,0 +1,59 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/wsclient"
+)
+
+const (
+	// DefaultWebSocketPingInterval is the default interval between pings sent over websockets to the control plane
+	DefaultWebSocketPingInterval = 30 * time.Second
+
+	// DefaultWebSocketPongTimeout is the default time allowed for a pong to arrive, after a ping is due
+	DefaultWebSocketPongTimeout = 10 * time.Second
+)
+
+var (
+	wsPingIntervalFlag = flag.Duration("ws-ping", DefaultWebSocketPingInterval, "interval between pings sent over websockets to the control plane")
+	wsPongTimeoutFlag  = flag.Duration("ws-pong-timeout", DefaultWebSocketPongTimeout, "time allowed for a websocket pong to arrive before reconnecting")
+)
+
+// getWebSocketKeepalive returns the keepalive settings given on the command line
+func getWebSocketKeepalive() wsclient.Keepalive {
+	return wsclient.Keepalive{PingInterval: *wsPingIntervalFlag, PongTimeout: *wsPongTimeoutFlag}
+}
+
+// newWebSocketStream returns a websocket stream to the control plane, which reports its status as a subsystem
+func newWebSocketStream(path string, subsystem Subsystem) *wsclient.Stream {
+	return &wsclient.Stream{
+		Path:      path,
+		Keepalive: getWebSocketKeepalive(),
+		StatusFunc: func(status wsclient.Status, target string, err error) {
+			switch status {
+			case wsclient.StatusConnected, wsclient.StatusDisconnected:
+				deviceState.SetStatus(subsystem, string(status))
+				log.Info("Websocket "+string(status), "target", target)
+			case wsclient.StatusUnresponsive:
+				log.Info("Websocket missed a pong, reconnecting", "target", target)
+			case wsclient.StatusReconnectFailed:
+				log.Error(err, "Failed to reconnect websocket", "target", target)
+			}
+		},
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// ZitaRestartBackoff is the delay before restarting a zita bridge after its first crash
+	ZitaRestartBackoff = time.Second
+
+	// ZitaMaxRestartBackoff is the maximum delay before restarting a zita bridge
+	ZitaMaxRestartBackoff = time.Minute
+
+	// ZitaMaxCrashes is the number of crashes within ZitaCrashWindow after which a device is marked as failed
+	ZitaMaxCrashes = 5
+
+	// ZitaCrashWindow is how long crashes are remembered; the count resets after a bridge runs this long
+	ZitaCrashWindow = 10 * time.Minute
+)
+
+// zitaServiceName matches zita service names, capturing the mode and device
+var zitaServiceName = regexp.MustCompile(`^zita-(a2j|j2a)@(.+)\.service$`)
+
+// zitaCrashState keeps track of crashes of a single zita bridge
+type zitaCrashState struct {
+	count       int
+	lastCrash   time.Time
+	nextRestart time.Time
+	pending     bool
+	failed      bool
+}
+
+// ZitaSupervisor restarts crashed zita bridges with an exponential backoff, and gives up on
+// devices that keep crashing (ie. on flaky USB hubs); the zero value is ready to use
+type ZitaSupervisor struct {
+	crashes map[string]*zitaCrashState
+	mutex   sync.Mutex
+}
+
+// getZitaRestartBackoff returns the delay before restarting a bridge after a number of crashes
+func getZitaRestartBackoff(count int) time.Duration {
+	backoff := ZitaRestartBackoff
+	for i := 1; i < count && backoff < ZitaMaxRestartBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > ZitaMaxRestartBackoff {
+		return ZitaMaxRestartBackoff
+	}
+	return backoff
+}
+
+// Crashed records a crash of a zita service, returning true if its device is now marked as failed
+func (s *ZitaSupervisor) Crashed(name string, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.crashes == nil {
+		s.crashes = map[string]*zitaCrashState{}
+	}
+	state, ok := s.crashes[name]
+	if !ok || now.Sub(state.lastCrash) > ZitaCrashWindow {
+		state = &zitaCrashState{}
+		s.crashes[name] = state
+	}
+	state.count++
+	state.lastCrash = now
+	if state.count >= ZitaMaxCrashes {
+		state.failed = true
+		state.pending = false
+		return true
+	}
+	state.pending = true
+	state.nextRestart = now.Add(getZitaRestartBackoff(state.count))
+	return false
+}
+
+// Due returns the crashed services that should be restarted now, and clears their pending restarts
+func (s *ZitaSupervisor) Due(now time.Time) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var names []string
+	for name, state := range s.crashes {
+		if state.pending && !now.Before(state.nextRestart) {
+			state.pending = false
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Retain forgets about crashes of services that are no longer in use, ie. after a device is unplugged
+func (s *ZitaSupervisor) Retain(names map[string]bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for name := range s.crashes {
+		if !names[name] {
+			delete(s.crashes, name)
+		}
+	}
+}
+
+// Reset forgets about all crashes, so that failed devices are tried again
+func (s *ZitaSupervisor) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.crashes = nil
+}
+
+// IsFailed returns true if a zita service crashed too many times to be restarted
+func (s *ZitaSupervisor) IsFailed(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	state, ok := s.crashes[name]
+	return ok && state.failed
+}
+
+// FailedDevices returns the names of devices with a zita bridge that crashed too many times
+func (s *ZitaSupervisor) FailedDevices() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	devices := map[string]bool{}
+	for name, state := range s.crashes {
+		if match := zitaServiceName.FindStringSubmatch(name); match != nil && state.failed {
+			devices[match[2]] = true
+		}
+	}
+	var result []string
+	for device := range devices {
+		result = append(result, device)
+	}
+	sort.Strings(result)
+	return result
+}
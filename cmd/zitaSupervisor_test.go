@@ -0,0 +1,112 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !server
+// +build !server
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetZitaRestartBackoff(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(time.Second, getZitaRestartBackoff(1))
+	assert.Equal(2*time.Second, getZitaRestartBackoff(2))
+	assert.Equal(8*time.Second, getZitaRestartBackoff(4))
+	assert.Equal(time.Minute, getZitaRestartBackoff(20))
+}
+
+func TestZitaSupervisor(t *testing.T) {
+	assert := assert.New(t)
+	var s ZitaSupervisor
+	name := "zita-a2j@USB.service"
+	now := time.Now()
+
+	assert.False(s.Crashed(name, now))
+	assert.Empty(s.Due(now))
+	assert.Equal([]string{name}, s.Due(now.Add(time.Second)))
+	assert.Empty(s.Due(now.Add(time.Second)))
+
+	// restarts are delayed longer after each crash, until the device is marked as failed
+	for i := 2; i < ZitaMaxCrashes; i++ {
+		assert.False(s.Crashed(name, now))
+	}
+	assert.Empty(s.Due(now.Add(4 * time.Second)))
+	assert.Equal([]string{name}, s.Due(now.Add(8*time.Second)))
+	assert.True(s.Crashed(name, now))
+	assert.True(s.IsFailed(name))
+	assert.Empty(s.Due(now.Add(time.Hour)))
+	assert.Equal([]string{"USB"}, s.FailedDevices())
+
+	// crashes are forgotten after the device is removed
+	s.Retain(map[string]bool{})
+	assert.False(s.IsFailed(name))
+	assert.Empty(s.FailedDevices())
+
+	// crashes are forgotten after a bridge runs long enough
+	assert.False(s.Crashed(name, now))
+	assert.False(s.Crashed(name, now.Add(ZitaCrashWindow+time.Second)))
+	assert.Equal(1, s.crashes[name].count)
+	s.Reset()
+	assert.Empty(s.Due(now.Add(time.Hour)))
+}
+
+func TestDeviceMixingManagerRestartsCrashedBridges(t *testing.T) {
+	assert := assert.New(t)
+	services := NewFakeServiceManager()
+	defer func(prev ServiceManager) { serviceManager = prev }(serviceManager)
+	serviceManager = services
+
+	dmm := DeviceMixingManager{
+		CurrentCaptureDevices:  map[string]bool{"USB": true},
+		CurrentPlaybackDevices: map[string]bool{},
+	}
+	now := time.Now()
+
+	// crashes are reported to watchers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan ServiceStateUpdate, 1)
+	go services.Watch(ctx, updates)
+	assert.Eventually(func() bool {
+		services.mutex.Lock()
+		defer services.mutex.Unlock()
+		return len(services.watchers) == 1
+	}, time.Second, time.Millisecond)
+	services.Fail("zita-a2j@USB.service")
+	assert.Equal(ServiceStateUpdate{Name: "zita-a2j@USB.service", SubState: ServiceFailed}, <-updates)
+
+	// Case for services that are not expected to be running
+	dmm.handleServiceUpdate(ServiceStateUpdate{Name: "zita-j2a@USB.service", SubState: ServiceFailed}, now)
+	dmm.handleServiceUpdate(ServiceStateUpdate{Name: "jack.service", SubState: ServiceFailed}, now)
+	assert.Empty(dmm.supervisor.Due(now.Add(time.Hour)))
+
+	dmm.handleServiceUpdate(ServiceStateUpdate{Name: "zita-a2j@USB.service", SubState: "running"}, now)
+	dmm.handleServiceUpdate(ServiceStateUpdate{Name: "zita-a2j@USB.service", SubState: ServiceFailed}, now)
+	dmm.restartCrashedBridges(now)
+	assert.False(services.IsActive("zita-a2j@USB.service"))
+	dmm.restartCrashedBridges(now.Add(time.Second))
+	assert.True(services.IsActive("zita-a2j@USB.service"))
+
+	for i := 1; i < ZitaMaxCrashes; i++ {
+		dmm.handleServiceUpdate(ServiceStateUpdate{Name: "zita-a2j@USB.service", SubState: ServiceFailed}, now)
+	}
+	assert.Equal([]string{"USB"}, dmm.FailedDevices())
+}
@@ -6,6 +6,7 @@ require (
 	github.com/coreos/go-systemd/v22 v22.1.0
 	github.com/go-logr/zapr v0.3.0
 	github.com/go-ping/ping v0.0.0-20201115131931-3300c582a663
+	github.com/godbus/dbus/v5 v5.0.3
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.4.2
 	github.com/jmoiron/sqlx v1.2.0
@@ -17,7 +18,6 @@ require (
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/go-logr/logr v0.2.0 // indirect
-	github.com/godbus/dbus/v5 v5.0.3 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	go.uber.org/atomic v1.6.0 // indirect
@@ -0,0 +1,63 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when requests are not attempted because the API has been failing
+var ErrCircuitOpen = errors.New("api circuit breaker is open")
+
+// CircuitBreaker stops sending requests to the API after consecutive failures, until a cooldown passes
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	mutex     sync.Mutex
+}
+
+// NewCircuitBreaker constructs a new instance of CircuitBreaker
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+// Allow returns true if a request may be attempted
+// NOTE: after the cooldown, requests are allowed again; a single failure re-opens the circuit
+func (b *CircuitBreaker) Allow(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.failures < b.Threshold || now.Sub(b.openedAt) >= b.Cooldown
+}
+
+// Success records a successful request, closing the circuit
+func (b *CircuitBreaker) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures = 0
+}
+
+// Failure records a failed request, opening the circuit once the threshold is reached
+func (b *CircuitBreaker) Failure(now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures++
+	if b.failures >= b.Threshold {
+		b.openedAt = now
+	}
+}
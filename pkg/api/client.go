@@ -0,0 +1,266 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// AgentPingURL is the URL used to POST HTTP heartbeats
+	AgentPingURL = "/agents/ping"
+
+	// DeviceConfigURL is the URL template used to GET the latest config for a device, with ETag support
+	DeviceConfigURL = "/devices/%s/config"
+
+	// AgentConfigAckURL is the URL template used to POST config acknowledgements
+	AgentConfigAckURL = "/agents/%s/config/ack"
+
+	// AgentCrashURL is the URL template used to POST crash reports
+	AgentCrashURL = "/agents/%s/crash"
+
+	// AgentHLSURL is the URL template used to PUT and DELETE HLS playlists and segments
+	AgentHLSURL = "/agents/hls/%s"
+
+	// AgentReachabilityURL is the URL template used to POST requests for reachability probes
+	AgentReachabilityURL = "/agents/%s/reachability"
+
+	// DeviceAlsaStateURL is the URL template used to PUT and GET backups of a device's ALSA state
+	DeviceAlsaStateURL = "/devices/%s/alsa"
+
+	// DeviceTelemetryURL is the URL template used to POST telemetry that was queued while a device was offline
+	DeviceTelemetryURL = "/devices/%s/telemetry"
+
+	// DefaultRetries is the number of times failed requests are retried
+	DefaultRetries = 2
+
+	// DefaultTimeout is the maximum duration of a single request attempt
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultBreakerThreshold is the number of consecutive failures that open the circuit breaker
+	DefaultBreakerThreshold = 5
+
+	// DefaultBreakerCooldown is how long the circuit breaker stays open
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// ErrNotModified is returned when a config has not changed since it was last fetched
+var ErrNotModified = errors.New("config not modified")
+
+// StatusError is returned when the API responds with an unexpected status code
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+}
+
+// Error returns a description of the status error
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("bad response from %s %s: Status=%d", e.Method, e.Path, e.StatusCode)
+}
+
+// isRetryable returns true if a failed request may succeed when retried
+func isRetryable(err error) bool {
+	if statusErr, ok := err.(*StatusError); ok {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	return err != nil
+}
+
+// Client is used to send requests to the JackTrip API
+type Client struct {
+	Origin      string
+	Credentials client.AgentCredentials
+	HTTPClient  *http.Client
+	Breaker     *CircuitBreaker
+	Retries     int
+	RetryDelay  time.Duration
+}
+
+// NewClient constructs a new instance of Client; a nil transport uses http.DefaultTransport
+func NewClient(origin string, credentials client.AgentCredentials, transport http.RoundTripper) *Client {
+	return &Client{
+		Origin:      origin,
+		Credentials: credentials,
+		HTTPClient:  &http.Client{Transport: transport, Timeout: DefaultTimeout},
+		Breaker:     NewCircuitBreaker(DefaultBreakerThreshold, DefaultBreakerCooldown),
+		Retries:     DefaultRetries,
+		RetryDelay:  time.Second,
+	}
+}
+
+// attempt sends a single request, decoding the response into result if it is not nil
+func (c *Client) attempt(ctx context.Context, method, path string, header http.Header, body []byte, result interface{}) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.Origin+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("APIPrefix", c.Credentials.APIPrefix)
+	req.Header.Set("APISecret", c.Credentials.APISecret)
+
+	r, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		io.Copy(ioutil.Discard, r.Body)
+		return r.Header, &StatusError{Method: method, Path: path, StatusCode: r.StatusCode}
+	}
+	if result == nil {
+		return r.Header, nil
+	}
+	return r.Header, json.NewDecoder(r.Body).Decode(result)
+}
+
+// doWithHeader sends a request with extra headers, retrying transient failures with an exponential backoff,
+// and returns the headers of the last response
+func (c *Client) doWithHeader(ctx context.Context, method, path string, header http.Header, body []byte, result interface{}) (http.Header, error) {
+	if !c.Breaker.Allow(time.Now()) {
+		return nil, ErrCircuitOpen
+	}
+
+	var err error
+	var respHeader http.Header
+	delay := c.RetryDelay
+	for i := 0; i <= c.Retries; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+		respHeader, err = c.attempt(ctx, method, path, header, body, result)
+		if !isRetryable(err) {
+			break
+		}
+	}
+
+	// only count failures that indicate the API is unavailable
+	if isRetryable(err) {
+		c.Breaker.Failure(time.Now())
+	} else {
+		c.Breaker.Success()
+	}
+	return respHeader, err
+}
+
+// do sends a request, retrying transient failures with an exponential backoff
+func (c *Client) do(ctx context.Context, method, path, contentType string, body []byte, result interface{}) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	_, err := c.doWithHeader(ctx, method, path, header, body, result)
+	return err
+}
+
+// doJSON sends a request with a JSON body
+func (c *Client) doJSON(ctx context.Context, method, path string, payload, result interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, path, "application/json", body, result)
+}
+
+// SendHeartbeat sends a heartbeat to the API and returns the latest config
+func (c *Client) SendHeartbeat(ctx context.Context, beat interface{}) (client.DeviceAgentConfig, error) {
+	var config client.DeviceAgentConfig
+	err := c.doJSON(ctx, "POST", AgentPingURL, beat, &config)
+	return config, err
+}
+
+// SendServerHeartbeat sends a server heartbeat to the API and returns the latest server config
+func (c *Client) SendServerHeartbeat(ctx context.Context, beat client.ServerHeartbeat) (client.ServerAgentConfig, error) {
+	var config client.ServerAgentConfig
+	err := c.doJSON(ctx, "POST", AgentPingURL, beat, &config)
+	return config, err
+}
+
+// FetchDeviceConfig returns the latest config for a device and its ETag, or ErrNotModified if it still matches etag
+func (c *Client) FetchDeviceConfig(ctx context.Context, id, etag string) (client.DeviceAgentConfig, string, error) {
+	var config client.DeviceAgentConfig
+	header := http.Header{}
+	if etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	respHeader, err := c.doWithHeader(ctx, "GET", fmt.Sprintf(DeviceConfigURL, id), header, nil, &config)
+	if statusErr, ok := err.(*StatusError); ok && statusErr.StatusCode == http.StatusNotModified {
+		return config, etag, ErrNotModified
+	}
+	if err != nil {
+		return config, "", err
+	}
+	return config, respHeader.Get("ETag"), nil
+}
+
+// AckConfig notifies the API whether an agent applied a config
+func (c *Client) AckConfig(ctx context.Context, id string, ack client.ConfigAck) error {
+	return c.doJSON(ctx, "POST", fmt.Sprintf(AgentConfigAckURL, id), ack, nil)
+}
+
+// UploadCrashReport notifies the API that an agent crashed
+func (c *Client) UploadCrashReport(ctx context.Context, id string, report client.CrashReport) error {
+	return c.doJSON(ctx, "POST", fmt.Sprintf(AgentCrashURL, id), report, nil)
+}
+
+// UploadHLSFile sends an HLS playlist or segment to the API
+func (c *Client) UploadHLSFile(ctx context.Context, name, contentType string, body []byte) error {
+	return c.do(ctx, "PUT", fmt.Sprintf(AgentHLSURL, name), contentType, body, nil)
+}
+
+// DeleteHLSFile removes an HLS playlist or segment from the API
+func (c *Client) DeleteHLSFile(ctx context.Context, name string) error {
+	return c.do(ctx, "DELETE", fmt.Sprintf(AgentHLSURL, name), "", nil, nil)
+}
+
+// UploadAlsaState saves a backup of a device's ALSA state
+func (c *Client) UploadAlsaState(ctx context.Context, id string, backup client.AlsaStateBackup) error {
+	return c.doJSON(ctx, "PUT", fmt.Sprintf(DeviceAlsaStateURL, id), backup, nil)
+}
+
+// FetchAlsaState returns the latest backup of a device's ALSA state
+func (c *Client) FetchAlsaState(ctx context.Context, id string) (client.AlsaStateBackup, error) {
+	var backup client.AlsaStateBackup
+	err := c.do(ctx, "GET", fmt.Sprintf(DeviceAlsaStateURL, id), "", nil, &backup)
+	return backup, err
+}
+
+// RequestReachabilityProbe asks the API to send a UDP packet to an agent, to check that it can be reached
+func (c *Client) RequestReachabilityProbe(ctx context.Context, id string, probe client.ReachabilityProbe) error {
+	return c.doJSON(ctx, "POST", fmt.Sprintf(AgentReachabilityURL, id), probe, nil)
+}
+
+// SendQueuedTelemetry delivers telemetry that was queued while a device was offline, oldest first
+func (c *Client) SendQueuedTelemetry(ctx context.Context, id string, entries []client.QueuedTelemetry) error {
+	return c.doJSON(ctx, "POST", fmt.Sprintf(DeviceTelemetryURL, id), entries, nil)
+}
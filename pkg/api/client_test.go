@@ -0,0 +1,218 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// roundTripFunc is used to inject a fake transport
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func newResponse(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader(body)), Header: http.Header{}}
+}
+
+func newTestClient(transport http.RoundTripper) *Client {
+	c := NewClient("http://api.test", client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}, transport)
+	c.RetryDelay = time.Millisecond
+	return c
+}
+
+func TestSendHeartbeat(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != AgentPingURL || r.Method != "POST" || r.Header.Get("APISecret") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var beat client.DeviceHeartbeat
+		json.NewDecoder(r.Body).Decode(&beat)
+		w.Write([]byte(`{"serverHost":"` + beat.MAC + `.b.com"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}, nil)
+	config, err := c.SendHeartbeat(context.Background(), client.DeviceHeartbeat{MAC: "a"})
+	assert.Nil(err)
+	assert.Equal("a.b.com", config.Host)
+}
+
+func TestSendServerHeartbeat(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != AgentPingURL || r.Method != "POST" || r.Header.Get("APISecret") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var beat client.ServerHeartbeat
+		json.NewDecoder(r.Body).Decode(&beat)
+		w.Write([]byte(`{"serverHost":"` + beat.CloudID + `.b.com","mixCode":"mix"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}, nil)
+	config, err := c.SendServerHeartbeat(context.Background(), client.ServerHeartbeat{CloudID: "a"})
+	assert.Nil(err)
+	assert.Equal("a.b.com", config.Host)
+	assert.Equal("mix", config.MixCode)
+}
+
+func TestAckConfig(t *testing.T) {
+	assert := assert.New(t)
+	var paths []string
+	c := newTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.Method+" "+req.URL.Path)
+		body, _ := ioutil.ReadAll(req.Body)
+		assert.Equal(`{"configVersion":1,"applied":false,"error":"bad"}`, string(body))
+		return newResponse(204, ""), nil
+	}))
+
+	assert.Nil(c.AckConfig(context.Background(), "abc", client.ConfigAck{ConfigVersion: 1, Error: "bad"}))
+	assert.Equal([]string{"POST /agents/abc/config/ack"}, paths)
+}
+
+func TestFetchDeviceConfig(t *testing.T) {
+	assert := assert.New(t)
+	c := newTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		assert.Equal("/devices/abc/config", req.URL.Path)
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			return newResponse(304, ""), nil
+		}
+		r := newResponse(200, `{"period":128}`)
+		r.Header.Set("ETag", `"v1"`)
+		return r, nil
+	}))
+
+	config, etag, err := c.FetchDeviceConfig(context.Background(), "abc", "")
+	assert.Nil(err)
+	assert.Equal(128, config.Period)
+	assert.Equal(`"v1"`, etag)
+
+	// Case for unchanged config
+	_, etag, err = c.FetchDeviceConfig(context.Background(), "abc", etag)
+	assert.Equal(ErrNotModified, err)
+	assert.Equal(`"v1"`, etag)
+}
+
+func TestAlsaState(t *testing.T) {
+	assert := assert.New(t)
+	var paths []string
+	c := newTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		paths = append(paths, req.Method+" "+req.URL.Path)
+		if req.Method == "GET" {
+			return newResponse(200, `{"type":"hifiberry","state":"state.card {}"}`), nil
+		}
+		var backup client.AlsaStateBackup
+		assert.Nil(json.NewDecoder(req.Body).Decode(&backup))
+		assert.Equal("hifiberry", backup.Type)
+		return newResponse(204, ""), nil
+	}))
+
+	assert.Nil(c.UploadAlsaState(context.Background(), "abc", client.AlsaStateBackup{Type: "hifiberry", State: "state.card {}"}))
+	backup, err := c.FetchAlsaState(context.Background(), "abc")
+	assert.Nil(err)
+	assert.Equal("state.card {}", backup.State)
+	assert.Equal([]string{"PUT /devices/abc/alsa", "GET /devices/abc/alsa"}, paths)
+}
+
+func TestRetries(t *testing.T) {
+	assert := assert.New(t)
+	attempts := 0
+	c := newTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("connection refused")
+		}
+		if attempts == 2 {
+			return newResponse(503, ""), nil
+		}
+		return newResponse(200, ""), nil
+	}))
+
+	// Case for transient failures that eventually succeed
+	assert.Nil(c.UploadCrashReport(context.Background(), "abc", client.CrashReport{Message: "boom"}))
+	assert.Equal(3, attempts)
+
+	// Case for client errors, which are not retried
+	attempts = 0
+	c.HTTPClient.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return newResponse(404, ""), nil
+	})
+	err := c.UploadHLSFile(context.Background(), "live.m3u8", "application/vnd.apple.mpegurl", nil)
+	assert.Equal(1, attempts)
+	statusErr, ok := err.(*StatusError)
+	assert.True(ok)
+	assert.Equal(404, statusErr.StatusCode)
+	assert.Equal("bad response from PUT /agents/hls/live.m3u8: Status=404", err.Error())
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+	attempts := 0
+	c := newTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return newResponse(500, ""), nil
+	}))
+	c.Retries = 0
+	c.Breaker = NewCircuitBreaker(2, time.Hour)
+
+	_, err := c.SendHeartbeat(context.Background(), nil)
+	assert.NotNil(err)
+	_, err = c.SendHeartbeat(context.Background(), nil)
+	assert.NotNil(err)
+
+	// Circuit is open, so no more requests are attempted
+	_, err = c.SendHeartbeat(context.Background(), nil)
+	assert.Equal(ErrCircuitOpen, err)
+	assert.Equal(2, attempts)
+
+	// Requests are allowed again after the cooldown
+	b := NewCircuitBreaker(1, time.Minute)
+	now := time.Now()
+	b.Failure(now)
+	assert.False(b.Allow(now))
+	assert.True(b.Allow(now.Add(time.Minute)))
+	b.Success()
+	assert.True(b.Allow(now))
+}
+
+func TestRetryCancelled(t *testing.T) {
+	assert := assert.New(t)
+	c := newTestClient(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	}))
+	c.RetryDelay = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := c.FetchDeviceConfig(ctx, "abc", "")
+	assert.NotNil(err)
+}
@@ -0,0 +1,434 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package apitest provides an in-memory fake of the JackTrip control plane for integration tests
+package apitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+// Server is a fake control plane that serves heartbeat and config endpoints from memory
+type Server struct {
+	*httptest.Server
+
+	// Credentials that agents must use; requests with other credentials are rejected
+	Credentials client.AgentCredentials
+
+	config     client.DeviceAgentConfig
+	server     *client.ServerAgentConfig
+	heartbeats []json.RawMessage
+	results    []json.RawMessage
+	queued     []client.QueuedTelemetry
+	acks       []client.ConfigAck
+	crashes    []client.CrashReport
+	hlsFiles   map[string][]byte
+	alsaStates map[string]client.AlsaStateBackup
+	conns      map[*websocket.Conn]bool
+	telemetry  map[*websocket.Conn]bool
+	blocked    bool
+	legacy     bool
+	mutex      sync.Mutex
+}
+
+// NewServer starts a new fake control plane, which must be closed when done
+func NewServer(credentials client.AgentCredentials) *Server {
+	s := &Server{
+		Credentials: credentials,
+		hlsFiles:    map[string][]byte{},
+		alsaStates:  map[string]client.AlsaStateBackup{},
+		conns:       map[*websocket.Conn]bool{},
+		telemetry:   map[*websocket.Conn]bool{},
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/agents/ping", s.handlePing).Methods("POST")
+	router.HandleFunc("/agents/hls/{name}", s.handleHLS).Methods("PUT")
+	router.HandleFunc("/agents/hls/{name}", s.handleDeleteHLS).Methods("DELETE")
+	router.HandleFunc("/agents/{id}/config/ack", s.handleAck).Methods("POST")
+	router.HandleFunc("/agents/{id}/crash", s.handleCrash).Methods("POST")
+	router.HandleFunc("/agents/{id}/reachability", s.handleReachability).Methods("POST")
+	router.HandleFunc("/devices/{id}/config", s.handleGetDeviceConfig).Methods("GET")
+	router.HandleFunc("/devices/{id}/alsa", s.handlePutAlsaState).Methods("PUT")
+	router.HandleFunc("/devices/{id}/alsa", s.handleGetAlsaState).Methods("GET")
+	router.HandleFunc("/devices/{id}/telemetry", s.handleTelemetryBatch).Methods("POST")
+	router.HandleFunc("/devices/{id}/control", s.handleControl).Methods("GET")
+	router.HandleFunc("/devices/{id}/heartbeat", s.handleTelemetry).Methods("GET")
+
+	s.Server = httptest.NewServer(s.authorize(router))
+	return s
+}
+
+// Close disconnects all websockets and shuts down the server
+func (s *Server) Close() {
+	s.mutex.Lock()
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+	for c := range s.telemetry {
+		c.Close()
+		delete(s.telemetry, c)
+	}
+	s.mutex.Unlock()
+	s.Server.Close()
+}
+
+// authorize rejects requests without the expected credentials
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("APIPrefix") != s.Credentials.APIPrefix || r.Header.Get("APISecret") != s.Credentials.APISecret {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetLegacy makes the server behave like control planes without the control route, which send configs over the
+// heartbeat websocket instead
+func (s *Server) SetLegacy(legacy bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.legacy = legacy
+}
+
+// SetConfig changes the config returned to agents, and pushes it to all connected control websockets
+// (or to the heartbeat websockets, in legacy mode)
+func (s *Server) SetConfig(config client.DeviceAgentConfig) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.config = config
+	conns := s.conns
+	if s.legacy {
+		conns = s.telemetry
+	}
+	for c := range conns {
+		if err := c.WriteJSON(config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetServerConfig makes HTTP heartbeats return a server config instead of the device config, as the control
+// plane does for agents running on audio servers
+func (s *Server) SetServerConfig(config client.ServerAgentConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.server = &config
+}
+
+// SendCommand sends a command to all connected control websockets
+func (s *Server) SendCommand(command client.AgentCommand) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.conns {
+		if err := c.WriteJSON(command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Connections returns the number of connected control websockets
+func (s *Server) Connections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.conns)
+}
+
+// TelemetryConnections returns the number of connected telemetry websockets
+func (s *Server) TelemetryConnections() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.telemetry)
+}
+
+// Heartbeats returns all heartbeats received over HTTP or telemetry websockets
+func (s *Server) Heartbeats() []json.RawMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]json.RawMessage{}, s.heartbeats...)
+}
+
+// QueuedTelemetry returns all telemetry that agents queued while offline, in the order it was received
+func (s *Server) QueuedTelemetry() []client.QueuedTelemetry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]client.QueuedTelemetry{}, s.queued...)
+}
+
+// Results returns all command results received over control websockets
+func (s *Server) Results() []json.RawMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]json.RawMessage{}, s.results...)
+}
+
+// Acks returns all config acknowledgements received
+func (s *Server) Acks() []client.ConfigAck {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]client.ConfigAck{}, s.acks...)
+}
+
+// Crashes returns all crash reports received
+func (s *Server) Crashes() []client.CrashReport {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]client.CrashReport{}, s.crashes...)
+}
+
+// HLSFile returns the contents of an uploaded HLS file
+func (s *Server) HLSFile(name string) ([]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	body, ok := s.hlsFiles[name]
+	return body, ok
+}
+
+// AlsaState returns the ALSA state backup of a device
+func (s *Server) AlsaState(id string) (client.AlsaStateBackup, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	backup, ok := s.alsaStates[id]
+	return backup, ok
+}
+
+// SetAlsaState changes the ALSA state backup of a device
+func (s *Server) SetAlsaState(id string, backup client.AlsaStateBackup) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.alsaStates[id] = backup
+}
+
+// SetUnreachable drops the UDP packets of reachability probes instead of sending them, as a firewall would
+func (s *Server) SetUnreachable(blocked bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.blocked = blocked
+}
+
+// respondConfig writes the current config as a response
+func (s *Server) respondConfig(w http.ResponseWriter) {
+	s.mutex.Lock()
+	config := s.config
+	s.mutex.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(config)
+}
+
+func (s *Server) handlePing(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil || !json.Valid(body) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mutex.Lock()
+	s.heartbeats = append(s.heartbeats, body)
+	server := s.server
+	s.mutex.Unlock()
+	if server != nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(server)
+		return
+	}
+	s.respondConfig(w)
+}
+
+func (s *Server) handleGetDeviceConfig(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	etag := fmt.Sprintf("%q", client.GetConfigHash(s.config))
+	s.mutex.Unlock()
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	s.respondConfig(w)
+}
+
+func (s *Server) handleAck(w http.ResponseWriter, r *http.Request) {
+	var ack client.ConfigAck
+	if err := json.NewDecoder(r.Body).Decode(&ack); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mutex.Lock()
+	s.acks = append(s.acks, ack)
+	s.mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleCrash(w http.ResponseWriter, r *http.Request) {
+	var report client.CrashReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mutex.Lock()
+	s.crashes = append(s.crashes, report)
+	s.mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReachability sends the token of a probe to the requested UDP port of the agent's address
+func (s *Server) handleReachability(w http.ResponseWriter, r *http.Request) {
+	var probe client.ReachabilityProbe
+	if err := json.NewDecoder(r.Body).Decode(&probe); err != nil || probe.Port <= 0 || probe.Token == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mutex.Lock()
+	blocked := s.blocked
+	s.mutex.Unlock()
+	if !blocked {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		if c, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(probe.Port))); err == nil {
+			c.Write([]byte(probe.Token))
+			c.Close()
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) handleHLS(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mutex.Lock()
+	s.hlsFiles[mux.Vars(r)["name"]] = body
+	s.mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleDeleteHLS(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	delete(s.hlsFiles, mux.Vars(r)["name"])
+	s.mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handlePutAlsaState(w http.ResponseWriter, r *http.Request) {
+	var backup client.AlsaStateBackup
+	if err := json.NewDecoder(r.Body).Decode(&backup); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.SetAlsaState(mux.Vars(r)["id"], backup)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetAlsaState(w http.ResponseWriter, r *http.Request) {
+	backup, ok := s.AlsaState(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(backup)
+}
+
+func (s *Server) handleTelemetryBatch(w http.ResponseWriter, r *http.Request) {
+	var entries []client.QueuedTelemetry
+	if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mutex.Lock()
+	s.queued = append(s.queued, entries...)
+	s.mutex.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleControl sends the current config on connect, then records command results until the agent disconnects
+func (s *Server) handleControl(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	legacy := s.legacy
+	s.mutex.Unlock()
+	if legacy {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	s.conns[c] = true
+	err = c.WriteJSON(s.config)
+	s.mutex.Unlock()
+	if err != nil {
+		c.Close()
+		return
+	}
+	s.readWebsocket(c, s.conns, &s.results)
+}
+
+// handleTelemetry records heartbeats until the agent disconnects; in legacy mode, it also sends the current config
+// on connect
+func (s *Server) handleTelemetry(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	c, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	s.telemetry[c] = true
+	if s.legacy {
+		err = c.WriteJSON(s.config)
+	}
+	s.mutex.Unlock()
+	if err != nil {
+		c.Close()
+		return
+	}
+	s.readWebsocket(c, s.telemetry, &s.heartbeats)
+}
+
+// readWebsocket appends each message to messages until the connection closes, then forgets the connection
+func (s *Server) readWebsocket(c *websocket.Conn, conns map[*websocket.Conn]bool, messages *[]json.RawMessage) {
+	for {
+		_, message, err := c.ReadMessage()
+		if err != nil {
+			break
+		}
+		s.mutex.Lock()
+		*messages = append(*messages, message)
+		s.mutex.Unlock()
+	}
+
+	s.mutex.Lock()
+	delete(conns, c)
+	s.mutex.Unlock()
+	c.Close()
+}
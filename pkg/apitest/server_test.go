@@ -0,0 +1,75 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apitest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/api"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	server := NewServer(credentials)
+	defer server.Close()
+
+	config := client.DeviceAgentConfig{}
+	config.Host = "a.b.com"
+	assert.Nil(server.SetConfig(config))
+
+	ctx := context.Background()
+	c := api.NewClient(server.URL, credentials, nil)
+	received, err := c.SendHeartbeat(ctx, client.DeviceHeartbeat{MAC: "abc"})
+	assert.Nil(err)
+	assert.Equal("a.b.com", received.Host)
+	assert.Equal(1, len(server.Heartbeats()))
+
+	received, etag, err := c.FetchDeviceConfig(ctx, "abc", "")
+	assert.Nil(err)
+	assert.Equal("a.b.com", received.Host)
+	_, _, err = c.FetchDeviceConfig(ctx, "abc", etag)
+	assert.Equal(api.ErrNotModified, err)
+
+	assert.Nil(c.AckConfig(ctx, "abc", client.ConfigAck{Applied: true}))
+	assert.Equal([]client.ConfigAck{{Applied: true}}, server.Acks())
+
+	assert.Nil(c.UploadCrashReport(ctx, "abc", client.CrashReport{Message: "boom"}))
+	assert.Equal("boom", server.Crashes()[0].Message)
+
+	assert.Nil(c.UploadHLSFile(ctx, "live.m3u8", "application/vnd.apple.mpegurl", []byte("#EXTM3U")))
+	body, ok := server.HLSFile("live.m3u8")
+	assert.True(ok)
+	assert.Equal("#EXTM3U", string(body))
+	assert.Nil(c.DeleteHLSFile(ctx, "live.m3u8"))
+	_, ok = server.HLSFile("live.m3u8")
+	assert.False(ok)
+
+	serverConfig := client.ServerAgentConfig{MixCode: "mix"}
+	serverConfig.Host = "c.d.com"
+	server.SetServerConfig(serverConfig)
+	receivedServer, err := c.SendServerHeartbeat(ctx, client.ServerHeartbeat{CloudID: "abc"})
+	assert.Nil(err)
+	assert.Equal(serverConfig, receivedServer)
+	assert.Equal(2, len(server.Heartbeats()))
+
+	// Case for wrong credentials
+	c = api.NewClient(server.URL, client.AgentCredentials{APIPrefix: "prefix", APISecret: "wrong"}, nil)
+	_, _, err = c.FetchDeviceConfig(ctx, "abc", "")
+	assert.NotNil(err)
+}
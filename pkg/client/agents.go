@@ -0,0 +1,165 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ConfigAck is used to notify the control plane whether an agent applied a config
+type ConfigAck struct {
+	// version of the config that was received
+	ConfigVersion int `json:"configVersion"`
+
+	// true if the config was applied
+	Applied bool `json:"applied"`
+
+	// reason the config was not applied
+	Error string `json:"error,omitempty"`
+}
+
+// CrashReport is used to notify the control plane when an agent crashes
+type CrashReport struct {
+	// version of the agent that crashed
+	Version string `json:"version"`
+
+	// reason for the crash
+	Message string `json:"message"`
+
+	// stack trace at the time of the crash
+	Stack string `json:"stack"`
+
+	// timestamp when the crash occurred
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DoctorCheck is the result of a single self-diagnostic check
+type DoctorCheck struct {
+	// name of the check
+	Name string `json:"name"`
+
+	// true if the check passed
+	Passed bool `json:"passed"`
+
+	// details about the result
+	Message string `json:"message"`
+}
+
+// DoctorReport is the result of running all self-diagnostic checks
+type DoctorReport struct {
+	// true if all checks passed
+	Passed bool `json:"passed"`
+
+	// results of each check
+	Checks []DoctorCheck `json:"checks"`
+
+	// timestamp when the checks were run
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// AgentCommand is sent by the control plane over websockets to request an action from an agent
+type AgentCommand struct {
+	// name of the command (ie. "doctor")
+	Command string `json:"command"`
+
+	// destination for commands that upload data (ie. a pre-signed URL for "export")
+	UploadURL string `json:"uploadUrl,omitempty"`
+
+	// name of the mix preset for commands that switch presets (ie. "preset")
+	Preset string `json:"preset,omitempty"`
+
+	// id of the device whose saved state is restored (ie. for "alsa-restore"); defaults to the receiving device
+	Device string `json:"device,omitempty"`
+}
+
+// DataExportReport is the result of exporting and purging all data stored locally by an agent
+type DataExportReport struct {
+	// names of the files included in the export
+	Files []string `json:"files"`
+
+	// size of the export archive, in bytes
+	Size int `json:"size"`
+
+	// true if the export was uploaded
+	Uploaded bool `json:"uploaded"`
+
+	// true if local data was purged after uploading
+	Purged bool `json:"purged"`
+
+	// details about the failure, if the export did not complete
+	Error string `json:"error,omitempty"`
+
+	// timestamp when the export finished
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// StandbyReport is the result of switching a device between its studio server and a standby server
+type StandbyReport struct {
+	// host the device is connected to after the command
+	Host string `json:"host"`
+
+	// true if the device is connected to the standby server
+	Standby bool `json:"standby"`
+
+	// details about the failure, if the device could not switch servers
+	Error string `json:"error,omitempty"`
+}
+
+// AlsaStateBackup is a copy of the ALSA mixer state of a device, stored by the control plane
+type AlsaStateBackup struct {
+	// type of sound device the state was saved from (ie. "snd_rpi_hifiberry_dacplusadcpro")
+	Type string `json:"type"`
+
+	// contents of the state file, as written by `alsactl store`
+	State string `json:"state"`
+
+	// timestamp when the state was saved
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// AlsaRestoreReport is the result of restoring the ALSA mixer state of a device from a backup
+type AlsaRestoreReport struct {
+	// id of the device the backup was saved from
+	Source string `json:"source"`
+
+	// true if the state was restored
+	Restored bool `json:"restored"`
+
+	// details about the failure, if the state was not restored
+	Error string `json:"error,omitempty"`
+
+	// timestamp when the restore finished
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// AgentCommandResult is sent by an agent over websockets in response to an AgentCommand
+type AgentCommandResult struct {
+	// name of the command
+	Command string `json:"command"`
+
+	// result of the command
+	Result interface{} `json:"result"`
+}
+
+// QueuedTelemetry is a heartbeat or event that an agent could not send while it was offline, and sent after
+// reconnecting so that the control plane sees what happened during the outage
+type QueuedTelemetry struct {
+	// timestamp when the message was queued
+	QueuedAt time.Time `json:"queuedAt"`
+
+	// the original message
+	Message json.RawMessage `json:"message"`
+}
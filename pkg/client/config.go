@@ -0,0 +1,52 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"time"
+)
+
+// AgentConfigVersion is the latest version of agent configs understood by this agent
+// NOTE: increment this whenever a config section changes in a way that older agents can't safely ignore
+const AgentConfigVersion = 1
+
+// VersionConfig identifies the version of an agent config; configs without a version are treated as version 0
+type VersionConfig struct {
+	// version of the config schema
+	ConfigVersion int `json:"configVersion" db:"config_version"`
+}
+
+// IsSupported returns true if this agent understands the config version
+func (c VersionConfig) IsSupported() bool {
+	return c.ConfigVersion >= 0 && c.ConfigVersion <= AgentConfigVersion
+}
+
+// BufferConfig defines JackTrip network buffering, shared by devices and servers
+type BufferConfig struct {
+	// frames per period
+	Period int `json:"period" db:"period"`
+
+	// size of jitter queue buffer
+	QueueBuffer int `json:"queueBuffer" db:"queue_buffer"`
+
+	// strategy to use for the network jitter buffer
+	BufferStrategy int `json:"bufferStrategy" db:"buffer_strategy"`
+}
+
+// ScheduleConfig defines when a studio is active, shared by devices and servers
+type ScheduleConfig struct {
+	// timestamp when the studio server will automatically be paused
+	ExpiresAt time.Time `json:"expiresAt" db:"expires_at"`
+}
@@ -0,0 +1,51 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionConfigIsSupported(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(VersionConfig{}.IsSupported())
+	assert.True(VersionConfig{ConfigVersion: AgentConfigVersion}.IsSupported())
+	assert.False(VersionConfig{ConfigVersion: AgentConfigVersion + 1}.IsSupported())
+	assert.False(VersionConfig{ConfigVersion: -1}.IsSupported())
+}
+
+func TestSharedConfigSections(t *testing.T) {
+	assert := assert.New(t)
+	raw := []byte(`{"period":128,"queueBuffer":4,"bufferStrategy":3,"expiresAt":"2022-01-02T03:04:05Z","configVersion":1}`)
+
+	// Devices and servers decode the same sections from identical JSON
+	var device DeviceAgentConfig
+	var server ServerAgentConfig
+	assert.Nil(json.Unmarshal(raw, &device))
+	assert.Nil(json.Unmarshal(raw, &server))
+	assert.Equal(device.BufferConfig, server.BufferConfig)
+	assert.Equal(device.ScheduleConfig, server.ScheduleConfig)
+	assert.Equal(device.VersionConfig, server.VersionConfig)
+	assert.Equal(128, device.Period)
+	assert.Equal(1, server.ConfigVersion)
+
+	// Fields are still flattened when encoding
+	encoded, err := json.Marshal(device.BufferConfig)
+	assert.Nil(err)
+	assert.Equal(`{"period":128,"queueBuffer":4,"bufferStrategy":3}`, string(encoded))
+}
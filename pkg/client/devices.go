@@ -16,12 +16,103 @@ package client
 
 import (
 	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/jmoiron/sqlx/types"
 )
 
+// MetronomeRouting is used to determine where metronome audio is sent
+type MetronomeRouting string
+
+const (
+	// MetronomeMonitor sends metronome audio to the local monitor output only
+	MetronomeMonitor MetronomeRouting = "monitor"
+
+	// MetronomeServer sends metronome audio to the audio server only
+	MetronomeServer MetronomeRouting = "server"
+
+	// MetronomeMonitorAndServer sends metronome audio to both the local monitor output and the audio server
+	MetronomeMonitorAndServer MetronomeRouting = "both"
+)
+
+// TimecodeFormat is used to determine which timecode is generated from JACK transport
+type TimecodeFormat string
+
+const (
+	// TimecodeOff disables timecode generation
+	TimecodeOff TimecodeFormat = ""
+
+	// TimecodeLTC generates linear timecode as audio
+	TimecodeLTC TimecodeFormat = "ltc"
+
+	// TimecodeMTC generates MIDI timecode
+	TimecodeMTC TimecodeFormat = "mtc"
+)
+
+// TransportAction is used to determine how JACK transport is controlled
+type TransportAction string
+
+const (
+	// TransportStart starts JACK transport rolling
+	TransportStart TransportAction = "start"
+
+	// TransportStop stops JACK transport
+	TransportStop TransportAction = "stop"
+
+	// TransportLocate moves JACK transport to a new position
+	TransportLocate TransportAction = "locate"
+)
+
+// TransportRequest is a request to control JACK transport on a device
+type TransportRequest struct {
+	// type of action
+	Action TransportAction `json:"action"`
+
+	// position to locate to, in seconds from the start of the timeline
+	Position float64 `json:"position"`
+}
+
+// IsValid returns true if the transport request can be performed
+func (r TransportRequest) IsValid() bool {
+	switch r.Action {
+	case TransportStart, TransportStop:
+		return true
+	case TransportLocate:
+		return r.Position >= 0
+	}
+	return false
+}
+
+// ClockSyncMode is used to determine how a device's clock is disciplined
+type ClockSyncMode string
+
+const (
+	// ClockSyncOff leaves the device clock to the operating system's default time sync
+	ClockSyncOff ClockSyncMode = ""
+
+	// ClockSyncChrony disciplines the device clock with chrony, using the configured NTP servers
+	ClockSyncChrony ClockSyncMode = "chrony"
+
+	// ClockSyncPTP disciplines the device clock with PTP (IEEE 1588), using ptp4l and phc2sys
+	ClockSyncPTP ClockSyncMode = "ptp"
+)
+
+// SampleRateStatus describes whether the device audio services are running at the configured sample rate
+type SampleRateStatus string
+
+const (
+	// SampleRateOK means JACK is running at the configured sample rate
+	SampleRateOK SampleRateStatus = "ok"
+
+	// SampleRateMismatch means JACK is running at a different sample rate than configured
+	SampleRateMismatch SampleRateStatus = "mismatch"
+
+	// SampleRateUnknown means the JACK sample rate could not be verified
+	SampleRateUnknown SampleRateStatus = "unknown"
+)
+
 // DeviceConfig defines configuration for a particular device
 type DeviceConfig struct {
 	// DevicePort is the bindport used by the device
@@ -48,12 +139,45 @@ type DeviceConfig struct {
 	// Input Channel Count
 	// 1: mono
 	// 2: stereo
+	// 3+: multichannel (surround or ambisonics), only supported by JackTrip
 	InputChannels int `json:"inputChannels" db:"input_channels"`
 
 	// Outputs Channel Count
 	// 1: mono
 	// 2: stereo
+	// 3+: multichannel (surround or ambisonics), only supported by JackTrip
 	OutputChannels int `json:"outputChannels" db:"output_channels"`
+
+	// JackTrip channel that the studio mix loops back for latency measurements (ie. 3); when 0, latency is
+	// measured on the first channel, and only while nobody else can be heard
+	LatencyChannel int `json:"latencyChannel" db:"latency_channel"`
+
+	// If true, a metronome click track will be generated on the device
+	Metronome types.BitBool `json:"metronome" db:"metronome"`
+
+	// Metronome tempo in beats per minute
+	MetronomeBPM int `json:"metronomeBpm" db:"metronome_bpm"`
+
+	// Where metronome audio is sent (defaults to the local monitor output)
+	MetronomeRouting MetronomeRouting `json:"metronomeRouting" db:"metronome_routing"`
+
+	// Timecode generated from JACK transport ("ltc" or "mtc"; defaults to none)
+	Timecode TimecodeFormat `json:"timecode" db:"timecode"`
+
+	// Timecode frames per second (24, 25 or 30; defaults to 30)
+	TimecodeFPS int `json:"timecodeFps" db:"timecode_fps"`
+
+	// If true, LTC is sent to the audio server on a dedicated JackTrip channel after the input channels
+	TimecodeToServer types.BitBool `json:"timecodeToServer" db:"timecode_to_server"`
+
+	// Comma-separated chain of effects applied to device input before it is sent to the server (ie. "gate,eq,compressor")
+	EffectsChain string `json:"effectsChain" db:"effects_chain"`
+
+	// Comma-separated chain of LV2 plugins applied to device input, after EffectsChain (ie. "gate,reverb")
+	LV2Plugins string `json:"lv2Plugins" db:"lv2_plugins"`
+
+	// If true, pprof and runtime diagnostics endpoints are enabled for authenticated requests
+	Diagnostics types.BitBool `json:"diagnostics" db:"diagnostics"`
 }
 
 // ALSAConfig defines configuration for a device's ALSA sound card
@@ -83,20 +207,153 @@ type ALSAConfig struct {
 	MonitorVolume int `json:"monitorVolume" db:"monitor_volume"`
 }
 
+// LV2Config defines parameters for a device's LV2 plugins that may be updated without restarting services
+type LV2Config struct {
+	// Comma-separated plugin parameters, each formatted as "<index>.<symbol>=<value>" (ie. "0.threshold=-30")
+	LV2Parameters string `json:"lv2Parameters" db:"lv2_parameters"`
+}
+
+// AES67Config defines configuration for bridging an AES67 network stream in place of a local sound card
+type AES67Config struct {
+	// If true, device audio is exchanged with an AES67 network instead of the local sound card
+	AES67Enabled types.BitBool `json:"aes67Enabled" db:"aes67_enabled"`
+
+	// Multicast address of the AES67 stream that is received by the device and sent to the audio server
+	AES67SourceAddress string `json:"aes67SourceAddress" db:"aes67_source_address"`
+
+	// Multicast address of the AES67 stream that is sent by the device with audio received from the audio server
+	AES67DestinationAddress string `json:"aes67DestinationAddress" db:"aes67_destination_address"`
+
+	// RTP port used by both AES67 streams (defaults to 5004)
+	AES67Port int `json:"aes67Port" db:"aes67_port"`
+
+	// Network interface connected to the AES67 network (defaults to eth0)
+	AES67Interface string `json:"aes67Interface" db:"aes67_interface"`
+
+	// PTP domain of the AES67 network clock
+	AES67PTPDomain int `json:"aes67PtpDomain" db:"aes67_ptp_domain"`
+}
+
+// MQTTConfig defines configuration for publishing device status to an MQTT broker
+type MQTTConfig struct {
+	// Address of the MQTT broker, with an optional port (ie. "homeassistant.local:1883"); publishing is disabled if empty
+	MQTTBroker string `json:"mqttBroker" db:"mqtt_broker"`
+
+	// Prefix of the topics that device status is published to (defaults to "jacktrip/<mac>")
+	MQTTTopicPrefix string `json:"mqttTopicPrefix" db:"mqtt_topic_prefix"`
+
+	// Optional credentials used to connect to the MQTT broker
+	MQTTUsername string `json:"mqttUsername" db:"mqtt_username"`
+	MQTTPassword string `json:"mqttPassword" db:"mqtt_password"`
+
+	// If true, the connection to the MQTT broker is encrypted using TLS
+	MQTTTLS types.BitBool `json:"mqttTls" db:"mqtt_tls"`
+}
+
+// LogForwardingConfig defines configuration for forwarding managed service logs to a remote syslog server
+type LogForwardingConfig struct {
+	// Address of the remote syslog server, including protocol (ie. "udp://logs.example.com:514"); forwarding is disabled if empty
+	SyslogAddress string `json:"syslogAddress" db:"syslog_address"`
+
+	// Comma-separated systemd units to forward, each with an optional minimum priority (ie. "jack.service=warning,zita-*")
+	SyslogFilters string `json:"syslogFilters" db:"syslog_filters"`
+}
+
+// ClockSyncConfig defines configuration for synchronizing the clocks of devices in multi-room installations
+type ClockSyncConfig struct {
+	// How the device clock is disciplined ("chrony" or "ptp"); disabled if empty
+	ClockSync ClockSyncMode `json:"clockSync" db:"clock_sync"`
+
+	// Comma-separated NTP servers used by chrony (ie. "ntp1.local,ntp2.local")
+	ClockSyncServers string `json:"clockSyncServers" db:"clock_sync_servers"`
+
+	// Network interface used for PTP (defaults to eth0)
+	ClockSyncInterface string `json:"clockSyncInterface" db:"clock_sync_interface"`
+
+	// PTP domain of the grandmaster clock
+	ClockSyncPTPDomain int `json:"clockSyncPtpDomain" db:"clock_sync_ptp_domain"`
+}
+
+// StandbyConfig defines a standby audio server that a device can switch to if its studio server fails
+type StandbyConfig struct {
+	// hostname of the standby server; disabled if empty
+	StandbyHost string `json:"standbyHost" db:"standby_host"`
+
+	// port number the standby server is listening on (defaults to the studio server port)
+	StandbyPort int `json:"standbyPort" db:"standby_port"`
+
+	// Number of consecutive missed keepalives before switching to the standby server (0 means only on command)
+	StandbyMissedKeepalives int `json:"standbyMissedKeepalives" db:"standby_missed_keepalives"`
+}
+
+// JitterTuningConfig defines bounds for automatically tuning the jitter queue of a device
+type JitterTuningConfig struct {
+	// If true, the jitter queue is adjusted based upon packet loss and jitter, instead of using QueueBuffer
+	AdaptiveQueue types.BitBool `json:"adaptiveQueue" db:"adaptive_queue"`
+
+	// Smallest jitter queue that may be used when AdaptiveQueue is true
+	QueueBufferMin int `json:"queueBufferMin" db:"queue_buffer_min"`
+
+	// Largest jitter queue that may be used when AdaptiveQueue is true
+	QueueBufferMax int `json:"queueBufferMax" db:"queue_buffer_max"`
+}
+
+// LocalAccessConfig defines how the device HTTP server may be used by others on the local network
+type LocalAccessConfig struct {
+	// If true, device info and the web app redirect require a signed request or the token of a paired app
+	LocalAuth types.BitBool `json:"localAuth" db:"local_auth"`
+
+	// Requests per minute allowed from each address to the device HTTP server (0 uses the default)
+	LocalRateLimit int `json:"localRateLimit" db:"local_rate_limit"`
+
+	// If true, nftables rules drop incoming traffic except on the ports used by the agent and its services
+	Firewall types.BitBool `json:"firewall" db:"firewall"`
+}
+
+// LocalRecordingConfig defines a safety recording of the device's own inputs, written to attached USB storage
+type LocalRecordingConfig struct {
+	// If true, the local capture ports are recorded to FLAC while the device is connected to a studio server
+	LocalRecording types.BitBool `json:"localRecording" db:"local_recording"`
+
+	// Free space to keep on the USB storage, in MB; recording stops below it (0 uses the default)
+	LocalRecordingMinFree int `json:"localRecordingMinFree" db:"local_recording_min_free"`
+}
+
+// StorageConfig designates the USB drive used for local recordings
+type StorageConfig struct {
+	// filesystem label of the USB drive to use (ie. "JACKTRIP"); the first USB drive is used if empty
+	StorageLabel string `json:"storageLabel" db:"storage_label"`
+}
+
+// NetworkBindingConfig pins audio traffic to a network interface or source address, for installations with
+// separate control and audio networks
+type NetworkBindingConfig struct {
+	// network interface used by JackTrip (ie. "eth0.20"); defaults to the interface of the default route
+	AudioInterface string `json:"audioInterface" db:"audio_interface"`
+
+	// source IP address used by JackTrip; overrides AudioInterface
+	AudioAddress string `json:"audioAddress" db:"audio_address"`
+}
+
 // DeviceAgentConfig defines active configuration for a device
 type DeviceAgentConfig struct {
 	DeviceConfig
 	ALSAConfig
+	LV2Config
+	AES67Config
+	MQTTConfig
+	LogForwardingConfig
+	ClockSyncConfig
+	StandbyConfig
+	JitterTuningConfig
+	LocalAccessConfig
+	LocalRecordingConfig
+	StorageConfig
+	NetworkBindingConfig
 	ServerConfig
-
-	// frames per period
-	Period int `json:"period" db:"period"`
-
-	// size of jitter queue buffer
-	QueueBuffer int `json:"queueBuffer" db:"queue_buffer"`
-
-	// strategy to use for the network jitter buffer
-	BufferStrategy int `json:"bufferStrategy" db:"buffer_strategy"`
+	BufferConfig
+	ScheduleConfig
+	VersionConfig
 
 	// authorization token used by jacktrip-agent to access studio servers
 	AuthToken string `json:"authToken" db:"auth_token"`
@@ -149,6 +406,152 @@ func GetAPIHash(apiSecret string) string {
 	return fmt.Sprintf("%x", sha256.Sum256([]byte(apiSecret)))
 }
 
+// GetConfigHash returns a hash of a device config, used to detect devices running a stale config
+func GetConfigHash(config DeviceAgentConfig) string {
+	rawBytes, _ := json.Marshal(config)
+	return fmt.Sprintf("%x", sha256.Sum256(rawBytes))
+}
+
+// LatencyReport is the result of measuring the latency from a device through the studio mix and back
+type LatencyReport struct {
+	// Time for a marker to travel from the device through the studio mix and back, in milliseconds
+	RoundTrip float64 `json:"roundTrip"`
+
+	// Estimated latency added by the device sound card buffers, in milliseconds
+	Device float64 `json:"device"`
+
+	// Mouth-to-ear latency, including device buffers, in milliseconds
+	Total float64 `json:"total"`
+
+	// details about the failure, if the measurement did not complete
+	Error string `json:"error,omitempty"`
+
+	// timestamp when the measurement finished
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// LocalRecordingStatus describes the safety recording of a device's own inputs
+type LocalRecordingStatus struct {
+	// true while the local capture ports are being recorded
+	Recording bool `json:"recording"`
+
+	// path of the file being recorded, or of the last recording
+	File string `json:"file,omitempty"`
+
+	// number of capture channels being recorded
+	Channels int `json:"channels,omitempty"`
+
+	// free space remaining on the USB storage, in MB
+	FreeSpace uint64 `json:"freeSpace,omitempty"`
+
+	// reason the device is not recording, if it should be (ie. "no USB storage is mounted")
+	Error string `json:"error,omitempty"`
+
+	// timestamp when the status last changed
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// StorageStatus describes the USB drive used for local recordings
+type StorageStatus struct {
+	// path of the block device (ie. "/dev/sda1")
+	Device string `json:"device,omitempty"`
+
+	// filesystem label of the drive
+	Label string `json:"label,omitempty"`
+
+	// filesystem type of the drive (ie. "vfat")
+	FSType string `json:"fsType,omitempty"`
+
+	// directory the drive is mounted on, if it is mounted
+	MountPoint string `json:"mountPoint,omitempty"`
+
+	// true if the filesystem is mounted and writable
+	Healthy bool `json:"healthy"`
+
+	// free space remaining on the drive, in MB
+	FreeSpace uint64 `json:"freeSpace,omitempty"`
+
+	// size of the filesystem, in MB
+	TotalSpace uint64 `json:"totalSpace,omitempty"`
+
+	// reason the drive can't be used, if any (ie. "filesystem is mounted read-only")
+	Error string `json:"error,omitempty"`
+
+	// timestamp when the drive was last checked
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// DeviceMetrics defines periodically collected audio metrics for a device
+type DeviceMetrics struct {
+	// Number of registered JACK ports
+	JackPorts int `json:"jackPorts"`
+
+	// Number of connections between JACK ports
+	JackConnections int `json:"jackConnections"`
+
+	// Number of JACK xruns since the agent started
+	Xruns int `json:"xruns"`
+
+	// Number of running zita-a2j bridges for USB capture devices
+	ZitaCaptureBridges int `json:"zitaCaptureBridges"`
+
+	// Number of running zita-j2a bridges for USB playback devices
+	ZitaPlaybackBridges int `json:"zitaPlaybackBridges"`
+
+	// Latest ping statistics to the audio server
+	PingStats PingStats `json:"pingStats"`
+
+	// Latest status of the device clock, if clock sync is enabled
+	ClockSync *ClockSyncStatus `json:"clockSync,omitempty"`
+
+	// Network traffic of JackTrip during the current session, if the device is connected to an audio server
+	Bandwidth *BandwidthUsage `json:"bandwidth,omitempty"`
+
+	// timestamp when the metrics were collected
+	CollectedAt time.Time `json:"collectedAt"`
+}
+
+// BandwidthUsage describes the network traffic of JackTrip audio during a session
+type BandwidthUsage struct {
+	// Bytes of audio sent to the audio server
+	BytesSent uint64 `json:"bytesSent"`
+
+	// Bytes of audio received from the audio server
+	BytesReceived uint64 `json:"bytesReceived"`
+
+	// Time when the session started
+	SessionStartedAt time.Time `json:"sessionStartedAt"`
+}
+
+// ClockSyncStatus describes how closely a device clock is synchronized to its reference
+type ClockSyncStatus struct {
+	// How the device clock is disciplined
+	Mode ClockSyncMode `json:"mode"`
+
+	// True if the clock is locked to its reference
+	Synchronized bool `json:"synchronized"`
+
+	// Estimated offset of the device clock from its reference
+	Offset time.Duration `json:"offset"`
+
+	// Reference clock (NTP server or PTP grandmaster identity)
+	Source string `json:"source,omitempty"`
+}
+
+// IdentitySource is used to determine where a device's identity (MAC address) came from
+type IdentitySource string
+
+const (
+	// IdentityInterface means the MAC address of the configured network interface (eth0 by default)
+	IdentityInterface IdentitySource = "interface"
+
+	// IdentityPhysical means the MAC address of another physical network interface (ie. Wi-Fi or a USB NIC)
+	IdentityPhysical IdentitySource = "physical"
+
+	// IdentityMachineID means a locally administered MAC address derived from /etc/machine-id
+	IdentityMachineID IdentitySource = "machine-id"
+)
+
 // DeviceHeartbeat is used to send heartbeat messages from devices
 type DeviceHeartbeat struct {
 	PingStats
@@ -156,9 +559,60 @@ type DeviceHeartbeat struct {
 	// MAC address for ethernet device (used when running on raspberry pi device)
 	MAC string `json:"mac"`
 
+	// Source of the MAC address used to identify the device
+	IdentitySource IdentitySource `json:"identitySource,omitempty"`
+
 	// Current image version for the device
 	Version string `json:"version"`
 
 	// Type of sound device ("snd_rpi_hifiberry_dacplusadcpro")
 	Type string `json:"type" db:"type"`
+
+	// Sample rate that JACK is running at, verified after services restart
+	SampleRate int `json:"sampleRate,omitempty"`
+
+	// Whether JACK is running at the configured sample rate
+	SampleRateStatus SampleRateStatus `json:"sampleRateStatus,omitempty"`
+
+	// Reason the most recently received config was rejected, if it was invalid
+	ConfigError string `json:"configError,omitempty"`
+
+	// Port needed by managed services that is held by another process (ie. "udp port 4464 is in use by jacktrip (pid 123)")
+	PortConflict string `json:"portConflict,omitempty"`
+
+	// Reason the managed services were not started (ie. "timed out after 1m0s waiting for jackd")
+	ServicesError string `json:"servicesError,omitempty"`
+
+	// Latest periodically collected metrics
+	Metrics *DeviceMetrics `json:"metrics,omitempty"`
+
+	// Result of the most recent end-to-end latency measurement
+	Latency *LatencyReport `json:"latency,omitempty"`
+
+	// Safety recording of the device's own inputs, when it is enabled
+	LocalRecording *LocalRecordingStatus `json:"localRecording,omitempty"`
+
+	// USB drive used for local recordings, when one is attached or designated
+	Storage *StorageStatus `json:"storage,omitempty"`
+
+	// USB audio devices whose zita bridges crashed repeatedly, and are no longer restarted
+	FailedDevices []string `json:"failedDevices,omitempty"`
+
+	// Hash of the currently applied config
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// Time when the current config was applied
+	ConfigAppliedAt time.Time `json:"configAppliedAt"`
+
+	// Seconds since the last message was received over the websocket (-1 if none was received)
+	LastMessageAge float64 `json:"lastMessageAge"`
+
+	// Duration in seconds of the last control plane outage that was ridden out without restarting audio services
+	LastOutage float64 `json:"lastOutage,omitempty"`
+
+	// Host of the standby server, if the device switched to it
+	StandbyActiveHost string `json:"standbyActiveHost,omitempty"`
+
+	// Jitter queue currently used by JackTrip, when it is tuned automatically
+	QueueBuffer int `json:"queueBuffer,omitempty"`
 }
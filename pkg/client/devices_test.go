@@ -47,6 +47,16 @@ func TestDeviceConfig(t *testing.T) {
 	assert.Equal(false, bool(target.Limiter))
 	assert.Equal(true, bool(target.Compressor))
 	assert.Equal(1, target.Quality)
+	assert.Equal(false, bool(target.Metronome))
+
+	raw = `{"metronome": true, "metronomeBpm": 90, "metronomeRouting": "both", "effectsChain": "gate,compressor", "lv2Plugins": "reverb"}`
+	target = DeviceConfig{}
+	json.Unmarshal([]byte(raw), &target)
+	assert.Equal(true, bool(target.Metronome))
+	assert.Equal(90, target.MetronomeBPM)
+	assert.Equal(MetronomeMonitorAndServer, target.MetronomeRouting)
+	assert.Equal("gate,compressor", target.EffectsChain)
+	assert.Equal("reverb", target.LV2Plugins)
 }
 
 func TestALSAConfig(t *testing.T) {
@@ -114,6 +124,12 @@ func TestDeviceAgentConfig(t *testing.T) {
 	assert.Equal(2, target.OutputChannels)
 	assert.Equal(true, bool(target.Enabled))
 	assert.Equal("foobar", target.AuthToken)
+	assert.Equal("2020-04-09 13:00:00 +0000 UTC", target.ExpiresAt.String())
+
+	raw = `{"lv2Parameters": "0.threshold=-30"}`
+	target = DeviceAgentConfig{}
+	json.Unmarshal([]byte(raw), &target)
+	assert.Equal("0.threshold=-30", target.LV2Parameters)
 }
 
 func TestAgentCredentials(t *testing.T) {
@@ -135,6 +151,17 @@ func TestGetAPIHash(t *testing.T) {
 	assert.Equal("b13dabc4285540382af3f280bfc55c0752806a177f896afa8ec568b0206c3bf5", result)
 }
 
+func TestGetConfigHash(t *testing.T) {
+	assert := assert.New(t)
+	config := DeviceAgentConfig{}
+	config.Host = "a.b.com"
+	hash := GetConfigHash(config)
+	assert.Len(hash, 64)
+	assert.Equal(hash, GetConfigHash(config))
+	config.Host = "c.d.com"
+	assert.NotEqual(hash, GetConfigHash(config))
+}
+
 func TestDeviceHeartbeat(t *testing.T) {
 	assert := assert.New(t)
 	var raw string
@@ -0,0 +1,122 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import "fmt"
+
+// ChannelLayout describes how the audio channels of a studio mix are arranged
+type ChannelLayout string
+
+const (
+	// LayoutMono is a single channel
+	LayoutMono ChannelLayout = "mono"
+
+	// LayoutStereo is left and right channels (default)
+	LayoutStereo ChannelLayout = "stereo"
+
+	// LayoutQuad is front left, front right, back left and back right channels
+	LayoutQuad ChannelLayout = "quad"
+
+	// Layout51 is 5.1 surround (FL, FR, FC, LFE, BL, BR)
+	Layout51 ChannelLayout = "5.1"
+
+	// Layout71 is 7.1 surround (FL, FR, FC, LFE, BL, BR, SL, SR)
+	Layout71 ChannelLayout = "7.1"
+
+	// LayoutAmbisonics1 is first order ambisonics in AmbiX format (ACN ordering, SN3D normalization)
+	LayoutAmbisonics1 ChannelLayout = "ambix1"
+
+	// LayoutAmbisonics2 is second order ambisonics in AmbiX format
+	LayoutAmbisonics2 ChannelLayout = "ambix2"
+
+	// LayoutAmbisonics3 is third order ambisonics in AmbiX format
+	LayoutAmbisonics3 ChannelLayout = "ambix3"
+
+	// MaxLayoutChannels is the largest number of channels used by any supported layout
+	MaxLayoutChannels = 16
+
+	// channelLayoutSCLangTemplate sets the environment variables read by the jacktrip-sc mixer for its channel layout
+	channelLayoutSCLangTemplate = "~channelLayout = \"%s\";\n~ambisonicOrder = %d;\n"
+)
+
+// layoutChannels maps each supported layout to its number of channels
+var layoutChannels = map[ChannelLayout]int{
+	LayoutMono:        1,
+	LayoutStereo:      2,
+	LayoutQuad:        4,
+	Layout51:          6,
+	Layout71:          8,
+	LayoutAmbisonics1: 4,
+	LayoutAmbisonics2: 9,
+	LayoutAmbisonics3: 16,
+}
+
+// IsValid returns true if a layout is supported; an empty layout is treated as stereo
+func (l ChannelLayout) IsValid() bool {
+	_, ok := layoutChannels[l]
+	return ok || l == ""
+}
+
+// Channels returns the number of audio channels used by a layout
+func (l ChannelLayout) Channels() int {
+	if n, ok := layoutChannels[l]; ok {
+		return n
+	}
+	return layoutChannels[LayoutStereo]
+}
+
+// IsAmbisonics returns true if a layout carries an ambisonic sound field instead of speaker feeds
+func (l ChannelLayout) IsAmbisonics() bool {
+	return l == LayoutAmbisonics1 || l == LayoutAmbisonics2 || l == LayoutAmbisonics3
+}
+
+// AmbisonicOrder returns the order of an ambisonic layout, or 0 for speaker layouts
+func (l ChannelLayout) AmbisonicOrder() int {
+	switch l {
+	case LayoutAmbisonics1:
+		return 1
+	case LayoutAmbisonics2:
+		return 2
+	case LayoutAmbisonics3:
+		return 3
+	}
+	return 0
+}
+
+// FFmpegLayout returns the channel layout name understood by ffmpeg; ambisonic layouts have no
+// speaker positions, so they are described by their number of channels (ie. "4c")
+func (l ChannelLayout) FFmpegLayout() string {
+	if l.IsAmbisonics() {
+		return fmt.Sprintf("%dc", l.Channels())
+	}
+	if _, ok := layoutChannels[l]; !ok {
+		return string(LayoutStereo)
+	}
+	return string(l)
+}
+
+// GetChannelLayout returns the channel layout of a server's mix, defaulting to stereo
+func GetChannelLayout(config ServerAgentConfig) ChannelLayout {
+	if _, ok := layoutChannels[config.ChannelLayout]; ok {
+		return config.ChannelLayout
+	}
+	return LayoutStereo
+}
+
+// GetChannelLayoutSCLang returns sclang code that configures the channel layout of the mixer; it is run before the mix code
+func GetChannelLayoutSCLang(config ServerAgentConfig) string {
+	layout := GetChannelLayout(config)
+	return fmt.Sprintf(channelLayoutSCLangTemplate, layout, layout.AmbisonicOrder())
+}
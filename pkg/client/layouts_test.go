@@ -0,0 +1,56 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelLayout(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for speaker layouts
+	assert.Equal(1, LayoutMono.Channels())
+	assert.Equal(6, Layout51.Channels())
+	assert.Equal("5.1", Layout51.FFmpegLayout())
+	assert.False(Layout71.IsAmbisonics())
+
+	// Case for ambisonic layouts
+	assert.Equal(9, LayoutAmbisonics2.Channels())
+	assert.Equal("16c", LayoutAmbisonics3.FFmpegLayout())
+	assert.True(LayoutAmbisonics1.IsAmbisonics())
+	assert.Equal(3, LayoutAmbisonics3.AmbisonicOrder())
+	assert.Equal(0, Layout51.AmbisonicOrder())
+
+	// Case for empty and unknown layouts
+	assert.True(ChannelLayout("").IsValid())
+	assert.False(ChannelLayout("22.2").IsValid())
+	assert.Equal(2, ChannelLayout("22.2").Channels())
+	assert.Equal("stereo", ChannelLayout("").FFmpegLayout())
+}
+
+func TestGetChannelLayout(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{}
+	assert.Equal(LayoutStereo, GetChannelLayout(config))
+	config.ChannelLayout = LayoutAmbisonics1
+	assert.Equal(LayoutAmbisonics1, GetChannelLayout(config))
+	assert.Equal("~channelLayout = \"ambix1\";\n~ambisonicOrder = 1;\n", GetChannelLayoutSCLang(config))
+	config.ChannelLayout = "unknown"
+	assert.Equal(LayoutStereo, GetChannelLayout(config))
+	assert.Equal("~channelLayout = \"stereo\";\n~ambisonicOrder = 0;\n", GetChannelLayoutSCLang(config))
+}
@@ -0,0 +1,354 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// SCConfigFlatSchema is the original SCConfig schema, which only supports numeric, string and boolean options
+	SCConfigFlatSchema = 1
+
+	// SCConfigNestedSchema adds arrays and per-client arrays to SCConfig options
+	SCConfigNestedSchema = 2
+
+	// CurrentSCConfigSchema is the newest SCConfig schema understood by this agent
+	CurrentSCConfigSchema = SCConfigNestedSchema
+
+	// maxSCValueDepth limits how deeply arrays may be nested in SCConfig options
+	maxSCValueDepth = 4
+
+	// SCOptionOSCAddress is the OSC address that the mixer listens on for live option updates
+	SCOptionOSCAddress = "/jacktrip/option"
+
+	// scOptionResponderSCLang listens for live option updates, setting an option of a running link by its index
+	scOptionResponderSCLang = "OSCdef(\\jacktripOption, { |msg| var link = ~links[msg[1]]; " +
+		"if (link.notNil, { link.perform(msg[2].asSymbol.asSetter, msg[3]) }) }, '" + SCOptionOSCAddress + "');\n"
+)
+
+var (
+	// scClassPattern matches the names of sclang classes
+	scClassPattern = regexp.MustCompile(`^[A-Z][A-Za-z0-9_]*$`)
+
+	// scOptionPattern matches the names of sclang keyword arguments
+	scOptionPattern = regexp.MustCompile(`^[a-z][A-Za-z0-9_]*$`)
+)
+
+// SCConfig defines the jacktrip-sc classes that are linked together to build a mix
+type SCConfig struct {
+	// Version of the schema used by the config (defaults to 1)
+	SchemaVersion int `json:"schemaVersion"`
+
+	// Classes to create, in the order their audio flows through the mix
+	Links []SCLink `json:"links"`
+}
+
+// SCLink defines a jacktrip-sc class and the options used to create it
+type SCLink struct {
+	// Name of the sclang class
+	Class string `json:"class"`
+
+	// Keyword arguments passed to the class; values may be numbers, strings or booleans, and with
+	// schemaVersion 2 also arrays or per-client arrays such as {"perClient": [1, 0.5], "default": 1}
+	Options map[string]interface{} `json:"options,omitempty"`
+}
+
+// ParseSCConfig decodes and validates an SCConfig from JSON; unknown fields are rejected
+func ParseSCConfig(data string) (SCConfig, error) {
+	config := SCConfig{SchemaVersion: SCConfigFlatSchema}
+	if strings.TrimSpace(data) == "" {
+		return config, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.DisallowUnknownFields()
+	decoder.UseNumber()
+	if err := decoder.Decode(&config); err != nil {
+		return config, fmt.Errorf("invalid scConfig: %w", err)
+	}
+	if config.SchemaVersion == 0 {
+		config.SchemaVersion = SCConfigFlatSchema
+	}
+	return config, config.Validate()
+}
+
+// Validate checks that an SCConfig only uses features of its schema version and can be rendered as sclang
+func (c SCConfig) Validate() error {
+	var e configErrors
+	if c.SchemaVersion < SCConfigFlatSchema || c.SchemaVersion > CurrentSCConfigSchema {
+		e = append(e, fmt.Sprintf("unsupported schemaVersion %d, expected 1 to %d", c.SchemaVersion, CurrentSCConfigSchema))
+	}
+	for i, link := range c.Links {
+		path := fmt.Sprintf("links[%d]", i)
+		if !scClassPattern.MatchString(link.Class) {
+			e = append(e, fmt.Sprintf("%s.class must be an sclang class name, got %q", path, link.Class))
+		}
+		for _, name := range sortedSCOptionNames(link.Options) {
+			optionPath := fmt.Sprintf("%s.options.%s", path, name)
+			if !scOptionPattern.MatchString(name) {
+				e = append(e, fmt.Sprintf("%s: option names must start with a lowercase letter", optionPath))
+				continue
+			}
+			e.validateSCValue(optionPath, link.Options[name], c.SchemaVersion, 0)
+		}
+	}
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid scConfig: %s", strings.Join(e, "; "))
+}
+
+// validateSCValue records errors for an option value that can't be used with a schema version
+func (e *configErrors) validateSCValue(path string, value interface{}, version, depth int) {
+	switch v := value.(type) {
+	case json.Number:
+		if f, err := v.Float64(); err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			*e = append(*e, fmt.Sprintf("%s: %s is not a finite number", path, v))
+		}
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			*e = append(*e, fmt.Sprintf("%s: %v is not a finite number", path, v))
+		}
+	case int, string, bool:
+	case []interface{}:
+		if version < SCConfigNestedSchema {
+			*e = append(*e, fmt.Sprintf("%s: arrays require schemaVersion %d", path, SCConfigNestedSchema))
+			return
+		}
+		if depth >= maxSCValueDepth {
+			*e = append(*e, fmt.Sprintf("%s: arrays may be nested at most %d deep", path, maxSCValueDepth))
+			return
+		}
+		for i, item := range v {
+			e.validateSCValue(fmt.Sprintf("%s[%d]", path, i), item, version, depth+1)
+		}
+	case map[string]interface{}:
+		if version < SCConfigNestedSchema {
+			*e = append(*e, fmt.Sprintf("%s: per-client arrays require schemaVersion %d", path, SCConfigNestedSchema))
+			return
+		}
+		e.validatePerClientValue(path, v, version, depth)
+	case nil:
+		*e = append(*e, fmt.Sprintf("%s: null is not supported, omit the option instead", path))
+	default:
+		*e = append(*e, fmt.Sprintf("%s: unsupported value %v", path, v))
+	}
+}
+
+// validatePerClientValue records errors for a per-client array, which must be an object with a
+// "perClient" array and an optional "default" for clients beyond the end of the array
+func (e *configErrors) validatePerClientValue(path string, value map[string]interface{}, version, depth int) {
+	for _, key := range sortedSCOptionNames(value) {
+		if key != "perClient" && key != "default" {
+			*e = append(*e, fmt.Sprintf("%s: unknown key %q, expected \"perClient\" or \"default\"", path, key))
+		}
+	}
+	values, ok := value["perClient"].([]interface{})
+	if !ok {
+		*e = append(*e, fmt.Sprintf("%s.perClient must be an array", path))
+		return
+	}
+	for i, item := range values {
+		if isSCPerClientValue(item) {
+			*e = append(*e, fmt.Sprintf("%s.perClient[%d]: per-client arrays can't be nested", path, i))
+			continue
+		}
+		e.validateSCValue(fmt.Sprintf("%s.perClient[%d]", path, i), item, version, depth+1)
+	}
+	if def, ok := value["default"]; ok {
+		if isSCPerClientValue(def) {
+			*e = append(*e, fmt.Sprintf("%s.default: per-client arrays can't be nested", path))
+			return
+		}
+		e.validateSCValue(path+".default", def, version, depth+1)
+	}
+}
+
+// isSCPerClientValue returns true if an option value is a per-client array
+func isSCPerClientValue(value interface{}) bool {
+	_, ok := value.(map[string]interface{})
+	return ok
+}
+
+// sortedSCOptionNames returns the keys of an options object in a stable order
+func sortedSCOptionNames(options map[string]interface{}) []string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SCLang returns sclang code that creates the linked classes, padding per-client arrays to the given number of clients
+func (c SCConfig) SCLang(clients int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "~scConfigVersion = %d;\n~links = [\n", c.SchemaVersion)
+	for _, link := range c.Links {
+		args := make([]string, 0, len(link.Options))
+		for _, name := range sortedSCOptionNames(link.Options) {
+			args = append(args, fmt.Sprintf("%s: %s", name, formatSCValue(link.Options[name], clients)))
+		}
+		fmt.Fprintf(&b, "\t%s.new(%s),\n", link.Class, strings.Join(args, ", "))
+	}
+	b.WriteString("];\n")
+	return b.String()
+}
+
+// formatSCValue returns the sclang literal for a validated option value
+func formatSCValue(value interface{}, clients int) string {
+	switch v := value.(type) {
+	case string:
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatSCValue(item, clients)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		values, _ := v["perClient"].([]interface{})
+		def := "nil"
+		if d, ok := v["default"]; ok {
+			def = formatSCValue(d, clients)
+		}
+		items := make([]string, clients)
+		for i := range items {
+			if i < len(values) {
+				items[i] = formatSCValue(values[i], clients)
+			} else {
+				items[i] = def
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(value)
+}
+
+// GetSCConfigSCLang returns sclang code for the SCConfig of a server, with per-client arrays sized for its max musicians
+func GetSCConfigSCLang(config ServerAgentConfig) (string, error) {
+	scConfig, err := ParseSCConfig(config.SCConfig)
+	if err != nil {
+		return "", err
+	}
+	clients := config.MaxMusicians
+	if clients < 1 {
+		clients = 1
+	}
+	return scConfig.SCLang(clients) + scOptionResponderSCLang, nil
+}
+
+// SCOptionUpdate is a change to an option of a running jacktrip-sc class, which is applied without restarting the mixer
+type SCOptionUpdate struct {
+	// Index of the link in the SCConfig
+	Link int
+
+	// Name of the option
+	Option string
+
+	// New value of the option; only numbers, strings and booleans are updated live
+	Value interface{}
+}
+
+// OSCArgs returns the arguments of the OSC message sent to SCOptionOSCAddress; booleans are sent as 0 or 1,
+// since OSC 1.0 has no boolean type
+func (u SCOptionUpdate) OSCArgs() []interface{} {
+	value := u.Value
+	switch v := u.Value.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		value = float32(f)
+	case float64:
+		value = float32(v)
+	case bool:
+		value = 0
+		if v {
+			value = 1
+		}
+	}
+	return []interface{}{u.Link, u.Option, value}
+}
+
+// isSCScalarValue returns true if an option value may be updated on a running mixer
+func isSCScalarValue(value interface{}) bool {
+	switch value.(type) {
+	case json.Number, float64, int, string, bool:
+		return true
+	}
+	return false
+}
+
+// GetSCOptionUpdates returns the options that changed between two SCConfigs; ok is false if the configs
+// differ in structure (their classes, option names or array options), which requires restarting the mixer
+func GetSCOptionUpdates(prev, next SCConfig) (updates []SCOptionUpdate, ok bool) {
+	if prev.SchemaVersion != next.SchemaVersion || len(prev.Links) != len(next.Links) {
+		return nil, false
+	}
+	for i, link := range next.Links {
+		old := prev.Links[i]
+		if old.Class != link.Class || len(old.Options) != len(link.Options) {
+			return nil, false
+		}
+		for _, name := range sortedSCOptionNames(link.Options) {
+			oldValue, found := old.Options[name]
+			if !found {
+				return nil, false
+			}
+			value := link.Options[name]
+			if formatSCValue(oldValue, 1) == formatSCValue(value, 1) {
+				continue
+			}
+			if !isSCScalarValue(oldValue) || !isSCScalarValue(value) {
+				return nil, false
+			}
+			updates = append(updates, SCOptionUpdate{Link: i, Option: name, Value: value})
+		}
+	}
+	return updates, true
+}
+
+// mixPresetNamePattern matches the names of mix presets, such as "rehearsal" or "concert-2"
+var mixPresetNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// MixPreset is a named SCConfig stored on an agent, which may be activated without pushing a new config
+type MixPreset struct {
+	// name of the preset (ie. "rehearsal", "concert" or "broadcast")
+	Name string `json:"name"`
+
+	// JSON encoded SCConfig used while the preset is active
+	SCConfig string `json:"scConfig"`
+}
+
+// IsValidMixPresetName returns true if a name may be used for a mix preset
+func IsValidMixPresetName(name string) bool {
+	return mixPresetNamePattern.MatchString(name)
+}
+
+// MixPresetList describes the mix presets stored on an agent
+type MixPresetList struct {
+	// stored presets, sorted by name
+	Presets []MixPreset `json:"presets"`
+
+	// name of the active preset, or empty if the mix uses the scConfig of the server config
+	Active string `json:"active"`
+
+	// error switching presets, if any
+	Error string `json:"error,omitempty"`
+}
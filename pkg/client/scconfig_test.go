@@ -0,0 +1,114 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSCConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for empty and flat configs
+	config, err := ParseSCConfig("")
+	assert.NoError(err)
+	assert.Equal(SCConfigFlatSchema, config.SchemaVersion)
+	config, err = ParseSCConfig(`{"links": [{"class": "Compressor", "options": {"threshold": -12, "mode": "soft"}}]}`)
+	assert.NoError(err)
+	assert.Equal(SCConfigFlatSchema, config.SchemaVersion)
+	assert.Equal("Compressor", config.Links[0].Class)
+
+	// Case for nested values, which require schema version 2
+	nested := `{"links": [{"class": "Mixer", "options": {"gains": {"perClient": [1, 0.5], "default": 1}}}]}`
+	_, err = ParseSCConfig(nested)
+	assert.EqualError(err, "invalid scConfig: links[0].options.gains: per-client arrays require schemaVersion 2")
+	_, err = ParseSCConfig(`{"schemaVersion": 2,` + nested[1:])
+	assert.NoError(err)
+
+	// Case for invalid configs
+	_, err = ParseSCConfig(`{"schemaVersion": 3}`)
+	assert.EqualError(err, "invalid scConfig: unsupported schemaVersion 3, expected 1 to 2")
+	_, err = ParseSCConfig(`{"links": [], "extra": true}`)
+	assert.Error(err)
+	_, err = ParseSCConfig(`{"schemaVersion": 2, "links": [{"class": "mixer", "options": {"Gain": 1, "pan": null,
+		"gains": {"perClient": [{"perClient": []}], "fallback": 1}}}]}`)
+	assert.EqualError(err, "invalid scConfig: links[0].class must be an sclang class name, got \"mixer\"; "+
+		"links[0].options.Gain: option names must start with a lowercase letter; "+
+		"links[0].options.gains: unknown key \"fallback\", expected \"perClient\" or \"default\"; "+
+		"links[0].options.gains.perClient[0]: per-client arrays can't be nested; "+
+		"links[0].options.pan: null is not supported, omit the option instead")
+}
+
+func TestSCConfigSCLang(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{MaxMusicians: 3}
+	config.SCConfig = `{"schemaVersion": 2, "links": [
+		{"class": "Compressor", "options": {"threshold": -12, "name": "say \"hi\"", "bypass": false}},
+		{"class": "Mixer", "options": {"gains": {"perClient": [1, 0.5], "default": 0.8}, "pans": [[-1, 1], 0]}},
+		{"class": "Limiter"}
+	]}`
+	code, err := GetSCConfigSCLang(config)
+	assert.NoError(err)
+	assert.Equal("~scConfigVersion = 2;\n~links = [\n"+
+		"\tCompressor.new(bypass: false, name: \"say \\\"hi\\\"\", threshold: -12),\n"+
+		"\tMixer.new(gains: [1, 0.5, 0.8], pans: [[-1, 1], 0]),\n"+
+		"\tLimiter.new(),\n"+
+		"];\n"+scOptionResponderSCLang, code)
+
+	config.SCConfig = `{"links": [{"class": "Mixer", "options": {"gains": [1]}}]}`
+	_, err = GetSCConfigSCLang(config)
+	assert.Error(err)
+}
+
+func TestGetSCOptionUpdates(t *testing.T) {
+	assert := assert.New(t)
+	parse := func(data string) SCConfig {
+		config, err := ParseSCConfig(data)
+		assert.NoError(err)
+		return config
+	}
+	prev := parse(`{"schemaVersion": 2, "links": [
+		{"class": "Compressor", "options": {"threshold": -12, "bypass": false}},
+		{"class": "Mixer", "options": {"gains": [1, 0.5]}}
+	]}`)
+
+	// Case for changed numbers and booleans, which are updated live
+	updates, ok := GetSCOptionUpdates(prev, parse(`{"schemaVersion": 2, "links": [
+		{"class": "Compressor", "options": {"threshold": -6, "bypass": true}},
+		{"class": "Mixer", "options": {"gains": [1, 0.5]}}
+	]}`))
+	assert.True(ok)
+	assert.Len(updates, 2)
+	assert.Equal([]interface{}{0, "bypass", 1}, updates[0].OSCArgs())
+	assert.Equal([]interface{}{0, "threshold", float32(-6)}, updates[1].OSCArgs())
+
+	// Case for no changes
+	updates, ok = GetSCOptionUpdates(prev, prev)
+	assert.True(ok)
+	assert.Empty(updates)
+
+	// Case for structural changes, which require a restart
+	for _, data := range []string{
+		`{"schemaVersion": 2, "links": [{"class": "Compressor", "options": {"threshold": -12, "bypass": false}}]}`,
+		`{"schemaVersion": 2, "links": [{"class": "Limiter", "options": {"threshold": -12, "bypass": false}}, {"class": "Mixer", "options": {"gains": [1, 0.5]}}]}`,
+		`{"schemaVersion": 2, "links": [{"class": "Compressor", "options": {"threshold": -12}}, {"class": "Mixer", "options": {"gains": [1, 0.5]}}]}`,
+		`{"schemaVersion": 2, "links": [{"class": "Compressor", "options": {"threshold": -12, "bypass": false}}, {"class": "Mixer", "options": {"gains": [1, 1]}}]}`,
+	} {
+		_, ok = GetSCOptionUpdates(prev, parse(data))
+		assert.False(ok, data)
+	}
+}
@@ -15,6 +15,9 @@
 package client
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx/types"
@@ -76,30 +79,107 @@ const (
 	PrivateRecordWStemWVideo BroadcastVisibility = 12
 )
 
+// BroadcastAccess controls who may access an audio server broadcast
+type BroadcastAccess string
+
+const (
+	// BroadcastOff means broadcasting and recording are disabled
+	BroadcastOff BroadcastAccess = "off"
+
+	// BroadcastPublic means broadcasting is enabled for anyone
+	BroadcastPublic BroadcastAccess = "public"
+
+	// BroadcastUnlisted means broadcasting is enabled for those with the link
+	BroadcastUnlisted BroadcastAccess = "unlisted"
+
+	// BroadcastPrivate means broadcasting is disabled but recording is enabled
+	BroadcastPrivate BroadcastAccess = "private"
+)
+
+// BroadcastFlags describes the access mode and recorded media of a broadcast
+type BroadcastFlags struct {
+	// who may access the broadcast
+	Access BroadcastAccess `json:"access"`
+
+	// true if stems are recorded for each musician
+	Stems bool `json:"stems"`
+
+	// true if video is enabled
+	Video bool `json:"video"`
+}
+
+// broadcastFlags maps each BroadcastVisibility to its flags
+var broadcastFlags = map[BroadcastVisibility]BroadcastFlags{
+	Offline:                        {Access: BroadcastOff},
+	BroadcastPublicWOStemWOVideo:   {Access: BroadcastPublic},
+	BroadcastUnlistedWOStemWOVideo: {Access: BroadcastUnlisted},
+	PrivateRecordWOStemWOVideo:     {Access: BroadcastPrivate},
+	BroadcastPublicWStemWOVideo:    {Access: BroadcastPublic, Stems: true},
+	BroadcastPublicWOStemWVideo:    {Access: BroadcastPublic, Video: true},
+	BroadcastPublicWStemWVideo:     {Access: BroadcastPublic, Stems: true, Video: true},
+	BroadcastUnlistedWStemWOVideo:  {Access: BroadcastUnlisted, Stems: true},
+	BroadcastUnlistedWOStemWVideo:  {Access: BroadcastUnlisted, Video: true},
+	BroadcastUnlistedWStemWVideo:   {Access: BroadcastUnlisted, Stems: true, Video: true},
+	PrivateRecordWStemWOVideo:      {Access: BroadcastPrivate, Stems: true},
+	PrivateRecordWOStemWVideo:      {Access: BroadcastPrivate, Video: true},
+	PrivateRecordWStemWVideo:       {Access: BroadcastPrivate, Stems: true, Video: true},
+}
+
+// Flags returns the access mode and recorded media of a broadcast visibility; unknown values are treated as offline
+func (vis BroadcastVisibility) Flags() BroadcastFlags {
+	if flags, ok := broadcastFlags[vis]; ok {
+		return flags
+	}
+	return BroadcastFlags{Access: BroadcastOff}
+}
+
+// Visibility returns the broadcast visibility matching a set of flags
+// NOTE: stems and video are ignored when the broadcast is off
+func (flags BroadcastFlags) Visibility() BroadcastVisibility {
+	if flags.Access == BroadcastOff || flags.Access == "" {
+		return Offline
+	}
+	for vis, f := range broadcastFlags {
+		if f == flags {
+			return vis
+		}
+	}
+	return Offline
+}
+
+// UnmarshalJSON accepts either the legacy integer visibility or an object of broadcast flags
+func (vis *BroadcastVisibility) UnmarshalJSON(data []byte) error {
+	var value int
+	if err := json.Unmarshal(data, &value); err == nil {
+		*vis = BroadcastVisibility(value)
+		return nil
+	}
+	var flags BroadcastFlags
+	if err := json.Unmarshal(data, &flags); err != nil {
+		return err
+	}
+	*vis = flags.Visibility()
+	return nil
+}
+
 // IsStemEnabled checks if stem recordings are enabled
 func IsStemEnabled(vis BroadcastVisibility) bool {
-	return vis == BroadcastPublicWStemWOVideo || vis == BroadcastPublicWStemWVideo ||
-		vis == BroadcastUnlistedWStemWOVideo || vis == BroadcastUnlistedWStemWVideo ||
-		vis == PrivateRecordWStemWOVideo || vis == PrivateRecordWStemWVideo
+	return vis.Flags().Stems
 }
 
 // IsVideoEnabled checks if video is enabled
 func IsVideoEnabled(vis BroadcastVisibility) bool {
-	return vis == BroadcastPublicWOStemWVideo || vis == BroadcastPublicWStemWVideo ||
-		vis == BroadcastUnlistedWOStemWVideo || vis == BroadcastUnlistedWStemWVideo ||
-		vis == PrivateRecordWOStemWVideo || vis == PrivateRecordWStemWVideo
+	return vis.Flags().Video
 }
 
 // IsStreamPublic checks if the HLS stream is public
 func IsStreamPublic(vis BroadcastVisibility) bool {
-	return vis == BroadcastPublicWOStemWOVideo || vis == BroadcastPublicWOStemWVideo ||
-		vis == BroadcastPublicWStemWOVideo || vis == BroadcastPublicWStemWVideo
+	return vis.Flags().Access == BroadcastPublic
 }
 
 // IsStreamUnlisted checks if the HLS stream is unlisted
 func IsStreamUnlisted(vis BroadcastVisibility) bool {
-	return vis == BroadcastUnlistedWOStemWOVideo || vis == BroadcastUnlistedWOStemWVideo ||
-		vis == BroadcastUnlistedWStemWOVideo || vis == BroadcastUnlistedWStemWVideo
+	return vis.Flags().Access == BroadcastUnlisted
 }
 
 // IsStreamEnabled checks if the HLS stream is enabled
@@ -109,8 +189,182 @@ func IsStreamEnabled(vis BroadcastVisibility) bool {
 
 // IsPrivateRecording checks if private recording is enabled
 func IsPrivateRecording(vis BroadcastVisibility) bool {
-	return vis == PrivateRecordWOStemWOVideo || vis == PrivateRecordWOStemWVideo ||
-		vis == PrivateRecordWStemWOVideo || vis == PrivateRecordWStemWVideo
+	return vis.Flags().Access == BroadcastPrivate
+}
+
+// MixEngine is used to determine which backend mixes audio on a server
+type MixEngine string
+
+const (
+	// SuperColliderEngine mixes audio using the jacktrip-sc classes
+	SuperColliderEngine MixEngine = "supercollider"
+
+	// FaustEngine mixes audio using a Faust program compiled with faust2jack
+	FaustEngine MixEngine = "faust"
+)
+
+// GetMixEngine returns the mixing backend to use for a config, defaulting to SuperCollider
+func GetMixEngine(config ServerAgentConfig) MixEngine {
+	if config.MixEngine == FaustEngine {
+		return FaustEngine
+	}
+	return SuperColliderEngine
+}
+
+// SCServerType is used to determine which SuperCollider audio server runs the mix
+type SCServerType string
+
+const (
+	// SCSynth is the default, single-threaded SuperCollider audio server
+	SCSynth SCServerType = "scsynth"
+
+	// Supernova is the multicore SuperCollider audio server
+	Supernova SCServerType = "supernova"
+)
+
+// GetSCServerType returns the SuperCollider audio server to use for a config, defaulting to scsynth
+func GetSCServerType(config ServerAgentConfig) SCServerType {
+	if config.SCServer == Supernova {
+		return Supernova
+	}
+	return SCSynth
+}
+
+// RecordingFormat is used to determine which files the recorder writes; the recorder service receives it as
+// RECORDER_FORMAT, and writes the start time of its masters to their bext chunk
+type RecordingFormat string
+
+const (
+	// RecordingFLAC writes FLAC segments for the HLS pipeline
+	RecordingFLAC RecordingFormat = "flac"
+
+	// RecordingWAV writes uncompressed Broadcast Wave (BWF) masters instead of FLAC segments
+	RecordingWAV RecordingFormat = "wav"
+
+	// RecordingFLACAndWAV writes FLAC segments and Broadcast Wave (BWF) masters in parallel
+	RecordingFLACAndWAV RecordingFormat = "flac+wav"
+)
+
+// GetRecordingFormat returns which files the recorder writes for a config, defaulting to FLAC
+func GetRecordingFormat(config ServerAgentConfig) RecordingFormat {
+	switch config.RecordingFormat {
+	case RecordingWAV, RecordingFLACAndWAV:
+		return config.RecordingFormat
+	}
+	return RecordingFLAC
+}
+
+// IncludesFLAC returns true if the recorder writes FLAC segments
+func (f RecordingFormat) IncludesFLAC() bool {
+	return f != RecordingWAV
+}
+
+// IncludesWAV returns true if the recorder writes Broadcast Wave (BWF) masters
+func (f RecordingFormat) IncludesWAV() bool {
+	return f == RecordingWAV || f == RecordingFLACAndWAV
+}
+
+// RecorderMode is used to determine how studio audio is captured to disk
+type RecorderMode string
+
+const (
+	// RecorderInProcess records the mix using the agent's in-process recorder
+	RecorderInProcess RecorderMode = "recorder"
+
+	// RecorderDirectCapture runs jack_capture for direct-to-disk multitrack capture of each client
+	RecorderDirectCapture RecorderMode = "capture"
+)
+
+// GetRecorderMode returns how studio audio is captured for a config, defaulting to the in-process recorder
+func GetRecorderMode(config ServerAgentConfig) RecorderMode {
+	if config.RecorderMode == RecorderDirectCapture {
+		return RecorderDirectCapture
+	}
+	return RecorderInProcess
+}
+
+// MaxRecordingPreRoll is the longest pre-roll that the recorder service keeps in memory for on-demand recordings
+const MaxRecordingPreRoll = 60 * time.Second
+
+// GetRecordingPreRoll returns how much audio from before a recording is started is included in it
+func GetRecordingPreRoll(config ServerAgentConfig) time.Duration {
+	preRoll := time.Duration(config.RecordingPreRoll) * time.Second
+	if preRoll < 0 {
+		return 0
+	}
+	if preRoll > MaxRecordingPreRoll {
+		return MaxRecordingPreRoll
+	}
+	return preRoll
+}
+
+// HLSDelivery is used to determine how HLS broadcasts reach listeners
+type HLSDelivery string
+
+const (
+	// HLSDeliveryLocal serves playlists and segments directly from the audio server
+	HLSDeliveryLocal HLSDelivery = "local"
+
+	// HLSDeliveryRelay pushes playlists and segments to the API, for listeners who can't reach the audio server
+	HLSDeliveryRelay HLSDelivery = "relay"
+)
+
+// GetHLSDelivery returns how HLS broadcasts are delivered for a config, defaulting to local
+func GetHLSDelivery(config ServerAgentConfig) HLSDelivery {
+	if config.HLSDelivery == HLSDeliveryRelay {
+		return HLSDeliveryRelay
+	}
+	return HLSDeliveryLocal
+}
+
+// DefaultHLSAllowedOrigins are the web origins allowed to play HLS broadcasts when none are configured
+const DefaultHLSAllowedOrigins = "https://app.jacktrip.org,https://www.jacktrip.org"
+
+// GetHLSAllowedOrigins returns the web origins allowed to play HLS broadcasts for a config
+func GetHLSAllowedOrigins(config ServerAgentConfig) []string {
+	origins := config.HLSAllowedOrigins
+	if strings.TrimSpace(origins) == "" {
+		origins = DefaultHLSAllowedOrigins
+	}
+	var result []string
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSuffix(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			result = append(result, origin)
+		}
+	}
+	return result
+}
+
+const (
+	// MixOutputPortPrefix is the prefix of the mixer's JACK output ports that carry the musicians' mix
+	MixOutputPortPrefix = "out_"
+
+	// BroadcastOutputPortPrefix is the prefix of the mixer's dedicated JACK output ports for broadcasts
+	BroadcastOutputPortPrefix = "broadcast_"
+
+	// broadcastMixSCLangTemplate sets the environment variables read by the jacktrip-sc mixer for its broadcast outputs
+	broadcastMixSCLangTemplate = "~broadcastMix = %t;\n~broadcastLimiter = %t;\n~broadcastTalkback = %t;\n~broadcastChannels = %d;\n"
+)
+
+// GetBroadcastPorts returns the names of a mixer client's JACK output ports that the recorder should capture
+func GetBroadcastPorts(config ServerAgentConfig, mixerClient string) []string {
+	prefix := MixOutputPortPrefix
+	if config.BroadcastMix {
+		prefix = BroadcastOutputPortPrefix
+	}
+	channels := GetChannelLayout(config).Channels()
+	ports := make([]string, channels)
+	for i := range ports {
+		ports[i] = fmt.Sprintf("%s:%s%d", mixerClient, prefix, i+1)
+	}
+	return ports
+}
+
+// GetBroadcastMixSCLang returns sclang code that configures the broadcast outputs of the mixer; it is run before the mix code
+func GetBroadcastMixSCLang(config ServerAgentConfig) string {
+	return fmt.Sprintf(broadcastMixSCLangTemplate, bool(config.BroadcastMix), bool(config.BroadcastLimiter),
+		bool(config.BroadcastTalkback), GetChannelLayout(config).Channels())
 }
 
 // ServerConfig defines configuration for a particular server
@@ -143,37 +397,366 @@ type ServerConfig struct {
 	Enabled types.BitBool `json:"enabled" db:"enabled"`
 }
 
-// ServerAgentConfig defines active configuration for a server
-type ServerAgentConfig struct {
-	ServerConfig
+// RetentionConfig defines how long recordings are kept for a studio
+type RetentionConfig struct {
+	// Maximum age of recordings, in days (0 means unlimited)
+	RetentionMaxAge int `json:"retentionMaxAge" db:"retention_max_age"`
+
+	// Maximum total size of recordings, in megabytes (0 means unlimited)
+	RetentionMaxSize int `json:"retentionMaxSize" db:"retention_max_size"`
+
+	// If true, recordings are deleted as soon as a session ends
+	DeleteOnSessionEnd types.BitBool `json:"deleteOnSessionEnd" db:"delete_on_session_end"`
+}
+
+// WebhookConfig defines where a studio is notified of key events
+type WebhookConfig struct {
+	// Comma-separated list of URLs that events are posted to
+	WebhookURLs string `json:"webhookUrls" db:"webhook_urls"`
+
+	// Shared secret used to sign webhook payloads with HMAC-SHA256
+	WebhookSecret string `json:"webhookSecret" db:"webhook_secret"`
+}
 
-	// frames per period
-	Period int `json:"period" db:"period"`
+// BroadcastMixConfig defines how the mix captured for recordings and broadcasts differs from the musicians' mix
+type BroadcastMixConfig struct {
+	// If true, the mixer exposes dedicated broadcast output ports, which the recorder captures instead of the musicians' mix
+	BroadcastMix types.BitBool `json:"broadcastMix" db:"broadcast_mix"`
 
-	// size of jitter queue buffer
-	QueueBuffer int `json:"queueBuffer" db:"queue_buffer"`
+	// If true, a limiter is applied to the broadcast mix
+	BroadcastLimiter types.BitBool `json:"broadcastLimiter" db:"broadcast_limiter"`
 
-	// strategy to use for the network jitter buffer
-	BufferStrategy int `json:"bufferStrategy" db:"buffer_strategy"`
+	// If true, talkback between musicians is included in the broadcast mix
+	BroadcastTalkback types.BitBool `json:"broadcastTalkback" db:"broadcast_talkback"`
+}
+
+// ServerAgentConfig defines active configuration for a server
+type ServerAgentConfig struct {
+	ServerConfig
+	BufferConfig
+	ScheduleConfig
+	VersionConfig
+	RetentionConfig
+	WebhookConfig
+	BroadcastMixConfig
+	SuperColliderConfig
 
 	// broadcast visibility of the audio server
 	Broadcast BroadcastVisibility `json:"broadcast" db:"broadcast"`
 
-	// timestamp when the server will automatically be paused
-	ExpiresAt time.Time `json:"expiresAt" db:"expires_at"`
-
 	// Branch of jacktrip/jacktrip-sc repository to use for mixing
 	MixBranch string `json:"mixBranch" db:"mix_branch"`
 
+	// Commit of jacktrip/jacktrip-sc repository to pin the mix to, instead of the latest commit of MixBranch
+	MixRevision string `json:"mixRevision" db:"mix_revision"`
+
+	// SHA-256 checksum (hex) of the source archive of MixRevision, which is verified before it is used
+	MixChecksum string `json:"mixChecksum" db:"mix_checksum"`
+
 	// SuperCollider (sclang) source code to run for mixing audio
 	MixCode string `json:"mixCode" db:"mix_code"`
 
 	// max number of musicians allowed in server
 	MaxMusicians int `json:"maxMusicians" db:"max_musicians"`
+
+	// If true, the recorder and HLS broadcast subsystem is not run for this studio (it runs by default)
+	DisableRecorder types.BitBool `json:"disableRecorder" db:"disable_recorder"`
+
+	// SuperCollider audio server used for mixing ("scsynth" or "supernova")
+	SCServer SCServerType `json:"scServer" db:"sc_server"`
+
+	// Backend used for mixing ("supercollider" or "faust")
+	MixEngine MixEngine `json:"mixEngine" db:"mix_engine"`
+
+	// Faust DSP source code to compile and run when MixEngine is "faust"
+	FaustCode string `json:"faustCode" db:"faust_code"`
+
+	// If true, the server stops accepting new connections while current musicians finish
+	Drain types.BitBool `json:"drain" db:"drain"`
+
+	// Maximum CPU for custom mix code, as a percent of one core (0 means unlimited)
+	MixCPUQuota int `json:"mixCpuQuota" db:"mix_cpu_quota"`
+
+	// Maximum memory for custom mix code, in megabytes (0 means unlimited)
+	MixMemoryMax int `json:"mixMemoryMax" db:"mix_memory_max"`
+
+	// Maximum CPU for the recorder and its encoders, as a percent of one core (0 means unlimited)
+	RecorderCPUQuota int `json:"recorderCpuQuota" db:"recorder_cpu_quota"`
+
+	// How HLS playlists and segments reach listeners ("local" or "relay")
+	HLSDelivery HLSDelivery `json:"hlsDelivery" db:"hls_delivery"`
+
+	// Comma-separated web origins allowed to play HLS broadcasts, such as "https://*.example.com" or "*" (defaults to JackTrip's)
+	HLSAllowedOrigins string `json:"hlsAllowedOrigins" db:"hls_allowed_origins"`
+
+	// Arrangement of the channels in the studio mix, recordings and broadcasts (defaults to "stereo")
+	ChannelLayout ChannelLayout `json:"channelLayout" db:"channel_layout"`
+
+	// Files written by the recorder ("flac", "wav" or "flac+wav"; defaults to "flac")
+	RecordingFormat RecordingFormat `json:"recordingFormat" db:"recording_format"`
+
+	// How studio audio is captured ("recorder" or "capture"; defaults to "recorder")
+	RecorderMode RecorderMode `json:"recorderMode" db:"recorder_mode"`
+
+	// Seconds of audio from before a recording is started that the recorder service includes in it (0 disables pre-roll)
+	RecordingPreRoll int `json:"recordingPreRoll" db:"recording_pre_roll"`
+}
+
+// IsRecorderEnabled checks if the recorder and HLS broadcast subsystem should run for a server
+func IsRecorderEnabled(config ServerAgentConfig) bool {
+	return !bool(config.DisableRecorder) && config.Broadcast != Offline
+}
+
+// IsHLSEnabled checks if the recorder should transcode and serve an HLS stream; private recordings
+// only write session files, which saves the CPU used by the HLS pipeline
+func IsHLSEnabled(config ServerAgentConfig) bool {
+	return IsRecorderEnabled(config) && IsStreamEnabled(config.Broadcast)
+}
+
+// MixerStatus describes the health of an audio server's SuperCollider mixer
+type MixerStatus string
+
+const (
+	// MixerHealthy means the mixer is responding to status requests
+	MixerHealthy MixerStatus = "healthy"
+
+	// MixerUnresponsive means the mixer stopped responding to status requests
+	MixerUnresponsive MixerStatus = "unresponsive"
+
+	// MixerRestarting means the mixer services are being restarted to recover
+	MixerRestarting MixerStatus = "restarting"
+)
+
+// UsesJamulus checks if a server type includes a Jamulus server
+func UsesJamulus(t ServerType) bool {
+	return t == Jamulus || t == JackTripJamulus
+}
+
+// IsJamulusRecordingEnabled checks if a server should record its Jamulus mix, so that hybrid studios
+// are broadcast regardless of which protocol musicians use
+func IsJamulusRecordingEnabled(config ServerAgentConfig) bool {
+	return UsesJamulus(config.Type) && IsRecorderEnabled(config) &&
+		(IsStreamEnabled(config.Broadcast) || IsPrivateRecording(config.Broadcast))
+}
+
+// IsBroadcastOnlyChange checks if broadcast visibility is the only difference between two configs,
+// in which case stream access and recording may be updated without restarting any services
+func IsBroadcastOnlyChange(last, next ServerAgentConfig) bool {
+	if last.Broadcast == next.Broadcast {
+		return false
+	}
+	// stems and video change what the recorder captures, so require a restart for those
+	lastFlags, nextFlags := last.Broadcast.Flags(), next.Broadcast.Flags()
+	if lastFlags.Stems != nextFlags.Stems || lastFlags.Video != nextFlags.Video {
+		return false
+	}
+	last.Broadcast = next.Broadcast
+	return last == next
+}
+
+// Utilization defines resource usage of an agent, used for bin-packing and autoscaling decisions
+type Utilization struct {
+	// Percent of CPU in use across all cores
+	CPUPercent float64 `json:"cpuPercent"`
+
+	// Percent of memory in use
+	MemoryPercent float64 `json:"memoryPercent"`
+
+	// Number of clients connected to the audio server
+	ActiveClients int `json:"activeClients"`
+
+	// Percent of the JACK process cycle used for DSP
+	DSPLoad float64 `json:"dspLoad"`
 }
 
 // ServerHeartbeat is used to send heartbeat messages from servers / studios
 type ServerHeartbeat struct {
 	// Cloud identifier for server (used when running on cloud audio server)
 	CloudID string `json:"cloudId"`
+
+	// Health of the SuperCollider mixer
+	MixerStatus MixerStatus `json:"mixerStatus,omitempty"`
+
+	// Number of times the mixer has been restarted to recover from failures
+	MixerRestarts int `json:"mixerRestarts"`
+
+	// Errors from validating MixCode; the previous mix code remains active when set
+	MixCodeError string `json:"mixCodeError,omitempty"`
+
+	// Latest error lines from the SuperCollider service logs (ie. "line 12 char 5:")
+	MixErrors []string `json:"mixErrors,omitempty"`
+
+	// True if the server is draining, which blocks new connections while connected clients finish
+	Draining bool `json:"draining"`
+
+	// Number of clients that remain connected while draining; the server is safe to recycle at zero
+	DrainRemaining int `json:"drainRemaining"`
+
+	// Resource usage of the studio
+	Utilization *Utilization `json:"utilization,omitempty"`
+
+	// Recordings deleted by retention policies since the last heartbeat
+	DeletedRecordings []DeletedRecording `json:"deletedRecordings,omitempty"`
+
+	// Clients that joined or left the studio since the last heartbeat
+	ClientEvents []ClientEvent `json:"clientEvents,omitempty"`
+
+	// Result of the latest check that the server can be reached from the internet
+	Reachability *ReachabilityReport `json:"reachability,omitempty"`
+}
+
+// ReachabilityProbe asks the control plane to send a UDP packet containing a token to a server
+type ReachabilityProbe struct {
+	// UDP port the packet is sent to
+	Port int `json:"port"`
+
+	// random token included in the packet, so that the server can recognize it
+	Token string `json:"token"`
+}
+
+// ReachabilityReport is the result of checking that a server can be reached from the internet
+type ReachabilityReport struct {
+	// UDP port that was checked
+	Port int `json:"port"`
+
+	// true if the probe sent by the control plane was received
+	Reachable bool `json:"reachable"`
+
+	// details about the failure, if the server is unreachable or the check could not run
+	Error string `json:"error,omitempty"`
+
+	// how to fix an unreachable server
+	Guidance string `json:"guidance,omitempty"`
+
+	// timestamp when the check finished
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DeletedRecording describes a recording file that was removed by a retention policy
+type DeletedRecording struct {
+	// Path to the file that was deleted
+	Path string `json:"path"`
+
+	// Size of the file, in bytes
+	Size int64 `json:"size"`
+
+	// Policy that caused the deletion ("maxAge", "maxSize" or "sessionEnd")
+	Reason string `json:"reason"`
+}
+
+// IsDrainComplete checks if a draining server no longer has any connected clients
+func IsDrainComplete(beat ServerHeartbeat) bool {
+	return beat.Draining && beat.DrainRemaining == 0
+}
+
+// ClientEventType is used to determine the type of a client event
+type ClientEventType string
+
+const (
+	// ClientJoined means a client connected to the audio server
+	ClientJoined ClientEventType = "join"
+
+	// ClientLeft means a client disconnected from the audio server
+	ClientLeft ClientEventType = "leave"
+)
+
+// ClientEvent is used to notify the control plane when clients join or leave an audio server
+type ClientEvent struct {
+	// type of event
+	Type ClientEventType `json:"type"`
+
+	// remote name of the client
+	Name string `json:"name"`
+
+	// number of clients connected after the event
+	Count int `json:"count"`
+
+	// timestamp when the event occurred
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// AdminActionType is used to determine the type of an admin action
+type AdminActionType string
+
+const (
+	// AdminMute silences a client in the studio mix
+	AdminMute AdminActionType = "mute"
+
+	// AdminUnmute restores a muted client in the studio mix
+	AdminUnmute AdminActionType = "unmute"
+
+	// AdminDisconnect removes all audio connections for a misbehaving client
+	AdminDisconnect AdminActionType = "disconnect"
+)
+
+// AdminAction is a request from a studio admin to act on a specific client
+type AdminAction struct {
+	// type of action
+	Action AdminActionType `json:"action"`
+
+	// remote name of the client
+	Client string `json:"client"`
+}
+
+const (
+	// DefaultListenTokenTTL is how long a guest listen token is valid, in seconds, when not requested
+	DefaultListenTokenTTL = 3600
+
+	// MaxListenTokenTTL is the longest a guest listen token may be valid, in seconds
+	MaxListenTokenTTL = 7 * 24 * 3600
+)
+
+// ListenTokenRequest is a request from a studio host for a temporary link to listen to a broadcast
+type ListenTokenRequest struct {
+	// Seconds until the token expires (defaults to DefaultListenTokenTTL)
+	TTL int `json:"ttl"`
+
+	// Optional label used to tell tokens apart in logs
+	Label string `json:"label"`
+}
+
+// GetTTL returns the seconds until a requested listen token expires
+func (r ListenTokenRequest) GetTTL() int {
+	if r.TTL == 0 {
+		return DefaultListenTokenTTL
+	}
+	return r.TTL
+}
+
+// IsValid returns true if a listen token can be minted for the request
+func (r ListenTokenRequest) IsValid() bool {
+	ttl := r.GetTTL()
+	return ttl > 0 && ttl <= MaxListenTokenTTL && len(r.Label) <= MaxMarkerLabelLength
+}
+
+// ListenToken is a temporary token that guests use to listen to a broadcast
+type ListenToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// MaxMarkerLabelLength is the longest label allowed for a recording marker
+const MaxMarkerLabelLength = 100
+
+// RecordingMarker is a request from an engineer to tag the current position of an active recording
+type RecordingMarker struct {
+	// name of the take, song or section
+	Label string `json:"label"`
+}
+
+// IsValid returns true if the marker can be added to a recording
+func (m RecordingMarker) IsValid() bool {
+	return m.Label != "" && len(m.Label) <= MaxMarkerLabelLength && !strings.ContainsAny(m.Label, "\r\n\"")
+}
+
+// IsValid returns true if the admin action can be performed
+func (a AdminAction) IsValid() bool {
+	if a.Client == "" {
+		return false
+	}
+	switch a.Action {
+	case AdminMute, AdminUnmute, AdminDisconnect:
+		return true
+	}
+	return false
 }
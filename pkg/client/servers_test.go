@@ -16,7 +16,9 @@ package client
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -64,6 +66,140 @@ func TestServerAgentConfig(t *testing.T) {
 	assert.Equal(BroadcastPublicWOStemWOVideo, target.Broadcast)
 	assert.Equal("main", target.MixBranch)
 	assert.Equal("echo hi", target.MixCode)
+	assert.Equal(false, bool(target.DisableRecorder))
+
+	raw = `{"type": "JackTrip", "broadcast": 3, "disableRecorder": true}`
+	target = ServerAgentConfig{}
+	json.Unmarshal([]byte(raw), &target)
+	assert.Equal(true, bool(target.DisableRecorder))
+	assert.Equal(PrivateRecordWOStemWOVideo, target.Broadcast)
+}
+
+func TestGetSCServerType(t *testing.T) {
+	assert := assert.New(t)
+	var target ServerAgentConfig
+
+	json.Unmarshal([]byte(`{"scServer": "supernova"}`), &target)
+	assert.Equal(Supernova, target.SCServer)
+	assert.Equal(Supernova, GetSCServerType(target))
+
+	target = ServerAgentConfig{}
+	assert.Equal(SCSynth, GetSCServerType(target))
+	target.SCServer = "foobar"
+	assert.Equal(SCSynth, GetSCServerType(target))
+}
+
+func TestGetMixEngine(t *testing.T) {
+	assert := assert.New(t)
+	var target ServerAgentConfig
+
+	json.Unmarshal([]byte(`{"mixEngine": "faust", "faustCode": "process = _;"}`), &target)
+	assert.Equal(FaustEngine, target.MixEngine)
+	assert.Equal("process = _;", target.FaustCode)
+	assert.Equal(FaustEngine, GetMixEngine(target))
+
+	target = ServerAgentConfig{}
+	assert.Equal(SuperColliderEngine, GetMixEngine(target))
+	target.MixEngine = "foobar"
+	assert.Equal(SuperColliderEngine, GetMixEngine(target))
+}
+
+func TestIsBroadcastOnlyChange(t *testing.T) {
+	assert := assert.New(t)
+	last := ServerAgentConfig{Broadcast: BroadcastPublicWOStemWOVideo, MixBranch: "main"}
+
+	// Case for no changes
+	assert.False(IsBroadcastOnlyChange(last, last))
+
+	// Case for public to unlisted
+	next := last
+	next.Broadcast = BroadcastUnlistedWOStemWOVideo
+	assert.True(IsBroadcastOnlyChange(last, next))
+
+	// Case for public to private recording
+	next.Broadcast = PrivateRecordWOStemWOVideo
+	assert.True(IsBroadcastOnlyChange(last, next))
+
+	// Case for enabling stems
+	next.Broadcast = BroadcastPublicWStemWOVideo
+	assert.False(IsBroadcastOnlyChange(last, next))
+
+	// Case for other changes
+	next.Broadcast = BroadcastUnlistedWOStemWOVideo
+	next.MixBranch = "dev"
+	assert.False(IsBroadcastOnlyChange(last, next))
+}
+
+func TestMixLimits(t *testing.T) {
+	assert := assert.New(t)
+	var config ServerAgentConfig
+	json.Unmarshal([]byte(`{"mixCpuQuota": 150, "mixMemoryMax": 512}`), &config)
+	assert.Equal(150, config.MixCPUQuota)
+	assert.Equal(512, config.MixMemoryMax)
+}
+
+func TestDrain(t *testing.T) {
+	assert := assert.New(t)
+	var config ServerAgentConfig
+	json.Unmarshal([]byte(`{"drain": true}`), &config)
+	assert.Equal(true, bool(config.Drain))
+
+	var beat ServerHeartbeat
+	json.Unmarshal([]byte(`{"draining": true, "drainRemaining": 2}`), &beat)
+	assert.Equal(true, beat.Draining)
+	assert.Equal(2, beat.DrainRemaining)
+	assert.False(IsDrainComplete(beat))
+	beat.DrainRemaining = 0
+	assert.True(IsDrainComplete(beat))
+	beat.Draining = false
+	assert.False(IsDrainComplete(beat))
+}
+
+func TestIsJamulusRecordingEnabled(t *testing.T) {
+	assert := assert.New(t)
+	assert.False(UsesJamulus(JackTrip))
+	assert.True(UsesJamulus(Jamulus))
+	assert.True(UsesJamulus(JackTripJamulus))
+
+	config := ServerAgentConfig{Broadcast: BroadcastPublicWOStemWOVideo}
+	config.Type = JackTrip
+	assert.False(IsJamulusRecordingEnabled(config))
+	config.Type = JackTripJamulus
+	assert.True(IsJamulusRecordingEnabled(config))
+	config.Broadcast = PrivateRecordWStemWOVideo
+	assert.True(IsJamulusRecordingEnabled(config))
+	config.Broadcast = Offline
+	assert.False(IsJamulusRecordingEnabled(config))
+	config.Broadcast = BroadcastPublicWOStemWOVideo
+	config.DisableRecorder = true
+	assert.False(IsJamulusRecordingEnabled(config))
+}
+
+func TestIsRecorderEnabled(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{Broadcast: BroadcastPublicWOStemWOVideo}
+	// the recorder runs by default, so existing studios keep recording
+	assert.True(IsRecorderEnabled(config))
+	config.DisableRecorder = true
+	assert.False(IsRecorderEnabled(config))
+	config.DisableRecorder = false
+	config.Broadcast = Offline
+	assert.False(IsRecorderEnabled(config))
+}
+
+func TestIsHLSEnabled(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{Broadcast: BroadcastUnlistedWOStemWOVideo, DisableRecorder: true}
+	assert.False(IsHLSEnabled(config))
+	config.DisableRecorder = false
+	assert.True(IsHLSEnabled(config))
+	config.Broadcast = BroadcastPublicWStemWVideo
+	assert.True(IsHLSEnabled(config))
+
+	// Case for private recordings, which still run the recorder
+	config.Broadcast = PrivateRecordWOStemWOVideo
+	assert.True(IsRecorderEnabled(config))
+	assert.False(IsHLSEnabled(config))
 }
 
 func TestServerHeartbeat(t *testing.T) {
@@ -72,8 +208,177 @@ func TestServerHeartbeat(t *testing.T) {
 	var target ServerHeartbeat
 
 	// Parse JSON into DeviceHeartbeat struct
-	raw = `{"cloudId": "aws"}`
+	raw = `{"cloudId": "aws", "mixerStatus": "unresponsive", "mixerRestarts": 2, "mixCodeError": "line 12 char 5", "mixErrors": ["ERROR: foo", "line 3 char 1:"]}`
 	target = ServerHeartbeat{}
 	json.Unmarshal([]byte(raw), &target)
 	assert.Equal("aws", target.CloudID)
+	assert.Equal(MixerUnresponsive, target.MixerStatus)
+	assert.Equal(2, target.MixerRestarts)
+	assert.Equal("line 12 char 5", target.MixCodeError)
+	assert.Equal([]string{"ERROR: foo", "line 3 char 1:"}, target.MixErrors)
+	assert.Nil(target.Utilization)
+
+	raw = `{"utilization": {"cpuPercent": 12.5, "memoryPercent": 40, "activeClients": 3, "dspLoad": 22.1}}`
+	target = ServerHeartbeat{}
+	json.Unmarshal([]byte(raw), &target)
+	assert.Equal(Utilization{CPUPercent: 12.5, MemoryPercent: 40, ActiveClients: 3, DSPLoad: 22.1}, *target.Utilization)
+
+	raw = `{"clientEvents": [{"type": "leave", "name": "alice", "count": 0}]}`
+	target = ServerHeartbeat{}
+	json.Unmarshal([]byte(raw), &target)
+	assert.Equal([]ClientEvent{{Type: ClientLeft, Name: "alice"}}, target.ClientEvents)
+}
+
+func TestClientEvent(t *testing.T) {
+	assert := assert.New(t)
+	target := ClientEvent{Type: ClientJoined, Name: "alice", Count: 3, Timestamp: time.Date(2022, 4, 9, 13, 0, 0, 0, time.UTC)}
+	result, err := json.Marshal(target)
+	assert.Nil(err)
+	assert.Equal(`{"type":"join","name":"alice","count":3,"timestamp":"2022-04-09T13:00:00Z"}`, string(result))
+}
+
+func TestAdminActionIsValid(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(AdminAction{Action: AdminMute, Client: "alice"}.IsValid())
+	assert.True(AdminAction{Action: AdminUnmute, Client: "alice"}.IsValid())
+	assert.True(AdminAction{Action: AdminDisconnect, Client: "alice"}.IsValid())
+	assert.False(AdminAction{Action: AdminMute}.IsValid())
+	assert.False(AdminAction{Action: "explode", Client: "alice"}.IsValid())
+}
+
+func TestRecordingMarkerIsValid(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(RecordingMarker{Label: "Verse 2"}.IsValid())
+	assert.False(RecordingMarker{}.IsValid())
+	assert.False(RecordingMarker{Label: strings.Repeat("a", MaxMarkerLabelLength+1)}.IsValid())
+	assert.False(RecordingMarker{Label: "take\n2"}.IsValid())
+	assert.False(RecordingMarker{Label: `take "2"`}.IsValid())
+}
+
+func TestListenTokenRequest(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(DefaultListenTokenTTL, ListenTokenRequest{}.GetTTL())
+	assert.True(ListenTokenRequest{}.IsValid())
+	assert.True(ListenTokenRequest{TTL: 600, Label: "guests"}.IsValid())
+	assert.False(ListenTokenRequest{TTL: -1}.IsValid())
+	assert.False(ListenTokenRequest{TTL: MaxListenTokenTTL + 1}.IsValid())
+	assert.False(ListenTokenRequest{Label: strings.Repeat("a", MaxMarkerLabelLength+1)}.IsValid())
+}
+
+func TestGetHLSDelivery(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{}
+	assert.Equal(HLSDeliveryLocal, GetHLSDelivery(config))
+	config.HLSDelivery = HLSDeliveryRelay
+	assert.Equal(HLSDeliveryRelay, GetHLSDelivery(config))
+	config.HLSDelivery = "bogus"
+	assert.Equal(HLSDeliveryLocal, GetHLSDelivery(config))
+}
+
+func TestGetHLSAllowedOrigins(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{}
+	assert.Equal([]string{"https://app.jacktrip.org", "https://www.jacktrip.org"}, GetHLSAllowedOrigins(config))
+	config.HLSAllowedOrigins = " https://radio.example.com/, ,http://localhost:3000"
+	assert.Equal([]string{"https://radio.example.com", "http://localhost:3000"}, GetHLSAllowedOrigins(config))
+}
+
+func TestGetRecordingFormat(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{}
+	assert.Equal(RecordingFLAC, GetRecordingFormat(config))
+	assert.True(GetRecordingFormat(config).IncludesFLAC())
+	assert.False(GetRecordingFormat(config).IncludesWAV())
+	config.RecordingFormat = RecordingWAV
+	assert.False(GetRecordingFormat(config).IncludesFLAC())
+	assert.True(GetRecordingFormat(config).IncludesWAV())
+	config.RecordingFormat = RecordingFLACAndWAV
+	assert.True(GetRecordingFormat(config).IncludesFLAC())
+	assert.True(GetRecordingFormat(config).IncludesWAV())
+	config.RecordingFormat = "mp3"
+	assert.Equal(RecordingFLAC, GetRecordingFormat(config))
+}
+
+func TestGetRecorderMode(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{}
+	assert.Equal(RecorderInProcess, GetRecorderMode(config))
+	config.RecorderMode = RecorderDirectCapture
+	assert.Equal(RecorderDirectCapture, GetRecorderMode(config))
+	config.RecorderMode = "ardour"
+	assert.Equal(RecorderInProcess, GetRecorderMode(config))
+}
+
+func TestGetRecordingPreRoll(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{}
+	assert.Equal(time.Duration(0), GetRecordingPreRoll(config))
+	config.RecordingPreRoll = 10
+	assert.Equal(10*time.Second, GetRecordingPreRoll(config))
+	config.RecordingPreRoll = 3600
+	assert.Equal(MaxRecordingPreRoll, GetRecordingPreRoll(config))
+	config.RecordingPreRoll = -1
+	assert.Equal(time.Duration(0), GetRecordingPreRoll(config))
+}
+
+func TestGetBroadcastPorts(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{}
+	assert.Equal([]string{"SuperCollider:out_1", "SuperCollider:out_2"}, GetBroadcastPorts(config, "SuperCollider"))
+	config.BroadcastMix = true
+	assert.Equal([]string{"SuperCollider:broadcast_1", "SuperCollider:broadcast_2"}, GetBroadcastPorts(config, "SuperCollider"))
+	config.ChannelLayout = LayoutQuad
+	assert.Len(GetBroadcastPorts(config, "SuperCollider"), 4)
+	assert.Equal("SuperCollider:broadcast_4", GetBroadcastPorts(config, "SuperCollider")[3])
+}
+
+func TestGetBroadcastMixSCLang(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{}
+	assert.Equal("~broadcastMix = false;\n~broadcastLimiter = false;\n~broadcastTalkback = false;\n~broadcastChannels = 2;\n", GetBroadcastMixSCLang(config))
+	config.BroadcastMix = true
+	config.BroadcastLimiter = true
+	config.ChannelLayout = LayoutMono
+	assert.Equal("~broadcastMix = true;\n~broadcastLimiter = true;\n~broadcastTalkback = false;\n~broadcastChannels = 1;\n", GetBroadcastMixSCLang(config))
+}
+
+func TestBroadcastFlags(t *testing.T) {
+	assert := assert.New(t)
+
+	// Every visibility maps to unique flags and back
+	seen := map[BroadcastFlags]bool{}
+	for vis := Offline; vis <= PrivateRecordWStemWVideo; vis++ {
+		flags := vis.Flags()
+		assert.False(seen[flags])
+		seen[flags] = true
+		assert.Equal(vis, flags.Visibility())
+	}
+
+	assert.Equal(BroadcastFlags{Access: BroadcastUnlisted, Stems: true}, BroadcastUnlistedWStemWOVideo.Flags())
+	assert.Equal(BroadcastFlags{Access: BroadcastOff}, BroadcastVisibility(99).Flags())
+	assert.Equal(Offline, BroadcastFlags{Access: BroadcastOff, Stems: true}.Visibility())
+	assert.Equal(Offline, BroadcastFlags{}.Visibility())
+	assert.Equal(PrivateRecordWOStemWVideo, BroadcastFlags{Access: BroadcastPrivate, Video: true}.Visibility())
+}
+
+func TestBroadcastVisibilityJSON(t *testing.T) {
+	assert := assert.New(t)
+	var config ServerAgentConfig
+
+	// Legacy integer values are still accepted
+	assert.Nil(json.Unmarshal([]byte(`{"broadcast":7}`), &config))
+	assert.Equal(BroadcastUnlistedWStemWOVideo, config.Broadcast)
+
+	// Flags are accepted as an object
+	assert.Nil(json.Unmarshal([]byte(`{"broadcast":{"access":"public","stems":true,"video":true}}`), &config))
+	assert.Equal(BroadcastPublicWStemWVideo, config.Broadcast)
+
+	// Visibility is always encoded as an integer for older agents
+	encoded, err := json.Marshal(struct {
+		Broadcast BroadcastVisibility `json:"broadcast"`
+	}{config.Broadcast})
+	assert.Nil(err)
+	assert.Equal(`{"broadcast":6}`, string(encoded))
+
+	assert.NotNil(json.Unmarshal([]byte(`{"broadcast":"public"}`), &config))
 }
@@ -0,0 +1,183 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Defaults of the SuperCollider audio server, which are used as minimums when options are derived
+const (
+	defaultSCMemorySize   = 8192
+	defaultSCAudioBuses   = 1024
+	defaultSCControlBuses = 16384
+	defaultSCMaxNodes     = 1024
+	defaultSCMaxSynthDefs = 1024
+	defaultSCWireBuffers  = 64
+)
+
+const (
+	// DefaultChannelsPerClient is the number of input and output channels mixed for each client
+	DefaultChannelsPerClient = 2
+
+	// MaxChannelsPerClient is the largest number of input or output channels mixed for each client
+	MaxChannelsPerClient = 16
+
+	// clientChannelsSCLangTemplate sets the environment variables read by the jacktrip-sc mixer for its client channels
+	clientChannelsSCLangTemplate = "~inputChannelsPerClient = %d;\n~outputChannelsPerClient = %d;\n"
+)
+
+// SuperColliderConfig defines how the SuperCollider mixer is run; zero values are derived from
+// the number of musicians and channels in the studio
+type SuperColliderConfig struct {
+	// Real-time memory of the audio server, in kilobytes (-m)
+	SCMemorySize int `json:"scMemorySize" db:"sc_memory_size"`
+
+	// Number of audio bus channels (-a)
+	SCAudioBuses int `json:"scAudioBuses" db:"sc_audio_buses"`
+
+	// Number of control bus channels (-c)
+	SCControlBuses int `json:"scControlBuses" db:"sc_control_buses"`
+
+	// Maximum number of nodes (-n)
+	SCMaxNodes int `json:"scMaxNodes" db:"sc_max_nodes"`
+
+	// Maximum number of synth definitions (-d)
+	SCMaxSynthDefs int `json:"scMaxSynthDefs" db:"sc_max_synth_defs"`
+
+	// Number of wire buffers (-w)
+	SCWireBuffers int `json:"scWireBuffers" db:"sc_wire_buffers"`
+
+	// Number of audio channels mixed from each client (defaults to 2)
+	InputChannelsPerClient int `json:"inputChannelsPerClient" db:"input_channels_per_client"`
+
+	// Number of audio channels mixed to each client (defaults to 2)
+	OutputChannelsPerClient int `json:"outputChannelsPerClient" db:"output_channels_per_client"`
+
+	// JSON encoded SCConfig, defining the jacktrip-sc classes linked together to build the mix
+	SCConfig string `json:"scConfig" db:"sc_config"`
+}
+
+// getChannelsPerClient clamps a configured number of channels per client, using the default for zero
+func getChannelsPerClient(channels int) int {
+	if channels <= 0 {
+		return DefaultChannelsPerClient
+	}
+	if channels > MaxChannelsPerClient {
+		return MaxChannelsPerClient
+	}
+	return channels
+}
+
+// GetClientChannels returns the number of input and output channels mixed for each client of a server
+func GetClientChannels(config ServerAgentConfig) (int, int) {
+	return getChannelsPerClient(config.InputChannelsPerClient), getChannelsPerClient(config.OutputChannelsPerClient)
+}
+
+// GetClientChannelsSCLang returns sclang code that configures the client channels of the mixer; it is run before the mix code
+func GetClientChannelsSCLang(config ServerAgentConfig) string {
+	inputs, outputs := GetClientChannels(config)
+	return fmt.Sprintf(clientChannelsSCLangTemplate, inputs, outputs)
+}
+
+// SCServerOptions are the options used to boot the SuperCollider audio server
+type SCServerOptions struct {
+	MemorySize   int
+	AudioBuses   int
+	ControlBuses int
+	MaxNodes     int
+	MaxSynthDefs int
+	WireBuffers  int
+}
+
+// GetSCServerOptions returns the options used to boot the SuperCollider audio server for a config,
+// preferring explicit overrides to values derived from the number of musicians and channels
+func GetSCServerOptions(config ServerAgentConfig) SCServerOptions {
+	musicians := config.MaxMusicians
+	if musicians < 1 {
+		musicians = 1
+	}
+	inputs, outputs := GetClientChannels(config)
+	clientChannels := musicians * maxInt(inputs, outputs)
+
+	// each client channel needs buses and wires for its inputs, outputs and effects,
+	// plus memory for the delay lines and buffers used by the mix
+	options := SCServerOptions{
+		MemorySize:   maxInt(defaultSCMemorySize, clientChannels*2048),
+		AudioBuses:   nextPowerOfTwo(maxInt(defaultSCAudioBuses, clientChannels*8)),
+		ControlBuses: defaultSCControlBuses,
+		MaxNodes:     maxInt(defaultSCMaxNodes, musicians*64),
+		MaxSynthDefs: defaultSCMaxSynthDefs,
+		WireBuffers:  nextPowerOfTwo(maxInt(defaultSCWireBuffers, clientChannels*4)),
+	}
+
+	overrides := config.SuperColliderConfig
+	if overrides.SCMemorySize > 0 {
+		options.MemorySize = overrides.SCMemorySize
+	}
+	if overrides.SCAudioBuses > 0 {
+		options.AudioBuses = overrides.SCAudioBuses
+	}
+	if overrides.SCControlBuses > 0 {
+		options.ControlBuses = overrides.SCControlBuses
+	}
+	if overrides.SCMaxNodes > 0 {
+		options.MaxNodes = overrides.SCMaxNodes
+	}
+	if overrides.SCMaxSynthDefs > 0 {
+		options.MaxSynthDefs = overrides.SCMaxSynthDefs
+	}
+	if overrides.SCWireBuffers > 0 {
+		options.WireBuffers = overrides.SCWireBuffers
+	}
+	return options
+}
+
+// Args returns command line arguments for scsynth or supernova
+func (o SCServerOptions) Args() []string {
+	return []string{
+		"-m", strconv.Itoa(o.MemorySize),
+		"-a", strconv.Itoa(o.AudioBuses),
+		"-c", strconv.Itoa(o.ControlBuses),
+		"-n", strconv.Itoa(o.MaxNodes),
+		"-d", strconv.Itoa(o.MaxSynthDefs),
+		"-w", strconv.Itoa(o.WireBuffers),
+	}
+}
+
+// SCLang returns sclang code that applies the options to the default server before it is booted
+func (o SCServerOptions) SCLang() string {
+	return fmt.Sprintf("s.options.memSize = %d;\ns.options.numAudioBusChannels = %d;\n"+
+		"s.options.numControlBusChannels = %d;\ns.options.maxNodes = %d;\n"+
+		"s.options.maxSynthDefs = %d;\ns.options.numWireBufs = %d;\n",
+		o.MemorySize, o.AudioBuses, o.ControlBuses, o.MaxNodes, o.MaxSynthDefs, o.WireBuffers)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// nextPowerOfTwo rounds n up to the nearest power of two
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
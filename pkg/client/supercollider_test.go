@@ -0,0 +1,75 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSCServerOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for a small studio, which uses the SuperCollider defaults
+	config := ServerAgentConfig{MaxMusicians: 2}
+	options := GetSCServerOptions(config)
+	assert.Equal(SCServerOptions{MemorySize: 8192, AudioBuses: 1024, ControlBuses: 16384,
+		MaxNodes: 1024, MaxSynthDefs: 1024, WireBuffers: 64}, options)
+
+	// Case for a large studio with many channels
+	config = ServerAgentConfig{MaxMusicians: 40}
+	config.InputChannelsPerClient = 6
+	config.OutputChannelsPerClient = 2
+	options = GetSCServerOptions(config)
+	assert.Equal(491520, options.MemorySize)
+	assert.Equal(2048, options.AudioBuses)
+	assert.Equal(2560, options.MaxNodes)
+	assert.Equal(1024, options.WireBuffers)
+
+	// Case for explicit overrides
+	config.SCMemorySize = 65536
+	config.SCMaxNodes = 4096
+	config.SCWireBuffers = -1
+	options = GetSCServerOptions(config)
+	assert.Equal(65536, options.MemorySize)
+	assert.Equal(4096, options.MaxNodes)
+	assert.Equal(2048, options.AudioBuses)
+	assert.Equal(1024, options.WireBuffers)
+}
+
+func TestSCServerOptionsArgs(t *testing.T) {
+	assert := assert.New(t)
+	options := SCServerOptions{MemorySize: 8192, AudioBuses: 1024, ControlBuses: 16384,
+		MaxNodes: 1024, MaxSynthDefs: 1024, WireBuffers: 64}
+	assert.Equal([]string{"-m", "8192", "-a", "1024", "-c", "16384", "-n", "1024", "-d", "1024", "-w", "64"}, options.Args())
+	assert.Contains(options.SCLang(), "s.options.memSize = 8192;\n")
+	assert.Contains(options.SCLang(), "s.options.numWireBufs = 64;\n")
+}
+
+func TestGetClientChannels(t *testing.T) {
+	assert := assert.New(t)
+	config := ServerAgentConfig{}
+	inputs, outputs := GetClientChannels(config)
+	assert.Equal(2, inputs)
+	assert.Equal(2, outputs)
+	assert.Equal("~inputChannelsPerClient = 2;\n~outputChannelsPerClient = 2;\n", GetClientChannelsSCLang(config))
+
+	config.InputChannelsPerClient = 1
+	config.OutputChannelsPerClient = 32
+	inputs, outputs = GetClientChannels(config)
+	assert.Equal(1, inputs)
+	assert.Equal(MaxChannelsPerClient, outputs)
+}
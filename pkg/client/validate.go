@@ -0,0 +1,251 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// validSampleRates are the sample rates supported by JACK on devices and servers
+var validSampleRates = map[int]bool{
+	44100: true,
+	48000: true,
+	96000: true,
+}
+
+// configErrors accumulates problems found while validating a config
+type configErrors []string
+
+// checkRange records an error if a value is outside of [min, max]
+func (e *configErrors) checkRange(name string, value, min, max int) {
+	if value < min || value > max {
+		*e = append(*e, fmt.Sprintf("%s must be between %d and %d, got %d", name, min, max, value))
+	}
+}
+
+// err returns an error describing all problems, or nil if there were none
+func (e configErrors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config: %s", strings.Join(e, "; "))
+}
+
+// isPowerOfTwo returns true if n is a positive power of two
+func isPowerOfTwo(n int) bool {
+	return n > 0 && n&(n-1) == 0
+}
+
+// validateBufferConfig checks network buffering settings; zero values mean defaults are used
+func (e *configErrors) validateBufferConfig(config BufferConfig) {
+	if config.Period != 0 && (!isPowerOfTwo(config.Period) || config.Period < 16 || config.Period > 1024) {
+		*e = append(*e, fmt.Sprintf("period must be a power of two between 16 and 1024, got %d", config.Period))
+	}
+	// NOTE: queue buffers <= 0 mean the queue is sized automatically
+	if config.QueueBuffer > 1024 {
+		*e = append(*e, fmt.Sprintf("queueBuffer must be at most 1024, got %d", config.QueueBuffer))
+	}
+	e.checkRange("bufferStrategy", config.BufferStrategy, 0, 4)
+}
+
+// checkMulticastAddress records an error if a value is not an IPv4 multicast address
+func (e *configErrors) checkMulticastAddress(name, value string) {
+	ip := net.ParseIP(value)
+	if ip == nil || ip.To4() == nil || !ip.IsMulticast() {
+		*e = append(*e, fmt.Sprintf("%s must be an IPv4 multicast address, got %q", name, value))
+	}
+}
+
+// validateAES67Config checks AES67 bridging settings; they are only required when bridging is enabled
+func (e *configErrors) validateAES67Config(config AES67Config) {
+	e.checkRange("aes67Port", config.AES67Port, 0, 65535)
+	e.checkRange("aes67PtpDomain", config.AES67PTPDomain, 0, 127)
+	if !config.AES67Enabled {
+		return
+	}
+	e.checkMulticastAddress("aes67SourceAddress", config.AES67SourceAddress)
+	e.checkMulticastAddress("aes67DestinationAddress", config.AES67DestinationAddress)
+}
+
+// validateMQTTConfig checks MQTT publishing settings; they are only required when a broker is configured
+func (e *configErrors) validateMQTTConfig(config MQTTConfig) {
+	if config.MQTTBroker == "" {
+		return
+	}
+	host := config.MQTTBroker
+	if h, port, err := net.SplitHostPort(config.MQTTBroker); err == nil {
+		host = h
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			*e = append(*e, fmt.Sprintf("mqttBroker has an invalid port %q", port))
+		}
+	}
+	if host == "" || strings.ContainsAny(host, "/ ") {
+		*e = append(*e, fmt.Sprintf("mqttBroker must be a host with an optional port, got %q", config.MQTTBroker))
+	}
+	if strings.ContainsAny(config.MQTTTopicPrefix, "#+") {
+		*e = append(*e, fmt.Sprintf("mqttTopicPrefix must not contain wildcards, got %q", config.MQTTTopicPrefix))
+	}
+}
+
+// validateLogForwardingConfig checks remote syslog settings; they are only required when forwarding is enabled
+func (e *configErrors) validateLogForwardingConfig(config LogForwardingConfig) {
+	if config.SyslogAddress == "" {
+		return
+	}
+	u, err := url.Parse(config.SyslogAddress)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Hostname() == "" || u.Port() == "" {
+		*e = append(*e, fmt.Sprintf("syslogAddress must be formatted as udp://host:port or tcp://host:port, got %q", config.SyslogAddress))
+	}
+}
+
+// validateClockSyncConfig checks clock sync settings
+func (e *configErrors) validateClockSyncConfig(config ClockSyncConfig) {
+	e.checkRange("clockSyncPtpDomain", config.ClockSyncPTPDomain, 0, 127)
+	switch config.ClockSync {
+	case ClockSyncOff, ClockSyncPTP:
+	case ClockSyncChrony:
+		if strings.Trim(config.ClockSyncServers, ", ") == "" {
+			*e = append(*e, "clockSyncServers is required when clockSync is chrony")
+		}
+	default:
+		*e = append(*e, fmt.Sprintf("unknown clockSync %q", config.ClockSync))
+	}
+	if strings.ContainsAny(config.ClockSyncServers, " \n\t") || strings.ContainsAny(config.ClockSyncInterface, " \n\t/") {
+		*e = append(*e, "clockSyncServers and clockSyncInterface must not contain whitespace")
+	}
+}
+
+// validateStandbyConfig validates the standby server for a device
+func (e *configErrors) validateStandbyConfig(config StandbyConfig) {
+	e.checkRange("standbyPort", config.StandbyPort, 0, 65535)
+	e.checkRange("standbyMissedKeepalives", config.StandbyMissedKeepalives, 0, 100)
+	if strings.ContainsAny(config.StandbyHost, " \n\t/") {
+		*e = append(*e, "standbyHost must not contain whitespace")
+	}
+}
+
+// validateNetworkBindingConfig checks the interface and source address used for audio
+func (e *configErrors) validateNetworkBindingConfig(config NetworkBindingConfig) {
+	if len(config.AudioInterface) > 15 || strings.ContainsAny(config.AudioInterface, " \n\t/") {
+		*e = append(*e, fmt.Sprintf("audioInterface must be a network interface name, got %q", config.AudioInterface))
+	}
+	if config.AudioAddress != "" && net.ParseIP(config.AudioAddress) == nil {
+		*e = append(*e, fmt.Sprintf("audioAddress must be an IP address, got %q", config.AudioAddress))
+	}
+}
+
+// validateJitterTuningConfig checks the bounds for tuning the jitter queue; they are only required when tuning is enabled
+func (e *configErrors) validateJitterTuningConfig(config JitterTuningConfig) {
+	if !config.AdaptiveQueue {
+		return
+	}
+	e.checkRange("queueBufferMin", config.QueueBufferMin, 1, 1024)
+	e.checkRange("queueBufferMax", config.QueueBufferMax, 1, 1024)
+	if config.QueueBufferMin > config.QueueBufferMax {
+		*e = append(*e, fmt.Sprintf("queueBufferMin must not be greater than queueBufferMax, got %d > %d", config.QueueBufferMin, config.QueueBufferMax))
+	}
+}
+
+// getMaxDeviceChannels returns the largest number of input or output channels supported by a device config
+func getMaxDeviceChannels(config DeviceAgentConfig) int {
+	// Jamulus only supports mono and stereo
+	if config.Type == Jamulus || (config.Type == JackTripJamulus && config.Quality < 2) {
+		return 2
+	}
+	// AES67 streams carry at most 8 channels
+	if config.AES67Enabled {
+		return 8
+	}
+	return MaxLayoutChannels
+}
+
+// ValidateDeviceAgentConfig checks that a device config can be applied safely, so that broken
+// payloads are rejected instead of being written to service configs
+func ValidateDeviceAgentConfig(config DeviceAgentConfig) error {
+	var e configErrors
+
+	// newer config versions are applied on a best-effort basis, since they may only add settings
+	if config.ConfigVersion < 0 {
+		e = append(e, fmt.Sprintf("configVersion must not be negative, got %d", config.ConfigVersion))
+	}
+
+	// ALSA settings
+	e.checkRange("captureVolume", config.CaptureVolume, 0, 100)
+	e.checkRange("playbackVolume", config.PlaybackVolume, 0, 100)
+	e.checkRange("monitorVolume", config.MonitorVolume, 0, 100)
+
+	// device settings
+	e.checkRange("devicePort", config.DevicePort, 0, 65535)
+	e.checkRange("reverb", config.Reverb, 0, 100)
+	e.checkRange("quality", config.Quality, 0, 2)
+	e.checkRange("inputChannels", config.InputChannels, 0, getMaxDeviceChannels(config))
+	e.checkRange("outputChannels", config.OutputChannels, 0, getMaxDeviceChannels(config))
+	if config.MetronomeBPM != 0 {
+		e.checkRange("metronomeBpm", config.MetronomeBPM, 20, 400)
+	}
+	switch config.MetronomeRouting {
+	case "", MetronomeMonitor, MetronomeServer, MetronomeMonitorAndServer:
+	default:
+		e = append(e, fmt.Sprintf("unknown metronomeRouting %q", config.MetronomeRouting))
+	}
+	switch config.Timecode {
+	case TimecodeOff, TimecodeLTC, TimecodeMTC:
+	default:
+		e = append(e, fmt.Sprintf("unknown timecode %q", config.Timecode))
+	}
+	switch config.TimecodeFPS {
+	case 0, 24, 25, 30:
+	default:
+		e = append(e, fmt.Sprintf("timecodeFps must be 24, 25 or 30, got %d", config.TimecodeFPS))
+	}
+	if config.TimecodeToServer && config.Timecode != TimecodeLTC {
+		e = append(e, "timecodeToServer requires ltc timecode")
+	}
+
+	e.validateAES67Config(config.AES67Config)
+	e.validateMQTTConfig(config.MQTTConfig)
+	e.validateLogForwardingConfig(config.LogForwardingConfig)
+	e.validateClockSyncConfig(config.ClockSyncConfig)
+	e.validateStandbyConfig(config.StandbyConfig)
+	e.validateNetworkBindingConfig(config.NetworkBindingConfig)
+	e.validateJitterTuningConfig(config.JitterTuningConfig)
+	e.checkRange("localRateLimit", config.LocalRateLimit, 0, 6000)
+
+	// server settings
+	e.checkRange("serverPort", config.Port, 0, 65535)
+	if config.SampleRate != 0 && !validSampleRates[config.SampleRate] {
+		e = append(e, fmt.Sprintf("unsupported sampleRate %d", config.SampleRate))
+	}
+	e.validateBufferConfig(config.BufferConfig)
+
+	// required fields when connected to a studio
+	if config.Enabled {
+		if config.Host == "" {
+			e = append(e, "serverHost is required when enabled")
+		}
+		if config.Type == "" {
+			e = append(e, "type is required when enabled")
+		}
+		if config.SampleRate == 0 {
+			e = append(e, "sampleRate is required when enabled")
+		}
+	}
+
+	return e.err()
+}
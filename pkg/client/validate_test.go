@@ -0,0 +1,187 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDeviceAgentConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for an empty, disabled config
+	assert.Nil(ValidateDeviceAgentConfig(DeviceAgentConfig{}))
+
+	// Case for a typical enabled config
+	config := DeviceAgentConfig{}
+	config.Enabled = true
+	config.Host = "a.b.com"
+	config.Type = JackTrip
+	config.Port = 4464
+	config.SampleRate = 48000
+	config.Period = 128
+	config.QueueBuffer = 4
+	config.CaptureVolume = 100
+	config.MetronomeRouting = MetronomeServer
+	assert.Nil(ValidateDeviceAgentConfig(config))
+
+	// Case for out-of-range values
+	bad := config
+	bad.CaptureVolume = 101
+	bad.Port = 70000
+	bad.Period = 100
+	err := ValidateDeviceAgentConfig(bad)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "captureVolume")
+	assert.Contains(err.Error(), "serverPort")
+	assert.Contains(err.Error(), "period")
+
+	// Case for unsupported values
+	bad = config
+	bad.SampleRate = 22050
+	bad.MetronomeRouting = "speakers"
+	bad.TimecodeFPS = 29
+	bad.TimecodeToServer = true
+	bad.ConfigVersion = -1
+	err = ValidateDeviceAgentConfig(bad)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "sampleRate")
+	assert.Contains(err.Error(), "metronomeRouting")
+	assert.Contains(err.Error(), "timecodeFps")
+	assert.Contains(err.Error(), "timecodeToServer")
+	assert.Contains(err.Error(), "configVersion")
+
+	// Case for configs newer than this agent, which are still accepted
+	newer := config
+	newer.ConfigVersion = AgentConfigVersion + 1
+	assert.Nil(ValidateDeviceAgentConfig(newer))
+
+	// Case for MQTT publishing
+	mqtt := config
+	mqtt.MQTTBroker = "homeassistant.local:1883"
+	mqtt.MQTTTopicPrefix = "studio/pi"
+	assert.Nil(ValidateDeviceAgentConfig(mqtt))
+	mqtt.MQTTBroker = "mqtt://homeassistant.local"
+	mqtt.MQTTTopicPrefix = "studio/#"
+	err = ValidateDeviceAgentConfig(mqtt)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "mqttBroker")
+	assert.Contains(err.Error(), "mqttTopicPrefix")
+
+	// Case for remote syslog forwarding
+	logs := config
+	logs.SyslogAddress = "udp://logs.example.com:514"
+	assert.Nil(ValidateDeviceAgentConfig(logs))
+	logs.SyslogAddress = "logs.example.com:514"
+	err = ValidateDeviceAgentConfig(logs)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "syslogAddress")
+
+	// Case for clock sync
+	clock := config
+	clock.ClockSync = ClockSyncChrony
+	clock.ClockSyncServers = "ntp1.local,ntp2.local"
+	assert.Nil(ValidateDeviceAgentConfig(clock))
+	clock.ClockSyncServers = ""
+	clock.ClockSyncPTPDomain = -1
+	err = ValidateDeviceAgentConfig(clock)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "clockSyncServers")
+	assert.Contains(err.Error(), "clockSyncPtpDomain")
+	clock.ClockSync = "gps"
+	assert.Contains(ValidateDeviceAgentConfig(clock).Error(), "unknown clockSync")
+
+	// Case for standby servers
+	standby := config
+	standby.StandbyHost = "standby.b.com"
+	standby.StandbyMissedKeepalives = 3
+	assert.Nil(ValidateDeviceAgentConfig(standby))
+	standby.StandbyHost = "standby b.com"
+	standby.StandbyPort = 70000
+	standby.StandbyMissedKeepalives = -1
+	err = ValidateDeviceAgentConfig(standby)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "standbyHost")
+	assert.Contains(err.Error(), "standbyPort")
+	assert.Contains(err.Error(), "standbyMissedKeepalives")
+
+	// Case for audio network bindings
+	binding := config
+	binding.AudioInterface = "eth0.20"
+	binding.AudioAddress = "10.0.20.5"
+	assert.Nil(ValidateDeviceAgentConfig(binding))
+	binding.AudioInterface = "eth0 20"
+	binding.AudioAddress = "10.0.20"
+	err = ValidateDeviceAgentConfig(binding)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "audioInterface")
+	assert.Contains(err.Error(), "audioAddress")
+
+	// Case for jitter queue tuning
+	tuning := config
+	tuning.QueueBufferMin = 50
+	assert.Nil(ValidateDeviceAgentConfig(tuning))
+	tuning.AdaptiveQueue = true
+	tuning.QueueBufferMax = 10
+	err = ValidateDeviceAgentConfig(tuning)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "queueBufferMin must not be greater")
+	tuning.QueueBufferMin = 0
+	assert.Contains(ValidateDeviceAgentConfig(tuning).Error(), "queueBufferMin must be between")
+
+	// Case for local access
+	local := config
+	local.LocalAuth = true
+	local.LocalRateLimit = 60
+	assert.Nil(ValidateDeviceAgentConfig(local))
+	local.LocalRateLimit = -1
+	assert.Contains(ValidateDeviceAgentConfig(local).Error(), "localRateLimit")
+
+	// Case for multichannel layouts, which are only supported by JackTrip
+	multi := config
+	multi.InputChannels = 16
+	multi.OutputChannels = 4
+	assert.Nil(ValidateDeviceAgentConfig(multi))
+	multi.Type = Jamulus
+	err = ValidateDeviceAgentConfig(multi)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "inputChannels")
+	assert.Contains(err.Error(), "outputChannels")
+
+	// Case for missing required fields
+	bad = DeviceAgentConfig{}
+	bad.Enabled = true
+	err = ValidateDeviceAgentConfig(bad)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "serverHost")
+	assert.Contains(err.Error(), "type")
+	assert.Contains(err.Error(), "sampleRate is required")
+
+	// Case for AES67 bridging
+	aes67 := config
+	aes67.AES67Enabled = true
+	aes67.AES67SourceAddress = "239.69.1.1"
+	aes67.AES67DestinationAddress = "239.69.1.2"
+	assert.Nil(ValidateDeviceAgentConfig(aes67))
+	aes67.AES67DestinationAddress = "10.0.0.2"
+	aes67.AES67PTPDomain = 128
+	err = ValidateDeviceAgentConfig(aes67)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "aes67DestinationAddress")
+	assert.Contains(err.Error(), "aes67PtpDomain")
+	assert.NotContains(err.Error(), "aes67SourceAddress")
+}
@@ -0,0 +1,128 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FirewallTable is the nftables table managed by the agent
+const FirewallTable = "jacktrip_agent"
+
+// DrainTable is the nftables table that blocks new connections to an audio server while it drains
+const DrainTable = "jacktrip_drain"
+
+// SSHPort is always left open, so that a host can't be locked out by its firewall
+const SSHPort = 22
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	First int
+	Last  int
+}
+
+// FirewallRules describes the ports that are reachable when the managed firewall is enabled;
+// all other incoming traffic is dropped, except for replies to outgoing connections
+type FirewallRules struct {
+	// TCP ports that accept connections
+	TCPPorts []int
+
+	// UDP ports that accept packets
+	UDPPorts []int
+
+	// UDP port ranges that accept packets (ie. the ports a JackTrip hub server assigns to its clients)
+	UDPRanges []PortRange
+
+	// TCP ports of HTTP endpoints, which accept a limited number of new connections from each address
+	HTTPPorts []int
+
+	// New connections per minute allowed from each address to the HTTP ports
+	HTTPRateLimit int
+}
+
+// formatPortSet returns a sorted nftables set of unique ports (ie. "{ 22, 80 }")
+func formatPortSet(ports []int) string {
+	unique := map[int]bool{}
+	for _, port := range ports {
+		if port > 0 && port < 65536 {
+			unique[port] = true
+		}
+	}
+	var sorted []int
+	for port := range unique {
+		sorted = append(sorted, port)
+	}
+	sort.Ints(sorted)
+	values := make([]string, len(sorted))
+	for i, port := range sorted {
+		values[i] = strconv.Itoa(port)
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "{ " + strings.Join(values, ", ") + " }"
+}
+
+// GetNFTablesRuleset returns an nftables script that replaces the managed table with the given rules
+func GetNFTablesRuleset(rules FirewallRules) string {
+	var b strings.Builder
+	// declaring the table first makes flushing it safe when it does not exist yet
+	fmt.Fprintf(&b, "table inet %s {}\nflush table inet %s\n\n", FirewallTable, FirewallTable)
+	fmt.Fprintf(&b, "table inet %s {\n", FirewallTable)
+	b.WriteString("  chain input {\n")
+	b.WriteString("    type filter hook input priority 0; policy drop;\n")
+	b.WriteString("    ct state established,related accept\n")
+	b.WriteString("    ct state invalid drop\n")
+	b.WriteString("    iif lo accept\n")
+	b.WriteString("    meta l4proto { icmp, ipv6-icmp } accept\n")
+	if ports := formatPortSet(rules.HTTPPorts); ports != "" {
+		if rules.HTTPRateLimit > 0 {
+			limit := fmt.Sprintf("limit rate over %d/minute burst %d packets", rules.HTTPRateLimit, rules.HTTPRateLimit)
+			fmt.Fprintf(&b, "    tcp dport %s ct state new meter http4 { ip saddr %s } drop\n", ports, limit)
+			fmt.Fprintf(&b, "    tcp dport %s ct state new meter http6 { ip6 saddr %s } drop\n", ports, limit)
+		}
+		fmt.Fprintf(&b, "    tcp dport %s accept\n", ports)
+	}
+	if ports := formatPortSet(rules.TCPPorts); ports != "" {
+		fmt.Fprintf(&b, "    tcp dport %s accept\n", ports)
+	}
+	if ports := formatPortSet(rules.UDPPorts); ports != "" {
+		fmt.Fprintf(&b, "    udp dport %s accept\n", ports)
+	}
+	for _, r := range rules.UDPRanges {
+		if r.First > 0 && r.First <= r.Last && r.Last < 65536 {
+			fmt.Fprintf(&b, "    udp dport %d-%d accept\n", r.First, r.Last)
+		}
+	}
+	b.WriteString("  }\n}\n")
+	return b.String()
+}
+
+// GetDrainRuleset returns an nftables script that rejects new connections to a server port, while packets of
+// established connections continue to flow
+func GetDrainRuleset(port int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "table inet %s {}\nflush table inet %s\n\n", DrainTable, DrainTable)
+	fmt.Fprintf(&b, "table inet %s {\n", DrainTable)
+	b.WriteString("  chain input {\n")
+	b.WriteString("    type filter hook input priority -10; policy accept;\n")
+	fmt.Fprintf(&b, "    tcp dport %d ct state new reject with tcp reset\n", port)
+	fmt.Fprintf(&b, "    udp dport %d ct state new drop\n", port)
+	b.WriteString("  }\n}\n")
+	return b.String()
+}
@@ -0,0 +1,64 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatPortSet(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", formatPortSet(nil))
+	assert.Equal("", formatPortSet([]int{0, 70000}))
+	assert.Equal("{ 22, 80, 4464 }", formatPortSet([]int{4464, 80, 22, 80}))
+}
+
+func TestGetNFTablesRuleset(t *testing.T) {
+	assert := assert.New(t)
+	result := GetNFTablesRuleset(FirewallRules{
+		TCPPorts:      []int{22},
+		UDPPorts:      []int{5353, 4464},
+		HTTPPorts:     []int{80, 443},
+		HTTPRateLimit: 120,
+	})
+	assert.Contains(result, "table inet jacktrip_agent {}\nflush table inet jacktrip_agent\n")
+	assert.Contains(result, "policy drop;")
+	assert.Contains(result, "ct state established,related accept")
+	assert.Contains(result, "tcp dport { 80, 443 } ct state new meter http4 { ip saddr limit rate over 120/minute burst 120 packets } drop")
+	assert.Contains(result, "tcp dport { 80, 443 } accept")
+	assert.Contains(result, "tcp dport { 22 } accept")
+	assert.Contains(result, "udp dport { 4464, 5353 } accept")
+
+	// Case for a UDP port range, where invalid ranges are skipped
+	result = GetNFTablesRuleset(FirewallRules{UDPRanges: []PortRange{{61002, 61999}, {5000, 4000}}})
+	assert.Contains(result, "udp dport 61002-61999 accept\n")
+	assert.NotContains(result, "5000")
+
+	// Case for no rate limit or UDP ports
+	result = GetNFTablesRuleset(FirewallRules{TCPPorts: []int{22}, HTTPPorts: []int{80}})
+	assert.NotContains(result, "meter")
+	assert.NotContains(result, "udp dport")
+}
+
+func TestGetDrainRuleset(t *testing.T) {
+	assert := assert.New(t)
+	result := GetDrainRuleset(4464)
+	assert.Contains(result, "table inet jacktrip_drain {}\nflush table inet jacktrip_drain\n")
+	assert.Contains(result, "policy accept;")
+	assert.Contains(result, "tcp dport 4464 ct state new reject with tcp reset\n")
+	assert.Contains(result, "udp dport 4464 ct state new drop\n")
+}
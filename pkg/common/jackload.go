@@ -0,0 +1,43 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+/*
+#cgo linux LDFLAGS: -ljack
+#cgo darwin LDFLAGS: -ljack
+
+#include <jack/jack.h>
+*/
+import "C"
+
+import (
+	"unsafe"
+
+	"github.com/xthexder/go-jack"
+)
+
+// GetJackCPULoad returns the percent of the JACK process cycle used by all clients of the server that a
+// client is connected to; go-jack does not wrap jack_cpu_load, so the client's handle, which is the first
+// field of jack.Client, is passed to it directly
+func GetJackCPULoad(client *jack.Client) float64 {
+	if client == nil {
+		return 0
+	}
+	handle := *(**C.jack_client_t)(unsafe.Pointer(client))
+	if handle == nil {
+		return 0
+	}
+	return float64(C.jack_cpu_load(handle))
+}
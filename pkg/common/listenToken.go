@@ -0,0 +1,86 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidListenToken is returned for listen tokens that are malformed or were not signed by the agent
+	ErrInvalidListenToken = errors.New("invalid listen token")
+
+	// ErrListenTokenExpired is returned for listen tokens that are no longer valid
+	ErrListenTokenExpired = errors.New("listen token has expired")
+)
+
+// ListenClaims describe the access granted by a listen token
+type ListenClaims struct {
+	// Unix time when the token was issued
+	IssuedAt int64 `json:"iat"`
+
+	// Unix time when the token expires
+	ExpiresAt int64 `json:"exp"`
+
+	// Optional label used to tell tokens apart in logs (ie. "guest list")
+	Label string `json:"label,omitempty"`
+}
+
+// signListenToken returns the HMAC-SHA256 signature of an encoded listen token payload
+func signListenToken(secret, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("listen."))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// MintListenToken returns a URL-safe token granting access to a studio's broadcast until the claims expire
+func MintListenToken(secret string, claims ListenClaims) (string, error) {
+	rawBytes, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(rawBytes)
+	return payload + "." + signListenToken(secret, payload), nil
+}
+
+// VerifyListenToken checks the signature and expiration of a listen token, returning its claims
+func VerifyListenToken(secret, token string, now time.Time) (ListenClaims, error) {
+	var claims ListenClaims
+	splits := strings.Split(token, ".")
+	if secret == "" || len(splits) != 2 {
+		return claims, ErrInvalidListenToken
+	}
+	if !hmac.Equal([]byte(signListenToken(secret, splits[0])), []byte(splits[1])) {
+		return claims, ErrInvalidListenToken
+	}
+	rawBytes, err := base64.RawURLEncoding.DecodeString(splits[0])
+	if err != nil || json.Unmarshal(rawBytes, &claims) != nil {
+		return claims, ErrInvalidListenToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return claims, ErrListenTokenExpired
+	}
+	return claims, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenToken(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1650000000, 0)
+	claims := ListenClaims{IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix(), Label: "guests"}
+
+	token, err := MintListenToken("secret", claims)
+	assert.NoError(err)
+	assert.NotContains(token, "secret")
+	assert.Equal(token, strings.NewReplacer("+", "", "/", "", "=", "").Replace(token))
+
+	result, err := VerifyListenToken("secret", token, now.Add(time.Minute))
+	assert.NoError(err)
+	assert.Equal(claims, result)
+
+	// Case for expired tokens
+	_, err = VerifyListenToken("secret", token, now.Add(time.Hour))
+	assert.Equal(ErrListenTokenExpired, err)
+
+	// Case for tokens signed with another secret, or tampered with
+	_, err = VerifyListenToken("other", token, now)
+	assert.Equal(ErrInvalidListenToken, err)
+	forged, _ := MintListenToken("other", ListenClaims{ExpiresAt: now.Add(24 * time.Hour).Unix()})
+	_, err = VerifyListenToken("secret", strings.Split(forged, ".")[0]+"."+strings.Split(token, ".")[1], now)
+	assert.Equal(ErrInvalidListenToken, err)
+
+	// Case for malformed tokens
+	for _, bad := range []string{"", "abc", "a.b.c", "!!!." + strings.Split(token, ".")[1]} {
+		_, err = VerifyListenToken("secret", bad, now)
+		assert.Equal(ErrInvalidListenToken, err, bad)
+	}
+	_, err = VerifyListenToken("", token, now)
+	assert.Equal(ErrInvalidListenToken, err)
+}
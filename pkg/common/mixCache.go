@@ -0,0 +1,207 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// MixArchiveURL is a template for the URL of a source archive of the jacktrip-sc repository at a commit
+	MixArchiveURL = "https://codeload.github.com/jacktrip/jacktrip-sc/tar.gz/%s"
+
+	// MixCacheDir is the directory that jacktrip-sc revisions are cached in
+	MixCacheDir = "/var/lib/jacktrip/mix"
+
+	// MixFetchTimeout is the maximum time spent downloading a jacktrip-sc revision
+	MixFetchTimeout = 2 * time.Minute
+
+	// mixChecksumFile is saved with each cached revision, so that a revision is fetched again if its checksum changes
+	mixChecksumFile = ".sha256"
+)
+
+var (
+	// ErrInvalidMixRevision is returned for revisions that are not a commit hash
+	ErrInvalidMixRevision = errors.New("mix revision must be a commit hash")
+
+	// ErrMixChecksumMismatch is returned when a downloaded revision does not match the expected checksum
+	ErrMixChecksumMismatch = errors.New("mix revision does not match checksum")
+
+	// mixRevisionRegexp matches abbreviated or full git commit hashes
+	mixRevisionRegexp = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+)
+
+// MixCache fetches revisions of the jacktrip-sc repository, so that the mix runs the same code after every restart
+type MixCache struct {
+	// Dir is the directory that revisions are extracted to, one subdirectory per revision
+	Dir string
+
+	// ArchiveURL is a template for the URL of the source archive of a revision
+	ArchiveURL string
+
+	// HTTPClient used to download revisions
+	HTTPClient *http.Client
+}
+
+// NewMixCache constructs a new instance of MixCache
+func NewMixCache() *MixCache {
+	return &MixCache{
+		Dir:        MixCacheDir,
+		ArchiveURL: MixArchiveURL,
+		HTTPClient: &http.Client{Timeout: MixFetchTimeout},
+	}
+}
+
+// Path returns the directory of a cached revision
+func (c *MixCache) Path(revision string) string {
+	return filepath.Join(c.Dir, revision)
+}
+
+// Get returns the directory of a revision, fetching it if it is not cached; if checksum is not empty,
+// the source archive must match it
+func (c *MixCache) Get(ctx context.Context, revision, checksum string) (string, error) {
+	revision, checksum = strings.ToLower(revision), strings.ToLower(checksum)
+	if !mixRevisionRegexp.MatchString(revision) {
+		return "", ErrInvalidMixRevision
+	}
+	path := c.Path(revision)
+	if cached, err := ioutil.ReadFile(filepath.Join(path, mixChecksumFile)); err == nil {
+		if checksum == "" || string(cached) == checksum {
+			return path, nil
+		}
+	}
+	if err := c.fetch(ctx, revision, checksum); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// fetch downloads and extracts a revision, replacing any cached copy
+func (c *MixCache) fetch(ctx context.Context, revision, checksum string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(c.ArchiveURL, revision), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to fetch mix revision %s: %s", revision, resp.Status)
+	}
+
+	// keep the archive until its checksum is verified, so that nothing is extracted from a tampered download
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	archive, err := ioutil.TempFile(c.Dir, revision+"-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(archive, hash), resp.Body); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if checksum != "" && sum != checksum {
+		return fmt.Errorf("%w: expected %s, got %s", ErrMixChecksumMismatch, checksum, sum)
+	}
+	if _, err := archive.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmpDir, err := ioutil.TempDir(c.Dir, revision+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := extractTarGz(archive, tmpDir); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmpDir, mixChecksumFile), []byte(sum), 0644); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(c.Path(revision)); err != nil {
+		return err
+	}
+	return os.Rename(tmpDir, c.Path(revision))
+}
+
+// extractTarGz extracts a gzipped tar archive into a directory, removing the top-level directory
+// that GitHub adds to source archives
+func extractTarGz(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		splits := strings.SplitN(strings.TrimPrefix(header.Name, "./"), "/", 2)
+		if len(splits) < 2 || splits[1] == "" {
+			continue
+		}
+		target := filepath.Join(dir, splits[1])
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in archive: %s", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&0755)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// getTestArchive returns a gzipped tar archive containing the given files
+func getTestArchive(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestMixCacheGet(t *testing.T) {
+	assert := assert.New(t)
+	revision := "0123abcd"
+	archive := getTestArchive(t, map[string]string{
+		"jacktrip-sc-0123abcd/classes/JackTripMix.sc": "JackTripMix {}",
+		"jacktrip-sc-0123abcd/README.md":              "jacktrip-sc",
+	})
+	sum := sha256.Sum256(archive)
+	checksum := hex.EncodeToString(sum[:])
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/"+revision {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(archive)
+	}))
+	defer server.Close()
+	cache := NewMixCache()
+	cache.Dir = t.TempDir()
+	cache.ArchiveURL = server.URL + "/%s"
+	ctx := context.Background()
+
+	// Case for invalid revisions
+	_, err := cache.Get(ctx, "main", "")
+	assert.Equal(ErrInvalidMixRevision, err)
+	_, err = cache.Get(ctx, "../../etc", "")
+	assert.Equal(ErrInvalidMixRevision, err)
+
+	// Case for a checksum mismatch, which leaves nothing in the cache
+	_, err = cache.Get(ctx, revision, hex.EncodeToString(make([]byte, 32)))
+	assert.True(errors.Is(err, ErrMixChecksumMismatch))
+	assert.NoDirExists(cache.Path(revision))
+
+	// Case for fetching a revision
+	path, err := cache.Get(ctx, revision, checksum)
+	assert.NoError(err)
+	assert.Equal(filepath.Join(cache.Dir, revision), path)
+	content, err := ioutil.ReadFile(filepath.Join(path, "classes", "JackTripMix.sc"))
+	assert.NoError(err)
+	assert.Equal("JackTripMix {}", string(content))
+	assert.Equal(2, requests)
+
+	// Case for a cached revision
+	_, err = cache.Get(ctx, revision, checksum)
+	assert.NoError(err)
+	_, err = cache.Get(ctx, revision, "")
+	assert.NoError(err)
+	assert.Equal(2, requests)
+
+	// Case for a missing revision
+	_, err = cache.Get(ctx, "fedcba9", "")
+	assert.Error(err)
+	files, _ := ioutil.ReadDir(cache.Dir)
+	assert.Len(files, 1)
+}
+
+func TestExtractTarGz(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	archive := getTestArchive(t, map[string]string{"top/../../escape.sc": "bad"})
+	assert.Error(extractTarGz(bytes.NewReader(archive), dir))
+	assert.Error(extractTarGz(bytes.NewReader([]byte("not gzip")), dir))
+}
@@ -0,0 +1,234 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// MQTTPort is the default port used by MQTT brokers
+	MQTTPort = 1883
+
+	// MQTTTLSPort is the default port used by MQTT brokers over TLS
+	MQTTTLSPort = 8883
+
+	// MQTTWriteTimeout is the maximum time to wait for a packet to be sent to a broker
+	MQTTWriteTimeout = 10 * time.Second
+
+	// MQTT 3.1.1 control packet types
+	mqttConnect    = 0x10
+	mqttConnAck    = 0x20
+	mqttPublish    = 0x30
+	mqttPingReq    = 0xc0
+	mqttDisconnect = 0xe0
+
+	// MQTT 3.1.1 connect flags
+	mqttCleanSession = 0x02
+	mqttWillFlag     = 0x04
+	mqttWillRetain   = 0x20
+	mqttPasswordFlag = 0x40
+	mqttUsernameFlag = 0x80
+)
+
+// MQTTOptions configures a connection to an MQTT broker
+type MQTTOptions struct {
+	// Address of the broker, with or without a port (ie. "broker.local:1883")
+	Broker string
+
+	// Client identifier, which must be unique for each connection to the broker
+	ClientID string
+
+	// Optional credentials
+	Username string
+	Password string
+
+	// If true, the connection to the broker is encrypted
+	TLS bool
+
+	// Maximum interval between packets before the broker considers the client disconnected
+	KeepAlive time.Duration
+
+	// Optional message retained by the broker on WillTopic when the client disconnects unexpectedly
+	WillTopic   string
+	WillPayload []byte
+}
+
+// MQTTClient publishes messages to an MQTT broker using MQTT 3.1.1 with QoS 0
+type MQTTClient struct {
+	conn  net.Conn
+	mutex sync.Mutex
+}
+
+// writeMQTTString writes a length-prefixed UTF-8 string
+func writeMQTTString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint16(len(s)))
+	buf.WriteString(s)
+}
+
+// encodeMQTTPacket prefixes a packet body with its fixed header
+func encodeMQTTPacket(header byte, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(header)
+	// remaining length is encoded 7 bits at a time, least significant first
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		buf.WriteByte(b)
+		if n == 0 {
+			break
+		}
+	}
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+// EncodeMQTTConnect encodes a CONNECT packet
+func EncodeMQTTConnect(opts MQTTOptions) []byte {
+	var flags byte = mqttCleanSession
+	if opts.WillTopic != "" {
+		flags |= mqttWillFlag | mqttWillRetain
+	}
+	if opts.Username != "" {
+		flags |= mqttUsernameFlag
+		if opts.Password != "" {
+			flags |= mqttPasswordFlag
+		}
+	}
+
+	var body bytes.Buffer
+	writeMQTTString(&body, "MQTT")
+	body.WriteByte(4) // protocol level 3.1.1
+	body.WriteByte(flags)
+	binary.Write(&body, binary.BigEndian, uint16(opts.KeepAlive/time.Second))
+	writeMQTTString(&body, opts.ClientID)
+	if opts.WillTopic != "" {
+		writeMQTTString(&body, opts.WillTopic)
+		writeMQTTString(&body, string(opts.WillPayload))
+	}
+	if flags&mqttUsernameFlag != 0 {
+		writeMQTTString(&body, opts.Username)
+	}
+	if flags&mqttPasswordFlag != 0 {
+		writeMQTTString(&body, opts.Password)
+	}
+	return encodeMQTTPacket(mqttConnect, body.Bytes())
+}
+
+// EncodeMQTTPublish encodes a PUBLISH packet with QoS 0
+func EncodeMQTTPublish(topic string, payload []byte, retain bool) []byte {
+	var header byte = mqttPublish
+	if retain {
+		header |= 0x01
+	}
+	var body bytes.Buffer
+	writeMQTTString(&body, topic)
+	body.Write(payload)
+	return encodeMQTTPacket(header, body.Bytes())
+}
+
+// getMQTTAddress returns the address of a broker, adding the default port if none was provided
+func getMQTTAddress(opts MQTTOptions) string {
+	if _, _, err := net.SplitHostPort(opts.Broker); err == nil {
+		return opts.Broker
+	}
+	if opts.TLS {
+		return net.JoinHostPort(opts.Broker, fmt.Sprintf("%d", MQTTTLSPort))
+	}
+	return net.JoinHostPort(opts.Broker, fmt.Sprintf("%d", MQTTPort))
+}
+
+// DialMQTT connects to an MQTT broker and waits for it to accept the connection
+func DialMQTT(ctx context.Context, opts MQTTOptions) (*MQTTClient, error) {
+	address := getMQTTAddress(opts)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TLS {
+		host, _, _ := net.SplitHostPort(address)
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	c := &MQTTClient{conn: conn}
+	if err := c.write(EncodeMQTTConnect(opts)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// CONNACK is always 4 bytes: header, remaining length, flags and return code
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+	}
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	if ack[0] != mqttConnAck || ack[1] != 2 {
+		conn.Close()
+		return nil, errors.New("unexpected response from MQTT broker")
+	}
+	if ack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("MQTT broker refused connection: return code %d", ack[3])
+	}
+	return c, nil
+}
+
+// write sends a packet to the broker
+func (c *MQTTClient) write(packet []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(MQTTWriteTimeout))
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// Publish sends a message to a topic; retained messages are delivered to future subscribers
+func (c *MQTTClient) Publish(topic string, payload []byte, retain bool) error {
+	return c.write(EncodeMQTTPublish(topic, payload, retain))
+}
+
+// Ping lets the broker know that the client is still connected
+func (c *MQTTClient) Ping() error {
+	return c.write([]byte{mqttPingReq, 0})
+}
+
+// Close disconnects from the broker; the will message is not published after a clean disconnect
+func (c *MQTTClient) Close() error {
+	c.write([]byte{mqttDisconnect, 0})
+	return c.conn.Close()
+}
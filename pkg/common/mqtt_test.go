@@ -0,0 +1,100 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeMQTTConnect(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for anonymous client
+	result := EncodeMQTTConnect(MQTTOptions{ClientID: "abc", KeepAlive: time.Minute})
+	assert.Equal([]byte("\x10\x0f\x00\x04MQTT\x04\x02\x00\x3c\x00\x03abc"), result)
+
+	// Case for client with credentials and a will message
+	result = EncodeMQTTConnect(MQTTOptions{ClientID: "a", Username: "u", Password: "p", WillTopic: "t", WillPayload: []byte("x")})
+	assert.Equal([]byte("\x10\x19\x00\x04MQTT\x04\xe6\x00\x00\x00\x01a\x00\x01t\x00\x01x\x00\x01u\x00\x01p"), result)
+}
+
+func TestEncodeMQTTPublish(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal([]byte("\x30\x07\x00\x01tabcd"), EncodeMQTTPublish("t", []byte("abcd"), false))
+	assert.Equal([]byte("\x31\x03\x00\x01t"), EncodeMQTTPublish("t", nil, true))
+
+	// remaining length uses multiple bytes for larger packets
+	result := EncodeMQTTPublish("t", bytes.Repeat([]byte("x"), 200), false)
+	assert.Equal([]byte{0x30, 0xcb, 0x01}, result[:3])
+	assert.Equal(206, len(result))
+}
+
+func TestGetMQTTAddress(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("broker.local:1883", getMQTTAddress(MQTTOptions{Broker: "broker.local"}))
+	assert.Equal("broker.local:8883", getMQTTAddress(MQTTOptions{Broker: "broker.local", TLS: true}))
+	assert.Equal("broker.local:1000", getMQTTAddress(MQTTOptions{Broker: "broker.local:1000", TLS: true}))
+}
+
+func TestMQTTClient(t *testing.T) {
+	assert := assert.New(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer listener.Close()
+
+	// fake broker accepts the connection, then records everything it receives
+	received := make(chan []byte)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		connect := make([]byte, len(EncodeMQTTConnect(MQTTOptions{ClientID: "device"})))
+		io.ReadFull(conn, connect)
+		conn.Write([]byte{mqttConnAck, 2, 0, 0})
+		rest, _ := io.ReadAll(conn)
+		received <- rest
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := DialMQTT(ctx, MQTTOptions{Broker: listener.Addr().String(), ClientID: "device"})
+	assert.Nil(err)
+	assert.Nil(c.Publish("a/b", []byte("on"), true))
+	assert.Nil(c.Close())
+	assert.Equal([]byte("\x31\x07\x00\x03a/bon\xe0\x00"), <-received)
+
+	// Case for refused connection
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte{mqttConnAck, 2, 0, 5})
+	}()
+	_, err = DialMQTT(ctx, MQTTOptions{Broker: listener.Addr().String(), ClientID: "device"})
+	assert.NotNil(err)
+	assert.True(strings.Contains(err.Error(), "return code 5"))
+}
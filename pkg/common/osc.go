@@ -0,0 +1,135 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+// SuperColliderOSCPort is the default port that SuperCollider's language interpreter listens on for OSC messages
+const SuperColliderOSCPort = 57120
+
+// writeOSCString writes a null-terminated string padded to a multiple of 4 bytes
+func writeOSCString(buf *bytes.Buffer, s string) {
+	buf.WriteString(s)
+	buf.WriteByte(0)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+// EncodeOSCMessage encodes an OSC 1.0 message; supported argument types are int32, int, float32, float64 and string
+func EncodeOSCMessage(address string, args ...interface{}) ([]byte, error) {
+	if len(address) == 0 || address[0] != '/' {
+		return nil, fmt.Errorf("invalid OSC address: %s", address)
+	}
+
+	typeTags := ","
+	var argBuf bytes.Buffer
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case int32:
+			typeTags += "i"
+			binary.Write(&argBuf, binary.BigEndian, v)
+		case int:
+			typeTags += "i"
+			binary.Write(&argBuf, binary.BigEndian, int32(v))
+		case float32:
+			typeTags += "f"
+			binary.Write(&argBuf, binary.BigEndian, math.Float32bits(v))
+		case float64:
+			typeTags += "f"
+			binary.Write(&argBuf, binary.BigEndian, math.Float32bits(float32(v)))
+		case string:
+			typeTags += "s"
+			writeOSCString(&argBuf, v)
+		default:
+			return nil, fmt.Errorf("unsupported OSC argument type: %T", arg)
+		}
+	}
+
+	var buf bytes.Buffer
+	writeOSCString(&buf, address)
+	writeOSCString(&buf, typeTags)
+	buf.Write(argBuf.Bytes())
+	return buf.Bytes(), nil
+}
+
+// DecodeOSCAddress returns the address of an OSC message
+func DecodeOSCAddress(packet []byte) (string, error) {
+	end := bytes.IndexByte(packet, 0)
+	if end < 1 || packet[0] != '/' {
+		return "", fmt.Errorf("invalid OSC message")
+	}
+	return string(packet[:end]), nil
+}
+
+// PingSCSynth sends a /status request to a SuperCollider server and waits for its /status.reply
+func PingSCSynth(address string, timeout time.Duration) error {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	request, _ := EncodeOSCMessage("/status")
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return err
+		}
+		// ignore any other messages that the server may be sending
+		if reply, err := DecodeOSCAddress(buf[:n]); err == nil && reply == "/status.reply" {
+			return nil
+		}
+	}
+}
+
+// OSCClient sends OSC messages to a running audio server, ie. to update mixer parameters without a restart
+type OSCClient struct {
+	// Address of the OSC server, formatted as host:port
+	Address string
+}
+
+// NewOSCClient constructs a new instance of OSCClient
+func NewOSCClient(host string, port int) *OSCClient {
+	return &OSCClient{Address: net.JoinHostPort(host, fmt.Sprintf("%d", port))}
+}
+
+// Send encodes and sends a single OSC message
+func (c *OSCClient) Send(address string, args ...interface{}) error {
+	packet, err := EncodeOSCMessage(address, args...)
+	if err != nil {
+		return err
+	}
+	conn, err := net.Dial("udp", c.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(packet)
+	return err
+}
@@ -0,0 +1,105 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeOSCMessage(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for message without arguments
+	result, err := EncodeOSCMessage("/ping")
+	assert.Nil(err)
+	assert.Equal([]byte("/ping\x00\x00\x00,\x00\x00\x00"), result)
+
+	// Case for message with mixed arguments
+	result, err = EncodeOSCMessage("/mix", "abc", int32(1), float32(0.5))
+	assert.Nil(err)
+	expected := []byte("/mix\x00\x00\x00\x00,sif\x00\x00\x00\x00abc\x00\x00\x00\x00\x01\x3f\x00\x00\x00")
+	assert.Equal(expected, result)
+	assert.Equal(0, len(result)%4)
+
+	// Case for invalid address
+	_, err = EncodeOSCMessage("mix")
+	assert.NotNil(err)
+
+	// Case for unsupported argument
+	_, err = EncodeOSCMessage("/mix", true)
+	assert.NotNil(err)
+}
+
+func TestDecodeOSCAddress(t *testing.T) {
+	assert := assert.New(t)
+	packet, _ := EncodeOSCMessage("/status.reply", 1)
+	result, err := DecodeOSCAddress(packet)
+	assert.Nil(err)
+	assert.Equal("/status.reply", result)
+
+	_, err = DecodeOSCAddress([]byte("status"))
+	assert.NotNil(err)
+	_, err = DecodeOSCAddress([]byte{})
+	assert.NotNil(err)
+}
+
+func TestPingSCSynth(t *testing.T) {
+	assert := assert.New(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer conn.Close()
+
+	// Case for a responsive server
+	go func() {
+		buf := make([]byte, 1024)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if address, _ := DecodeOSCAddress(buf[:n]); address == "/status" {
+			reply, _ := EncodeOSCMessage("/status.reply", 1)
+			conn.WriteTo(reply, addr)
+		}
+	}()
+	assert.Nil(PingSCSynth(conn.LocalAddr().String(), time.Second))
+
+	// Case for an unresponsive server
+	assert.NotNil(PingSCSynth(conn.LocalAddr().String(), 100*time.Millisecond))
+}
+
+func TestOSCClient(t *testing.T) {
+	assert := assert.New(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(err)
+	defer conn.Close()
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	c := NewOSCClient("127.0.0.1", port)
+	assert.Nil(c.Send("/mixer/gain", 2, float32(0.5)))
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.Nil(err)
+	expected, _ := EncodeOSCMessage("/mixer/gain", 2, float32(0.5))
+	assert.Equal(expected, buf[:n])
+
+	// Case for invalid message
+	assert.NotNil(c.Send("mixer"))
+}
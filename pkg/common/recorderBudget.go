@@ -0,0 +1,218 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RecorderSliceName is the systemd slice that the recorder and its encoders are placed in
+	RecorderSliceName = "jacktrip-recorder.slice"
+
+	// RecorderDropInName is the name of the drop-in file containing recorder CPU settings
+	RecorderDropInName = "jacktrip-recorder.conf"
+
+	// PathToRecorderCPUStat is the cgroup v2 CPU statistics file of the recorder slice
+	PathToRecorderCPUStat = "/sys/fs/cgroup/jacktrip.slice/jacktrip-recorder.slice/cpu.stat"
+
+	// RecorderThrottleThreshold is the fraction of time the recorder may be throttled before it is over budget
+	RecorderThrottleThreshold = 0.05
+
+	// RecorderShedAfter is the number of consecutive samples over budget before an HLS variant is shed
+	RecorderShedAfter = 3
+
+	// RecorderBudgetInterval is the time between samples of the recorder's CPU statistics
+	RecorderBudgetInterval = 10 * time.Second
+)
+
+// HLSVariant is one rendition of an HLS broadcast
+type HLSVariant struct {
+	// Name used for the variant playlist (ie. "high")
+	Name string
+
+	// Audio bitrate in kbps
+	Bitrate int
+}
+
+// DefaultHLSVariants are the renditions encoded for HLS broadcasts, from highest to lowest bitrate
+var DefaultHLSVariants = []HLSVariant{
+	{Name: "high", Bitrate: 256},
+	{Name: "medium", Bitrate: 128},
+	{Name: "low", Bitrate: 64},
+}
+
+// GetRecorderDropIn returns a systemd drop-in that places a recorder service in its own slice, with a lower
+// priority than the mixer and an optional CPU quota
+func GetRecorderDropIn(cpuQuota int) string {
+	lines := []string{
+		"[Service]",
+		fmt.Sprintf("Slice=%s", RecorderSliceName),
+		"Nice=10",
+		"CPUWeight=20",
+	}
+	if cpuQuota > 0 {
+		lines = append(lines, fmt.Sprintf("CPUQuota=%d%%", cpuQuota))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// WriteRecorderDropIn writes recorder CPU settings for a systemd service; systemd must be reloaded for them to apply
+func WriteRecorderDropIn(serviceName string, cpuQuota int) error {
+	dir := fmt.Sprintf("%s/%s.d", PathToSystemdDropIns, serviceName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fmt.Sprintf("%s/%s", dir, RecorderDropInName), []byte(GetRecorderDropIn(cpuQuota)), 0644)
+}
+
+// CPUStat holds the CPU statistics of a cgroup
+type CPUStat struct {
+	// Total CPU time used, in microseconds
+	UsageUsec uint64
+
+	// Total time processes were throttled by the CPU quota, in microseconds
+	ThrottledUsec uint64
+}
+
+// ParseCPUStat parses the contents of a cgroup v2 cpu.stat file
+func ParseCPUStat(rawBytes []byte) (CPUStat, error) {
+	var stat CPUStat
+	var found bool
+	scanner := bufio.NewScanner(bytes.NewReader(rawBytes))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return stat, fmt.Errorf("invalid value for %s: %w", fields[0], err)
+		}
+		switch fields[0] {
+		case "usage_usec":
+			stat.UsageUsec, found = value, true
+		case "throttled_usec":
+			stat.ThrottledUsec = value
+		}
+	}
+	if !found {
+		return stat, fmt.Errorf("missing usage_usec")
+	}
+	return stat, scanner.Err()
+}
+
+// ReadCPUStat reads the CPU statistics of a cgroup from its cpu.stat file
+func ReadCPUStat(path string) (CPUStat, error) {
+	rawBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return CPUStat{}, err
+	}
+	return ParseCPUStat(rawBytes)
+}
+
+// RecorderBudget keeps the recorder within its CPU budget by shedding HLS variants, highest bitrate first,
+// when it is throttled for several consecutive samples
+type RecorderBudget struct {
+	variants []HLSVariant
+	active   int
+	last     CPUStat
+	lastTime time.Time
+	over     int
+	mutex    sync.Mutex
+}
+
+// NewRecorderBudget constructs a new instance of RecorderBudget
+func NewRecorderBudget(variants []HLSVariant) *RecorderBudget {
+	return &RecorderBudget{variants: variants, active: len(variants)}
+}
+
+// Active returns the variants that should be encoded, from highest to lowest bitrate
+func (b *RecorderBudget) Active() []HLSVariant {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.variants[len(b.variants)-b.active:]
+}
+
+// Reset encodes all variants again, for example when the recorder is restarted
+func (b *RecorderBudget) Reset() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.active = len(b.variants)
+	b.lastTime = time.Time{}
+	b.over = 0
+}
+
+// Sample records the latest CPU statistics of the recorder, returning the variant to shed if it is over budget
+func (b *RecorderBudget) Sample(stat CPUStat, now time.Time) (HLSVariant, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	last, lastTime := b.last, b.lastTime
+	b.last, b.lastTime = stat, now
+	// the first sample, and counters that were reset by a restart, only establish a baseline
+	if lastTime.IsZero() || stat.ThrottledUsec < last.ThrottledUsec || !now.After(lastTime) {
+		return HLSVariant{}, false
+	}
+
+	throttled := float64(stat.ThrottledUsec-last.ThrottledUsec) / float64(now.Sub(lastTime).Microseconds())
+	if throttled <= RecorderThrottleThreshold {
+		b.over = 0
+		return HLSVariant{}, false
+	}
+	b.over++
+	// always keep at least one variant
+	if b.over < RecorderShedAfter || b.active <= 1 {
+		return HLSVariant{}, false
+	}
+	b.over = 0
+	shed := b.variants[len(b.variants)-b.active]
+	b.active--
+	return shed, true
+}
+
+// Run samples the CPU statistics in a cpu.stat file until the context is cancelled, calling onShed
+// whenever a variant should no longer be encoded
+func (b *RecorderBudget) Run(ctx context.Context, wg *sync.WaitGroup, path string, onShed func(HLSVariant)) {
+	defer wg.Done()
+	ticker := time.NewTicker(RecorderBudgetInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			stat, err := ReadCPUStat(path)
+			if err != nil {
+				continue
+			}
+			if variant, ok := b.Sample(stat, now); ok {
+				onShed(variant)
+			}
+		}
+	}
+}
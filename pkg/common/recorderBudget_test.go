@@ -0,0 +1,107 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetRecorderDropIn(t *testing.T) {
+	assert := assert.New(t)
+	result := GetRecorderDropIn(0)
+	assert.Equal("[Service]\nSlice=jacktrip-recorder.slice\nNice=10\nCPUWeight=20\n", result)
+	assert.Contains(GetRecorderDropIn(80), "CPUQuota=80%\n")
+}
+
+func TestParseCPUStat(t *testing.T) {
+	assert := assert.New(t)
+	stat, err := ParseCPUStat([]byte("usage_usec 5000000\nuser_usec 4000000\nsystem_usec 1000000\nnr_periods 100\nnr_throttled 12\nthrottled_usec 250000\n"))
+	assert.NoError(err)
+	assert.Equal(CPUStat{UsageUsec: 5000000, ThrottledUsec: 250000}, stat)
+
+	// Case for cgroups without a CPU quota
+	stat, err = ParseCPUStat([]byte("usage_usec 12\nuser_usec 10\nsystem_usec 2\n"))
+	assert.NoError(err)
+	assert.Equal(CPUStat{UsageUsec: 12}, stat)
+
+	_, err = ParseCPUStat([]byte("user_usec 10\n"))
+	assert.Error(err)
+	_, err = ParseCPUStat([]byte("usage_usec abc\n"))
+	assert.Error(err)
+
+	path := filepath.Join(t.TempDir(), "cpu.stat")
+	assert.NoError(ioutil.WriteFile(path, []byte("usage_usec 7\n"), 0644))
+	stat, err = ReadCPUStat(path)
+	assert.NoError(err)
+	assert.Equal(uint64(7), stat.UsageUsec)
+}
+
+func TestRecorderBudget(t *testing.T) {
+	assert := assert.New(t)
+	budget := NewRecorderBudget(DefaultHLSVariants)
+	assert.Equal(DefaultHLSVariants, budget.Active())
+	now := time.Now()
+	var stat CPUStat
+
+	// sample adds the given throttled time over a 10 second interval
+	sample := func(throttled time.Duration) (HLSVariant, bool) {
+		now = now.Add(10 * time.Second)
+		stat.ThrottledUsec += uint64(throttled.Microseconds())
+		return budget.Sample(stat, now)
+	}
+
+	// Case for the baseline sample
+	_, shed := sample(0)
+	assert.False(shed)
+
+	// Case for brief throttling, which is tolerated
+	for i := 0; i < RecorderShedAfter-1; i++ {
+		_, shed = sample(time.Second)
+		assert.False(shed)
+	}
+	_, shed = sample(0)
+	assert.False(shed)
+
+	// Case for sustained throttling, which sheds the highest bitrate first
+	for i := 0; i < RecorderShedAfter-1; i++ {
+		_, shed = sample(time.Second)
+		assert.False(shed)
+	}
+	variant, shed := sample(time.Second)
+	assert.True(shed)
+	assert.Equal("high", variant.Name)
+	assert.Equal(DefaultHLSVariants[1:], budget.Active())
+
+	// Case for the last variant, which is never shed
+	for i := 0; i < 3*RecorderShedAfter; i++ {
+		sample(time.Second)
+	}
+	assert.Equal(DefaultHLSVariants[2:], budget.Active())
+
+	// Case for counters reset by a restart, and resetting the budget
+	stat.ThrottledUsec = 0
+	_, shed = sample(0)
+	assert.False(shed)
+	budget.Reset()
+	assert.Equal(DefaultHLSVariants, budget.Active())
+}
@@ -0,0 +1,138 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+// clientPortRegexp matches JACK ports registered by JackTrip hub server for each client, named using remote names
+var clientPortRegexp = regexp.MustCompile(`^(.+):(send|receive)_(\d+)$`)
+
+// excludedRosterClients are JACK clients with JackTrip-like ports that are not musicians
+var excludedRosterClients = map[string]bool{
+	"hubserver": true,
+	"system":    true,
+}
+
+// RosterClient describes a client connected to an audio server
+type RosterClient struct {
+	// Remote name of the client
+	Name string `json:"name"`
+
+	// Number of audio channels sent by the client
+	Channels int `json:"channels"`
+
+	// Timestamp when the client was first seen
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// ClientRoster keeps track of the clients connected to an audio server
+type ClientRoster struct {
+	clients map[string]*RosterClient
+	mutex   sync.Mutex
+}
+
+// NewClientRoster constructs a new instance of ClientRoster
+func NewClientRoster() *ClientRoster {
+	return &ClientRoster{clients: map[string]*RosterClient{}}
+}
+
+// getClientChannels returns the number of channels for each client found in a list of JACK port names
+func getClientChannels(portNames []string) map[string]int {
+	channels := map[string]int{}
+	for _, name := range portNames {
+		match := clientPortRegexp.FindStringSubmatch(name)
+		if len(match) != 4 || excludedRosterClients[match[1]] {
+			continue
+		}
+		channel, err := strconv.Atoi(match[3])
+		if err != nil {
+			continue
+		}
+		if match[2] == "receive" {
+			channels[match[1]] = Max(channels[match[1]], channel)
+		} else if _, ok := channels[match[1]]; !ok {
+			channels[match[1]] = 0
+		}
+	}
+	return channels
+}
+
+// Update synchronizes the roster with the current JACK ports, returning join and leave events
+func (r *ClientRoster) Update(portNames []string, now time.Time) []client.ClientEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var joined, left []string
+	active := getClientChannels(portNames)
+	for name, channels := range active {
+		if c, ok := r.clients[name]; ok {
+			c.Channels = channels
+			continue
+		}
+		r.clients[name] = &RosterClient{Name: name, Channels: channels, ConnectedAt: now}
+		joined = append(joined, name)
+	}
+	for name := range r.clients {
+		if _, ok := active[name]; !ok {
+			delete(r.clients, name)
+			left = append(left, name)
+		}
+	}
+
+	// sort for consistent event ordering
+	sort.Strings(joined)
+	sort.Strings(left)
+	var events []client.ClientEvent
+	for _, name := range left {
+		events = append(events, client.ClientEvent{Type: client.ClientLeft, Name: name, Count: len(r.clients), Timestamp: now})
+	}
+	for _, name := range joined {
+		events = append(events, client.ClientEvent{Type: client.ClientJoined, Name: name, Count: len(r.clients), Timestamp: now})
+	}
+	return events
+}
+
+// Count returns the number of connected clients
+func (r *ClientRoster) Count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.clients)
+}
+
+// Clients returns a snapshot of the connected clients, ordered by connection time
+func (r *ClientRoster) Clients() []RosterClient {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	clients := []RosterClient{}
+	for _, c := range r.clients {
+		clients = append(clients, *c)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].ConnectedAt.Equal(clients[j].ConnectedAt) {
+			return clients[i].Name < clients[j].Name
+		}
+		return clients[i].ConnectedAt.Before(clients[j].ConnectedAt)
+	})
+	return clients
+}
@@ -0,0 +1,85 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetClientChannels(t *testing.T) {
+	assert := assert.New(t)
+	ports := []string{
+		"system:capture_1",
+		"hubserver:send_1",
+		"alice:receive_1",
+		"alice:receive_2",
+		"alice:send_1",
+		"alice:send_2",
+		"__ffff_10.0.0.1:receive_1",
+		"__ffff_10.0.0.1:send_1",
+		"SuperCollider:in_1",
+	}
+	result := getClientChannels(ports)
+	assert.Equal(map[string]int{"alice": 2, "__ffff_10.0.0.1": 1}, result)
+}
+
+func TestClientRosterUpdate(t *testing.T) {
+	assert := assert.New(t)
+	roster := NewClientRoster()
+	now := time.Date(2022, 4, 9, 13, 0, 0, 0, time.UTC)
+
+	// Case for clients joining
+	events := roster.Update([]string{"bob:receive_1", "alice:receive_1"}, now)
+	assert.Equal([]client.ClientEvent{
+		{Type: client.ClientJoined, Name: "alice", Count: 2, Timestamp: now},
+		{Type: client.ClientJoined, Name: "bob", Count: 2, Timestamp: now},
+	}, events)
+	assert.Equal(2, roster.Count())
+
+	// Case for no changes
+	events = roster.Update([]string{"bob:receive_1", "alice:receive_1"}, now)
+	assert.Equal(0, len(events))
+
+	// Case for a client leaving while another joins
+	later := now.Add(time.Minute)
+	events = roster.Update([]string{"carol:receive_1", "alice:receive_1"}, later)
+	assert.Equal([]client.ClientEvent{
+		{Type: client.ClientLeft, Name: "bob", Count: 2, Timestamp: later},
+		{Type: client.ClientJoined, Name: "carol", Count: 2, Timestamp: later},
+	}, events)
+
+	// Case for all clients leaving
+	events = roster.Update(nil, later)
+	assert.Equal(2, len(events))
+	assert.Equal(0, roster.Count())
+}
+
+func TestClientRosterClients(t *testing.T) {
+	assert := assert.New(t)
+	roster := NewClientRoster()
+	assert.Equal([]RosterClient{}, roster.Clients())
+
+	now := time.Date(2022, 4, 9, 13, 0, 0, 0, time.UTC)
+	roster.Update([]string{"bob:receive_1"}, now)
+	roster.Update([]string{"bob:receive_1", "alice:receive_1", "alice:receive_2"}, now.Add(time.Second))
+	assert.Equal([]RosterClient{
+		{Name: "bob", Channels: 1, ConnectedAt: now},
+		{Name: "alice", Channels: 2, ConnectedAt: now.Add(time.Second)},
+	}, roster.Clients())
+}
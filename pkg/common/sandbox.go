@@ -0,0 +1,113 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// PathToSystemdDropIns is the directory for runtime systemd unit drop-ins, which are cleared on reboot
+	PathToSystemdDropIns = "/run/systemd/system"
+
+	// SandboxDropInName is the name of the drop-in file containing sandbox settings
+	SandboxDropInName = "jacktrip-sandbox.conf"
+
+	// SandboxSliceName is the systemd slice that sandboxed services are placed in
+	SandboxSliceName = "jacktrip-mix.slice"
+
+	// SystemdRunPath is the path to systemd-run, used to run commands in transient sandboxed units
+	SystemdRunPath = "/usr/bin/systemd-run"
+)
+
+// SandboxLimits defines resource limits for services running untrusted code
+type SandboxLimits struct {
+	// Maximum CPU time as a percent of one core (ie. 200 for two cores); 0 means unlimited
+	CPUQuota int
+
+	// Maximum memory in megabytes; 0 means unlimited
+	MemoryMaxMB int
+
+	// If true, network access is denied (except loopback, used for OSC)
+	NoNetwork bool
+
+	// Directory bound read-only into the service, so that config files under /tmp stay visible with PrivateTmp
+	ConfigDir string
+}
+
+// getSandboxProperties returns the systemd unit properties that constrain a service with the given limits
+func getSandboxProperties(limits SandboxLimits) []string {
+	props := []string{
+		fmt.Sprintf("Slice=%s", SandboxSliceName),
+		"NoNewPrivileges=yes",
+		"PrivateTmp=yes",
+		"ProtectSystem=strict",
+		"ProtectHome=yes",
+	}
+	if limits.CPUQuota > 0 {
+		props = append(props, fmt.Sprintf("CPUQuota=%d%%", limits.CPUQuota))
+	}
+	if limits.MemoryMaxMB > 0 {
+		props = append(props, fmt.Sprintf("MemoryMax=%dM", limits.MemoryMaxMB))
+	}
+	if limits.NoNetwork {
+		props = append(props, "IPAddressDeny=any", "IPAddressAllow=localhost")
+	}
+	if limits.ConfigDir != "" {
+		props = append(props, fmt.Sprintf("BindReadOnlyPaths=%s", limits.ConfigDir))
+	}
+	return props
+}
+
+// GetSandboxDropIn returns a systemd drop-in that constrains a service with the given limits
+func GetSandboxDropIn(limits SandboxLimits) string {
+	lines := append([]string{"[Service]"}, getSandboxProperties(limits)...)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// GetSandboxRunArgs returns the systemd-run arguments for running a command in a transient unit with the
+// given limits; unlike long-running services, it also runs as an unprivileged dynamic user, gets a private
+// network namespace if network access is denied, and is stopped by systemd after timeout
+func GetSandboxRunArgs(unitName string, limits SandboxLimits, timeout time.Duration, env ...string) []string {
+	args := []string{"--unit=" + unitName, "--wait", "--pipe", "--collect", "--quiet"}
+	props := append(getSandboxProperties(limits), "DynamicUser=yes",
+		fmt.Sprintf("RuntimeMaxSec=%d", int(timeout.Seconds())))
+	if limits.NoNetwork {
+		props = append(props, "PrivateNetwork=yes")
+	}
+	for _, prop := range props {
+		args = append(args, "-p", prop)
+	}
+	for _, e := range env {
+		args = append(args, "-E", e)
+	}
+	return args
+}
+
+// WriteSandboxDropIn writes sandbox settings for a systemd service; systemd must be reloaded for them to apply
+func WriteSandboxDropIn(serviceName string, limits SandboxLimits) error {
+	dir := fmt.Sprintf("%s/%s.d", PathToSystemdDropIns, serviceName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fmt.Sprintf("%s/%s", dir, SandboxDropInName), []byte(GetSandboxDropIn(limits)), 0644)
+}
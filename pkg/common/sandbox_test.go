@@ -0,0 +1,65 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetSandboxDropIn(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for no limits
+	result := GetSandboxDropIn(SandboxLimits{})
+	assert.Contains(result, "[Service]\nSlice=jacktrip-mix.slice\n")
+	assert.NotContains(result, "CPUQuota")
+	assert.NotContains(result, "MemoryMax")
+	assert.NotContains(result, "IPAddressDeny")
+	assert.NotContains(result, "BindReadOnlyPaths")
+
+	// Case for all limits
+	result = GetSandboxDropIn(SandboxLimits{CPUQuota: 150, MemoryMaxMB: 512, NoNetwork: true, ConfigDir: "/tmp/default"})
+	assert.Contains(result, "CPUQuota=150%\n")
+	assert.Contains(result, "MemoryMax=512M\n")
+	assert.Contains(result, "IPAddressDeny=any\nIPAddressAllow=localhost\n")
+	assert.Contains(result, "BindReadOnlyPaths=/tmp/default\n")
+}
+
+func TestGetSandboxRunArgs(t *testing.T) {
+	assert := assert.New(t)
+
+	// Case for no limits
+	result := GetSandboxRunArgs("jacktrip-shadow-1", SandboxLimits{}, time.Minute)
+	assert.Equal([]string{"--unit=jacktrip-shadow-1", "--wait", "--pipe", "--collect", "--quiet"}, result[:5])
+	assert.Contains(result, "Slice=jacktrip-mix.slice")
+	assert.Contains(result, "DynamicUser=yes")
+	assert.Contains(result, "RuntimeMaxSec=60")
+	assert.NotContains(result, "PrivateNetwork=yes")
+
+	// Case for all limits and environment variables
+	result = GetSandboxRunArgs("jacktrip-shadow-1", SandboxLimits{CPUQuota: 150, MemoryMaxMB: 512, NoNetwork: true, ConfigDir: "/tmp/jacktrip-shadow-1"},
+		time.Minute, "HOME=/tmp")
+	assert.Contains(result, "CPUQuota=150%")
+	assert.Contains(result, "MemoryMax=512M")
+	assert.Contains(result, "PrivateNetwork=yes")
+	assert.Contains(result, "BindReadOnlyPaths=/tmp/jacktrip-shadow-1")
+	assert.Equal([]string{"-E", "HOME=/tmp"}, result[len(result)-2:])
+}
@@ -0,0 +1,229 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SCLangPath is the path to the SuperCollider language interpreter
+	SCLangPath = "/usr/bin/sclang"
+
+	// SCLangValidationTimeout is the maximum time to wait for sclang to validate code
+	SCLangValidationTimeout = 30 * time.Second
+
+	// JackdPath is the path to the JACK audio server, used to run a dummy server for shadow tests
+	JackdPath = "/usr/bin/jackd"
+
+	// ShadowJackServerName is the name of the dummy JACK server that new mix code is tested against
+	ShadowJackServerName = "jacktrip-shadow"
+
+	// ShadowSCSynthPort is the UDP port of the scsynth instance booted for shadow tests
+	ShadowSCSynthPort = 57190
+
+	// SCLangShadowTimeout is the maximum time to wait for new mix code to boot on a shadow server
+	SCLangShadowTimeout = 60 * time.Second
+
+	// shadowShellScript starts a dummy JACK server, runs a sclang script against it and stops it again;
+	// its arguments are the paths to jackd, the server name, the sample rate, and the paths to sclang and the script
+	shadowShellScript = `"$1" -n "$2" -d dummy -r "$3" &
+jackd=$!
+sleep 1
+"$4" "$5"
+status=$?
+kill $jackd
+wait $jackd
+exit $status`
+
+	// sclangCodeFile is the name of the file that mix code is written to in a sandbox directory
+	sclangCodeFile = "mix.scd"
+
+	// sclangScriptFile is the name of the file that the script running mix code is written to in a sandbox directory
+	sclangScriptFile = "script.scd"
+
+	// SCLogLines is the number of recent log lines scanned for SuperCollider errors
+	SCLogLines = 200
+
+	// sclangValidationToken is printed by the validation script when code fails to compile
+	sclangValidationToken = "JACKTRIP_SYNTAX_ERROR"
+
+	// sclangValidationTemplate compiles a file of sclang code without running it
+	sclangValidationTemplate = `(
+var code = File.readAllString(%q);
+if (code.compile.isNil, { "%s".postln; 1.exit }, { 0.exit });
+)
+`
+
+	// sclangShadowToken is printed by the shadow script when code runs without errors
+	sclangShadowToken = "JACKTRIP_SHADOW_OK"
+
+	// sclangShadowTemplate boots a separate scsynth instance and runs a file of sclang code against it
+	sclangShadowTemplate = `(
+var code = File.readAllString(%q);
+Server.default = s = Server(\shadow, NetAddr("127.0.0.1", %d));
+s.waitForBoot({
+	var ok = try { code.interpret; true } { |error| error.reportError; false };
+	s.sync;
+	if (ok, { "%s".postln });
+	s.quit;
+	0.exit;
+}, onFailure: { "ERROR: shadow server failed to boot".postln; 1.exit });
+)
+`
+)
+
+// errSandboxTimeout is returned when a sandboxed command doesn't finish in time
+var errSandboxTimeout = errors.New("sandboxed command timed out")
+
+// sclangErrorRegexp matches the lines sclang prints when it encounters an error
+var sclangErrorRegexp = regexp.MustCompile(`^\s*(ERROR:|FAILURE IN SERVER|exception in|line \d+ char \d+|in file|\^\^)`)
+
+// ParseSCLangErrors returns the lines of sclang or scsynth output that describe errors
+func ParseSCLangErrors(output string) []string {
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		if sclangErrorRegexp.MatchString(line) {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return lines
+}
+
+// writeSCLangSandbox writes mix code and a script that runs it to a new temporary directory, which is
+// readable by the unprivileged user of a sandboxed unit; the script template is given the path to the code
+// followed by args. It returns the path to the directory.
+func writeSCLangSandbox(pattern, code, scriptTemplate string, args ...interface{}) (string, error) {
+	dir, err := ioutil.TempDir("", pattern)
+	if err != nil {
+		return "", err
+	}
+	codePath := filepath.Join(dir, sclangCodeFile)
+	script := fmt.Sprintf(scriptTemplate, append([]interface{}{codePath}, args...)...)
+	if err = os.Chmod(dir, 0755); err == nil {
+		if err = ioutil.WriteFile(codePath, []byte(code), 0644); err == nil {
+			err = ioutil.WriteFile(filepath.Join(dir, sclangScriptFile), []byte(script), 0644)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// runSCLangSandbox runs a command in a transient systemd unit that is named after and can only read the
+// sandbox directory, with the same limits as the mix services; it returns errSandboxTimeout if the command
+// did not finish in time
+func runSCLangSandbox(dir string, limits SandboxLimits, timeout time.Duration, env []string, command ...string) ([]byte, error) {
+	limits.ConfigDir = dir
+	env = append(env, "HOME=/tmp", "QT_QPA_PLATFORM=offscreen")
+	args := append(GetSandboxRunArgs(filepath.Base(dir), limits, timeout, env...), command...)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, SystemdRunPath, args...).CombinedOutput()
+	if ctx.Err() != nil {
+		return out, errSandboxTimeout
+	}
+	return out, err
+}
+
+// ValidateSCLang compiles sclang code using a dry-run interpreter in a sandbox, returning any syntax errors
+func ValidateSCLang(code string, limits SandboxLimits) error {
+	dir, err := writeSCLangSandbox("jacktrip-validate-", code, sclangValidationTemplate, sclangValidationToken)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	out, err := runSCLangSandbox(dir, limits, SCLangValidationTimeout, nil, SCLangPath, filepath.Join(dir, sclangScriptFile))
+	if err == errSandboxTimeout {
+		return errors.New("timed out validating sclang code")
+	}
+	if err != nil || strings.Contains(string(out), sclangValidationToken) {
+		lines := ParseSCLangErrors(string(out))
+		if len(lines) == 0 {
+			return errors.New("sclang code failed to compile")
+		}
+		return errors.New(strings.Join(lines, "\n"))
+	}
+	return nil
+}
+
+// ShadowTestSCLang validates sclang code, then runs it against a separate scsynth instance on a dummy
+// JACK server, so that code which fails to boot is caught before it replaces a running mixer. Both run
+// in a sandbox with the same limits as the mix services, since the code is untrusted.
+func ShadowTestSCLang(code string, sampleRate int, limits SandboxLimits) error {
+	if err := ValidateSCLang(code, limits); err != nil {
+		return err
+	}
+
+	dir, err := writeSCLangSandbox("jacktrip-shadow-", code, sclangShadowTemplate, ShadowSCSynthPort, sclangShadowToken)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// the dummy JACK server runs in the same unit, so that sclang can reach it from the private namespaces
+	env := []string{"JACK_DEFAULT_SERVER=" + ShadowJackServerName, "JACK_NO_START_SERVER=1"}
+	out, err := runSCLangSandbox(dir, limits, SCLangShadowTimeout, env, "/bin/sh", "-c", shadowShellScript, "sh",
+		JackdPath, ShadowJackServerName, strconv.Itoa(sampleRate), SCLangPath, filepath.Join(dir, sclangScriptFile))
+	if err == errSandboxTimeout {
+		return errors.New("timed out running sclang code on shadow server")
+	}
+	if err != nil || !strings.Contains(string(out), sclangShadowToken) {
+		lines := ParseSCLangErrors(string(out))
+		if len(lines) == 0 {
+			return errors.New("sclang code failed on shadow server")
+		}
+		return errors.New(strings.Join(lines, "\n"))
+	}
+	return nil
+}
+
+// lastLines returns up to the last n lines
+func lastLines(lines []string, n int) []string {
+	if len(lines) <= n {
+		return lines
+	}
+	return lines[len(lines)-n:]
+}
+
+// GetServiceErrors scans the recent journal of systemd services for SuperCollider errors, returning up to maxLines of the latest errors
+func GetServiceErrors(serviceNames []string, maxLines int) ([]string, error) {
+	args := []string{"--no-pager", "--output", "cat", "--lines", fmt.Sprintf("%d", SCLogLines)}
+	for _, name := range serviceNames {
+		args = append(args, "--unit", name)
+	}
+	out, err := exec.Command(JournalctlPath, args...).Output()
+	if err != nil {
+		return nil, err
+	}
+	return lastLines(ParseSCLangErrors(string(out)), maxLines), nil
+}
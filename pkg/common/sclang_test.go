@@ -0,0 +1,78 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSCLangErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	output := `compiling class library...
+ERROR: syntax error, unexpected ')', expecting end of file
+  in interpreted text
+  line 12 char 5:
+
+  SinOsc.ar(440));
+      ^^
+-----------------------------------
+ERROR: Command line parse failed
+sc3> `
+	result := ParseSCLangErrors(output)
+	assert.Equal([]string{
+		"ERROR: syntax error, unexpected ')', expecting end of file",
+		"line 12 char 5:",
+		"^^",
+		"ERROR: Command line parse failed",
+	}, result)
+
+	assert.Equal(0, len(ParseSCLangErrors("compiling class library...\nsc3> ")))
+}
+
+func TestLastLines(t *testing.T) {
+	assert := assert.New(t)
+	lines := []string{"a", "b", "c"}
+	assert.Equal(lines, lastLines(lines, 5))
+	assert.Equal(lines, lastLines(lines, 3))
+	assert.Equal([]string{"b", "c"}, lastLines(lines, 2))
+	assert.Equal(0, len(lastLines(nil, 2)))
+}
+
+func TestWriteSCLangSandbox(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := writeSCLangSandbox("jacktrip-test-", "SinOsc.ar(440)", sclangShadowTemplate, ShadowSCSynthPort, sclangShadowToken)
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	info, err := os.Stat(dir)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0755), info.Mode().Perm())
+	code, err := ioutil.ReadFile(filepath.Join(dir, sclangCodeFile))
+	assert.NoError(err)
+	assert.Equal("SinOsc.ar(440)", string(code))
+	script, err := ioutil.ReadFile(filepath.Join(dir, sclangScriptFile))
+	assert.NoError(err)
+	assert.Contains(string(script), filepath.Join(dir, sclangCodeFile))
+	assert.Contains(string(script), sclangShadowToken)
+}
@@ -0,0 +1,195 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SessionTrack describes a single recorded stem in a multitrack session
+type SessionTrack struct {
+	// Display name of the track (ie. the client's remote name)
+	Name string `json:"name"`
+
+	// Path to the audio file, relative to the session directory
+	File string `json:"file"`
+
+	// Offset of the first sample from the start of the session, in seconds
+	Offset float64 `json:"offset"`
+}
+
+// SessionMarker tags a position in a multitrack session, such as the start of a take or song
+type SessionMarker struct {
+	// Name of the take, song or section
+	Label string `json:"label"`
+
+	// Offset of the marker from the start of the session, in seconds
+	Offset float64 `json:"offset"`
+
+	// Timestamp when the marker was added
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// SessionManifest describes a multitrack session
+type SessionManifest struct {
+	// Name of the session
+	Name string `json:"name"`
+
+	// Sample rate of all tracks
+	SampleRate int `json:"sampleRate"`
+
+	// Timestamp when the session started
+	StartedAt time.Time `json:"startedAt"`
+
+	// Recorded tracks
+	Tracks []SessionTrack `json:"tracks"`
+
+	// Markers added while the session was recorded
+	Markers []SessionMarker `json:"markers,omitempty"`
+}
+
+// AddMarker adds a marker at the given time to a session, returning it
+func (m *SessionManifest) AddMarker(label string, at time.Time) SessionMarker {
+	offset := at.Sub(m.StartedAt).Seconds()
+	if offset < 0 {
+		offset = 0
+	}
+	marker := SessionMarker{Label: label, Offset: offset, Timestamp: at}
+	m.Markers = append(m.Markers, marker)
+	return marker
+}
+
+// AddTrack adds a track that started recording at the given time to a session, returning it
+func (m *SessionManifest) AddTrack(name, file string, at time.Time) SessionTrack {
+	offset := at.Sub(m.StartedAt).Seconds()
+	if offset < 0 {
+		offset = 0
+	}
+	track := SessionTrack{Name: name, File: file, Offset: offset}
+	m.Tracks = append(m.Tracks, track)
+	return track
+}
+
+// TrackMarkers returns the markers of a session that were added while a track was recording, with offsets
+// from the start of the track
+func (m *SessionManifest) TrackMarkers(track SessionTrack) []SessionMarker {
+	var markers []SessionMarker
+	for _, marker := range m.Markers {
+		if marker.Offset < track.Offset {
+			continue
+		}
+		marker.Offset -= track.Offset
+		markers = append(markers, marker)
+	}
+	return markers
+}
+
+// GetHLSMarkerTag returns an HLS playlist tag that places a marker on the program timeline
+func GetHLSMarkerTag(marker SessionMarker, index int) string {
+	return fmt.Sprintf("#EXT-X-DATERANGE:ID=\"marker-%d\",CLASS=\"com.jacktrip.marker\",START-DATE=\"%s\",X-LABEL=%q",
+		index, marker.Timestamp.UTC().Format("2006-01-02T15:04:05.000Z"), marker.Label)
+}
+
+// GetFLACMarkerComments returns Vorbis comments that describe markers as chapters (ie. "CHAPTER001=00:00:12.500")
+func GetFLACMarkerComments(markers []SessionMarker) []string {
+	var comments []string
+	for i, marker := range markers {
+		offset := time.Duration(marker.Offset * float64(time.Second))
+		hours, minutes := int(offset.Hours()), int(offset.Minutes())%60
+		seconds := offset.Seconds() - float64(hours*3600+minutes*60)
+		comments = append(comments,
+			fmt.Sprintf("CHAPTER%03d=%02d:%02d:%06.3f", i+1, hours, minutes, seconds),
+			fmt.Sprintf("CHAPTER%03dNAME=%s", i+1, marker.Label))
+	}
+	return comments
+}
+
+// GetReaperProject returns a Reaper project (.RPP) that imports every track of a session
+func GetReaperProject(manifest SessionManifest) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<REAPER_PROJECT 0.1 \"6.0\"\n  SAMPLERATE %d 0 0\n", manifest.SampleRate)
+	for _, track := range manifest.Tracks {
+		fmt.Fprintf(&b, "  <TRACK\n    NAME %q\n    <ITEM\n      POSITION %f\n      NAME %q\n", track.Name, track.Offset, track.Name)
+		fmt.Fprintf(&b, "      <SOURCE FLAC\n        FILE %q\n      >\n    >\n  >\n", track.File)
+	}
+	for i, marker := range manifest.Markers {
+		fmt.Fprintf(&b, "  MARKER %d %f %q 0\n", i+1, marker.Offset, marker.Label)
+	}
+	b.WriteString(">\n")
+	return b.String()
+}
+
+// addZipFile copies a file from disk into a zip archive
+func addZipFile(zw *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// ExportSession writes a zip archive containing session audio files, a JSON manifest and a Reaper project
+func ExportSession(w io.Writer, dir string, manifest SessionManifest) error {
+	zw := zip.NewWriter(w)
+
+	for _, track := range manifest.Tracks {
+		// only allow files within the session directory
+		name := filepath.Clean(track.File)
+		if filepath.IsAbs(name) || strings.HasPrefix(name, "..") {
+			return fmt.Errorf("invalid session track file: %s", track.File)
+		}
+		if err := addZipFile(zw, filepath.Join(dir, name), name); err != nil {
+			return err
+		}
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	mw, err := zw.Create("session.json")
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(manifestBytes); err != nil {
+		return err
+	}
+
+	rw, err := zw.Create("session.rpp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(rw, GetReaperProject(manifest)); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
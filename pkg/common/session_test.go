@@ -0,0 +1,116 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetReaperProject(t *testing.T) {
+	assert := assert.New(t)
+	manifest := SessionManifest{SampleRate: 48000, Tracks: []SessionTrack{{Name: "alice", File: "alice.flac", Offset: 1.5}}}
+	result := GetReaperProject(manifest)
+	assert.Contains(result, "SAMPLERATE 48000 0 0")
+	assert.Contains(result, `NAME "alice"`)
+	assert.Contains(result, "POSITION 1.500000")
+	assert.Contains(result, `FILE "alice.flac"`)
+}
+
+func TestSessionMarkers(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+	manifest := SessionManifest{SampleRate: 48000, StartedAt: start}
+
+	marker := manifest.AddMarker("Intro", start.Add(-time.Second))
+	assert.Equal(0.0, marker.Offset)
+	marker = manifest.AddMarker("Verse 2", start.Add(time.Hour+14*time.Minute+12500*time.Millisecond))
+	assert.Equal(4452.5, marker.Offset)
+	assert.Len(manifest.Markers, 2)
+
+	result := GetReaperProject(manifest)
+	assert.Contains(result, `MARKER 1 0.000000 "Intro" 0`)
+	assert.Contains(result, `MARKER 2 4452.500000 "Verse 2" 0`)
+
+	assert.Equal(`#EXT-X-DATERANGE:ID="marker-2",CLASS="com.jacktrip.marker",START-DATE="2022-05-01T21:14:12.500Z",X-LABEL="Verse 2"`,
+		GetHLSMarkerTag(marker, 2))
+
+	assert.Equal([]string{
+		"CHAPTER001=00:00:00.000",
+		"CHAPTER001NAME=Intro",
+		"CHAPTER002=01:14:12.500",
+		"CHAPTER002NAME=Verse 2",
+	}, GetFLACMarkerComments(manifest.Markers))
+}
+
+func TestSessionTracks(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Date(2022, 5, 1, 20, 0, 0, 0, time.UTC)
+	manifest := SessionManifest{StartedAt: start}
+	manifest.AddMarker("Intro", start.Add(5*time.Second))
+	manifest.AddMarker("Verse", start.Add(90*time.Second))
+	track := manifest.AddTrack("alice", "alice.flac", start.Add(time.Minute))
+	assert.Equal(60.0, track.Offset)
+	assert.Len(manifest.Tracks, 1)
+
+	markers := manifest.TrackMarkers(track)
+	assert.Len(markers, 1)
+	assert.Equal("Verse", markers[0].Label)
+	assert.Equal(30.0, markers[0].Offset)
+	assert.Equal(90.0, manifest.Markers[1].Offset)
+}
+
+func TestExportSession(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "jacktrip-session")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "alice.flac"), []byte("alice"), 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, "bob.flac"), []byte("bob"), 0644))
+
+	manifest := SessionManifest{
+		Name:       "rehearsal",
+		SampleRate: 48000,
+		Tracks:     []SessionTrack{{Name: "alice", File: "alice.flac"}, {Name: "bob", File: "bob.flac", Offset: 2}},
+	}
+	var buf bytes.Buffer
+	assert.Nil(ExportSession(&buf, dir, manifest))
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	assert.Nil(err)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.Equal([]string{"alice.flac", "bob.flac", "session.json", "session.rpp"}, names)
+
+	// Case for files outside of the session directory
+	manifest.Tracks = []SessionTrack{{Name: "evil", File: "../../etc/passwd"}}
+	assert.NotNil(ExportSession(&bytes.Buffer{}, dir, manifest))
+
+	// Case for missing files
+	manifest.Tracks = []SessionTrack{{Name: "carol", File: "carol.flac"}}
+	assert.NotNil(ExportSession(&bytes.Buffer{}, dir, manifest))
+}
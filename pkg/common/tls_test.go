@@ -0,0 +1,78 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateSelfSignedCert(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	certPEM, keyPEM, err := GenerateSelfSignedCert([]string{"jacktrip.local", "127.0.0.1"}, now)
+	assert.NoError(err)
+	assert.Contains(string(keyPEM), "PRIVATE KEY")
+
+	block, _ := pem.Decode(certPEM)
+	assert.NotNil(block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(err)
+	assert.Equal("jacktrip.local", cert.Subject.CommonName)
+	assert.Equal([]string{"jacktrip.local"}, cert.DNSNames)
+	assert.Len(cert.IPAddresses, 1)
+	assert.NoError(cert.VerifyHostname("jacktrip.local"))
+	assert.NoError(cert.VerifyHostname("127.0.0.1"))
+	assert.True(cert.NotAfter.After(now.Add(SelfSignedCertValidity - time.Minute)))
+}
+
+func TestLoadOrCreateSelfSignedCert(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "tls")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	certPath, keyPath := filepath.Join(dir, "tls", "cert.pem"), filepath.Join(dir, "tls", "key.pem")
+	hosts := []string{"jacktrip.local"}
+	now := time.Now()
+
+	// Case for creating a new certificate
+	cert, err := LoadOrCreateSelfSignedCert(certPath, keyPath, hosts, now)
+	assert.NoError(err)
+	info, err := os.Stat(keyPath)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	// Case for reusing a valid certificate
+	reused, err := LoadOrCreateSelfSignedCert(certPath, keyPath, hosts, now.Add(24*time.Hour))
+	assert.NoError(err)
+	assert.Equal(cert.Certificate[0], reused.Certificate[0])
+
+	// Case for renewing a certificate that is close to expiring
+	renewed, err := LoadOrCreateSelfSignedCert(certPath, keyPath, hosts, now.Add(SelfSignedCertValidity-SelfSignedCertRenewBefore))
+	assert.NoError(err)
+	assert.NotEqual(cert.Certificate[0], renewed.Certificate[0])
+
+	// Case for replacing an invalid certificate
+	assert.NoError(ioutil.WriteFile(certPath, []byte("garbage"), 0644))
+	_, err = LoadOrCreateSelfSignedCert(certPath, keyPath, hosts, now)
+	assert.NoError(err)
+}
@@ -0,0 +1,127 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// PathToProcStat is the path to kernel CPU statistics
+	PathToProcStat = "/proc/stat"
+
+	// PathToMeminfo is the path to kernel memory statistics
+	PathToMeminfo = "/proc/meminfo"
+)
+
+// cpuTimes are the cumulative busy and total CPU times reported by the kernel
+type cpuTimes struct {
+	busy  uint64
+	total uint64
+}
+
+// parseProcStat parses the aggregate CPU line from /proc/stat
+func parseProcStat(output string) (cpuTimes, error) {
+	var times cpuTimes
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return times, err
+			}
+			times.total += value
+			// idle and iowait are the 4th and 5th columns
+			if i != 3 && i != 4 {
+				times.busy += value
+			}
+		}
+		return times, nil
+	}
+	return times, errors.New("aggregate cpu line not found")
+}
+
+// parseMeminfo parses the memory usage percent from /proc/meminfo
+func parseMeminfo(output string) (float64, error) {
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = value
+	}
+	total, ok := values["MemTotal"]
+	available, ok2 := values["MemAvailable"]
+	if !ok || !ok2 || total == 0 {
+		return 0, errors.New("memory totals not found")
+	}
+	return float64(total-available) * 100 / float64(total), nil
+}
+
+// CPUSampler measures CPU usage between consecutive samples
+type CPUSampler struct {
+	last  cpuTimes
+	mutex sync.Mutex
+}
+
+// Sample returns the CPU usage percent since the last sample
+func (s *CPUSampler) Sample() (float64, error) {
+	rawBytes, err := ioutil.ReadFile(PathToProcStat)
+	if err != nil {
+		return 0, err
+	}
+	times, err := parseProcStat(string(rawBytes))
+	if err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	percent := getCPUPercent(s.last, times)
+	s.last = times
+	return percent, nil
+}
+
+// getCPUPercent returns the CPU usage percent between two samples
+func getCPUPercent(last, next cpuTimes) float64 {
+	if next.total <= last.total || next.busy < last.busy {
+		return 0
+	}
+	return float64(next.busy-last.busy) * 100 / float64(next.total-last.total)
+}
+
+// GetMemoryPercent returns the percent of memory in use
+func GetMemoryPercent() (float64, error) {
+	rawBytes, err := ioutil.ReadFile(PathToMeminfo)
+	if err != nil {
+		return 0, err
+	}
+	return parseMeminfo(string(rawBytes))
+}
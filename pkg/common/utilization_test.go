@@ -0,0 +1,55 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProcStat(t *testing.T) {
+	assert := assert.New(t)
+	output := `cpu  100 0 50 800 50 0 0 0 0 0
+cpu0 50 0 25 400 25 0 0 0 0 0
+intr 12345
+`
+	result, err := parseProcStat(output)
+	assert.Nil(err)
+	assert.Equal(cpuTimes{busy: 150, total: 1000}, result)
+
+	_, err = parseProcStat("intr 12345\n")
+	assert.NotNil(err)
+}
+
+func TestParseMeminfo(t *testing.T) {
+	assert := assert.New(t)
+	output := `MemTotal:        1000 kB
+MemFree:          100 kB
+MemAvailable:     250 kB
+`
+	result, err := parseMeminfo(output)
+	assert.Nil(err)
+	assert.Equal(75.0, result)
+
+	_, err = parseMeminfo("MemFree: 100 kB\n")
+	assert.NotNil(err)
+}
+
+func TestGetCPUPercent(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(25.0, getCPUPercent(cpuTimes{busy: 100, total: 400}, cpuTimes{busy: 200, total: 800}))
+	assert.Equal(0.0, getCPUPercent(cpuTimes{busy: 100, total: 400}, cpuTimes{busy: 100, total: 400}))
+}
@@ -15,9 +15,14 @@
 package common
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"io/ioutil"
 	"math"
 	"math/rand"
+	"os"
+	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx/types"
@@ -33,6 +38,13 @@ const (
 
 	// RetryBackoffMax sets the maximum wait duration between retry attempts
 	RetryBackoffMax = 10000 // milliseconds
+
+	// JournalctlPath is the path to the systemd journal reader
+	JournalctlPath = "/usr/bin/journalctl"
+
+	// AgentLogNamespace is the journal namespace that the agent's services log to, so that their logs can be
+	// purged without touching the rest of the system journal
+	AgentLogNamespace = "jacktrip"
 )
 
 func exponentialBackoffSleep(iteration int) {
@@ -140,3 +152,100 @@ func WaitForJackd() error {
 	}
 	return nil
 }
+
+// countLogLines returns the number of lines in log output that contain a token, ignoring case
+func countLogLines(output, token string) int {
+	count := 0
+	token = strings.ToLower(token)
+	for _, line := range strings.Split(output, "\n") {
+		if strings.Contains(strings.ToLower(line), token) {
+			count++
+		}
+	}
+	return count
+}
+
+// CountLogLines returns the number of lines logged by a systemd service since a given time that contain a token
+func CountLogLines(serviceName string, since time.Time, token string) (int, error) {
+	args := []string{"--no-pager", "--output", "cat", "--namespace", "+" + AgentLogNamespace, "--unit", serviceName, "--since", since.Format("2006-01-02 15:04:05")}
+	out, err := exec.Command(JournalctlPath, args...).Output()
+	if err != nil {
+		return 0, err
+	}
+	return countLogLines(string(out), token), nil
+}
+
+// journalCursorPrefix starts the last line printed by `journalctl --show-cursor`
+const journalCursorPrefix = "-- cursor: "
+
+// splitJournalCursor separates the cursor printed by `journalctl --show-cursor` from the log lines before it
+func splitJournalCursor(output string) (string, string) {
+	trimmed := strings.TrimRight(output, "\n")
+	idx := strings.LastIndex(trimmed, "\n")
+	last := trimmed[idx+1:]
+	if !strings.HasPrefix(last, journalCursorPrefix) {
+		return output, ""
+	}
+	return trimmed[:idx+1], strings.TrimPrefix(last, journalCursorPrefix)
+}
+
+// LogCounter counts the lines logged by a systemd service that contain a token, only reading
+// lines that were logged since it last counted
+type LogCounter struct {
+	ServiceName string
+	Token       string
+	since       time.Time
+	cursor      string
+	total       int
+}
+
+// NewLogCounter constructs a new instance of LogCounter, which counts lines logged after a given time
+func NewLogCounter(serviceName, token string, since time.Time) *LogCounter {
+	return &LogCounter{ServiceName: serviceName, Token: token, since: since}
+}
+
+// Count returns the total number of matching lines logged since the counter was created
+func (c *LogCounter) Count() (int, error) {
+	args := []string{"--no-pager", "--output", "cat", "--namespace", "+" + AgentLogNamespace, "--unit", c.ServiceName, "--show-cursor"}
+	if c.cursor != "" {
+		args = append(args, "--after-cursor", c.cursor)
+	} else {
+		args = append(args, "--since", c.since.Format("2006-01-02 15:04:05"))
+	}
+	out, err := exec.Command(JournalctlPath, args...).Output()
+	if err != nil {
+		return c.total, err
+	}
+	lines, cursor := splitJournalCursor(string(out))
+	c.total += countLogLines(lines, c.Token)
+	if cursor != "" {
+		c.cursor = cursor
+	}
+	return c.total, nil
+}
+
+// NewXrunCounter constructs a LogCounter for the xruns logged by a JACK systemd service after a given time
+func NewXrunCounter(serviceName string, since time.Time) *LogCounter {
+	return NewLogCounter(serviceName, "xrun", since)
+}
+
+// IsFileUnchanged checks if a file already has the given content, by comparing content hashes
+func IsFileUnchanged(path string, content []byte) bool {
+	current, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return sha256.Sum256(current) == sha256.Sum256(content)
+}
+
+// WriteFileIfChanged writes a file only if its content differs, to avoid wearing out SD cards,
+// and returns true if the file was written
+func WriteFileIfChanged(path string, content []byte, perm os.FileMode) (bool, error) {
+	if IsFileUnchanged(path, content) {
+		return false, nil
+	}
+	if err := ioutil.WriteFile(path, content, perm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -15,6 +15,8 @@
 package common
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"github.com/jmoiron/sqlx/types"
@@ -57,3 +59,53 @@ func TestVolumeString(t *testing.T) {
 	assert.Equal("30%", VolumeString(30, no))
 	assert.Equal("100%", VolumeString(100, no))
 }
+
+func TestCountXruns(t *testing.T) {
+	assert := assert.New(t)
+	output := "Jack: JackEngine::XRun: client = hubserver\nsome other line\nJack: XRun detected\n"
+	assert.Equal(2, countLogLines(output, "xrun"))
+	assert.Equal(0, countLogLines("", "xrun"))
+	assert.Equal(1, countLogLines("UDP Waiting too long (more than 30ms) for 127.0.0.1\n", "waiting too long"))
+}
+
+func TestSplitJournalCursor(t *testing.T) {
+	assert := assert.New(t)
+	lines, cursor := splitJournalCursor("Jack: XRun detected\n-- cursor: s=abc;i=2\n")
+	assert.Equal("Jack: XRun detected\n", lines)
+	assert.Equal("s=abc;i=2", cursor)
+
+	// Case for no new lines
+	lines, cursor = splitJournalCursor("-- cursor: s=abc;i=3\n")
+	assert.Equal("", lines)
+	assert.Equal("s=abc;i=3", cursor)
+
+	// Case for output without a cursor
+	lines, cursor = splitJournalCursor("Jack: XRun detected\n")
+	assert.Equal("Jack: XRun detected\n", lines)
+	assert.Equal("", cursor)
+	lines, cursor = splitJournalCursor("")
+	assert.Equal("", lines)
+	assert.Equal("", cursor)
+}
+
+func TestWriteFileIfChanged(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "jack")
+	assert.False(IsFileUnchanged(path, []byte("a")))
+
+	changed, err := WriteFileIfChanged(path, []byte("a"), 0644)
+	assert.Nil(err)
+	assert.True(changed)
+	assert.True(IsFileUnchanged(path, []byte("a")))
+
+	// Case for unchanged content, which must not be rewritten
+	changed, err = WriteFileIfChanged(path, []byte("a"), 0644)
+	assert.Nil(err)
+	assert.False(changed)
+
+	changed, err = WriteFileIfChanged(path, []byte("b"), 0644)
+	assert.Nil(err)
+	assert.True(changed)
+	rawBytes, _ := ioutil.ReadFile(path)
+	assert.Equal("b", string(rawBytes))
+}
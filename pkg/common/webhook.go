@@ -0,0 +1,268 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// WebhookSignatureHeader is the HTTP header carrying the HMAC-SHA256 signature of a webhook payload
+	WebhookSignatureHeader = "X-JackTrip-Signature"
+
+	// WebhookTimestampHeader is the HTTP header carrying the unix time a webhook was sent
+	WebhookTimestampHeader = "X-JackTrip-Timestamp"
+
+	// WebhookEventHeader is the HTTP header carrying the type of a webhook event
+	WebhookEventHeader = "X-JackTrip-Event"
+
+	// WebhookTimeout is the maximum time spent delivering a webhook to one URL
+	WebhookTimeout = 10 * time.Second
+
+	// WebhookQueueSize is the maximum number of events waiting to be delivered
+	WebhookQueueSize = 100
+)
+
+// WebhookEventType is used to determine the type of a webhook event
+type WebhookEventType string
+
+const (
+	// WebhookBroadcastStarted means the studio started broadcasting to listeners
+	WebhookBroadcastStarted WebhookEventType = "broadcast.started"
+
+	// WebhookBroadcastStopped means the studio stopped broadcasting to listeners
+	WebhookBroadcastStopped WebhookEventType = "broadcast.stopped"
+
+	// WebhookRecordingSaved means a recording was written to disk
+	WebhookRecordingSaved WebhookEventType = "recording.saved"
+
+	// WebhookClientJoined means a client connected to the audio server
+	WebhookClientJoined WebhookEventType = "client.joined"
+
+	// WebhookClientLeft means a client disconnected from the audio server
+	WebhookClientLeft WebhookEventType = "client.left"
+
+	// WebhookError means the server entered an error state
+	WebhookError WebhookEventType = "error"
+)
+
+// WebhookEvent is the payload posted to webhook URLs
+type WebhookEvent struct {
+	// type of event
+	Type WebhookEventType `json:"type"`
+
+	// timestamp when the event occurred
+	Timestamp time.Time `json:"timestamp"`
+
+	// event specific details
+	Data interface{} `json:"data,omitempty"`
+}
+
+// NewClientWebhookEvent converts a client roster event into a webhook event
+func NewClientWebhookEvent(event client.ClientEvent) WebhookEvent {
+	t := WebhookClientJoined
+	if event.Type == client.ClientLeft {
+		t = WebhookClientLeft
+	}
+	return WebhookEvent{Type: t, Timestamp: event.Timestamp, Data: event}
+}
+
+// WebhookBroadcastData describes the broadcast of broadcast.started and broadcast.stopped events
+type WebhookBroadcastData struct {
+	// visibility of the broadcast
+	Broadcast client.BroadcastVisibility `json:"broadcast"`
+}
+
+// WebhookErrorData describes the failure of an error event
+type WebhookErrorData struct {
+	// component of the server that failed (ie. "mixer" or "recorder")
+	Source string `json:"source"`
+
+	// description of the failure
+	Error string `json:"error"`
+}
+
+// NewBroadcastWebhookEvent returns a broadcast.started or broadcast.stopped event for a broadcast visibility
+func NewBroadcastWebhookEvent(started bool, broadcast client.BroadcastVisibility) WebhookEvent {
+	t := WebhookBroadcastStopped
+	if started {
+		t = WebhookBroadcastStarted
+	}
+	return WebhookEvent{Type: t, Data: WebhookBroadcastData{Broadcast: broadcast}}
+}
+
+// NewRecordingWebhookEvent returns a recording.saved event for the manifest of a session
+func NewRecordingWebhookEvent(manifest SessionManifest) WebhookEvent {
+	return WebhookEvent{Type: WebhookRecordingSaved, Data: manifest}
+}
+
+// NewErrorWebhookEvent returns an error event for a component of the server
+func NewErrorWebhookEvent(source string, err error) WebhookEvent {
+	return WebhookEvent{Type: WebhookError, Data: WebhookErrorData{Source: source, Error: err.Error()}}
+}
+
+// GetWebhookURLs returns the list of webhook URLs configured for a studio
+func GetWebhookURLs(config client.WebhookConfig) []string {
+	var urls []string
+	for _, url := range strings.Split(config.WebhookURLs, ",") {
+		url = strings.TrimSpace(url)
+		if url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// SignWebhook returns the signature for a webhook payload sent at the given unix time
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks that a signature matches a webhook payload sent at the given unix time
+func VerifyWebhook(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhook(secret, timestamp, body)), []byte(signature))
+}
+
+// WebhookNotifier delivers studio events to the webhook URLs configured for a studio
+type WebhookNotifier struct {
+	// HTTPClient used to deliver webhooks
+	HTTPClient *http.Client
+
+	config client.WebhookConfig
+	queue  chan WebhookEvent
+	mutex  sync.Mutex
+}
+
+// NewWebhookNotifier constructs a new instance of WebhookNotifier
+func NewWebhookNotifier() *WebhookNotifier {
+	return &WebhookNotifier{
+		HTTPClient: &http.Client{Timeout: WebhookTimeout},
+		queue:      make(chan WebhookEvent, WebhookQueueSize),
+	}
+}
+
+// SetConfig updates the webhook URLs and secret used for future events
+func (n *WebhookNotifier) SetConfig(config client.WebhookConfig) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.config = config
+}
+
+// getConfig returns the current webhook configuration
+func (n *WebhookNotifier) getConfig() client.WebhookConfig {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.config
+}
+
+// Notify queues an event for delivery, returning false if it was dropped
+func (n *WebhookNotifier) Notify(event WebhookEvent) bool {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	select {
+	case n.queue <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// NotifyClientEvents queues webhook events for client roster changes
+func (n *WebhookNotifier) NotifyClientEvents(events []client.ClientEvent) {
+	for _, event := range events {
+		n.Notify(NewClientWebhookEvent(event))
+	}
+}
+
+// Send delivers an event to every configured webhook URL, returning the first error
+func (n *WebhookNotifier) Send(ctx context.Context, event WebhookEvent) error {
+	config := n.getConfig()
+	urls := GetWebhookURLs(config)
+	if len(urls) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	signature := SignWebhook(config.WebhookSecret, timestamp, body)
+
+	var firstErr error
+	for _, url := range urls {
+		if err := n.post(ctx, url, event.Type, timestamp, signature, body); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// post delivers a signed webhook payload to a single URL
+func (n *WebhookNotifier) post(ctx context.Context, url string, t WebhookEventType, timestamp int64, signature string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(t))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, signature)
+
+	resp, err := n.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// Run delivers queued events until the context is cancelled
+func (n *WebhookNotifier) Run(ctx context.Context, wg *sync.WaitGroup, onError func(WebhookEvent, error)) {
+	defer wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-n.queue:
+			if err := n.Send(ctx, event); err != nil && onError != nil {
+				onError(event, err)
+			}
+		}
+	}
+}
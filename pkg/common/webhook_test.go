@@ -0,0 +1,173 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !device
+// +build !device
+
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetWebhookURLs(t *testing.T) {
+	assert := assert.New(t)
+	assert.Nil(GetWebhookURLs(client.WebhookConfig{}))
+	urls := GetWebhookURLs(client.WebhookConfig{WebhookURLs: " https://a.example/hook, ,https://b.example/hook"})
+	assert.Equal([]string{"https://a.example/hook", "https://b.example/hook"}, urls)
+}
+
+func TestSignWebhook(t *testing.T) {
+	assert := assert.New(t)
+	body := []byte(`{"type":"error"}`)
+	sig := SignWebhook("secret", 1600000000, body)
+	assert.Regexp(`^sha256=[0-9a-f]{64}$`, sig)
+	assert.True(VerifyWebhook("secret", 1600000000, body, sig))
+	assert.False(VerifyWebhook("other", 1600000000, body, sig))
+	assert.False(VerifyWebhook("secret", 1600000001, body, sig))
+	assert.False(VerifyWebhook("secret", 1600000000, []byte(`{}`), sig))
+}
+
+func TestNewClientWebhookEvent(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	event := NewClientWebhookEvent(client.ClientEvent{Type: client.ClientJoined, Name: "alice", Count: 1, Timestamp: now})
+	assert.Equal(WebhookClientJoined, event.Type)
+	assert.Equal(now, event.Timestamp)
+	event = NewClientWebhookEvent(client.ClientEvent{Type: client.ClientLeft, Name: "alice"})
+	assert.Equal(WebhookClientLeft, event.Type)
+}
+
+func TestNewWebhookEvents(t *testing.T) {
+	assert := assert.New(t)
+	event := NewBroadcastWebhookEvent(true, client.BroadcastPublicWOStemWOVideo)
+	assert.Equal(WebhookBroadcastStarted, event.Type)
+	assert.Equal(WebhookBroadcastData{Broadcast: client.BroadcastPublicWOStemWOVideo}, event.Data)
+	event = NewBroadcastWebhookEvent(false, client.Offline)
+	assert.Equal(WebhookBroadcastStopped, event.Type)
+
+	manifest := SessionManifest{Name: "20220501T200000Z"}
+	event = NewRecordingWebhookEvent(manifest)
+	assert.Equal(WebhookRecordingSaved, event.Type)
+	assert.Equal(manifest, event.Data)
+
+	event = NewErrorWebhookEvent("mixer", errors.New("failed to start"))
+	assert.Equal(WebhookError, event.Type)
+	assert.Equal(WebhookErrorData{Source: "mixer", Error: "failed to start"}, event.Data)
+}
+
+func TestWebhookNotifierSend(t *testing.T) {
+	assert := assert.New(t)
+	received := make(chan *http.Request, 2)
+	bodies := make(chan []byte, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer ts.Close()
+
+	n := NewWebhookNotifier()
+	assert.NoError(n.Send(context.Background(), WebhookEvent{Type: WebhookError}))
+	assert.Len(received, 0)
+
+	n.SetConfig(client.WebhookConfig{WebhookURLs: ts.URL + "," + ts.URL, WebhookSecret: "secret"})
+	event := WebhookEvent{Type: WebhookRecordingSaved, Timestamp: time.Now(), Data: map[string]string{"path": "a.flac"}}
+	assert.NoError(n.Send(context.Background(), event))
+	assert.Len(received, 2)
+
+	r := <-received
+	body := <-bodies
+	assert.Equal(http.MethodPost, r.Method)
+	assert.Equal("application/json", r.Header.Get("Content-Type"))
+	assert.Equal(string(WebhookRecordingSaved), r.Header.Get(WebhookEventHeader))
+	timestamp, err := strconv.ParseInt(r.Header.Get(WebhookTimestampHeader), 10, 64)
+	assert.NoError(err)
+	assert.True(VerifyWebhook("secret", timestamp, body, r.Header.Get(WebhookSignatureHeader)))
+
+	var decoded WebhookEvent
+	assert.NoError(json.Unmarshal(body, &decoded))
+	assert.Equal(WebhookRecordingSaved, decoded.Type)
+}
+
+func TestWebhookNotifierSendError(t *testing.T) {
+	assert := assert.New(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	n := NewWebhookNotifier()
+	n.SetConfig(client.WebhookConfig{WebhookURLs: ts.URL})
+	assert.Error(n.Send(context.Background(), WebhookEvent{Type: WebhookError}))
+}
+
+func TestWebhookNotifierRun(t *testing.T) {
+	assert := assert.New(t)
+	received := make(chan WebhookEvent, 4)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event WebhookEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer ts.Close()
+
+	n := NewWebhookNotifier()
+	n.SetConfig(client.WebhookConfig{WebhookURLs: ts.URL})
+	n.NotifyClientEvents([]client.ClientEvent{
+		{Type: client.ClientJoined, Name: "alice", Count: 1},
+		{Type: client.ClientLeft, Name: "alice", Count: 0},
+	})
+	assert.True(n.Notify(WebhookEvent{Type: WebhookBroadcastStarted}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go n.Run(ctx, &wg, nil)
+
+	var types []WebhookEventType
+	for i := 0; i < 3; i++ {
+		select {
+		case event := <-received:
+			types = append(types, event.Type)
+			assert.False(event.Timestamp.IsZero())
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for webhook")
+		}
+	}
+	cancel()
+	wg.Wait()
+	assert.Equal([]WebhookEventType{WebhookClientJoined, WebhookClientLeft, WebhookBroadcastStarted}, types)
+}
+
+func TestWebhookNotifierQueueFull(t *testing.T) {
+	assert := assert.New(t)
+	n := NewWebhookNotifier()
+	for i := 0; i < WebhookQueueSize; i++ {
+		assert.True(n.Notify(WebhookEvent{Type: WebhookError}))
+	}
+	assert.False(n.Notify(WebhookEvent{Type: WebhookError}))
+}
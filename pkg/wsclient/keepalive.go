@@ -0,0 +1,92 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wsclient provides websocket connections to the JackTrip control plane, shared by devices and servers
+package wsclient
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Keepalive detects half-open websocket connections, by sending pings and expecting pongs in reply
+type Keepalive struct {
+	PingInterval time.Duration
+	PongTimeout  time.Duration
+}
+
+// Deadline returns the time by which another pong (or message) must arrive, for a connection to be considered alive
+func (k Keepalive) Deadline(now time.Time) time.Time {
+	return now.Add(k.PingInterval + k.PongTimeout)
+}
+
+// Start sends pings over a connection, until the returned function is called or a pong is missed; dead is called
+// once if a pong was missed or a ping could not be sent, so that the connection can be replaced right away
+// NOTE: pongs are only processed while another goroutine is reading from the connection, and a connection without
+// a ping interval is never considered dead
+func (k Keepalive) Start(c *websocket.Conn, dead func()) func() {
+	if k.PingInterval <= 0 {
+		return func() {}
+	}
+	var mutex sync.Mutex
+	lastPong := time.Now()
+	c.SetReadDeadline(k.Deadline(lastPong))
+	c.SetPongHandler(func(string) error {
+		now := time.Now()
+		mutex.Lock()
+		lastPong = now
+		mutex.Unlock()
+		return c.SetReadDeadline(k.Deadline(now))
+	})
+
+	// halt stops sending pings, returning false if they were already stopped
+	done := make(chan struct{})
+	stopped := false
+	halt := func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if stopped {
+			return false
+		}
+		stopped = true
+		close(done)
+		return true
+	}
+
+	go func() {
+		ticker := time.NewTicker(k.PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				mutex.Lock()
+				missed := now.After(k.Deadline(lastPong))
+				mutex.Unlock()
+				if !missed && c.WriteControl(websocket.PingMessage, nil, now.Add(k.PongTimeout)) == nil {
+					continue
+				}
+				// a connection that was stopped while sending a ping is not dead
+				if halt() {
+					dead()
+				}
+				return
+			}
+		}
+	}()
+	return func() { halt() }
+}
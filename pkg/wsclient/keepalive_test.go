@@ -0,0 +1,86 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// newKeepaliveTestServer starts a websocket server that answers pings only if respond is true
+func newKeepaliveTestServer(respond bool, accepted *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		atomic.AddInt32(accepted, 1)
+		if !respond {
+			// ignore pings, like a peer that went away without closing the connection
+			c.SetPingHandler(func(string) error { return nil })
+		}
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestKeepalive(t *testing.T) {
+	assert := assert.New(t)
+	keepalive := Keepalive{PingInterval: 50 * time.Millisecond, PongTimeout: 50 * time.Millisecond}
+	start := time.Now()
+	assert.Equal(start.Add(100*time.Millisecond), keepalive.Deadline(start))
+
+	for _, respond := range []bool{true, false} {
+		var accepted int32
+		server := newKeepaliveTestServer(respond, &accepted)
+		c, _, err := websocket.DefaultDialer.Dial("ws"+server.URL[len("http"):], nil)
+		assert.Nil(err)
+
+		var dead int32
+		stop := keepalive.Start(c, func() { atomic.AddInt32(&dead, 1) })
+		go func() {
+			for {
+				if _, _, err := c.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		time.Sleep(400 * time.Millisecond)
+		stop()
+		c.Close()
+		server.Close()
+
+		// A connection is only dead if pongs stop arriving, and dead is called once
+		if respond {
+			assert.Equal(int32(0), atomic.LoadInt32(&dead))
+		} else {
+			assert.Equal(int32(1), atomic.LoadInt32(&dead))
+		}
+	}
+
+	// Keepalives are disabled without a ping interval
+	assert.NotNil(Keepalive{}.Start(nil, func() { t.Fail() }))
+}
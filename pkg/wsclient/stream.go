@@ -0,0 +1,291 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+)
+
+const (
+	// WriteTimeout is the maximum time allowed to send a message, before the connection is replaced
+	WriteTimeout = 10 * time.Second
+
+	// MinBackoff and MaxBackoff bound the delay between attempts to reconnect a stream
+	MinBackoff = time.Second
+	MaxBackoff = time.Minute
+
+	// IdleInterval is how long ReadLoop waits for a stream to be connected, before checking again
+	IdleInterval = time.Second
+)
+
+// Status is reported to a stream's StatusFunc whenever its connection changes
+type Status string
+
+const (
+	// StatusConnected means a new connection was opened
+	StatusConnected Status = "connected"
+
+	// StatusDisconnected means the connection was closed
+	StatusDisconnected Status = "disconnected"
+
+	// StatusUnresponsive means a pong was missed, and the connection is being replaced
+	StatusUnresponsive Status = "unresponsive"
+
+	// StatusReconnectFailed means a dead connection could not be replaced right away
+	StatusReconnectFailed Status = "reconnect failed"
+)
+
+var (
+	// ErrBackoff is returned when a stream is not reconnected because its last attempt failed recently,
+	// or because another attempt is still in progress
+	ErrBackoff = errors.New("websocket is waiting to reconnect")
+
+	// ErrNotConnected is returned when sending or receiving over a stream that is not connected
+	ErrNotConnected = errors.New("websocket is not connected")
+)
+
+// Backoff returns the delay before reconnecting after a number of consecutive failures
+func Backoff(failures int) time.Duration {
+	backoff := MinBackoff
+	for i := 1; i < failures && backoff < MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > MaxBackoff {
+		return MaxBackoff
+	}
+	return backoff
+}
+
+// URL returns the ws(s) URL of a route on the control plane, given its origin and a route template
+func URL(origin, path, id string) string {
+	u, _ := url.Parse(origin)
+	scheme := "ws"
+	if u.Scheme == "https" {
+		scheme = "wss"
+	}
+	wsURL := url.URL{Scheme: scheme, Host: u.Host, Path: u.Path + fmt.Sprintf(path, id)}
+	return wsURL.String()
+}
+
+// Stream is one websocket connection to the control plane, which reconnects with its own backoff and replaces
+// connections that stop answering pings
+type Stream struct {
+	// Path is the URL template of the stream, formatted with the agent id
+	Path string
+
+	// Keepalive is used to detect half-open connections; it is disabled if the ping interval is zero
+	Keepalive Keepalive
+
+	// StatusFunc is called whenever the connection changes, if set
+	// NOTE: it is called while the stream is locked, so it must not use the stream
+	StatusFunc func(status Status, target string, err error)
+
+	target        string
+	header        http.Header
+	conn          *websocket.Conn
+	stopKeepalive func()
+	reconnected   bool
+	dialing       bool
+	closes        int
+	failures      int
+	retryAt       time.Time
+	lastMessageAt time.Time
+	mutex         sync.Mutex
+}
+
+// notify reports a status change; callers must hold the lock
+func (s *Stream) notify(status Status, err error) {
+	if s.StatusFunc != nil {
+		s.StatusFunc(status, s.target, err)
+	}
+}
+
+// Connect opens the stream if it is not connected, unless it is waiting to reconnect after a failure. It returns
+// true if a new connection was opened since it was last called, including when a dead connection was replaced
+func (s *Stream) Connect(origin string, credentials client.AgentCredentials, id string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.target = URL(origin, s.Path, id)
+	s.header = http.Header{"Origin": []string{"http://jacktrip.local"}}
+	s.header.Set("APISecret", credentials.APISecret)
+	s.header.Set("APIPrefix", credentials.APIPrefix)
+	if s.conn == nil {
+		if err := s.dial(time.Now()); err != nil {
+			return false, err
+		}
+	}
+	reconnected := s.reconnected
+	s.reconnected = false
+	return reconnected, nil
+}
+
+// dial opens a new connection to the stream target; callers must hold the lock, which is released while dialing
+// so that a slow or unreachable control plane does not block other users of the stream
+func (s *Stream) dial(now time.Time) error {
+	if s.dialing || now.Before(s.retryAt) {
+		return ErrBackoff
+	}
+	s.dialing = true
+	target, header, closes := s.target, s.header, s.closes
+	s.mutex.Unlock()
+	c, _, err := websocket.DefaultDialer.Dial(target, header)
+	s.mutex.Lock()
+	s.dialing = false
+	if err != nil {
+		s.failures++
+		s.retryAt = now.Add(Backoff(s.failures))
+		return err
+	}
+	// the stream was closed while dialing, so don't reopen it
+	if s.closes != closes {
+		c.Close()
+		return ErrNotConnected
+	}
+	s.conn = c
+	s.reconnected = true
+	s.failures = 0
+	s.retryAt = time.Time{}
+	s.stopKeepalive = s.Keepalive.Start(c, func() { s.reconnect(c, StatusUnresponsive) })
+	s.notify(StatusConnected, nil)
+	return nil
+}
+
+// drop closes the current connection; callers must hold the lock
+func (s *Stream) drop() {
+	s.stopKeepalive()
+	s.conn.Close()
+	s.conn = nil
+	s.notify(StatusDisconnected, nil)
+}
+
+// reconnect replaces a dead connection right away, unless it was already replaced or the stream was closed
+func (s *Stream) reconnect(c *websocket.Conn, reason Status) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if c == nil || s.conn != c {
+		return
+	}
+	if reason != "" {
+		s.notify(reason, nil)
+	}
+	s.drop()
+	if err := s.dial(time.Now()); err != nil && err != ErrBackoff {
+		s.notify(StatusReconnectFailed, err)
+	}
+}
+
+// Connected returns true if the stream is connected
+func (s *Stream) Connected() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.conn != nil
+}
+
+// Close closes the stream, if it is connected
+func (s *Stream) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closes++
+	if s.conn != nil {
+		s.drop()
+	}
+}
+
+// LastMessageAt returns the time the last message was received, or zero if none was received
+func (s *Stream) LastMessageAt() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.lastMessageAt
+}
+
+// current returns the connection of the stream, or nil if it is not connected
+func (s *Stream) current() *websocket.Conn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.conn
+}
+
+// Send writes a message as JSON, replacing the connection if the message can't be sent in time
+// NOTE: only one goroutine may send over a stream
+func (s *Stream) Send(message interface{}) error {
+	c := s.current()
+	if c == nil {
+		return ErrNotConnected
+	}
+	msgBytes, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	c.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	if err := c.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+		s.reconnect(c, "")
+		return err
+	}
+	return nil
+}
+
+// Receive reads a message, replacing the connection if it fails or the keepalive deadline passes
+// NOTE: only one goroutine may receive from a stream
+func (s *Stream) Receive() ([]byte, error) {
+	c := s.current()
+	if c == nil {
+		return nil, ErrNotConnected
+	}
+	_, message, err := c.ReadMessage()
+	if err != nil {
+		s.reconnect(c, "")
+		return nil, err
+	}
+	s.mutex.Lock()
+	s.lastMessageAt = time.Now()
+	s.mutex.Unlock()
+	return message, nil
+}
+
+// ReadLoop receives messages until the context is cancelled, passing each one to handle, or its error to
+// handleError if set; this also processes pongs, so every stream with a keepalive needs a reader
+func (s *Stream) ReadLoop(ctx context.Context, handle func(message []byte), handleError func(err error)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if !s.Connected() {
+			// sleep while not connected to avoid inf loop
+			time.Sleep(IdleInterval)
+			continue
+		}
+		message, err := s.Receive()
+		if err != nil {
+			if handleError != nil {
+				handleError(err)
+			}
+			continue
+		}
+		handle(message)
+	}
+}
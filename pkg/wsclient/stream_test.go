@@ -0,0 +1,158 @@
+// Copyright 2020-2022 JackTrip Labs, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wsclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/jacktrip/jacktrip-agent/pkg/apitest"
+	"github.com/jacktrip/jacktrip-agent/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(time.Second, Backoff(1))
+	assert.Equal(4*time.Second, Backoff(3))
+	assert.Equal(MaxBackoff, Backoff(100))
+	assert.Equal("ws://example.com/api/devices/abc/control", URL("http://example.com/api", "/devices/%s/control", "abc"))
+	assert.Equal("wss://example.com/devices/abc/heartbeat", URL("https://example.com", "/devices/%s/heartbeat", "abc"))
+}
+
+func TestStream(t *testing.T) {
+	assert := assert.New(t)
+	credentials := client.AgentCredentials{APIPrefix: "prefix", APISecret: "secret"}
+	server := apitest.NewServer(credentials)
+	defer server.Close()
+
+	var statuses []Status
+	stream := Stream{
+		Path:       "/devices/%s/control",
+		StatusFunc: func(status Status, target string, err error) { statuses = append(statuses, status) },
+	}
+	assert.Equal(ErrNotConnected, stream.Send("hello"))
+
+	// A failed connection is not retried until its backoff has passed
+	_, err := stream.Connect(server.URL, client.AgentCredentials{}, "abc")
+	assert.NotNil(err)
+	_, err = stream.Connect(server.URL, credentials, "abc")
+	assert.Equal(ErrBackoff, err)
+	stream.retryAt = time.Time{}
+	reconnected, err := stream.Connect(server.URL, credentials, "abc")
+	assert.Nil(err)
+	assert.True(reconnected)
+	assert.True(stream.Connected())
+	assert.Equal(0, stream.failures)
+	reconnected, err = stream.Connect(server.URL, credentials, "abc")
+	assert.Nil(err)
+	assert.False(reconnected)
+
+	// Messages are received in a loop until the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan []byte, 10)
+	done := make(chan struct{})
+	go func() {
+		stream.ReadLoop(ctx, func(message []byte) { received <- message }, nil)
+		close(done)
+	}()
+	var config client.DeviceAgentConfig
+	assert.Nil(json.Unmarshal(<-received, &config))
+	assert.False(stream.LastMessageAt().IsZero())
+	assert.Nil(stream.Send(client.AgentCommandResult{Command: "doctor"}))
+	assert.Eventually(func() bool { return len(server.Results()) == 1 }, time.Second, 10*time.Millisecond)
+
+	// Closing is idempotent
+	stream.Close()
+	stream.Close()
+	assert.False(stream.Connected())
+	cancel()
+	<-done
+	assert.Equal([]Status{StatusConnected, StatusDisconnected}, statuses)
+}
+
+func TestStreamReconnectsAfterMissedPong(t *testing.T) {
+	assert := assert.New(t)
+	var accepted int32
+	server := newKeepaliveTestServer(false, &accepted)
+	defer server.Close()
+
+	// A connection that stops answering pings is replaced without waiting for the next connect
+	stream := Stream{
+		Path:      "/devices/%s/control",
+		Keepalive: Keepalive{PingInterval: 50 * time.Millisecond, PongTimeout: 50 * time.Millisecond},
+	}
+	reconnected, err := stream.Connect(server.URL, client.AgentCredentials{}, "abc")
+	assert.Nil(err)
+	assert.True(reconnected)
+	go func() {
+		for stream.Connected() {
+			stream.Receive()
+		}
+	}()
+	assert.Eventually(func() bool { return atomic.LoadInt32(&accepted) >= 2 }, time.Second, 10*time.Millisecond)
+	reconnected, err = stream.Connect(server.URL, client.AgentCredentials{}, "abc")
+	assert.Nil(err)
+	assert.True(reconnected)
+
+	// Closing the stream on purpose does not reconnect it
+	stream.Close()
+	count := atomic.LoadInt32(&accepted)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(count, atomic.LoadInt32(&accepted))
+	assert.False(stream.Connected())
+}
+
+func TestStreamDialsWithoutLock(t *testing.T) {
+	assert := assert.New(t)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+		if c, err := upgrader.Upgrade(w, r, nil); err == nil {
+			c.Close()
+		}
+	}))
+	defer server.Close()
+
+	stream := Stream{Path: "/devices/%s/control"}
+	done := make(chan error)
+	go func() {
+		_, err := stream.Connect(server.URL, client.AgentCredentials{}, "abc")
+		done <- err
+	}()
+	assert.Eventually(func() bool {
+		stream.mutex.Lock()
+		defer stream.mutex.Unlock()
+		return stream.dialing
+	}, time.Second, time.Millisecond)
+
+	// The stream can be used while dialing, and only one connection is opened at a time
+	assert.False(stream.Connected())
+	_, err := stream.Connect(server.URL, client.AgentCredentials{}, "abc")
+	assert.Equal(ErrBackoff, err)
+
+	// Closing while dialing keeps the stream closed
+	stream.Close()
+	close(release)
+	assert.Equal(ErrNotConnected, <-done)
+	assert.False(stream.Connected())
+}